@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var cacheBlockCacheDirFlag = &cli.StringFlag{
+	Name:     "block-cache-dir",
+	Usage:    "the daemon's --block-cache-dir",
+	EnvVars:  []string{"LASSIE_BLOCK_CACHE_DIR"},
+	Required: true,
+}
+
+var cacheCmd = &cli.Command{
+	Name:  "cache",
+	Usage: "Copies the blocks of the daemon's --block-cache-dir to and from CAR bundles",
+	Description: "Export and import work on the block cache directory of a daemon that isn't running, " +
+		"since the daemon doesn't see blocks added to its directory by another process. " +
+		"Use the GET /admin/caches/blocks/export and POST /admin/caches/blocks/import admin endpoints with a running daemon.",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "export",
+			Usage:     "Writes the cached blocks, or those of the DAGs of the given roots, to a CAR bundle",
+			UsageText: "lassie cache export --block-cache-dir <dir> [--root <cid>]... [--output <path>]",
+			Flags: []cli.Flag{
+				cacheBlockCacheDirFlag,
+				&cli.StringSliceFlag{
+					Name:  "root",
+					Usage: "export only the cached blocks of the DAG of this root, may be repeated",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "the CAR file to write the bundle to, or - for stdout",
+					Value:   "-",
+				},
+			},
+			Action: cacheExportAction,
+		},
+		{
+			Name:      "import",
+			Usage:     "Adds the blocks of a CAR, such as a bundle written by export, to a block cache",
+			UsageText: "lassie cache import --block-cache-dir <dir> --block-cache-size <size> <path>",
+			Flags: []cli.Flag{
+				cacheBlockCacheDirFlag,
+				&cli.StringFlag{
+					Name:     "block-cache-size",
+					Usage:    "the daemon's --block-cache-size, beyond which the least recently used blocks are evicted",
+					EnvVars:  []string{"LASSIE_BLOCK_CACHE_SIZE"},
+					Required: true,
+				},
+			},
+			Action: cacheImportAction,
+		},
+	},
+}
+
+func cacheExportAction(cctx *cli.Context) error {
+	var roots []cid.Cid
+	for _, root := range cctx.StringSlice("root") {
+		c, err := cid.Parse(root)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("invalid --root %q: %s", root, err), 1)
+		}
+		roots = append(roots, c)
+	}
+	dir := cctx.String("block-cache-dir")
+	if _, err := os.Stat(dir); err != nil {
+		return cli.Exit(err, 1)
+	}
+	// opened without a size limit, so that nothing is evicted
+	cache, err := blockcache.New(dir, math.MaxUint64)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var exported int
+	if output := cctx.String("output"); output == "-" {
+		exported, err = cache.Export(cctx.Context, cctx.App.Writer, roots...)
+	} else {
+		var file *os.File
+		if file, err = os.Create(output); err != nil {
+			return cli.Exit(err, 1)
+		}
+		exported, err = cache.Export(cctx.Context, file, roots...)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Fprintf(cctx.App.ErrWriter, "Exported %d block(s)\n", exported)
+	return nil
+}
+
+func cacheImportAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		return cli.Exit("expected a single CAR file path, or - for stdin", 1)
+	}
+	cache, err := newBlockCache(cctx)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var r io.Reader = os.Stdin
+	if path := cctx.Args().First(); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return cli.Exit(err, 1)
+		}
+		defer file.Close()
+		r = file
+	}
+	imported, err := cache.Import(cctx.Context, r)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("imported %d block(s): %w", imported, err), 1)
+	}
+	fmt.Fprintf(cctx.App.ErrWriter, "Imported %d block(s), the cache holds %d block(s)\n", imported, cache.Len())
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCacheCommand(t *testing.T) {
+	blk := func(data string) cid.Cid {
+		c, err := cid.V1Builder{Codec: uint64(multicodec.Raw), MhType: multihash.SHA2_256}.Sum([]byte(data))
+		require.NoError(t, err)
+		return c
+	}
+	sourceDir, targetDir := t.TempDir(), t.TempDir()
+	source, err := blockcache.New(sourceDir, 1<<20)
+	require.NoError(t, err)
+	a, b := blk("aaaa"), blk("bbbb")
+	require.NoError(t, source.Put(a, []byte("aaaa")))
+	require.NoError(t, source.Put(b, []byte("bbbb")))
+	bundlePath := filepath.Join(t.TempDir(), "blocks.car")
+
+	run := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		app := &cli.App{
+			Name:           "cli-test",
+			Commands:       []*cli.Command{cacheCmd},
+			Writer:         &stdout,
+			ErrWriter:      &stderr,
+			ExitErrHandler: func(*cli.Context, error) {},
+		}
+		err := app.Run(append([]string{"cli-test", "cache"}, args...))
+		return stdout.String() + stderr.String(), err
+	}
+
+	out, err := run("export", "--block-cache-dir", sourceDir, "--root", b.String(), "-o", bundlePath)
+	require.NoError(t, err)
+	require.Contains(t, out, "Exported 1 block(s)")
+	out, err = run("import", "--block-cache-dir", targetDir, "--block-cache-size", "1MiB", bundlePath)
+	require.NoError(t, err)
+	require.Contains(t, out, "Imported 1 block(s)")
+
+	out, err = run("export", "--block-cache-dir", sourceDir)
+	require.NoError(t, err)
+	require.Contains(t, out, "Exported 2 block(s)")
+	_, err = run("export", "--block-cache-dir", sourceDir, "-o", bundlePath)
+	require.NoError(t, err)
+	out, err = run("import", "--block-cache-dir", targetDir, "--block-cache-size", "1MiB", bundlePath)
+	require.NoError(t, err)
+	require.Contains(t, out, "Imported 2 block(s), the cache holds 2 block(s)")
+
+	target, err := blockcache.New(targetDir, 1<<20)
+	require.NoError(t, err)
+	require.True(t, target.Has(a))
+	require.True(t, target.Has(b))
+
+	_, err = run("export", "--block-cache-dir", sourceDir, "--root", "nope")
+	require.Error(t, err)
+	_, err = run("export", "--block-cache-dir", filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+	_, err = run("import", "--block-cache-dir", targetDir, bundlePath)
+	require.Error(t, err)
+	_, err = run("import", "--block-cache-dir", targetDir, "--block-cache-size", "1MiB", filepath.Join(t.TempDir(), "missing.car"))
+	require.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/filecoin-project/lassie/pkg/unixfsextract"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/urfave/cli/v2"
+)
+
+var catFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: "range",
+		Usage: "the range of bytes of the file to write, of the form from:to, where " +
+			"from and to are inclusive byte offsets, to may be '*', and negative " +
+			"offsets count from the end of the file",
+		DefaultText: "the entire file, 0:*",
+		Action: func(cctx *cli.Context, v string) error {
+			if _, err := trustlessutils.ParseByteRange(v); err != nil {
+				return fmt.Errorf("invalid range parameter, must be of the " +
+					"form from:to, where from and to are byte offsets and to may be '*'")
+			}
+			return nil
+		},
+	},
+	FlagTempDir,
+	FlagIPNIEndpoint,
+	FlagAllowProviders,
+	FlagExcludeProviders,
+	FlagProtocols,
+	FlagGlobalTimeout,
+	FlagProviderTimeout,
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var catCmd = &cli.Command{
+	Name:      "cat",
+	Usage:     "Writes the bytes of a UnixFS file to stdout as it's retrieved",
+	ArgsUsage: "<cid>[/path] | /ipfs/<cid>[/path][?entity-bytes=from:to]",
+	Description: "Retrieves the UnixFS file at the given path and writes its bytes to " +
+		"stdout as its blocks arrive, in order. With --range, or an entity-bytes " +
+		"parameter, only the blocks holding the bytes of the range are retrieved and only " +
+		"those bytes are written. If the retrieval fails part way, the bytes received so " +
+		"far have already been written and the command exits with a non-zero status.",
+	After:  after,
+	Action: catAction,
+	Flags:  catFlags,
+}
+
+func catAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "cat", 0)
+		return nil
+	}
+
+	root, path, _, byteRange, _, err := parseCidPath(cctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if cctx.IsSet("range") {
+		if entityBytes, err := trustlessutils.ParseByteRange(cctx.String("range")); err != nil {
+			return err
+		} else if entityBytes.IsDefault() {
+			byteRange = nil
+		} else {
+			byteRange = &entityBytes
+		}
+	}
+
+	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = catRun(cctx.Context, lassieCfg, cctx.String("tempdir"), cctx.App.Writer, root, path, byteRange)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type catRunFunc func(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	tempDir string,
+	dataWriter io.Writer,
+	root cid.Cid,
+	path datamodel.Path,
+	byteRange *trustlessutils.ByteRange,
+) error
+
+var catRun catRunFunc = defaultCatRun
+
+// defaultCatRun is the handler for the cat command.
+func defaultCatRun(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	tempDir string,
+	dataWriter io.Writer,
+	root cid.Cid,
+	path datamodel.Path,
+	byteRange *trustlessutils.ByteRange,
+) error {
+	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
+	if err != nil {
+		return err
+	}
+
+	// blocks are kept until the retrieval is done, a leaf may be needed again
+	// where it's repeated in the file
+	store := storage.NewDeferredStorageCar(tempDir, root)
+	defer store.Close()
+	request, err := types.NewRequestForPath(store, root, path.String(), trustlessutils.DagScopeEntity, byteRange)
+	if err != nil {
+		return err
+	}
+
+	// the first block reached at the path is the root of the file, write as
+	// much of the file as the blocks received so far allow after each block
+	var stream *unixfsextract.FileStream
+	_, err = lassie.FetchNodes(ctx, request, func(p traversal.Progress, n datamodel.Node) error {
+		if stream == nil {
+			if p.Path.String() != path.String() {
+				return nil
+			}
+			stream = unixfsextract.NewFileStream(ctx, store, p.LastBlock.Link.(cidlink.Link).Cid, byteRange, dataWriter)
+		}
+		return stream.Advance()
+	})
+	if err != nil {
+		if errors.Is(err, unixfsextract.ErrNotFile) {
+			return fmt.Errorf("%w: /%s", unixfsextract.ErrNotFile, path)
+		}
+		return err
+	}
+
+	if stream == nil {
+		return fmt.Errorf("/%s not found in %s", path, root)
+	}
+	if !stream.Done() {
+		return fmt.Errorf("retrieval of /%s ended before the whole file was received", path)
+	}
+	return nil
+}
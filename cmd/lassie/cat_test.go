@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	l "github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCatCommandFlags(t *testing.T) {
+	var to int64 = 2000
+
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   catRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"cat", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4"},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, tempDir string, dataWriter io.Writer, root cid.Cid, path datamodel.Path, byteRange *trustlessutils.ByteRange) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", root.String())
+				require.Equal(t, "birb.mp4", path.String())
+				require.Equal(t, defaultTempDirectory, tempDir)
+				require.Nil(t, lCfg.Finder)
+				require.Nil(t, byteRange)
+				return nil
+			},
+		},
+		{
+			name: "with entity-bytes in the path",
+			args: []string{"cat", "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4?entity-bytes=1000:2000"},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, tempDir string, dataWriter io.Writer, root cid.Cid, path datamodel.Path, byteRange *trustlessutils.ByteRange) error {
+				require.Equal(t, &trustlessutils.ByteRange{From: 1000, To: &to}, byteRange)
+				return nil
+			},
+		},
+		{
+			name: "with range, tempdir and providers",
+			args: []string{
+				"cat",
+				"--range", "-1000:*",
+				"--tempdir", "/mytmpdir",
+				"--providers",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4?entity-bytes=1000:2000",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, tempDir string, dataWriter io.Writer, root cid.Cid, path datamodel.Path, byteRange *trustlessutils.ByteRange) error {
+				require.Equal(t, &trustlessutils.ByteRange{From: -1000}, byteRange)
+				require.Equal(t, "/mytmpdir", tempDir)
+				require.NotNil(t, lCfg.Finder)
+				return nil
+			},
+		},
+		{
+			name: "with the whole file as the range",
+			args: []string{"cat", "--range", "0:*", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?entity-bytes=1000:2000"},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, tempDir string, dataWriter io.Writer, root cid.Cid, path datamodel.Path, byteRange *trustlessutils.ByteRange) error {
+				require.Nil(t, byteRange)
+				return nil
+			},
+		},
+		{
+			name:        "with an invalid range",
+			args:        []string{"cat", "--range", "nope", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
+			shouldError: true,
+		},
+		{
+			name:        "with an invalid cid",
+			args:        []string{"cat", "nope"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// catRun is a global var that we can override for testing purposes
+		catRun = test.assertRun
+		if test.shouldError {
+			catRun = noopCatRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    catFlags,
+			Commands: []*cli.Command{catCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopCatRun(ctx context.Context, lCfg *l.LassieConfig, tempDir string, dataWriter io.Writer, root cid.Cid, path datamodel.Path, byteRange *trustlessutils.ByteRange) error {
+	return nil
+}
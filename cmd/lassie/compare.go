@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/compare"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/types"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/urfave/cli/v2"
+)
+
+var compareFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "write the report as JSON",
+	},
+	FlagVerbose,
+	FlagVeryVerbose,
+	FlagProtocols,
+	FlagAllowProviders,
+	FlagTempDir,
+	FlagBitswapConcurrency,
+	FlagMaxBlockSize,
+	FlagGlobalTimeout,
+	FlagProviderTimeout,
+}
+
+var compareCmd = &cli.Command{
+	Name:  "compare",
+	Usage: "Retrieves content from a single provider over multiple protocols and reports any differences",
+	Description: "Retrieves the same content from the provider given with --provider over each " +
+		"of the given protocols in turn, graphsync and http by default, and compares the " +
+		"blocks received, the outcome and the performance of each retrieval. Exits with a " +
+		"non-zero status if any inconsistencies are found.",
+	After:  after,
+	Action: compareAction,
+	Flags:  compareFlags,
+}
+
+func compareAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "compare", 0)
+		return nil
+	}
+
+	root, path, scope, byteRange, duplicates, err := parseCidPath(cctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	if len(fetchProviderAddrInfos) != 1 {
+		return errors.New("compare requires a single provider, set with --provider")
+	}
+
+	if len(protocols) == 0 {
+		protocols = []multicodec.Code{multicodec.TransportGraphsyncFilecoinv1, multicodec.TransportIpfsGatewayHttp}
+	}
+	if len(protocols) < 2 {
+		return errors.New("compare requires at least two protocols")
+	}
+
+	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	request := types.RetrievalRequest{
+		Request: trustlessutils.Request{
+			Root:       root,
+			Path:       path.String(),
+			Scope:      scope,
+			Bytes:      byteRange,
+			Duplicates: duplicates,
+		},
+		FixedPeers: fetchProviderAddrInfos,
+	}
+
+	err = compareRun(
+		cctx.Context,
+		lassieCfg,
+		cctx.App.Writer,
+		request,
+		protocols,
+		cctx.String("tempdir"),
+		cctx.Bool("json"),
+	)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type compareRunFunc func(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	dataWriter io.Writer,
+	request types.RetrievalRequest,
+	protocols []multicodec.Code,
+	tempDir string,
+	jsonOutput bool,
+) error
+
+var compareRun compareRunFunc = defaultCompareRun
+
+// defaultCompareRun is the handler for the compare command.
+func defaultCompareRun(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	dataWriter io.Writer,
+	request types.RetrievalRequest,
+	protocols []multicodec.Code,
+	tempDir string,
+	jsonOutput bool,
+) error {
+	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := compare.Compare(ctx, lassie, request, tempDir, protocols...)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(dataWriter)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeCompareReport(dataWriter, report)
+	}
+
+	if !report.Consistent() {
+		return fmt.Errorf("found %d inconsistencies", len(report.Inconsistencies))
+	}
+	return nil
+}
+
+func writeCompareReport(w io.Writer, report *compare.Report) {
+	printPath := report.Path
+	if printPath != "" {
+		printPath = "/" + printPath
+	}
+	fmt.Fprintf(w, "Compared %s%s from %s\n\n", report.Root, printPath, report.Provider)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROTOCOL\tRESULT\tBLOCKS\tBYTES\tDURATION\tTTFB\tSPEED")
+	for _, result := range report.Results {
+		outcome := "ok"
+		if !result.Succeeded() {
+			outcome = "failed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s/s\n",
+			result.Protocol,
+			outcome,
+			result.Blocks,
+			humanize.IBytes(result.Bytes),
+			result.Duration,
+			result.TimeToFirstByte,
+			humanize.IBytes(result.AverageSpeed),
+		)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+
+	for _, result := range report.Results {
+		if !result.Succeeded() {
+			fmt.Fprintf(w, "%s failed: %s\n", result.Protocol, result.Error)
+		}
+	}
+
+	if report.Consistent() {
+		fmt.Fprintln(w, "No inconsistencies found")
+		return
+	}
+	fmt.Fprintln(w, "Inconsistencies:")
+	for _, inconsistency := range report.Inconsistencies {
+		fmt.Fprintf(w, "  - %s\n", inconsistency)
+	}
+}
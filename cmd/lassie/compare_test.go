@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	l "github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCompareCommandFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   compareRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{
+				"compare",
+				"--provider",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, request types.RetrievalRequest, protocols []multicodec.Code, tempDir string, jsonOutput bool) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", request.Root.String())
+				require.Equal(t, "birb.mp4", request.Path)
+				require.Len(t, request.FixedPeers, 1)
+				require.Equal(t, "12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4", request.FixedPeers[0].ID.String())
+				require.Equal(t, []multicodec.Code{multicodec.TransportGraphsyncFilecoinv1, multicodec.TransportIpfsGatewayHttp}, protocols)
+				require.Equal(t, protocols, lCfg.Protocols)
+				require.NotNil(t, lCfg.Finder)
+				require.False(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "with protocols and json",
+			args: []string{
+				"compare",
+				"--provider",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+				"--protocols",
+				"bitswap,graphsync,http",
+				"--json",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, request types.RetrievalRequest, protocols []multicodec.Code, tempDir string, jsonOutput bool) error {
+				require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1, multicodec.TransportIpfsGatewayHttp}, protocols)
+				require.True(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "without a provider",
+			args: []string{
+				"compare",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with multiple providers",
+			args: []string{
+				"compare",
+				"--provider",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4,/ip4/127.0.0.1/tcp/5001/p2p/12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with a single protocol",
+			args: []string{
+				"compare",
+				"--provider",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+				"--protocols",
+				"http",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// compareRun is a global var that we can override for testing purposes
+		compareRun = test.assertRun
+		if test.shouldError {
+			compareRun = noopCompareRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    compareFlags,
+			Commands: []*cli.Command{compareCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopCompareRun(
+	ctx context.Context,
+	lCfg *l.LassieConfig,
+	dataWriter io.Writer,
+	request types.RetrievalRequest,
+	protocols []multicodec.Code,
+	tempDir string,
+	jsonOutput bool,
+) error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/conformance"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/urfave/cli/v2"
+)
+
+var conformanceFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "cid",
+		Usage: "the content the provider is expected to serve, as <cid>[/path/to/content], ideally a UnixFS file",
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "the time allowed for each case",
+		Value: time.Minute,
+	},
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "write the report as JSON",
+	},
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var conformanceCmd = &cli.Command{
+	Name:      "conformance",
+	Usage:     "Checks a provider's HTTP endpoint against the Trustless Gateway specification",
+	ArgsUsage: "<provider-url>",
+	Description: "Sends a battery of Trustless Gateway requests for the content given with --cid " +
+		"to the provider at <provider-url>, covering DAG scopes, entity-bytes, duplicates, " +
+		"ordering and error responses, verifies each response as Lassie would verify a " +
+		"retrieval and reports which of the specification's requirements the provider meets. " +
+		"Exits with a non-zero status if any MUST requirement isn't met.",
+	After:  after,
+	Action: conformanceAction,
+	Flags:  conformanceFlags,
+}
+
+func conformanceAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "conformance", 0)
+		return nil
+	}
+
+	if cctx.String("cid") == "" {
+		return errors.New("conformance requires the content the provider serves, set with --cid")
+	}
+	root, path, _, _, _, err := parseCidPath(cctx.String("cid"))
+	if err != nil {
+		return err
+	}
+
+	err = conformanceRun(
+		cctx.Context,
+		cctx.App.Writer,
+		cctx.Args().Get(0),
+		trustlessutils.Request{Root: root, Path: path.String()},
+		cctx.Duration("timeout"),
+		cctx.Bool("json"),
+	)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type conformanceRunFunc func(
+	ctx context.Context,
+	dataWriter io.Writer,
+	providerURL string,
+	request trustlessutils.Request,
+	timeout time.Duration,
+	jsonOutput bool,
+) error
+
+var conformanceRun conformanceRunFunc = defaultConformanceRun
+
+// defaultConformanceRun is the handler for the conformance command.
+func defaultConformanceRun(
+	ctx context.Context,
+	dataWriter io.Writer,
+	providerURL string,
+	request trustlessutils.Request,
+	timeout time.Duration,
+	jsonOutput bool,
+) error {
+	report, err := conformance.Run(ctx, &http.Client{Timeout: timeout}, providerURL, request)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(dataWriter)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeConformanceReport(dataWriter, report)
+	}
+
+	if !report.Compliant() {
+		return fmt.Errorf("failed %d MUST cases", report.Failed(conformance.LevelMust))
+	}
+	return nil
+}
+
+func writeConformanceReport(w io.Writer, report *conformance.Report) {
+	printPath := report.Path
+	if printPath != "" {
+		printPath = "/" + printPath
+	}
+	fmt.Fprintf(w, "Checked %s with %s%s\n\n", report.Provider, report.Root, printPath)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CASE\tAREA\tLEVEL\tRESULT\tSTATUS\tBLOCKS\tBYTES\tDURATION")
+	for _, result := range report.Results {
+		outcome := "pass"
+		if !result.Passed() {
+			outcome = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			result.Name,
+			result.Area,
+			result.Level,
+			outcome,
+			result.Status,
+			result.Blocks,
+			humanize.IBytes(result.Bytes),
+			result.Duration,
+		)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+
+	for _, result := range report.Results {
+		if !result.Passed() {
+			fmt.Fprintf(w, "%s (%s): %s\n  %s\n", result.Name, result.Description, result.Error, result.URL)
+		}
+	}
+
+	must, should := report.Failed(conformance.LevelMust), report.Failed(conformance.LevelShould)
+	if must == 0 && should == 0 {
+		fmt.Fprintln(w, "All cases passed")
+		return
+	}
+	fmt.Fprintf(w, "Failed %d MUST and %d SHOULD cases\n", must, should)
+}
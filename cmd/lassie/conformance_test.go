@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestConformanceCommandFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   conformanceRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{
+				"conformance",
+				"--cid",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4",
+				"https://provider.example.com",
+			},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, providerURL string, request trustlessutils.Request, timeout time.Duration, jsonOutput bool) error {
+				require.Equal(t, "https://provider.example.com", providerURL)
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", request.Root.String())
+				require.Equal(t, "birb.mp4", request.Path)
+				require.Equal(t, time.Minute, timeout)
+				require.False(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "with timeout and json",
+			args: []string{
+				"conformance",
+				"--cid",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+				"--timeout",
+				"10s",
+				"--json",
+				"http://127.0.0.1:8080",
+			},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, providerURL string, request trustlessutils.Request, timeout time.Duration, jsonOutput bool) error {
+				require.Equal(t, "http://127.0.0.1:8080", providerURL)
+				require.Equal(t, "", request.Path)
+				require.Equal(t, 10*time.Second, timeout)
+				require.True(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "without a cid",
+			args: []string{
+				"conformance",
+				"https://provider.example.com",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with an invalid cid",
+			args: []string{
+				"conformance",
+				"--cid",
+				"not-a-cid",
+				"https://provider.example.com",
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// conformanceRun is a global var that we can override for testing purposes
+		conformanceRun = test.assertRun
+		if test.shouldError {
+			conformanceRun = noopConformanceRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    conformanceFlags,
+			Commands: []*cli.Command{conformanceCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopConformanceRun(
+	ctx context.Context,
+	dataWriter io.Writer,
+	providerURL string,
+	request trustlessutils.Request,
+	timeout time.Duration,
+	jsonOutput bool,
+) error {
+	return nil
+}
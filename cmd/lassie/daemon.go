@@ -1,19 +1,58 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/accesslog"
 	"github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
 	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/filecoin-project/lassie/pkg/responsecache"
+	"github.com/filecoin-project/lassie/pkg/resultstore"
 	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"gopkg.in/yaml.v3"
 )
 
+// eventFlushTimeout bounds how long the daemon waits on shutdown for the
+// events of the retrievals it served to be recorded.
+const eventFlushTimeout = 10 * time.Second
+
 var daemonFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:      "config",
+		Usage:     "read flags from this YAML (.yaml or .yml) or TOML (.toml) file, keyed by flag name, e.g. port: 8080; flags given on the command line or in environment variables take precedence",
+		TakesFile: true,
+		EnvVars:   []string{"LASSIE_CONFIG"},
+	},
 	&cli.StringFlag{
 		Name:        "address",
 		Aliases:     []string{"a"},
@@ -30,6 +69,43 @@ var daemonFlags = []cli.Flag{
 		DefaultText: "random",
 		EnvVars:     []string{"LASSIE_PORT"},
 	},
+	&cli.StringFlag{
+		Name:    "tls-cert",
+		Usage:   "serve HTTPS with the PEM certificate chain in this file; requires --tls-key",
+		EnvVars: []string{"LASSIE_TLS_CERT"},
+	},
+	&cli.StringFlag{
+		Name:    "tls-key",
+		Usage:   "the PEM private key of --tls-cert",
+		EnvVars: []string{"LASSIE_TLS_KEY"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "tls-acme-domain",
+		Usage:   "serve HTTPS with a certificate for this domain obtained automatically from Let's Encrypt, accepting its terms of service; may be repeated; requires --tls-acme-cache-dir",
+		EnvVars: []string{"LASSIE_TLS_ACME_DOMAINS"},
+	},
+	&cli.StringFlag{
+		Name:    "tls-acme-cache-dir",
+		Usage:   "directory in which ACME certificates and the account key are kept across restarts",
+		EnvVars: []string{"LASSIE_TLS_ACME_CACHE_DIR"},
+	},
+	&cli.StringFlag{
+		Name:    "tls-acme-email",
+		Usage:   "contact email given to the certificate authority",
+		EnvVars: []string{"LASSIE_TLS_ACME_EMAIL"},
+	},
+	&cli.StringFlag{
+		Name:        "tls-acme-directory",
+		Usage:       "directory URL of the ACME certificate authority, e.g. Let's Encrypt's staging directory for testing",
+		DefaultText: "Let's Encrypt",
+		EnvVars:     []string{"LASSIE_TLS_ACME_DIRECTORY"},
+	},
+	&cli.StringFlag{
+		Name:        "tls-acme-http-address",
+		Usage:       "address, e.g. :80, on which to answer ACME HTTP-01 challenges and redirect HTTP to HTTPS",
+		DefaultText: "TLS-ALPN-01 challenges on --port, which must be reachable on port 443",
+		EnvVars:     []string{"LASSIE_TLS_ACME_HTTP_ADDRESS"},
+	},
 	&cli.Uint64Flag{
 		Name:        "maxblocks",
 		Aliases:     []string{"mb"},
@@ -38,6 +114,105 @@ var daemonFlags = []cli.Flag{
 		DefaultText: "no limit",
 		EnvVars:     []string{"LASSIE_MAX_BLOCKS_PER_REQUEST"},
 	},
+	&cli.UintFlag{
+		Name:        "max-concurrent-requests",
+		Usage:       "number of in-flight requests at which /readyz reports the daemon as not ready",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONCURRENT_REQUESTS"},
+	},
+	&cli.StringFlag{
+		Name:        "min-temp-space",
+		Usage:       "free space in the temporary directory below which /readyz reports the daemon as not ready, e.g. 10GiB",
+		DefaultText: "no minimum",
+		EnvVars:     []string{"LASSIE_MIN_TEMP_SPACE"},
+	},
+	&cli.StringFlag{
+		Name:        "in-memory-threshold",
+		Usage:       "hold the temporary blocks of each request in memory until they exceed this size, e.g. 4MiB, only then writing them to a CAR in the temporary directory, so that small requests never create a temporary file",
+		DefaultText: "always write to the temporary directory",
+		EnvVars:     []string{"LASSIE_IN_MEMORY_THRESHOLD"},
+	},
+	&cli.StringFlag{
+		Name:        "temp-quota",
+		Usage:       "maximum total size of the blocks held in temporary storage by all of the requests being served, e.g. 50GiB, beyond which a retrieval fails rather than write another",
+		DefaultText: "no quota",
+		EnvVars:     []string{"LASSIE_TEMP_QUOTA"},
+	},
+	&cli.StringFlag{
+		Name:        "temp-quota-high-water",
+		Usage:       "temporary storage in use at or above which new retrievals wait for it to fall, or are rejected with 503, and /readyz reports the daemon as not ready; requires --temp-quota",
+		DefaultText: "90% of --temp-quota",
+		EnvVars:     []string{"LASSIE_TEMP_QUOTA_HIGH_WATER"},
+	},
+	&cli.DurationFlag{
+		Name:        "temp-quota-timeout",
+		Usage:       "how long a new retrieval waits for temporary storage in use to fall below --temp-quota-high-water before it is rejected with 503; requires --temp-quota",
+		DefaultText: "rejected immediately",
+		EnvVars:     []string{"LASSIE_TEMP_QUOTA_TIMEOUT"},
+	},
+	&cli.UintFlag{
+		Name:        "max-concurrent-retrievals",
+		Usage:       "maximum number of retrieval requests served at once, beyond which requests are queued or rejected with 503",
+		Value:       0,
+		DefaultText: "no limit",
+		EnvVars:     []string{"LASSIE_MAX_CONCURRENT_RETRIEVALS"},
+	},
+	&cli.UintFlag{
+		Name:    "max-queued-retrievals",
+		Usage:   "maximum number of retrieval requests waiting for one of --max-concurrent-retrievals to finish",
+		Value:   0,
+		EnvVars: []string{"LASSIE_MAX_QUEUED_RETRIEVALS"},
+	},
+	&cli.DurationFlag{
+		Name:    "queue-timeout",
+		Usage:   "how long a queued retrieval request waits before it is rejected with 503",
+		Value:   30 * time.Second,
+		EnvVars: []string{"LASSIE_QUEUE_TIMEOUT"},
+	},
+	&cli.DurationFlag{
+		Name:    "drain-timeout",
+		Usage:   "how long to wait on shutdown for requests in flight to complete before cutting them off, or 0 to cut them off immediately; a second interrupt exits immediately",
+		Value:   30 * time.Second,
+		EnvVars: []string{"LASSIE_DRAIN_TIMEOUT"},
+	},
+	&cli.StringFlag{
+		Name:    "cache-dir",
+		Usage:   "directory in which to cache complete CAR responses, so that repeated requests for the same content aren't retrieved again; requires --cache-size",
+		EnvVars: []string{"LASSIE_CACHE_DIR"},
+	},
+	&cli.StringFlag{
+		Name:    "cache-size",
+		Usage:   "maximum total size of the responses in --cache-dir, e.g. 10GiB, beyond which the least recently used are evicted",
+		EnvVars: []string{"LASSIE_CACHE_SIZE"},
+	},
+	&cli.StringFlag{
+		Name:    "block-cache-dir",
+		Usage:   "directory in which to cache every block retrieved, so that requests whose blocks are all cached, including for content other than that first requested, are served without contacting providers; requires --block-cache-size",
+		EnvVars: []string{"LASSIE_BLOCK_CACHE_DIR"},
+	},
+	&cli.StringFlag{
+		Name:    "block-cache-size",
+		Usage:   "maximum total size of the blocks in --block-cache-dir, e.g. 100GiB, beyond which the least recently used are evicted",
+		EnvVars: []string{"LASSIE_BLOCK_CACHE_SIZE"},
+	},
+	&cli.StringFlag{
+		Name:    "access-log",
+		Usage:   "file to write a JSON access log entry to for each request served, or - for stdout",
+		EnvVars: []string{"LASSIE_ACCESS_LOG"},
+	},
+	&cli.StringFlag{
+		Name:    "access-log-max-size",
+		Usage:   "size, e.g. 100MiB, at which the --access-log file is rotated; 0 never rotates it",
+		Value:   "100MiB",
+		EnvVars: []string{"LASSIE_ACCESS_LOG_MAX_SIZE"},
+	},
+	&cli.IntFlag{
+		Name:    "access-log-max-backups",
+		Usage:   "number of rotated --access-log files to keep",
+		Value:   5,
+		EnvVars: []string{"LASSIE_ACCESS_LOG_MAX_BACKUPS"},
+	},
 	&cli.IntFlag{
 		Name:        "libp2p-conns-lowwater",
 		Aliases:     []string{"lw"},
@@ -62,32 +237,260 @@ var daemonFlags = []cli.Flag{
 		DefaultText: "no limit",
 		EnvVars:     []string{"LASSIE_CONCURRENT_SP_RETRIEVALS"},
 	},
+	&cli.DurationFlag{
+		Name:        "telemetry-interval",
+		Usage:       "how often to log the state of the libp2p swarm, such as connected peers and open graphsync channels",
+		Value:       0,
+		DefaultText: "disabled",
+		EnvVars:     []string{"LASSIE_TELEMETRY_INTERVAL"},
+	},
+	&cli.StringFlag{
+		Name:        "identity",
+		Usage:       "path to a file holding the libp2p private key, giving the daemon a stable peer ID across restarts; a new key is generated and written to the file if it doesn't exist",
+		DefaultText: "new peer ID on each start",
+		EnvVars:     []string{"LASSIE_IDENTITY"},
+		TakesFile:   true,
+	},
+	&cli.StringFlag{
+		Name:        "reputation-dir",
+		Usage:       "path to a directory holding a datastore that provider performance metrics are saved to and loaded from, so that a restart doesn't reset provider scoring",
+		DefaultText: "metrics are lost on restart",
+		EnvVars:     []string{"LASSIE_REPUTATION_DIR"},
+		TakesFile:   true,
+	},
+	&cli.DurationFlag{
+		Name:        "reputation-half-life",
+		Usage:       "the age at which provider metrics loaded from --reputation-dir carry half of their weight",
+		DefaultText: session.DefaultPersistHalfLife.String(),
+		EnvVars:     []string{"LASSIE_REPUTATION_HALF_LIFE"},
+	},
+	&cli.StringFlag{
+		Name:        "results-dir",
+		Usage:       "path to a directory holding a datastore that the result of each retrieval is stored in, to be queried at /results",
+		DefaultText: "results are not stored",
+		EnvVars:     []string{"LASSIE_RESULTS_DIR"},
+		TakesFile:   true,
+	},
+	&cli.DurationFlag{
+		Name:        "results-retention",
+		Usage:       "how long the results stored in --results-dir are kept for",
+		DefaultText: resultstore.DefaultRetention.String(),
+		EnvVars:     []string{"LASSIE_RESULTS_RETENTION"},
+	},
 	FlagIPNIEndpoint,
 	FlagEventRecorderAuth,
 	FlagEventRecorderInstanceId,
 	FlagEventRecorderUrl,
+	FlagEventWebhookUrl,
+	FlagEventWebhookHeaders,
+	FlagAlertWebhookUrl,
+	FlagAlertWebhookHeaders,
 	FlagVerbose,
 	FlagVeryVerbose,
 	FlagProtocols,
 	FlagAllowProviders,
 	FlagExcludeProviders,
+	FlagExcludeNetworks,
+	FlagProviderBlockList,
+	FlagProviderAllowList,
+	&cli.DurationFlag{
+		Name:        "provider-list-refresh",
+		Usage:       "how often --provider-block-list and --provider-allow-list are reloaded, they are also reloaded on SIGHUP",
+		DefaultText: "only reloaded on SIGHUP",
+		EnvVars:     []string{"LASSIE_PROVIDER_LIST_REFRESH"},
+	},
 	FlagTempDir,
 	FlagBitswapConcurrency,
 	FlagBitswapConcurrencyPerRetrieval,
+	FlagBitswapSessionIdleTimeout,
+	FlagBitswapPreloadMaxBytes,
+	FlagBitswapPreloadSpillDir,
+	FlagMaxBlockSize,
+	FlagMaxCandidates,
+	FlagMaxGraphsyncQueries,
+	FlagMaxHttpQueries,
+	FlagHttpRateLimit,
+	FlagHttpRateLimitBurst,
+	FlagHttpHostRateLimit,
+	FlagHttpPrewarm,
+	FlagHttpPrewarmMinLatency,
+	FlagLatencyProbes,
+	FlagLatencyProbeTimeout,
+	FlagRegion,
+	FlagRegionTable,
+	FlagCapabilityTTL,
+	FlagCircuitBreakerThreshold,
+	FlagCircuitBreakerCooldown,
+	FlagCircuitBreakerMaxCooldown,
+	FlagRetries,
+	FlagRetryBackoff,
+	FlagRetryMaxBackoff,
+	FlagRetryJitter,
+	FlagRetryOverride,
+	FlagScoringWeights,
 	FlagGlobalTimeout,
 	FlagProviderTimeout,
 	&cli.StringFlag{
-		Name:  "access-token",
-		Usage: "require HTTP clients to authorize using Bearer scheme and given access token",
-		Value: "",
+		Name:        "provider-config",
+		Usage:       "path to a JSON file overriding --provider-timeout and --concurrent-sp-retrievals per protocol and per provider, see the README",
+		DefaultText: "the same for all protocols and providers",
+		EnvVars:     []string{"LASSIE_PROVIDER_CONFIG"},
+		TakesFile:   true,
+	},
+	FlagRetrievalReceipts,
+	FlagIpnsGateways,
+	FlagIpnsMaxDepth,
+	&cli.DurationFlag{
+		Name:    "ipns-max-age",
+		Usage:   "how long an IPNS name resolution is reused by /ipns/ requests before it is revalidated",
+		Value:   time.Minute,
+		EnvVars: []string{"LASSIE_IPNS_MAX_AGE"},
+	},
+	&cli.DurationFlag{
+		Name:    "ipns-max-stale",
+		Usage:   "how long after --ipns-max-age an IPNS name resolution may still be served immediately while it is revalidated in the background",
+		Value:   time.Hour,
+		EnvVars: []string{"LASSIE_IPNS_MAX_STALE"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "access-token",
+		Usage:   "require HTTP clients to authorize using Bearer scheme and given access token; may be repeated to accept any of several tokens, e.g. while rotating them",
+		EnvVars: []string{"LASSIE_ACCESS_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:      "jwt-secret-file",
+		Usage:     "accept JWTs signed with HS256, HS384 or HS512 and the secret in this file as access tokens; surrounding whitespace is ignored",
+		TakesFile: true,
+		EnvVars:   []string{"LASSIE_JWT_SECRET_FILE"},
+	},
+	&cli.StringFlag{
+		Name:      "jwt-public-key-file",
+		Usage:     "accept JWTs signed with the private key of the PEM encoded RSA, ECDSA or Ed25519 public key or certificate in this file as access tokens",
+		TakesFile: true,
+		EnvVars:   []string{"LASSIE_JWT_PUBLIC_KEY_FILE"},
+	},
+	&cli.StringFlag{
+		Name:    "jwt-issuer",
+		Usage:   "require JWTs to have this iss claim",
+		EnvVars: []string{"LASSIE_JWT_ISSUER"},
+	},
+	&cli.StringFlag{
+		Name:    "jwt-audience",
+		Usage:   "require JWTs to have this aud claim",
+		EnvVars: []string{"LASSIE_JWT_AUDIENCE"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "jwt-claim",
+		Usage:   "require JWTs to have a claim, in the form name=value, that is either the value or an array containing it; may be repeated",
+		EnvVars: []string{"LASSIE_JWT_CLAIMS"},
+	},
+	&cli.DurationFlag{
+		Name:    "jwt-leeway",
+		Usage:   "the clock skew allowed for when checking the exp and nbf claims of JWTs",
+		EnvVars: []string{"LASSIE_JWT_LEEWAY"},
+	},
+	&cli.StringFlag{
+		Name:    "rate-limit-ip",
+		Usage:   "limit the requests of each client IP address, as rate[:burst] in requests per second, e.g. 5:20; requests over the limit receive 429 Too Many Requests",
+		EnvVars: []string{"LASSIE_RATE_LIMIT_IP"},
+	},
+	&cli.StringFlag{
+		Name:    "rate-limit-ip-bandwidth",
+		Usage:   "limit the response bandwidth of each client IP address, as size[:burst] in bytes per second, e.g. 10MiB:50MiB; responses over the limit are slowed down",
+		EnvVars: []string{"LASSIE_RATE_LIMIT_IP_BANDWIDTH"},
+	},
+	&cli.StringFlag{
+		Name:    "rate-limit-token",
+		Usage:   "limit the requests of each Bearer token, as rate[:burst] in requests per second",
+		EnvVars: []string{"LASSIE_RATE_LIMIT_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "rate-limit-token-bandwidth",
+		Usage:   "limit the response bandwidth of each Bearer token, as size[:burst] in bytes per second",
+		EnvVars: []string{"LASSIE_RATE_LIMIT_TOKEN_BANDWIDTH"},
+	},
+	&cli.StringFlag{
+		Name:      "rate-limit-config",
+		Usage:     "read rate limits from this JSON file, which may also set limits for particular tokens; the --rate-limit-* flags override its limits",
+		TakesFile: true,
+		EnvVars:   []string{"LASSIE_RATE_LIMIT_CONFIG"},
 	},
+	&cli.StringFlag{
+		Name:      "api-keys",
+		Usage:     "read the API keys of the daemon's tenants, with their rate limits and request and egress quotas, from this JSON file; clients authorize with them like access tokens and their usage is reported by the /admin/api-keys endpoint",
+		TakesFile: true,
+		EnvVars:   []string{"LASSIE_API_KEYS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "cors-origin",
+		Usage:   "allow scripts in web pages from this origin to fetch from the daemon, e.g. https://app.example.com; * allows any origin and https://*.example.com its subdomains; may be repeated",
+		EnvVars: []string{"LASSIE_CORS_ORIGINS"},
+	},
+	&cli.StringSliceFlag{
+		Name:        "cors-method",
+		Usage:       "a method that cross-origin requests may use; may be repeated",
+		DefaultText: "GET and HEAD",
+		EnvVars:     []string{"LASSIE_CORS_METHODS"},
+	},
+	&cli.StringSliceFlag{
+		Name:        "cors-header",
+		Usage:       "a request header that cross-origin requests may send, * allowing any; may be repeated",
+		DefaultText: "the headers that lassie reads",
+		EnvVars:     []string{"LASSIE_CORS_HEADERS"},
+	},
+	&cli.DurationFlag{
+		Name:    "cors-max-age",
+		Usage:   "how long browsers may cache the response to a CORS preflight request",
+		EnvVars: []string{"LASSIE_CORS_MAX_AGE"},
+	},
+	&cli.BoolFlag{
+		Name:    "admin",
+		Usage:   "serve the /admin/ endpoints for listing and cancelling in-flight retrievals, toggling protocols, viewing provider statistics, flushing caches, adjusting log levels and reporting the usage of API keys; they require --admin-token, if given, or otherwise --access-token, and --admin-token is required with --api-keys or --jwt-*",
+		EnvVars: []string{"LASSIE_ADMIN"},
+	},
+	&cli.StringFlag{
+		Name:    "admin-address",
+		Usage:   "serve the /admin/ endpoints on this address, e.g. 127.0.0.1:8081, rather than alongside the gateway; requires --admin-token",
+		EnvVars: []string{"LASSIE_ADMIN_ADDRESS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "admin-token",
+		Usage:   "require clients of the /admin/ endpoints, served with --admin or --admin-address, to authorize using Bearer scheme and this token in place of the gateway's tokens; may be repeated",
+		EnvVars: []string{"LASSIE_ADMIN_TOKENS"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "log-level",
+		Usage:   "set the level of lassie's logs to debug, info, warn or error, or that of a single subsystem with subsystem=level, e.g. lassie/httpserver=debug; may be repeated",
+		EnvVars: []string{"LASSIE_LOG_LEVELS"},
+	},
+	&cli.BoolFlag{
+		Name:    "in-memory",
+		Usage:   "never touch disk, holding the temporary CAR of each request in memory; can't be used with --identity, --reputation-dir, --results-dir, --tempdir, --min-temp-space, --cache-dir, --block-cache-dir, --bitswap-preload-spill-dir, --tls-acme-cache-dir or an --access-log file",
+		EnvVars: []string{"LASSIE_IN_MEMORY"},
+	},
+}
+
+// reloadableDaemonFlags are the flags that are safe to change while
+// retrievals are in progress, which the daemon reads again from its --config
+// file on SIGHUP. They aren't set from the file like the other flags, so that
+// whether they were given on the command line or in the environment, which
+// takes precedence, is still known when reloading.
+var reloadableDaemonFlags = []string{
+	"global-timeout",
+	"log-level",
+	"rate-limit-ip",
+	"rate-limit-ip-bandwidth",
+	"rate-limit-token",
+	"rate-limit-token-bandwidth",
+	"rate-limit-config",
 }
 
 var daemonCmd = &cli.Command{
 	Name:   "daemon",
 	Usage:  "Starts a lassie daemon, accepting http requests",
+	Before: loadDaemonConfig,
 	After:  after,
-	Flags:  daemonFlags,
+	Flags:  configurableFlags(daemonFlags),
 	Action: daemonAction,
 }
 
@@ -100,12 +503,101 @@ func daemonAction(cctx *cli.Context) error {
 	libp2pLowWater := cctx.Int("libp2p-conns-lowwater")
 	libp2pHighWater := cctx.Int("libp2p-conns-highwater")
 	concurrentSPRetrievals := cctx.Uint("concurrent-sp-retrievals")
+	telemetryInterval := cctx.Duration("telemetry-interval")
+	identityPath := cctx.String("identity")
+	reputationDir := cctx.String("reputation-dir")
+	resultsDir := cctx.String("results-dir")
+	inMemory := cctx.Bool("in-memory")
 	lassieOpts := []lassie.LassieOption{}
 
+	if inMemory {
+		if identityPath != "" {
+			return fmt.Errorf("%w: identity file %s", lassie.ErrDiskAccess, identityPath)
+		}
+		if reputationDir != "" {
+			return fmt.Errorf("%w: reputation directory %s", lassie.ErrDiskAccess, reputationDir)
+		}
+		if resultsDir != "" {
+			return fmt.Errorf("%w: results directory %s", lassie.ErrDiskAccess, resultsDir)
+		}
+		if cctx.IsSet("tempdir") {
+			return fmt.Errorf("%w: temporary directory %s", lassie.ErrDiskAccess, cctx.String("tempdir"))
+		}
+		if cctx.IsSet("min-temp-space") {
+			return fmt.Errorf("%w: minimum temporary space %s", lassie.ErrDiskAccess, cctx.String("min-temp-space"))
+		}
+		if cctx.IsSet("in-memory-threshold") {
+			return fmt.Errorf("--in-memory-threshold can't be used with --in-memory, which holds every request in memory")
+		}
+		if cctx.IsSet("bitswap-preload-spill-dir") {
+			return fmt.Errorf("%w: preload spill directory %s", lassie.ErrDiskAccess, cctx.String("bitswap-preload-spill-dir"))
+		}
+		if cctx.IsSet("cache-dir") {
+			return fmt.Errorf("%w: cache directory %s", lassie.ErrDiskAccess, cctx.String("cache-dir"))
+		}
+		if cctx.IsSet("block-cache-dir") {
+			return fmt.Errorf("%w: block cache directory %s", lassie.ErrDiskAccess, cctx.String("block-cache-dir"))
+		}
+		if cctx.IsSet("tls-acme-cache-dir") {
+			return fmt.Errorf("%w: ACME cache directory %s", lassie.ErrDiskAccess, cctx.String("tls-acme-cache-dir"))
+		}
+		if accessLog := cctx.String("access-log"); accessLog != "" && accessLog != "-" {
+			return fmt.Errorf("%w: access log %s", lassie.ErrDiskAccess, accessLog)
+		}
+		lassieOpts = append(lassieOpts, lassie.WithInMemory())
+	}
+
 	if concurrentSPRetrievals > 0 {
 		lassieOpts = append(lassieOpts, lassie.WithConcurrentSPRetrievals(concurrentSPRetrievals))
 	}
 
+	if telemetryInterval > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithTelemetryInterval(telemetryInterval))
+	}
+
+	if reputationDir != "" {
+		// the datastore is closed once the daemon has stopped, after the
+		// final save on shutdown
+		ds, err := leveldb.NewDatastore(reputationDir, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open reputation datastore: %w", err)
+		}
+		defer closeDatastore("reputation", ds)
+		lassieOpts = append(lassieOpts, lassie.WithReputationPersistence(session.PersistConfig{
+			Datastore: ds,
+			HalfLife:  cctx.Duration("reputation-half-life"),
+		}))
+	}
+
+	if resultsDir != "" {
+		ds, err := leveldb.NewDatastore(resultsDir, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open results datastore: %w", err)
+		}
+		defer closeDatastore("results", ds)
+		lassieOpts = append(lassieOpts, lassie.WithResultStore(resultstore.NewStore(resultstore.Config{
+			Datastore: ds,
+			Retention: cctx.Duration("results-retention"),
+		})))
+	}
+
+	blockCache, err := newBlockCache(cctx)
+	if err != nil {
+		return err
+	}
+	if blockCache != nil {
+		lassieOpts = append(lassieOpts, lassie.WithBlockCache(blockCache))
+	}
+
+	// retrieval metrics, along with the Go runtime and process metrics, are
+	// served at /metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	lassieOpts = append(lassieOpts, lassie.WithMetricsRegisterer(registry))
+
 	libp2pOpts := []config.Option{}
 	if libp2pHighWater != 0 || libp2pLowWater != 0 {
 		connManager, err := connmgr.NewConnManager(libp2pLowWater, libp2pHighWater)
@@ -115,18 +607,114 @@ func daemonAction(cctx *cli.Context) error {
 		libp2pOpts = append(libp2pOpts, libp2p.ConnectionManager(connManager))
 	}
 
+	if identityPath != "" {
+		key, err := host.LoadOrCreateIdentity(identityPath)
+		if err != nil {
+			return fmt.Errorf("failed to load identity: %w", err)
+		}
+		peerID, err := peer.IDFromPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		logger.Infow("Using libp2p identity", "peer_id", peerID, "path", identityPath)
+		libp2pOpts = append(libp2pOpts, libp2p.Identity(key))
+	}
+
 	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, lassieOpts, libp2pOpts)
 	if err != nil {
 		return err
 	}
+	appliedLogLevels := newLogLevels(cctx)
+	reload := func() (daemonSettings, error) {
+		settings, err := loadDaemonSettings(cctx)
+		if err == nil {
+			appliedLogLevels.set(settings.logLevels)
+		}
+		return settings, err
+	}
+	settings, err := reload()
+	if err != nil {
+		return err
+	}
+	lassieCfg.GlobalTimeout = settings.globalTimeout
 
 	// http server config
 	address := cctx.String("address")
 	port := cctx.Uint("port")
 	tempDir := cctx.String("tempdir")
+	if inMemory {
+		tempDir = ""
+	}
 	maxBlocks := cctx.Uint64("maxblocks")
-	accessToken := cctx.String("access-token")
-	httpServerCfg := getHttpServerConfigForDaemon(address, port, tempDir, maxBlocks, accessToken)
+	accessTokens := cctx.StringSlice("access-token")
+	maxConcurrentRequests := cctx.Uint("max-concurrent-requests")
+	httpServerCfg := getHttpServerConfigForDaemon(address, port, tempDir, maxBlocks, accessTokens, maxConcurrentRequests)
+	if httpServerCfg.JWT, err = newJWTConfig(cctx); err != nil {
+		return err
+	}
+	httpServerCfg.RateLimits = settings.rateLimits
+	if httpServerCfg.APIKeys, err = readAPIKeys(cctx.String("api-keys")); err != nil {
+		return err
+	}
+	if httpServerCfg.CORS, err = newCORSConfig(cctx); err != nil {
+		return err
+	}
+	if httpServerCfg.TLS, err = newTLSConfig(cctx); err != nil {
+		return err
+	}
+	if minTempSpace := cctx.String("min-temp-space"); minTempSpace != "" {
+		if httpServerCfg.MinTempSpace, err = humanize.ParseBytes(minTempSpace); err != nil {
+			return fmt.Errorf("invalid --min-temp-space %q: %w", minTempSpace, err)
+		}
+	}
+	if threshold := cctx.String("in-memory-threshold"); threshold != "" {
+		if httpServerCfg.InMemoryThreshold, err = humanize.ParseBytes(threshold); err != nil {
+			return fmt.Errorf("invalid --in-memory-threshold %q: %w", threshold, err)
+		}
+	}
+	if httpServerCfg.ResponseCache, err = newResponseCache(cctx); err != nil {
+		return err
+	}
+	if httpServerCfg.AccessLog, err = newAccessLog(cctx); err != nil {
+		return err
+	}
+	httpServerCfg.MaxConcurrentRetrievals = cctx.Uint("max-concurrent-retrievals")
+	if httpServerCfg.MaxConcurrentRetrievals == 0 {
+		for _, name := range []string{"max-queued-retrievals", "queue-timeout"} {
+			if cctx.IsSet(name) {
+				return fmt.Errorf("--%s requires --max-concurrent-retrievals", name)
+			}
+		}
+	}
+	httpServerCfg.MaxQueuedRetrievals = cctx.Uint("max-queued-retrievals")
+	httpServerCfg.QueueTimeout = cctx.Duration("queue-timeout")
+	httpServerCfg.DrainTimeout = cctx.Duration("drain-timeout")
+	httpServerCfg.EnableAdmin = cctx.Bool("admin")
+	httpServerCfg.AdminAddress = cctx.String("admin-address")
+	httpServerCfg.AdminAccessTokens = cctx.StringSlice("admin-token")
+	if !httpServerCfg.EnableAdmin && httpServerCfg.AdminAddress == "" && len(httpServerCfg.AdminAccessTokens) > 0 {
+		return fmt.Errorf("--admin-token requires --admin or --admin-address")
+	}
+	if httpServerCfg.AdminAddress != "" && len(httpServerCfg.AdminAccessTokens) == 0 {
+		return fmt.Errorf("--admin-address requires --admin-token")
+	}
+	if httpServerCfg.EnableAdmin && len(httpServerCfg.AdminAccessTokens) == 0 && (len(httpServerCfg.APIKeys) > 0 || httpServerCfg.JWT != nil) {
+		return fmt.Errorf("--admin with --api-keys or --jwt-* requires --admin-token, so that tenants can't call the /admin/ endpoints")
+	}
+	httpServerCfg.Metrics = registry
+	if httpServerCfg.TempQuota, err = newTempQuota(cctx); err != nil {
+		return err
+	}
+	if httpServerCfg.TempQuota != nil {
+		registry.MustRegister(httpServerCfg.TempQuota.Collectors()...)
+	}
+	httpServerCfg.TempQuotaTimeout = cctx.Duration("temp-quota-timeout")
+	httpServerCfg.InMemory = inMemory
+	if httpServerCfg.IpnsResolver, err = newIpnsResolver(cctx); err != nil {
+		return err
+	}
+	httpServerCfg.IpnsMaxAge = cctx.Duration("ipns-max-age")
+	httpServerCfg.IpnsMaxStale = cctx.Duration("ipns-max-stale")
 
 	// event recorder config
 	eventRecorderURL := cctx.String("event-recorder-url")
@@ -139,6 +727,7 @@ func daemonAction(cctx *cli.Context) error {
 		lassieCfg,
 		httpServerCfg,
 		eventRecorderCfg,
+		reload,
 	)
 	if err != nil {
 		return cli.Exit(err, 1)
@@ -147,12 +736,15 @@ func daemonAction(cctx *cli.Context) error {
 	return nil
 }
 
-// daemonRunFunc is the function signature for the daemonRun function.
+// daemonRunFunc is the function signature for the daemonRun function. reload
+// reads the settings of the reloadableDaemonFlags again, applying their log
+// levels.
 type daemonRunFunc func(
 	ctx context.Context,
 	lassieCfg *lassie.LassieConfig,
 	httpServerCfg httpserver.HttpServerConfig,
 	eventRecorderCfg *aggregateeventrecorder.EventRecorderConfig,
+	reload func() (daemonSettings, error),
 ) error
 
 // daemonRun is the instance of a daemonRunFunc function that will
@@ -166,26 +758,85 @@ func defaultDaemonRun(
 	lassieCfg *lassie.LassieConfig,
 	httpServerCfg httpserver.HttpServerConfig,
 	eventRecorderCfg *aggregateeventrecorder.EventRecorderConfig,
+	reload func() (daemonSettings, error),
 ) error {
-	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
+	// ctx is cancelled on the first interrupt, at which point the daemon
+	// drains, so everything runs with a context of its own that lasts until
+	// the daemon has stopped
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	lassie, err := lassie.NewLassieWithConfig(runCtx, lassieCfg)
 	if err != nil {
-		return nil
+		return fmt.Errorf("failed to start lassie: %w", err)
 	}
 
-	// create and subscribe an event recorder API if an endpoint URL is set
-	if eventRecorderCfg.EndpointURL != "" {
-		setupLassieEventRecorder(ctx, eventRecorderCfg, lassie)
+	// log swarm telemetry if it's enabled
+	if lassieCfg.TelemetryInterval > 0 {
+		lassie.RegisterSubscriber(events.TypedSubscriber(func(telemetry events.SwarmTelemetryEvent) {
+			logger.Infow("Swarm telemetry",
+				"connected_peers", telemetry.ConnectedPeers(),
+				"bitswap_sessions", telemetry.ActiveBitswapSessions(),
+				"graphsync_channels", telemetry.OpenGraphsyncChannels(),
+				"dials_in_progress", telemetry.DialsInProgress(),
+			)
+		}))
+	}
+
+	// accept W3C trace context from clients so retrieval spans join their
+	// traces, spans are exported by whatever tracer provider is installed
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// pass the hops of IPNS and DNSLink resolutions on to the subscribers of
+	// retrieval events
+	if resolver, ok := httpServerCfg.IpnsResolver.(*ipnsresolver.Resolver); ok {
+		defer resolver.RegisterSubscriber(lassie.DispatchEvent)()
 	}
 
-	httpServer, err := httpserver.NewHttpServer(ctx, lassie, httpServerCfg)
+	// create and subscribe an event recorder API if an endpoint URL is set
+	closeEventRecorder := setupLassieEventRecorder(runCtx, eventRecorderCfg, lassie)
+
+	httpServer, err := httpserver.NewHttpServer(runCtx, lassie, httpServerCfg)
 	if err != nil {
 		logger.Errorw("failed to create http server", "err", err)
 		return err
 	}
 
+	// reload the provider lists and the reloadable flags on SIGHUP, so that
+	// providers can be blocked and limits changed without a restart that
+	// would drop the retrievals in progress
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-hangup:
+				if lassieCfg.ProviderListSources != nil {
+					if err := lassie.ReloadProviderLists(runCtx); err != nil {
+						logger.Errorw("Failed to reload provider lists, keeping the previous lists", "err", err)
+					}
+				}
+				settings, err := reload()
+				if err != nil {
+					logger.Errorw("Failed to reload configuration, keeping the previous settings", "err", err)
+					continue
+				}
+				lassie.SetGlobalTimeout(settings.globalTimeout)
+				httpServer.SetRateLimits(settings.rateLimits)
+				logger.Infow("Reloaded configuration", "global_timeout", settings.globalTimeout)
+			}
+		}
+	}()
+
 	serverErrChan := make(chan error, 1)
 	go func() {
 		fmt.Printf("Lassie daemon listening on address %s\n", httpServer.Addr())
+		if adminAddr := httpServer.AdminAddr(); adminAddr != "" {
+			fmt.Printf("Lassie admin endpoints listening on address %s\n", adminAddr)
+		}
 		fmt.Println("Hit CTRL-C to stop the daemon")
 		serverErrChan <- httpServer.Start()
 	}()
@@ -197,21 +848,559 @@ func defaultDaemonRun(
 	}
 
 	fmt.Println("Shutting down Lassie daemon")
-	if err = httpServer.Close(); err != nil {
+	if httpServerCfg.DrainTimeout > 0 {
+		fmt.Printf("Waiting up to %s for requests in flight to complete\n", httpServerCfg.DrainTimeout)
+		err = httpServer.Shutdown(runCtx)
+	} else {
+		err = httpServer.Close()
+	}
+	if err != nil {
 		logger.Errorw("failed to close http server", "err", err)
 	}
 
+	// record the events of the retrievals served before exiting
+	flushCtx, cancel := context.WithTimeout(runCtx, eventFlushTimeout)
+	defer cancel()
+	if err := lassie.FlushEvents(flushCtx); err != nil {
+		logger.Warnw("failed to deliver retrieval events", "err", err)
+	}
+	if err := closeEventRecorder(flushCtx); err != nil {
+		logger.Warnw("failed to record retrieval events with the event recorder", "err", err)
+	}
+
+	// save the provider metrics a final time before their datastore is closed
+	stop()
+	saveCtx, cancelSave := context.WithTimeout(context.Background(), eventFlushTimeout)
+	defer cancelSave()
+	if err := lassie.WaitReputationSaved(saveCtx); err != nil {
+		logger.Warnw("failed to save provider metrics", "err", err)
+	}
+
 	fmt.Println("Lassie daemon stopped")
 	return err
 }
 
+// closeDatastore closes a datastore opened for the daemon, logging a failure
+func closeDatastore(name string, ds *leveldb.Datastore) {
+	if err := ds.Close(); err != nil {
+		logger.Warnw("failed to close "+name+" datastore", "err", err)
+	}
+}
+
 // getHttpServerConfigForDaemon returns a HttpServerConfig for the daemon command.
-func getHttpServerConfigForDaemon(address string, port uint, tempDir string, maxBlocks uint64, accessToken string) httpserver.HttpServerConfig {
+func getHttpServerConfigForDaemon(address string, port uint, tempDir string, maxBlocks uint64, accessTokens []string, maxConcurrentRequests uint) httpserver.HttpServerConfig {
 	return httpserver.HttpServerConfig{
-		Address:             address,
-		Port:                port,
-		TempDir:             tempDir,
-		MaxBlocksPerRequest: maxBlocks,
-		AccessToken:         accessToken,
+		Address:               address,
+		Port:                  port,
+		TempDir:               tempDir,
+		MaxBlocksPerRequest:   maxBlocks,
+		AccessTokens:          accessTokens,
+		MaxConcurrentRequests: maxConcurrentRequests,
+	}
+}
+
+// newJWTConfig returns the JWT validation configured with the --jwt-* flags,
+// or nil if neither a secret nor a public key is set.
+func newJWTConfig(cctx *cli.Context) (*httpserver.JWTConfig, error) {
+	secretFile, publicKeyFile := cctx.String("jwt-secret-file"), cctx.String("jwt-public-key-file")
+	if secretFile == "" && publicKeyFile == "" {
+		for _, name := range []string{"jwt-issuer", "jwt-audience", "jwt-claim", "jwt-leeway"} {
+			if cctx.IsSet(name) {
+				return nil, fmt.Errorf("--%s requires --jwt-secret-file or --jwt-public-key-file", name)
+			}
+		}
+		return nil, nil
+	}
+
+	cfg := &httpserver.JWTConfig{
+		Issuer:   cctx.String("jwt-issuer"),
+		Audience: cctx.String("jwt-audience"),
+		Leeway:   cctx.Duration("jwt-leeway"),
+	}
+	if secretFile != "" {
+		secret, err := os.ReadFile(secretFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read JWT secret: %w", err)
+		}
+		if cfg.Secret = bytes.TrimSpace(secret); len(cfg.Secret) == 0 {
+			return nil, fmt.Errorf("JWT secret file %s is empty", secretFile)
+		}
+	}
+	if publicKeyFile != "" {
+		data, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read JWT public key: %w", err)
+		}
+		if cfg.PublicKey, err = httpserver.ParseJWTPublicKey(data); err != nil {
+			return nil, fmt.Errorf("invalid JWT public key %s: %w", publicKeyFile, err)
+		}
+	}
+	for _, claim := range cctx.StringSlice("jwt-claim") {
+		name, value, ok := strings.Cut(claim, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid JWT claim %q, expected name=value", claim)
+		}
+		if cfg.Claims == nil {
+			cfg.Claims = make(map[string]string)
+		}
+		cfg.Claims[name] = value
+	}
+	return cfg, nil
+}
+
+// newCORSConfig returns the CORS configured with the --cors-* flags, or nil if
+// no origins are allowed.
+func newCORSConfig(cctx *cli.Context) (*httpserver.CORSConfig, error) {
+	origins := cctx.StringSlice("cors-origin")
+	if len(origins) == 0 {
+		for _, name := range []string{"cors-method", "cors-header", "cors-max-age"} {
+			if cctx.IsSet(name) {
+				return nil, fmt.Errorf("--%s requires --cors-origin", name)
+			}
+		}
+		return nil, nil
+	}
+	methods := cctx.StringSlice("cors-method")
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+	}
+	return &httpserver.CORSConfig{
+		AllowedOrigins: origins,
+		AllowedMethods: methods,
+		AllowedHeaders: cctx.StringSlice("cors-header"),
+		MaxAge:         cctx.Duration("cors-max-age"),
+	}, nil
+}
+
+// newTLSConfig returns the TLS configuration of the --tls-* flags, or nil if
+// the daemon is to serve plain HTTP.
+func newTLSConfig(cctx *cli.Context) (*httpserver.TLSConfig, error) {
+	certFile, keyFile := cctx.String("tls-cert"), cctx.String("tls-key")
+	domains := cctx.StringSlice("tls-acme-domain")
+	if len(domains) == 0 {
+		for _, name := range []string{"tls-acme-cache-dir", "tls-acme-email", "tls-acme-directory", "tls-acme-http-address"} {
+			if cctx.IsSet(name) {
+				return nil, fmt.Errorf("--%s requires --tls-acme-domain", name)
+			}
+		}
+		if certFile == "" && keyFile == "" {
+			return nil, nil
+		}
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("--tls-cert and --tls-key must be used together")
+		}
+		return &httpserver.TLSConfig{CertFile: certFile, KeyFile: keyFile}, nil
+	}
+	if certFile != "" || keyFile != "" {
+		return nil, fmt.Errorf("--tls-acme-domain can't be used with --tls-cert or --tls-key")
+	}
+	cacheDir := cctx.String("tls-acme-cache-dir")
+	if cacheDir == "" {
+		// without it every restart obtains new certificates, which soon
+		// runs into the certificate authority's rate limits
+		return nil, fmt.Errorf("--tls-acme-domain requires --tls-acme-cache-dir")
+	}
+	return &httpserver.TLSConfig{
+		ACMEDomains:      domains,
+		ACMECacheDir:     cacheDir,
+		ACMEEmail:        cctx.String("tls-acme-email"),
+		ACMEDirectoryURL: cctx.String("tls-acme-directory"),
+		ACMEHTTPAddress:  cctx.String("tls-acme-http-address"),
+	}, nil
+}
+
+// newRateLimits returns the rate limits of the --rate-limit-config file, if
+// any, overridden by the other --rate-limit-* flags, whose values are looked
+// up with value.
+func newRateLimits(value func(name string) string) (httpserver.RateLimits, error) {
+	var limits httpserver.RateLimits
+	if configFile := value("rate-limit-config"); configFile != "" {
+		f, err := os.Open(configFile)
+		if err != nil {
+			return limits, fmt.Errorf("cannot read rate limit config: %w", err)
+		}
+		defer f.Close()
+		if limits, err = httpserver.ParseRateLimits(f); err != nil {
+			return limits, fmt.Errorf("%s: %w", configFile, err)
+		}
+	}
+	for _, flag := range []struct {
+		name  string
+		limit *httpserver.RateLimit
+	}{{"rate-limit-ip", &limits.IP}, {"rate-limit-token", &limits.Token}} {
+		if v := value(flag.name); v != "" {
+			rate, burst, hasBurst := strings.Cut(v, ":")
+			requestsPerSecond, err := strconv.ParseFloat(rate, 64)
+			if err != nil || requestsPerSecond <= 0 {
+				return limits, fmt.Errorf("invalid rate in --%s %q, expected rate[:burst]", flag.name, v)
+			}
+			flag.limit.RequestsPerSecond, flag.limit.RequestBurst = requestsPerSecond, 0
+			if hasBurst {
+				if flag.limit.RequestBurst, err = strconv.Atoi(burst); err != nil || flag.limit.RequestBurst < 1 {
+					return limits, fmt.Errorf("invalid burst in --%s %q, expected rate[:burst]", flag.name, v)
+				}
+			}
+		}
+	}
+	for _, flag := range []struct {
+		name  string
+		limit *httpserver.RateLimit
+	}{{"rate-limit-ip-bandwidth", &limits.IP}, {"rate-limit-token-bandwidth", &limits.Token}} {
+		if v := value(flag.name); v != "" {
+			size, burst, hasBurst := strings.Cut(v, ":")
+			bytesPerSecond, err := humanize.ParseBytes(size)
+			if err != nil || bytesPerSecond == 0 {
+				return limits, fmt.Errorf("invalid size in --%s %q, expected size[:burst]", flag.name, v)
+			}
+			flag.limit.BytesPerSecond, flag.limit.ByteBurst = float64(bytesPerSecond), 0
+			if hasBurst {
+				byteBurst, err := humanize.ParseBytes(burst)
+				if err != nil || byteBurst == 0 {
+					return limits, fmt.Errorf("invalid burst in --%s %q, expected size[:burst]", flag.name, v)
+				}
+				flag.limit.ByteBurst = int64(byteBurst)
+			}
+		}
+	}
+	return limits, nil
+}
+
+// readAPIKeys returns the API keys of the --api-keys file, if any.
+func readAPIKeys(path string) ([]httpserver.APIKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read API keys: %w", err)
+	}
+	defer f.Close()
+	keys, err := httpserver.ParseAPIKeys(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// newResponseCache returns the response cache configured with --cache-dir and
+// --cache-size, or nil if there's no cache directory.
+func newResponseCache(cctx *cli.Context) (*responsecache.Cache, error) {
+	dir, size := cctx.String("cache-dir"), cctx.String("cache-size")
+	if dir == "" {
+		if size != "" {
+			return nil, fmt.Errorf("--cache-size requires --cache-dir")
+		}
+		return nil, nil
+	}
+	if size == "" {
+		return nil, fmt.Errorf("--cache-dir requires --cache-size")
+	}
+	maxSize, err := humanize.ParseBytes(size)
+	if err != nil || maxSize == 0 {
+		return nil, fmt.Errorf("invalid --cache-size %q", size)
+	}
+	return responsecache.New(dir, maxSize)
+}
+
+// newTempQuota returns the temporary storage quota configured with
+// --temp-quota and --temp-quota-high-water, or nil if there's no quota.
+func newTempQuota(cctx *cli.Context) (*storage.TempQuota, error) {
+	quota, highWater := cctx.String("temp-quota"), cctx.String("temp-quota-high-water")
+	if quota == "" {
+		for _, name := range []string{"temp-quota-high-water", "temp-quota-timeout"} {
+			if cctx.IsSet(name) {
+				return nil, fmt.Errorf("--%s requires --temp-quota", name)
+			}
+		}
+		return nil, nil
+	}
+	max, err := humanize.ParseBytes(quota)
+	if err != nil || max == 0 {
+		return nil, fmt.Errorf("invalid --temp-quota %q", quota)
+	}
+	var highWaterBytes uint64
+	if highWater != "" {
+		if highWaterBytes, err = humanize.ParseBytes(highWater); err != nil || highWaterBytes == 0 {
+			return nil, fmt.Errorf("invalid --temp-quota-high-water %q", highWater)
+		}
+	}
+	return storage.NewTempQuota(max, highWaterBytes)
+}
+
+// newBlockCache returns the block cache configured with --block-cache-dir and
+// --block-cache-size, or nil if there's no block cache directory.
+func newBlockCache(cctx *cli.Context) (*blockcache.Cache, error) {
+	dir, size := cctx.String("block-cache-dir"), cctx.String("block-cache-size")
+	if dir == "" {
+		if size != "" {
+			return nil, fmt.Errorf("--block-cache-size requires --block-cache-dir")
+		}
+		return nil, nil
+	}
+	if size == "" {
+		return nil, fmt.Errorf("--block-cache-dir requires --block-cache-size")
+	}
+	maxSize, err := humanize.ParseBytes(size)
+	if err != nil || maxSize == 0 {
+		return nil, fmt.Errorf("invalid --block-cache-size %q", size)
+	}
+	return blockcache.New(dir, maxSize)
+}
+
+// newAccessLog returns the access log configured with --access-log, or nil if
+// there's none. A log file is left open for the life of the process.
+func newAccessLog(cctx *cli.Context) (*accesslog.Log, error) {
+	path := cctx.String("access-log")
+	if path == "" {
+		return nil, nil
+	}
+	if path == "-" {
+		return accesslog.New(os.Stdout), nil
+	}
+	maxSize, err := humanize.ParseBytes(cctx.String("access-log-max-size"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --access-log-max-size %q", cctx.String("access-log-max-size"))
+	}
+	f, err := accesslog.OpenRotatingFile(path, maxSize, cctx.Int("access-log-max-backups"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open access log: %w", err)
+	}
+	return accesslog.New(f), nil
+}
+
+// configurableFlags wraps the flags so that they may also be set from the
+// --config file, see loadDaemonConfig.
+func configurableFlags(flags []cli.Flag) []cli.Flag {
+	wrapped := make([]cli.Flag, 0, len(flags))
+	for _, flag := range flags {
+		if isReloadableDaemonFlag(flag.Names()[0]) {
+			wrapped = append(wrapped, flag)
+			continue
+		}
+		switch f := flag.(type) {
+		case *cli.BoolFlag:
+			flag = altsrc.NewBoolFlag(f)
+		case *cli.DurationFlag:
+			flag = altsrc.NewDurationFlag(f)
+		case *cli.Float64Flag:
+			flag = altsrc.NewFloat64Flag(f)
+		case *cli.IntFlag:
+			flag = altsrc.NewIntFlag(f)
+		case *cli.Int64Flag:
+			flag = altsrc.NewInt64Flag(f)
+		case *cli.UintFlag:
+			flag = altsrc.NewUintFlag(f)
+		case *cli.Uint64Flag:
+			flag = altsrc.NewUint64Flag(f)
+		case *cli.StringFlag:
+			if f.Name != "config" {
+				flag = altsrc.NewStringFlag(f)
+			}
+		case *cli.StringSliceFlag:
+			flag = altsrc.NewStringSliceFlag(f)
+		}
+		wrapped = append(wrapped, flag)
+	}
+	return wrapped
+}
+
+func isReloadableDaemonFlag(name string) bool {
+	for _, reloadable := range reloadableDaemonFlags {
+		if name == reloadable {
+			return true
+		}
+	}
+	return false
+}
+
+// loadDaemonConfig sets the flags that aren't given on the command line or in
+// environment variables from the --config file, if any, except for the
+// reloadableDaemonFlags, which are read by loadDaemonSettings.
+func loadDaemonConfig(cctx *cli.Context) error {
+	path := cctx.String("config")
+	if path == "" {
+		return nil
+	}
+	source, err := readDaemonConfig(path, cctx.Command.Flags)
+	if err != nil {
+		return err
+	}
+	if err := altsrc.ApplyInputSourceValues(cctx, source, cctx.Command.Flags); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readDaemonConfig reads a --config file. The file maps flag names to their
+// values, lists for flags that may be repeated, and any other key is an error
+// so that typos don't go unnoticed.
+func readDaemonConfig(path string, flags []cli.Flag) (altsrc.InputSourceContext, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	var source altsrc.InputSourceContext
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &values); err == nil {
+			source, err = altsrc.NewYamlSourceFromFile(path)
+		}
+	case ".toml":
+		if err = toml.Unmarshal(data, &values); err == nil {
+			source, err = altsrc.NewTomlSourceFromFile(path)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	configurable := make(map[string]struct{})
+	for _, name := range reloadableDaemonFlags {
+		configurable[name] = struct{}{}
+	}
+	for _, flag := range flags {
+		if _, ok := flag.(altsrc.FlagInputSourceExtension); ok {
+			for _, name := range flag.Names() {
+				configurable[name] = struct{}{}
+			}
+		}
+	}
+	for key := range values {
+		if _, ok := configurable[key]; !ok {
+			return nil, fmt.Errorf("invalid config file %s: unknown flag %q", path, key)
+		}
+	}
+	return source, nil
+}
+
+// daemonSettings are the settings of the reloadableDaemonFlags.
+type daemonSettings struct {
+	globalTimeout time.Duration
+	logLevels     map[string]logging.Level
+	rateLimits    httpserver.RateLimits
+}
+
+// loadDaemonSettings reads the reloadableDaemonFlags from the command line and
+// environment variables or, for those given in neither, from the --config
+// file, which is read again on every call.
+func loadDaemonSettings(cctx *cli.Context) (daemonSettings, error) {
+	var source altsrc.InputSourceContext
+	if path := cctx.String("config"); path != "" {
+		var err error
+		if source, err = readDaemonConfig(path, cctx.Command.Flags); err != nil {
+			return daemonSettings{}, err
+		}
+	}
+	fromConfig := func(name string) bool {
+		return source != nil && !cctx.IsSet(name)
+	}
+
+	var settings daemonSettings
+	var err error
+	settings.globalTimeout = cctx.Duration("global-timeout")
+	if fromConfig("global-timeout") {
+		if settings.globalTimeout, err = source.Duration("global-timeout"); err != nil {
+			return settings, fmt.Errorf("invalid config file %s: %w", cctx.String("config"), err)
+		}
+	}
+	logLevels := cctx.StringSlice("log-level")
+	if fromConfig("log-level") {
+		if logLevels, err = source.StringSlice("log-level"); err != nil {
+			return settings, fmt.Errorf("invalid config file %s: %w", cctx.String("config"), err)
+		}
+	}
+	if settings.logLevels, err = parseLogLevels(logLevels); err != nil {
+		return settings, err
+	}
+	var sourceErr error
+	settings.rateLimits, err = newRateLimits(func(name string) string {
+		if !fromConfig(name) {
+			return cctx.String(name)
+		}
+		value, err := source.String(name)
+		if err != nil && sourceErr == nil {
+			sourceErr = fmt.Errorf("invalid config file %s: %w", cctx.String("config"), err)
+		}
+		return value
+	})
+	if sourceErr != nil {
+		return settings, sourceErr
+	}
+	return settings, err
+}
+
+// parseLogLevels parses the values of --log-level, returning the levels by
+// subsystem, under "" for a level that applies to all of lassie's logs.
+func parseLogLevels(values []string) (map[string]logging.Level, error) {
+	levels := make(map[string]logging.Level, len(values))
+	for _, value := range values {
+		subsystem, name, ok := strings.Cut(value, "=")
+		if !ok {
+			subsystem, name = "", value
+		}
+		level, err := logging.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --log-level %q, expected level or subsystem=level: %w", value, err)
+		}
+		levels[subsystem] = level
+	}
+	return levels, nil
+}
+
+// logLevels applies the levels parsed by parseLogLevels at startup and on
+// each reload, those of single subsystems taking precedence over the level of
+// all of lassie's logs.
+type logLevels struct {
+	// verbose is the level of lassie's logs set with --verbose or
+	// --very-verbose, if any
+	verbose string
+	applied map[string]logging.Level
+}
+
+func newLogLevels(cctx *cli.Context) *logLevels {
+	return &logLevels{verbose: verboseLevel(cctx)}
+}
+
+// set applies levels, first resetting the subsystems named by the levels
+// applied before to their level at startup, so that those no longer named
+// don't keep their previous level.
+func (ll *logLevels) set(levels map[string]logging.Level) {
+	for subsystem := range ll.applied {
+		if subsystem == "" {
+			for _, subsystem := range verboseLoggingSubsystems {
+				ll.reset(subsystem)
+			}
+		} else {
+			ll.reset(subsystem)
+		}
+	}
+	if level, ok := levels[""]; ok {
+		for _, subsystem := range verboseLoggingSubsystems {
+			logging.SetLevel(subsystem, level)
+		}
+	}
+	for subsystem, level := range levels {
+		if subsystem != "" {
+			logging.SetLevel(subsystem, level)
+		}
+	}
+	ll.applied = levels
+}
+
+// reset restores the level of a subsystem at startup: that of --verbose or
+// --very-verbose for lassie's subsystems, or go-log's configuration.
+func (ll *logLevels) reset(subsystem string) {
+	logging.UnsetLevel(subsystem)
+	if ll.verbose == "" {
+		return
+	}
+	for _, name := range verboseLoggingSubsystems {
+		if name == subsystem {
+			_ = log.SetLogLevel(subsystem, ll.verbose)
+		}
 	}
 }
@@ -2,21 +2,79 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	a "github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	"github.com/filecoin-project/lassie/pkg/eventwebhook"
 	"github.com/filecoin-project/lassie/pkg/indexerlookup"
 	l "github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/filecoin-project/lassie/pkg/retriever"
 	h "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/config"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestDaemonCommandFlags(t *testing.T) {
+	identityPath := filepath.Join(t.TempDir(), "identity")
+	identity, err := host.LoadOrCreateIdentity(identityPath)
+	require.NoError(t, err)
+	newIdentityPath := filepath.Join(t.TempDir(), "identity")
+	invalidIdentityPath := filepath.Join(t.TempDir(), "identity")
+	require.NoError(t, os.WriteFile(invalidIdentityPath, []byte("not a key"), 0600))
+	reputationDir := t.TempDir()
+	resultsDir := t.TempDir()
+	cacheDir := t.TempDir()
+	regionTablePath := filepath.Join(t.TempDir(), "regions.csv")
+	require.NoError(t, os.WriteFile(regionTablePath, []byte("203.0.113.0/24,eu-west\n"), 0644))
+	providerConfigPath := filepath.Join(t.TempDir(), "providers.json")
+	require.NoError(t, os.WriteFile(providerConfigPath, []byte(`{"protocols": {"graphsync": {"retrievalTimeout": "1m"}}}`), 0644))
+	invalidProviderConfigPath := filepath.Join(t.TempDir(), "providers.json")
+	require.NoError(t, os.WriteFile(invalidProviderConfigPath, []byte(`{"protocols": {"ftp": {}}}`), 0644))
+	jwtSecretPath := filepath.Join(t.TempDir(), "jwt-secret")
+	require.NoError(t, os.WriteFile(jwtSecretPath, []byte("secret\n"), 0600))
+	jwtPublicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	jwtPublicKeyDer, err := x509.MarshalPKIXPublicKey(jwtPublicKey)
+	require.NoError(t, err)
+	jwtPublicKeyPath := filepath.Join(t.TempDir(), "jwt.pem")
+	require.NoError(t, os.WriteFile(jwtPublicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: jwtPublicKeyDer}), 0644))
+	rateLimitConfigPath := filepath.Join(t.TempDir(), "ratelimits.json")
+	require.NoError(t, os.WriteFile(rateLimitConfigPath, []byte(`{"ip": {"requestsPerSecond": 5, "requestBurst": 20}, "tokens": {"trusted": {}}}`), 0644))
+	apiKeysPath := filepath.Join(t.TempDir(), "apikeys.json")
+	require.NoError(t, os.WriteFile(apiKeysPath, []byte(`[{"name": "acme", "key": "acme-key", "rateLimit": {"requestsPerSecond": 10}, "quota": {"requests": 1000, "period": "24h"}}]`), 0644))
+	yamlConfigPath := filepath.Join(t.TempDir(), "lassie.yaml")
+	require.NoError(t, os.WriteFile(yamlConfigPath, []byte("port: 8080\nprotocols: bitswap,graphsync\naccess-token:\n  - old-secret\n  - new-secret\nmax-concurrent-retrievals: 10\ndrain-timeout: 1m\nadmin: true\n"), 0644))
+	tomlConfigPath := filepath.Join(t.TempDir(), "lassie.toml")
+	require.NoError(t, os.WriteFile(tomlConfigPath, []byte("port = 8080\nprotocols = \"http\"\naccess-token = [\"secret\"]\nipni-endpoint = \"https://cid.contact\"\nhttp-rate-limit = 2.5\n"), 0644))
+	unknownConfigPath := filepath.Join(t.TempDir(), "lassie.yaml")
+	require.NoError(t, os.WriteFile(unknownConfigPath, []byte("prot: 8080\n"), 0644))
+	invalidConfigPath := filepath.Join(t.TempDir(), "lassie.yaml")
+	require.NoError(t, os.WriteFile(invalidConfigPath, []byte("port: eighty\n"), 0644))
+	reloadableConfigPath := filepath.Join(t.TempDir(), "lassie.yaml")
+	require.NoError(t, os.WriteFile(reloadableConfigPath, []byte("global-timeout: 30s\nrate-limit-ip: \"5:20\"\nlog-level:\n  - lassie/retriever=info\n"), 0644))
+	logLevelsConfigPath := filepath.Join(t.TempDir(), "lassie.yaml")
+	require.NoError(t, os.WriteFile(logLevelsConfigPath, []byte("log-level:\n  - warn\n  - lassie/bitswap=error\n  - bitswap=info\n"), 0644))
+	jsonConfigPath := filepath.Join(t.TempDir(), "lassie.json")
+	require.NoError(t, os.WriteFile(jsonConfigPath, []byte(`{"port": 8080}`), 0644))
+
 	tests := []struct {
 		name        string
 		args        []string
@@ -26,10 +84,10 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with default args",
 			args: []string{"daemon"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				// lassie config
 				require.Equal(t, nil, lCfg.Finder)
-				require.NotNil(t, lCfg.Host, "host should not be nil")
+				require.Nil(t, lCfg.Host, "host should be started lazily")
 				require.Equal(t, 20*time.Second, lCfg.ProviderTimeout)
 				require.Equal(t, uint(0), lCfg.ConcurrentSPRetrievals)
 				require.Equal(t, 0*time.Second, lCfg.GlobalTimeout)
@@ -39,12 +97,51 @@ func TestDaemonCommandFlags(t *testing.T) {
 				require.Equal(t, 0, len(lCfg.ProviderAllowList))
 				require.Equal(t, 32, lCfg.BitswapConcurrency)
 				require.Equal(t, 12, lCfg.BitswapConcurrencyPerRetrieval)
+				require.Equal(t, uint64(2<<20), lCfg.MaxBlockSize)
+				require.Equal(t, types.ProviderQueryLimits{}, lCfg.ProviderQueryLimits)
+				require.Equal(t, retriever.HttpRateLimits{}, lCfg.HttpRateLimits)
+				require.Equal(t, retriever.HttpPrewarm{}, lCfg.HttpPrewarm)
+				require.Equal(t, time.Duration(0), lCfg.TelemetryInterval)
 
 				// http server config
 				require.Equal(t, "127.0.0.1", hCfg.Address)
 				require.Equal(t, uint(0), hCfg.Port)
 				require.Equal(t, uint64(0), hCfg.MaxBlocksPerRequest)
-				require.Equal(t, "", hCfg.AccessToken)
+				require.Empty(t, hCfg.AccessTokens)
+				require.Nil(t, hCfg.JWT)
+				require.Equal(t, h.RateLimits{}, hCfg.RateLimits)
+				require.Nil(t, hCfg.CORS)
+				require.Equal(t, uint(0), hCfg.MaxConcurrentRequests)
+				require.Equal(t, uint64(0), hCfg.MinTempSpace)
+				require.Equal(t, uint(0), hCfg.MaxConcurrentRetrievals)
+				require.Equal(t, uint(0), hCfg.MaxQueuedRetrievals)
+				require.Equal(t, 30*time.Second, hCfg.QueueTimeout)
+				require.Equal(t, 30*time.Second, hCfg.DrainTimeout)
+				require.Nil(t, hCfg.ResponseCache)
+				require.Nil(t, hCfg.AccessLog)
+				require.Nil(t, hCfg.TLS)
+				require.False(t, hCfg.EnableAdmin)
+				require.False(t, hCfg.InMemory)
+				require.False(t, lCfg.InMemory)
+				require.NotNil(t, hCfg.Metrics)
+				require.Equal(t, hCfg.Metrics, lCfg.MetricsRegisterer)
+				require.NotNil(t, hCfg.IpnsResolver)
+				require.Equal(t, time.Minute, hCfg.IpnsMaxAge)
+				require.Equal(t, time.Hour, hCfg.IpnsMaxStale)
+				require.Nil(t, lCfg.EventWebhook)
+				require.Nil(t, lCfg.AlertWebhook)
+				require.Nil(t, lCfg.ScoringWeights)
+				require.Nil(t, lCfg.LatencyProbe)
+				require.Nil(t, lCfg.ResultStore)
+				require.Equal(t, "", lCfg.Region)
+				require.Nil(t, lCfg.RegionLocator)
+				require.Equal(t, time.Duration(0), lCfg.CapabilityTTL)
+				require.Nil(t, lCfg.CircuitBreaker)
+				require.Equal(t, retriever.RetryPolicies{}, lCfg.RetryPolicies)
+				require.Equal(t, time.Duration(0), lCfg.BitswapSessionIdleTimeout)
+				require.Nil(t, lCfg.ProviderListSources)
+				require.Nil(t, lCfg.ProviderBlockedNetworks)
+				require.Equal(t, l.ProviderConfigs{}, lCfg.ProviderConfigs)
 
 				// event recorder config
 				require.Equal(t, "", erCfg.EndpointURL)
@@ -56,9 +153,12 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with libp2p low and high connection thresholds and concurrent sp retrievals",
 			args: []string{"daemon", "--libp2p-conns-lowwater", "10", "--libp2p-conns-highwater", "20"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
-				require.NotNil(t, lCfg.Host, "host should not be nil")
-				cmgr, ok := lCfg.Host.ConnManager().(*connmgr.BasicConnMgr)
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Nil(t, lCfg.Host, "host should be started lazily")
+				require.Len(t, lCfg.Libp2pOptions, 1)
+				var libp2pCfg config.Config
+				require.NoError(t, libp2pCfg.Apply(lCfg.Libp2pOptions...))
+				cmgr, ok := libp2pCfg.ConnManager.(*connmgr.BasicConnMgr)
 				require.True(t, ok)
 				cmInfo := cmgr.GetInfo()
 				require.Equal(t, cmInfo.LowWater, 10)
@@ -69,15 +169,52 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with concurrent sp retrievals",
 			args: []string{"daemon", "--concurrent-sp-retrievals", "10"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, uint(10), lCfg.ConcurrentSPRetrievals)
 				return nil
 			},
 		},
+		{
+			name: "with telemetry interval",
+			args: []string{"daemon", "--telemetry-interval", "30s"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, 30*time.Second, lCfg.TelemetryInterval)
+				return nil
+			},
+		},
+		{
+			name: "with identity",
+			args: []string{"daemon", "--identity", identityPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Nil(t, lCfg.Host, "host should be started lazily")
+				require.Len(t, lCfg.Libp2pOptions, 1)
+				var libp2pCfg config.Config
+				require.NoError(t, libp2pCfg.Apply(lCfg.Libp2pOptions...))
+				require.True(t, identity.Equals(libp2pCfg.PeerKey))
+				return nil
+			},
+		},
+		{
+			name: "with new identity",
+			args: []string{"daemon", "--identity", newIdentityPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				key, err := host.LoadIdentity(newIdentityPath)
+				require.NoError(t, err)
+				var libp2pCfg config.Config
+				require.NoError(t, libp2pCfg.Apply(lCfg.Libp2pOptions...))
+				require.True(t, key.Equals(libp2pCfg.PeerKey))
+				return nil
+			},
+		},
+		{
+			name:        "with invalid identity",
+			args:        []string{"daemon", "--identity", invalidIdentityPath},
+			shouldError: true,
+		},
 		{
 			name: "with temp directory",
 			args: []string{"daemon", "--tempdir", "/mytmpdir"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, "/mytmpdir", hCfg.TempDir)
 				return nil
 			},
@@ -85,7 +222,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with provider timeout",
 			args: []string{"daemon", "--provider-timeout", "30s"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, 30*time.Second, lCfg.ProviderTimeout)
 				return nil
 			},
@@ -93,7 +230,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with global timeout",
 			args: []string{"daemon", "--global-timeout", "30s"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, 30*time.Second, lCfg.GlobalTimeout)
 				return nil
 			},
@@ -101,7 +238,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with protocols",
 			args: []string{"daemon", "--protocols", "bitswap,graphsync"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1}, lCfg.Protocols)
 				return nil
 			},
@@ -109,7 +246,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with exclude providers",
 			args: []string{"daemon", "--exclude-providers", "12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4,12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				p1, err := peer.Decode("12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4")
 				require.NoError(t, err)
 				p2, err := peer.Decode("12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys")
@@ -123,15 +260,255 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with bitswap concurrency",
 			args: []string{"daemon", "--bitswap-concurrency", "10"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, 10, lCfg.BitswapConcurrency)
 				return nil
 			},
 		},
+		{
+			name: "with bitswap session pool",
+			args: []string{"daemon", "--bitswap-session-idle-timeout", "30s"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, 30*time.Second, lCfg.BitswapSessionIdleTimeout)
+				return nil
+			},
+		},
+		{
+			name: "with bitswap preload buffer",
+			args: []string{"daemon", "--bitswap-preload-max-bytes", "64MiB", "--bitswap-preload-spill-dir", "/tmp/lassie-preload"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint64(64<<20), lCfg.BitswapPreloadMaxBytes)
+				require.Equal(t, "/tmp/lassie-preload", lCfg.BitswapPreloadSpillDir)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid bitswap preload max bytes",
+			args:        []string{"daemon", "--bitswap-preload-max-bytes", "lots"},
+			shouldError: true,
+		},
+		{
+			name:        "with bitswap preload spill dir without max bytes",
+			args:        []string{"daemon", "--bitswap-preload-spill-dir", "/tmp/lassie-preload"},
+			shouldError: true,
+		},
+		{
+			name:        "with bitswap preload spill dir in memory",
+			args:        []string{"daemon", "--bitswap-preload-max-bytes", "64MiB", "--bitswap-preload-spill-dir", "/tmp/lassie-preload", "--in-memory"},
+			shouldError: true,
+		},
+		{
+			name: "with max block size",
+			args: []string{"daemon", "--max-block-size", "1048576"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint64(1<<20), lCfg.MaxBlockSize)
+				return nil
+			},
+		},
+		{
+			name: "with provider query limits",
+			args: []string{"daemon", "--max-candidates", "100", "--max-graphsync-queries", "5", "--max-http-queries", "10"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, types.ProviderQueryLimits{MaxCandidates: 100, MaxGraphsyncQueries: 5, MaxHttpQueries: 10}, lCfg.ProviderQueryLimits)
+				return nil
+			},
+		},
+		{
+			name: "with http rate limits",
+			args: []string{
+				"daemon",
+				"--http-rate-limit", "2.5",
+				"--http-rate-limit-burst", "4",
+				"--http-host-rate-limit", "example.com=10",
+				"--http-host-rate-limit", "127.0.0.1:8080=0.5:2",
+			},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, retriever.HttpRateLimits{
+					Default: retriever.HttpRateLimit{RequestsPerSecond: 2.5, Burst: 4},
+					Hosts: map[string]retriever.HttpRateLimit{
+						"example.com":    {RequestsPerSecond: 10, Burst: 1},
+						"127.0.0.1:8080": {RequestsPerSecond: 0.5, Burst: 2},
+					},
+				}, lCfg.HttpRateLimits)
+				return nil
+			},
+		},
+		{
+			name: "with http prewarm",
+			args: []string{"daemon", "--http-prewarm", "2", "--http-prewarm-min-latency", "100ms"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, retriever.HttpPrewarm{Requests: 2, MinLatency: 100 * time.Millisecond}, lCfg.HttpPrewarm)
+				return nil
+			},
+		},
+		{
+			name: "with latency probes",
+			args: []string{"daemon", "--latency-probes", "4", "--latency-probe-timeout", "500ms"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &retriever.LatencyProbe{MaxProbes: 4, Timeout: 500 * time.Millisecond}, lCfg.LatencyProbe)
+				return nil
+			},
+		},
+		{
+			name: "with region",
+			args: []string{"daemon", "--region", "eu-west", "--region-table", regionTablePath, "--scoring-weight", "region=2"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, "eu-west", lCfg.Region)
+				require.IsType(t, &retriever.RegionTable{}, lCfg.RegionLocator)
+				require.Equal(t, 2.0, lCfg.ScoringWeights.Region)
+				return nil
+			},
+		},
+		{
+			name: "with circuit breaker",
+			args: []string{"daemon", "--circuit-breaker-threshold", "3", "--circuit-breaker-cooldown", "1m"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				expected := session.DefaultCircuitBreaker()
+				expected.Threshold = 3
+				expected.Cooldown = time.Minute
+				require.Equal(t, &expected, lCfg.CircuitBreaker)
+				return nil
+			},
+		},
+		{
+			name: "with circuit breaker disabled",
+			args: []string{"daemon", "--circuit-breaker-threshold", "0"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Zero(t, lCfg.CircuitBreaker.Threshold)
+				return nil
+			},
+		},
+		{
+			name: "with retries",
+			args: []string{
+				"daemon",
+				"--retries", "2",
+				"--retry-max-backoff", "10s",
+				"--retry-override", "http=4:500ms",
+				"--retry-override", "12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4=0",
+			},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				p, err := peer.Decode("12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4")
+				require.NoError(t, err)
+				policy := retriever.RetryPolicy{MaxRetries: 2, Backoff: time.Second, MaxBackoff: 10 * time.Second, Jitter: 0.2}
+				httpPolicy := policy
+				httpPolicy.MaxRetries = 4
+				httpPolicy.Backoff = 500 * time.Millisecond
+				providerPolicy := policy
+				providerPolicy.MaxRetries = 0
+				require.Equal(t, retriever.RetryPolicies{
+					Default:   policy,
+					Protocols: map[multicodec.Code]retriever.RetryPolicy{multicodec.TransportIpfsGatewayHttp: httpPolicy},
+					Providers: map[peer.ID]retriever.RetryPolicy{p: providerPolicy},
+				}, lCfg.RetryPolicies)
+				return nil
+			},
+		},
+		{
+			name: "with excluded networks",
+			args: []string{"daemon", "--exclude-networks", "192.0.2.0/24, 2001:db8::/32,198.51.100.7,203.0.113.9/24"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, []netip.Prefix{
+					netip.MustParsePrefix("192.0.2.0/24"),
+					netip.MustParsePrefix("2001:db8::/32"),
+					netip.MustParsePrefix("198.51.100.7/32"),
+					netip.MustParsePrefix("203.0.113.0/24"),
+				}, lCfg.ProviderBlockedNetworks)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid excluded network",
+			args:        []string{"daemon", "--exclude-networks", "192.0.2.0/33"},
+			shouldError: true,
+		},
+		{
+			name: "with provider lists",
+			args: []string{
+				"daemon",
+				"--provider-block-list", "/etc/lassie/blocked",
+				"--provider-allow-list", "https://example.com/allowed",
+				"--provider-list-refresh", "5m",
+			},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &l.ProviderListSources{
+					BlockList:       "/etc/lassie/blocked",
+					AllowList:       "https://example.com/allowed",
+					RefreshInterval: 5 * time.Minute,
+				}, lCfg.ProviderListSources)
+				return nil
+			},
+		},
+		{
+			name: "with provider config",
+			args: []string{"daemon", "--provider-config", providerConfigPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, l.ProviderConfigs{
+					Protocols: map[multicodec.Code]session.ProviderConfig{
+						multicodec.TransportGraphsyncFilecoinv1: {RetrievalTimeout: time.Minute},
+					},
+				}, lCfg.ProviderConfigs)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid provider config",
+			args:        []string{"daemon", "--provider-config", invalidProviderConfigPath},
+			shouldError: true,
+		},
+		{
+			name:        "with missing provider config",
+			args:        []string{"daemon", "--provider-config", filepath.Join(t.TempDir(), "missing.json")},
+			shouldError: true,
+		},
+		{
+			name:        "with bitswap retry override",
+			args:        []string{"daemon", "--retry-override", "bitswap=2"},
+			shouldError: true,
+		},
+		{
+			name: "with capability ttl",
+			args: []string{"daemon", "--capability-ttl", "-1s"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, -time.Second, lCfg.CapabilityTTL)
+				return nil
+			},
+		},
+		{
+			name:        "with region table without region",
+			args:        []string{"daemon", "--region-table", regionTablePath},
+			shouldError: true,
+		},
+		{
+			name: "with scoring weights",
+			args: []string{"daemon", "--scoring-weight", "bandwidth=2", "--scoring-weight", "graphsync-verified-deal=0"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				expected := session.DefaultScoringWeights()
+				expected.Bandwidth = 2
+				expected.GraphsyncVerifiedDeal = 0
+				require.Equal(t, &expected, lCfg.ScoringWeights)
+				return nil
+			},
+		},
+		{
+			name:        "with unknown scoring weight",
+			args:        []string{"daemon", "--scoring-weight", "latency=2"},
+			shouldError: true,
+		},
+		{
+			name:        "with negative scoring weight",
+			args:        []string{"daemon", "--scoring-weight", "success=-1"},
+			shouldError: true,
+		},
+		{
+			name:        "with bad http host rate limit",
+			args:        []string{"daemon", "--http-host-rate-limit", "example.com"},
+			shouldError: true,
+		},
 		{
 			name: "with address",
 			args: []string{"daemon", "--address", "0.0.0.0"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, "0.0.0.0", hCfg.Address)
 				return nil
 			},
@@ -139,7 +516,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with port",
 			args: []string{"daemon", "--port", "1234"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, uint(1234), hCfg.Port)
 				return nil
 			},
@@ -147,15 +524,235 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with max blocks",
 			args: []string{"daemon", "--maxblocks", "10"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, uint64(10), hCfg.MaxBlocksPerRequest)
 				return nil
 			},
 		},
+		{
+			name: "with max concurrent requests",
+			args: []string{"daemon", "--max-concurrent-requests", "100"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint(100), hCfg.MaxConcurrentRequests)
+				return nil
+			},
+		},
+		{
+			name: "with tls certificate",
+			args: []string{"daemon", "--tls-cert", "cert.pem", "--tls-key", "key.pem"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &h.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, hCfg.TLS)
+				return nil
+			},
+		},
+		{
+			name:        "with tls certificate without key",
+			args:        []string{"daemon", "--tls-cert", "cert.pem"},
+			shouldError: true,
+		},
+		{
+			name: "with tls acme",
+			args: []string{"daemon", "--tls-acme-domain", "a.example.com", "--tls-acme-domain", "b.example.com", "--tls-acme-cache-dir", cacheDir, "--tls-acme-email", "ops@example.com", "--tls-acme-http-address", ":80"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &h.TLSConfig{
+					ACMEDomains:     []string{"a.example.com", "b.example.com"},
+					ACMECacheDir:    cacheDir,
+					ACMEEmail:       "ops@example.com",
+					ACMEHTTPAddress: ":80",
+				}, hCfg.TLS)
+				return nil
+			},
+		},
+		{
+			name:        "with tls acme without cache dir",
+			args:        []string{"daemon", "--tls-acme-domain", "a.example.com"},
+			shouldError: true,
+		},
+		{
+			name:        "with tls acme and certificate",
+			args:        []string{"daemon", "--tls-acme-domain", "a.example.com", "--tls-acme-cache-dir", cacheDir, "--tls-cert", "cert.pem", "--tls-key", "key.pem"},
+			shouldError: true,
+		},
+		{
+			name:        "with tls acme option without domain",
+			args:        []string{"daemon", "--tls-acme-email", "ops@example.com"},
+			shouldError: true,
+		},
+		{
+			name:        "with tls acme cache dir in memory",
+			args:        []string{"daemon", "--tls-acme-domain", "a.example.com", "--tls-acme-cache-dir", cacheDir, "--in-memory"},
+			shouldError: true,
+		},
+		{
+			name: "with access log file",
+			args: []string{"daemon", "--access-log", filepath.Join(cacheDir, "access.log"), "--access-log-max-size", "1MiB", "--access-log-max-backups", "2"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, hCfg.AccessLog)
+				require.FileExists(t, filepath.Join(cacheDir, "access.log"))
+				return nil
+			},
+		},
+		{
+			name: "with access log to stdout in memory",
+			args: []string{"daemon", "--access-log", "-", "--in-memory"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, hCfg.AccessLog)
+				return nil
+			},
+		},
+		{
+			name:        "with access log file in memory",
+			args:        []string{"daemon", "--access-log", filepath.Join(cacheDir, "access.log"), "--in-memory"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid access log max size",
+			args:        []string{"daemon", "--access-log", filepath.Join(cacheDir, "access.log"), "--access-log-max-size", "lots"},
+			shouldError: true,
+		},
+		{
+			name: "with response cache",
+			args: []string{"daemon", "--cache-dir", cacheDir, "--cache-size", "1GiB"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, hCfg.ResponseCache)
+				require.Equal(t, 0, hCfg.ResponseCache.Len())
+				return nil
+			},
+		},
+		{
+			name:        "with cache dir without size",
+			args:        []string{"daemon", "--cache-dir", cacheDir},
+			shouldError: true,
+		},
+		{
+			name:        "with cache size without dir",
+			args:        []string{"daemon", "--cache-size", "1GiB"},
+			shouldError: true,
+		},
+		{
+			name:        "with cache dir in memory",
+			args:        []string{"daemon", "--cache-dir", cacheDir, "--cache-size", "1GiB", "--in-memory"},
+			shouldError: true,
+		},
+		{
+			name: "with temp quota",
+			args: []string{"daemon", "--temp-quota", "10GiB", "--temp-quota-timeout", "5s"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, hCfg.TempQuota)
+				require.Equal(t, uint64(10<<30), hCfg.TempQuota.Max())
+				require.Equal(t, uint64(9<<30), hCfg.TempQuota.HighWater())
+				require.Equal(t, 5*time.Second, hCfg.TempQuotaTimeout)
+				return nil
+			},
+		},
+		{
+			name: "with temp quota high water",
+			args: []string{"daemon", "--temp-quota", "10GiB", "--temp-quota-high-water", "8GiB", "--in-memory"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint64(8<<30), hCfg.TempQuota.HighWater())
+				require.Zero(t, hCfg.TempQuotaTimeout)
+				return nil
+			},
+		},
+		{
+			name:        "with temp quota high water above quota",
+			args:        []string{"daemon", "--temp-quota", "10GiB", "--temp-quota-high-water", "11GiB"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid temp quota",
+			args:        []string{"daemon", "--temp-quota", "lots"},
+			shouldError: true,
+		},
+		{
+			name:        "with temp quota timeout without quota",
+			args:        []string{"daemon", "--temp-quota-timeout", "5s"},
+			shouldError: true,
+		},
+		{
+			name: "with block cache",
+			args: []string{"daemon", "--block-cache-dir", cacheDir, "--block-cache-size", "1GiB"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, lCfg.BlockCache)
+				require.Equal(t, 0, lCfg.BlockCache.Len())
+				return nil
+			},
+		},
+		{
+			name:        "with block cache dir without size",
+			args:        []string{"daemon", "--block-cache-dir", cacheDir},
+			shouldError: true,
+		},
+		{
+			name:        "with block cache size without dir",
+			args:        []string{"daemon", "--block-cache-size", "1GiB"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid block cache size",
+			args:        []string{"daemon", "--block-cache-dir", cacheDir, "--block-cache-size", "lots"},
+			shouldError: true,
+		},
+		{
+			name:        "with block cache dir in memory",
+			args:        []string{"daemon", "--block-cache-dir", cacheDir, "--block-cache-size", "1GiB", "--in-memory"},
+			shouldError: true,
+		},
+		{
+			name: "with min temp space",
+			args: []string{"daemon", "--min-temp-space", "10GiB"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint64(10<<30), hCfg.MinTempSpace)
+				return nil
+			},
+		},
+		{
+			name: "with max concurrent retrievals",
+			args: []string{"daemon", "--max-concurrent-retrievals", "50", "--max-queued-retrievals", "200", "--queue-timeout", "10s"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint(50), hCfg.MaxConcurrentRetrievals)
+				require.Equal(t, uint(200), hCfg.MaxQueuedRetrievals)
+				require.Equal(t, 10*time.Second, hCfg.QueueTimeout)
+				return nil
+			},
+		},
+		{
+			name:        "with max queued retrievals without max concurrent retrievals",
+			args:        []string{"daemon", "--max-queued-retrievals", "200"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid min temp space",
+			args:        []string{"daemon", "--min-temp-space", "lots"},
+			shouldError: true,
+		},
+		{
+			name:        "with min temp space in memory",
+			args:        []string{"daemon", "--min-temp-space", "10GiB", "--in-memory"},
+			shouldError: true,
+		},
+		{
+			name: "with in-memory threshold",
+			args: []string{"daemon", "--in-memory-threshold", "4MiB"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint64(4<<20), hCfg.InMemoryThreshold)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid in-memory threshold",
+			args:        []string{"daemon", "--in-memory-threshold", "lots"},
+			shouldError: true,
+		},
+		{
+			name:        "with in-memory threshold in memory",
+			args:        []string{"daemon", "--in-memory-threshold", "4MiB", "--in-memory"},
+			shouldError: true,
+		},
 		{
 			name: "with ipni endpoint",
 			args: []string{"daemon", "--ipni-endpoint", "https://cid.contact"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.IsType(t, &indexerlookup.IndexerCandidateFinder{}, lCfg.Finder, "finder should be an IndexerCandidateFinder when providing an ipni endpoint")
 				return nil
 			},
@@ -168,7 +765,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with event recorder url",
 			args: []string{"daemon", "--event-recorder-url", "https://myeventrecorder.com/v1/retrieval-events"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, "https://myeventrecorder.com/v1/retrieval-events", erCfg.EndpointURL)
 				return nil
 			},
@@ -176,7 +773,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with event recorder auth",
 			args: []string{"daemon", "--event-recorder-auth", "secret"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, "secret", erCfg.EndpointAuthorization)
 				return nil
 			},
@@ -184,7 +781,7 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with event recorder instance ID",
 			args: []string{"daemon", "--event-recorder-instance-id", "myinstanceid"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 				require.Equal(t, "myinstanceid", erCfg.InstanceID)
 				return nil
 			},
@@ -192,11 +789,427 @@ func TestDaemonCommandFlags(t *testing.T) {
 		{
 			name: "with access token",
 			args: []string{"daemon", "--access-token", "super-secret"},
-			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
-				require.Equal(t, "super-secret", hCfg.AccessToken)
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, []string{"super-secret"}, hCfg.AccessTokens)
+				return nil
+			},
+		},
+		{
+			name: "with access tokens",
+			args: []string{"daemon", "--access-token", "old-secret", "--access-token", "new-secret"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, []string{"old-secret", "new-secret"}, hCfg.AccessTokens)
+				return nil
+			},
+		},
+		{
+			name: "with jwt secret",
+			args: []string{"daemon", "--jwt-secret-file", jwtSecretPath, "--jwt-issuer", "auth.example.com", "--jwt-audience", "lassie", "--jwt-claim", "scope=retrieve", "--jwt-leeway", "30s"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &h.JWTConfig{
+					Secret:   []byte("secret"),
+					Issuer:   "auth.example.com",
+					Audience: "lassie",
+					Claims:   map[string]string{"scope": "retrieve"},
+					Leeway:   30 * time.Second,
+				}, hCfg.JWT)
+				return nil
+			},
+		},
+		{
+			name: "with jwt public key",
+			args: []string{"daemon", "--jwt-public-key-file", jwtPublicKeyPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &h.JWTConfig{PublicKey: jwtPublicKey}, hCfg.JWT)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid jwt public key",
+			args:        []string{"daemon", "--jwt-public-key-file", jwtSecretPath},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid jwt claim",
+			args:        []string{"daemon", "--jwt-secret-file", jwtSecretPath, "--jwt-claim", "scope"},
+			shouldError: true,
+		},
+		{
+			name:        "with jwt claim without key",
+			args:        []string{"daemon", "--jwt-claim", "scope=retrieve"},
+			shouldError: true,
+		},
+		{
+			name: "with cors",
+			args: []string{"daemon", "--cors-origin", "https://app.example.com", "--cors-origin", "https://*.example.org", "--cors-method", "get", "--cors-method", "delete", "--cors-header", "*", "--cors-max-age", "1h"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &h.CORSConfig{
+					AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+					AllowedMethods: []string{"GET", "DELETE"},
+					AllowedHeaders: []string{"*"},
+					MaxAge:         time.Hour,
+				}, hCfg.CORS)
+				return nil
+			},
+		},
+		{
+			name:        "with cors method without origin",
+			args:        []string{"daemon", "--cors-method", "GET"},
+			shouldError: true,
+		},
+		{
+			name: "with rate limits",
+			args: []string{"daemon", "--rate-limit-ip", "5:20", "--rate-limit-ip-bandwidth", "10MiB:50MiB", "--rate-limit-token", "0.5", "--rate-limit-token-bandwidth", "1MB"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, h.RateLimits{
+					IP:    h.RateLimit{RequestsPerSecond: 5, RequestBurst: 20, BytesPerSecond: 10 << 20, ByteBurst: 50 << 20},
+					Token: h.RateLimit{RequestsPerSecond: 0.5, BytesPerSecond: 1000000},
+				}, hCfg.RateLimits)
+				return nil
+			},
+		},
+		{
+			name: "with rate limit config",
+			args: []string{"daemon", "--rate-limit-config", rateLimitConfigPath, "--rate-limit-ip", "10"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, h.RateLimits{
+					IP:     h.RateLimit{RequestsPerSecond: 10},
+					Tokens: map[string]h.RateLimit{"trusted": {}},
+				}, hCfg.RateLimits)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid rate limit",
+			args:        []string{"daemon", "--rate-limit-ip", "5:many"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid rate limit bandwidth",
+			args:        []string{"daemon", "--rate-limit-token-bandwidth", "fast"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid rate limit config",
+			args:        []string{"daemon", "--rate-limit-config", jwtSecretPath},
+			shouldError: true,
+		},
+		{
+			name: "with api keys",
+			args: []string{"daemon", "--api-keys", apiKeysPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, []h.APIKey{{
+					Name:      "acme",
+					Key:       "acme-key",
+					RateLimit: h.RateLimit{RequestsPerSecond: 10},
+					Quota:     h.Quota{Requests: 1000, Period: 24 * time.Hour},
+				}}, hCfg.APIKeys)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid api keys",
+			args:        []string{"daemon", "--api-keys", rateLimitConfigPath},
+			shouldError: true,
+		},
+		{
+			name:        "with missing api keys",
+			args:        []string{"daemon", "--api-keys", filepath.Join(t.TempDir(), "missing.json")},
+			shouldError: true,
+		},
+		{
+			name: "with yaml config",
+			args: []string{"daemon", "--config", yamlConfigPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint(8080), hCfg.Port)
+				require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1}, lCfg.Protocols)
+				require.Equal(t, []string{"old-secret", "new-secret"}, hCfg.AccessTokens)
+				require.Equal(t, uint(10), hCfg.MaxConcurrentRetrievals)
+				require.Equal(t, time.Minute, hCfg.DrainTimeout)
+				require.True(t, hCfg.EnableAdmin)
+				return nil
+			},
+		},
+		{
+			name: "with toml config",
+			args: []string{"daemon", "--config", tomlConfigPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint(8080), hCfg.Port)
+				require.Equal(t, []multicodec.Code{multicodec.TransportIpfsGatewayHttp}, lCfg.Protocols)
+				require.Equal(t, []string{"secret"}, hCfg.AccessTokens)
+				return nil
+			},
+		},
+		{
+			name: "with flags overriding config",
+			args: []string{"daemon", "--config", yamlConfigPath, "--port", "1234", "--access-token", "flag-secret"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, uint(1234), hCfg.Port)
+				require.Equal(t, []string{"flag-secret"}, hCfg.AccessTokens)
+				require.Equal(t, uint(10), hCfg.MaxConcurrentRetrievals)
+				return nil
+			},
+		},
+		{
+			name: "with reloaded config",
+			args: []string{"daemon", "--config", reloadableConfigPath, "--rate-limit-token", "1"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, 30*time.Second, lCfg.GlobalTimeout)
+				require.Equal(t, h.RateLimits{
+					IP:    h.RateLimit{RequestsPerSecond: 5, RequestBurst: 20},
+					Token: h.RateLimit{RequestsPerSecond: 1},
+				}, hCfg.RateLimits)
+				require.Equal(t, logging.LevelInfo, logging.Levels()["lassie/retriever"])
+
+				require.NoError(t, os.WriteFile(reloadableConfigPath, []byte("global-timeout: 1m\nrate-limit-ip: \"10\"\nrate-limit-token: \"2\"\n"), 0644))
+				settings, err := reload()
+				require.NoError(t, err)
+				require.Equal(t, time.Minute, settings.globalTimeout)
+				// the flag takes precedence over the file
+				require.Equal(t, h.RateLimits{
+					IP:    h.RateLimit{RequestsPerSecond: 10},
+					Token: h.RateLimit{RequestsPerSecond: 1},
+				}, settings.rateLimits)
+				require.Empty(t, settings.logLevels)
+				// the level removed from the file no longer applies
+				require.NotContains(t, logging.Levels(), "lassie/retriever")
+
+				require.NoError(t, os.WriteFile(reloadableConfigPath, []byte("rate-limit-ip: fast\n"), 0644))
+				_, err = reload()
+				require.Error(t, err)
+				require.NoError(t, os.WriteFile(reloadableConfigPath, []byte("prot: 8080\n"), 0644))
+				_, err = reload()
+				require.Error(t, err)
+				return nil
+			},
+		},
+		{
+			name: "with log levels",
+			args: []string{"daemon", "--log-level", "warn", "--log-level", "lassie/httpserver=debug"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				levels := logging.Levels()
+				require.Equal(t, logging.LevelWarn, levels["lassie"])
+				require.Equal(t, logging.LevelWarn, levels["lassie/retriever"])
+				require.Equal(t, logging.LevelDebug, levels["lassie/httpserver"])
+				settings, err := reload()
+				require.NoError(t, err)
+				require.Equal(t, map[string]logging.Level{"": logging.LevelWarn, "lassie/httpserver": logging.LevelDebug}, settings.logLevels)
+				return nil
+			},
+		},
+		{
+			name: "with log levels removed on reload",
+			args: []string{"daemon", "--very-verbose", "--config", logLevelsConfigPath},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				levels := logging.Levels()
+				require.Equal(t, logging.LevelWarn, levels["lassie"])
+				require.Equal(t, logging.LevelError, levels["lassie/bitswap"])
+				require.Equal(t, logging.LevelInfo, levels["bitswap"])
+
+				// the global level and the subsystems no longer named are
+				// reset to their levels at startup
+				require.NoError(t, os.WriteFile(logLevelsConfigPath, []byte("log-level:\n  - lassie/httpserver=info\n"), 0644))
+				_, err := reload()
+				require.NoError(t, err)
+				levels = logging.Levels()
+				require.Equal(t, logging.LevelInfo, levels["lassie/httpserver"])
+				for _, subsystem := range []string{"lassie", "lassie/bitswap", "bitswap"} {
+					require.NotContains(t, levels, subsystem)
+				}
+				require.True(t, log.Logger("lassie/bitswap").Desugar().Core().Enabled(zapcore.DebugLevel))
+				require.False(t, log.Logger("bitswap").Desugar().Core().Enabled(zapcore.InfoLevel))
+				return nil
+			},
+		},
+		{
+			name:        "with invalid log level",
+			args:        []string{"daemon", "--log-level", "lassie/httpserver=loud"},
+			shouldError: true,
+		},
+		{
+			name:        "with config with unknown flag",
+			args:        []string{"daemon", "--config", unknownConfigPath},
+			shouldError: true,
+		},
+		{
+			name:        "with config with invalid value",
+			args:        []string{"daemon", "--config", invalidConfigPath},
+			shouldError: true,
+		},
+		{
+			name:        "with unsupported config format",
+			args:        []string{"daemon", "--config", jsonConfigPath},
+			shouldError: true,
+		},
+		{
+			name:        "with missing config",
+			args:        []string{"daemon", "--config", filepath.Join(t.TempDir(), "missing.yaml")},
+			shouldError: true,
+		},
+		{
+			name: "with drain timeout",
+			args: []string{"daemon", "--drain-timeout", "2m"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, 2*time.Minute, hCfg.DrainTimeout)
+				return nil
+			},
+		},
+		{
+			name: "without draining",
+			args: []string{"daemon", "--drain-timeout", "0"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Zero(t, hCfg.DrainTimeout)
+				return nil
+			},
+		},
+		{
+			name: "with admin",
+			args: []string{"daemon", "--admin"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.True(t, hCfg.EnableAdmin)
+				return nil
+			},
+		},
+		{
+			name: "with admin address",
+			args: []string{"daemon", "--admin", "--admin-address", "127.0.0.1:8081", "--admin-token", "one", "--admin-token", "two"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.True(t, hCfg.EnableAdmin)
+				require.Equal(t, "127.0.0.1:8081", hCfg.AdminAddress)
+				require.Equal(t, []string{"one", "two"}, hCfg.AdminAccessTokens)
 				return nil
 			},
 		},
+		{
+			name:        "with admin address without token",
+			args:        []string{"daemon", "--admin", "--admin-address", "127.0.0.1:8081"},
+			shouldError: true,
+		},
+		{
+			name: "with admin token",
+			args: []string{"daemon", "--admin", "--admin-token", "one"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.True(t, hCfg.EnableAdmin)
+				require.Equal(t, []string{"one"}, hCfg.AdminAccessTokens)
+				return nil
+			},
+		},
+		{
+			name:        "with admin token without admin",
+			args:        []string{"daemon", "--admin-token", "one"},
+			shouldError: true,
+		},
+		{
+			name:        "with admin and jwt without admin token",
+			args:        []string{"daemon", "--admin", "--jwt-secret-file", jwtSecretPath},
+			shouldError: true,
+		},
+		{
+			name:        "with admin and api keys without admin token",
+			args:        []string{"daemon", "--admin", "--api-keys", apiKeysPath},
+			shouldError: true,
+		},
+		{
+			name: "with admin and api keys",
+			args: []string{"daemon", "--admin", "--api-keys", apiKeysPath, "--admin-token", "one"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.True(t, hCfg.EnableAdmin)
+				require.NotEmpty(t, hCfg.APIKeys)
+				require.Equal(t, []string{"one"}, hCfg.AdminAccessTokens)
+				return nil
+			},
+		},
+		{
+			name: "with in memory",
+			args: []string{"daemon", "--in-memory"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.True(t, lCfg.InMemory)
+				require.True(t, hCfg.InMemory)
+				require.Equal(t, "", hCfg.TempDir)
+				return nil
+			},
+		},
+		{
+			name: "with ipns staleness bounds",
+			args: []string{"daemon", "--ipns-max-age", "10s", "--ipns-max-stale", "5m"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, 10*time.Second, hCfg.IpnsMaxAge)
+				require.Equal(t, 5*time.Minute, hCfg.IpnsMaxStale)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid ipns gateway",
+			args:        []string{"daemon", "--ipns-gateway", "not a gateway"},
+			shouldError: true,
+		},
+		{
+			name: "with event webhook",
+			args: []string{"daemon", "--event-webhook-url", "https://example.com/events", "--event-webhook-header", "Authorization: Bearer applesauce"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &eventwebhook.Config{
+					URL:    "https://example.com/events",
+					Header: http.Header{"Authorization": []string{"Bearer applesauce"}},
+				}, lCfg.EventWebhook)
+				return nil
+			},
+		},
+		{
+			name: "with alert webhook",
+			args: []string{"daemon", "--alert-webhook-url", "https://example.com/alerts", "--alert-webhook-header", "Authorization: Bearer applesauce"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.Equal(t, &eventwebhook.Config{
+					URL:    "https://example.com/alerts",
+					Header: http.Header{"Authorization": []string{"Bearer applesauce"}},
+					Codes:  []types.EventCode{types.ProviderAlertCode},
+				}, lCfg.AlertWebhook)
+				require.Nil(t, lCfg.EventWebhook)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid event webhook header",
+			args:        []string{"daemon", "--event-webhook-url", "https://example.com/events", "--event-webhook-header", "Authorization"},
+			shouldError: true,
+		},
+		{
+			name: "with reputation dir",
+			args: []string{"daemon", "--reputation-dir", reputationDir, "--reputation-half-life", "1h"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, lCfg.ReputationPersistence)
+				require.NotNil(t, lCfg.ReputationPersistence.Datastore)
+				require.Equal(t, time.Hour, lCfg.ReputationPersistence.HalfLife)
+				return nil
+			},
+		},
+		{
+			name: "with results dir",
+			args: []string{"daemon", "--results-dir", resultsDir, "--results-retention", "24h"},
+			assert: func(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
+				require.NotNil(t, lCfg.ResultStore)
+				return nil
+			},
+		},
+		{
+			name:        "with in memory and results dir",
+			args:        []string{"daemon", "--in-memory", "--results-dir", resultsDir},
+			shouldError: true,
+		},
+		{
+			name:        "with in memory and reputation dir",
+			args:        []string{"daemon", "--in-memory", "--reputation-dir", reputationDir},
+			shouldError: true,
+		},
+		{
+			name:        "with in memory and identity",
+			args:        []string{"daemon", "--in-memory", "--identity", newIdentityPath},
+			shouldError: true,
+		},
+		{
+			name:        "with in memory and tempdir",
+			args:        []string{"daemon", "--in-memory", "--tempdir", t.TempDir()},
+			shouldError: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -224,6 +1237,6 @@ func TestDaemonCommandFlags(t *testing.T) {
 	}
 }
 
-func noopDaemonRun(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig) error {
+func noopDaemonRun(ctx context.Context, lCfg *l.LassieConfig, hCfg h.HttpServerConfig, erCfg *a.EventRecorderConfig, reload func() (daemonSettings, error)) error {
 	return nil
 }
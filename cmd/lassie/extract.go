@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/unixfsextract"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/urfave/cli/v2"
+)
+
+const stdinFileString string = "-" // a string representing stdin
+
+var extractFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage: "the directory to extract to, created if it doesn't exist, or use '-' " +
+			"to write a single file to stdout",
+		Value:     ".",
+		TakesFile: true,
+	},
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var extractCmd = &cli.Command{
+	Name:      "extract",
+	Usage:     "Extracts UnixFS files and directories from a CAR",
+	ArgsUsage: "<file.car> [path]",
+	Description: "Writes the UnixFS content of the root of a CAR, such as one written by " +
+		"fetch, or of the given path below it, out as files and directories. Use '-' to " +
+		"read the CAR from stdin, which is held in memory while extracting. The entries " +
+		"of a root directory are written into the output directory, anything else is " +
+		"written to it under its name, or the root CID. Sharded directories are supported, " +
+		"and every block is checked against its CID as it's read.",
+	After:  after,
+	Action: extractAction,
+	Flags:  extractFlags,
+}
+
+func extractAction(cctx *cli.Context) error {
+	if cctx.Args().Len() < 1 || cctx.Args().Len() > 2 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "extract", 0)
+		return nil
+	}
+
+	output := cctx.String("output")
+	if output == stdoutFileString && isTerminal(cctx.App.Writer) {
+		return errors.New("refusing to write a file to a terminal, redirect stdout or pipe it to another command")
+	}
+
+	err := extractRun(
+		cctx.Context,
+		cctx.App.ErrWriter,
+		cctx.App.Writer,
+		cctx.App.Reader,
+		cctx.Args().Get(0),
+		datamodel.ParsePath(cctx.Args().Get(1)),
+		output,
+	)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type extractRunFunc func(
+	ctx context.Context,
+	msgWriter io.Writer,
+	dataWriter io.Writer,
+	dataReader io.Reader,
+	carPath string,
+	path datamodel.Path,
+	output string,
+) error
+
+var extractRun extractRunFunc = defaultExtractRun
+
+// defaultExtractRun is the handler for the extract command.
+func defaultExtractRun(
+	ctx context.Context,
+	msgWriter io.Writer,
+	dataWriter io.Writer,
+	dataReader io.Reader,
+	carPath string,
+	path datamodel.Path,
+	output string,
+) error {
+	var roots []cid.Cid
+	lsys := cidlink.DefaultLinkSystem()
+	if carPath == stdinFileString {
+		// a stream can't be read at random, so hold its blocks in memory
+		carPath = "stdin"
+		br, err := car.NewBlockReader(dataReader)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", carPath, err)
+		}
+		roots = br.Roots
+		if len(roots) != 1 {
+			return fmt.Errorf("%s has %d roots, only a CAR with a single root can be extracted", carPath, len(roots))
+		}
+		store := storage.NewDeferredStorageCarInMemory(roots[0])
+		defer store.Close()
+		for {
+			blk, err := br.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("failed to read %s: %w", carPath, err)
+			}
+			if err := store.Put(ctx, blk.Cid().KeyString(), blk.RawData()); err != nil {
+				return err
+			}
+		}
+		lsys.SetReadStorage(store)
+		lsys.TrustedStorage = true // checked by the block reader
+	} else {
+		f, err := os.Open(carPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readable, err := carstorage.OpenReadable(f)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", carPath, err)
+		}
+		roots = readable.Roots()
+		if len(roots) != 1 {
+			return fmt.Errorf("%s has %d roots, only a CAR with a single root can be extracted", carPath, len(roots))
+		}
+		lsys.SetReadStorage(readable)
+	}
+	root := roots[0]
+
+	if output == stdoutFileString {
+		n, err := unixfsextract.WriteFile(ctx, lsys, root, path, &onlyWriter{dataWriter})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(msgWriter, "Extracted /%s from %s: %s\n", path, root, humanize.IBytes(n))
+		return nil
+	}
+
+	stats, err := unixfsextract.Extract(ctx, lsys, root, path, output)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(msgWriter, "Extracted /%s from %s to %s:\n"+
+		"\t      Files: %d\n"+
+		"\tDirectories: %d\n"+
+		"\t   Symlinks: %d\n"+
+		"\t      Bytes: %s\n",
+		path,
+		root,
+		output,
+		stats.Files,
+		stats.Directories,
+		stats.Symlinks,
+		humanize.IBytes(stats.Bytes),
+	)
+	return nil
+}
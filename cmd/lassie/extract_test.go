@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestExtractCommandFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		assertRun extractRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"extract", "birb.car"},
+			assertRun: func(ctx context.Context, msgWriter io.Writer, dataWriter io.Writer, dataReader io.Reader, carPath string, path datamodel.Path, output string) error {
+				require.Equal(t, "birb.car", carPath)
+				require.Equal(t, emptyPath, path)
+				require.Equal(t, ".", output)
+				return nil
+			},
+		},
+		{
+			name: "with path and output",
+			args: []string{"extract", "-o", "out", "birb.car", "/birb.mp4"},
+			assertRun: func(ctx context.Context, msgWriter io.Writer, dataWriter io.Writer, dataReader io.Reader, carPath string, path datamodel.Path, output string) error {
+				require.Equal(t, "birb.car", carPath)
+				require.Equal(t, datamodel.ParsePath("birb.mp4"), path)
+				require.Equal(t, "out", output)
+				return nil
+			},
+		},
+		{
+			name: "with stdin and stdout",
+			args: []string{"extract", "-o", "-", "-", "birb.mp4"},
+			assertRun: func(ctx context.Context, msgWriter io.Writer, dataWriter io.Writer, dataReader io.Reader, carPath string, path datamodel.Path, output string) error {
+				require.Equal(t, stdinFileString, carPath)
+				require.Equal(t, stdoutFileString, output)
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		// extractRun is a global var that we can override for testing purposes
+		extractRun = test.assertRun
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    extractFlags,
+			Commands: []*cli.Command{extractCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, app.Run(append([]string{"cli-test"}, test.args...)))
+		})
+	}
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
-	"strings"
+	"os"
 
 	"github.com/dustin/go-humanize"
 	"github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	"github.com/filecoin-project/lassie/pkg/contentpath"
 	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/globpath"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
 	"github.com/filecoin-project/lassie/pkg/lassie"
 	"github.com/filecoin-project/lassie/pkg/storage"
 	"github.com/filecoin-project/lassie/pkg/types"
@@ -19,7 +23,7 @@ import (
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	trustlessutils "github.com/ipld/go-trustless-utils"
-	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v2"
 )
 
@@ -30,14 +34,47 @@ var fetchFlags = []cli.Flag{
 		Name:    "output",
 		Aliases: []string{"o"},
 		Usage: "the CAR file to write to, may be an existing or a new CAR, " +
-			"or use '-' to write to stdout",
+			"or use '-' to write to stdout. With --input, the directory to write " +
+			"the CARs to",
 		TakesFile: true,
 	},
+	&cli.StringFlag{
+		Name:    "input",
+		Aliases: []string{"i"},
+		Usage: "a file listing the content to fetch, one CID or path per line, " +
+			"optionally followed by the name of the CAR to write it to, or use '-' " +
+			"to read stdin. Exits with a non-zero status if any fail to fetch",
+		TakesFile: true,
+	},
+	&cli.StringFlag{
+		Name: "resume",
+		Usage: "continue a fetch that was interrupted, reading the blocks already " +
+			"in the partially written CAR so they aren't fetched again and appending " +
+			"those that are missing. Starts a new CAR if the file doesn't exist",
+		TakesFile: true,
+	},
+	&cli.IntFlag{
+		Name:  "parallel",
+		Usage: "the number of entries of the --input file to fetch at a time",
+		Value: 4,
+	},
 	&cli.BoolFlag{
 		Name:    "progress",
 		Aliases: []string{"p"},
 		Usage:   "print progress output",
 	},
+	&cli.BoolFlag{
+		Name: "in-memory",
+		Usage: "hold the blocks being fetched in memory rather than in a temporary " +
+			"file, so that with '-o -' the fetch never touches disk; memory use grows " +
+			"with the size of the content. Can't be used with --tempdir",
+	},
+	&cli.BoolFlag{
+		Name:    "quiet",
+		Aliases: []string{"q"},
+		Usage: "don't print the live status line, or the progress of the fetch " +
+			"when not writing to a terminal",
+	},
 	&cli.StringFlag{
 		Name: "dag-scope",
 		Usage: "describes the fetch behavior at the end of the traversal " +
@@ -77,30 +114,101 @@ var fetchFlags = []cli.Flag{
 			"may be useful for streaming.",
 		Aliases: []string{"dups"},
 	},
+	&cli.BoolFlag{
+		Name: "glob",
+		Usage: "treat the path as a glob pattern, e.g. /logs/2024-*/errors.json, " +
+			"matching entries are expanded during traversal and only those are " +
+			"fetched. Can't be used with duplicates or entity-bytes.",
+	},
+	&cli.Uint64Flag{
+		Name: "depth",
+		Usage: "limit the depth of the DAG fetched below the path, where each " +
+			"link followed is one level, e.g. a depth of 1 fetches a directory and " +
+			"the root block of each of its entries. Requires dag-scope=all.",
+		DefaultText: "no limit",
+	},
+	&cli.BoolFlag{
+		Name: "nested-cars",
+		Usage: "expand CAR files found within the fetched DAG, verifying each " +
+			"and writing its blocks to the output CAR, for datasets packaged as " +
+			"nested CARs",
+	},
+	&cli.IntFlag{
+		Name:  "nested-cars-depth",
+		Usage: "the number of levels of CARs within CARs to expand with --nested-cars",
+		Value: types.DefaultNestedCarMaxDepth,
+	},
+	&cli.Uint64Flag{
+		Name:  "nested-cars-max-bytes",
+		Usage: "the size of the largest CAR to expand with --nested-cars, larger CARs are left as they are",
+		Value: types.DefaultNestedCarMaxBytes,
+	},
+	&cli.StringFlag{
+		Name: "expected-digest",
+		Usage: "verify the fetched UnixFS file against the digest of its bytes, " +
+			"given as <hash function>:<hex digest>, e.g. sha2-256:<hex> or blake3:<hex>",
+	},
 	FlagIPNIEndpoint,
 	FlagEventRecorderAuth,
 	FlagEventRecorderInstanceId,
 	FlagEventRecorderUrl,
+	FlagEventWebhookUrl,
+	FlagEventWebhookHeaders,
+	FlagAlertWebhookUrl,
+	FlagAlertWebhookHeaders,
 	FlagVerbose,
 	FlagVeryVerbose,
 	FlagProtocols,
 	FlagAllowProviders,
 	FlagExcludeProviders,
+	FlagExcludeNetworks,
+	FlagProviderBlockList,
+	FlagProviderAllowList,
 	FlagTempDir,
 	FlagBitswapConcurrency,
+	FlagMaxBlockSize,
+	FlagMaxCandidates,
+	FlagMaxGraphsyncQueries,
+	FlagMaxHttpQueries,
+	FlagHttpRateLimit,
+	FlagHttpRateLimitBurst,
+	FlagHttpHostRateLimit,
+	FlagHttpPrewarm,
+	FlagHttpPrewarmMinLatency,
+	FlagLatencyProbes,
+	FlagLatencyProbeTimeout,
+	FlagRegion,
+	FlagRegionTable,
+	FlagCapabilityTTL,
+	FlagCircuitBreakerThreshold,
+	FlagCircuitBreakerCooldown,
+	FlagCircuitBreakerMaxCooldown,
+	FlagRetries,
+	FlagRetryBackoff,
+	FlagRetryMaxBackoff,
+	FlagRetryJitter,
+	FlagRetryOverride,
+	FlagScoringWeights,
 	FlagGlobalTimeout,
 	FlagProviderTimeout,
+	FlagRetrievalReceipts,
+	FlagIpnsGateways,
+	FlagIpnsMaxDepth,
 }
 
 var fetchCmd = &cli.Command{
-	Name:   "fetch",
-	Usage:  "Fetches content from the IPFS and Filecoin network",
-	After:  after,
-	Action: fetchAction,
-	Flags:  fetchFlags,
+	Name:      "fetch",
+	Usage:     "Fetches content from the IPFS and Filecoin network",
+	ArgsUsage: "<cid>[/path] | /ipfs/<cid>[/path] | ipfs://<cid>[/path] | /ipns/<name>[/path] | ipns://<name>[/path] | --input <file>",
+	After:     after,
+	Action:    fetchAction,
+	Flags:     fetchFlags,
 }
 
 func fetchAction(cctx *cli.Context) error {
+	if cctx.IsSet("input") {
+		return fetchBatchAction(cctx)
+	}
 	if cctx.Args().Len() != 1 {
 		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
 		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
@@ -112,33 +220,29 @@ func fetchAction(cctx *cli.Context) error {
 	msgWriter := cctx.App.ErrWriter
 	dataWriter := cctx.App.Writer
 
-	root, path, scope, byteRange, duplicates, err := parseCidPath(cctx.Args().Get(0))
+	root, path, scope, byteRange, duplicates, err := fetchParams(cctx, cctx.Args().Get(0))
 	if err != nil {
 		return err
 	}
 
-	if cctx.IsSet("dag-scope") {
-		if scope, err = trustlessutils.ParseDagScope(cctx.String("dag-scope")); err != nil {
-			return err
-		}
+	glob := cctx.Bool("glob")
+	depth := cctx.Uint64("depth")
+
+	nestedCars, err := nestedCarsConfig(cctx)
+	if err != nil {
+		return err
 	}
 
-	if cctx.IsSet("entity-bytes") {
-		if entityBytes, err := trustlessutils.ParseByteRange(cctx.String("entity-bytes")); err != nil {
+	var expectedDigest multihash.Multihash
+	if cctx.IsSet("expected-digest") {
+		if expectedDigest, err = types.ParseDigestString(cctx.String("expected-digest")); err != nil {
 			return err
-		} else if entityBytes.IsDefault() {
-			byteRange = nil
-		} else {
-			byteRange = &entityBytes
 		}
 	}
 
-	if cctx.IsSet("duplicates") {
-		duplicates = cctx.Bool("duplicates")
-	}
-
 	tempDir := cctx.String("tempdir")
 	progress := cctx.Bool("progress")
+	quiet := cctx.Bool("quiet")
 
 	output := cctx.String("output")
 	outfile := fmt.Sprintf("%s.car", root.String())
@@ -146,7 +250,34 @@ func fetchAction(cctx *cli.Context) error {
 		outfile = output
 	}
 
-	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, nil, nil)
+	var resume bool
+	if cctx.IsSet("resume") {
+		if cctx.IsSet("output") {
+			return errors.New("resume can't be used with output, the CAR being resumed is written to")
+		}
+		if duplicates {
+			return errors.New("resume can't be used with duplicates")
+		}
+		outfile = cctx.String("resume")
+		if outfile == stdoutFileString {
+			return errors.New("resume can't continue a CAR written to stdout")
+		}
+		// a CAR that was never started is fetched as normal
+		if fi, err := os.Stat(outfile); err == nil {
+			resume = fi.Size() > 0
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if outfile == stdoutFileString && isTerminal(dataWriter) {
+		return errors.New("refusing to write a CAR to a terminal, redirect stdout or pipe it to another command")
+	}
+
+	lassieOpts, err := fetchLassieOptions(cctx)
+	if err != nil {
+		return err
+	}
+	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, lassieOpts, nil)
 	if err != nil {
 		return err
 	}
@@ -167,8 +298,14 @@ func fetchAction(cctx *cli.Context) error {
 		scope,
 		byteRange,
 		duplicates,
+		glob,
+		depth,
+		nestedCars,
+		expectedDigest,
 		tempDir,
 		progress,
+		quiet,
+		resume,
 		outfile,
 	)
 	if err != nil {
@@ -178,7 +315,10 @@ func fetchAction(cctx *cli.Context) error {
 	return nil
 }
 
-func parseCidPath(spec string) (
+// fetchParams parses content given in any of the forms accepted by
+// contentpath.Parse, resolving IPNS names, into the parameters of a retrieval,
+// with those set by flags taking precedence over those of its query.
+func fetchParams(cctx *cli.Context, content string) (
 	root cid.Cid,
 	path datamodel.Path,
 	scope trustlessutils.DagScope,
@@ -186,48 +326,173 @@ func parseCidPath(spec string) (
 	duplicates bool,
 	err error,
 ) {
-	scope = trustlessutils.DagScopeAll // default
+	spec, err := contentpath.Parse(content)
+	if err != nil {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+	}
+	if spec.Namespace == contentpath.NamespaceIPNS {
+		if spec, err = resolveIpnsSpec(cctx, spec); err != nil {
+			return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+		}
+	}
 
-	if !strings.HasPrefix(spec, "/ipfs/") {
-		cstr := strings.Split(spec, "/")[0]
-		path = datamodel.ParsePath(strings.TrimPrefix(spec, cstr))
-		if root, err = cid.Parse(cstr); err != nil {
+	root, path, scope, byteRange, duplicates, err = contentPathParams(spec)
+	if err != nil {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+	}
+
+	if cctx.IsSet("dag-scope") {
+		if scope, err = trustlessutils.ParseDagScope(cctx.String("dag-scope")); err != nil {
 			return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
 		}
-		return root, path, scope, byteRange, duplicates, err
-	} else {
-		specParts := strings.Split(spec, "?")
-		spec = specParts[0]
+	}
 
-		if root, path, err = trustlesshttp.ParseUrlPath(spec); err != nil {
+	if cctx.IsSet("entity-bytes") {
+		if entityBytes, err := trustlessutils.ParseByteRange(cctx.String("entity-bytes")); err != nil {
 			return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+		} else if entityBytes.IsDefault() {
+			byteRange = nil
+		} else {
+			byteRange = &entityBytes
 		}
+	}
 
-		switch len(specParts) {
-		case 1:
-		case 2:
-			query, err := url.ParseQuery(specParts[1])
-			if err != nil {
-				return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
-			}
-			scope, err = trustlessutils.ParseDagScope(query.Get("dag-scope"))
+	if cctx.IsSet("duplicates") {
+		duplicates = cctx.Bool("duplicates")
+	}
+
+	if err := checkFetchParams(cctx, scope, byteRange, duplicates); err != nil {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+	}
+	return root, path, scope, byteRange, duplicates, nil
+}
+
+// checkFetchParams checks that the --glob and --depth flags can be used with
+// the parameters of a retrieval.
+func checkFetchParams(cctx *cli.Context, scope trustlessutils.DagScope, byteRange *trustlessutils.ByteRange, duplicates bool) error {
+	glob := cctx.Bool("glob")
+	if glob && duplicates {
+		return globpath.ErrGlobWithDuplicates
+	}
+	if glob && byteRange != nil && !byteRange.IsDefault() {
+		return globpath.ErrGlobWithByteRange
+	}
+
+	depth := cctx.Uint64("depth")
+	if depth > 0 && glob {
+		return errors.New("depth can't be used with glob")
+	}
+	if depth > 0 && duplicates {
+		return errors.New("depth can't be used with duplicates")
+	}
+	if depth > 0 && (scope != trustlessutils.DagScopeAll || (byteRange != nil && !byteRange.IsDefault())) {
+		return errors.New("depth can only be used with dag-scope=all and no entity-bytes")
+	}
+	return nil
+}
+
+// fetchLassieOptions returns the options of the Lassie instance for the fetch
+// flags that aren't shared with other commands.
+func fetchLassieOptions(cctx *cli.Context) ([]lassie.LassieOption, error) {
+	var lassieOpts []lassie.LassieOption
+	if cctx.Bool("in-memory") {
+		if cctx.IsSet("tempdir") {
+			return nil, fmt.Errorf("%w: temporary directory %s", lassie.ErrDiskAccess, cctx.String("tempdir"))
+		}
+		lassieOpts = append(lassieOpts, lassie.WithInMemory())
+	}
+	return lassieOpts, nil
+}
+
+// nestedCarsConfig returns the configuration of --nested-cars, or nil if it
+// isn't set.
+func nestedCarsConfig(cctx *cli.Context) (*types.NestedCarConfig, error) {
+	if cctx.Bool("nested-cars") {
+		return &types.NestedCarConfig{
+			MaxDepth: cctx.Int("nested-cars-depth"),
+			MaxBytes: cctx.Uint64("nested-cars-max-bytes"),
+		}, nil
+	}
+	if cctx.IsSet("nested-cars-depth") || cctx.IsSet("nested-cars-max-bytes") {
+		return nil, errors.New("nested-cars-depth and nested-cars-max-bytes require nested-cars")
+	}
+	return nil, nil
+}
+
+// newIpnsResolver creates an IPNS resolver fetching signed records from the
+// gateways set with --ipns-gateway, and following chains of names up to
+// --ipns-max-depth.
+func newIpnsResolver(cctx *cli.Context) (*ipnsresolver.Resolver, error) {
+	var opts []ipnsresolver.Option
+	if gateways := cctx.StringSlice("ipns-gateway"); len(gateways) > 0 {
+		gatewayUrls := make([]*url.URL, 0, len(gateways))
+		for _, gw := range gateways {
+			u, err := url.Parse(gw)
 			if err != nil {
-				return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+				return nil, fmt.Errorf("invalid IPNS gateway %q: %w", gw, err)
 			}
-			if query.Get("entity-bytes") != "" {
-				br, err := trustlessutils.ParseByteRange(query.Get("entity-bytes"))
-				if err != nil {
-					return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
-				}
-				byteRange = &br
-			}
-			duplicates = query.Get("dups") == "y"
-		default:
-			return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, fmt.Errorf("invalid query: %s", spec)
+			gatewayUrls = append(gatewayUrls, u)
 		}
+		opts = append(opts, ipnsresolver.WithGateways(gatewayUrls...))
+	}
+	opts = append(opts, ipnsresolver.WithMaxDepth(cctx.Int("ipns-max-depth")))
+	return ipnsresolver.NewResolver(opts...)
+}
+
+// resolveIpnsSpec resolves the name in an /ipns/ content path using signed
+// records fetched from the configured gateways, returning the equivalent
+// /ipfs/ content path.
+func resolveIpnsSpec(cctx *cli.Context, spec contentpath.ContentPath) (contentpath.ContentPath, error) {
+	resolver, err := newIpnsResolver(cctx)
+	if err != nil {
+		return contentpath.ContentPath{}, err
+	}
 
-		return root, path, scope, byteRange, duplicates, nil
+	root, path, err := resolver.Resolve(cctx.Context, spec.Name)
+	if err != nil {
+		return contentpath.ContentPath{}, fmt.Errorf("failed to resolve /ipns/%s: %w", spec.Name, err)
 	}
+	return spec.Resolved(root, path), nil
+}
+
+// parseCidPath parses a content path given in any of the forms accepted by
+// contentpath.Parse, such as /ipfs/<cid>/path, ipfs://<cid>/path or a gateway
+// URL, into the parameters of a retrieval.
+func parseCidPath(spec string) (
+	root cid.Cid,
+	path datamodel.Path,
+	scope trustlessutils.DagScope,
+	byteRange *trustlessutils.ByteRange,
+	duplicates bool,
+	err error,
+) {
+	p, err := contentpath.Parse(spec)
+	if err != nil {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+	}
+	return contentPathParams(p)
+}
+
+// contentPathParams returns the parameters of a retrieval for an /ipfs/
+// content path.
+func contentPathParams(p contentpath.ContentPath) (
+	root cid.Cid,
+	path datamodel.Path,
+	scope trustlessutils.DagScope,
+	byteRange *trustlessutils.ByteRange,
+	duplicates bool,
+	err error,
+) {
+	if p.Namespace != contentpath.NamespaceIPFS {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, fmt.Errorf("%s must be resolved to an /ipfs/ path", p)
+	}
+	if scope, err = p.DagScope(); err != nil {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+	}
+	if byteRange, err = p.EntityBytes(); err != nil {
+		return cid.Undef, datamodel.Path{}, trustlessutils.DagScopeAll, nil, false, err
+	}
+	return p.Root, p.Path, scope, byteRange, p.Duplicates(), nil
 }
 
 type progressPrinter struct {
@@ -262,6 +527,12 @@ func (pp *progressPrinter) subscriber(event types.RetrievalEvent) {
 		}
 	case events.FailedEvent:
 		fmt.Fprintf(pp.writer, "\rRetrieval failure from indexer: %s\n", ret.ErrorMessage())
+	case events.CandidateSkippedEvent:
+		fmt.Fprintf(pp.writer, "\rSkipping [%s] for %s: %s\n", events.Identifier(ret), ret.Protocol(), ret.Reason())
+	case events.CircuitBreakerOpenedEvent:
+		fmt.Fprintf(pp.writer, "\rSuspending [%s] for %s after repeated failures\n", events.Identifier(ret), ret.Cooldown())
+	case events.ProviderAlertEvent:
+		fmt.Fprintf(pp.writer, "\rALERT: allowlisted [%s] %s: %s\n", events.Identifier(ret), ret.Reason(), ret.ErrorMessage())
 	case events.FailedRetrievalEvent:
 		fmt.Fprintf(pp.writer, "\rRetrieval failure for [%s]: %s\n", events.Identifier(ret), ret.ErrorMessage())
 	case events.SucceededEvent:
@@ -288,8 +559,14 @@ type fetchRunFunc func(
 	dagScope trustlessutils.DagScope,
 	entityBytes *trustlessutils.ByteRange,
 	duplicates bool,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	expectedDigest multihash.Multihash,
 	tempDir string,
 	progress bool,
+	quiet bool,
+	resume bool,
 	outfile string,
 ) error
 
@@ -309,8 +586,14 @@ func defaultFetchRun(
 	dagScope trustlessutils.DagScope,
 	entityBytes *trustlessutils.ByteRange,
 	duplicates bool,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	expectedDigest multihash.Multihash,
 	tempDir string,
 	progress bool,
+	quiet bool,
+	resume bool,
 	outfile string,
 ) error {
 	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
@@ -332,12 +615,108 @@ func defaultFetchRun(
 	} else {
 		fmt.Fprintf(msgWriter, "Fetching %s from specified provider(s)", rootCid.String()+printPath)
 	}
-	if progress {
+
+	// on a terminal, a live status line is redrawn below any progress output,
+	// otherwise progress is a dot per block, or a count of the blocks received
+	var status *fetchStatus
+	progressWriter := msgWriter
+	if !quiet && isTerminal(msgWriter) {
+		fmt.Fprintln(msgWriter)
+		status = newFetchStatus(msgWriter)
+		lassie.RegisterSubscriber(status.subscriber)
+		progressWriter = status
+	} else if progress {
 		fmt.Fprintln(msgWriter)
-		pp := &progressPrinter{writer: msgWriter}
+	}
+	if progress {
+		pp := &progressPrinter{writer: progressWriter}
 		lassie.RegisterSubscriber(pp.subscriber)
 	}
 
+	var onResume func(blocks int, bytes uint64)
+	if resume {
+		onResume = func(blocks int, bytes uint64) {
+			if status == nil && !progress {
+				// finish the "Fetching" line before the dots for each block
+				fmt.Fprintln(msgWriter)
+			}
+			fmt.Fprintf(progressWriter, "Resuming %s, %d blocks / %s already fetched\n", outfile, blocks, humanize.IBytes(bytes))
+		}
+	}
+
+	var blockCount int
+	var byteLength uint64
+	stats, err := fetchCar(ctx, lassie, dataWriter, rootCid, path, dagScope, entityBytes, duplicates, glob, depth, nestedCars, expectedDigest, tempDir, outfile, onResume, func(putBytes int) {
+		blockCount++
+		byteLength += uint64(putBytes)
+		switch {
+		case status != nil:
+			status.onPut(putBytes)
+		case quiet:
+		case progress:
+			fmt.Fprintf(msgWriter, "\rReceived %d blocks / %s...", blockCount, humanize.IBytes(byteLength))
+		default:
+			fmt.Fprint(msgWriter, ".")
+		}
+	})
+	if status != nil {
+		status.done()
+	}
+	if err != nil {
+		if status == nil {
+			fmt.Fprintln(msgWriter)
+		}
+		return err
+	}
+	spid := stats.StorageProviderId.String()
+	if spid == "" {
+		spid = types.BitswapIndentifier
+	}
+	fmt.Fprintf(msgWriter, "\nFetched [%s] from [%s]:\n"+
+		"\tDuration: %s\n"+
+		"\t  Blocks: %d\n"+
+		"\t   Bytes: %s\n"+
+		"\t    Hash: %s\n",
+		rootCid,
+		spid,
+		stats.Duration,
+		blockCount,
+		humanize.IBytes(stats.Size),
+		stats.RequestHash,
+	)
+	if stats.Prewarm != nil {
+		fmt.Fprintf(msgWriter, "\t Prewarm: %d request(s) in %s, provider latency %s\n", stats.Prewarm.Requests, stats.Prewarm.Duration, stats.Prewarm.Latency)
+	}
+	if stats.NestedCars > 0 {
+		fmt.Fprintf(msgWriter, "\t  Nested: %d CAR(s) expanded\n", stats.NestedCars)
+	}
+
+	return nil
+}
+
+// fetchCar retrieves content with lassie into a CAR written to outfile, or to
+// dataWriter if outfile is "-", calling onPut with the size of each block
+// written to it. If onResume is not nil, outfile is a partially written CAR
+// that is continued, the blocks already in it aren't written again and
+// onResume is called with their number and size before the retrieval starts.
+func fetchCar(
+	ctx context.Context,
+	lassie *lassie.Lassie,
+	dataWriter io.Writer,
+	rootCid cid.Cid,
+	path datamodel.Path,
+	dagScope trustlessutils.DagScope,
+	entityBytes *trustlessutils.ByteRange,
+	duplicates bool,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	expectedDigest multihash.Multihash,
+	tempDir string,
+	outfile string,
+	onResume func(blocks int, bytes uint64),
+	onPut func(putBytes int),
+) (*types.RetrievalStats, error) {
 	var carWriter storage.DeferredWriter
 	carOpts := []car.Option{
 		car.WriteAsCarV1(true),
@@ -346,8 +725,23 @@ func defaultFetchRun(
 	}
 
 	tempStore := storage.NewDeferredStorageCar(tempDir, rootCid)
+	if lassie.InMemory() {
+		tempStore = storage.NewDeferredStorageCarInMemory(rootCid)
+	}
 
-	if outfile == stdoutFileString {
+	if onResume != nil {
+		// seed the temporary store with the blocks already written so the
+		// traversal finds them there rather than retrieving them again
+		appendingCar, err := storage.OpenAppendingCar(ctx, outfile, rootCid, func(key string, data []byte) error {
+			return tempStore.Put(ctx, key, data)
+		})
+		if err != nil {
+			tempStore.Close()
+			return nil, err
+		}
+		onResume(appendingCar.Existing())
+		carWriter = appendingCar
+	} else if outfile == stdoutFileString {
 		// we need the onlyWriter because stdout is presented as an os.File, and
 		// therefore pretend to support seeks, so feature-checking in go-car
 		// will make bad assumptions about capabilities unless we hide it
@@ -369,21 +763,11 @@ func defaultFetchRun(
 	carStore := storage.NewCachingTempStore(carWriter.BlockWriteOpener(), tempStore)
 	defer carStore.Close()
 
-	var blockCount int
-	var byteLength uint64
-	carWriter.OnPut(func(putBytes int) {
-		blockCount++
-		byteLength += uint64(putBytes)
-		if !progress {
-			fmt.Fprint(msgWriter, ".")
-		} else {
-			fmt.Fprintf(msgWriter, "\rReceived %d blocks / %s...", blockCount, humanize.IBytes(byteLength))
-		}
-	}, false)
+	carWriter.OnPut(onPut, false)
 
 	request, err := types.NewRequestForPath(carStore, rootCid, path.String(), dagScope, entityBytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// setup preload storage for bitswap, the temporary CAR store can set up a
 	// separate preload space in its storage
@@ -394,25 +778,22 @@ func defaultFetchRun(
 	request.PreloadLinkSystem.TrustedStorage = true
 	request.Duplicates = duplicates
 
-	stats, err := lassie.Fetch(ctx, request)
-	if err != nil {
-		fmt.Fprintln(msgWriter)
-		return err
+	if glob {
+		if request, err = globpath.Expand(ctx, lassie, request); err != nil {
+			return nil, err
+		}
 	}
-	spid := stats.StorageProviderId.String()
-	if spid == "" {
-		spid = types.BitswapIndentifier
+
+	var fetchOpts []types.FetchOption
+	if depth > 0 {
+		fetchOpts = append(fetchOpts, types.WithMaxDepth(depth))
+	}
+	if nestedCars != nil {
+		fetchOpts = append(fetchOpts, types.WithNestedCars(*nestedCars))
+	}
+	if expectedDigest != nil {
+		fetchOpts = append(fetchOpts, types.WithExpectedDigest(expectedDigest))
 	}
-	fmt.Fprintf(msgWriter, "\nFetched [%s] from [%s]:\n"+
-		"\tDuration: %s\n"+
-		"\t  Blocks: %d\n"+
-		"\t   Bytes: %s\n",
-		rootCid,
-		spid,
-		stats.Duration,
-		blockCount,
-		humanize.IBytes(stats.Size),
-	)
 
-	return nil
+	return lassie.Fetch(ctx, request, fetchOpts...)
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -10,11 +12,13 @@ import (
 	"github.com/filecoin-project/lassie/pkg/indexerlookup"
 	l "github.com/filecoin-project/lassie/pkg/lassie"
 	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
 )
@@ -22,6 +26,10 @@ import (
 var emptyPath = datamodel.ParsePath("")
 
 func TestFetchCommandFlags(t *testing.T) {
+	partialCar := filepath.Join(t.TempDir(), "partial.car")
+	require.NoError(t, os.WriteFile(partialCar, []byte("partial"), 0644))
+	missingCar := filepath.Join(t.TempDir(), "missing.car")
+
 	tests := []struct {
 		name        string
 		args        []string
@@ -31,7 +39,7 @@ func TestFetchCommandFlags(t *testing.T) {
 		{
 			name: "with default args",
 			args: []string{"fetch", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				// fetch specific params
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
 				require.Equal(t, emptyPath, path)
@@ -39,11 +47,13 @@ func TestFetchCommandFlags(t *testing.T) {
 				require.Nil(t, entityBytes)
 				require.False(t, duplicates)
 				require.False(t, progress)
+				require.Nil(t, nestedCars)
+				require.Nil(t, expectedDigest)
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4.car", outfile)
 
 				// lassie config
 				require.Equal(t, nil, lCfg.Finder)
-				require.NotNil(t, lCfg.Host, "host should not be nil")
+				require.Nil(t, lCfg.Host, "host should be started lazily")
 				require.Equal(t, 20*time.Second, lCfg.ProviderTimeout)
 				require.Equal(t, uint(0), lCfg.ConcurrentSPRetrievals)
 				require.Equal(t, 0*time.Second, lCfg.GlobalTimeout)
@@ -54,6 +64,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				// there's only one --bitswap-concurrency for `fetch` and it sets both to be the same
 				require.Equal(t, 32, lCfg.BitswapConcurrency)
 				require.Equal(t, 32, lCfg.BitswapConcurrencyPerRetrieval)
+				require.Equal(t, uint64(2<<20), lCfg.MaxBlockSize)
 
 				// event recorder config
 				require.Equal(t, "", erCfg.EndpointURL)
@@ -76,7 +87,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"fetch",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, datamodel.ParsePath("birb.mp4"), path)
 				return nil
 			},
@@ -89,7 +100,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"entity",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, trustlessutils.DagScopeEntity, dagScope)
 				return nil
 			},
@@ -102,7 +113,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"block",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, trustlessutils.DagScopeBlock, dagScope)
 				return nil
 			},
@@ -115,7 +126,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"0:*",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Nil(t, entityBytes) // default is ignored
 				return nil
 			},
@@ -128,7 +139,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"0:10",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				var to int64 = 10
 				require.Equal(t, &trustlessutils.ByteRange{From: 0, To: &to}, entityBytes)
 				return nil
@@ -142,7 +153,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"1000:20000",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				var to int64 = 20000
 				require.Equal(t, &trustlessutils.ByteRange{From: 1000, To: &to}, entityBytes)
 				return nil
@@ -155,7 +166,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"--duplicates",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.True(t, duplicates)
 				return nil
 			},
@@ -167,7 +178,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"--progress",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.True(t, progress)
 				return nil
 			},
@@ -180,7 +191,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"myfile",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, "myfile", outfile)
 				return nil
 			},
@@ -193,8 +204,9 @@ func TestFetchCommandFlags(t *testing.T) {
 				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.IsType(t, &retriever.DirectCandidateFinder{}, lCfg.Finder, "finder should be a DirectCandidateFinder when providers are specified")
+				require.NotNil(t, lCfg.Host, "host should be started for the direct candidate finder")
 				return nil
 			},
 		},
@@ -206,7 +218,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"https://cid.contact",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.IsType(t, &indexerlookup.IndexerCandidateFinder{}, lCfg.Finder, "finder should be an IndexerCandidateFinder when providing an ipni endpoint")
 				return nil
 			},
@@ -229,7 +241,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"/mytmpdir",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, "/mytmpdir", tempDir)
 				return nil
 			},
@@ -242,7 +254,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"30s",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, 30*time.Second, lCfg.ProviderTimeout)
 				return nil
 			},
@@ -255,7 +267,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"30s",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, 30*time.Second, lCfg.GlobalTimeout)
 				return nil
 			},
@@ -268,7 +280,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"bitswap,graphsync",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1}, lCfg.Protocols)
 				return nil
 			},
@@ -281,7 +293,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4,12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				p1, err := peer.Decode("12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4")
 				require.NoError(t, err)
 				p2, err := peer.Decode("12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys")
@@ -300,11 +312,24 @@ func TestFetchCommandFlags(t *testing.T) {
 				"10",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, 10, lCfg.BitswapConcurrency)
 				return nil
 			},
 		},
+		{
+			name: "with max block size",
+			args: []string{
+				"fetch",
+				"--max-block-size",
+				"1048576",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, uint64(1<<20), lCfg.MaxBlockSize)
+				return nil
+			},
+		},
 		{
 			name: "with event recorder url",
 			args: []string{
@@ -313,7 +338,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"https://myeventrecorder.com/v1/retrieval-events",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, "https://myeventrecorder.com/v1/retrieval-events", erCfg.EndpointURL)
 				return nil
 			},
@@ -326,7 +351,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"secret",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, "secret", erCfg.EndpointAuthorization)
 				return nil
 			},
@@ -339,7 +364,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"myinstanceid",
 				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				require.Equal(t, "myinstanceid", erCfg.InstanceID)
 				return nil
 			},
@@ -350,7 +375,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"fetch",
 				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				// fetch specific params
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
 				require.Equal(t, emptyPath, path)
@@ -365,7 +390,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"fetch",
 				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4/nope",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				// fetch specific params
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
 				require.Equal(t, datamodel.ParsePath("birb.mp4/nope"), path)
@@ -380,7 +405,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"fetch",
 				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4/nope?dag-scope=entity",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				// fetch specific params
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
 				require.Equal(t, datamodel.ParsePath("birb.mp4/nope"), path)
@@ -395,7 +420,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"fetch",
 				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4/nope?dag-scope=entity&entity-bytes=1000:20000",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				// fetch specific params
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
 				require.Equal(t, datamodel.ParsePath("birb.mp4/nope"), path)
@@ -413,7 +438,7 @@ func TestFetchCommandFlags(t *testing.T) {
 				"--entity-bytes", "0:*",
 				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4/nope?dag-scope=entity&entity-bytes=1000:20000",
 			},
-			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, tempDir string, progress bool, outfile string) error {
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
 				// fetch specific params
 				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
 				require.Equal(t, datamodel.ParsePath("birb.mp4/nope"), path)
@@ -422,6 +447,217 @@ func TestFetchCommandFlags(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name: "with in-memory",
+			args: []string{
+				"fetch",
+				"--in-memory",
+				"-o", "-",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.True(t, lCfg.InMemory)
+				require.Equal(t, "-", outfile)
+				return nil
+			},
+		},
+		{
+			name: "with resume",
+			args: []string{
+				"fetch",
+				"--resume", partialCar,
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.True(t, resume)
+				require.Equal(t, partialCar, outfile)
+				return nil
+			},
+		},
+		{
+			name: "with resume of a missing CAR",
+			args: []string{
+				"fetch",
+				"--resume", missingCar,
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.False(t, resume)
+				require.Equal(t, missingCar, outfile)
+				return nil
+			},
+		},
+		{
+			name: "with resume and output",
+			args: []string{
+				"fetch",
+				"--resume", partialCar,
+				"--output", "myfile",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with resume and duplicates",
+			args: []string{
+				"fetch",
+				"--resume", partialCar,
+				"--duplicates",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with in-memory and temp directory",
+			args: []string{
+				"fetch",
+				"--in-memory",
+				"--tempdir", "/foo",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with ipfs url",
+			args: []string{
+				"fetch",
+				"ipfs://bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
+				require.Equal(t, emptyPath, path)
+				require.Equal(t, trustlessutils.DagScopeAll, dagScope)
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4.car", outfile)
+				return nil
+			},
+		},
+		{
+			name: "with ipfs url+path+scope",
+			args: []string{
+				"fetch",
+				"ipfs://bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4/nope?dag-scope=entity",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
+				require.Equal(t, datamodel.ParsePath("birb.mp4/nope"), path)
+				require.Equal(t, trustlessutils.DagScopeEntity, dagScope)
+				return nil
+			},
+		},
+		{
+			name: "with bad ipfs url",
+			args: []string{
+				"fetch",
+				"ipfs:///birb.mp4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with glob",
+			args: []string{
+				"fetch",
+				"--glob",
+				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/logs/2024-*/errors.json",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", rootCid.String())
+				require.Equal(t, datamodel.ParsePath("logs/2024-*/errors.json"), path)
+				require.True(t, glob)
+				require.False(t, duplicates)
+				return nil
+			},
+		},
+		{
+			name: "with glob and duplicates",
+			args: []string{
+				"fetch",
+				"--glob",
+				"--duplicates",
+				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/logs/2024-*/errors.json",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with depth",
+			args: []string{
+				"fetch",
+				"--depth", "2",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/some/dir",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, datamodel.ParsePath("some/dir"), path)
+				require.Equal(t, uint64(2), depth)
+				return nil
+			},
+		},
+		{
+			name: "with nested cars",
+			args: []string{
+				"fetch",
+				"--nested-cars",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, &types.NestedCarConfig{MaxDepth: types.DefaultNestedCarMaxDepth, MaxBytes: types.DefaultNestedCarMaxBytes}, nestedCars)
+				return nil
+			},
+		},
+		{
+			name: "with nested cars bounds",
+			args: []string{
+				"fetch",
+				"--nested-cars",
+				"--nested-cars-depth", "3",
+				"--nested-cars-max-bytes", "1024",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				require.Equal(t, &types.NestedCarConfig{MaxDepth: 3, MaxBytes: 1024}, nestedCars)
+				return nil
+			},
+		},
+		{
+			name: "with nested cars depth but not nested cars",
+			args: []string{
+				"fetch",
+				"--nested-cars-depth", "3",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with expected digest",
+			args: []string{
+				"fetch",
+				"--expected-digest", "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, dataWriter io.Writer, rootCid cid.Cid, path datamodel.Path, dagScope trustlessutils.DagScope, entityBytes *trustlessutils.ByteRange, duplicates bool, glob bool, depth uint64, nestedCars *types.NestedCarConfig, expectedDigest multihash.Multihash, tempDir string, progress bool, quiet bool, resume bool, outfile string) error {
+				expected, err := multihash.FromHexString("12209f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
+				require.NoError(t, err)
+				require.Equal(t, expected, expectedDigest)
+				return nil
+			},
+		},
+		{
+			name: "with invalid expected digest",
+			args: []string{
+				"fetch",
+				"--expected-digest", "blake3:9f86d0",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
+		{
+			name: "with depth and dag-scope",
+			args: []string{
+				"fetch",
+				"--depth", "2",
+				"--dag-scope", "entity",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			},
+			shouldError: true,
+		},
 	}
 
 	fetchRunOrig := fetchRun
@@ -465,8 +701,14 @@ func noopRun(
 	dagScope trustlessutils.DagScope,
 	entityBytes *trustlessutils.ByteRange,
 	duplicates bool,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	expectedDigest multihash.Multihash,
 	tempDir string,
 	progress bool,
+	quiet bool,
+	resume bool,
 	outfile string,
 ) error {
 	return nil
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/urfave/cli/v2"
+)
+
+// batchEntry is a line of a --input file: the content to fetch and the CAR to
+// write it to.
+type batchEntry struct {
+	line        int
+	content     string
+	root        cid.Cid
+	path        datamodel.Path
+	dagScope    trustlessutils.DagScope
+	entityBytes *trustlessutils.ByteRange
+	duplicates  bool
+	outfile     string
+}
+
+// batchInputLine is an entry of a --input file as it is written, before its
+// content is parsed.
+type batchInputLine struct {
+	line    int
+	content string
+	output  string
+}
+
+// readBatchInput reads the entries of a --input file, one per line, each the
+// content to fetch in any of the forms accepted by the fetch command,
+// optionally followed by whitespace and the name of the CAR to write it to.
+// Blank lines and lines starting with '#' are skipped.
+func readBatchInput(r io.Reader) ([]batchInputLine, error) {
+	var lines []batchInputLine
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected content and an optional output name, got %q", n, line)
+		}
+		entry := batchInputLine{line: n, content: fields[0]}
+		if len(fields) == 2 {
+			entry.output = fields[1]
+		}
+		lines = append(lines, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("no content to fetch in the input")
+	}
+	return lines, nil
+}
+
+// fetchBatchAction fetches each entry of the --input file to a CAR in the
+// --output directory.
+func fetchBatchAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 0 {
+		return errors.New("content can't be given as an argument with --input")
+	}
+	if cctx.IsSet("expected-digest") {
+		return errors.New("expected-digest can't be used with --input")
+	}
+	if cctx.IsSet("resume") {
+		return errors.New("resume can't be used with --input")
+	}
+	outputDir := cctx.String("output")
+	if outputDir == stdoutFileString {
+		return errors.New("output can't be written to stdout with --input")
+	}
+	parallel := cctx.Int("parallel")
+	if parallel < 1 {
+		return errors.New("parallel must be at least 1")
+	}
+
+	var input io.Reader = os.Stdin
+	if inputFile := cctx.String("input"); inputFile != "-" {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	lines, err := readBatchInput(input)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]batchEntry, 0, len(lines))
+	outfiles := make(map[string]int, len(lines))
+	for _, l := range lines {
+		root, path, scope, byteRange, duplicates, err := fetchParams(cctx, l.content)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", l.line, err)
+		}
+		outfile := l.output
+		if outfile == "" {
+			outfile = fmt.Sprintf("%s.car", root.String())
+		}
+		if !filepath.IsAbs(outfile) {
+			outfile = filepath.Join(outputDir, outfile)
+		}
+		if other, ok := outfiles[outfile]; ok {
+			return fmt.Errorf("line %d: %s is also written by line %d, give one of them an output name", l.line, outfile, other)
+		}
+		outfiles[outfile] = l.line
+		entries = append(entries, batchEntry{
+			line:        l.line,
+			content:     l.content,
+			root:        root,
+			path:        path,
+			dagScope:    scope,
+			entityBytes: byteRange,
+			duplicates:  duplicates,
+			outfile:     outfile,
+		})
+	}
+
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	nestedCars, err := nestedCarsConfig(cctx)
+	if err != nil {
+		return err
+	}
+
+	lassieOpts, err := fetchLassieOptions(cctx)
+	if err != nil {
+		return err
+	}
+	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, lassieOpts, nil)
+	if err != nil {
+		return err
+	}
+
+	eventRecorderURL := cctx.String("event-recorder-url")
+	authToken := cctx.String("event-recorder-auth")
+	instanceID := cctx.String("event-recorder-instance-id")
+	eventRecorderCfg := getEventRecorderConfig(eventRecorderURL, authToken, instanceID)
+
+	err = fetchBatchRun(
+		cctx.Context,
+		lassieCfg,
+		eventRecorderCfg,
+		cctx.App.ErrWriter,
+		entries,
+		cctx.Bool("glob"),
+		cctx.Uint64("depth"),
+		nestedCars,
+		cctx.String("tempdir"),
+		parallel,
+	)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type fetchBatchRunFunc func(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	eventRecorderCfg *aggregateeventrecorder.EventRecorderConfig,
+	msgWriter io.Writer,
+	entries []batchEntry,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	tempDir string,
+	parallel int,
+) error
+
+var fetchBatchRun fetchBatchRunFunc = defaultFetchBatchRun
+
+// batchResult is the outcome of fetching a batchEntry.
+type batchResult struct {
+	entry  batchEntry
+	blocks int
+	stats  *types.RetrievalStats
+	err    error
+}
+
+// defaultFetchBatchRun is the handler for the fetch command with --input,
+// fetching up to parallel entries at a time with a single Lassie instance,
+// reporting each as it completes followed by a summary. It fails if any of the
+// entries can't be fetched, after attempting all of them.
+func defaultFetchBatchRun(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	eventRecorderCfg *aggregateeventrecorder.EventRecorderConfig,
+	msgWriter io.Writer,
+	entries []batchEntry,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	tempDir string,
+	parallel int,
+) error {
+	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
+	if err != nil {
+		return err
+	}
+
+	// create and subscribe an event recorder API if an endpoint URL is set
+	if eventRecorderCfg.EndpointURL != "" {
+		setupLassieEventRecorder(ctx, eventRecorderCfg, lassie)
+	}
+
+	fmt.Fprintf(msgWriter, "Fetching %d item(s), %d at a time\n", len(entries), parallel)
+
+	start := time.Now()
+	results := make([]batchResult, len(entries))
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for i, entry := range entries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i] = batchResult{entry: entry, err: ctx.Err()}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, entry batchEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := batchResult{entry: entry}
+			result.stats, result.err = fetchCar(ctx, lassie, nil, entry.root, entry.path, entry.dagScope, entry.entityBytes, entry.duplicates, glob, depth, nestedCars, nil, tempDir, entry.outfile, nil, func(int) {
+				result.blocks++
+			})
+
+			lk.Lock()
+			defer lk.Unlock()
+			results[i] = result
+			if result.err != nil {
+				fmt.Fprintf(msgWriter, "Failed %s (line %d): %s\n", entry.content, entry.line, result.err)
+			} else {
+				fmt.Fprintf(msgWriter, "Fetched %s to %s: %d blocks, %s in %s\n", entry.content, entry.outfile, result.blocks, humanize.IBytes(result.stats.Size), result.stats.Duration)
+			}
+		}(i, entry)
+	}
+	wg.Wait()
+
+	var failed []batchResult
+	var blocks int
+	var size uint64
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result)
+			continue
+		}
+		blocks += result.blocks
+		size += result.stats.Size
+	}
+	fmt.Fprintf(msgWriter, "\nFetched %d of %d item(s):\n"+
+		"\tDuration: %s\n"+
+		"\t  Blocks: %d\n"+
+		"\t   Bytes: %s\n",
+		len(entries)-len(failed),
+		len(entries),
+		time.Since(start),
+		blocks,
+		humanize.IBytes(size),
+	)
+	if len(failed) == 0 {
+		return nil
+	}
+	fmt.Fprintf(msgWriter, "\t  Failed: %d\n", len(failed))
+	for _, result := range failed {
+		fmt.Fprintf(msgWriter, "\t\tline %d, %s: %s\n", result.entry.line, result.entry.content, result.err)
+	}
+	return fmt.Errorf("%d of %d item(s) failed to fetch", len(failed), len(entries))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	a "github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	l "github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestReadBatchInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []batchInputLine
+		wantErr string
+	}{
+		{
+			name:  "content and output names",
+			input: "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4   birb.car\n",
+			want: []batchInputLine{
+				{line: 1, content: "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
+				{line: 2, content: "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4", output: "birb.car"},
+			},
+		},
+		{
+			name:  "blank lines and comments",
+			input: "# exports\n\n  ipfs://bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\t out.car \n",
+			want: []batchInputLine{
+				{line: 3, content: "ipfs://bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", output: "out.car"},
+			},
+		},
+		{
+			name:    "too many fields",
+			input:   "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4 a.car b.car\n",
+			wantErr: "line 1: expected content and an optional output name",
+		},
+		{
+			name:    "empty",
+			input:   "# nothing\n",
+			wantErr: "no content to fetch in the input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readBatchInput(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFetchCommandBatch(t *testing.T) {
+	dir := t.TempDir()
+	writeInput := func(t *testing.T, input string) string {
+		path := filepath.Join(t.TempDir(), "cids.txt")
+		require.NoError(t, os.WriteFile(path, []byte(input), 0644))
+		return path
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		args        []string
+		shouldError bool
+		assertRun   fetchBatchRunFunc
+	}{
+		{
+			name:  "with default args",
+			input: "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4?dag-scope=entity birb.car\n",
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, entries []batchEntry, glob bool, depth uint64, nestedCars *types.NestedCarConfig, tempDir string, parallel int) error {
+				require.Len(t, entries, 2)
+				require.Equal(t, 1, entries[0].line)
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", entries[0].root.String())
+				require.Equal(t, emptyPath, entries[0].path)
+				require.Equal(t, trustlessutils.DagScopeAll, entries[0].dagScope)
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4.car", entries[0].outfile)
+				require.Equal(t, datamodel.ParsePath("birb.mp4"), entries[1].path)
+				require.Equal(t, trustlessutils.DagScopeEntity, entries[1].dagScope)
+				require.Equal(t, "birb.car", entries[1].outfile)
+				require.Equal(t, 4, parallel)
+				require.False(t, glob)
+				require.Nil(t, nestedCars)
+				return nil
+			},
+		},
+		{
+			name:  "with output directory, parallel and overrides",
+			input: "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n",
+			args:  []string{"--output", filepath.Join(dir, "exports"), "--parallel", "16", "--dag-scope", "block", "--duplicates"},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, erCfg *a.EventRecorderConfig, msgWriter io.Writer, entries []batchEntry, glob bool, depth uint64, nestedCars *types.NestedCarConfig, tempDir string, parallel int) error {
+				require.Len(t, entries, 1)
+				require.Equal(t, filepath.Join(dir, "exports", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4.car"), entries[0].outfile)
+				require.Equal(t, trustlessutils.DagScopeBlock, entries[0].dagScope)
+				require.True(t, entries[0].duplicates)
+				require.Equal(t, 16, parallel)
+				require.DirExists(t, filepath.Join(dir, "exports"))
+				return nil
+			},
+		},
+		{
+			name:        "with the same output twice",
+			input:       "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4\n",
+			shouldError: true,
+		},
+		{
+			name:        "with bad content",
+			input:       "bafyfoo\n",
+			shouldError: true,
+		},
+		{
+			name:        "with content argument",
+			input:       "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n",
+			args:        []string{"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
+			shouldError: true,
+		},
+		{
+			name:        "with stdout output",
+			input:       "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n",
+			args:        []string{"--output", "-"},
+			shouldError: true,
+		},
+		{
+			name:        "with expected digest",
+			input:       "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n",
+			args:        []string{"--expected-digest", "sha2-256:0000000000000000000000000000000000000000000000000000000000000000"},
+			shouldError: true,
+		},
+		{
+			name:        "with resume",
+			input:       "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n",
+			args:        []string{"--resume", "partial.car"},
+			shouldError: true,
+		},
+		{
+			name:        "with zero parallel",
+			input:       "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4\n",
+			args:        []string{"--parallel", "0"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// fetchBatchRun is a global var that we can override for testing purposes
+			fetchBatchRun = test.assertRun
+			if test.shouldError {
+				fetchBatchRun = noopBatchRun
+			}
+
+			app := &cli.App{
+				Name:     "cli-test",
+				Flags:    fetchFlags,
+				Commands: []*cli.Command{fetchCmd},
+			}
+
+			args := append([]string{"cli-test", "fetch", "--input", writeInput(t, test.input)}, test.args...)
+			err := app.Run(args)
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopBatchRun(
+	ctx context.Context,
+	lCfg *l.LassieConfig,
+	erCfg *a.EventRecorderConfig,
+	msgWriter io.Writer,
+	entries []batchEntry,
+	glob bool,
+	depth uint64,
+	nestedCars *types.NestedCarConfig,
+	tempDir string,
+	parallel int,
+) error {
+	return nil
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"fmt"
+	"net/netip"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/filecoin-project/lassie/pkg/heyfil"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
 	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/session"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -21,6 +27,7 @@ var (
 		"lassie/retriever",
 		"lassie/httpserver",
 		"lassie/indexerlookup",
+		"lassie/ipnsresolver",
 		"lassie/bitswap",
 	}
 )
@@ -47,6 +54,21 @@ var FlagVeryVerbose = &cli.BoolFlag{
 	Action:  setLogLevel("DEBUG"),
 }
 
+// verboseLevel returns the level set by the verbose or very-verbose flags, or
+// "" if neither is set or logging is configured in the environment.
+func verboseLevel(cctx *cli.Context) string {
+	if os.Getenv("GOLOG_LOG_LEVEL") != "" {
+		return ""
+	}
+	if cctx.Bool(FlagVeryVerbose.Name) {
+		return "DEBUG"
+	}
+	if cctx.Bool(FlagVerbose.Name) {
+		return "INFO"
+	}
+	return ""
+}
+
 // setLogLevel returns a CLI Action function that sets the
 // logging level for the given subsystems to the given level.
 // It is used as an action for the verbose and very-verbose flags.
@@ -92,6 +114,41 @@ var FlagEventRecorderUrl = &cli.StringFlag{
 	EnvVars:     []string{"LASSIE_EVENT_RECORDER_URL"},
 }
 
+// FlagEventWebhookUrl asks for and provides the URL of a webhook that
+// batches of retrieval events are POSTed to as JSON.
+var FlagEventWebhookUrl = &cli.StringFlag{
+	Name:        "event-webhook-url",
+	Usage:       "the url of a webhook to POST batches of retrieval events to as JSON",
+	DefaultText: "no events will be posted",
+	EnvVars:     []string{"LASSIE_EVENT_WEBHOOK_URL"},
+}
+
+// FlagEventWebhookHeaders provides headers, such as an Authorization header,
+// to add to each request to the event webhook.
+var FlagEventWebhookHeaders = &cli.StringSliceFlag{
+	Name:    "event-webhook-header",
+	Usage:   "a header, in \"Name: value\" form, to add to each request to the event webhook, may be specified multiple times",
+	EnvVars: []string{"LASSIE_EVENT_WEBHOOK_HEADERS"},
+}
+
+// FlagAlertWebhookUrl asks for and provides the URL of a webhook that alerts
+// about allowlisted providers serving invalid data or failing persistently
+// are POSTed to as JSON.
+var FlagAlertWebhookUrl = &cli.StringFlag{
+	Name:        "alert-webhook-url",
+	Usage:       "the url of a webhook to POST alerts to as JSON when allowlisted providers serve invalid data or fail persistently",
+	DefaultText: "alerts are only logged",
+	EnvVars:     []string{"LASSIE_ALERT_WEBHOOK_URL"},
+}
+
+// FlagAlertWebhookHeaders provides headers to add to each request to the
+// alert webhook.
+var FlagAlertWebhookHeaders = &cli.StringSliceFlag{
+	Name:    "alert-webhook-header",
+	Usage:   "a header, in \"Name: value\" form, to add to each request to the alert webhook, may be specified multiple times",
+	EnvVars: []string{"LASSIE_ALERT_WEBHOOK_HEADERS"},
+}
+
 var providerBlockList map[peer.ID]bool
 var FlagExcludeProviders = &cli.StringFlag{
 	Name:        "exclude-providers",
@@ -117,6 +174,49 @@ var FlagExcludeProviders = &cli.StringFlag{
 	},
 }
 
+var providerBlockedNetworks []netip.Prefix
+var FlagExcludeNetworks = &cli.StringFlag{
+	Name:        "exclude-networks",
+	DefaultText: "All networks allowed",
+	Usage: "IP addresses or networks in CIDR notation, separated by a comma, that providers with an address in are never retrieved from, " +
+		"DNS addresses are resolved to check them. Example: 192.0.2.0/24,2001:db8::/32,198.51.100.7",
+	EnvVars: []string{"LASSIE_EXCLUDE_NETWORKS"},
+	Action: func(cctx *cli.Context, v string) error {
+		// Do nothing if given an empty string
+		if v == "" {
+			return nil
+		}
+
+		providerBlockedNetworks = make([]netip.Prefix, 0)
+		for _, v := range strings.Split(v, ",") {
+			v = strings.TrimSpace(v)
+			network, err := netip.ParsePrefix(v)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(v)
+				if addrErr != nil {
+					return fmt.Errorf("invalid network %q, expected an IP address or CIDR network", v)
+				}
+				network = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			providerBlockedNetworks = append(providerBlockedNetworks, network.Masked())
+		}
+		return nil
+	},
+}
+
+var FlagProviderBlockList = &cli.StringFlag{
+	Name: "provider-block-list",
+	Usage: "file or HTTP(S) URL of a list of provider peer IDs, one per line, that are never retrieved from, " +
+		"in addition to --exclude-providers",
+	EnvVars: []string{"LASSIE_PROVIDER_BLOCK_LIST"},
+}
+
+var FlagProviderAllowList = &cli.StringFlag{
+	Name:    "provider-allow-list",
+	Usage:   "file or HTTP(S) URL of a list of provider peer IDs, one per line, that are the only providers retrieved from",
+	EnvVars: []string{"LASSIE_PROVIDER_ALLOW_LIST"},
+}
+
 var fetchProviderAddrInfos []peer.AddrInfo
 
 var FlagAllowProviders = &cli.StringFlag{
@@ -189,6 +289,336 @@ var FlagBitswapConcurrencyPerRetrieval = &cli.IntFlag{
 	EnvVars: []string{"LASSIE_BITSWAP_CONCURRENCY_PER_RETRIEVAL"},
 }
 
+var FlagBitswapSessionIdleTimeout = &cli.DurationFlag{
+	Name: "bitswap-session-idle-timeout",
+	Usage: "share bitswap sessions between retrievals from the same providers, keeping a session for this long " +
+		"after its last retrieval, so that clustered retrievals reuse what it learned about the providers",
+	DefaultText: "sessions aren't shared",
+	EnvVars:     []string{"LASSIE_BITSWAP_SESSION_IDLE_TIMEOUT"},
+}
+
+var FlagBitswapPreloadMaxBytes = &cli.StringFlag{
+	Name: "bitswap-preload-max-bytes",
+	Usage: "maximum size of the blocks that bitswap preloads ahead of each retrieval's traversal, e.g. 64MiB, " +
+		"beyond which they are spilled to --bitswap-preload-spill-dir or, without one, preloading pauses",
+	DefaultText: "unbounded",
+	EnvVars:     []string{"LASSIE_BITSWAP_PRELOAD_MAX_BYTES"},
+}
+
+var FlagBitswapPreloadSpillDir = &cli.StringFlag{
+	Name:    "bitswap-preload-spill-dir",
+	Usage:   "directory for the temporary files holding preloaded blocks beyond --bitswap-preload-max-bytes",
+	EnvVars: []string{"LASSIE_BITSWAP_PRELOAD_SPILL_DIR"},
+}
+
+var FlagMaxBlockSize = &cli.Uint64Flag{
+	Name:    "max-block-size",
+	Usage:   "maximum size in bytes of a single block received from a provider, providers sending larger blocks are treated as failed",
+	Value:   lassie.DefaultMaxBlockSize,
+	EnvVars: []string{"LASSIE_MAX_BLOCK_SIZE"},
+}
+
+var FlagMaxCandidates = &cli.UintFlag{
+	Name:    "max-candidates",
+	Usage:   "maximum number of candidates accepted from the indexer for each retrieval, 0 means no limit",
+	EnvVars: []string{"LASSIE_MAX_CANDIDATES"},
+}
+
+var FlagMaxGraphsyncQueries = &cli.UintFlag{
+	Name:    "max-graphsync-queries",
+	Usage:   "maximum number of providers queried over graphsync for each retrieval, 0 means no limit",
+	EnvVars: []string{"LASSIE_MAX_GRAPHSYNC_QUERIES"},
+}
+
+var FlagMaxHttpQueries = &cli.UintFlag{
+	Name:    "max-http-queries",
+	Usage:   "maximum number of providers queried over HTTP for each retrieval, 0 means no limit",
+	EnvVars: []string{"LASSIE_MAX_HTTP_QUERIES"},
+}
+
+var FlagHttpRateLimit = &cli.Float64Flag{
+	Name:        "http-rate-limit",
+	Usage:       "maximum rate of requests per second made to each HTTP provider, requests over the limit are delayed",
+	DefaultText: "no limit",
+	EnvVars:     []string{"LASSIE_HTTP_RATE_LIMIT"},
+}
+
+var FlagHttpRateLimitBurst = &cli.IntFlag{
+	Name:    "http-rate-limit-burst",
+	Usage:   "number of requests that may be made to an HTTP provider at once before the rate limit applies",
+	Value:   1,
+	EnvVars: []string{"LASSIE_HTTP_RATE_LIMIT_BURST"},
+}
+
+var httpHostRateLimits map[string]retriever.HttpRateLimit
+var FlagHttpHostRateLimit = &cli.StringSliceFlag{
+	Name: "http-host-rate-limit",
+	Usage: "rate limit for a particular HTTP provider host, overriding --http-rate-limit, " +
+		"as host=rate or host=rate:burst, may be specified multiple times. Example: example.com=5:10",
+	EnvVars: []string{"LASSIE_HTTP_HOST_RATE_LIMITS"},
+	Action: func(cctx *cli.Context, v []string) error {
+		httpHostRateLimits = make(map[string]retriever.HttpRateLimit)
+		for _, hostLimit := range v {
+			host, limit, err := parseHttpHostRateLimit(hostLimit)
+			if err != nil {
+				return err
+			}
+			httpHostRateLimits[host] = limit
+		}
+		return nil
+	},
+}
+
+var FlagHttpPrewarm = &cli.IntFlag{
+	Name: "http-prewarm",
+	Usage: "number of small requests made in parallel to a high latency HTTP provider to warm up connections " +
+		"before retrieving from it, 0 disables prewarming",
+	EnvVars: []string{"LASSIE_HTTP_PREWARM"},
+}
+
+var FlagHttpPrewarmMinLatency = &cli.DurationFlag{
+	Name:        "http-prewarm-min-latency",
+	Usage:       "minimum time to first byte of an HTTP provider for its connections to be warmed up with --http-prewarm",
+	DefaultText: "every provider is warmed up",
+	EnvVars:     []string{"LASSIE_HTTP_PREWARM_MIN_LATENCY"},
+}
+
+var FlagLatencyProbes = &cli.IntFlag{
+	Name: "latency-probes",
+	Usage: "number of candidates of providers that haven't been connected to before that are probed with an HTTP HEAD " +
+		"request or libp2p ping for each retrieval, so they're ordered by their measured latency, 0 disables probing",
+	EnvVars: []string{"LASSIE_LATENCY_PROBES"},
+}
+
+var FlagLatencyProbeTimeout = &cli.DurationFlag{
+	Name:        "latency-probe-timeout",
+	Usage:       "maximum time spent probing a candidate with --latency-probes, which delays retrieving from it",
+	DefaultText: lassie.DefaultLatencyProbeTimeout.String(),
+	EnvVars:     []string{"LASSIE_LATENCY_PROBE_TIMEOUT"},
+}
+
+var FlagRegion = &cli.StringFlag{
+	Name: "region",
+	Usage: "region that lassie is running in, candidates whose providers are located in the same region with " +
+		"--region-table are preferred when they are otherwise comparable",
+	EnvVars: []string{"LASSIE_REGION"},
+}
+
+var FlagRegionTable = &cli.StringFlag{
+	Name: "region-table",
+	Usage: "file of lines of a peer ID or network prefix and its region, separated by a comma, such as " +
+		"\"203.0.113.0/24,eu-west\", that providers are located with for --region",
+	EnvVars:   []string{"LASSIE_REGION_TABLE"},
+	TakesFile: true,
+}
+
+var FlagCircuitBreakerThreshold = &cli.UintFlag{
+	Name: "circuit-breaker-threshold",
+	Usage: "number of consecutive failures of a provider after which it is suspended for --circuit-breaker-cooldown, " +
+		"doubling each time it fails again after the cooldown, 0 disables suspending providers",
+	DefaultText: fmt.Sprint(session.DefaultCircuitBreaker().Threshold),
+	EnvVars:     []string{"LASSIE_CIRCUIT_BREAKER_THRESHOLD"},
+}
+
+var FlagCircuitBreakerCooldown = &cli.DurationFlag{
+	Name:        "circuit-breaker-cooldown",
+	Usage:       "time a provider is first suspended for after --circuit-breaker-threshold consecutive failures",
+	DefaultText: session.DefaultCircuitBreaker().Cooldown.String(),
+	EnvVars:     []string{"LASSIE_CIRCUIT_BREAKER_COOLDOWN"},
+}
+
+var FlagCircuitBreakerMaxCooldown = &cli.DurationFlag{
+	Name:        "circuit-breaker-max-cooldown",
+	Usage:       "longest time a provider is suspended for by the circuit breaker",
+	DefaultText: session.DefaultCircuitBreaker().MaxCooldown.String(),
+	EnvVars:     []string{"LASSIE_CIRCUIT_BREAKER_MAX_COOLDOWN"},
+}
+
+var FlagCapabilityTTL = &cli.DurationFlag{
+	Name: "capability-ttl",
+	Usage: "how long the libp2p protocols that a provider was identified as supporting are relied on to skip " +
+		"candidates it can't serve, a negative duration disables skipping",
+	DefaultText: retriever.DefaultCapabilityTTL.String(),
+	EnvVars:     []string{"LASSIE_CAPABILITY_TTL"},
+}
+
+var FlagRetries = &cli.UintFlag{
+	Name: "retries",
+	Usage: "number of times a failed Graphsync or HTTP retrieval from a provider is retried, after --retry-backoff, " +
+		"before moving on to other providers",
+	EnvVars: []string{"LASSIE_RETRIES"},
+}
+
+var FlagRetryBackoff = &cli.DurationFlag{
+	Name:    "retry-backoff",
+	Usage:   "time waited before the first retry of a provider, doubling for each retry after it",
+	Value:   time.Second,
+	EnvVars: []string{"LASSIE_RETRY_BACKOFF"},
+}
+
+var FlagRetryMaxBackoff = &cli.DurationFlag{
+	Name:        "retry-max-backoff",
+	Usage:       "longest time waited before retrying a provider",
+	DefaultText: "no limit",
+	EnvVars:     []string{"LASSIE_RETRY_MAX_BACKOFF"},
+}
+
+var FlagRetryJitter = &cli.Float64Flag{
+	Name:    "retry-jitter",
+	Usage:   "fraction, from 0 to 1, by which the time waited before a retry is randomly lengthened or shortened",
+	Value:   0.2,
+	EnvVars: []string{"LASSIE_RETRY_JITTER"},
+}
+
+var retryProtocolPolicies map[multicodec.Code]retriever.RetryPolicy
+var retryProviderPolicies map[peer.ID]retriever.RetryPolicy
+var FlagRetryOverride = &cli.StringSliceFlag{
+	Name: "retry-override",
+	Usage: "retries for a protocol, graphsync or http, or for a provider peer ID, overriding --retries and " +
+		"--retry-backoff, as target=retries or target=retries:backoff, may be specified multiple times. Example: http=3:500ms",
+	EnvVars: []string{"LASSIE_RETRY_OVERRIDES"},
+	Action: func(cctx *cli.Context, v []string) error {
+		retryProtocolPolicies = make(map[multicodec.Code]retriever.RetryPolicy)
+		retryProviderPolicies = make(map[peer.ID]retriever.RetryPolicy)
+		for _, override := range v {
+			if err := parseRetryOverride(retryPolicyFromFlags(cctx), override); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// retryPolicyFromFlags returns the default retry policy set by the retry
+// flags.
+func retryPolicyFromFlags(cctx *cli.Context) retriever.RetryPolicy {
+	return retriever.RetryPolicy{
+		MaxRetries: cctx.Uint("retries"),
+		Backoff:    cctx.Duration("retry-backoff"),
+		MaxBackoff: cctx.Duration("retry-max-backoff"),
+		Jitter:     cctx.Float64("retry-jitter"),
+	}
+}
+
+// parseRetryOverride parses a target=retries[:backoff] override of the given
+// retry policy into the protocol or provider retry policies.
+func parseRetryOverride(policy retriever.RetryPolicy, v string) error {
+	target, value, ok := strings.Cut(v, "=")
+	if !ok || target == "" {
+		return fmt.Errorf("invalid retry override %q, expected target=retries[:backoff]", v)
+	}
+	retries, backoff, hasBackoff := strings.Cut(value, ":")
+	maxRetries, err := strconv.ParseUint(retries, 10, 0)
+	if err != nil {
+		return fmt.Errorf("invalid retries in retry override %q", v)
+	}
+	policy.MaxRetries = uint(maxRetries)
+	if hasBackoff {
+		if policy.Backoff, err = time.ParseDuration(backoff); err != nil || policy.Backoff < 0 {
+			return fmt.Errorf("invalid backoff in retry override %q", v)
+		}
+	}
+	if protocols, err := types.ParseProtocolsString(target); err == nil && len(protocols) == 1 {
+		if protocols[0] == multicodec.TransportBitswap {
+			return fmt.Errorf("invalid retry override %q, bitswap retrievals are not retried", v)
+		}
+		retryProtocolPolicies[protocols[0]] = policy
+		return nil
+	}
+	id, err := peer.Decode(target)
+	if err != nil {
+		return fmt.Errorf("invalid retry override %q, %q is neither graphsync, http nor a peer ID", v, target)
+	}
+	retryProviderPolicies[id] = policy
+	return nil
+}
+
+// parseHttpHostRateLimit parses a host=rate[:burst] rate limit override.
+func parseHttpHostRateLimit(v string) (string, retriever.HttpRateLimit, error) {
+	host, value, ok := strings.Cut(v, "=")
+	if !ok || host == "" {
+		return "", retriever.HttpRateLimit{}, fmt.Errorf("invalid HTTP host rate limit %q, expected host=rate[:burst]", v)
+	}
+	rate, burst, hasBurst := strings.Cut(value, ":")
+	limit := retriever.HttpRateLimit{Burst: 1}
+	var err error
+	if limit.RequestsPerSecond, err = strconv.ParseFloat(rate, 64); err != nil || limit.RequestsPerSecond < 0 {
+		return "", retriever.HttpRateLimit{}, fmt.Errorf("invalid rate in HTTP host rate limit %q", v)
+	}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return "", retriever.HttpRateLimit{}, fmt.Errorf("invalid burst in HTTP host rate limit %q", v)
+		}
+	}
+	return host, limit, nil
+}
+
+var scoringWeights *session.ScoringWeights
+var FlagScoringWeights = &cli.StringSliceFlag{
+	Name: "scoring-weight",
+	Usage: "weight that candidates are scored with when choosing which to retrieve from, as name=weight, where name is one of " +
+		strings.Join(scoringWeightNames, ", ") + ", may be specified multiple times. Example: bandwidth=2",
+	DefaultText: "Defaults to the built-in weights",
+	EnvVars:     []string{"LASSIE_SCORING_WEIGHTS"},
+	Action: func(cctx *cli.Context, v []string) error {
+		weights := session.DefaultScoringWeights()
+		for _, weight := range v {
+			if err := parseScoringWeight(&weights, weight); err != nil {
+				return err
+			}
+		}
+		if err := weights.Validate(); err != nil {
+			return err
+		}
+		scoringWeights = &weights
+		return nil
+	},
+}
+
+var scoringWeightNames = []string{
+	"connect-time",
+	"first-byte-time",
+	"bandwidth",
+	"large-content-bandwidth",
+	"success",
+	"graphsync-verified-deal",
+	"graphsync-fast-retrieval",
+	"region",
+}
+
+// parseScoringWeight parses a name=weight scoring weight into weights.
+func parseScoringWeight(weights *session.ScoringWeights, v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("invalid scoring weight %q, expected name=weight", v)
+	}
+	weight, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid weight in scoring weight %q", v)
+	}
+	switch name {
+	case "connect-time":
+		weights.ConnectTime = weight
+	case "first-byte-time":
+		weights.FirstByteTime = weight
+	case "bandwidth":
+		weights.Bandwidth = weight
+	case "large-content-bandwidth":
+		weights.LargeContentBandwidth = weight
+	case "success":
+		weights.Success = weight
+	case "graphsync-verified-deal":
+		weights.GraphsyncVerifiedDeal = weight
+	case "graphsync-fast-retrieval":
+		weights.GraphsyncFastRetrieval = weight
+	case "region":
+		weights.Region = weight
+	default:
+		return fmt.Errorf("unknown scoring weight %q, expected one of %s", name, strings.Join(scoringWeightNames, ", "))
+	}
+	return nil
+}
+
 var FlagGlobalTimeout = &cli.DurationFlag{
 	Name:    "global-timeout",
 	Aliases: []string{"gt"},
@@ -204,6 +634,30 @@ var FlagProviderTimeout = &cli.DurationFlag{
 	EnvVars: []string{"LASSIE_PROVIDER_TIMEOUT"},
 }
 
+var FlagRetrievalReceipts = &cli.BoolFlag{
+	Name:    "retrieval-receipts",
+	Usage:   "send a signed receipt to providers after a successful HTTP or Graphsync retrieval",
+	EnvVars: []string{"LASSIE_RETRIEVAL_RECEIPTS"},
+}
+
+// FlagIpnsGateways sets the trustless gateways that signed IPNS records are
+// fetched from when resolving an /ipns/ name. Records are validated locally.
+var FlagIpnsGateways = &cli.StringSliceFlag{
+	Name:        "ipns-gateway",
+	DefaultText: "Defaults to https://trustless-gateway.link",
+	Usage:       "trustless gateway to fetch IPNS records from, may be specified multiple times",
+	EnvVars:     []string{"LASSIE_IPNS_GATEWAYS"},
+}
+
+// FlagIpnsMaxDepth sets the maximum number of IPNS names and DNSLink domains
+// followed when resolving an /ipns/ name.
+var FlagIpnsMaxDepth = &cli.IntFlag{
+	Name:    "ipns-max-depth",
+	Usage:   "the maximum number of IPNS names and DNSLink domains followed to resolve an /ipns/ name, which fails if it loops or takes more",
+	Value:   ipnsresolver.DefaultMaxDepth,
+	EnvVars: []string{"LASSIE_IPNS_MAX_DEPTH"},
+}
+
 var FlagIPNIEndpoint = &cli.StringFlag{
 	Name:        "ipni-endpoint",
 	Aliases:     []string{"ipni"},
@@ -217,4 +671,9 @@ func ResetGlobalFlags() {
 	fetchProviderAddrInfos = make([]peer.AddrInfo, 0)
 	protocols = make([]multicodec.Code, 0)
 	providerBlockList = make(map[peer.ID]bool)
+	providerBlockedNetworks = nil
+	httpHostRateLimits = make(map[string]retriever.HttpRateLimit)
+	scoringWeights = nil
+	retryProtocolPolicies = nil
+	retryProviderPolicies = nil
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+var identityCmd = &cli.Command{
+	Name:  "identity",
+	Usage: "Manages the libp2p identity used by the daemon with --identity",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "generate",
+			Usage:     "Generates a new identity and writes it to a file",
+			UsageText: "lassie identity generate [--force] <path>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "overwrite the file if it already exists",
+				},
+			},
+			Action: identityGenerateAction,
+		},
+		{
+			Name:      "rotate",
+			Usage:     "Replaces an identity with a newly generated one, keeping a backup of the old one",
+			UsageText: "lassie identity rotate <path>",
+			Action:    identityRotateAction,
+		},
+		{
+			Name:      "show",
+			Usage:     "Prints the peer ID of an identity",
+			UsageText: "lassie identity show <path>",
+			Action:    identityShowAction,
+		},
+	},
+}
+
+func identityPathArg(cctx *cli.Context) (string, error) {
+	if cctx.Args().Len() != 1 {
+		return "", cli.Exit("expected a single identity file path", 1)
+	}
+	return cctx.Args().First(), nil
+}
+
+func identityGenerateAction(cctx *cli.Context) error {
+	path, err := identityPathArg(cctx)
+	if err != nil {
+		return err
+	}
+	if !cctx.Bool("force") {
+		if _, err := os.Stat(path); err == nil {
+			return cli.Exit(fmt.Sprintf("%s already exists, use --force to overwrite it or rotate to replace it", path), 1)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return cli.Exit(err, 1)
+		}
+	}
+	key, err := host.GenerateIdentity()
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	if err := host.WriteIdentity(path, key); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printPeerID(cctx, key)
+}
+
+func identityRotateAction(cctx *cli.Context) error {
+	path, err := identityPathArg(cctx)
+	if err != nil {
+		return err
+	}
+	oldKey, err := host.LoadIdentity(path)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	backupPath := path + ".old"
+	if err := host.WriteIdentity(backupPath, oldKey); err != nil {
+		return cli.Exit(fmt.Errorf("failed to back up identity: %w", err), 1)
+	}
+	key, err := host.GenerateIdentity()
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	if err := host.WriteIdentity(path, key); err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Fprintf(cctx.App.ErrWriter, "Replaced %s, previous identity %s backed up to %s\n", path, oldID, backupPath)
+	return printPeerID(cctx, key)
+}
+
+func identityShowAction(cctx *cli.Context) error {
+	path, err := identityPathArg(cctx)
+	if err != nil {
+		return err
+	}
+	key, err := host.LoadIdentity(path)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	return printPeerID(cctx, key)
+}
+
+func printPeerID(cctx *cli.Context, key crypto.PrivKey) error {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+	fmt.Fprintln(cctx.App.Writer, id)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/filecoin-project/lassie/pkg/unixfsextract"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/urfave/cli/v2"
+)
+
+var lsFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "write the entries as JSON",
+	},
+	FlagIPNIEndpoint,
+	FlagAllowProviders,
+	FlagExcludeProviders,
+	FlagProtocols,
+	FlagGlobalTimeout,
+	FlagProviderTimeout,
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var lsCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "Lists the entries of a UnixFS directory",
+	ArgsUsage: "<cid>[/path] | /ipfs/<cid>[/path]",
+	Description: "Retrieves only the blocks of the UnixFS directory at the given path, " +
+		"including each shard of a sharded directory, and lists the name, CID and size of " +
+		"each of its entries, without retrieving the entries themselves. The size of an " +
+		"entry is the total size of its blocks, as recorded in the directory.",
+	After:  after,
+	Action: lsAction,
+	Flags:  lsFlags,
+}
+
+func lsAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "ls", 0)
+		return nil
+	}
+
+	root, path, _, _, _, err := parseCidPath(cctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = lsRun(cctx.Context, lassieCfg, cctx.App.Writer, root, path, cctx.Bool("json"))
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type lsRunFunc func(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	dataWriter io.Writer,
+	root cid.Cid,
+	path datamodel.Path,
+	jsonOutput bool,
+) error
+
+var lsRun lsRunFunc = defaultLsRun
+
+// defaultLsRun is the handler for the ls command.
+func defaultLsRun(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	dataWriter io.Writer,
+	root cid.Cid,
+	path datamodel.Path,
+	jsonOutput bool,
+) error {
+	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
+	if err != nil {
+		return err
+	}
+
+	// the directory is small enough to hold in memory, its entries aren't
+	// retrieved
+	store := storage.NewDeferredStorageCarInMemory(root)
+	defer store.Close()
+	request, err := types.NewRequestForPath(store, root, path.String(), trustlessutils.DagScopeEntity, nil)
+	if err != nil {
+		return err
+	}
+
+	// the entity of a file is all of it, so stop as soon as the path turns out
+	// to lead to one
+	var checked bool
+	_, err = lassie.FetchNodes(ctx, request, func(p traversal.Progress, n datamodel.Node) error {
+		if checked || p.Path.String() != path.String() {
+			return nil
+		}
+		checked = true
+		if typ := unixfsType(n); typ != data.Data_Directory && typ != data.Data_HAMTShard {
+			return fmt.Errorf("%w: /%s", unixfsextract.ErrNotDirectory, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.TrustedStorage = true // verified by the retrieval
+	entries, err := unixfsextract.List(ctx, lsys, root, path)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(dataWriter)
+		enc.SetIndent("", "  ")
+		return enc.Encode(newLsReport(root, path, entries))
+	}
+	tw := tabwriter.NewWriter(dataWriter, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.Cid, humanize.IBytes(entry.Size), entry.Name)
+	}
+	return tw.Flush()
+}
+
+// unixfsType returns the UnixFS type of a block decoded as a data model node,
+// or -1 if it isn't UnixFS. A raw block is a file.
+func unixfsType(n datamodel.Node) int64 {
+	if n.Kind() == datamodel.Kind_Bytes {
+		return data.Data_Raw
+	}
+	dataNode, err := n.LookupByString("Data")
+	if err != nil {
+		return -1
+	}
+	byts, err := dataNode.AsBytes()
+	if err != nil {
+		return -1
+	}
+	ufsData, err := data.DecodeUnixFSData(byts)
+	if err != nil {
+		return -1
+	}
+	return ufsData.FieldDataType().Int()
+}
+
+type lsReport struct {
+	Root    string    `json:"root"`
+	Path    string    `json:"path"`
+	Entries []lsEntry `json:"entries"`
+}
+
+type lsEntry struct {
+	Name string `json:"name"`
+	Cid  string `json:"cid"`
+	Size uint64 `json:"size"`
+}
+
+func newLsReport(root cid.Cid, path datamodel.Path, entries []unixfsextract.Entry) lsReport {
+	report := lsReport{Root: root.String(), Path: "/" + path.String(), Entries: make([]lsEntry, 0, len(entries))}
+	for _, entry := range entries {
+		report.Entries = append(report.Entries, lsEntry{Name: entry.Name, Cid: entry.Cid.String(), Size: entry.Size})
+	}
+	return report
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	l "github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestLsCommandFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   lsRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"ls", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, root cid.Cid, path datamodel.Path, jsonOutput bool) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", root.String())
+				require.Equal(t, "", path.String())
+				require.Nil(t, lCfg.Finder)
+				require.False(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "with path, providers and json",
+			args: []string{
+				"ls",
+				"--providers",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+				"--json",
+				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birbs/2023",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, root cid.Cid, path datamodel.Path, jsonOutput bool) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", root.String())
+				require.Equal(t, "birbs/2023", path.String())
+				require.NotNil(t, lCfg.Finder)
+				require.True(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name:        "with an invalid cid",
+			args:        []string{"ls", "nope"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// lsRun is a global var that we can override for testing purposes
+		lsRun = test.assertRun
+		if test.shouldError {
+			lsRun = noopLsRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    lsFlags,
+			Commands: []*cli.Command{lsCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopLsRun(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, root cid.Cid, path datamodel.Path, jsonOutput bool) error {
+	return nil
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/dustin/go-humanize"
 	"github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	"github.com/filecoin-project/lassie/pkg/eventwebhook"
 	"github.com/filecoin-project/lassie/pkg/indexerlookup"
 	"github.com/filecoin-project/lassie/pkg/lassie"
 	"github.com/filecoin-project/lassie/pkg/net/host"
 	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/config"
@@ -33,7 +40,8 @@ func main() {
 
 		select {
 		case <-interrupt:
-			fmt.Println()
+			// stdout may be carrying a CAR, so end any progress line on stderr
+			fmt.Fprintln(os.Stderr)
 			logger.Info("received interrupt signal")
 			cancel()
 		case <-ctx.Done():
@@ -52,8 +60,19 @@ func main() {
 			FlagVeryVerbose,
 		},
 		Commands: []*cli.Command{
+			cacheCmd,
+			catCmd,
+			compareCmd,
+			conformanceCmd,
 			daemonCmd,
+			extractCmd,
 			fetchCmd,
+			identityCmd,
+			lsCmd,
+			providersCmd,
+			replayCmd,
+			verifyCmd,
+			verifyDirCmd,
 			versionCmd,
 		},
 	}
@@ -73,6 +92,7 @@ func buildLassieConfigFromCLIContext(cctx *cli.Context, lassieOpts []lassie.Lass
 	globalTimeout := cctx.Duration("global-timeout")
 	bitswapConcurrency := cctx.Int("bitswap-concurrency")
 	bitswapConcurrencyPerRetrieval := cctx.Int("bitswap-concurrency-per-retrieval")
+	maxBlockSize := cctx.Uint64("max-block-size")
 
 	lassieOpts = append(lassieOpts, lassie.WithProviderTimeout(providerTimeout))
 
@@ -80,17 +100,31 @@ func buildLassieConfigFromCLIContext(cctx *cli.Context, lassieOpts []lassie.Lass
 		lassieOpts = append(lassieOpts, lassie.WithGlobalTimeout(globalTimeout))
 	}
 
-	if len(protocols) > 0 {
-		lassieOpts = append(lassieOpts, lassie.WithProtocols(protocols))
+	if cctx.IsSet("provider-config") {
+		file, err := os.Open(cctx.String("provider-config"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot open provider config: %w", err)
+		}
+		defer file.Close()
+		providerConfigs, err := lassie.ParseProviderConfigs(file)
+		if err != nil {
+			return nil, err
+		}
+		lassieOpts = append(lassieOpts, lassie.WithProviderConfigs(providerConfigs))
 	}
 
-	host, err := host.InitHost(cctx.Context, libp2pOpts)
-	if err != nil {
-		return nil, err
+	if len(protocols) > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithProtocols(protocols))
 	}
-	lassieOpts = append(lassieOpts, lassie.WithHost(host))
 
 	if len(fetchProviderAddrInfos) > 0 {
+		// the direct candidate finder needs a host to probe providers with, so
+		// it can't be started lazily by lassie
+		host, err := host.InitHost(cctx.Context, libp2pOpts)
+		if err != nil {
+			return nil, err
+		}
+		lassieOpts = append(lassieOpts, lassie.WithHost(host))
 		finderOpt := lassie.WithFinder(retriever.NewDirectCandidateFinder(host, fetchProviderAddrInfos))
 		if cctx.IsSet("ipni-endpoint") {
 			logger.Warn("Ignoring ipni-endpoint flag since direct provider is specified")
@@ -112,10 +146,26 @@ func buildLassieConfigFromCLIContext(cctx *cli.Context, lassieOpts []lassie.Lass
 		logger.Debug("Using explicit IPNI endpoint to find candidates", "endpoint", endpoint)
 	}
 
+	if len(fetchProviderAddrInfos) == 0 && len(libp2pOpts) > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithLibp2pOpts(libp2pOpts...))
+	}
+
 	if len(providerBlockList) > 0 {
 		lassieOpts = append(lassieOpts, lassie.WithProviderBlockList(providerBlockList))
 	}
 
+	if len(providerBlockedNetworks) > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithProviderBlockedNetworks(providerBlockedNetworks...))
+	}
+
+	if cctx.IsSet("provider-block-list") || cctx.IsSet("provider-allow-list") {
+		lassieOpts = append(lassieOpts, lassie.WithProviderListSources(lassie.ProviderListSources{
+			BlockList:       cctx.String("provider-block-list"),
+			AllowList:       cctx.String("provider-allow-list"),
+			RefreshInterval: cctx.Duration("provider-list-refresh"),
+		}))
+	}
+
 	if bitswapConcurrency > 0 {
 		lassieOpts = append(lassieOpts, lassie.WithBitswapConcurrency(bitswapConcurrency))
 	}
@@ -126,9 +176,155 @@ func buildLassieConfigFromCLIContext(cctx *cli.Context, lassieOpts []lassie.Lass
 		lassieOpts = append(lassieOpts, lassie.WithBitswapConcurrencyPerRetrieval(bitswapConcurrency))
 	}
 
+	if idleTimeout := cctx.Duration("bitswap-session-idle-timeout"); idleTimeout > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithBitswapSessionPool(idleTimeout))
+	}
+
+	if preloadMaxBytes := cctx.String("bitswap-preload-max-bytes"); preloadMaxBytes != "" {
+		maxBytes, err := humanize.ParseBytes(preloadMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --bitswap-preload-max-bytes %q: %w", preloadMaxBytes, err)
+		}
+		lassieOpts = append(lassieOpts, lassie.WithBitswapPreloadBuffer(maxBytes, cctx.String("bitswap-preload-spill-dir")))
+	} else if cctx.IsSet("bitswap-preload-spill-dir") {
+		return nil, errors.New("--bitswap-preload-spill-dir requires --bitswap-preload-max-bytes")
+	}
+
+	if maxBlockSize > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithMaxBlockSize(maxBlockSize))
+	}
+
+	queryLimits := types.ProviderQueryLimits{
+		MaxCandidates:       cctx.Uint("max-candidates"),
+		MaxGraphsyncQueries: cctx.Uint("max-graphsync-queries"),
+		MaxHttpQueries:      cctx.Uint("max-http-queries"),
+	}
+	if queryLimits != (types.ProviderQueryLimits{}) {
+		lassieOpts = append(lassieOpts, lassie.WithProviderQueryLimits(queryLimits))
+	}
+
+	httpRateLimits := retriever.HttpRateLimits{
+		Default: retriever.HttpRateLimit{
+			RequestsPerSecond: cctx.Float64("http-rate-limit"),
+			Burst:             cctx.Int("http-rate-limit-burst"),
+		},
+		Hosts: httpHostRateLimits,
+	}
+	if httpRateLimits.Default.RequestsPerSecond > 0 || len(httpRateLimits.Hosts) > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithHttpRateLimits(httpRateLimits))
+	}
+
+	if prewarm := cctx.Int("http-prewarm"); prewarm > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithHttpPrewarm(retriever.HttpPrewarm{
+			Requests:   prewarm,
+			MinLatency: cctx.Duration("http-prewarm-min-latency"),
+		}))
+	}
+
+	if probes := cctx.Int("latency-probes"); probes > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithLatencyProbe(retriever.LatencyProbe{
+			MaxProbes: probes,
+			Timeout:   cctx.Duration("latency-probe-timeout"),
+		}))
+	}
+
+	if scoringWeights != nil {
+		lassieOpts = append(lassieOpts, lassie.WithScoringWeights(*scoringWeights))
+	}
+
+	region, regionTablePath := cctx.String("region"), cctx.String("region-table")
+	if regionTablePath != "" && region == "" {
+		return nil, errors.New("--region-table requires --region")
+	}
+	if region != "" {
+		var locator retriever.RegionLocator
+		if regionTablePath != "" {
+			regionTable, err := loadRegionTable(regionTablePath)
+			if err != nil {
+				return nil, err
+			}
+			locator = regionTable
+		}
+		lassieOpts = append(lassieOpts, lassie.WithRegion(region, locator))
+	}
+
+	if cctx.IsSet("circuit-breaker-threshold") || cctx.IsSet("circuit-breaker-cooldown") || cctx.IsSet("circuit-breaker-max-cooldown") {
+		circuitBreaker := session.DefaultCircuitBreaker()
+		if cctx.IsSet("circuit-breaker-threshold") {
+			circuitBreaker.Threshold = cctx.Uint("circuit-breaker-threshold")
+		}
+		if cctx.IsSet("circuit-breaker-cooldown") {
+			circuitBreaker.Cooldown = cctx.Duration("circuit-breaker-cooldown")
+		}
+		if cctx.IsSet("circuit-breaker-max-cooldown") {
+			circuitBreaker.MaxCooldown = cctx.Duration("circuit-breaker-max-cooldown")
+		}
+		lassieOpts = append(lassieOpts, lassie.WithCircuitBreaker(circuitBreaker))
+	}
+
+	retryPolicies := retriever.RetryPolicies{
+		Default:   retryPolicyFromFlags(cctx),
+		Protocols: retryProtocolPolicies,
+		Providers: retryProviderPolicies,
+	}
+	if retryPolicies.Default.MaxRetries > 0 || len(retryPolicies.Protocols) > 0 || len(retryPolicies.Providers) > 0 {
+		lassieOpts = append(lassieOpts, lassie.WithRetryPolicies(retryPolicies))
+	}
+
+	if cctx.IsSet("capability-ttl") {
+		lassieOpts = append(lassieOpts, lassie.WithCapabilityTTL(cctx.Duration("capability-ttl")))
+	}
+
+	if cctx.Bool("retrieval-receipts") {
+		lassieOpts = append(lassieOpts, lassie.WithRetrievalReceipts())
+	}
+
+	if webhookUrl := cctx.String("event-webhook-url"); webhookUrl != "" {
+		webhookCfg, err := webhookConfigFromFlags(cctx, "event", webhookUrl)
+		if err != nil {
+			return nil, err
+		}
+		lassieOpts = append(lassieOpts, lassie.WithEventWebhook(webhookCfg))
+	}
+
+	if webhookUrl := cctx.String("alert-webhook-url"); webhookUrl != "" {
+		webhookCfg, err := webhookConfigFromFlags(cctx, "alert", webhookUrl)
+		if err != nil {
+			return nil, err
+		}
+		lassieOpts = append(lassieOpts, lassie.WithAlertWebhook(webhookCfg))
+	}
+
 	return lassie.NewLassieConfig(lassieOpts...), nil
 }
 
+// webhookConfigFromFlags validates the URL of the named webhook and parses
+// the headers given with its --<name>-webhook-header flag.
+func webhookConfigFromFlags(cctx *cli.Context, name string, webhookUrl string) (eventwebhook.Config, error) {
+	if _, err := url.ParseRequestURI(webhookUrl); err != nil {
+		return eventwebhook.Config{}, fmt.Errorf("cannot parse given %s webhook URL %s as valid URL: %w", name, webhookUrl, err)
+	}
+	header := http.Header{}
+	for _, h := range cctx.StringSlice(name + "-webhook-header") {
+		headerName, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(headerName) == "" {
+			return eventwebhook.Config{}, fmt.Errorf("invalid %s webhook header %q, expected \"Name: value\"", name, h)
+		}
+		header.Add(strings.TrimSpace(headerName), strings.TrimSpace(value))
+	}
+	return eventwebhook.Config{URL: webhookUrl, Header: header}, nil
+}
+
+// loadRegionTable reads the region table given with --region-table.
+func loadRegionTable(path string) (*retriever.RegionTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open region table: %w", err)
+	}
+	defer f.Close()
+	return retriever.ParseRegionTable(f)
+}
+
 func getEventRecorderConfig(endpointURL string, authToken string, instanceID string) *aggregateeventrecorder.EventRecorderConfig {
 	return &aggregateeventrecorder.EventRecorderConfig{
 		InstanceID:            instanceID,
@@ -137,12 +333,14 @@ func getEventRecorderConfig(endpointURL string, authToken string, instanceID str
 	}
 }
 
-// setupLassieEventRecorder creates and subscribes an EventRecorder if an event recorder URL is given
+// setupLassieEventRecorder creates and subscribes an EventRecorder if an event
+// recorder URL is given, returning a function that records the events received
+// so far and stops it
 func setupLassieEventRecorder(
 	ctx context.Context,
 	cfg *aggregateeventrecorder.EventRecorderConfig,
 	lassie *lassie.Lassie,
-) {
+) func(context.Context) error {
 	if cfg.EndpointURL != "" {
 		if cfg.InstanceID == "" {
 			uuid, err := uuid.NewRandom()
@@ -155,5 +353,7 @@ func setupLassieEventRecorder(
 		eventRecorder := aggregateeventrecorder.NewAggregateEventRecorder(ctx, *cfg)
 		lassie.RegisterSubscriber(eventRecorder.RetrievalEventSubscriber())
 		logger.Infow("Reporting retrieval events to event recorder API", "url", cfg.EndpointURL, "instance_id", cfg.InstanceID)
+		return eventRecorder.Close
 	}
+	return func(context.Context) error { return nil }
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/urfave/cli/v2"
+)
+
+var providersFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "write the candidates as JSON",
+	},
+	FlagIPNIEndpoint,
+	FlagAllowProviders,
+	FlagExcludeProviders,
+	FlagExcludeNetworks,
+	FlagProviderBlockList,
+	FlagProviderAllowList,
+	FlagProtocols,
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var providersCmd = &cli.Command{
+	Name:      "providers",
+	Usage:     "Lists the providers found for a CID, without retrieving from them",
+	ArgsUsage: "<cid> | /ipfs/<cid>[/path]",
+	Description: "Runs only the candidate discovery of a fetch of <cid>, from the indexer or " +
+		"the providers given with --providers, and prints each candidate found with its " +
+		"addresses, the transports it advertises and their metadata, and the transports a " +
+		"fetch would currently use it for given the protocols enabled and the providers " +
+		"excluded. Exits with a non-zero status if no candidates are found.",
+	After:  after,
+	Action: providersAction,
+	Flags:  providersFlags,
+}
+
+func providersAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "providers", 0)
+		return nil
+	}
+
+	// candidates are found for the root, whatever the path below it
+	root, _, _, _, _, err := parseCidPath(cctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	lassieCfg, err := buildLassieConfigFromCLIContext(cctx, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = providersRun(cctx.Context, lassieCfg, cctx.App.Writer, root, cctx.Bool("json"))
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type providersRunFunc func(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	dataWriter io.Writer,
+	root cid.Cid,
+	jsonOutput bool,
+) error
+
+var providersRun providersRunFunc = defaultProvidersRun
+
+// defaultProvidersRun is the handler for the providers command.
+func defaultProvidersRun(
+	ctx context.Context,
+	lassieCfg *lassie.LassieConfig,
+	dataWriter io.Writer,
+	root cid.Cid,
+	jsonOutput bool,
+) error {
+	lassie, err := lassie.NewLassieWithConfig(ctx, lassieCfg)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := lassie.FindCandidates(ctx, root)
+	if err != nil {
+		return fmt.Errorf("failed to find candidates for %s: %w", root, err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(dataWriter)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(newProvidersReport(root, candidates)); err != nil {
+			return err
+		}
+	} else {
+		writeProvidersReport(dataWriter, root, candidates)
+	}
+
+	if len(candidates) == 0 {
+		return fmt.Errorf("no candidates found for %s", root)
+	}
+	return nil
+}
+
+type providersReport struct {
+	Root       string              `json:"root"`
+	Candidates []providerCandidate `json:"candidates"`
+}
+
+type providerCandidate struct {
+	PeerID    string             `json:"peerId"`
+	Addrs     []string           `json:"addrs"`
+	Protocols []providerProtocol `json:"protocols"`
+	// Usable lists the protocols a fetch would currently use the candidate for
+	Usable []string `json:"usable"`
+}
+
+type providerProtocol struct {
+	Protocol string            `json:"protocol"`
+	Metadata metadata.Protocol `json:"metadata"`
+}
+
+func newProvidersReport(root cid.Cid, candidates []lassie.Candidate) providersReport {
+	report := providersReport{Root: root.String(), Candidates: make([]providerCandidate, 0, len(candidates))}
+	for _, candidate := range candidates {
+		pc := providerCandidate{
+			PeerID:    candidate.MinerPeer.ID.String(),
+			Addrs:     make([]string, 0, len(candidate.MinerPeer.Addrs)),
+			Protocols: make([]providerProtocol, 0),
+			Usable:    make([]string, 0, len(candidate.Usable)),
+		}
+		for _, addr := range candidate.MinerPeer.Addrs {
+			pc.Addrs = append(pc.Addrs, addr.String())
+		}
+		for _, protocol := range candidate.Metadata.Protocols() {
+			pc.Protocols = append(pc.Protocols, providerProtocol{Protocol: protocol.String(), Metadata: candidate.Metadata.Get(protocol)})
+		}
+		for _, protocol := range candidate.Usable {
+			pc.Usable = append(pc.Usable, protocol.String())
+		}
+		report.Candidates = append(report.Candidates, pc)
+	}
+	return report
+}
+
+func writeProvidersReport(w io.Writer, root cid.Cid, candidates []lassie.Candidate) {
+	if len(candidates) == 0 {
+		fmt.Fprintf(w, "No candidates found for %s\n", root)
+		return
+	}
+	fmt.Fprintf(w, "Found %d candidate(s) for %s\n", len(candidates), root)
+
+	for _, candidate := range candidates {
+		fmt.Fprintf(w, "\n%s\n", candidate.MinerPeer.ID)
+		if len(candidate.MinerPeer.Addrs) == 0 {
+			fmt.Fprintf(w, "\tAddresses: none\n")
+		}
+		for i, addr := range candidate.MinerPeer.Addrs {
+			label := "Addresses:"
+			if i > 0 {
+				label = ""
+			}
+			fmt.Fprintf(w, "\t%-10s %s\n", label, addr)
+		}
+		for i, protocol := range candidate.Metadata.Protocols() {
+			label := "Protocols:"
+			if i > 0 {
+				label = ""
+			}
+			fmt.Fprintf(w, "\t%-10s %s%s\n", label, protocol, describeProtocolMetadata(candidate.Metadata.Get(protocol)))
+		}
+		if len(candidate.Usable) == 0 {
+			fmt.Fprintf(w, "\t%-10s none, a fetch would skip this candidate\n", "Usable:")
+		} else {
+			usable := make([]string, 0, len(candidate.Usable))
+			for _, protocol := range candidate.Usable {
+				usable = append(usable, protocol.String())
+			}
+			fmt.Fprintf(w, "\t%-10s %s\n", "Usable:", strings.Join(usable, ", "))
+		}
+	}
+}
+
+// describeProtocolMetadata describes the metadata advertised with a protocol,
+// if it has any.
+func describeProtocolMetadata(md metadata.Protocol) string {
+	gs, ok := md.(*metadata.GraphsyncFilecoinV1)
+	if !ok {
+		return ""
+	}
+	details := []string{"piece " + gs.PieceCID.String()}
+	if gs.VerifiedDeal {
+		details = append(details, "verified deal")
+	}
+	if gs.FastRetrieval {
+		details = append(details, "fast retrieval")
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
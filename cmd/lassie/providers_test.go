@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	l "github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestProvidersCommandFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   providersRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"providers", "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, root cid.Cid, jsonOutput bool) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", root.String())
+				require.Nil(t, lCfg.Finder)
+				require.False(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "with path, providers, protocols and json",
+			args: []string{
+				"providers",
+				"--providers",
+				"/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+				"--protocols",
+				"http",
+				"--json",
+				"/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4",
+			},
+			assertRun: func(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, root cid.Cid, jsonOutput bool) error {
+				require.Equal(t, "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4", root.String())
+				require.NotNil(t, lCfg.Finder)
+				require.Equal(t, []multicodec.Code{multicodec.TransportIpfsGatewayHttp}, lCfg.Protocols)
+				require.True(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name:        "with an invalid cid",
+			args:        []string{"providers", "nope"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// providersRun is a global var that we can override for testing purposes
+		providersRun = test.assertRun
+		if test.shouldError {
+			providersRun = noopProvidersRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    providersFlags,
+			Commands: []*cli.Command{providersCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestProvidersReport(t *testing.T) {
+	root := cid.MustParse("bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4")
+	pid, err := peer.Decode("12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4")
+	require.NoError(t, err)
+	candidates := []l.Candidate{{
+		RetrievalCandidate: types.NewRetrievalCandidate(
+			pid,
+			[]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/5000")},
+			root,
+			&metadata.GraphsyncFilecoinV1{PieceCID: root, VerifiedDeal: true},
+			&metadata.IpfsGatewayHttp{},
+		),
+		Usable: []multicodec.Code{multicodec.TransportIpfsGatewayHttp},
+	}}
+
+	var buf bytes.Buffer
+	writeProvidersReport(&buf, root, candidates)
+	require.Equal(t, "Found 1 candidate(s) for "+root.String()+"\n\n"+
+		pid.String()+"\n"+
+		"\tAddresses: /ip4/127.0.0.1/tcp/5000\n"+
+		"\tProtocols: transport-graphsync-filecoinv1 (piece "+root.String()+", verified deal)\n"+
+		"\t           transport-ipfs-gateway-http\n"+
+		"\tUsable:    transport-ipfs-gateway-http\n", buf.String())
+
+	byts, err := json.Marshal(newProvidersReport(root, candidates))
+	require.NoError(t, err)
+	var report map[string]interface{}
+	require.NoError(t, json.Unmarshal(byts, &report))
+	require.Equal(t, root.String(), report["root"])
+	candidate := report["candidates"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, pid.String(), candidate["peerId"])
+	require.Equal(t, []interface{}{"transport-ipfs-gateway-http"}, candidate["usable"])
+	require.Len(t, candidate["protocols"], 2)
+}
+
+func noopProvidersRun(ctx context.Context, lCfg *l.LassieConfig, dataWriter io.Writer, root cid.Cid, jsonOutput bool) error {
+	return nil
+}
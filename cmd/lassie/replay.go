@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/retriever/replay"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/urfave/cli/v2"
+)
+
+var defaultSessionConfig = session.DefaultConfig()
+
+var replayFlags = []cli.Flag{
+	&cli.DurationFlag{
+		Name:  "initial-pause",
+		Usage: "time each protocol waits after connecting to its first provider, for others to connect, before choosing one to retrieve from",
+		Value: retriever.GraphsyncDefaultInitialWait,
+	},
+	&cli.Int64Flag{
+		Name:  "seed",
+		Usage: "seed for the weighted random choice between providers, the same seed replays the same decisions",
+	},
+	&cli.Float64Flag{
+		Name:  "connect-time-weight",
+		Usage: "scoring weight of a provider's connect time",
+		Value: defaultSessionConfig.ConnectTimeWeight,
+	},
+	&cli.Float64Flag{
+		Name:  "first-byte-time-weight",
+		Usage: "scoring weight of a provider's time to first byte",
+		Value: defaultSessionConfig.FirstByteTimeWeight,
+	},
+	&cli.Float64Flag{
+		Name:  "bandwidth-weight",
+		Usage: "scoring weight of a provider's bandwidth",
+		Value: defaultSessionConfig.BandwidthWeight,
+	},
+	&cli.Float64Flag{
+		Name:  "success-weight",
+		Usage: "scoring weight of a provider's rate of success",
+		Value: defaultSessionConfig.SuccessWeight,
+	},
+	&cli.Float64Flag{
+		Name:  "verified-deal-weight",
+		Usage: "scoring weight of a graphsync provider with a verified deal",
+		Value: defaultSessionConfig.GraphsyncVerifiedDealWeight,
+	},
+	&cli.Float64Flag{
+		Name:  "fast-retrieval-weight",
+		Usage: "scoring weight of a graphsync provider offering fast retrieval",
+		Value: defaultSessionConfig.GraphsyncFastRetrievalWeight,
+	},
+	FlagVerbose,
+	FlagVeryVerbose,
+	FlagProtocols,
+	FlagExcludeProviders,
+	FlagGlobalTimeout,
+	FlagProviderTimeout,
+	FlagMaxCandidates,
+	FlagMaxGraphsyncQueries,
+	FlagMaxHttpQueries,
+}
+
+var replayCmd = &cli.Command{
+	Name:      "replay",
+	Usage:     "Replays recorded retrievals offline to show the decisions that would be made with the given settings",
+	ArgsUsage: "<scenario.json>",
+	Description: "Replays the retrievals recorded in a scenario file, with the candidates found for " +
+		"each and a model of how each provider behaves, through a simulation of Lassie's " +
+		"retrieval orchestration. No network requests are made and the retrievals run on a " +
+		"simulated clock, so settings such as timeouts and scoring weights can be tuned " +
+		"against real traffic patterns safely. Bitswap candidates are not replayed.",
+	After:  after,
+	Action: replayAction,
+	Flags:  replayFlags,
+}
+
+func replayAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "replay", 0)
+		return nil
+	}
+
+	scenario, err := replay.LoadScenario(cctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	sessionCfg := session.DefaultConfig().
+		WithProviderBlockList(providerBlockList).
+		WithConnectTimeWeight(cctx.Float64("connect-time-weight")).
+		WithFirstByteTimeWeight(cctx.Float64("first-byte-time-weight")).
+		WithBandwidthWeight(cctx.Float64("bandwidth-weight")).
+		WithSuccessWeight(cctx.Float64("success-weight")).
+		WithGraphsyncVerifiedDealWeight(cctx.Float64("verified-deal-weight")).
+		WithGraphsyncFastRetrievalWeight(cctx.Float64("fast-retrieval-weight"))
+
+	cfg := replay.Config{
+		Session:         sessionCfg,
+		Protocols:       protocols,
+		ProviderTimeout: cctx.Duration("provider-timeout"),
+		GlobalTimeout:   cctx.Duration("global-timeout"),
+		InitialPause:    cctx.Duration("initial-pause"),
+		QueryLimits: types.ProviderQueryLimits{
+			MaxCandidates:       cctx.Uint("max-candidates"),
+			MaxGraphsyncQueries: cctx.Uint("max-graphsync-queries"),
+			MaxHttpQueries:      cctx.Uint("max-http-queries"),
+		},
+		Seed: cctx.Int64("seed"),
+	}
+
+	return replayRun(cctx.Context, cctx.App.Writer, scenario, cfg)
+}
+
+type replayRunFunc func(ctx context.Context, w io.Writer, scenario replay.Scenario, cfg replay.Config) error
+
+var replayRun replayRunFunc = defaultReplayRun
+
+// defaultReplayRun is the handler for the replay command.
+func defaultReplayRun(ctx context.Context, w io.Writer, scenario replay.Scenario, cfg replay.Config) error {
+	result, err := replay.Replay(scenario, cfg)
+	if err != nil {
+		return err
+	}
+	writeReplayResult(w, result)
+	return nil
+}
+
+func writeReplayResult(w io.Writer, result replay.Result) {
+	var succeeded int
+	for i, rr := range result.Retrievals {
+		fmt.Fprintf(w, "Retrieval %d: %s (%s)\n", i+1, rr.Root, rr.Strategy)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, event := range rr.Events {
+			var protocol string
+			if pe, ok := event.(events.EventWithProtocol); ok {
+				protocol = pe.Protocol().String()
+			}
+			fmt.Fprintf(tw, "  +%s\t%s\t%s\t%s", event.Time().Sub(rr.Start), event.Code(), protocol, events.Identifier(event))
+			if fe, ok := event.(events.FailedRetrievalEvent); ok {
+				fmt.Fprintf(tw, "\t%s", fe.ErrorMessage())
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+		if rr.Err != nil {
+			fmt.Fprintf(w, "  Failed after %s: %s\n\n", rr.Duration, rr.Err)
+			continue
+		}
+		succeeded++
+		fmt.Fprintf(w, "  Retrieved from %s over %s in %s\n\n", rr.Provider, rr.Protocol, rr.Duration)
+	}
+	fmt.Fprintf(w, "%d of %d retrievals succeeded\n", succeeded, len(result.Retrievals))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/retriever/replay"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+const replayScenario = `{
+	"retrievals": [{
+		"root": {"/": "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"},
+		"size": 1048576,
+		"candidates": [
+			{"provider": "12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4", "protocols": "http"},
+			{"provider": "12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys", "protocols": "graphsync", "foundAfter": "100ms"}
+		]
+	}],
+	"providers": {
+		"12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4": {"connectLatency": "10ms", "firstByteLatency": "2s"},
+		"12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys": {"connectLatency": "10ms", "connectError": "connection refused"}
+	}
+}`
+
+func TestReplayCommandFlags(t *testing.T) {
+	scenarioPath := filepath.Join(t.TempDir(), "scenario.json")
+	require.NoError(t, os.WriteFile(scenarioPath, []byte(replayScenario), 0644))
+
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   replayRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"replay", scenarioPath},
+			assertRun: func(ctx context.Context, w io.Writer, scenario replay.Scenario, cfg replay.Config) error {
+				require.Len(t, scenario.Retrievals, 1)
+				require.Len(t, scenario.Retrievals[0].Candidates, 2)
+				require.Len(t, scenario.Providers, 2)
+				require.Equal(t, defaultProviderTimeout, cfg.ProviderTimeout)
+				require.Zero(t, cfg.GlobalTimeout)
+				require.Equal(t, retriever.GraphsyncDefaultInitialWait, cfg.InitialPause)
+				require.Empty(t, cfg.Protocols)
+				require.Zero(t, cfg.Seed)
+				require.Equal(t, types.ProviderQueryLimits{}, cfg.QueryLimits)
+				require.Equal(t, defaultSessionConfig.ConnectTimeWeight, cfg.Session.ConnectTimeWeight)
+				require.Equal(t, defaultSessionConfig.FirstByteTimeWeight, cfg.Session.FirstByteTimeWeight)
+				require.Equal(t, defaultSessionConfig.BandwidthWeight, cfg.Session.BandwidthWeight)
+				require.Equal(t, defaultSessionConfig.SuccessWeight, cfg.Session.SuccessWeight)
+				require.Equal(t, defaultSessionConfig.GraphsyncVerifiedDealWeight, cfg.Session.GraphsyncVerifiedDealWeight)
+				require.Equal(t, defaultSessionConfig.GraphsyncFastRetrievalWeight, cfg.Session.GraphsyncFastRetrievalWeight)
+				require.Empty(t, cfg.Session.ProviderBlockList)
+				return nil
+			},
+		},
+		{
+			name: "with settings",
+			args: []string{
+				"replay",
+				"--protocols", "http",
+				"--exclude-providers", "12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys",
+				"--provider-timeout", "1s",
+				"--global-timeout", "30s",
+				"--initial-pause", "50ms",
+				"--max-http-queries", "3",
+				"--seed", "42",
+				"--connect-time-weight", "0.5",
+				"--first-byte-time-weight", "2",
+				"--bandwidth-weight", "4",
+				"--success-weight", "3",
+				"--verified-deal-weight", "1",
+				"--fast-retrieval-weight", "1.5",
+				scenarioPath,
+			},
+			assertRun: func(ctx context.Context, w io.Writer, scenario replay.Scenario, cfg replay.Config) error {
+				require.Equal(t, []multicodec.Code{multicodec.TransportIpfsGatewayHttp}, cfg.Protocols)
+				blocked, err := peer.Decode("12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys")
+				require.NoError(t, err)
+				require.Equal(t, map[peer.ID]bool{blocked: true}, cfg.Session.ProviderBlockList)
+				require.Equal(t, time.Second, cfg.ProviderTimeout)
+				require.Equal(t, 30*time.Second, cfg.GlobalTimeout)
+				require.Equal(t, 50*time.Millisecond, cfg.InitialPause)
+				require.Equal(t, types.ProviderQueryLimits{MaxHttpQueries: 3}, cfg.QueryLimits)
+				require.Equal(t, int64(42), cfg.Seed)
+				require.Equal(t, 0.5, cfg.Session.ConnectTimeWeight)
+				require.Equal(t, 2.0, cfg.Session.FirstByteTimeWeight)
+				require.Equal(t, 4.0, cfg.Session.BandwidthWeight)
+				require.Equal(t, 3.0, cfg.Session.SuccessWeight)
+				require.Equal(t, 1.0, cfg.Session.GraphsyncVerifiedDealWeight)
+				require.Equal(t, 1.5, cfg.Session.GraphsyncFastRetrievalWeight)
+				return nil
+			},
+		},
+		{
+			name:        "with a missing scenario",
+			args:        []string{"replay", filepath.Join(t.TempDir(), "nope.json")},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// replayRun is a global var that we can override for testing purposes
+		replayRun = test.assertRun
+		if test.shouldError {
+			replayRun = noopReplayRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    replayFlags,
+			Commands: []*cli.Command{replayCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+	replayRun = defaultReplayRun
+}
+
+func TestReplayCommandOutput(t *testing.T) {
+	scenarioPath := filepath.Join(t.TempDir(), "scenario.json")
+	require.NoError(t, os.WriteFile(scenarioPath, []byte(replayScenario), 0644))
+
+	var out bytes.Buffer
+	app := &cli.App{
+		Name:     "cli-test",
+		Writer:   &out,
+		Commands: []*cli.Command{replayCmd},
+	}
+	require.NoError(t, app.Run([]string{"cli-test", "replay", "--provider-timeout", "1s", scenarioPath}))
+	require.Equal(t, `Retrieval 1: bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4 (balanced)
+  +0s      started-retrieval      transport-ipfs-gateway-http     12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4
+  +10ms    connected-to-provider  transport-ipfs-gateway-http     12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4
+  +100ms   started-retrieval      transport-graphsync-filecoinv1  12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys
+  +110ms   failed-retrieval       transport-graphsync-filecoinv1  12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys  unable to connect to provider: connection refused
+  +1.012s  failed-retrieval       transport-ipfs-gateway-http     12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4  timeout after 1s
+  Failed after 1.012s: unable to connect to provider: connection refused; retrieval timed out: timeout after 1s; all retrievals failed
+
+0 of 1 retrievals succeeded
+`, out.String())
+}
+
+func noopReplayRun(ctx context.Context, w io.Writer, scenario replay.Scenario, cfg replay.Config) error {
+	return nil
+}
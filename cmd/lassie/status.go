@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/mattn/go-isatty"
+)
+
+// statusInterval is how often the status line is redrawn while nothing
+// happens, to keep its elapsed time and throughput current.
+const statusInterval = 250 * time.Millisecond
+
+// clearLine returns the cursor to the start of the line and erases it.
+const clearLine = "\r\x1b[K"
+
+// isTerminal reports whether w writes to a terminal, where a status line can be
+// redrawn in place.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
+
+// fetchStatus draws a live status line for a fetch, showing the candidates
+// found, the provider and transport blocks are being received from, and the
+// blocks, bytes and throughput received so far. It is a writer for messages
+// printed while the line is shown, which are written above it.
+type fetchStatus struct {
+	writer io.Writer
+	start  time.Time
+
+	lk         sync.Mutex
+	candidates int
+	provider   string
+	transport  string
+	blocks     int
+	bytes      uint64
+	drawn      bool
+	lastDraw   time.Time
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// newFetchStatus starts a fetchStatus drawing to w, which should be a
+// terminal. Stop it with done before writing anything else to w.
+func newFetchStatus(w io.Writer) *fetchStatus {
+	s := &fetchStatus{
+		writer:  w,
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *fetchStatus) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.lk.Lock()
+			s.draw()
+			s.lk.Unlock()
+		}
+	}
+}
+
+// subscriber updates the status from the events of the retrieval.
+func (s *fetchStatus) subscriber(event types.RetrievalEvent) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	switch ret := event.(type) {
+	case events.CandidatesFoundEvent:
+		s.candidates = len(ret.Candidates())
+	case events.CandidatesFilteredEvent:
+		s.candidates = len(ret.Candidates())
+	case events.FirstByteEvent:
+		s.provider, s.transport = events.Identifier(ret), transportName(ret)
+	case events.BlockReceivedEvent:
+		s.provider, s.transport = events.Identifier(ret), transportName(ret)
+	default:
+		return
+	}
+	s.draw()
+}
+
+// onPut counts a block written to the output.
+func (s *fetchStatus) onPut(putBytes int) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.blocks++
+	s.bytes += uint64(putBytes)
+	if time.Since(s.lastDraw) >= statusInterval/2 {
+		s.draw()
+	}
+}
+
+// Write writes p above the status line.
+func (s *fetchStatus) Write(p []byte) (int, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.drawn {
+		fmt.Fprint(s.writer, clearLine)
+		s.drawn = false
+	}
+	n, err := s.writer.Write([]byte(strings.TrimPrefix(string(p), "\r")))
+	if err == nil && strings.HasSuffix(string(p), "\n") {
+		s.draw()
+	}
+	return n, err
+}
+
+// done stops redrawing the status line and erases it.
+func (s *fetchStatus) done() {
+	close(s.stop)
+	<-s.stopped
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.drawn {
+		fmt.Fprint(s.writer, clearLine)
+		s.drawn = false
+	}
+}
+
+// draw redraws the status line, the lock must be held.
+func (s *fetchStatus) draw() {
+	fmt.Fprint(s.writer, clearLine+s.line())
+	s.drawn = true
+	s.lastDraw = time.Now()
+}
+
+func (s *fetchStatus) line() string {
+	elapsed := time.Since(s.start)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %d candidate(s)", elapsed.Truncate(time.Second), s.candidates)
+	if s.provider != "" {
+		fmt.Fprintf(&b, "  [%s] (%s)", shortIdentifier(s.provider), s.transport)
+	}
+	fmt.Fprintf(&b, "  %d block(s)  %s", s.blocks, humanize.IBytes(s.bytes))
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		fmt.Fprintf(&b, "  %s/s", humanize.IBytes(uint64(float64(s.bytes)/seconds)))
+	}
+	return b.String()
+}
+
+// transportName returns the name of the transport of an event, such as
+// ipfs-gateway-http.
+func transportName(event events.EventWithProtocol) string {
+	return strings.TrimPrefix(event.Protocol().String(), "transport-")
+}
+
+// shortIdentifier shortens a peer ID to fit on the status line.
+func shortIdentifier(id string) string {
+	if len(id) <= 16 {
+		return id
+	}
+	return id[:6] + "…" + id[len(id)-6:]
+}
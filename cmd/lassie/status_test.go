@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchStatus(t *testing.T) {
+	root := cid.MustParse("bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4")
+	providerID, err := peer.Decode("12D3KooWHjcXTqqLxyoGt3DLfMbgrH9Nhm2ZEoEWnPZjCrz8aBAV")
+	require.NoError(t, err)
+	candidate := types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: providerID}, RootCid: root}
+	retrievalID, err := types.NewRetrievalID()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	status := newFetchStatus(&buf)
+	status.subscriber(events.CandidatesFound(time.Now(), retrievalID, root, []types.RetrievalCandidate{candidate, candidate, candidate}))
+	status.subscriber(events.CandidatesFiltered(time.Now(), retrievalID, root, []types.RetrievalCandidate{candidate, candidate}))
+	status.subscriber(events.BlockReceived(time.Now(), retrievalID, candidate, multicodec.TransportIpfsGatewayHttp, 1024))
+	status.onPut(1024)
+	status.onPut(1024)
+	fmt.Fprintf(status, "\rRetrieving from [%s]...\n", providerID)
+	status.done()
+
+	out := buf.String()
+	lines := strings.Split(out, clearLine)
+	last := lines[len(lines)-2]
+	require.Contains(t, last, "2 candidate(s)")
+	require.Contains(t, last, "[12D3Ko…z8aBAV] (ipfs-gateway-http)")
+	require.Contains(t, last, "2 block(s)  2.0 KiB")
+	require.Contains(t, out, clearLine+"Retrieving from [12D3KooWHjcXTqqLxyoGt3DLfMbgrH9Nhm2ZEoEWnPZjCrz8aBAV]...\n")
+	require.True(t, strings.HasSuffix(out, clearLine), "the status line should be erased when done")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/carverify"
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: "dag-scope",
+		Usage: "the scope of the DAG at the end of the path the CAR is expected " +
+			"to hold. Valid values include [all, entity, block].",
+		DefaultText: "defaults to all, or the scope of the content path",
+		Action: func(cctx *cli.Context, v string) error {
+			if _, err := trustlessutils.ParseDagScope(v); err != nil {
+				return fmt.Errorf("invalid dag-scope parameter, must be of value " +
+					"[all, entity, block]")
+			}
+			return nil
+		},
+	},
+	&cli.StringFlag{
+		Name: "entity-bytes",
+		Usage: "the byte range of a sharded file at the end of the path the CAR " +
+			"is expected to hold, of the form from:to, where from and to are byte " +
+			"offsets and to may be '*'",
+		DefaultText: "defaults to the entire file, 0:*",
+		Action: func(cctx *cli.Context, v string) error {
+			if _, err := trustlessutils.ParseByteRange(v); err != nil {
+				return fmt.Errorf("invalid entity-bytes parameter, must be of the " +
+					"form from:to, where from and to are byte offsets and to may be '*'")
+			}
+			return nil
+		},
+	},
+	&cli.BoolFlag{
+		Name:    "duplicates",
+		Usage:   "expect blocks repeated in the traversal to be repeated in the CAR",
+		Aliases: []string{"dups"},
+	},
+	&cli.BoolFlag{
+		Name: "unordered",
+		Usage: "accept the blocks in any order, and repeated or not, rather than " +
+			"in the depth-first order of a retrieval",
+	},
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "write the report as JSON",
+	},
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var verifyCmd = &cli.Command{
+	Name:      "verify",
+	Usage:     "Verifies a CAR file against a request",
+	ArgsUsage: "<file.car> [<cid>[/path] | /ipfs/<cid>[/path][?params]]",
+	Description: "Checks that the CAR file holds exactly the blocks of a retrieval of the " +
+		"given content, or of the root of the CAR if none is given, in the order and with " +
+		"the duplicates a retrieval would produce, and that every block matches its CID. " +
+		"The blocks that are missing, not part of the request, or corrupt are listed. " +
+		"Exits with a non-zero status if the CAR file fails verification.",
+	After:  after,
+	Action: verifyAction,
+	Flags:  verifyFlags,
+}
+
+func verifyAction(cctx *cli.Context) error {
+	if cctx.Args().Len() < 1 || cctx.Args().Len() > 2 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "verify", 0)
+		return nil
+	}
+
+	request := trustlessutils.Request{Scope: trustlessutils.DagScopeAll}
+	if cctx.Args().Len() == 2 {
+		root, path, scope, byteRange, duplicates, err := parseCidPath(cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+		request = trustlessutils.Request{
+			Root:       root,
+			Path:       path.String(),
+			Scope:      scope,
+			Bytes:      byteRange,
+			Duplicates: duplicates,
+		}
+	}
+
+	if cctx.IsSet("dag-scope") {
+		scope, err := trustlessutils.ParseDagScope(cctx.String("dag-scope"))
+		if err != nil {
+			return err
+		}
+		request.Scope = scope
+	}
+	if cctx.IsSet("entity-bytes") {
+		entityBytes, err := trustlessutils.ParseByteRange(cctx.String("entity-bytes"))
+		if err != nil {
+			return err
+		}
+		request.Bytes = nil
+		if !entityBytes.IsDefault() {
+			request.Bytes = &entityBytes
+		}
+	}
+	if cctx.IsSet("duplicates") {
+		request.Duplicates = cctx.Bool("duplicates")
+	}
+
+	err := verifyRun(
+		cctx.Context,
+		cctx.App.Writer,
+		cctx.Args().Get(0),
+		request,
+		!cctx.Bool("unordered"),
+		cctx.Bool("json"),
+	)
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type verifyRunFunc func(
+	ctx context.Context,
+	dataWriter io.Writer,
+	carPath string,
+	request trustlessutils.Request,
+	ordered bool,
+	jsonOutput bool,
+) error
+
+var verifyRun verifyRunFunc = defaultVerifyRun
+
+// defaultVerifyRun is the handler for the verify command.
+func defaultVerifyRun(
+	ctx context.Context,
+	dataWriter io.Writer,
+	carPath string,
+	request trustlessutils.Request,
+	ordered bool,
+	jsonOutput bool,
+) error {
+	report, err := carverify.VerifyCar(ctx, carPath, request, ordered)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(dataWriter)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeVerifyReport(dataWriter, report)
+	}
+
+	if !report.Verified() {
+		return fmt.Errorf("%s failed verification", carPath)
+	}
+	return nil
+}
+
+// writeBlockCids lists the blocks a CAR is missing, holds that it shouldn't
+// and holds corrupt, as reported by carverify.
+func writeBlockCids(w io.Writer, missing, extraneous, corrupt []cid.Cid) {
+	writeCids := func(what string, cids []cid.Cid) {
+		if len(cids) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s (%d):\n", what, len(cids))
+		for _, c := range cids {
+			fmt.Fprintf(w, "\t%s\n", c)
+		}
+	}
+	writeCids("Missing blocks", missing)
+	writeCids("Extraneous blocks", extraneous)
+	writeCids("Corrupt blocks", corrupt)
+}
+
+func writeVerifyReport(w io.Writer, report *carverify.CarReport) {
+	if report.Query != "" {
+		fmt.Fprintf(w, "Verifying %s against %s\n", report.Path, report.Query)
+	} else {
+		fmt.Fprintf(w, "Verifying %s\n", report.Path)
+	}
+	fmt.Fprintf(w, "%d blocks, %d duplicates, %s\n", report.Blocks, report.Duplicates, humanize.IBytes(report.Bytes))
+	writeBlockCids(w, report.Missing, report.Extraneous, report.Corrupt)
+
+	if report.Verified() {
+		fmt.Fprintln(w, "\nok")
+	} else {
+		fmt.Fprintf(w, "\nfailed: %s\n", report.Error)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestVerifyCommandFlags(t *testing.T) {
+	root := cid.MustParse("bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4")
+	var to int64 = 20000
+
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   verifyRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"verify", "birb.car"},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, carPath string, request trustlessutils.Request, ordered bool, jsonOutput bool) error {
+				require.Equal(t, "birb.car", carPath)
+				require.Equal(t, trustlessutils.Request{Scope: trustlessutils.DagScopeAll}, request)
+				require.True(t, ordered)
+				require.False(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "with content path",
+			args: []string{"verify", "birb.car", "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4?dag-scope=entity&entity-bytes=1000:20000&dups=y"},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, carPath string, request trustlessutils.Request, ordered bool, jsonOutput bool) error {
+				require.Equal(t, trustlessutils.Request{
+					Root:       root,
+					Path:       "birb.mp4",
+					Scope:      trustlessutils.DagScopeEntity,
+					Bytes:      &trustlessutils.ByteRange{From: 1000, To: &to},
+					Duplicates: true,
+				}, request)
+				return nil
+			},
+		},
+		{
+			name: "with overrides, unordered and json",
+			args: []string{
+				"verify",
+				"--dag-scope", "block",
+				"--entity-bytes", "0:*",
+				"--duplicates=false",
+				"--unordered",
+				"--json",
+				"birb.car",
+				"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/birb.mp4?dag-scope=entity&entity-bytes=1000:20000&dups=y",
+			},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, carPath string, request trustlessutils.Request, ordered bool, jsonOutput bool) error {
+				require.Equal(t, trustlessutils.Request{
+					Root:  root,
+					Path:  "birb.mp4",
+					Scope: trustlessutils.DagScopeBlock,
+				}, request)
+				require.False(t, ordered)
+				require.True(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid content path",
+			args:        []string{"verify", "birb.car", "/ipns/"},
+			shouldError: true,
+		},
+		{
+			name:        "with invalid dag-scope",
+			args:        []string{"verify", "--dag-scope", "nope", "birb.car"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// verifyRun is a global var that we can override for testing purposes
+		verifyRun = test.assertRun
+		if test.shouldError {
+			verifyRun = noopVerifyRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    verifyFlags,
+			Commands: []*cli.Command{verifyCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopVerifyRun(ctx context.Context, dataWriter io.Writer, carPath string, request trustlessutils.Request, ordered bool, jsonOutput bool) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/carverify"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyDirFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:      "manifest",
+		Usage:     "a JSON file mapping the paths of CAR files, relative to the directory, to the request each is expected to satisfy, with \"root\", \"path\", \"dag-scope\" and \"entity-bytes\" fields",
+		TakesFile: true,
+	},
+	&cli.IntFlag{
+		Name:  "workers",
+		Usage: "the number of CAR files to verify in parallel",
+		Value: runtime.NumCPU(),
+	},
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "write the report as JSON",
+	},
+	FlagVerbose,
+	FlagVeryVerbose,
+}
+
+var verifyDirCmd = &cli.Command{
+	Name:      "verify-dir",
+	Usage:     "Verifies the CAR files in a directory tree",
+	ArgsUsage: "<path>",
+	Description: "Walks the directory tree at <path> and verifies that each CAR file found holds " +
+		"the complete, correctly hashed DAG of its header roots and nothing more, or of the " +
+		"request given for it in the --manifest. Exits with a non-zero status if any CAR " +
+		"file fails verification.",
+	After:  after,
+	Action: verifyDirAction,
+	Flags:  verifyDirFlags,
+}
+
+func verifyDirAction(cctx *cli.Context) error {
+	if cctx.Args().Len() != 1 {
+		// "help" becomes a subcommand, clear it to deal with a urfave/cli bug
+		// Ref: https://github.com/urfave/cli/blob/v2.25.7/help.go#L253-L255
+		cctx.Command.Subcommands = nil
+		cli.ShowCommandHelpAndExit(cctx, "verify-dir", 0)
+		return nil
+	}
+
+	cfg := carverify.Config{Workers: cctx.Int("workers")}
+	if cfg.Workers <= 0 {
+		return errors.New("invalid --workers, must be a positive integer")
+	}
+	if cctx.IsSet("manifest") {
+		manifest, err := carverify.LoadManifest(cctx.String("manifest"))
+		if err != nil {
+			return err
+		}
+		cfg.Manifest = manifest
+	}
+
+	err := verifyDirRun(cctx.Context, cctx.App.Writer, cctx.Args().Get(0), cfg, cctx.Bool("json"))
+	if err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return nil
+}
+
+type verifyDirRunFunc func(
+	ctx context.Context,
+	dataWriter io.Writer,
+	dir string,
+	cfg carverify.Config,
+	jsonOutput bool,
+) error
+
+var verifyDirRun verifyDirRunFunc = defaultVerifyDirRun
+
+// defaultVerifyDirRun is the handler for the verify-dir command.
+func defaultVerifyDirRun(
+	ctx context.Context,
+	dataWriter io.Writer,
+	dir string,
+	cfg carverify.Config,
+	jsonOutput bool,
+) error {
+	report, err := carverify.VerifyDir(ctx, dir, cfg)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(dataWriter)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		writeVerifyDirReport(dataWriter, report)
+	}
+
+	if !report.Passed() {
+		return fmt.Errorf("%d of %d CAR files failed verification", report.Failed, len(report.Files))
+	}
+	return nil
+}
+
+func writeVerifyDirReport(w io.Writer, report *carverify.Report) {
+	fmt.Fprintf(w, "Checked %d CAR files in %s\n\n", len(report.Files), report.Dir)
+
+	if len(report.Files) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tRESULT\tBLOCKS\tBYTES")
+		for _, result := range report.Files {
+			outcome := "ok"
+			if !result.Verified() {
+				outcome = "failed"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", result.Path, outcome, result.Blocks, humanize.IBytes(result.Bytes))
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+
+	for _, result := range report.Files {
+		if !result.Verified() {
+			fmt.Fprintf(w, "%s failed: %s\n", result.Path, result.Error)
+			writeBlockCids(w, result.Missing, result.Extraneous, result.Corrupt)
+			fmt.Fprintln(w)
+		}
+	}
+
+	fmt.Fprintf(w, "%d verified, %d failed, %d blocks, %s\n", report.Verified, report.Failed, report.Blocks, humanize.IBytes(report.Bytes))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/carverify"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestVerifyDirCommandFlags(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(`{"birb.car":{"path":"birb.mp4","dag-scope":"entity"}}`), 0644))
+	invalidManifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, os.WriteFile(invalidManifestPath, []byte(`{"birb.car":{"dag-scope":"nope"}}`), 0644))
+
+	tests := []struct {
+		name        string
+		args        []string
+		shouldError bool
+		assertRun   verifyDirRunFunc
+	}{
+		{
+			name: "with default args",
+			args: []string{"verify-dir", "/archive"},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, dir string, cfg carverify.Config, jsonOutput bool) error {
+				require.Equal(t, "/archive", dir)
+				require.Positive(t, cfg.Workers)
+				require.Nil(t, cfg.Manifest)
+				require.False(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name: "with manifest, workers and json",
+			args: []string{"verify-dir", "--manifest", manifestPath, "--workers", "3", "--json", "/archive"},
+			assertRun: func(ctx context.Context, dataWriter io.Writer, dir string, cfg carverify.Config, jsonOutput bool) error {
+				require.Equal(t, 3, cfg.Workers)
+				require.Equal(t, carverify.Manifest{"birb.car": {Path: "birb.mp4", DagScope: "entity"}}, cfg.Manifest)
+				require.True(t, jsonOutput)
+				return nil
+			},
+		},
+		{
+			name:        "with invalid manifest",
+			args:        []string{"verify-dir", "--manifest", invalidManifestPath, "/archive"},
+			shouldError: true,
+		},
+		{
+			name:        "with zero workers",
+			args:        []string{"verify-dir", "--workers", "0", "/archive"},
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		// verifyDirRun is a global var that we can override for testing purposes
+		verifyDirRun = test.assertRun
+		if test.shouldError {
+			verifyDirRun = noopVerifyDirRun
+		}
+
+		app := &cli.App{
+			Name:     "cli-test",
+			Flags:    verifyDirFlags,
+			Commands: []*cli.Command{verifyDirCmd},
+		}
+
+		t.Run(test.name, func(t *testing.T) {
+			err := app.Run(append([]string{"cli-test"}, test.args...))
+			if err != nil && !test.shouldError {
+				t.Fatal(err)
+			}
+
+			if err == nil && test.shouldError {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func noopVerifyDirRun(ctx context.Context, dataWriter io.Writer, dir string, cfg carverify.Config, jsonOutput bool) error {
+	return nil
+}
@@ -0,0 +1,57 @@
+// Package accesslog writes a JSON record of each request served by the
+// daemon, one per line, describing the client, the response and, for
+// retrievals, the content requested and where it was retrieved from, so that
+// traffic can be audited and analysed without enabling debug logging.
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Entry describes a request and the response to it. The retrieval fields are
+// only set for requests that started a retrieval, or were served from the
+// daemon's response cache.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"clientIp"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Status   int       `json:"status"`
+	Bytes    uint64    `json:"bytes"`
+	Duration string    `json:"duration"`
+
+	RetrievalID string `json:"retrievalId,omitempty"`
+	Root        string `json:"root,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	Provider    string `json:"provider,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	// Cache is "hit" or "miss" for a request that may be served from the
+	// daemon's response cache.
+	Cache string `json:"cache,omitempty"`
+	// APIKey is the name of the API key that the request authorized with, if
+	// any.
+	APIKey string `json:"apiKey,omitempty"`
+}
+
+// Log writes Entries to an io.Writer as JSON, one per line. It is safe for
+// concurrent use.
+type Log struct {
+	lk  sync.Mutex
+	enc *json.Encoder
+}
+
+// New returns a Log writing to w, for example os.Stdout or a RotatingFile.
+func New(w io.Writer) *Log {
+	return &Log{enc: json.NewEncoder(w)}
+}
+
+// Write writes an entry to the log.
+func (l *Log) Write(entry Entry) error {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.enc.Encode(entry)
+}
@@ -0,0 +1,101 @@
+package accesslog_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/accesslog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := accesslog.New(&buf)
+	entries := []accesslog.Entry{
+		{Time: time.Unix(0, 0).UTC(), ClientIP: "192.0.2.1", Method: "GET", URL: "/healthz", Status: 200, Bytes: 10, Duration: "1ms"},
+		{Time: time.Unix(1, 0).UTC(), ClientIP: "192.0.2.2", Method: "GET", URL: "/ipfs/bafy", Status: 200, Bytes: 1024, Duration: "2s", RetrievalID: "id", Root: "bafy", Scope: "all", Provider: "12D3Koo", Protocol: "transport-bitswap"},
+	}
+	for _, entry := range entries {
+		require.NoError(t, log.Write(entry))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	// retrieval fields are omitted from requests that aren't retrievals
+	require.NotContains(t, lines[0], "retrievalId")
+	for i, line := range lines {
+		var entry accesslog.Entry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		require.Equal(t, entries[i], entry)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	read := func(path string) []string {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return lines
+	}
+
+	f, err := accesslog.OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"aaaa", "bbbb", "cccc", "dddd", "eeee", "ffff", "gggg"} {
+		_, err := f.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	// two lines fit in each file, the oldest beyond the backups are removed
+	require.Equal(t, []string{"gggg"}, read(path))
+	require.Equal(t, []string{"eeee", "ffff"}, read(path+".1"))
+	require.Equal(t, []string{"cccc", "dddd"}, read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// reopened, the file is appended to and its size counts towards rotation
+	f, err = accesslog.OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hhhh\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("iiii\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, []string{"iiii"}, read(path))
+	require.Equal(t, []string{"gggg", "hhhh"}, read(path+".1"))
+	_, err = f.Write([]byte("jjjj\n"))
+	require.ErrorIs(t, err, os.ErrClosed)
+
+	// without backups the file is truncated
+	f, err = accesslog.OpenRotatingFile(path, 10, 0)
+	require.NoError(t, err)
+	for _, line := range []string{"kkkk", "llll"} {
+		_, err := f.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	require.Equal(t, []string{"llll"}, read(path))
+	require.Equal(t, []string{"gggg", "hhhh"}, read(path+".1"))
+
+	// a single write larger than the maximum isn't split
+	f, err = accesslog.OpenRotatingFile(filepath.Join(t.TempDir(), "big.log"), 10, 1)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("mmmmmmmmmmmmmmmmmmmm\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = accesslog.OpenRotatingFile(path, 10, -1)
+	require.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+)
+
+var logger = logging.Subsystem("lassie/accesslog")
+
+// RotatingFile is an io.WriteCloser appending to a file, which is rotated once
+// it reaches a maximum size: the file is renamed with a ".1" suffix, earlier
+// rotations are renamed from ".1" to ".2" and so on, and the oldest beyond the
+// number of backups kept are removed. It is safe for concurrent use; a single
+// write is never split across files.
+type RotatingFile struct {
+	path       string
+	maxSize    uint64
+	maxBackups int
+
+	lk   sync.Mutex
+	file *os.File
+	size uint64
+}
+
+var _ io.WriteCloser = (*RotatingFile)(nil)
+
+// OpenRotatingFile opens the file at path for appending, creating it if it
+// doesn't exist. A maxSize of zero never rotates the file, and with a
+// maxBackups of zero the file is truncated rather than rotated.
+func OpenRotatingFile(path string, maxSize uint64, maxBackups int) (*RotatingFile, error) {
+	if maxBackups < 0 {
+		return nil, errors.New("number of backups must not be negative")
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = uint64(info.Size())
+	return nil
+}
+
+func (f *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// rotate moves the current file aside and opens a new one, or reopens the
+// current one if it can't be moved. Must be called with the lock held.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		logger.Warnw("Failed to close access log", "path", f.path, "err", err)
+	}
+	if err := f.moveAside(); err != nil {
+		logger.Warnw("Failed to rotate access log", "path", f.path, "err", err)
+	}
+	if err := f.open(); err != nil {
+		f.file = nil
+		return err
+	}
+	return nil
+}
+
+func (f *RotatingFile) moveAside() error {
+	if f.maxBackups == 0 {
+		return os.Remove(f.path)
+	}
+	if err := os.Remove(f.backup(f.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for n := f.maxBackups - 1; n > 0; n-- {
+		if err := os.Rename(f.backup(n), f.backup(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.backup(1))
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+uint64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("failed to reopen %s: %w", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += uint64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
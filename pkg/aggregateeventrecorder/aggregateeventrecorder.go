@@ -1,24 +1,21 @@
 package aggregateeventrecorder
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"sync"
 	"time"
 
 	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/types"
-	"github.com/ipfs/go-log/v2"
 	"github.com/multiformats/go-multicodec"
 )
 
-var logger = log.Logger("lassie/aggregateeventrecorder")
+var logger = logging.Subsystem("lassie/aggregateeventrecorder")
 
 const (
 	httpTimeout     = 5 * time.Second // The timeout for HTTP requests
-	parallelPosters = 5               // The number of goroutines to use for posting events to the event recorder service
+	parallelPosters = 5               // The number of goroutines to use for recording events with the sink
 )
 
 type tempData struct {
@@ -74,31 +71,41 @@ type batchedEvents struct {
 }
 
 type aggregateEventRecorder struct {
-	ctx                   context.Context
-	instanceID            string                    // The ID of the instance generating the event
-	endpointURL           string                    // The URL to POST the events to
-	endpointAuthorization string                    // The key to use in an Authorization header
-	ingestChan            chan types.RetrievalEvent // A channel for incoming events
-	postChan              chan []AggregateEvent     // A channel for posting events
+	ctx        context.Context
+	instanceID string                    // The ID of the instance generating the event
+	sink       Sink                      // The sink to record the events with
+	ingestChan chan types.RetrievalEvent // A channel for incoming events
+	postChan   chan []AggregateEvent     // A channel for posting events
+	closing    chan struct{}             // Closed to stop ingesting and post what's left
+	closeOnce  sync.Once
+	posters    sync.WaitGroup
 }
 
 type EventRecorderConfig struct {
 	InstanceID            string
 	EndpointURL           string
 	EndpointAuthorization string
+	// Sink records the batches of aggregate events, defaulting to an HttpSink
+	// for the EndpointURL and EndpointAuthorization when nil.
+	Sink Sink
 }
 
 func NewAggregateEventRecorder(ctx context.Context, eventRecorderConfig EventRecorderConfig) *aggregateEventRecorder {
+	sink := eventRecorderConfig.Sink
+	if sink == nil {
+		sink = NewHttpSink(eventRecorderConfig.EndpointURL, eventRecorderConfig.EndpointAuthorization)
+	}
 	recorder := &aggregateEventRecorder{
-		ctx:                   ctx,
-		instanceID:            eventRecorderConfig.InstanceID,
-		endpointURL:           eventRecorderConfig.EndpointURL,
-		endpointAuthorization: eventRecorderConfig.EndpointAuthorization,
-		ingestChan:            make(chan types.RetrievalEvent),
-		postChan:              make(chan []AggregateEvent),
+		ctx:        ctx,
+		instanceID: eventRecorderConfig.InstanceID,
+		sink:       sink,
+		ingestChan: make(chan types.RetrievalEvent),
+		postChan:   make(chan []AggregateEvent),
+		closing:    make(chan struct{}),
 	}
 
 	go recorder.ingestEvents()
+	recorder.posters.Add(parallelPosters)
 	for i := 0; i < parallelPosters; i++ {
 		go recorder.postEvents()
 	}
@@ -106,18 +113,38 @@ func NewAggregateEventRecorder(ctx context.Context, eventRecorderConfig EventRec
 	return recorder
 }
 
-// RetrievalEventSubsciber returns a RetrievalEventSubscriber that POSTs
-// a batch of aggregated retrieval events to an event recorder API endpoint
+// RetrievalEventSubsciber returns a RetrievalEventSubscriber that records
+// batches of aggregated retrieval events with the sink, by default POSTing
+// them to an event recorder API endpoint
 func (a *aggregateEventRecorder) RetrievalEventSubscriber() types.RetrievalEventSubscriber {
 	return func(event types.RetrievalEvent) {
 		// Process the incoming event
 		select {
 		case <-a.ctx.Done():
+		case <-a.closing:
 		case a.ingestChan <- event:
 		}
 	}
 }
 
+// Close stops recording events and waits until the aggregate events of the
+// retrievals that have already finished have been recorded with the sink, or
+// the context is done. Events received after Close are dropped.
+func (a *aggregateEventRecorder) Close(ctx context.Context) error {
+	a.closeOnce.Do(func() { close(a.closing) })
+	posted := make(chan struct{})
+	go func() {
+		a.posters.Wait()
+		close(posted)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-posted:
+		return nil
+	}
+}
+
 // ingestEvents receives and stores event data from ingestChan
 // to generate an aggregated event upon receiving a finished event.
 func (a *aggregateEventRecorder) ingestEvents() {
@@ -130,6 +157,18 @@ func (a *aggregateEventRecorder) ingestEvents() {
 		case <-a.ctx.Done():
 			return
 
+		// Hand the last batch to the posters and have them stop once it's
+		// recorded
+		case <-a.closing:
+			if len(batchedData) > 0 {
+				select {
+				case <-a.ctx.Done():
+				case a.postChan <- batchedData:
+				}
+			}
+			close(a.postChan)
+			return
+
 		// Read incoming data
 		case event := <-a.ingestChan:
 			id := event.RetrievalId()
@@ -161,7 +200,7 @@ func (a *aggregateEventRecorder) ingestEvents() {
 			tempData, ok := eventTempMap[id]
 			if !ok {
 				if event.Code() == types.FinishedCode {
-					logger.Errorf("Received Finished event but can't find aggregate data. Skipping creation of aggregate event.")
+					logger.Errorw("Received Finished event but can't find aggregate data. Skipping creation of aggregate event.", logging.RetrievalIDKey, event.RetrievalId())
 				}
 				continue
 			}
@@ -288,46 +327,20 @@ func (a *aggregateEventRecorder) ingestEvents() {
 }
 
 // postEvents receives batched aggregated events from postChan
-// and sends a POST request to the provided endpointURL. If an
-// endpointAuthorization is provided, it's used in the Authorization
-// header.
+// and records them with the sink.
 func (a *aggregateEventRecorder) postEvents() {
-	client := http.Client{Timeout: httpTimeout}
-
+	defer a.posters.Done()
 	for {
 		select {
 		case <-a.ctx.Done():
 			return
 
-		case batchedData := <-a.postChan:
-			byts, err := json.Marshal(batchedEvents{batchedData})
-			if err != nil {
-				logger.Errorf("Failed to JSONify and encode event: %w", err.Error())
-				continue
-			}
-
-			req, err := http.NewRequest("POST", a.endpointURL, bytes.NewBufferString(string(byts)))
-			if err != nil {
-				logger.Errorf("Failed to create POST request for [%s]: %w", a.endpointURL, err.Error())
-				continue
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-
-			// set authorization header if configured
-			if a.endpointAuthorization != "" {
-				req.Header.Set("Authorization", fmt.Sprintf("Basic %s", a.endpointAuthorization))
-			}
-
-			resp, err := client.Do(req)
-			if err != nil {
-				logger.Errorf("Failed to POST event to [%s]: %w", a.endpointURL, err.Error())
-				continue
+		case batchedData, ok := <-a.postChan:
+			if !ok {
+				return
 			}
-
-			defer resp.Body.Close() // error not so important at this point
-			if resp.StatusCode < 200 || resp.StatusCode > 299 {
-				logger.Errorf("Expected success response code from server, got: %s", http.StatusText(resp.StatusCode))
+			if err := a.sink.RecordEvents(a.ctx, batchedData); err != nil {
+				logger.Errorw("Failed to record aggregate events", "events", len(batchedData), "err", err)
 			}
 		}
 	}
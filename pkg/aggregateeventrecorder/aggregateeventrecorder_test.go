@@ -1,9 +1,12 @@
 package aggregateeventrecorder_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -198,6 +201,92 @@ func TestAggregateEventRecorder(t *testing.T) {
 	}
 }
 
+type chanSink chan []aggregateeventrecorder.AggregateEvent
+
+func (cs chanSink) RecordEvents(ctx context.Context, events []aggregateeventrecorder.AggregateEvent) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case cs <- events:
+		return nil
+	}
+}
+
+func TestAggregateEventRecorderSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sink := make(chanSink, 1)
+	subscriber := aggregateeventrecorder.NewAggregateEventRecorder(
+		ctx,
+		aggregateeventrecorder.EventRecorderConfig{InstanceID: "test-instance", Sink: sink},
+	).RetrievalEventSubscriber()
+	testCid := testutil.GenerateCid()
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	clock := clock.NewMock()
+	subscriber(events.StartedFetch(clock.Now(), id, testCid, "/applesauce", multicodec.TransportBitswap))
+	clock.Add(time.Second)
+	subscriber(events.Finished(clock.Now(), id, types.RetrievalCandidate{RootCid: testCid}))
+
+	var recorded []aggregateeventrecorder.AggregateEvent
+	select {
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	case recorded = <-sink:
+	}
+	require.Len(t, recorded, 1)
+	require.Equal(t, "test-instance", recorded[0].InstanceID)
+	require.Equal(t, id.String(), recorded[0].RetrievalID)
+	require.Equal(t, []string{"transport-bitswap"}, recorded[0].ProtocolsAllowed)
+	require.Equal(t, time.Second, recorded[0].EndTime.Sub(recorded[0].StartTime))
+
+	// the same batch written as newline delimited JSON
+	var buf bytes.Buffer
+	require.NoError(t, aggregateeventrecorder.NewNDJSONSink(&buf).RecordEvents(ctx, append(recorded, recorded...)))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		var event aggregateeventrecorder.AggregateEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		require.Equal(t, id.String(), event.RetrievalID)
+	}
+}
+
+func TestAggregateEventRecorderClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sink := make(chanSink, 10)
+	recorder := aggregateeventrecorder.NewAggregateEventRecorder(
+		ctx,
+		aggregateeventrecorder.EventRecorderConfig{InstanceID: "test-instance", Sink: sink},
+	)
+	subscriber := recorder.RetrievalEventSubscriber()
+	testCid := testutil.GenerateCid()
+	for i := 0; i < 3; i++ {
+		id, err := types.NewRetrievalID()
+		require.NoError(t, err)
+		subscriber(events.StartedFetch(time.Now(), id, testCid, "", multicodec.TransportBitswap))
+		subscriber(events.Finished(time.Now(), id, types.RetrievalCandidate{RootCid: testCid}))
+	}
+
+	// every finished retrieval has been recorded once closed
+	require.NoError(t, recorder.Close(ctx))
+	close(sink)
+	var recorded int
+	for batch := range sink {
+		recorded += len(batch)
+	}
+	require.Equal(t, 3, recorded)
+
+	// events are dropped once closed, rather than blocking
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	subscriber(events.StartedFetch(time.Now(), id, testCid, "", multicodec.TransportBitswap))
+	require.NoError(t, recorder.Close(ctx))
+}
+
 func verifyListNode(t *testing.T, node datamodel.Node, key string, expectedLength int64) datamodel.Node {
 	subNode, err := node.LookupByString(key)
 	require.NoError(t, err)
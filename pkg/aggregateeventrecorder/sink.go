@@ -0,0 +1,93 @@
+package aggregateeventrecorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Sink receives the batches of aggregate events generated by an aggregate
+// event recorder, such as the HttpSink for the hosted event recorder service,
+// or an operator's own sink writing to a queue or log. RecordEvents is called
+// concurrently for different batches, and errors are logged and the batch
+// dropped.
+type Sink interface {
+	RecordEvents(ctx context.Context, events []AggregateEvent) error
+}
+
+var _ Sink = (*HttpSink)(nil)
+var _ Sink = (*NDJSONSink)(nil)
+
+// HttpSink POSTs each batch of events as JSON to an event recorder API
+// endpoint.
+type HttpSink struct {
+	endpointURL           string
+	endpointAuthorization string
+	client                *http.Client
+}
+
+// NewHttpSink creates a new HttpSink posting to the given endpoint URL. If an
+// endpoint authorization is provided, it's used in a Basic Authorization
+// header.
+func NewHttpSink(endpointURL string, endpointAuthorization string) *HttpSink {
+	return &HttpSink{
+		endpointURL:           endpointURL,
+		endpointAuthorization: endpointAuthorization,
+		client:                &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (hs *HttpSink) RecordEvents(ctx context.Context, events []AggregateEvent) error {
+	byts, err := json.Marshal(batchedEvents{events})
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hs.endpointURL, bytes.NewReader(byts))
+	if err != nil {
+		return fmt.Errorf("failed to create POST request for %s: %w", hs.endpointURL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// set authorization header if configured
+	if hs.endpointAuthorization != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", hs.endpointAuthorization))
+	}
+
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to POST events to %s: %w", hs.endpointURL, err)
+	}
+	defer resp.Body.Close() // error not so important at this point
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("expected success response code from %s, got %s", hs.endpointURL, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// NDJSONSink writes each event as a line of JSON to a writer, such as
+// os.Stdout or a file tailed by a log shipper.
+type NDJSONSink struct {
+	lk  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewNDJSONSink creates a new NDJSONSink writing to the given writer.
+func NewNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{enc: json.NewEncoder(w)}
+}
+
+func (ns *NDJSONSink) RecordEvents(ctx context.Context, events []AggregateEvent) error {
+	ns.lk.Lock()
+	defer ns.lk.Unlock()
+	for _, event := range events {
+		if err := ns.enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+/*
+Package blockcache keeps blocks on disk, bounded in total size, so that the
+blocks fetched by one retrieval can be used to serve later retrievals that need
+them without fetching them again. Blocks are keyed by the multihash of their
+CID, so the same block is shared by CIDs of different versions and codecs, and
+the least recently used are evicted first once the cache is full. Blocks are
+checked against their CIDs when they are read back.
+*/
+package blockcache
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base32"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var logger = logging.Subsystem("lassie/blockcache")
+
+const (
+	entrySuffix = ".data"
+	tempSuffix  = ".tmp"
+)
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type entry struct {
+	key  string
+	size uint64
+}
+
+// Cache is a size-bounded cache of blocks in a directory. It is safe for
+// concurrent use.
+type Cache struct {
+	dir     string
+	maxSize uint64
+
+	lk      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // most recently used at the front
+	size    uint64
+}
+
+// New returns a Cache of up to maxSize bytes of blocks in dir, creating the
+// directory if needed. Blocks already in the directory, from an earlier Cache,
+// are kept, the least recently modified being the first evicted.
+func New(dir string, maxSize uint64) (*Cache, error) {
+	if maxSize == 0 {
+		return nil, errors.New("cache size must be greater than zero")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	var existing []fs.FileInfo
+	err := filepath.WalkDir(dir, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil || dirEntry.IsDir() {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(dirEntry.Name(), tempSuffix):
+			// an incomplete block from a Cache that didn't shut down cleanly
+			if err := os.Remove(path); err != nil {
+				logger.Warnw("Failed to remove incomplete block", "file", path, "err", err)
+			}
+		case strings.HasSuffix(dirEntry.Name(), entrySuffix):
+			info, err := dirEntry.Info()
+			if err != nil {
+				return err
+			}
+			existing = append(existing, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].ModTime().Before(existing[j].ModTime()) })
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	for _, info := range existing {
+		c.add(strings.TrimSuffix(info.Name(), entrySuffix), uint64(info.Size()))
+	}
+	c.evict()
+	return c, nil
+}
+
+// key returns the name under which the block with the CID is stored, the
+// lower-case base32 of its multihash.
+func key(c cid.Cid) string {
+	return strings.ToLower(keyEncoding.EncodeToString(c.Hash()))
+}
+
+// path returns the file of a block, in a directory named for the next to last
+// two characters of its key, so that no directory grows too large.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[len(key)-3:len(key)-1], key+entrySuffix)
+}
+
+// Has returns true if the block with the CID is cached.
+func (c *Cache) Has(blockCid cid.Cid) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	_, ok := c.entries[key(blockCid)]
+	return ok
+}
+
+// Get returns the block with the CID, or false if it isn't cached, marking it
+// as the most recently used. A block whose data no longer matches its CID,
+// such as one corrupted on disk, is dropped from the cache rather than
+// returned, so that it can be put again.
+func (c *Cache) Get(blockCid cid.Cid) ([]byte, bool) {
+	k := key(blockCid)
+	c.lk.Lock()
+	elem, ok := c.entries[k]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.lk.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(k))
+	if errors.Is(err, os.ErrNotExist) {
+		// evicted since
+		return nil, false
+	}
+	if err == nil {
+		var sum cid.Cid
+		if sum, err = blockCid.Prefix().Sum(data); err == nil && !bytes.Equal(sum.Hash(), blockCid.Hash()) {
+			err = errors.New("data doesn't match CID")
+		}
+	}
+	if err != nil {
+		logger.Warnw("Dropping unreadable cached block", "cid", blockCid, "err", err)
+		c.lk.Lock()
+		if elem, ok := c.entries[k]; ok {
+			c.remove(elem)
+		}
+		c.lk.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// Put adds the block with the CID to the cache, evicting the least recently
+// used blocks to make room for it. Blocks already cached are only marked as
+// the most recently used, and identity CIDs, whose data is in the CID itself,
+// and blocks larger than the cache are ignored.
+func (c *Cache) Put(blockCid cid.Cid, data []byte) error {
+	if blockCid.Prefix().MhType == multihash.IDENTITY || uint64(len(data)) > c.maxSize {
+		return nil
+	}
+	k := key(blockCid)
+	c.lk.Lock()
+	if elem, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(elem)
+		c.lk.Unlock()
+		return nil
+	}
+	c.lk.Unlock()
+
+	path := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), k+"-*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if err == nil {
+		// renamed with the lock held, so that the block can't be evicted by
+		// another Put between being written and being added
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	c.add(k, uint64(len(data)))
+	c.evict()
+	return nil
+}
+
+// Size returns the total size of the blocks in the cache.
+func (c *Cache) Size() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.size
+}
+
+// Len returns the number of blocks in the cache.
+func (c *Cache) Len() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.lru.Len()
+}
+
+// Clear removes every block from the cache, returning the number removed.
+func (c *Cache) Clear() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	n := c.lru.Len()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return n
+}
+
+// add records a block in the directory as the most recently used. Must be
+// called with the lock held.
+func (c *Cache) add(key string, size uint64) {
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*entry).size
+		elem.Value.(*entry).size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&entry{key: key, size: size})
+	}
+	c.size += size
+}
+
+// remove drops a block from the cache. Must be called with the lock held.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if err := os.Remove(c.path(e.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warnw("Failed to remove cached block", "key", e.key, "err", err)
+	}
+}
+
+// evict drops the least recently used blocks until the cache is within its
+// size. Must be called with the lock held.
+func (c *Cache) evict() {
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
@@ -0,0 +1,146 @@
+package blockcache_test
+
+import (
+	"encoding/base32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func block(t *testing.T, data string) cid.Cid {
+	c, err := cid.V1Builder{Codec: uint64(multicodec.Raw), MhType: multihash.SHA2_256}.Sum([]byte(data))
+	require.NoError(t, err)
+	return c
+}
+
+// blockFile returns the file in which the block with the CID is cached.
+func blockFile(dir string, c cid.Cid) string {
+	key := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.Hash()))
+	return filepath.Join(dir, key[len(key)-3:len(key)-1], key+".data")
+}
+
+func files(t *testing.T, dir string, suffix string) []string {
+	var found []string
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, suffix) {
+			found = append(found, path)
+		}
+		return err
+	}))
+	return found
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := blockcache.New(dir, 10)
+	require.NoError(t, err)
+
+	a, b, c := block(t, "aaaa"), block(t, "bbbb"), block(t, "cccc")
+	_, ok := cache.Get(a)
+	require.False(t, ok)
+	require.False(t, cache.Has(a))
+
+	require.NoError(t, cache.Put(a, []byte("aaaa")))
+	require.NoError(t, cache.Put(b, []byte("bbbb")))
+	data, ok := cache.Get(a)
+	require.True(t, ok)
+	require.Equal(t, "aaaa", string(data))
+	require.Equal(t, uint64(8), cache.Size())
+
+	// the same block under a CID of another version and codec
+	v0 := cid.NewCidV0(a.Hash())
+	require.True(t, cache.Has(v0))
+	data, ok = cache.Get(v0)
+	require.True(t, ok)
+	require.Equal(t, "aaaa", string(data))
+
+	// putting a block again doesn't add to the size
+	require.NoError(t, cache.Put(a, []byte("aaaa")))
+	require.Equal(t, uint64(8), cache.Size())
+
+	// b is the least recently used and is evicted to make room for c
+	require.NoError(t, cache.Put(c, []byte("cccc")))
+	require.False(t, cache.Has(b))
+	_, ok = cache.Get(b)
+	require.False(t, ok)
+	require.True(t, cache.Has(a))
+	require.True(t, cache.Has(c))
+	require.Equal(t, 2, cache.Len())
+	require.Equal(t, uint64(8), cache.Size())
+
+	// a block larger than the cache isn't kept
+	d := block(t, "ddddddddddd")
+	require.NoError(t, cache.Put(d, []byte("ddddddddddd")))
+	require.False(t, cache.Has(d))
+
+	// nor is one with an identity CID
+	id, err := cid.V1Builder{Codec: uint64(multicodec.Raw), MhType: multihash.IDENTITY}.Sum([]byte("e"))
+	require.NoError(t, err)
+	require.NoError(t, cache.Put(id, []byte("e")))
+	require.False(t, cache.Has(id))
+	require.Equal(t, 2, cache.Len())
+
+	// a block corrupted on disk is dropped rather than returned
+	require.NoError(t, os.WriteFile(blockFile(dir, c), []byte("CCCC"), 0644))
+	_, ok = cache.Get(c)
+	require.False(t, ok)
+	require.False(t, cache.Has(c))
+	require.NoError(t, cache.Put(c, []byte("cccc")))
+	data, ok = cache.Get(c)
+	require.True(t, ok)
+	require.Equal(t, "cccc", string(data))
+
+	// no temporary files are left behind
+	require.Empty(t, files(t, dir, ".tmp"))
+	require.Len(t, files(t, dir, ".data"), 2)
+
+	// clearing removes every block and its file
+	require.Equal(t, 2, cache.Clear())
+	require.Equal(t, 0, cache.Len())
+	require.Equal(t, uint64(0), cache.Size())
+	_, ok = cache.Get(a)
+	require.False(t, ok)
+	require.Empty(t, files(t, dir, ".data"))
+}
+
+func TestCacheReopen(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := blockcache.New(dir, 10)
+	require.NoError(t, err)
+	a, b, c := block(t, "aaa"), block(t, "bbb"), block(t, "ccc")
+	for blk, data := range map[cid.Cid]string{a: "aaa", b: "bbb", c: "ccc"} {
+		require.NoError(t, cache.Put(blk, []byte(data)))
+	}
+	// a is the oldest
+	aFile := blockFile(dir, a)
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(aFile, old, old))
+	// an incomplete block left behind
+	tempFile := strings.TrimSuffix(aFile, ".data") + "-123.tmp"
+	require.NoError(t, os.WriteFile(tempFile, []byte("x"), 0644))
+
+	// reopened smaller, the oldest block is evicted
+	cache, err = blockcache.New(dir, 6)
+	require.NoError(t, err)
+	require.Equal(t, 2, cache.Len())
+	require.False(t, cache.Has(a))
+	data, ok := cache.Get(b)
+	require.True(t, ok)
+	require.Equal(t, "bbb", string(data))
+	_, err = os.Stat(aFile)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(tempFile)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = blockcache.New(dir, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package blockcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// BundleRoot is the header root of a bundle of the whole cache written by
+// Export, the identity CID of no data, since a CAR must have a root and the
+// cache's blocks have none.
+var BundleRoot = cid.MustParse("bafkqaaa")
+
+// ErrInvalidCar is returned by Import when the CAR can't be read or holds a
+// block that doesn't match its CID.
+var ErrInvalidCar = errors.New("invalid CAR")
+
+var protoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
+
+// Export writes a bundle of cached blocks to w as a CARv1, returning the
+// number of blocks written, so that another cache can be seeded with them
+// with Import.
+//
+// Without roots, every block in the cache is written, least recently used
+// first, under BundleRoot. The cache only keeps the multihashes of blocks, so
+// they're written with raw CIDs, which Import keys the same way. With roots,
+// the blocks of the entire DAG of each root that are cached are written, with
+// their CIDs, in the order of a depth-first traversal, under the roots. Blocks
+// of a DAG that aren't cached are skipped along with those below them.
+func (c *Cache) Export(ctx context.Context, w io.Writer, roots ...cid.Cid) (int, error) {
+	headerRoots := roots
+	if len(headerRoots) == 0 {
+		headerRoots = []cid.Cid{BundleRoot}
+	}
+	car, err := carstorage.NewWritable(w, headerRoots, carv2.WriteAsCarV1(true), carv2.StoreIdentityCIDs(false))
+	if err != nil {
+		return 0, err
+	}
+	if len(roots) == 0 {
+		return c.exportAll(ctx, car)
+	}
+	return c.exportDAGs(ctx, car, roots)
+}
+
+func (c *Cache) exportAll(ctx context.Context, car carstorage.WritableCar) (int, error) {
+	c.lk.Lock()
+	keys := make([]string, 0, c.lru.Len())
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		keys = append(keys, elem.Value.(*entry).key)
+	}
+	c.lk.Unlock()
+
+	var written int
+	for _, k := range keys {
+		if ctx.Err() != nil {
+			return written, ctx.Err()
+		}
+		hash, err := keyEncoding.DecodeString(strings.ToUpper(k))
+		if err != nil {
+			logger.Warnw("Skipping cached block with an invalid key", "key", k, "err", err)
+			continue
+		}
+		if _, err := multihash.Cast(hash); err != nil {
+			logger.Warnw("Skipping cached block with an invalid key", "key", k, "err", err)
+			continue
+		}
+		blockCid := cid.NewCidV1(uint64(multicodec.Raw), hash)
+		data, ok := c.Get(blockCid)
+		if !ok {
+			// evicted, or dropped as corrupt, since the keys were listed
+			continue
+		}
+		if err := car.Put(ctx, blockCid.KeyString(), data); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+func (c *Cache) exportDAGs(ctx context.Context, car carstorage.WritableCar, roots []cid.Cid) (int, error) {
+	var written int
+	exported := make(map[cid.Cid]struct{})
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true // Get checks blocks against their CIDs
+	lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		blockCid := lnk.(cidlink.Link).Cid
+		if blockCid.Prefix().MhType == multihash.IDENTITY {
+			decoded, err := multihash.Decode(blockCid.Hash())
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(decoded.Digest), nil
+		}
+		data, ok := c.Get(blockCid)
+		if !ok {
+			return nil, traversal.SkipMe{}
+		}
+		if _, ok := exported[blockCid]; !ok {
+			exported[blockCid] = struct{}{}
+			if err := car.Put(lctx.Ctx, blockCid.KeyString(), data); err != nil {
+				return nil, err
+			}
+			written++
+		}
+		return bytes.NewReader(data), nil
+	}
+
+	sel, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	if err != nil {
+		return 0, err
+	}
+	for _, root := range roots {
+		lnk := cidlink.Link{Cid: root}
+		lnkCtx := linking.LinkContext{Ctx: ctx}
+		proto, err := protoChooser(lnk, lnkCtx)
+		if err != nil {
+			return written, err
+		}
+		rootNode, err := lsys.Load(lnkCtx, lnk, proto)
+		if errors.Is(err, traversal.SkipMe{}) {
+			continue
+		} else if err != nil {
+			return written, fmt.Errorf("failed to load %s: %w", root, err)
+		}
+		progress := traversal.Progress{
+			Cfg: &traversal.Config{
+				Ctx:                            ctx,
+				LinkSystem:                     lsys,
+				LinkTargetNodePrototypeChooser: protoChooser,
+			},
+		}
+		if err := progress.WalkAdv(rootNode, sel, func(traversal.Progress, datamodel.Node, traversal.VisitReason) error { return nil }); err != nil {
+			return written, fmt.Errorf("failed to export %s: %w", root, err)
+		}
+	}
+	return written, nil
+}
+
+// Import adds the blocks of a CAR, such as a bundle written by Export, to the
+// cache, returning the number of blocks read. Each block is checked against
+// its CID, and the import stops at the first that doesn't match. The CAR's
+// roots are ignored, and blocks that Put ignores aren't cached.
+func (c *Cache) Import(ctx context.Context, r io.Reader) (int, error) {
+	reader, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidCar, err)
+	}
+	var imported int
+	for {
+		if ctx.Err() != nil {
+			return imported, ctx.Err()
+		}
+		blk, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("%w: %s", ErrInvalidCar, err)
+		}
+		if err := c.Put(blk.Cid(), blk.RawData()); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+}
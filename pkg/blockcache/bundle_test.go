@@ -0,0 +1,145 @@
+package blockcache_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	carv2 "github.com/ipld/go-car/v2"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// readBundle returns the roots and block CIDs of a bundle, in order.
+func readBundle(t *testing.T, bundle []byte) ([]cid.Cid, []cid.Cid) {
+	reader, err := carv2.NewBlockReader(bytes.NewReader(bundle))
+	require.NoError(t, err)
+	var cids []cid.Cid
+	for {
+		blk, err := reader.Next()
+		if err == io.EOF {
+			return reader.Roots, cids
+		}
+		require.NoError(t, err)
+		cids = append(cids, blk.Cid())
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	file1 := unixfs.GenerateFile(t, &lsys, rand.New(rand.NewSource(1)), 1<<20)
+	file2 := unixfs.GenerateFile(t, &lsys, rand.New(rand.NewSource(2)), 1<<20)
+	dag1 := testutil.ToBlocks(t, lsys, file1.Root, selectorparse.CommonSelector_ExploreAllRecursively)
+	dag2 := testutil.ToBlocks(t, lsys, file2.Root, selectorparse.CommonSelector_ExploreAllRecursively)
+
+	cache, err := blockcache.New(t.TempDir(), 1<<30)
+	require.NoError(t, err)
+	for _, blk := range append(dag1, dag2...) {
+		require.NoError(t, cache.Put(blk.Cid(), blk.RawData()))
+	}
+	unrelated := block(t, "unrelated")
+	require.NoError(t, cache.Put(unrelated, []byte("unrelated")))
+	total := cache.Len()
+
+	t.Run("whole cache", func(t *testing.T) {
+		var bundle bytes.Buffer
+		written, err := cache.Export(ctx, &bundle)
+		require.NoError(t, err)
+		require.Equal(t, total, written)
+		roots, cids := readBundle(t, bundle.Bytes())
+		require.Equal(t, []cid.Cid{blockcache.BundleRoot}, roots)
+		require.Len(t, cids, total)
+		// least recently used first, as raw blocks
+		require.Equal(t, dag1[0].Cid().Hash(), cids[0].Hash())
+		require.Equal(t, unrelated, cids[len(cids)-1])
+		for _, c := range cids {
+			require.Equal(t, uint64(multicodec.Raw), c.Prefix().Codec)
+		}
+
+		imported, err := blockcache.New(t.TempDir(), 1<<30)
+		require.NoError(t, err)
+		read, err := imported.Import(ctx, &bundle)
+		require.NoError(t, err)
+		require.Equal(t, total, read)
+		require.Equal(t, total, imported.Len())
+		require.Equal(t, cache.Size(), imported.Size())
+		for _, blk := range append(dag1, dag2...) {
+			data, ok := imported.Get(blk.Cid())
+			require.True(t, ok)
+			require.Equal(t, blk.RawData(), data)
+		}
+	})
+
+	t.Run("by root", func(t *testing.T) {
+		var bundle bytes.Buffer
+		written, err := cache.Export(ctx, &bundle, file2.Root)
+		require.NoError(t, err)
+		require.Equal(t, len(dag2), written)
+		roots, cids := readBundle(t, bundle.Bytes())
+		require.Equal(t, []cid.Cid{file2.Root}, roots)
+		expected := make([]cid.Cid, 0, len(dag2))
+		for _, blk := range dag2 {
+			expected = append(expected, blk.Cid())
+		}
+		require.Equal(t, expected, cids)
+
+		imported, err := blockcache.New(t.TempDir(), 1<<30)
+		require.NoError(t, err)
+		read, err := imported.Import(ctx, &bundle)
+		require.NoError(t, err)
+		require.Equal(t, len(dag2), read)
+		for _, blk := range dag1 {
+			require.False(t, imported.Has(blk.Cid()))
+		}
+		for _, blk := range dag2 {
+			require.True(t, imported.Has(blk.Cid()))
+		}
+	})
+
+	t.Run("by root, partly cached", func(t *testing.T) {
+		partial, err := blockcache.New(t.TempDir(), 1<<30)
+		require.NoError(t, err)
+		// the root and its first child, without the rest of the file
+		require.NoError(t, partial.Put(dag1[0].Cid(), dag1[0].RawData()))
+		require.NoError(t, partial.Put(dag1[1].Cid(), dag1[1].RawData()))
+
+		var bundle bytes.Buffer
+		written, err := partial.Export(ctx, &bundle, file1.Root, file2.Root)
+		require.NoError(t, err)
+		require.Equal(t, 2, written)
+		roots, cids := readBundle(t, bundle.Bytes())
+		require.Equal(t, []cid.Cid{file1.Root, file2.Root}, roots)
+		require.Equal(t, []cid.Cid{dag1[0].Cid(), dag1[1].Cid()}, cids)
+	})
+
+	t.Run("import rejects a corrupt block", func(t *testing.T) {
+		var bundle bytes.Buffer
+		_, err := cache.Export(ctx, &bundle, file1.Root)
+		require.NoError(t, err)
+		byts := bundle.Bytes()
+		byts[len(byts)-1] ^= 0xff
+
+		imported, err := blockcache.New(t.TempDir(), 1<<30)
+		require.NoError(t, err)
+		read, err := imported.Import(ctx, bytes.NewReader(byts))
+		require.ErrorIs(t, err, blockcache.ErrInvalidCar)
+		require.Equal(t, len(dag1)-1, read)
+		require.False(t, imported.Has(dag1[len(dag1)-1].Cid()))
+
+		_, err = imported.Import(ctx, bytes.NewReader([]byte("not a car")))
+		require.ErrorIs(t, err, blockcache.ErrInvalidCar)
+	})
+}
@@ -0,0 +1,272 @@
+/*
+Package carverify verifies the CAR files in a directory tree, such as an
+archive of retrieval outputs, checking that each holds the complete and
+correctly hashed DAG for its root and nothing more. The request that produced
+each CAR, its path, scope and byte range, may be given in a Manifest, otherwise
+the CAR is expected to hold the entire DAG of each of its header roots.
+*/
+package carverify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+)
+
+var logger = logging.Subsystem("lassie/carverify")
+
+// Expectation describes the request a CAR file is expected to satisfy, with
+// the same path, "dag-scope" and "entity-bytes" parameters as a trustless
+// gateway request. If Root is set it must be one of the CAR's header roots and
+// is the only root verified, otherwise each of the header roots is verified.
+type Expectation struct {
+	Root        string `json:"root,omitempty"`
+	Path        string `json:"path,omitempty"`
+	DagScope    string `json:"dag-scope,omitempty"`
+	EntityBytes string `json:"entity-bytes,omitempty"`
+}
+
+// request returns the trustless request for the given root described by the
+// Expectation, a request for the entire DAG if the Expectation is empty.
+func (e Expectation) request(root cid.Cid) (trustlessutils.Request, error) {
+	request := trustlessutils.Request{Root: root, Path: e.Path, Scope: trustlessutils.DagScopeAll}
+	if e.DagScope != "" {
+		scope, err := trustlessutils.ParseDagScope(e.DagScope)
+		if err != nil {
+			return trustlessutils.Request{}, err
+		}
+		request.Scope = scope
+	}
+	if e.EntityBytes != "" {
+		byteRange, err := trustlessutils.ParseByteRange(e.EntityBytes)
+		if err != nil {
+			return trustlessutils.Request{}, err
+		}
+		request.Bytes = &byteRange
+	}
+	return request, nil
+}
+
+// Manifest maps the paths of CAR files, relative to the directory being
+// verified and separated by forward slashes, to the request each is expected
+// to satisfy.
+type Manifest map[string]Expectation
+
+// LoadManifest reads a JSON Manifest from the given file.
+func LoadManifest(path string) (Manifest, error) {
+	byts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(byts, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	for name, expectation := range manifest {
+		if expectation.Root != "" {
+			if _, err := cid.Parse(expectation.Root); err != nil {
+				return nil, fmt.Errorf("invalid manifest entry for %s: invalid root: %w", name, err)
+			}
+		}
+		if _, err := expectation.request(cid.Undef); err != nil {
+			return nil, fmt.Errorf("invalid manifest entry for %s: %w", name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// Config configures the verification of a directory.
+type Config struct {
+	// Workers is the number of CAR files verified in parallel, defaulting to
+	// the number of CPUs.
+	Workers int
+	// Manifest optionally describes the request each CAR file is expected to
+	// satisfy. CAR files that aren't listed are expected to hold the entire DAG
+	// of each of their header roots.
+	Manifest Manifest
+}
+
+// FileResult is the outcome of the verification of a single CAR file.
+type FileResult struct {
+	Path  string    `json:"path"`
+	Roots []cid.Cid `json:"roots"`
+	// Blocks is the number of unique blocks in the CAR file
+	Blocks uint64 `json:"blocks"`
+	// Bytes is the size of the CAR file
+	Bytes uint64 `json:"bytes"`
+	// Missing, Extraneous and Corrupt list the blocks the CAR file doesn't
+	// hold, shouldn't hold and holds corrupt, see CarReport.
+	Missing    []cid.Cid `json:"missing,omitempty"`
+	Extraneous []cid.Cid `json:"extraneous,omitempty"`
+	Corrupt    []cid.Cid `json:"corrupt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Verified returns true if the CAR file passed verification.
+func (fr FileResult) Verified() bool {
+	return fr.Error == ""
+}
+
+// Report is the outcome of the verification of a directory, with a result for
+// each CAR file, in path order, and totals across them.
+type Report struct {
+	Dir      string       `json:"dir"`
+	Files    []FileResult `json:"files"`
+	Verified int          `json:"verified"`
+	Failed   int          `json:"failed"`
+	Blocks   uint64       `json:"blocks"`
+	Bytes    uint64       `json:"bytes"`
+}
+
+// Passed returns true if every CAR file passed verification.
+func (r Report) Passed() bool {
+	return r.Failed == 0
+}
+
+// VerifyDir walks the directory tree rooted at dir and verifies every file
+// with a ".car" extension against its Expectation in the Manifest, or
+// against its header roots if it isn't listed. Files listed in the Manifest
+// that don't exist are reported as failures. An error is only returned if the
+// directory can't be walked; the failure of individual files is recorded in
+// the Report.
+func VerifyDir(ctx context.Context, dir string, cfg Config) (*Report, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && strings.EqualFold(filepath.Ext(path), ".car") {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		found[path] = struct{}{}
+	}
+	for path := range cfg.Manifest {
+		if _, ok := found[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]FileResult, len(paths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				path := paths[index]
+				result := FileResult{Path: path}
+				if _, ok := found[path]; !ok {
+					result.Error = "listed in manifest but not found"
+				} else if err := verifyFile(ctx, filepath.Join(dir, filepath.FromSlash(path)), cfg.Manifest[path], &result); err != nil {
+					result.Error = err.Error()
+				}
+				logger.Debugw("verified CAR", "path", path, "err", result.Error)
+				results[index] = result
+			}
+		}()
+	}
+	for index := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	report := &Report{Dir: dir, Files: results}
+	for _, result := range results {
+		if result.Verified() {
+			report.Verified++
+		} else {
+			report.Failed++
+		}
+		report.Blocks += result.Blocks
+		report.Bytes += result.Bytes
+	}
+	return report, nil
+}
+
+// verifyFile verifies a single CAR file with the checks of VerifyCar, against
+// each of its header roots or the one expected, filling in the result.
+func verifyFile(ctx context.Context, path string, expectation Expectation, result *FileResult) error {
+	report, err := verifyCar(ctx, path, false, func(_ *CarReport, roots []cid.Cid) ([]trustlessutils.Request, error) {
+		result.Roots = roots
+		if expectation.Root != "" {
+			root, err := cid.Parse(expectation.Root)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expected root: %w", err)
+			}
+			if !containsCid(roots, root) {
+				return nil, fmt.Errorf("%w: expected root %s is not a root of the CAR", traversal.ErrBadRoots, root)
+			}
+			roots = []cid.Cid{root}
+		}
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("%w: CAR has no roots", traversal.ErrBadRoots)
+		}
+		requests := make([]trustlessutils.Request, 0, len(roots))
+		for _, root := range roots {
+			request, err := expectation.request(root)
+			if err != nil {
+				return nil, err
+			}
+			requests = append(requests, request)
+		}
+		return requests, nil
+	})
+	if err != nil {
+		return err
+	}
+	result.Blocks = report.Blocks - report.Duplicates
+	result.Bytes = report.Bytes
+	result.Missing = report.Missing
+	result.Extraneous = report.Extraneous
+	result.Corrupt = report.Corrupt
+	if !report.Verified() {
+		return errors.New(report.Error)
+	}
+	return nil
+}
+
+func containsCid(cids []cid.Cid, c cid.Cid) bool {
+	for _, other := range cids {
+		if other.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package carverify_test
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/carverify"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDir(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	rnd := rand.New(rand.NewSource(0))
+	file := unixfs.GenerateFile(t, &lsys, rnd, 1<<20)
+	other := unixfs.GenerateFile(t, &lsys, rnd, 1<<10)
+	dir := unixfs.GenerateDirectory(t, &lsys, rnd, 1<<20, false)
+	child := dir.Children[0]
+	childName := filepath.Base(child.Path)
+
+	blockData := func(c cid.Cid) []byte {
+		data, err := store.Get(ctx, c.KeyString())
+		require.NoError(t, err)
+		return data
+	}
+	writeCar := func(path string, roots []cid.Cid, cids []cid.Cid, data func(cid.Cid) []byte) {
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		defer f.Close()
+		car, err := carstorage.NewWritable(f, roots, carv2.WriteAsCarV1(true))
+		require.NoError(t, err)
+		for _, c := range cids {
+			require.NoError(t, car.Put(ctx, c.KeyString(), data(c)))
+		}
+		require.NoError(t, car.Finalize())
+	}
+	writeRequestCar := func(path string, request trustlessutils.Request) {
+		var cids []cid.Cid
+		for _, blk := range testutil.ToBlocks(t, lsys, request.Root, request.Selector()) {
+			cids = append(cids, blk.Cid())
+		}
+		writeCar(path, []cid.Cid{request.Root}, cids, blockData)
+	}
+
+	testCases := []struct {
+		name             string
+		path             string
+		write            func(path string)
+		expectation      *carverify.Expectation
+		expectErr        string
+		expectMissing    []cid.Cid
+		expectExtraneous []cid.Cid
+		expectCorrupt    []cid.Cid
+	}{
+		{
+			name: "complete DAG",
+			path: "file.car",
+			write: func(path string) {
+				writeCar(path, []cid.Cid{file.Root}, file.SelfCids, blockData)
+			},
+		},
+		{
+			name: "nested directory",
+			path: "nested/dir/file.car",
+			write: func(path string) {
+				writeRequestCar(path, trustlessutils.Request{Root: dir.Root, Scope: trustlessutils.DagScopeAll})
+			},
+		},
+		{
+			name: "path and scope from manifest",
+			path: "path.car",
+			write: func(path string) {
+				writeRequestCar(path, trustlessutils.Request{Root: dir.Root, Path: childName, Scope: trustlessutils.DagScopeEntity})
+			},
+			expectation: &carverify.Expectation{Root: dir.Root.String(), Path: childName, DagScope: "entity"},
+		},
+		{
+			name: "partial DAG without manifest",
+			path: "partial.car",
+			write: func(path string) {
+				writeRequestCar(path, trustlessutils.Request{Root: dir.Root, Path: childName, Scope: trustlessutils.DagScopeEntity})
+			},
+			expectErr: traversal.ErrMissingBlock.Error(),
+		},
+		{
+			name: "missing block",
+			path: "missing.car",
+			write: func(path string) {
+				writeCar(path, []cid.Cid{file.Root}, file.SelfCids[1:], blockData)
+			},
+			expectErr:     traversal.ErrMissingBlock.Error(),
+			expectMissing: file.SelfCids[:1],
+		},
+		{
+			name: "extraneous block",
+			path: "extraneous.car",
+			write: func(path string) {
+				writeCar(path, []cid.Cid{file.Root}, append(append([]cid.Cid{}, file.SelfCids...), other.Root), blockData)
+			},
+			expectErr:        traversal.ErrExtraneousBlock.Error(),
+			expectExtraneous: []cid.Cid{other.Root},
+		},
+		{
+			name: "corrupt block",
+			path: "corrupt.car",
+			write: func(path string) {
+				corrupt := file.SelfCids[len(file.SelfCids)-1]
+				writeCar(path, []cid.Cid{file.Root}, file.SelfCids, func(c cid.Cid) []byte {
+					if c.Equals(corrupt) {
+						return []byte("not the block")
+					}
+					return blockData(c)
+				})
+			},
+			expectErr:     "1 corrupt block(s)",
+			expectMissing: file.SelfCids[len(file.SelfCids)-1:],
+			expectCorrupt: file.SelfCids[len(file.SelfCids)-1:],
+		},
+		{
+			name: "unexpected root",
+			path: "root.car",
+			write: func(path string) {
+				writeCar(path, []cid.Cid{file.Root}, file.SelfCids, blockData)
+			},
+			expectation: &carverify.Expectation{Root: other.Root.String()},
+			expectErr:   traversal.ErrBadRoots.Error(),
+		},
+		{
+			name:        "listed in manifest but missing",
+			path:        "absent.car",
+			expectation: &carverify.Expectation{},
+			expectErr:   "listed in manifest but not found",
+		},
+	}
+
+	root := t.TempDir()
+	manifest := carverify.Manifest{}
+	for _, testCase := range testCases {
+		if testCase.write != nil {
+			path := filepath.Join(root, filepath.FromSlash(testCase.path))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			testCase.write(path)
+		}
+		if testCase.expectation != nil {
+			manifest[testCase.path] = *testCase.expectation
+		}
+	}
+	// files without a .car extension are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README"), []byte("not a CAR"), 0644))
+
+	report, err := carverify.VerifyDir(ctx, root, carverify.Config{Workers: 3, Manifest: manifest})
+	require.NoError(t, err)
+	require.Len(t, report.Files, len(testCases))
+	results := make(map[string]carverify.FileResult)
+	for _, result := range report.Files {
+		results[result.Path] = result
+	}
+
+	var failed int
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			result, ok := results[testCase.path]
+			require.True(t, ok)
+			if testCase.expectErr == "" {
+				require.True(t, result.Verified(), result.Error)
+				require.NotZero(t, result.Blocks)
+				require.NotZero(t, result.Bytes)
+			} else {
+				require.Contains(t, result.Error, testCase.expectErr)
+			}
+			if testCase.expectMissing != nil {
+				require.Equal(t, testCase.expectMissing, result.Missing)
+			}
+			require.Equal(t, testCase.expectExtraneous, result.Extraneous)
+			require.Equal(t, testCase.expectCorrupt, result.Corrupt)
+		})
+		if testCase.expectErr != "" {
+			failed++
+		}
+	}
+	require.Equal(t, failed, report.Failed)
+	require.Equal(t, len(testCases)-failed, report.Verified)
+	require.False(t, report.Passed())
+}
+
+func TestLoadManifest(t *testing.T) {
+	testCases := []struct {
+		name      string
+		manifest  string
+		expectErr bool
+	}{
+		{
+			name:     "valid",
+			manifest: `{"a.car":{"root":"bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4","path":"birb.mp4","dag-scope":"entity","entity-bytes":"0:*"}}`,
+		},
+		{
+			name:      "invalid JSON",
+			manifest:  `[]`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid root",
+			manifest:  `{"a.car":{"root":"nope"}}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid scope",
+			manifest:  `{"a.car":{"dag-scope":"nope"}}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid entity bytes",
+			manifest:  `{"a.car":{"entity-bytes":"nope"}}`,
+			expectErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest.json")
+			require.NoError(t, os.WriteFile(path, []byte(testCase.manifest), 0644))
+			manifest, err := carverify.LoadManifest(path)
+			if testCase.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, carverify.Expectation{
+				Root:        "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+				Path:        "birb.mp4",
+				DagScope:    "entity",
+				EntityBytes: "0:*",
+			}, manifest["a.car"])
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package carverify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	ipldtraversal "github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+)
+
+var protoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
+
+// CarReport is the outcome of the verification of a CAR file against a
+// request by VerifyCar.
+type CarReport struct {
+	Path    string                 `json:"path"`
+	Request trustlessutils.Request `json:"-"`
+	// Query is the request as a trustless gateway path and query
+	Query string `json:"query"`
+	// Ordered is true if the CAR was expected to hold its blocks in the order
+	// of a depth-first traversal of the request
+	Ordered bool `json:"ordered"`
+	// Blocks is the number of blocks in the CAR file, including duplicates
+	Blocks uint64 `json:"blocks"`
+	// Duplicates is the number of blocks that appear in the CAR file more than
+	// once, counting each repeat
+	Duplicates uint64 `json:"duplicates"`
+	// Bytes is the size of the CAR file
+	Bytes uint64 `json:"bytes"`
+	// Missing lists the blocks of the request that the CAR doesn't hold, or
+	// holds corrupt, in the order the traversal needs them. A missing block
+	// stops the traversal below it, so blocks beneath it aren't listed.
+	Missing []cid.Cid `json:"missing,omitempty"`
+	// Extraneous lists the blocks of the CAR that aren't part of the request,
+	// only known when the traversal of the request could be completed.
+	Extraneous []cid.Cid `json:"extraneous,omitempty"`
+	// Corrupt lists the blocks of the CAR whose data doesn't match their CID.
+	Corrupt []cid.Cid `json:"corrupt,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Verified returns true if the CAR file passed verification.
+func (cr CarReport) Verified() bool {
+	return cr.Error == ""
+}
+
+// VerifyCar verifies that the CAR file at path holds exactly the blocks of a
+// trustless request, with the same checks a retrieval makes of a response.
+// If the request has no root, the CAR must have a single root, which is used.
+// If ordered is true, the blocks must appear in the order of a depth-first
+// traversal of the request, with the request's Duplicates deciding whether
+// blocks repeated in the traversal are repeated in the CAR, otherwise any
+// order is accepted.
+//
+// When verification fails, the blocks that are missing, extraneous or
+// corrupt are listed in the report, alongside the reason verification failed.
+// An error is only returned if the file can't be read.
+func VerifyCar(ctx context.Context, path string, request trustlessutils.Request, ordered bool) (*CarReport, error) {
+	return verifyCar(ctx, path, ordered, func(report *CarReport, roots []cid.Cid) ([]trustlessutils.Request, error) {
+		if !request.Root.Defined() {
+			if len(roots) != 1 {
+				return nil, fmt.Errorf("%w: expected a single root, found %d", traversal.ErrBadRoots, len(roots))
+			}
+			request.Root = roots[0]
+		}
+		report.Request = request
+		query, err := request.UrlPath()
+		if err != nil {
+			return nil, err
+		}
+		report.Query = "/ipfs/" + request.Root.String() + query
+		if request.Duplicates {
+			report.Query += "&dups=y"
+		}
+		if len(roots) != 1 || !roots[0].Equals(request.Root) {
+			return nil, fmt.Errorf("%w: expected %s, found %v", traversal.ErrBadRoots, request.Root, roots)
+		}
+		return []trustlessutils.Request{request}, nil
+	})
+}
+
+// requestsOf returns the requests a CAR with the given header roots is
+// verified against, noting them in the report, or an error recorded as the
+// reason verification failed.
+type requestsOf func(report *CarReport, roots []cid.Cid) ([]trustlessutils.Request, error)
+
+// verifyCar verifies that the CAR file at path holds exactly the blocks of
+// the requests returned by requestsOf, see VerifyCar. Only a single request
+// can be ordered.
+func verifyCar(ctx context.Context, path string, ordered bool, requestsOf requestsOf) (*CarReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CarReport{Path: path, Ordered: ordered, Bytes: uint64(stat.Size())}
+	fail := func(err error) (*CarReport, error) {
+		if report.Error == "" {
+			report.Error = err.Error()
+		}
+		return report, nil
+	}
+
+	// read every block, checking each against its CID without stopping at the
+	// first that doesn't match
+	reader, err := carv2.NewBlockReader(file, carv2.WithTrustedCAR(true))
+	if err != nil {
+		return fail(fmt.Errorf("%w: %s", traversal.ErrMalformedCar, err))
+	}
+	requests, err := requestsOf(report, reader.Roots)
+	if err != nil {
+		return fail(err)
+	}
+
+	present := make(map[cid.Cid]struct{})
+	corrupt := make(map[cid.Cid]struct{})
+	for {
+		blk, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// carry on to report what's missing from the blocks read so far
+			report.Error = fmt.Sprintf("%s: %s", traversal.ErrMalformedCar, err)
+			break
+		}
+		report.Blocks++
+		c := blk.Cid()
+		if _, ok := present[c]; ok {
+			report.Duplicates++
+			continue
+		}
+		if _, ok := corrupt[c]; ok {
+			report.Duplicates++
+			continue
+		}
+		if hashed, err := c.Prefix().Sum(blk.RawData()); err != nil || !hashed.Equals(c) {
+			corrupt[c] = struct{}{}
+			report.Corrupt = append(report.Corrupt, c)
+			continue
+		}
+		present[c] = struct{}{}
+	}
+	if len(report.Corrupt) > 0 && report.Error == "" {
+		report.Error = fmt.Sprintf("%d corrupt block(s) in CAR", len(report.Corrupt))
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	store, err := carstorage.OpenReadable(file)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %s", traversal.ErrMalformedCar, err))
+	}
+
+	// traverse the request over the blocks present, noting those it needs
+	// that are missing rather than failing on the first
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.TrustedStorage = true // corrupt blocks are never read
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	read := lsys.StorageReadOpener
+	visited := make(map[cid.Cid]struct{})
+	lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		c := lnk.(cidlink.Link).Cid
+		if c.Prefix().MhType != 0 { // identity CIDs are never missing
+			if _, ok := present[c]; !ok {
+				if _, ok := visited[c]; !ok {
+					report.Missing = append(report.Missing, c)
+				}
+				visited[c] = struct{}{}
+				return nil, ipldtraversal.SkipMe{}
+			}
+		}
+		visited[c] = struct{}{}
+		return read(lctx, lnk)
+	}
+	var walkErr error
+	for _, request := range requests {
+		lastPath, err := walk(ctx, lsys, request)
+		if err == nil && len(report.Missing) == 0 {
+			err = traversal.CheckPath(datamodel.ParsePath(request.Path), lastPath)
+		}
+		if err != nil {
+			if len(requests) > 1 {
+				err = fmt.Errorf("failed to verify %s: %w", request.Root, err)
+			}
+			walkErr = err
+			break
+		}
+	}
+	if walkErr == nil {
+		for c := range present {
+			if _, ok := visited[c]; !ok {
+				report.Extraneous = append(report.Extraneous, c)
+			}
+		}
+	}
+	switch {
+	case len(report.Missing) > 0:
+		return fail(fmt.Errorf("%w: %d block(s) missing from CAR", traversal.ErrMissingBlock, len(report.Missing)))
+	case walkErr != nil:
+		return fail(walkErr)
+	case len(report.Extraneous) > 0:
+		return fail(fmt.Errorf("%w: %d block(s) not part of the request", traversal.ErrExtraneousBlock, len(report.Extraneous)))
+	case report.Error != "" || !ordered:
+		return report, nil
+	}
+
+	// with every block accounted for, check their order and duplicates as a
+	// retrieval would
+	request := requests[0]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	verifyLsys := cidlink.DefaultLinkSystem()
+	verifyLsys.SetReadStorage(store)
+	verifyLsys.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return io.Discard, func(datamodel.Link) error { return nil }, nil
+	}
+	_, err = traversal.Config{
+		Root:               request.Root,
+		Selector:           request.Selector(),
+		CheckRootsMismatch: true,
+		ExpectDuplicatesIn: request.Duplicates,
+	}.VerifyCar(ctx, file, verifyLsys)
+	if err != nil {
+		return fail(err)
+	}
+	return report, nil
+}
+
+// walk traverses the request, returning the last path visited. Unlike
+// traversal.Config.Traverse, links whose loading is skipped don't fail it.
+func walk(ctx context.Context, lsys linking.LinkSystem, request trustlessutils.Request) (datamodel.Path, error) {
+	sel, err := selector.CompileSelector(request.Selector())
+	if err != nil {
+		return datamodel.Path{}, err
+	}
+	lnk := cidlink.Link{Cid: request.Root}
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	proto, err := protoChooser(lnk, lnkCtx)
+	if err != nil {
+		return datamodel.Path{}, err
+	}
+	rootNode, err := lsys.Load(lnkCtx, lnk, proto)
+	if err != nil {
+		return datamodel.Path{}, err
+	}
+	progress := ipldtraversal.Progress{
+		Cfg: &ipldtraversal.Config{
+			Ctx:                            ctx,
+			LinkSystem:                     lsys,
+			LinkTargetNodePrototypeChooser: protoChooser,
+		},
+	}
+	var lastPath datamodel.Path
+	err = progress.WalkAdv(rootNode, sel, func(p ipldtraversal.Progress, n datamodel.Node, vr ipldtraversal.VisitReason) error {
+		lastPath = p.Path
+		if vr == ipldtraversal.VisitReason_SelectionMatch {
+			return unixfsnode.BytesConsumingMatcher(p, n)
+		}
+		return nil
+	})
+	return lastPath, err
+}
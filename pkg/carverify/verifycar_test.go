@@ -0,0 +1,256 @@
+package carverify_test
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/carverify"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCar(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	rnd := rand.New(rand.NewSource(0))
+	file := unixfs.GenerateFile(t, &lsys, rnd, 1<<20)
+	other := unixfs.GenerateFile(t, &lsys, rnd, 1<<10)
+	var fileCids []cid.Cid
+	for _, blk := range testutil.ToBlocks(t, lsys, file.Root, trustlessutils.Request{Root: file.Root, Scope: trustlessutils.DagScopeAll}.Selector()) {
+		fileCids = append(fileCids, blk.Cid())
+	}
+	require.Greater(t, len(fileCids), 3)
+
+	// a directory with the same file under two names, so a traversal of it
+	// visits the blocks of the file twice
+	var entries []dagpb.PBLink
+	for _, name := range []string{"a", "b"} {
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, 0, cidlink.Link{Cid: file.Root})
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	dirLnk, _, err := builder.BuildUnixFSDirectory(entries, &lsys)
+	require.NoError(t, err)
+	dir := dirLnk.(cidlink.Link).Cid
+
+	blockData := func(c cid.Cid) []byte {
+		data, err := store.Get(ctx, c.KeyString())
+		require.NoError(t, err)
+		return data
+	}
+	// writeCar writes the blocks of cids in order, repeats included
+	writeCar := func(t *testing.T, roots []cid.Cid, cids []cid.Cid, data func(cid.Cid) []byte) string {
+		path := filepath.Join(t.TempDir(), "test.car")
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		defer f.Close()
+		car, err := carstorage.NewWritable(f, roots, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(true))
+		require.NoError(t, err)
+		for _, c := range cids {
+			require.NoError(t, car.Put(ctx, c.KeyString(), data(c)))
+		}
+		require.NoError(t, car.Finalize())
+		return path
+	}
+	concat := func(cidLists ...[]cid.Cid) []cid.Cid {
+		var all []cid.Cid
+		for _, cids := range cidLists {
+			all = append(all, cids...)
+		}
+		return all
+	}
+	reversed := make([]cid.Cid, 0, len(fileCids))
+	for i := len(fileCids) - 1; i >= 0; i-- {
+		reversed = append(reversed, fileCids[i])
+	}
+	missing := fileCids[2]
+	corrupt := fileCids[len(fileCids)-1]
+
+	testCases := []struct {
+		name             string
+		roots            []cid.Cid
+		cids             []cid.Cid
+		data             func(cid.Cid) []byte
+		request          trustlessutils.Request
+		unordered        bool
+		expectErr        string
+		expectMissing    []cid.Cid
+		expectExtraneous []cid.Cid
+		expectCorrupt    []cid.Cid
+	}{
+		{
+			name:    "complete DAG",
+			cids:    fileCids,
+			request: trustlessutils.Request{Root: file.Root},
+		},
+		{
+			name: "root from CAR",
+			cids: fileCids,
+		},
+		{
+			name:      "wrong root",
+			cids:      fileCids,
+			request:   trustlessutils.Request{Root: other.Root},
+			expectErr: traversal.ErrBadRoots.Error(),
+		},
+		{
+			name:          "missing block",
+			cids:          concat(fileCids[:2], fileCids[3:]),
+			expectErr:     traversal.ErrMissingBlock.Error(),
+			expectMissing: []cid.Cid{missing},
+		},
+		{
+			name:             "extraneous block",
+			cids:             concat(fileCids, []cid.Cid{other.Root}),
+			expectErr:        traversal.ErrExtraneousBlock.Error(),
+			expectExtraneous: []cid.Cid{other.Root},
+		},
+		{
+			name: "corrupt block",
+			cids: fileCids,
+			data: func(c cid.Cid) []byte {
+				if c.Equals(corrupt) {
+					return []byte("not the block")
+				}
+				return blockData(c)
+			},
+			expectErr:     "1 corrupt block(s)",
+			expectMissing: []cid.Cid{corrupt},
+			expectCorrupt: []cid.Cid{corrupt},
+		},
+		{
+			name:      "out of order",
+			cids:      reversed,
+			expectErr: traversal.ErrUnexpectedBlock.Error(),
+		},
+		{
+			name:      "out of order, unordered",
+			cids:      reversed,
+			unordered: true,
+		},
+		{
+			name:    "duplicates",
+			roots:   []cid.Cid{dir},
+			cids:    concat([]cid.Cid{dir}, fileCids, fileCids),
+			request: trustlessutils.Request{Duplicates: true},
+		},
+		{
+			name:      "unexpected duplicates",
+			roots:     []cid.Cid{dir},
+			cids:      concat([]cid.Cid{dir}, fileCids, fileCids),
+			expectErr: traversal.ErrExtraneousBlock.Error(),
+		},
+		{
+			name:      "unexpected duplicates, unordered",
+			roots:     []cid.Cid{dir},
+			cids:      concat([]cid.Cid{dir}, fileCids, fileCids),
+			unordered: true,
+		},
+		{
+			name:      "missing duplicates",
+			roots:     []cid.Cid{dir},
+			cids:      concat([]cid.Cid{dir}, fileCids),
+			request:   trustlessutils.Request{Duplicates: true},
+			expectErr: traversal.ErrMissingBlock.Error(),
+		},
+		{
+			name:    "path",
+			roots:   []cid.Cid{dir},
+			cids:    concat([]cid.Cid{dir}, fileCids),
+			request: trustlessutils.Request{Path: "b"},
+		},
+		{
+			name:      "missing path",
+			roots:     []cid.Cid{dir},
+			cids:      []cid.Cid{dir},
+			request:   trustlessutils.Request{Path: "c"},
+			expectErr: "failed to traverse full path",
+		},
+		{
+			name:    "entity bytes",
+			cids:    fileCids[:2],
+			request: trustlessutils.Request{Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: 0, To: ptr(1)}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			roots := testCase.roots
+			if roots == nil {
+				roots = []cid.Cid{file.Root}
+			}
+			data := testCase.data
+			if data == nil {
+				data = blockData
+			}
+			path := writeCar(t, roots, testCase.cids, data)
+			request := testCase.request
+			if request.Scope == "" {
+				request.Scope = trustlessutils.DagScopeAll
+			}
+
+			report, err := carverify.VerifyCar(ctx, path, request, !testCase.unordered)
+			require.NoError(t, err)
+			if testCase.expectErr == "" {
+				require.True(t, report.Verified(), report.Error)
+				require.Contains(t, report.Query, "/ipfs/"+roots[0].String())
+			} else {
+				require.False(t, report.Verified())
+				require.Contains(t, report.Error, testCase.expectErr)
+			}
+			if testCase.expectErr != traversal.ErrBadRoots.Error() {
+				require.Equal(t, uint64(len(testCase.cids)), report.Blocks)
+			}
+			require.Equal(t, testCase.expectMissing, report.Missing)
+			require.Equal(t, testCase.expectExtraneous, report.Extraneous)
+			require.Equal(t, testCase.expectCorrupt, report.Corrupt)
+		})
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "malformed.car")
+		require.NoError(t, os.WriteFile(path, []byte("not a CAR"), 0644))
+		report, err := carverify.VerifyCar(ctx, path, trustlessutils.Request{}, true)
+		require.NoError(t, err)
+		require.Contains(t, report.Error, traversal.ErrMalformedCar.Error())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		path := writeCar(t, []cid.Cid{file.Root}, fileCids, blockData)
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(path, stat.Size()-10))
+		report, err := carverify.VerifyCar(ctx, path, trustlessutils.Request{Scope: trustlessutils.DagScopeAll}, true)
+		require.NoError(t, err)
+		require.Contains(t, report.Error, traversal.ErrMalformedCar.Error())
+		require.Equal(t, uint64(len(fileCids)-1), report.Blocks)
+		require.Equal(t, []cid.Cid{fileCids[len(fileCids)-1]}, report.Missing)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := carverify.VerifyCar(ctx, filepath.Join(t.TempDir(), "missing.car"), trustlessutils.Request{}, true)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func ptr(v int64) *int64 {
+	return &v
+}
@@ -0,0 +1,259 @@
+/*
+Package compare retrieves the same content from a single provider over more
+than one protocol and reports any differences between the results, for
+storage providers and checker networks to validate that each of a provider's
+retrieval protocols serves the same content.
+*/
+package compare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+)
+
+var logger = logging.Subsystem("lassie/compare")
+
+var (
+	ErrSingleProviderRequired = errors.New("comparison requires a single provider")
+	ErrTooFewProtocols        = errors.New("comparison requires at least two protocols")
+)
+
+// maxMissingExamples is the number of missing block CIDs listed in an
+// inconsistency
+const maxMissingExamples = 3
+
+// ProtocolResult describes the retrieval of the content over one protocol.
+type ProtocolResult struct {
+	Protocol        string        `json:"protocol"`
+	Error           string        `json:"error,omitempty"`
+	Blocks          uint64        `json:"blocks"`
+	Bytes           uint64        `json:"bytes"`
+	Duration        time.Duration `json:"duration"`
+	TimeToFirstByte time.Duration `json:"timeToFirstByte"`
+	AverageSpeed    uint64        `json:"averageSpeed"`
+
+	// cids are the unique blocks received, in the order they were received
+	cids []cid.Cid
+}
+
+// Succeeded returns true if the retrieval over this protocol succeeded.
+func (pr ProtocolResult) Succeeded() bool {
+	return pr.Error == ""
+}
+
+// Report is the outcome of a comparison, with a result for each protocol, in
+// the order they were retrieved, and a description of each inconsistency
+// found between them.
+type Report struct {
+	Root            cid.Cid          `json:"root"`
+	Path            string           `json:"path,omitempty"`
+	Provider        peer.ID          `json:"provider"`
+	Results         []ProtocolResult `json:"results"`
+	Inconsistencies []string         `json:"inconsistencies"`
+}
+
+// Consistent returns true if no inconsistencies were found between the
+// protocols.
+func (r Report) Consistent() bool {
+	return len(r.Inconsistencies) == 0
+}
+
+// Compare retrieves the request from its single FixedPeers provider using
+// each of the given protocols in turn, so that they don't compete for the
+// provider's bandwidth, and compares the blocks received and the outcome of
+// each retrieval. The request's LinkSystem and Protocols are ignored, each
+// retrieval is stored in its own temporary CAR in tempDir which is removed
+// once the comparison is complete.
+//
+// An error is only returned if the comparison couldn't be performed, failed
+// retrievals are recorded in the Report.
+func Compare(
+	ctx context.Context,
+	fetcher types.Fetcher,
+	request types.RetrievalRequest,
+	tempDir string,
+	protocols ...multicodec.Code,
+) (*Report, error) {
+	if len(request.FixedPeers) != 1 {
+		return nil, ErrSingleProviderRequired
+	}
+	if len(protocols) < 2 {
+		return nil, ErrTooFewProtocols
+	}
+
+	report := &Report{
+		Root:            request.Root,
+		Path:            request.Path,
+		Provider:        request.FixedPeers[0].ID,
+		Inconsistencies: []string{},
+	}
+	for _, protocol := range protocols {
+		result, err := retrieve(ctx, fetcher, request, tempDir, protocol)
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Inconsistencies = findInconsistencies(report.Results)
+	return report, nil
+}
+
+func retrieve(
+	ctx context.Context,
+	fetcher types.Fetcher,
+	request types.RetrievalRequest,
+	tempDir string,
+	protocol multicodec.Code,
+) (ProtocolResult, error) {
+	result := ProtocolResult{Protocol: protocol.String()}
+
+	retrievalId, err := types.NewRetrievalID()
+	if err != nil {
+		return result, err
+	}
+	store := storage.NewDeferredStorageCar(tempDir, request.Root)
+	defer func() {
+		if err := store.Close(); err != nil {
+			logger.Warnw("Failed to remove temporary CAR", "err", err)
+		}
+	}()
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	order := &blockOrder{}
+	order.wrap(&lsys)
+
+	request.RetrievalID = retrievalId
+	request.LinkSystem = lsys
+	request.Protocols = []multicodec.Code{protocol}
+
+	logger.Debugw("Retrieving for comparison", "root", request.Root, "provider", request.FixedPeers[0].ID, "protocol", protocol)
+	start := time.Now()
+	stats, err := fetcher.Fetch(ctx, request)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if stats != nil {
+		result.TimeToFirstByte = stats.TimeToFirstByte
+		result.AverageSpeed = stats.AverageSpeed
+		result.Bytes = stats.Size
+	}
+	result.cids = order.cids
+	result.Blocks = uint64(len(result.cids))
+	return result, nil
+}
+
+// blockOrder records the CID of each unique block committed to a
+// LinkSystem, in the order they were committed.
+type blockOrder struct {
+	lk   sync.Mutex
+	seen map[cid.Cid]struct{}
+	cids []cid.Cid
+}
+
+func (bo *blockOrder) wrap(lsys *linking.LinkSystem) {
+	bo.seen = make(map[cid.Cid]struct{})
+	bwo := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, func(lnk datamodel.Link) error {
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			bo.record(lnk.(cidlink.Link).Cid)
+			return nil
+		}, nil
+	}
+}
+
+func (bo *blockOrder) record(c cid.Cid) {
+	bo.lk.Lock()
+	defer bo.lk.Unlock()
+	if _, ok := bo.seen[c]; !ok {
+		bo.seen[c] = struct{}{}
+		bo.cids = append(bo.cids, c)
+	}
+}
+
+// findInconsistencies compares each result with the first.
+func findInconsistencies(results []ProtocolResult) []string {
+	inconsistencies := []string{}
+	baseline := results[0]
+	for _, other := range results[1:] {
+		switch {
+		case baseline.Succeeded() && !other.Succeeded():
+			inconsistencies = append(inconsistencies, fmt.Sprintf("%s succeeded but %s failed: %s", baseline.Protocol, other.Protocol, other.Error))
+			continue
+		case !baseline.Succeeded() && other.Succeeded():
+			inconsistencies = append(inconsistencies, fmt.Sprintf("%s succeeded but %s failed: %s", other.Protocol, baseline.Protocol, baseline.Error))
+			continue
+		case !baseline.Succeeded() && !other.Succeeded():
+			continue
+		}
+
+		missingFromOther := missingFrom(baseline.cids, other.cids)
+		if len(missingFromOther) > 0 {
+			inconsistencies = append(inconsistencies, describeMissing(missingFromOther, baseline.Protocol, other.Protocol))
+		}
+		missingFromBaseline := missingFrom(other.cids, baseline.cids)
+		if len(missingFromBaseline) > 0 {
+			inconsistencies = append(inconsistencies, describeMissing(missingFromBaseline, other.Protocol, baseline.Protocol))
+		}
+		// bitswap fetches blocks in parallel so its order isn't meaningful
+		if len(missingFromOther) == 0 && len(missingFromBaseline) == 0 && isOrdered(baseline) && isOrdered(other) {
+			for i := range baseline.cids {
+				if baseline.cids[i] != other.cids[i] {
+					inconsistencies = append(inconsistencies, fmt.Sprintf("blocks were received in a different order over %s and %s, first differing at block %d: %s != %s", baseline.Protocol, other.Protocol, i, baseline.cids[i], other.cids[i]))
+					break
+				}
+			}
+		}
+	}
+	return inconsistencies
+}
+
+func isOrdered(result ProtocolResult) bool {
+	return result.Protocol != multicodec.TransportBitswap.String()
+}
+
+// missingFrom returns the CIDs in have that are not in other.
+func missingFrom(have []cid.Cid, other []cid.Cid) []cid.Cid {
+	otherSet := make(map[cid.Cid]struct{}, len(other))
+	for _, c := range other {
+		otherSet[c] = struct{}{}
+	}
+	var missing []cid.Cid
+	for _, c := range have {
+		if _, ok := otherSet[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+func describeMissing(missing []cid.Cid, receivedOver string, missingOver string) string {
+	examples := missing
+	if len(examples) > maxMissingExamples {
+		examples = examples[:maxMissingExamples]
+	}
+	return fmt.Sprintf("%d block(s) received over %s were not received over %s, including %v", len(missing), receivedOver, missingOver, examples)
+}
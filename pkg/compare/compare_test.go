@@ -0,0 +1,191 @@
+package compare_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/compare"
+	"github.com/filecoin-project/lassie/pkg/internal/mockfetcher"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	blks := testutil.GenerateBlocksOfSize(4, 100)
+	provider := peer.AddrInfo{ID: peer.ID("A")}
+	failed := errors.New("retrieval failed")
+	graphsync := multicodec.TransportGraphsyncFilecoinv1
+	http := multicodec.TransportIpfsGatewayHttp
+	bitswap := multicodec.TransportBitswap
+
+	type served struct {
+		blocks []blocks.Block
+		err    error
+	}
+
+	testCases := []struct {
+		name                  string
+		fixedPeers            []peer.AddrInfo
+		protocols             []multicodec.Code
+		served                map[multicodec.Code]served
+		expectErr             error
+		expectBlocks          []uint64
+		expectErrors          []bool
+		expectInconsistencies []string
+	}{
+		{
+			name:       "consistent",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{graphsync, http},
+			served: map[multicodec.Code]served{
+				graphsync: {blocks: blks},
+				http:      {blocks: blks},
+			},
+			expectBlocks:          []uint64{4, 4},
+			expectErrors:          []bool{false, false},
+			expectInconsistencies: []string{},
+		},
+		{
+			name:       "missing blocks",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{graphsync, http},
+			served: map[multicodec.Code]served{
+				graphsync: {blocks: blks},
+				http:      {blocks: blks[:2]},
+			},
+			expectBlocks: []uint64{4, 2},
+			expectErrors: []bool{false, false},
+			expectInconsistencies: []string{
+				"2 block(s) received over transport-graphsync-filecoinv1 were not received over transport-ipfs-gateway-http, including [" + blks[2].Cid().String() + " " + blks[3].Cid().String() + "]",
+			},
+		},
+		{
+			name:       "different order",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{graphsync, http},
+			served: map[multicodec.Code]served{
+				graphsync: {blocks: blks},
+				http:      {blocks: []blocks.Block{blks[0], blks[2], blks[1], blks[3]}},
+			},
+			expectBlocks: []uint64{4, 4},
+			expectErrors: []bool{false, false},
+			expectInconsistencies: []string{
+				"blocks were received in a different order over transport-graphsync-filecoinv1 and transport-ipfs-gateway-http, first differing at block 1: " + blks[1].Cid().String() + " != " + blks[2].Cid().String(),
+			},
+		},
+		{
+			name:       "bitswap order is ignored",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{bitswap, http},
+			served: map[multicodec.Code]served{
+				bitswap: {blocks: []blocks.Block{blks[3], blks[2], blks[1], blks[0]}},
+				http:    {blocks: blks},
+			},
+			expectBlocks:          []uint64{4, 4},
+			expectErrors:          []bool{false, false},
+			expectInconsistencies: []string{},
+		},
+		{
+			name:       "one protocol fails",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{graphsync, http},
+			served: map[multicodec.Code]served{
+				graphsync: {blocks: blks[:1], err: failed},
+				http:      {blocks: blks},
+			},
+			expectBlocks: []uint64{1, 4},
+			expectErrors: []bool{true, false},
+			expectInconsistencies: []string{
+				"transport-ipfs-gateway-http succeeded but transport-graphsync-filecoinv1 failed: retrieval failed",
+			},
+		},
+		{
+			name:       "all protocols fail",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{graphsync, http},
+			served: map[multicodec.Code]served{
+				graphsync: {err: failed},
+				http:      {err: failed},
+			},
+			expectBlocks:          []uint64{0, 0},
+			expectErrors:          []bool{true, true},
+			expectInconsistencies: []string{},
+		},
+		{
+			name:      "no provider",
+			protocols: []multicodec.Code{graphsync, http},
+			expectErr: compare.ErrSingleProviderRequired,
+		},
+		{
+			name:       "multiple providers",
+			fixedPeers: []peer.AddrInfo{provider, {ID: peer.ID("B")}},
+			protocols:  []multicodec.Code{graphsync, http},
+			expectErr:  compare.ErrSingleProviderRequired,
+		},
+		{
+			name:       "one protocol",
+			fixedPeers: []peer.AddrInfo{provider},
+			protocols:  []multicodec.Code{graphsync},
+			expectErr:  compare.ErrTooFewProtocols,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			retrievalIds := make(map[types.RetrievalID]struct{})
+			fetcher := &mockfetcher.MockFetcher{
+				FetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+					require.Len(t, r.Protocols, 1)
+					require.Equal(t, tc.fixedPeers, r.FixedPeers)
+					retrievalIds[r.RetrievalID] = struct{}{}
+					s := tc.served[r.Protocols[0]]
+					var size uint64
+					for _, blk := range s.blocks {
+						w, commit, err := r.LinkSystem.StorageWriteOpener(linking.LinkContext{Ctx: ctx})
+						require.NoError(t, err)
+						_, err = w.Write(blk.RawData())
+						require.NoError(t, err)
+						require.NoError(t, commit(cidlink.Link{Cid: blk.Cid()}))
+						size += uint64(len(blk.RawData()))
+					}
+					if s.err != nil {
+						return nil, s.err
+					}
+					return &types.RetrievalStats{Size: size, Blocks: uint64(len(s.blocks))}, nil
+				},
+			}
+
+			request := types.RetrievalRequest{
+				Request:    trustlessutils.Request{Root: blks[0].Cid(), Scope: trustlessutils.DagScopeAll},
+				FixedPeers: tc.fixedPeers,
+			}
+			report, err := compare.Compare(ctx, fetcher, request, t.TempDir(), tc.protocols...)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, retrievalIds, len(tc.protocols))
+			require.Equal(t, blks[0].Cid(), report.Root)
+			require.Equal(t, provider.ID, report.Provider)
+			require.Len(t, report.Results, len(tc.protocols))
+			for i, result := range report.Results {
+				require.Equal(t, tc.protocols[i].String(), result.Protocol)
+				require.Equal(t, tc.expectBlocks[i], result.Blocks)
+				require.Equal(t, tc.expectErrors[i], !result.Succeeded())
+			}
+			require.Equal(t, tc.expectInconsistencies, report.Inconsistencies)
+			require.Equal(t, len(tc.expectInconsistencies) == 0, report.Consistent())
+		})
+	}
+}
@@ -0,0 +1,438 @@
+/*
+Package conformance exercises a provider's HTTP endpoint with a battery of
+requests drawn from the Trustless Gateway specification,
+https://specs.ipfs.tech/http-gateways/trustless-gateway/, and reports which
+of them the provider handles as the specification requires. Responses are
+verified with the same machinery Lassie uses for its own HTTP retrievals, so a
+provider that passes is one Lassie can retrieve from.
+*/
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/build"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/multiformats/go-multihash"
+)
+
+var logger = logging.Subsystem("lassie/conformance")
+
+var ErrInvalidProviderURL = errors.New("provider URL must be an http or https URL")
+
+// maxRawBlockSize is the largest block read from a raw block response, the
+// largest block Lassie accepts over Bitswap
+const maxRawBlockSize = 2 << 20
+
+// Level is the requirement level of a case, as the specification words it.
+type Level string
+
+const (
+	// LevelMust cases are required of every trustless gateway.
+	LevelMust Level = "MUST"
+	// LevelShould cases are recommended, a provider failing them may still be
+	// usable.
+	LevelShould Level = "SHOULD"
+)
+
+// CaseResult is the outcome of a single case.
+type CaseResult struct {
+	Name        string        `json:"name"`
+	Area        string        `json:"area"`
+	Level       Level         `json:"level"`
+	Description string        `json:"description"`
+	URL         string        `json:"url"`
+	Accept      string        `json:"accept,omitempty"`
+	Status      int           `json:"status,omitempty"`
+	ContentType string        `json:"contentType,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Blocks      uint64        `json:"blocks,omitempty"`
+	Bytes       uint64        `json:"bytes,omitempty"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Passed returns true if the provider handled the case as the specification
+// requires.
+func (cr CaseResult) Passed() bool {
+	return cr.Error == ""
+}
+
+// Report is the outcome of a conformance run, with a result for each case in
+// the order they were run.
+type Report struct {
+	Provider string       `json:"provider"`
+	Root     cid.Cid      `json:"root"`
+	Path     string       `json:"path,omitempty"`
+	Results  []CaseResult `json:"results"`
+}
+
+// Failed returns the number of cases of the given level that failed.
+func (r Report) Failed(level Level) int {
+	var failed int
+	for _, result := range r.Results {
+		if result.Level == level && !result.Passed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Compliant returns true if every MUST case passed.
+func (r Report) Compliant() bool {
+	return r.Failed(LevelMust) == 0
+}
+
+// Run exercises the trustless gateway at providerURL with each case in turn,
+// using request's Root and Path as the content the provider is expected to
+// serve; its Scope and Bytes are ignored as the cases choose their own. The
+// root should be a UnixFS file, or a directory with a file at Path, for the
+// "entity-bytes" case to be meaningful. The client's Timeout, if any, applies
+// to each case.
+//
+// An error is only returned if the cases couldn't be run, failed cases are
+// recorded in the Report.
+func Run(ctx context.Context, client *http.Client, providerURL string, request trustlessutils.Request) (*Report, error) {
+	u, err := url.Parse(providerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidProviderURL
+	}
+	baseURL := strings.TrimSuffix(u.String(), "/")
+	missing, err := randomCid()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Provider: baseURL,
+		Root:     request.Root,
+		Path:     request.Path,
+	}
+	for _, c := range cases(request, missing) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		report.Results = append(report.Results, c.run(ctx, client, baseURL))
+	}
+	return report, nil
+}
+
+// testCase is a single request and the checks made of its response.
+type testCase struct {
+	name        string
+	area        string
+	level       Level
+	description string
+	// urlPath follows /ipfs/ in the request URL, including any query
+	urlPath string
+	accept  string
+	check   func(ctx context.Context, res *http.Response, result *CaseResult) error
+}
+
+func (tc testCase) run(ctx context.Context, client *http.Client, baseURL string) CaseResult {
+	result := CaseResult{
+		Name:        tc.name,
+		Area:        tc.area,
+		Level:       tc.level,
+		Description: tc.description,
+		URL:         baseURL + "/ipfs/" + tc.urlPath,
+		Accept:      tc.accept,
+	}
+	logger.Debugw("Running conformance case", "case", tc.name, "url", result.URL)
+	start := time.Now()
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, result.URL, nil)
+		if err != nil {
+			return err
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		req.Header.Set("User-Agent", build.UserAgent)
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		result.Status = res.StatusCode
+		result.ContentType = res.Header.Get("Content-Type")
+		return tc.check(ctx, res, &result)
+	}()
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// cases returns the cases run for the request, with missing as the CID of
+// content the provider can't have.
+func cases(request trustlessutils.Request, missing cid.Cid) []testCase {
+	root := request.Root.String()
+	withScope := func(scope trustlessutils.DagScope) trustlessutils.Request {
+		return trustlessutils.Request{Root: request.Root, Path: request.Path, Scope: scope}
+	}
+	urlPath := func(r trustlessutils.Request) string {
+		p, _ := r.UrlPath()
+		return r.Root.String() + p
+	}
+	all := withScope(trustlessutils.DagScopeAll)
+	entity := withScope(trustlessutils.DagScopeEntity)
+	block := withScope(trustlessutils.DagScopeBlock)
+	var to int64 = 1023
+	entityBytes := withScope(trustlessutils.DagScopeEntity)
+	entityBytes.Bytes = &trustlessutils.ByteRange{From: 0, To: &to}
+	carAccept := trustlesshttp.DefaultContentType()
+
+	return []testCase{
+		{
+			name:        "raw-block",
+			area:        "formats",
+			level:       LevelMust,
+			description: "application/vnd.ipld.raw responds with the root block",
+			urlPath:     root,
+			accept:      trustlesshttp.MimeTypeRaw,
+			check:       expectRawBlock(request.Root),
+		},
+		{
+			name:        "car-scope-all",
+			area:        "scopes",
+			level:       LevelMust,
+			description: "dag-scope=all responds with the entire DAG at the path",
+			urlPath:     urlPath(all),
+			accept:      carAccept.String(),
+			check:       expectCar(all, nil, ""),
+		},
+		{
+			name:        "car-scope-entity",
+			area:        "scopes",
+			level:       LevelMust,
+			description: "dag-scope=entity responds with the entity at the path",
+			urlPath:     urlPath(entity),
+			accept:      carAccept.String(),
+			check:       expectCar(entity, nil, ""),
+		},
+		{
+			name:        "car-scope-block",
+			area:        "scopes",
+			level:       LevelMust,
+			description: "dag-scope=block responds with the block at the path",
+			urlPath:     urlPath(block),
+			accept:      carAccept.String(),
+			check:       expectCar(block, nil, ""),
+		},
+		{
+			name:        "car-entity-bytes",
+			area:        "entity-bytes",
+			level:       LevelShould,
+			description: "entity-bytes=0:1023 responds with the blocks of the first KiB of the entity",
+			urlPath:     urlPath(entityBytes),
+			accept:      carAccept.String(),
+			check:       expectCar(entityBytes, nil, ""),
+		},
+		{
+			name:        "car-dups-n",
+			area:        "dups",
+			level:       LevelShould,
+			description: "dups=n responds with each block once",
+			urlPath:     urlPath(all),
+			accept:      carAccept.WithDuplicates(false).String(),
+			check:       expectCar(all, boolPtr(false), ""),
+		},
+		{
+			name:        "car-dups-y",
+			area:        "dups",
+			level:       LevelShould,
+			description: "dups=y responds with each block as often as the traversal meets it",
+			urlPath:     urlPath(all),
+			accept:      carAccept.WithDuplicates(true).String(),
+			check:       expectCar(all, boolPtr(true), ""),
+		},
+		{
+			name:        "car-order-dfs",
+			area:        "order",
+			level:       LevelShould,
+			description: "order=dfs responds with the blocks in depth-first order",
+			urlPath:     urlPath(all),
+			accept:      carAccept.WithOrder(trustlesshttp.ContentTypeOrderDfs).String(),
+			check:       expectCar(all, nil, trustlesshttp.ContentTypeOrderDfs),
+		},
+		{
+			name:        "car-format-param",
+			area:        "formats",
+			level:       LevelShould,
+			description: "format=car without an Accept header responds with a CAR",
+			urlPath:     urlPath(all) + "&format=" + trustlesshttp.FormatParameterCar,
+			check:       expectCar(all, nil, ""),
+		},
+		{
+			name:        "error-invalid-cid",
+			area:        "errors",
+			level:       LevelMust,
+			description: "an invalid CID responds with 400",
+			urlPath:     "not-a-cid?dag-scope=all",
+			accept:      carAccept.String(),
+			check:       expectStatus(http.StatusBadRequest),
+		},
+		{
+			name:        "error-invalid-scope",
+			area:        "errors",
+			level:       LevelShould,
+			description: "an unknown dag-scope responds with 400",
+			urlPath:     root + "?dag-scope=invalid",
+			accept:      carAccept.String(),
+			check:       expectStatus(http.StatusBadRequest),
+		},
+		{
+			name:        "error-invalid-entity-bytes",
+			area:        "errors",
+			level:       LevelShould,
+			description: "a malformed entity-bytes responds with 400",
+			urlPath:     root + "?dag-scope=entity&entity-bytes=invalid",
+			accept:      carAccept.String(),
+			check:       expectStatus(http.StatusBadRequest),
+		},
+		{
+			name:        "error-unsupported-accept",
+			area:        "errors",
+			level:       LevelShould,
+			description: "an Accept header without a trustless format responds with 400 or 406",
+			urlPath:     root,
+			accept:      "text/html",
+			check:       expectStatus(http.StatusBadRequest, http.StatusNotAcceptable),
+		},
+		{
+			name:        "error-missing-content",
+			area:        "errors",
+			level:       LevelShould,
+			description: "content the provider doesn't have responds with 404",
+			urlPath:     missing.String() + "?dag-scope=all",
+			accept:      carAccept.String(),
+			check:       expectStatus(http.StatusNotFound),
+		},
+	}
+}
+
+func expectStatus(codes ...int) func(context.Context, *http.Response, *CaseResult) error {
+	return func(_ context.Context, res *http.Response, _ *CaseResult) error {
+		for _, code := range codes {
+			if res.StatusCode == code {
+				return nil
+			}
+		}
+		want := make([]string, 0, len(codes))
+		for _, code := range codes {
+			want = append(want, fmt.Sprint(code))
+		}
+		return fmt.Errorf("expected status %s, got %d", strings.Join(want, " or "), res.StatusCode)
+	}
+}
+
+func expectRawBlock(root cid.Cid) func(context.Context, *http.Response, *CaseResult) error {
+	return func(ctx context.Context, res *http.Response, result *CaseResult) error {
+		if err := expectStatus(http.StatusOK)(ctx, res, result); err != nil {
+			return err
+		}
+		if contentType, valid := trustlesshttp.ParseContentType(res.Header.Get("Content-Type")); !valid || !contentType.IsRaw() {
+			return fmt.Errorf("expected Content-Type %s, got %q", trustlesshttp.MimeTypeRaw, res.Header.Get("Content-Type"))
+		}
+		data, err := io.ReadAll(io.LimitReader(res.Body, maxRawBlockSize+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxRawBlockSize {
+			return fmt.Errorf("block is larger than %d bytes", maxRawBlockSize)
+		}
+		result.Bytes = uint64(len(data))
+		got, err := root.Prefix().Sum(data)
+		if err != nil {
+			return err
+		}
+		if !got.Equals(root) {
+			return fmt.Errorf("block hashes to %s, not the requested CID", got)
+		}
+		result.Blocks = 1
+		return nil
+	}
+}
+
+// expectCar checks for a CAR holding exactly the blocks of the request,
+// verified as Lassie verifies an HTTP retrieval. If duplicates is set, the
+// Content-Type must declare that dups value, and if order is set that order.
+func expectCar(
+	request trustlessutils.Request,
+	duplicates *bool,
+	order trustlesshttp.ContentTypeOrder,
+) func(context.Context, *http.Response, *CaseResult) error {
+	return func(ctx context.Context, res *http.Response, result *CaseResult) error {
+		if err := expectStatus(http.StatusOK)(ctx, res, result); err != nil {
+			return err
+		}
+		contentType, valid := trustlesshttp.ParseContentType(res.Header.Get("Content-Type"))
+		if !valid || contentType.MimeType != trustlesshttp.MimeTypeCar {
+			return fmt.Errorf("expected Content-Type %s, got %q", trustlesshttp.MimeTypeCar, res.Header.Get("Content-Type"))
+		}
+		if duplicates != nil && contentType.Duplicates != *duplicates {
+			return fmt.Errorf("expected Content-Type with dups=%s, got %q", yesNo(*duplicates), res.Header.Get("Content-Type"))
+		}
+		if order != "" && contentType.Order != order {
+			return fmt.Errorf("expected Content-Type with order=%s, got %q", order, res.Header.Get("Content-Type"))
+		}
+
+		cfg := traversal.Config{
+			Root:               request.Root,
+			Selector:           request.Selector(),
+			ExpectDuplicatesIn: contentType.Duplicates,
+		}
+		traversalResult, err := cfg.VerifyCar(ctx, res.Body, newLinkSystem())
+		result.Blocks = traversalResult.BlocksIn
+		result.Bytes = traversalResult.BytesIn
+		return err
+	}
+}
+
+// newLinkSystem returns a LinkSystem for verifying a single response.
+func newLinkSystem() linking.LinkSystem {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	return lsys
+}
+
+// randomCid returns the CID of a random raw block, content no provider has.
+func randomCid() (cid.Cid, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(data)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "y"
+	}
+	return "n"
+}
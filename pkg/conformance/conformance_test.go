@@ -0,0 +1,178 @@
+package conformance_test
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/conformance"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/stretchr/testify/require"
+)
+
+// gateway is a minimal trustless gateway over a memstore, which can be made
+// to misbehave
+type gateway struct {
+	t     *testing.T
+	store *memstore.Store
+	lsys  linking.LinkSystem
+
+	noRaw         bool
+	noContentType bool
+	corrupt       bool
+	missingStatus int
+}
+
+func (g *gateway) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	root, path, err := trustlesshttp.ParseUrlPath(req.URL.Path)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	accepts, err := trustlesshttp.CheckFormat(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if has, _ := g.store.Has(req.Context(), root.KeyString()); !has {
+		http.Error(res, "not found", g.missingStatus)
+		return
+	}
+	if accepts[0].IsRaw() {
+		if g.noRaw {
+			http.Error(res, "raw blocks are not supported", http.StatusNotAcceptable)
+			return
+		}
+		data, err := g.store.Get(req.Context(), root.KeyString())
+		require.NoError(g.t, err)
+		res.Header().Set("Content-Type", trustlesshttp.MimeTypeRaw)
+		_, _ = res.Write(data)
+		return
+	}
+	scope, err := trustlesshttp.ParseScope(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	byteRange, err := trustlesshttp.ParseByteRange(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := trustlessutils.Request{Root: root, Path: path.String(), Scope: scope, Bytes: byteRange}
+
+	if !g.noContentType {
+		res.Header().Set("Content-Type", trustlesshttp.DefaultContentType().WithDuplicates(accepts[0].Duplicates).String())
+	}
+	car, err := carstorage.NewWritable(res, []cid.Cid{root}, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(accepts[0].Duplicates))
+	require.NoError(g.t, err)
+	for i, blk := range testutil.ToBlocks(g.t, g.lsys, root, request.Selector()) {
+		data := blk.RawData()
+		if g.corrupt && i > 0 {
+			data = append([]byte{}, data...)
+			data[0] ^= 0xff
+		}
+		require.NoError(g.t, car.Put(req.Context(), blk.Cid().KeyString(), data))
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	file := unixfs.GenerateFile(t, &lsys, rand.New(rand.NewSource(0)), 1<<20)
+
+	testCases := []struct {
+		name          string
+		gateway       gateway
+		wantCompliant bool
+		wantFailed    []string
+	}{
+		{
+			name:          "conforming",
+			gateway:       gateway{missingStatus: http.StatusNotFound},
+			wantCompliant: true,
+		},
+		{
+			name:          "without raw blocks",
+			gateway:       gateway{missingStatus: http.StatusNotFound, noRaw: true},
+			wantCompliant: false,
+			wantFailed:    []string{"raw-block"},
+		},
+		{
+			name:          "502 for missing content",
+			gateway:       gateway{missingStatus: http.StatusBadGateway},
+			wantCompliant: true,
+			wantFailed:    []string{"error-missing-content"},
+		},
+		{
+			name:          "without Content-Type",
+			gateway:       gateway{missingStatus: http.StatusNotFound, noContentType: true},
+			wantCompliant: false,
+			wantFailed:    []string{"car-scope-all", "car-scope-entity", "car-scope-block", "car-entity-bytes", "car-dups-n", "car-dups-y", "car-order-dfs", "car-format-param"},
+		},
+		{
+			name:          "corrupt blocks",
+			gateway:       gateway{missingStatus: http.StatusNotFound, corrupt: true},
+			wantCompliant: false,
+			// the root block is intact, so only cases beyond it fail
+			wantFailed: []string{"car-scope-all", "car-scope-entity", "car-entity-bytes", "car-dups-n", "car-dups-y", "car-order-dfs", "car-format-param"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			g := testCase.gateway
+			g.t = t
+			g.store = store
+			g.lsys = lsys
+			server := httptest.NewServer(&g)
+			defer server.Close()
+
+			report, err := conformance.Run(ctx, server.Client(), server.URL+"/", trustlessutils.Request{Root: file.Root})
+			require.NoError(t, err)
+			require.Equal(t, server.URL, report.Provider)
+			require.Equal(t, file.Root, report.Root)
+			require.Equal(t, testCase.wantCompliant, report.Compliant())
+
+			var failed []string
+			for _, result := range report.Results {
+				if !result.Passed() {
+					failed = append(failed, result.Name)
+				}
+			}
+			require.Equal(t, testCase.wantFailed, failed)
+
+			if testCase.wantFailed == nil {
+				for _, result := range report.Results {
+					if result.Name == "car-scope-all" {
+						require.Equal(t, uint64(len(file.SelfCids)), result.Blocks)
+					}
+					if result.Name == "car-scope-block" {
+						require.Equal(t, uint64(1), result.Blocks)
+					}
+				}
+			}
+		})
+	}
+
+	_, err := conformance.Run(ctx, http.DefaultClient, "ftp://example.com", trustlessutils.Request{Root: file.Root})
+	require.ErrorIs(t, err, conformance.ErrInvalidProviderURL)
+}
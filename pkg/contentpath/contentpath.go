@@ -0,0 +1,218 @@
+// Package contentpath normalizes the different ways of addressing IPFS
+// content into a single form. It accepts:
+//
+//   - content paths: /ipfs/<cid>/path and /ipns/<name>/path
+//   - native URLs: ipfs://<cid>/path and ipns://<name>/path
+//   - path gateway URLs: https://<gateway>/ipfs/<cid>/path
+//   - subdomain gateway URLs: https://<cid>.ipfs.<gateway>/path and
+//     https://<name>.ipns.<gateway>/path, with or without the scheme
+//   - bare CIDs: <cid>/path
+//
+// Any of these may carry a query string, such as ?dag-scope=entity, which is
+// kept with the parsed path.
+package contentpath
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+// Namespace is the namespace a content path is addressed in.
+type Namespace string
+
+const (
+	// NamespaceIPFS addresses immutable content by CID.
+	NamespaceIPFS Namespace = "ipfs"
+	// NamespaceIPNS addresses mutable content by IPNS name, which must be
+	// resolved to a CID before it can be retrieved.
+	NamespaceIPNS Namespace = "ipns"
+)
+
+var (
+	// ErrNotContentPath is returned when a string is not in any of the
+	// supported forms, or is missing the CID or name.
+	ErrNotContentPath = errors.New("not an /ipfs/ or /ipns/ content path")
+	// ErrBadCid is returned when the root of an /ipfs/ path is not a valid CID.
+	ErrBadCid = errors.New("failed to parse root CID")
+)
+
+// ContentPath is a normalized reference to IPFS content.
+type ContentPath struct {
+	Namespace Namespace
+	// Root is the root CID of an /ipfs/ path, and cid.Undef for /ipns/ paths.
+	Root cid.Cid
+	// Name is the name of an /ipns/ path, and empty for /ipfs/ paths. Inlined
+	// DNSLink names from subdomain gateways are decoded, so
+	// en-wikipedia--on--ipfs-org becomes en.wikipedia-on-ipfs.org.
+	Name string
+	// Path is the path within the content below the root.
+	Path datamodel.Path
+	// Query holds the query parameters, if any.
+	Query url.Values
+}
+
+// Parse parses a string in any of the supported forms.
+func Parse(s string) (ContentPath, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") && !strings.HasPrefix(s, "/") {
+		first, _, _ := strings.Cut(s, "/")
+		first, _, _ = strings.Cut(first, "?")
+		if strings.Contains(first, ".") {
+			// CIDs never contain a '.', so this is a gateway host
+			s = "https://" + s
+		} else {
+			s = "/ipfs/" + s
+		}
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ContentPath{}, fmt.Errorf("%w: %s", ErrNotContentPath, err)
+	}
+	return ParseURL(u)
+}
+
+// ParseURL parses a URL in any of the supported forms. A URL with no scheme
+// and no host is treated as a content path.
+func ParseURL(u *url.URL) (ContentPath, error) {
+	var p ContentPath
+	var err error
+	switch strings.ToLower(u.Scheme) {
+	case "":
+		p, err = ParsePath(u.Path)
+	case "ipfs", "ipns":
+		if u.Opaque != "" {
+			return ContentPath{}, fmt.Errorf("%w: %s", ErrNotContentPath, u)
+		}
+		p, err = newContentPath(Namespace(strings.ToLower(u.Scheme)), u.Host, datamodel.ParsePath(u.Path))
+	case "http", "https":
+		labels := strings.Split(u.Hostname(), ".")
+		if len(labels) > 2 && (labels[1] == string(NamespaceIPFS) || labels[1] == string(NamespaceIPNS)) {
+			p, err = newContentPath(Namespace(labels[1]), labels[0], datamodel.ParsePath(u.Path))
+		} else {
+			p, err = ParsePath(u.Path)
+		}
+	default:
+		return ContentPath{}, fmt.Errorf("%w: unsupported scheme %q", ErrNotContentPath, u.Scheme)
+	}
+	if err != nil {
+		return ContentPath{}, err
+	}
+	if u.RawQuery != "" {
+		if p.Query, err = url.ParseQuery(u.RawQuery); err != nil {
+			return ContentPath{}, fmt.Errorf("invalid query: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// ParsePath parses an /ipfs/<cid>/path or /ipns/<name>/path content path. The
+// path should already be unescaped, as it is in url.URL.Path, and is not
+// checked for a query string.
+func ParsePath(path string) (ContentPath, error) {
+	segments := datamodel.ParsePath(path)
+	ns, segments := segments.Shift()
+	if segments.Len() == 0 {
+		return ContentPath{}, ErrNotContentPath
+	}
+	root, segments := segments.Shift()
+	switch ns.String() {
+	case string(NamespaceIPFS), string(NamespaceIPNS):
+		return newContentPath(Namespace(ns.String()), root.String(), segments)
+	default:
+		return ContentPath{}, ErrNotContentPath
+	}
+}
+
+func newContentPath(ns Namespace, root string, path datamodel.Path) (ContentPath, error) {
+	if root == "" {
+		return ContentPath{}, ErrNotContentPath
+	}
+	if ns == NamespaceIPNS {
+		if _, err := cid.Decode(root); err != nil && !strings.Contains(root, ".") {
+			root = decodeDNSLinkLabel(root)
+		}
+		return ContentPath{Namespace: ns, Root: cid.Undef, Name: root, Path: path}, nil
+	}
+	c, err := cid.Parse(root)
+	if err != nil {
+		return ContentPath{}, fmt.Errorf("%w %q: %s", ErrBadCid, root, err)
+	}
+	return ContentPath{Namespace: ns, Root: c, Path: path}, nil
+}
+
+// decodeDNSLinkLabel decodes a DNSLink name inlined into a single DNS label,
+// where '.' is replaced with '-' and '-' with "--".
+func decodeDNSLinkLabel(label string) string {
+	const placeholder = "\x00"
+	label = strings.ReplaceAll(label, "--", placeholder)
+	label = strings.ReplaceAll(label, "-", ".")
+	return strings.ReplaceAll(label, placeholder, "-")
+}
+
+// Resolved returns the /ipfs/ path that an /ipns/ path resolves to, given the
+// CID and path that its name resolves to. The path of p is appended to the
+// resolved path and its query is kept.
+func (p ContentPath) Resolved(root cid.Cid, path datamodel.Path) ContentPath {
+	return ContentPath{
+		Namespace: NamespaceIPFS,
+		Root:      root,
+		Path:      path.Join(p.Path),
+		Query:     p.Query,
+	}
+}
+
+// String returns the canonical /ipfs/<cid>/path?query or
+// /ipns/<name>/path?query form of the content path.
+func (p ContentPath) String() string {
+	var sb strings.Builder
+	sb.WriteString("/")
+	sb.WriteString(string(p.Namespace))
+	sb.WriteString("/")
+	if p.Namespace == NamespaceIPNS {
+		sb.WriteString(p.Name)
+	} else {
+		sb.WriteString(p.Root.String())
+	}
+	if p.Path.Len() > 0 {
+		sb.WriteString("/")
+		sb.WriteString(p.Path.String())
+	}
+	if len(p.Query) > 0 {
+		sb.WriteString("?")
+		sb.WriteString(p.Query.Encode())
+	}
+	return sb.String()
+}
+
+// DagScope returns the dag-scope query parameter, defaulting to
+// trustlessutils.DagScopeAll.
+func (p ContentPath) DagScope() (trustlessutils.DagScope, error) {
+	if p.Query.Get("dag-scope") == "" {
+		return trustlessutils.DagScopeAll, nil
+	}
+	return trustlessutils.ParseDagScope(p.Query.Get("dag-scope"))
+}
+
+// EntityBytes returns the entity-bytes query parameter, or nil if it isn't
+// set.
+func (p ContentPath) EntityBytes() (*trustlessutils.ByteRange, error) {
+	if p.Query.Get("entity-bytes") == "" {
+		return nil, nil
+	}
+	br, err := trustlessutils.ParseByteRange(p.Query.Get("entity-bytes"))
+	if err != nil {
+		return nil, err
+	}
+	return &br, nil
+}
+
+// Duplicates returns whether the dups=y query parameter is set.
+func (p ContentPath) Duplicates() bool {
+	return p.Query.Get("dups") == "y"
+}
@@ -0,0 +1,196 @@
+package contentpath_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/contentpath"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCid   = "bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4"
+	testCidV0 = "QmXoypizjW3WknFiJnKLwHCnL72vedxjQkDDP1mXWo6uco"
+	testKey   = "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		// content paths
+		{name: "ipfs path", input: "/ipfs/" + testCid, want: "/ipfs/" + testCid},
+		{name: "ipfs path with path", input: "/ipfs/" + testCid + "/a/b.txt", want: "/ipfs/" + testCid + "/a/b.txt"},
+		{name: "ipfs path with trailing and repeated slashes", input: "/ipfs/" + testCid + "//a/b/", want: "/ipfs/" + testCid + "/a/b"},
+		{name: "ipfs path with query", input: "/ipfs/" + testCid + "/a?dag-scope=entity&dups=y", want: "/ipfs/" + testCid + "/a?dag-scope=entity&dups=y"},
+		{name: "ipfs path with escaped path", input: "/ipfs/" + testCid + "/a%20b", want: "/ipfs/" + testCid + "/a b"},
+		{name: "ipfs path with CIDv0", input: "/ipfs/" + testCidV0, want: "/ipfs/" + testCidV0},
+		{name: "ipns path", input: "/ipns/" + testKey + "/a", want: "/ipns/" + testKey + "/a"},
+		{name: "ipns path with DNSLink name", input: "/ipns/en.wikipedia-on-ipfs.org/wiki", want: "/ipns/en.wikipedia-on-ipfs.org/wiki"},
+		{name: "ipfs path without CID", input: "/ipfs/", wantErr: contentpath.ErrNotContentPath},
+		{name: "ipfs path with bad CID", input: "/ipfs/bafyfoo", wantErr: contentpath.ErrBadCid},
+		{name: "other path", input: "/foo/" + testCid, wantErr: contentpath.ErrNotContentPath},
+
+		// bare CIDs
+		{name: "bare CID", input: testCid, want: "/ipfs/" + testCid},
+		{name: "bare CIDv0", input: testCidV0, want: "/ipfs/" + testCidV0},
+		{name: "bare CID with path", input: testCid + "/a/b.txt", want: "/ipfs/" + testCid + "/a/b.txt"},
+		{name: "bare CID with query", input: testCid + "?dag-scope=block", want: "/ipfs/" + testCid + "?dag-scope=block"},
+		{name: "bare CID with surrounding space", input: " " + testCid + "\n", want: "/ipfs/" + testCid},
+		{name: "bad bare CID", input: "bafyfoo", wantErr: contentpath.ErrBadCid},
+		{name: "empty", input: "", wantErr: contentpath.ErrNotContentPath},
+
+		// native URLs
+		{name: "ipfs URL", input: "ipfs://" + testCid, want: "/ipfs/" + testCid},
+		{name: "ipfs URL with path and query", input: "ipfs://" + testCid + "/a/b.txt?dag-scope=entity", want: "/ipfs/" + testCid + "/a/b.txt?dag-scope=entity"},
+		{name: "ipfs URL with CIDv0", input: "ipfs://" + testCidV0 + "/a", want: "/ipfs/" + testCidV0 + "/a"},
+		{name: "ipfs URL with upper case scheme", input: "IPFS://" + testCid, want: "/ipfs/" + testCid},
+		{name: "ipfs URL with fragment", input: "ipfs://" + testCid + "/a#section", want: "/ipfs/" + testCid + "/a"},
+		{name: "ipns URL", input: "ipns://" + testKey + "/a", want: "/ipns/" + testKey + "/a"},
+		{name: "ipns URL with DNSLink name", input: "ipns://docs.ipfs.tech/install", want: "/ipns/docs.ipfs.tech/install"},
+		{name: "ipfs URL without CID", input: "ipfs:///a", wantErr: contentpath.ErrNotContentPath},
+		{name: "ipfs URL with bad CID", input: "ipfs://bafyfoo", wantErr: contentpath.ErrBadCid},
+		{name: "ipfs URL without slashes", input: "ipfs:" + testCid, wantErr: contentpath.ErrBadCid},
+		{name: "unsupported scheme", input: "ftp://example.com/ipfs/" + testCid, wantErr: contentpath.ErrNotContentPath},
+
+		// path gateway URLs
+		{name: "path gateway", input: "https://ipfs.io/ipfs/" + testCid + "/a", want: "/ipfs/" + testCid + "/a"},
+		{name: "path gateway with port and query", input: "http://127.0.0.1:8080/ipfs/" + testCid + "?entity-bytes=0:100", want: "/ipfs/" + testCid + "?entity-bytes=0%3A100"},
+		{name: "path gateway ipns", input: "https://ipfs.io/ipns/" + testKey, want: "/ipns/" + testKey},
+		{name: "path gateway without scheme", input: "ipfs.io/ipfs/" + testCid + "/a", want: "/ipfs/" + testCid + "/a"},
+		{name: "path gateway with other path", input: "https://ipfs.io/about", wantErr: contentpath.ErrNotContentPath},
+		{name: "path gateway with bad CID", input: "https://ipfs.io/ipfs/bafyfoo", wantErr: contentpath.ErrBadCid},
+
+		// subdomain gateway URLs
+		{name: "subdomain gateway", input: "https://" + testCid + ".ipfs.dweb.link/a/b.txt", want: "/ipfs/" + testCid + "/a/b.txt"},
+		{name: "subdomain gateway with port and query", input: "http://" + testCid + ".ipfs.localhost:8080/?dag-scope=entity", want: "/ipfs/" + testCid + "?dag-scope=entity"},
+		{name: "subdomain gateway without scheme", input: testCid + ".ipfs.dweb.link/a", want: "/ipfs/" + testCid + "/a"},
+		{name: "subdomain gateway ipns key", input: "https://" + testKey + ".ipns.dweb.link/a", want: "/ipns/" + testKey + "/a"},
+		{name: "subdomain gateway inlined DNSLink", input: "https://en-wikipedia--on--ipfs-org.ipns.dweb.link/wiki", want: "/ipns/en.wikipedia-on-ipfs.org/wiki"},
+		{name: "subdomain gateway with bad CID", input: "https://bafyfoo.ipfs.dweb.link/", wantErr: contentpath.ErrBadCid},
+		{name: "ipfs host is not a subdomain gateway", input: "https://ipfs.localhost/ipfs/" + testCid, want: "/ipfs/" + testCid},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			p, err := contentpath.Parse(testCase.input)
+			if testCase.wantErr != nil {
+				require.ErrorIs(t, err, testCase.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.want, p.String())
+
+			// the canonical form parses to the same content path
+			again, err := contentpath.Parse(p.String())
+			require.NoError(t, err)
+			require.Equal(t, p, again)
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	// already unescaped, so '%' and '?' are part of the path
+	p, err := contentpath.ParsePath("/ipfs/" + testCid + "/100%/a?b")
+	require.NoError(t, err)
+	require.Equal(t, contentpath.NamespaceIPFS, p.Namespace)
+	require.Equal(t, cid.MustParse(testCid), p.Root)
+	require.Equal(t, datamodel.ParsePath("100%/a?b"), p.Path)
+	require.Nil(t, p.Query)
+
+	_, err = contentpath.ParsePath("/ipfs")
+	require.ErrorIs(t, err, contentpath.ErrNotContentPath)
+}
+
+func TestParseURL(t *testing.T) {
+	u, err := url.Parse("https://" + testCid + ".ipfs.dweb.link/a?dups=y")
+	require.NoError(t, err)
+	p, err := contentpath.ParseURL(u)
+	require.NoError(t, err)
+	require.Equal(t, cid.MustParse(testCid), p.Root)
+	require.Equal(t, datamodel.ParsePath("a"), p.Path)
+	require.True(t, p.Duplicates())
+}
+
+func TestResolved(t *testing.T) {
+	p, err := contentpath.Parse("ipns://" + testKey + "/b/c?dag-scope=entity")
+	require.NoError(t, err)
+	require.Equal(t, contentpath.NamespaceIPNS, p.Namespace)
+	require.Equal(t, testKey, p.Name)
+	require.Equal(t, cid.Undef, p.Root)
+
+	resolved := p.Resolved(cid.MustParse(testCid), datamodel.ParsePath("a"))
+	require.Equal(t, "/ipfs/"+testCid+"/a/b/c?dag-scope=entity", resolved.String())
+}
+
+func TestQueryParameters(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		wantScope      trustlessutils.DagScope
+		wantByteRange  *trustlessutils.ByteRange
+		wantDuplicates bool
+		wantErr        bool
+	}{
+		{
+			name:      "defaults",
+			input:     testCid,
+			wantScope: trustlessutils.DagScopeAll,
+		},
+		{
+			name:      "entity-bytes without dag-scope",
+			input:     testCid + "?entity-bytes=10:*",
+			wantScope: trustlessutils.DagScopeAll,
+			wantByteRange: &trustlessutils.ByteRange{
+				From: 10,
+			},
+		},
+		{
+			name:           "all set",
+			input:          "/ipfs/" + testCid + "?dag-scope=entity&entity-bytes=0:99&dups=y",
+			wantScope:      trustlessutils.DagScopeEntity,
+			wantByteRange:  &trustlessutils.ByteRange{From: 0, To: ptr(int64(99))},
+			wantDuplicates: true,
+		},
+		{
+			name:    "bad dag-scope",
+			input:   testCid + "?dag-scope=nope",
+			wantErr: true,
+		},
+		{
+			name:    "bad entity-bytes",
+			input:   testCid + "?entity-bytes=nope",
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			p, err := contentpath.Parse(testCase.input)
+			require.NoError(t, err)
+			scope, scopeErr := p.DagScope()
+			byteRange, byteRangeErr := p.EntityBytes()
+			if testCase.wantErr {
+				require.True(t, scopeErr != nil || byteRangeErr != nil)
+				return
+			}
+			require.NoError(t, scopeErr)
+			require.NoError(t, byteRangeErr)
+			require.Equal(t, testCase.wantScope, scope)
+			require.Equal(t, testCase.wantByteRange, byteRange)
+			require.Equal(t, testCase.wantDuplicates, p.Duplicates())
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/multiformats/go-multicodec"
+)
+
+var (
+	_ types.RetrievalEvent = CandidateSkippedEvent{}
+	_ EventWithProviderID  = CandidateSkippedEvent{}
+	_ EventWithProtocol    = CandidateSkippedEvent{}
+)
+
+// CandidateSkippedEvent is emitted when a candidate isn't retrieved from over
+// a protocol because its provider demonstrably can't serve it, such as when it
+// was identified as not supporting the protocol.
+type CandidateSkippedEvent struct {
+	providerRetrievalEvent
+	protocol multicodec.Code
+	reason   string
+}
+
+func (e CandidateSkippedEvent) Code() types.EventCode     { return types.CandidateSkippedCode }
+func (e CandidateSkippedEvent) Protocol() multicodec.Code { return e.protocol }
+func (e CandidateSkippedEvent) Reason() string            { return e.reason }
+func (e CandidateSkippedEvent) String() string {
+	return fmt.Sprintf("CandidateSkippedEvent<%s, %s, %s, %s, %s, %s>", e.eventTime, e.retrievalId, e.rootCid, e.providerId, e.protocol, e.reason)
+}
+
+func CandidateSkipped(at time.Time, retrievalId types.RetrievalID, candidate types.RetrievalCandidate, protocol multicodec.Code, reason string) CandidateSkippedEvent {
+	return CandidateSkippedEvent{providerRetrievalEvent{retrievalEvent{at, retrievalId, candidate.RootCid}, candidate.MinerPeer.ID}, protocol, reason}
+}
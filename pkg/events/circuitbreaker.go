@@ -0,0 +1,55 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/multiformats/go-multicodec"
+)
+
+var (
+	_ types.RetrievalEvent = CircuitBreakerOpenedEvent{}
+	_ EventWithProviderID  = CircuitBreakerOpenedEvent{}
+	_ EventWithProtocol    = CircuitBreakerOpenedEvent{}
+	_ types.RetrievalEvent = CircuitBreakerClosedEvent{}
+	_ EventWithProviderID  = CircuitBreakerClosedEvent{}
+	_ EventWithProtocol    = CircuitBreakerClosedEvent{}
+)
+
+// CircuitBreakerOpenedEvent is emitted when a failure to retrieve from a
+// provider opens its circuit breaker, so that it isn't retrieved from again
+// until the cooldown has passed.
+type CircuitBreakerOpenedEvent struct {
+	providerRetrievalEvent
+	protocol multicodec.Code
+	cooldown time.Duration
+}
+
+func (e CircuitBreakerOpenedEvent) Code() types.EventCode     { return types.CircuitBreakerOpenedCode }
+func (e CircuitBreakerOpenedEvent) Protocol() multicodec.Code { return e.protocol }
+func (e CircuitBreakerOpenedEvent) Cooldown() time.Duration   { return e.cooldown }
+func (e CircuitBreakerOpenedEvent) String() string {
+	return fmt.Sprintf("CircuitBreakerOpenedEvent<%s, %s, %s, %s, %s, %s>", e.eventTime, e.retrievalId, e.rootCid, e.providerId, e.protocol, e.cooldown)
+}
+
+func CircuitBreakerOpened(at time.Time, retrievalId types.RetrievalID, candidate types.RetrievalCandidate, protocol multicodec.Code, cooldown time.Duration) CircuitBreakerOpenedEvent {
+	return CircuitBreakerOpenedEvent{providerRetrievalEvent{retrievalEvent{at, retrievalId, candidate.RootCid}, candidate.MinerPeer.ID}, protocol, cooldown}
+}
+
+// CircuitBreakerClosedEvent is emitted when a successful retrieval from a
+// provider closes the circuit breaker that its earlier failures opened.
+type CircuitBreakerClosedEvent struct {
+	providerRetrievalEvent
+	protocol multicodec.Code
+}
+
+func (e CircuitBreakerClosedEvent) Code() types.EventCode     { return types.CircuitBreakerClosedCode }
+func (e CircuitBreakerClosedEvent) Protocol() multicodec.Code { return e.protocol }
+func (e CircuitBreakerClosedEvent) String() string {
+	return fmt.Sprintf("CircuitBreakerClosedEvent<%s, %s, %s, %s, %s>", e.eventTime, e.retrievalId, e.rootCid, e.providerId, e.protocol)
+}
+
+func CircuitBreakerClosed(at time.Time, retrievalId types.RetrievalID, candidate types.RetrievalCandidate, protocol multicodec.Code) CircuitBreakerClosedEvent {
+	return CircuitBreakerClosedEvent{providerRetrievalEvent{retrievalEvent{at, retrievalId, candidate.RootCid}, candidate.MinerPeer.ID}, protocol}
+}
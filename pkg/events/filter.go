@@ -0,0 +1,70 @@
+package events
+
+import (
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/multiformats/go-multicodec"
+	"golang.org/x/exp/slices"
+)
+
+// Filter selects the events delivered to a subscriber, see
+// FilteredSubscriber. Each field that is set narrows the events matched, and
+// an empty Filter matches every event.
+type Filter struct {
+	// Codes matches events with any of the given codes.
+	Codes []types.EventCode
+	// Protocols matches events for any of the given protocols, either for a
+	// single protocol or, like StartedFetchEvent, for a set of protocols.
+	// Events that aren't about a protocol aren't matched.
+	Protocols []multicodec.Code
+	// RetrievalIDs matches events of any of the given retrievals.
+	RetrievalIDs []types.RetrievalID
+}
+
+// Matches returns true if the event is selected by the filter.
+func (f Filter) Matches(event types.RetrievalEvent) bool {
+	if len(f.Codes) > 0 && !slices.Contains(f.Codes, event.Code()) {
+		return false
+	}
+	if len(f.RetrievalIDs) > 0 && !slices.Contains(f.RetrievalIDs, event.RetrievalId()) {
+		return false
+	}
+	if len(f.Protocols) > 0 {
+		switch evt := event.(type) {
+		case EventWithProtocol:
+			return slices.Contains(f.Protocols, evt.Protocol())
+		case EventWithProtocols:
+			for _, protocol := range evt.Protocols() {
+				if slices.Contains(f.Protocols, protocol) {
+					return true
+				}
+			}
+			return false
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// FilteredSubscriber returns a subscriber that passes on only the events
+// matching the filter to the given subscriber.
+func FilteredSubscriber(filter Filter, subscriber types.RetrievalEventSubscriber) types.RetrievalEventSubscriber {
+	return func(event types.RetrievalEvent) {
+		if filter.Matches(event) {
+			subscriber(event)
+		}
+	}
+}
+
+// TypedSubscriber returns a subscriber that passes on only the events of type
+// E to the given function, so that it receives them without a type switch.
+// E may be an event type, such as FailedRetrievalEvent, or one of the
+// interfaces implemented by several event types, such as EventWithProviderID
+// or EventWithErrorMessage.
+func TypedSubscriber[E types.RetrievalEvent](subscriber func(event E)) types.RetrievalEventSubscriber {
+	return func(event types.RetrievalEvent) {
+		if evt, ok := event.(E); ok {
+			subscriber(evt)
+		}
+	}
+}
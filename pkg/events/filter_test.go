@@ -0,0 +1,112 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	idA := types.RetrievalID(uuid.New())
+	idB := types.RetrievalID(uuid.New())
+	root := cid.MustParse("bafkqaalb")
+	candidate := types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peer.ID("A")}, RootCid: root}
+	now := time.Now()
+
+	all := []types.RetrievalEvent{
+		events.StartedFetch(now, idA, root, "", multicodec.TransportBitswap, multicodec.TransportIpfsGatewayHttp),
+		events.StartedFindingCandidates(now, idA, root),
+		events.StartedRetrieval(now, idA, candidate, multicodec.TransportIpfsGatewayHttp),
+		events.FailedRetrieval(now, idA, candidate, multicodec.TransportIpfsGatewayHttp, "nope"),
+		events.StartedRetrieval(now, idB, candidate, multicodec.TransportGraphsyncFilecoinv1),
+		events.Success(now, idB, candidate, 100, 2, time.Second, multicodec.TransportGraphsyncFilecoinv1),
+	}
+
+	testCases := []struct {
+		name     string
+		filter   events.Filter
+		expected []types.RetrievalEvent
+	}{
+		{
+			name:     "empty",
+			expected: all,
+		},
+		{
+			name:     "codes",
+			filter:   events.Filter{Codes: []types.EventCode{types.StartedRetrievalCode, types.SuccessCode}},
+			expected: []types.RetrievalEvent{all[2], all[4], all[5]},
+		},
+		{
+			name:     "protocols",
+			filter:   events.Filter{Protocols: []multicodec.Code{multicodec.TransportIpfsGatewayHttp}},
+			expected: []types.RetrievalEvent{all[0], all[2], all[3]},
+		},
+		{
+			name:     "retrieval ids",
+			filter:   events.Filter{RetrievalIDs: []types.RetrievalID{idB}},
+			expected: []types.RetrievalEvent{all[4], all[5]},
+		},
+		{
+			name: "combined",
+			filter: events.Filter{
+				Codes:        []types.EventCode{types.StartedRetrievalCode},
+				RetrievalIDs: []types.RetrievalID{idA, idB},
+				Protocols:    []multicodec.Code{multicodec.TransportGraphsyncFilecoinv1},
+			},
+			expected: []types.RetrievalEvent{all[4]},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			var got []types.RetrievalEvent
+			subscriber := events.FilteredSubscriber(testCase.filter, func(event types.RetrievalEvent) {
+				got = append(got, event)
+			})
+			for _, event := range all {
+				subscriber(event)
+			}
+			require.Equal(t, testCase.expected, got)
+		})
+	}
+}
+
+func TestTypedSubscriber(t *testing.T) {
+	id := types.RetrievalID(uuid.New())
+	root := cid.MustParse("bafkqaalb")
+	candidate := types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peer.ID("A")}, RootCid: root}
+	now := time.Now()
+
+	var started []events.StartedRetrievalEvent
+	var errorMessages []string
+	subscribers := []types.RetrievalEventSubscriber{
+		events.TypedSubscriber(func(event events.StartedRetrievalEvent) {
+			started = append(started, event)
+		}),
+		events.TypedSubscriber(func(event events.EventWithErrorMessage) {
+			errorMessages = append(errorMessages, event.ErrorMessage())
+		}),
+	}
+	for _, event := range []types.RetrievalEvent{
+		events.StartedFindingCandidates(now, id, root),
+		events.StartedRetrieval(now, id, candidate, multicodec.TransportBitswap),
+		events.FailedRetrieval(now, id, candidate, multicodec.TransportBitswap, "nope"),
+		events.Failed(now, id, candidate, "all failed"),
+	} {
+		for _, subscriber := range subscribers {
+			subscriber(event)
+		}
+	}
+
+	require.Len(t, started, 1)
+	require.Equal(t, multicodec.TransportBitswap, started[0].Protocol())
+	require.Equal(t, []string{"nope", "all failed"}, errorMessages)
+}
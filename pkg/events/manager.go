@@ -4,8 +4,10 @@ import (
 	"container/list"
 	"context"
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
 )
 
 type indexedSubscriber struct {
@@ -53,6 +55,10 @@ func (em *EventManager) Start() {
 		for {
 			select {
 			case event := <-toProcess:
+				if flush, ok := event.(flushEvent); ok {
+					close(flush.done)
+					continue
+				}
 				em.lk.RLock()
 				// make a copy of the subscribers slice so that we don't hold the lock
 				subscribers := append([]indexedSubscriber{}, em.subscribers...)
@@ -106,6 +112,11 @@ func (em *EventManager) IsStarted() bool {
 	return em.started
 }
 
+// IsStopped returns true if the event loop has been stopped.
+func (em *EventManager) IsStopped() bool {
+	return em.ctx.Err() != nil
+}
+
 // Stop stops the event loop. A channel is returned that will receive a single
 // value when the event loop has stopped.
 func (em *EventManager) Stop() chan struct{} {
@@ -113,6 +124,41 @@ func (em *EventManager) Stop() chan struct{} {
 	return em.stopped
 }
 
+// Flush waits until the events dispatched before it was called have been
+// delivered to the subscribers, so that they may be recorded before shutting
+// down, returning an error if the context is done or the event loop stopped
+// first.
+func (em *EventManager) Flush(ctx context.Context) error {
+	flush := flushEvent{done: make(chan struct{})}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-em.ctx.Done():
+		return em.ctx.Err()
+	case em.events <- flush:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-em.ctx.Done():
+		return em.ctx.Err()
+	case <-flush.done:
+		return nil
+	}
+}
+
+// flushEvent marks the position of a Flush call in the queue of events, it
+// isn't delivered to subscribers.
+type flushEvent struct {
+	done chan struct{}
+}
+
+func (flushEvent) String() string                 { return "flush" }
+func (flushEvent) Time() time.Time                { return time.Time{} }
+func (flushEvent) RetrievalId() types.RetrievalID { return types.RetrievalID{} }
+func (flushEvent) Code() types.EventCode          { return "" }
+func (flushEvent) RootCid() cid.Cid               { return cid.Undef }
+
 // RegisterSubscriber registers a subscriber to receive events. The returned
 // function can be called to unregister the subscriber.
 func (em *EventManager) RegisterSubscriber(subscriber types.RetrievalEventSubscriber) func() {
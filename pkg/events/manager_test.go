@@ -2,6 +2,7 @@ package events_test
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -150,3 +151,37 @@ func TestEventManager(t *testing.T) {
 	verifyEvent(gotEvents1, types.FailedRetrievalCode, verifyRetrievalFailure)
 	verifyEvent(gotEvents2, types.FailedRetrievalCode, verifyRetrievalFailure)
 }
+
+func TestEventManagerFlush(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	em := events.NewEventManager(context.Background())
+	em.Start()
+	id := types.RetrievalID(uuid.New())
+	cid := cid.MustParse("bafkqaalb")
+
+	var delivered atomic.Int32
+	em.RegisterSubscriber(func(event types.RetrievalEvent) {
+		time.Sleep(10 * time.Millisecond)
+		delivered.Add(1)
+	})
+	for i := 0; i < 5; i++ {
+		em.DispatchEvent(events.StartedFindingCandidates(time.Now(), id, cid))
+	}
+
+	// the events dispatched before flushing have all been delivered once it
+	// returns, and flushing itself isn't delivered
+	require.NoError(t, em.Flush(ctx))
+	require.Equal(t, int32(5), delivered.Load())
+	require.NoError(t, em.Flush(ctx))
+	require.Equal(t, int32(5), delivered.Load())
+
+	// flushing gives up with the context
+	em.DispatchEvent(events.StartedFindingCandidates(time.Now(), id, cid))
+	expiredCtx, expire := context.WithCancel(ctx)
+	expire()
+	require.ErrorIs(t, em.Flush(expiredCtx), context.Canceled)
+
+	<-em.Stop()
+	require.Error(t, em.Flush(ctx))
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+)
+
+var _ types.RetrievalEvent = NameResolvedEvent{}
+
+// NameResolvedEvent is emitted for each hop of the resolution of an IPNS name
+// or DNSLink domain, which may point to a further name before it reaches a
+// CID. Name resolution precedes any retrieval, so it has an empty retrieval ID,
+// and its root CID is only defined for the hop that resolves to an /ipfs/ path.
+type NameResolvedEvent struct {
+	retrievalEvent
+	name  string
+	value string
+	ttl   time.Duration
+	depth int
+}
+
+func (e NameResolvedEvent) Code() types.EventCode { return types.NameResolvedCode }
+
+// Name is the IPNS name or DNSLink domain that was resolved.
+func (e NameResolvedEvent) Name() string { return e.name }
+
+// Value is the /ipfs/ or /ipns/ path that the name resolved to.
+func (e NameResolvedEvent) Value() string { return e.value }
+
+// TTL is how long the resolution may be cached for, according to the IPNS
+// record, or zero if it isn't known.
+func (e NameResolvedEvent) TTL() time.Duration { return e.ttl }
+
+// Depth is the position of the hop in the chain, starting at 1.
+func (e NameResolvedEvent) Depth() int { return e.depth }
+
+func (e NameResolvedEvent) String() string {
+	return fmt.Sprintf("NameResolvedEvent<%s, %s, %s, %s, %d>", e.eventTime, e.name, e.value, e.ttl, e.depth)
+}
+
+func NameResolved(at time.Time, name string, value string, root cid.Cid, ttl time.Duration, depth int) NameResolvedEvent {
+	return NameResolvedEvent{retrievalEvent{at, types.RetrievalID{}, root}, name, value, ttl, depth}
+}
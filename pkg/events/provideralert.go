@@ -0,0 +1,51 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/multiformats/go-multicodec"
+)
+
+var (
+	_ types.RetrievalEvent  = ProviderAlertEvent{}
+	_ EventWithProviderID   = ProviderAlertEvent{}
+	_ EventWithProtocol     = ProviderAlertEvent{}
+	_ EventWithErrorMessage = ProviderAlertEvent{}
+)
+
+// ProviderAlertReason is why a ProviderAlertEvent was emitted.
+type ProviderAlertReason string
+
+const (
+	// ProviderAlertInvalidData is the reason when a provider served data that
+	// failed verification.
+	ProviderAlertInvalidData ProviderAlertReason = "invalid-data"
+	// ProviderAlertPersistentFailure is the reason when a provider failed
+	// enough times in a row, see session.Config#AllowListAlertThreshold.
+	ProviderAlertPersistentFailure ProviderAlertReason = "persistent-failure"
+)
+
+// ProviderAlertEvent is emitted when an allowlisted provider, one that is
+// expected to serve the content it is retrieved for, serves invalid data or
+// fails persistently. Unlike other failures, which only deprioritise the
+// provider, these need the attention of an operator.
+type ProviderAlertEvent struct {
+	providerRetrievalEvent
+	protocol     multicodec.Code
+	reason       ProviderAlertReason
+	errorMessage string
+}
+
+func (e ProviderAlertEvent) Code() types.EventCode       { return types.ProviderAlertCode }
+func (e ProviderAlertEvent) Protocol() multicodec.Code   { return e.protocol }
+func (e ProviderAlertEvent) Reason() ProviderAlertReason { return e.reason }
+func (e ProviderAlertEvent) ErrorMessage() string        { return e.errorMessage }
+func (e ProviderAlertEvent) String() string {
+	return fmt.Sprintf("ProviderAlertEvent<%s, %s, %s, %s, %s, %s, %s>", e.eventTime, e.retrievalId, e.rootCid, e.providerId, e.protocol, e.reason, e.errorMessage)
+}
+
+func ProviderAlert(at time.Time, retrievalId types.RetrievalID, candidate types.RetrievalCandidate, protocol multicodec.Code, reason ProviderAlertReason, errorMessage string) ProviderAlertEvent {
+	return ProviderAlertEvent{providerRetrievalEvent{retrievalEvent{at, retrievalId, candidate.RootCid}, candidate.MinerPeer.ID}, protocol, reason, errorMessage}
+}
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+)
+
+var _ types.RetrievalEvent = SwarmTelemetryEvent{}
+
+// SwarmTelemetryEvent is a periodic snapshot of the state of the libp2p swarm
+// used for Bitswap and Graphsync retrievals. It isn't associated with any one
+// retrieval, so it has an empty retrieval ID and an undefined root CID.
+type SwarmTelemetryEvent struct {
+	retrievalEvent
+	connectedPeers        int
+	activeBitswapSessions int
+	openGraphsyncChannels int
+	dialsInProgress       int
+}
+
+func (e SwarmTelemetryEvent) Code() types.EventCode      { return types.SwarmTelemetryCode }
+func (e SwarmTelemetryEvent) ConnectedPeers() int        { return e.connectedPeers }
+func (e SwarmTelemetryEvent) ActiveBitswapSessions() int { return e.activeBitswapSessions }
+func (e SwarmTelemetryEvent) OpenGraphsyncChannels() int { return e.openGraphsyncChannels }
+func (e SwarmTelemetryEvent) DialsInProgress() int       { return e.dialsInProgress }
+func (e SwarmTelemetryEvent) String() string {
+	return fmt.Sprintf("SwarmTelemetryEvent<%s, %d, %d, %d, %d>", e.eventTime, e.connectedPeers, e.activeBitswapSessions, e.openGraphsyncChannels, e.dialsInProgress)
+}
+
+func SwarmTelemetry(at time.Time, connectedPeers int, activeBitswapSessions int, openGraphsyncChannels int, dialsInProgress int) SwarmTelemetryEvent {
+	return SwarmTelemetryEvent{retrievalEvent{at, types.RetrievalID{}, cid.Undef}, connectedPeers, activeBitswapSessions, openGraphsyncChannels, dialsInProgress}
+}
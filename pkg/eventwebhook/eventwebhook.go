@@ -0,0 +1,304 @@
+package eventwebhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/build"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+var logger = logging.Subsystem("lassie/eventwebhook")
+
+const httpTimeout = 5 * time.Second // The timeout for each POST to the webhook
+
+// DefaultCodes are the codes of the events published when Config.Codes is
+// empty, covering the start of a retrieval, the candidates found for it, the
+// first byte received, how it ended and any provider alerts.
+var DefaultCodes = []types.EventCode{
+	types.StartedFetchCode,
+	types.CandidatesFoundCode,
+	types.FirstByteCode,
+	types.SuccessCode,
+	types.FailedRetrievalCode,
+	types.ProviderAlertCode,
+	types.FailedCode,
+	types.FinishedCode,
+}
+
+// Config configures a Publisher, only the URL is required.
+type Config struct {
+	// URL is the webhook endpoint that batches of events are POSTed to.
+	URL string
+	// Header is added to each request, such as an Authorization header.
+	Header http.Header
+	// Codes are the codes of the events to publish, defaulting to
+	// DefaultCodes.
+	Codes []types.EventCode
+	// MaxBatchSize is the number of events at which a batch is posted without
+	// waiting for BatchInterval to elapse. Defaults to 100.
+	MaxBatchSize int
+	// BatchInterval is how long events are collected for before being posted
+	// as a batch. Defaults to 1 second.
+	BatchInterval time.Duration
+	// MaxAttempts is the number of times a batch is posted before it is
+	// dropped. Only network errors and 429 or 5xx responses are retried.
+	// Defaults to 5.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry of a batch, doubling for
+	// each following retry up to MaxBackoff. Defaults to 1 and 30 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// QueueSize is the number of events waiting to be posted beyond which new
+	// events are dropped, so that a slow or unavailable webhook never blocks
+	// retrievals. Defaults to 1000.
+	QueueSize int
+}
+
+func (cfg Config) withDefaults() Config {
+	if len(cfg.Codes) == 0 {
+		cfg.Codes = DefaultCodes
+	}
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = 100
+	}
+	if cfg.BatchInterval <= 0 {
+		cfg.BatchInterval = time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	return cfg
+}
+
+// Event is the JSON form of a retrieval event posted to the webhook. Fields
+// that don't apply to an event's code are omitted.
+type Event struct {
+	Code           types.EventCode `json:"code"`
+	Time           time.Time       `json:"time"`
+	RetrievalID    string          `json:"retrievalId"`
+	RootCid        string          `json:"rootCid"`
+	URLPath        string          `json:"urlPath,omitempty"`        // The path after the root CID, including scope, for started-fetch
+	ProviderID     string          `json:"providerId,omitempty"`     // The provider, or "Bitswap", the event relates to
+	Protocol       string          `json:"protocol,omitempty"`       // The protocol the event relates to
+	Protocols      []string        `json:"protocols,omitempty"`      // The protocols allowed, for started-fetch
+	Candidates     int             `json:"candidates,omitempty"`     // The number of candidates, for candidates-found and candidates-filtered
+	Duration       string          `json:"duration,omitempty"`       // The time to first byte, of the whole retrieval for success, or the cooldown for circuit-breaker-opened
+	BytesReceived  uint64          `json:"bytesReceived,omitempty"`  // The bytes received, for success
+	BlocksReceived uint64          `json:"blocksReceived,omitempty"` // The blocks received, for success
+	Error          string          `json:"error,omitempty"`          // The error message, for failures
+	Reason         string          `json:"reason,omitempty"`         // The reason a candidate was skipped, for candidate-skipped, or alerted, for provider-alert
+}
+
+// Batch is the JSON body of each POST to the webhook.
+type Batch struct {
+	Events []Event `json:"events"`
+}
+
+func newEvent(event types.RetrievalEvent) Event {
+	evt := Event{
+		Code:        event.Code(),
+		Time:        event.Time(),
+		RetrievalID: event.RetrievalId().String(),
+		RootCid:     event.RootCid().String(),
+		ProviderID:  events.Identifier(event),
+	}
+	if e, ok := event.(events.EventWithProtocol); ok {
+		evt.Protocol = e.Protocol().String()
+	}
+	if e, ok := event.(events.EventWithProtocols); ok {
+		for _, protocol := range e.Protocols() {
+			evt.Protocols = append(evt.Protocols, protocol.String())
+		}
+	}
+	if e, ok := event.(events.EventWithCandidates); ok {
+		evt.Candidates = len(e.Candidates())
+	}
+	if e, ok := event.(events.EventWithErrorMessage); ok {
+		evt.Error = e.ErrorMessage()
+	}
+	switch e := event.(type) {
+	case events.StartedFetchEvent:
+		evt.URLPath = e.UrlPath()
+	case events.FirstByteEvent:
+		evt.Duration = e.Duration().String()
+	case events.CandidateSkippedEvent:
+		evt.Reason = e.Reason()
+	case events.CircuitBreakerOpenedEvent:
+		evt.Duration = e.Cooldown().String()
+	case events.ProviderAlertEvent:
+		evt.Reason = string(e.Reason())
+	case events.SucceededEvent:
+		evt.Duration = e.Duration().String()
+		evt.BytesReceived = e.ReceivedBytesSize()
+		evt.BlocksReceived = e.ReceivedCidsCount()
+	}
+	return evt
+}
+
+// Publisher POSTs batches of retrieval events as JSON to a webhook, retrying
+// failed posts with exponential backoff. It generalises the
+// aggregateeventrecorder, which posts a single summary of each retrieval to
+// the event recorder service, to the raw events and arbitrary endpoints.
+//
+// Batches are posted one at a time, in the order of their events; while a
+// batch is being retried new events are queued, and dropped once the queue
+// is full.
+type Publisher struct {
+	ctx     context.Context
+	cfg     Config
+	client  *http.Client
+	queue   chan Event
+	batches chan []Event
+	dropped atomic.Uint64
+}
+
+// NewPublisher creates a new Publisher, posting events until the context is
+// cancelled.
+func NewPublisher(ctx context.Context, cfg Config) *Publisher {
+	cfg = cfg.withDefaults()
+	publisher := &Publisher{
+		ctx:     ctx,
+		cfg:     cfg,
+		client:  &http.Client{Timeout: httpTimeout},
+		queue:   make(chan Event, cfg.QueueSize),
+		batches: make(chan []Event),
+	}
+	go publisher.batchEvents()
+	go publisher.postBatches()
+	return publisher
+}
+
+// RetrievalEventSubscriber returns a RetrievalEventSubscriber that queues the
+// events with the configured codes to be published.
+func (p *Publisher) RetrievalEventSubscriber() types.RetrievalEventSubscriber {
+	return events.FilteredSubscriber(events.Filter{Codes: p.cfg.Codes}, func(event types.RetrievalEvent) {
+		select {
+		case p.queue <- newEvent(event):
+		default:
+			p.dropped.Add(1)
+		}
+	})
+}
+
+// batchEvents collects queued events into batches, handing each to
+// postBatches once it's full or BatchInterval has elapsed.
+func (p *Publisher) batchEvents() {
+	ticker := time.NewTicker(p.cfg.BatchInterval)
+	defer ticker.Stop()
+
+	var batch []Event
+	var batches chan []Event // nil, disabling the send, while batch isn't ready
+	queue := p.queue         // nil, leaving events queued, while batch is full
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case event := <-queue:
+			batch = append(batch, event)
+			if len(batch) >= p.cfg.MaxBatchSize {
+				batches = p.batches
+				queue = nil
+			}
+		case <-ticker.C:
+			if dropped := p.dropped.Swap(0); dropped > 0 {
+				logger.Warnw("Dropped retrieval events, the webhook queue is full", "url", p.cfg.URL, "dropped", dropped)
+			}
+			if len(batch) > 0 {
+				batches = p.batches
+			}
+		case batches <- batch:
+			batch = nil
+			batches = nil
+			queue = p.queue
+		}
+	}
+}
+
+// postBatches posts each batch to the webhook, retrying with backoff until it
+// succeeds or MaxAttempts is reached.
+func (p *Publisher) postBatches() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case batch := <-p.batches:
+			byts, err := json.Marshal(Batch{batch})
+			if err != nil {
+				logger.Errorw("Failed to encode retrieval events", "err", err)
+				continue
+			}
+			backoff := p.cfg.MinBackoff
+			for attempt := 1; ; attempt++ {
+				retry, err := p.post(byts)
+				if err == nil {
+					break
+				}
+				if p.ctx.Err() != nil {
+					return
+				}
+				if !retry || attempt >= p.cfg.MaxAttempts {
+					logger.Errorw("Failed to post retrieval events to webhook, dropping them", "url", p.cfg.URL, "events", len(batch), "attempts", attempt, "err", err)
+					break
+				}
+				logger.Debugw("Failed to post retrieval events to webhook, retrying", "url", p.cfg.URL, "attempt", attempt, "backoff", backoff, "err", err)
+				select {
+				case <-p.ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > p.cfg.MaxBackoff {
+					backoff = p.cfg.MaxBackoff
+				}
+			}
+		}
+	}
+}
+
+// post makes a single POST of an encoded batch, returning whether a failure
+// may be retried.
+func (p *Publisher) post(byts []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(byts))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range p.cfg.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", build.UserAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected response status: %s", resp.Status)
+}
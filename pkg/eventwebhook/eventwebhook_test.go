@@ -0,0 +1,188 @@
+package eventwebhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/eventwebhook"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisher(t *testing.T) {
+	root := testutil.GenerateCid()
+	candidates := testutil.GenerateRetrievalCandidatesForCID(t, 2, root, &metadata.IpfsGatewayHttp{})
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	now := time.Now()
+	retrieval := []types.RetrievalEvent{
+		events.StartedFetch(now, id, root, "/birb?dag-scope=all", multicodec.TransportIpfsGatewayHttp),
+		events.StartedFindingCandidates(now, id, root),
+		events.CandidatesFound(now, id, root, candidates),
+		events.StartedRetrieval(now, id, candidates[0], multicodec.TransportIpfsGatewayHttp),
+		events.FirstByte(now, id, candidates[0], 20*time.Millisecond, multicodec.TransportIpfsGatewayHttp),
+		events.Success(now, id, candidates[0], 1000, 3, time.Second, multicodec.TransportIpfsGatewayHttp),
+		events.Finished(now, id, candidates[0]),
+	}
+	providerID := candidates[0].MinerPeer.ID.String()
+	expected := []eventwebhook.Event{
+		{Code: types.StartedFetchCode, URLPath: "/birb?dag-scope=all", Protocols: []string{"transport-ipfs-gateway-http"}},
+		{Code: types.CandidatesFoundCode, Candidates: 2},
+		{Code: types.FirstByteCode, ProviderID: providerID, Protocol: "transport-ipfs-gateway-http", Duration: "20ms"},
+		{Code: types.SuccessCode, ProviderID: providerID, Protocol: "transport-ipfs-gateway-http", Duration: "1s", BytesReceived: 1000, BlocksReceived: 3},
+		{Code: types.FinishedCode, ProviderID: providerID},
+	}
+	for i := range expected {
+		expected[i].Time = now
+		expected[i].RetrievalID = id.String()
+		expected[i].RootCid = root.String()
+	}
+
+	testCases := []struct {
+		name string
+		// statuses are responded with in turn, then 200
+		statuses    []int
+		maxAttempts int
+		posts       int
+		delivered   bool
+	}{
+		{
+			name:      "delivered",
+			posts:     1,
+			delivered: true,
+		},
+		{
+			name:        "retried until delivered",
+			statuses:    []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			maxAttempts: 3,
+			posts:       3,
+			delivered:   true,
+		},
+		{
+			name:        "dropped after max attempts",
+			statuses:    []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			maxAttempts: 2,
+			posts:       2,
+		},
+		{
+			name:        "not retried on client error",
+			statuses:    []int{http.StatusBadRequest},
+			maxAttempts: 3,
+			posts:       1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var posts atomic.Int32
+			delivered := make(chan eventwebhook.Batch, 1)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				post := int(posts.Add(1))
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				require.Equal(t, "Bearer applesauce", r.Header.Get("Authorization"))
+				if post <= len(testCase.statuses) {
+					w.WriteHeader(testCase.statuses[post-1])
+					return
+				}
+				var batch eventwebhook.Batch
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+				delivered <- batch
+			}))
+			defer ts.Close()
+
+			publisher := eventwebhook.NewPublisher(ctx, eventwebhook.Config{
+				URL:           ts.URL,
+				Header:        http.Header{"Authorization": []string{"Bearer applesauce"}},
+				MaxBatchSize:  len(expected),
+				BatchInterval: time.Hour,
+				MaxAttempts:   testCase.maxAttempts,
+				MinBackoff:    time.Millisecond,
+			})
+			subscriber := publisher.RetrievalEventSubscriber()
+			for _, event := range retrieval {
+				subscriber(event)
+			}
+
+			if testCase.delivered {
+				select {
+				case <-ctx.Done():
+					require.FailNow(t, "batch not delivered")
+				case batch := <-delivered:
+					for i := range batch.Events {
+						// compare times without their monotonic clock reading
+						require.True(t, expected[i].Time.Equal(batch.Events[i].Time))
+						batch.Events[i].Time = expected[i].Time
+					}
+					require.Equal(t, expected, batch.Events)
+				}
+			} else {
+				require.Eventually(t, func() bool { return int(posts.Load()) == testCase.posts }, time.Second, time.Millisecond)
+				time.Sleep(50 * time.Millisecond)
+			}
+			require.Equal(t, testCase.posts, int(posts.Load()))
+		})
+	}
+}
+
+func TestPublisherBatches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	batches := make(chan eventwebhook.Batch, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch eventwebhook.Batch
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		batches <- batch
+	}))
+	defer ts.Close()
+
+	// full batches are posted straight away, and the remainder once the
+	// interval has elapsed
+	publisher := eventwebhook.NewPublisher(ctx, eventwebhook.Config{
+		URL:           ts.URL,
+		Codes:         []types.EventCode{types.StartedFindingCandidatesCode},
+		MaxBatchSize:  3,
+		BatchInterval: 100 * time.Millisecond,
+	})
+	subscriber := publisher.RetrievalEventSubscriber()
+	root := testutil.GenerateCid()
+	var ids []string
+	for i := 0; i < 7; i++ {
+		id, err := types.NewRetrievalID()
+		require.NoError(t, err)
+		ids = append(ids, id.String())
+		subscriber(events.StartedFindingCandidates(time.Now(), id, root))
+		subscriber(events.Finished(time.Now(), id, types.RetrievalCandidate{RootCid: root}))
+	}
+
+	var received []string
+	var sizes []int
+	for len(received) < len(ids) {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "batches not delivered")
+		case batch := <-batches:
+			sizes = append(sizes, len(batch.Events))
+			for _, event := range batch.Events {
+				require.Equal(t, types.StartedFindingCandidatesCode, event.Code)
+				received = append(received, event.RetrievalID)
+			}
+		}
+	}
+	require.Equal(t, []int{3, 3, 1}, sizes)
+	require.Equal(t, ids, received)
+}
@@ -0,0 +1,270 @@
+// Package globpath expands UnixFS paths containing glob patterns, such as
+// /logs/2024-*/errors.json, into an explicit selector that fetches only the
+// matching entries.
+package globpath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+var logger = logging.Subsystem("lassie/globpath")
+
+var (
+	ErrNoMatches          = errors.New("no entries match the glob path")
+	ErrGlobWithByteRange  = errors.New("glob paths can't be used with a byte range")
+	ErrGlobWithSelector   = errors.New("glob paths can't be used with an explicit selector")
+	ErrGlobWithDuplicates = errors.New("glob paths can't be used with duplicates")
+)
+
+var protoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
+
+// IsPattern returns true if any segment of the path contains glob pattern
+// characters as understood by path.Match.
+func IsPattern(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+// Expand resolves the glob patterns in the Path of the request and returns a
+// new request with an explicit Selector that fetches the entries matching the
+// full path, each according to the Scope of the original request.
+//
+// Expansion is performed segment by segment, for each segment containing a
+// pattern the directory it applies to is fetched with DagScopeEntity so its
+// entries can be matched. These directory listings are fetched into temporary
+// memory and don't touch the request's LinkSystem; only the final retrieval
+// writes to it.
+//
+// The returned request's Path is set to the longest literal prefix of the
+// glob path with DagScopeAll so that HTTP retrievals, which can't transmit a
+// selector, fetch the subgraph containing all matches and prune it locally.
+func Expand(ctx context.Context, fetcher types.Fetcher, request types.RetrievalRequest, opts ...types.FetchOption) (types.RetrievalRequest, error) {
+	if request.Selector != nil {
+		return types.RetrievalRequest{}, ErrGlobWithSelector
+	}
+	if request.HasByteRange() {
+		return types.RetrievalRequest{}, ErrGlobWithByteRange
+	}
+	if request.Duplicates {
+		return types.RetrievalRequest{}, ErrGlobWithDuplicates
+	}
+
+	segments := datamodel.ParsePath(request.Path).Segments()
+	literal := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		s := seg.String()
+		if _, err := path.Match(s, ""); err != nil {
+			return types.RetrievalRequest{}, fmt.Errorf("invalid glob segment %q: %w", s, err)
+		}
+		if IsPattern(s) {
+			break
+		}
+		literal = append(literal, s)
+	}
+
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	e := &expander{ctx: ctx, fetcher: fetcher, request: request, lsys: lsys, opts: opts}
+	matches, err := e.expand(segments, 0)
+	if err != nil {
+		return types.RetrievalRequest{}, err
+	}
+	if len(matches) == 0 {
+		return types.RetrievalRequest{}, fmt.Errorf("%w: %s", ErrNoMatches, request.Path)
+	}
+
+	tree := &pathTree{}
+	for _, m := range matches {
+		tree.add(m)
+	}
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	request.Selector = tree.selectorSpec(ssb, request.Scope.TerminalSelectorSpec()).Node()
+	request.Path = strings.Join(literal, "/")
+	request.Scope = trustlessutils.DagScopeAll
+	return request, nil
+}
+
+type expander struct {
+	ctx     context.Context
+	fetcher types.Fetcher
+	request types.RetrievalRequest
+	lsys    linking.LinkSystem
+	opts    []types.FetchOption
+}
+
+// expand returns the concrete paths that match the glob path segments, where
+// the segments before index `from` have already been resolved to names.
+func (e *expander) expand(segments []datamodel.PathSegment, from int) ([][]string, error) {
+	next := from
+	for next < len(segments) && !IsPattern(segments[next].String()) {
+		next++
+	}
+	if next == len(segments) {
+		return [][]string{segmentStrings(segments)}, nil
+	}
+
+	dir := segmentStrings(segments[:next])
+	names, err := e.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	pattern := segments[next].String()
+	var matches [][]string
+	for _, name := range names {
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		expanded := append(append(append([]datamodel.PathSegment{}, segments[:next]...), datamodel.PathSegmentOfString(name)), segments[next+1:]...)
+		m, err := e.expand(expanded, next+1)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m...)
+	}
+	return matches, nil
+}
+
+// list fetches the directory at the given path and returns the names of its
+// entries. If the path is not a directory, no names are returned.
+func (e *expander) list(dir []string) ([]string, error) {
+	retrievalId, err := types.NewRetrievalID()
+	if err != nil {
+		return nil, err
+	}
+	listRequest := types.RetrievalRequest{
+		Request: trustlessutils.Request{
+			Root:  e.request.Root,
+			Path:  strings.Join(dir, "/"),
+			Scope: trustlessutils.DagScopeEntity,
+		},
+		RetrievalID: retrievalId,
+		LinkSystem:  e.lsys,
+		Protocols:   e.request.Protocols,
+		FixedPeers:  e.request.FixedPeers,
+		MaxBlocks:   e.request.MaxBlocks,
+	}
+	logger.Debugw("fetching directory for glob expansion", "root", e.request.Root, "path", listRequest.Path, logging.RetrievalIDKey, retrievalId)
+	if _, err := e.fetcher.Fetch(e.ctx, listRequest, e.opts...); err != nil {
+		return nil, fmt.Errorf("failed to fetch /%s for glob expansion: %w", listRequest.Path, err)
+	}
+
+	node, err := e.load(e.request.Root)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range dir {
+		child, err := node.LookupByString(name)
+		if err != nil {
+			return nil, nil // not found, no matches below here
+		}
+		lnk, err := child.AsLink()
+		if err != nil {
+			return nil, nil
+		}
+		if node, err = e.load(lnk.(cidlink.Link).Cid); err != nil {
+			return nil, err
+		}
+	}
+	if node.Kind() != datamodel.Kind_Map {
+		return nil, nil
+	}
+
+	names := make([]string, 0, node.Length())
+	itr := node.MapIterator()
+	for !itr.Done() {
+		k, _, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		name, err := k.AsString()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// load loads and reifies the UnixFS node for the given CID.
+func (e *expander) load(c cid.Cid) (datamodel.Node, error) {
+	lnk := cidlink.Link{Cid: c}
+	lctx := linking.LinkContext{Ctx: e.ctx}
+	proto, err := protoChooser(lnk, lctx)
+	if err != nil {
+		return nil, err
+	}
+	node, err := e.lsys.Load(lctx, lnk, proto)
+	if err != nil {
+		return nil, err
+	}
+	return unixfsnode.Reify(lctx, node, &e.lsys)
+}
+
+func segmentStrings(segments []datamodel.PathSegment) []string {
+	s := make([]string, len(segments))
+	for i, seg := range segments {
+		s[i] = seg.String()
+	}
+	return s
+}
+
+// pathTree is a tree of path segments used to build a single selector that
+// covers multiple paths.
+type pathTree struct {
+	leaf     bool
+	children map[string]*pathTree
+}
+
+func (t *pathTree) add(p []string) {
+	if len(p) == 0 {
+		t.leaf = true
+		return
+	}
+	if t.children == nil {
+		t.children = make(map[string]*pathTree)
+	}
+	child, ok := t.children[p[0]]
+	if !ok {
+		child = &pathTree{}
+		t.children[p[0]] = child
+	}
+	child.add(p[1:])
+}
+
+func (t *pathTree) selectorSpec(ssb builder.SelectorSpecBuilder, terminal builder.SelectorSpec) builder.SelectorSpec {
+	if t.leaf {
+		return terminal
+	}
+	names := make([]string, 0, len(t.children))
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return ssb.ExploreInterpretAs("unixfs", ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		for _, name := range names {
+			efsb.Insert(name, t.children[name].selectorSpec(ssb, terminal))
+		}
+	}))
+}
@@ -0,0 +1,207 @@
+package globpath_test
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"path"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/globpath"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPattern(t *testing.T) {
+	require.False(t, globpath.IsPattern(""))
+	require.False(t, globpath.IsPattern("logs/2024-01/errors.json"))
+	require.True(t, globpath.IsPattern("logs/2024-*/errors.json"))
+	require.True(t, globpath.IsPattern("logs/2024-0?/errors.json"))
+	require.True(t, globpath.IsPattern("logs/2024-0[12]/errors.json"))
+}
+
+// storeFetcher is a Fetcher that copies every block from a source store into
+// the request's LinkSystem, regardless of the request's path or scope.
+type storeFetcher struct {
+	src     *memstore.Store
+	fetched []string
+}
+
+func (sf *storeFetcher) Fetch(ctx context.Context, request types.RetrievalRequest, opts ...types.FetchOption) (*types.RetrievalStats, error) {
+	sf.fetched = append(sf.fetched, request.Path)
+	for k, v := range sf.src.Bag {
+		w, commit, err := request.LinkSystem.StorageWriteOpener(linking.LinkContext{Ctx: ctx})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(v); err != nil {
+			return nil, err
+		}
+		c, err := cid.Cast([]byte(k))
+		if err != nil {
+			return nil, err
+		}
+		if err := commit(cidlink.Link{Cid: c}); err != nil {
+			return nil, err
+		}
+	}
+	return &types.RetrievalStats{}, nil
+}
+
+func TestExpand(t *testing.T) {
+	ctx := context.Background()
+	rndReader := rand.New(rand.NewSource(3333))
+
+	src := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(src)
+	lsys.SetWriteStorage(src)
+
+	file := func(path string) unixfs.DirEntry {
+		ent := unixfs.GenerateFile(t, &lsys, rndReader, 1024)
+		ent.Path = path
+		return ent
+	}
+	dir := func(path string, children ...unixfs.DirEntry) unixfs.DirEntry {
+		ent := unixfs.BuildDirectory(t, &lsys, children, false)
+		ent.Path = path
+		return ent
+	}
+
+	jan := file("logs/2024-01/errors.json")
+	feb := file("logs/2024-02/errors.json")
+	dec := file("logs/2023-12/errors.json")
+	logsDir := dir("logs",
+		dir("logs/2023-12", dec, file("logs/2023-12/access.log")),
+		dir("logs/2024-01", jan, file("logs/2024-01/access.log")),
+		dir("logs/2024-02", feb, file("logs/2024-02/access.log")),
+	)
+	root := dir("", logsDir, file("README"))
+
+	testCases := []struct {
+		name        string
+		path        string
+		scope       trustlessutils.DagScope
+		byteRange   *trustlessutils.ByteRange
+		duplicates  bool
+		expectErr   error
+		expectPath  string
+		expectFetch []string
+		expectCids  []cid.Cid
+	}{
+		{
+			name:        "wildcard directory",
+			path:        "logs/2024-*/errors.json",
+			scope:       trustlessutils.DagScopeAll,
+			expectPath:  "logs",
+			expectFetch: []string{"logs"},
+			expectCids: []cid.Cid{
+				root.Root,
+				logsDir.Root,
+				logsDir.Children[1].Root,
+				jan.Root,
+				logsDir.Children[2].Root,
+				feb.Root,
+			},
+		},
+		{
+			name:        "wildcard directory and file",
+			path:        "logs/2023-?/*.json",
+			scope:       trustlessutils.DagScopeAll,
+			expectErr:   globpath.ErrNoMatches,
+			expectFetch: []string{"logs"},
+		},
+		{
+			name:        "character class",
+			path:        "logs/202[3]-*/*.json",
+			scope:       trustlessutils.DagScopeAll,
+			expectPath:  "logs",
+			expectFetch: []string{"logs", "logs/2023-12"},
+			expectCids: []cid.Cid{
+				root.Root,
+				logsDir.Root,
+				logsDir.Children[0].Root,
+				dec.Root,
+			},
+		},
+		{
+			name:        "entity scope",
+			path:        "logs/*",
+			scope:       trustlessutils.DagScopeEntity,
+			expectPath:  "logs",
+			expectFetch: []string{"logs"},
+			expectCids: []cid.Cid{
+				root.Root,
+				logsDir.Root,
+				logsDir.Children[0].Root,
+				logsDir.Children[1].Root,
+				logsDir.Children[2].Root,
+			},
+		},
+		{
+			name:      "invalid pattern",
+			path:      "logs/[",
+			scope:     trustlessutils.DagScopeAll,
+			expectErr: path.ErrBadPattern,
+		},
+		{
+			name:       "duplicates",
+			path:       "logs/*",
+			scope:      trustlessutils.DagScopeAll,
+			duplicates: true,
+			expectErr:  globpath.ErrGlobWithDuplicates,
+		},
+		{
+			name:      "byte range",
+			path:      "logs/*",
+			scope:     trustlessutils.DagScopeEntity,
+			byteRange: &trustlessutils.ByteRange{From: 10},
+			expectErr: globpath.ErrGlobWithByteRange,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := types.NewRequestForPath(&memstore.Store{}, root.Root, tc.path, tc.scope, tc.byteRange)
+			require.NoError(t, err)
+			request.Duplicates = tc.duplicates
+
+			fetcher := &storeFetcher{src: src}
+			expanded, err := globpath.Expand(ctx, fetcher, request)
+			require.Equal(t, tc.expectFetch, fetcher.fetched)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectPath, expanded.Path)
+			require.Equal(t, trustlessutils.DagScopeAll, expanded.Scope)
+			require.NotNil(t, expanded.Selector)
+
+			// traverse the source with the expanded selector to check that only
+			// the matching entries are visited
+			var loaded []cid.Cid
+			tlsys := cidlink.DefaultLinkSystem()
+			tlsys.SetReadStorage(src)
+			unixfsnode.AddUnixFSReificationToLinkSystem(&tlsys)
+			sro := tlsys.StorageReadOpener
+			tlsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+				loaded = append(loaded, lnk.(cidlink.Link).Cid)
+				return sro(lctx, lnk)
+			}
+			_, err = traversal.Config{Root: root.Root, Selector: expanded.Selector}.Traverse(ctx, tlsys, nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectCids, loaded)
+		})
+	}
+}
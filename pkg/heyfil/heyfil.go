@@ -7,14 +7,14 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/ipfs/go-log/v2"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"go.uber.org/multierr"
 )
 
 const DefaultHeyfilEndpoint = "https://heyfil.prod.cid.contact/"
 
-var logger = log.Logger("lassie/heyfil")
+var logger = logging.Subsystem("lassie/heyfil")
 
 func isPeerId(s string) bool {
 	_, err := peer.Decode(s)
@@ -156,7 +156,7 @@ func (h Heyfil) translateFaddr(s string) (string, error) {
 }
 
 func httpGet[T any](url string, result *T) error {
-	logger.Debugf("http get: %s", url)
+	logger.Debugw("http get", "url", url)
 	resp, err := http.Get(url)
 	if err != nil {
 		return fmt.Errorf("http get: %w", err)
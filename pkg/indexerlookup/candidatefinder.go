@@ -12,10 +12,10 @@ import (
 	"net/http"
 	"path"
 
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/retriever"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-log/v2"
 	"github.com/ipni/go-libipni/find/model"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/multiformats/go-multihash"
@@ -24,7 +24,7 @@ import (
 var (
 	_ retriever.CandidateFinder = (*IndexerCandidateFinder)(nil)
 
-	logger = log.Logger("lassie/indexerlookup")
+	logger = logging.Subsystem("lassie/indexerlookup")
 )
 
 type IndexerCandidateFinder struct {
@@ -138,6 +138,28 @@ func (idxf *IndexerCandidateFinder) FindCandidatesAsync(ctx context.Context, c c
 	}
 }
 
+// Ping checks that the indexer can be reached by querying its health
+// endpoint, returning an error if it can't be reached or reports itself as
+// unhealthy.
+func (idxf *IndexerCandidateFinder) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, idxf.httpEndpoint.String()+"/health", nil)
+	if err != nil {
+		return err
+	}
+	if idxf.httpUserAgent != "" {
+		req.Header.Set("User-Agent", idxf.httpUserAgent)
+	}
+	resp, err := idxf.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("indexer health check failed: %s", http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
 func (idxf *IndexerCandidateFinder) newFindHttpRequest(ctx context.Context, c cid.Cid) (*http.Request, error) {
 	endpoint := idxf.findByMultihashEndpoint(c.Hash())
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
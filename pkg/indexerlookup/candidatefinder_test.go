@@ -2,7 +2,10 @@ package indexerlookup_test
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -145,3 +148,31 @@ func TestCandidateFinder(t *testing.T) {
 		})
 	}
 }
+
+func TestPing(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/health" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !healthy.Load() {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	endpoint, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	finder, err := indexerlookup.NewCandidateFinder(indexerlookup.WithHttpEndpoint(endpoint))
+	require.NoError(t, err)
+
+	require.NoError(t, finder.Ping(context.Background()))
+	healthy.Store(false)
+	require.ErrorContains(t, finder.Ping(context.Background()), "Service Unavailable")
+	srv.Close()
+	require.Error(t, finder.Ping(context.Background()))
+}
@@ -0,0 +1,84 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinityKey(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(3)
+	req.NoError(mrn.MN.LinkAll())
+	// every provider has each part of the dataset
+	parts := make([]unixfs.DirEntry, 4)
+	for i := range parts {
+		for _, remote := range mrn.Remotes {
+			parts[i] = unixfs.GenerateFile(t, remote.LinkSystem, rand.New(rand.NewSource(int64(i))), 256<<10)
+		}
+	}
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	fetch := func(root cid.Cid, opts ...types.FetchOption) *types.RetrievalStats {
+		store := storage.NewDeferredStorageCar(t.TempDir(), root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+		stats, err := l.Fetch(ctx, request, opts...)
+		req.NoError(err)
+		return stats
+	}
+
+	// the first part is served by any provider, which the following parts
+	// then stick to
+	stats := fetch(parts[0].Root, types.WithAffinityKey("dataset"))
+	req.False(stats.AffinityHit)
+	affine := stats.StorageProviderId
+	for _, part := range parts[1:3] {
+		stats := fetch(part.Root, types.WithAffinityKey("dataset"))
+		req.True(stats.AffinityHit)
+		req.Equal(affine, stats.StorageProviderId)
+	}
+
+	// retrievals without the key aren't counted
+	req.False(fetch(parts[1].Root).AffinityHit)
+
+	// when the provider fails, the retrieval fails over to another
+	for _, remote := range mrn.Remotes {
+		if remote.ID == affine {
+			req.NoError(remote.Blockstore().DeleteBlock(ctx, parts[3].SelfCids[2]))
+		}
+	}
+	stats = fetch(parts[3].Root, types.WithAffinityKey("dataset"))
+	req.False(stats.AffinityHit)
+	req.NotEqual(affine, stats.StorageProviderId)
+	req.NotEqual(peer.ID(""), stats.StorageProviderId)
+
+	affinityStats := l.AffinityStats()
+	req.Equal(lassie.AffinityStats{Retrievals: 4, Hits: 2}, affinityStats)
+	req.Equal(0.5, affinityStats.HitRate())
+}
@@ -0,0 +1,148 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockCache(t *testing.T) {
+	testCases := []struct {
+		name     string
+		protocol multicodec.Code
+	}{
+		{name: "bitswap", protocol: multicodec.TransportBitswap},
+		{name: "graphsync", protocol: multicodec.TransportGraphsyncFilecoinv1},
+		{name: "http", protocol: multicodec.TransportIpfsGatewayHttp},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			var finishedChan chan []datatransfer.Event
+			switch testCase.protocol {
+			case multicodec.TransportBitswap:
+				mrn.AddBitswapPeers(1)
+			case multicodec.TransportGraphsyncFilecoinv1:
+				mrn.AddGraphsyncPeers(1)
+				finishedChan = mocknet.SetupRetrieval(t, mrn.Remotes[0])
+			case multicodec.TransportIpfsGatewayHttp:
+				mrn.AddHttpPeers(1)
+			}
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateDirectory(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 4<<20, false)
+
+			cache, err := blockcache.New(t.TempDir(), 1<<30)
+			req.NoError(err)
+			l, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithGlobalTimeout(5*time.Second),
+				lassie.WithBlockCache(cache),
+			)
+			req.NoError(err)
+			var retrievalEvents atomic.Int32
+			l.RegisterSubscriber(func(types.RetrievalEvent) { retrievalEvents.Add(1) })
+
+			fetch := func(path string) (*types.RetrievalStats, []byte) {
+				var buf bytes.Buffer
+				stats, err := l.FetchToWriter(ctx, srcData.Root, path, trustlessutils.DagScopeAll, &buf)
+				req.NoError(err)
+				req.NoError(l.FlushEvents(ctx))
+				if finishedChan != nil && !stats.BlockCacheHit {
+					mocknet.WaitForFinish(ctx, t, finishedChan, 1*time.Second)
+				}
+				return stats, buf.Bytes()
+			}
+
+			// the first retrieval is from the provider, and fills the cache
+			stats, first := fetch("")
+			req.False(stats.BlockCacheHit)
+			req.NotZero(retrievalEvents.Load())
+			req.Equal(int(stats.Blocks), cache.Len())
+
+			// the same request is served from the cache, without contacting
+			// the provider, with the same response
+			retrievalEvents.Store(0)
+			stats, second := fetch("")
+			req.True(stats.BlockCacheHit)
+			req.Empty(stats.StorageProviderId)
+			req.Equal(uint64(cache.Len()), stats.Blocks)
+			req.Zero(retrievalEvents.Load())
+			req.Equal(first, second)
+
+			// as is a request for a file within it
+			var file unixfs.DirEntry
+			for _, child := range srcData.Children {
+				if child.Content != nil {
+					file = child
+					break
+				}
+			}
+			req.NotNil(file.Content)
+			stats, _ = fetch(strings.TrimPrefix(file.Path, srcData.Path+"/"))
+			req.True(stats.BlockCacheHit)
+			req.Zero(retrievalEvents.Load())
+
+			// once flushed, the provider is contacted again
+			req.NotZero(cache.Clear())
+			stats, third := fetch("")
+			req.False(stats.BlockCacheHit)
+			req.NotZero(retrievalEvents.Load())
+			req.Equal(first, third)
+
+			// a bundle of the cache seeds that of another instance, which then
+			// serves the request without contacting the provider
+			var bundle bytes.Buffer
+			exported, err := l.ExportBlockCache(ctx, &bundle, srcData.Root)
+			req.NoError(err)
+			req.Equal(cache.Len(), exported)
+			otherCache, err := blockcache.New(t.TempDir(), 1<<30)
+			req.NoError(err)
+			other, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+				lassie.WithBlockCache(otherCache),
+			)
+			req.NoError(err)
+			imported, err := other.ImportBlockCache(ctx, &bundle)
+			req.NoError(err)
+			req.Equal(exported, imported)
+			var buf bytes.Buffer
+			stats, err = other.FetchToWriter(ctx, srcData.Root, "", trustlessutils.DagScopeAll, &buf)
+			req.NoError(err)
+			req.True(stats.BlockCacheHit)
+			req.Equal(first, buf.Bytes())
+
+			uncached, err := lassie.NewLassie(ctx, lassie.WithFinder(mrn.Finder), lassie.WithHost(mrn.Self), lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}))
+			req.NoError(err)
+			_, err = uncached.ExportBlockCache(ctx, &bundle)
+			req.ErrorIs(err, lassie.ErrNoBlockCache)
+			_, err = uncached.ImportBlockCache(ctx, &bundle)
+			req.ErrorIs(err, lassie.ErrNoBlockCache)
+		})
+	}
+}
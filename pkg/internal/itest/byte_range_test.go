@@ -0,0 +1,102 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteRange(t *testing.T) {
+	const fileSize = 4 << 20
+
+	last, err := types.ByteRangeLast(1 << 20)
+	require.NoError(t, err)
+	byteRanges := []struct {
+		name      string
+		byteRange trustlessutils.ByteRange
+	}{
+		{name: "from", byteRange: types.ByteRangeFrom(3 << 20)},
+		{name: "between", byteRange: types.ByteRangeBetween(1<<20, 2<<20)},
+		{name: "last", byteRange: last},
+	}
+	protocols := []struct {
+		name     string
+		protocol multicodec.Code
+	}{
+		{name: "bitswap", protocol: multicodec.TransportBitswap},
+		{name: "graphsync", protocol: multicodec.TransportGraphsyncFilecoinv1},
+		{name: "http", protocol: multicodec.TransportIpfsGatewayHttp},
+	}
+
+	for _, protocol := range protocols {
+		for _, byteRange := range byteRanges {
+			protocol, byteRange := protocol, byteRange
+			t.Run(protocol.name+"/"+byteRange.name, func(t *testing.T) {
+				req := require.New(t)
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+
+				rndSeed := time.Now().UTC().UnixNano()
+				t.Logf("random seed: %d", rndSeed)
+				var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+				mrn := mocknet.NewMockRetrievalNet(ctx, t)
+				var finishedChan chan []datatransfer.Event
+				switch protocol.protocol {
+				case multicodec.TransportBitswap:
+					mrn.AddBitswapPeers(1)
+				case multicodec.TransportGraphsyncFilecoinv1:
+					mrn.AddGraphsyncPeers(1)
+					finishedChan = mocknet.SetupRetrieval(t, mrn.Remotes[0])
+				case multicodec.TransportIpfsGatewayHttp:
+					mrn.AddHttpPeers(1)
+				}
+				req.NoError(mrn.MN.LinkAll())
+				srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, fileSize)
+
+				l, err := lassie.NewLassie(
+					ctx,
+					lassie.WithFinder(mrn.Finder),
+					lassie.WithHost(mrn.Self),
+					lassie.WithProtocols([]multicodec.Code{protocol.protocol}),
+					lassie.WithGlobalTimeout(5*time.Second),
+				)
+				req.NoError(err)
+
+				store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+				defer store.Close()
+				request, err := types.NewRequestForByteRange(store, srcData.Root, "", byteRange.byteRange)
+				req.NoError(err)
+				stats, err := l.Fetch(ctx, request)
+				req.NoError(err)
+				if finishedChan != nil {
+					mocknet.WaitForFinish(ctx, t, finishedChan, 1*time.Second)
+				}
+
+				// only the blocks of the range are fetched, the same blocks
+				// whichever the protocol
+				expected := testutil.ToBlocks(t, *mrn.Remotes[0].LinkSystem, srcData.Root, request.GetSelector())
+				req.Less(len(expected), len(srcData.SelfCids))
+				req.Equal(uint64(len(expected)), stats.Blocks)
+				for _, blk := range expected {
+					data, err := store.Get(ctx, blk.Cid().KeyString())
+					req.NoError(err)
+					req.Equal(blk.RawData(), data)
+				}
+			})
+		}
+	}
+}
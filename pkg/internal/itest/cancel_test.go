@@ -0,0 +1,111 @@
+package itest
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancel(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cancel func(t *testing.T, l *lassie.Lassie, id types.RetrievalID)
+	}{
+		{
+			name: "Lassie.Cancel",
+			cancel: func(t *testing.T, l *lassie.Lassie, id types.RetrievalID) {
+				require.True(t, l.Cancel(id))
+			},
+		},
+		{
+			name: "admin endpoint",
+			cancel: func(t *testing.T, l *lassie.Lassie, id types.RetrievalID) {
+				handler := httpserver.NewHandler(l, httpserver.HttpServerConfig{TempDir: t.TempDir()}, httpserver.WithAdmin(true))
+
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/retrievals", nil))
+				require.Equal(t, http.StatusOK, rr.Code)
+				var active []lassie.ActiveRetrieval
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &active))
+				require.Len(t, active, 1)
+				require.Equal(t, id, active[0].RetrievalID)
+
+				rr = httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/admin/retrievals/"+id.String(), nil))
+				require.Equal(t, http.StatusNoContent, rr.Code)
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			mrn.AddHttpPeers(1)
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 4<<20)
+
+			l, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+
+			// the retrieval is paused to hold it in flight until it's cancelled
+			retrievalIDs := make(chan types.RetrievalID, 1)
+			handle := l.StartFetch(ctx, request, types.WithEventsCallback(func(event types.RetrievalEvent) {
+				if _, ok := event.(events.StartedFindingCandidatesEvent); ok {
+					retrievalIDs <- event.RetrievalId()
+				}
+			}))
+			handle.Pause()
+			select {
+			case id := <-retrievalIDs:
+				req.Equal(request.RetrievalID, id)
+			case <-ctx.Done():
+				req.FailNow("retrieval didn't start")
+			}
+			active := l.ActiveRetrievals()
+			req.Len(active, 1)
+			req.Equal(request.RetrievalID, active[0].RetrievalID)
+			req.Equal(srcData.Root, active[0].Root)
+
+			testCase.cancel(t, l, request.RetrievalID)
+			select {
+			case <-handle.Done():
+			case <-time.After(5 * time.Second):
+				req.FailNow("retrieval didn't end when cancelled")
+			}
+			_, err = handle.Wait()
+			req.ErrorIs(err, lassie.ErrRetrievalCancelled)
+			req.Empty(l.ActiveRetrievals())
+			req.False(l.Cancel(request.RetrievalID))
+		})
+	}
+}
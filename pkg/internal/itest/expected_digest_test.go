@@ -0,0 +1,128 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpectedDigest(t *testing.T) {
+	rndReader := rand.New(rand.NewSource(0))
+	content := make([]byte, 2<<20)
+	rndReader.Read(content)
+	digest := func(code uint64, content []byte) multihash.Multihash {
+		mh, err := multihash.Sum(content, code, -1)
+		require.NoError(t, err)
+		return mh
+	}
+
+	testCases := []struct {
+		name      string
+		path      string
+		scope     trustlessutils.DagScope
+		digest    multihash.Multihash
+		maxBlocks uint64
+		expectErr error
+	}{
+		{
+			name:   "sha2-256",
+			path:   "file.bin",
+			digest: digest(multihash.SHA2_256, content),
+		},
+		{
+			name:   "blake3",
+			path:   "file.bin",
+			digest: digest(multihash.BLAKE3, content),
+		},
+		{
+			name:   "entity scope",
+			path:   "file.bin",
+			scope:  trustlessutils.DagScopeEntity,
+			digest: digest(multihash.SHA2_256, content),
+		},
+		{
+			name:      "mismatch",
+			path:      "file.bin",
+			digest:    digest(multihash.SHA2_256, content[1:]),
+			expectErr: lassie.ErrDigestMismatch,
+		},
+		{
+			name:      "partial",
+			path:      "file.bin",
+			digest:    digest(multihash.SHA2_256, content),
+			maxBlocks: 3,
+			expectErr: lassie.ErrDigestMismatch,
+		},
+		{
+			name:      "directory",
+			digest:    digest(multihash.SHA2_256, content),
+			expectErr: lassie.ErrDigestMismatch,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			mrn.AddHttpPeers(1)
+			req.NoError(mrn.MN.LinkAll())
+			root := directory(t, mrn.Remotes[0].LinkSystem, map[string][]byte{"file.bin": content})
+
+			l, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+				lassie.WithGlobalTimeout(5*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), root)
+			defer store.Close()
+			scope := testCase.scope
+			if scope == "" {
+				scope = trustlessutils.DagScopeAll
+			}
+			request, err := types.NewRequestForPath(store, root, testCase.path, scope, nil)
+			req.NoError(err)
+			opts := []types.FetchOption{types.WithExpectedDigest(testCase.digest)}
+			if testCase.maxBlocks > 0 {
+				opts = append(opts, types.WithMaxBlocks(testCase.maxBlocks))
+			}
+			_, err = l.Fetch(ctx, request, opts...)
+			if testCase.expectErr != nil {
+				req.ErrorIs(err, testCase.expectErr)
+				return
+			}
+			req.NoError(err)
+		})
+	}
+
+	t.Run("block scope", func(t *testing.T) {
+		ctx := context.Background()
+		l, err := lassie.NewLassie(ctx, lassie.WithFinder(mocknet.NewMockRetrievalNet(ctx, t).Finder))
+		require.NoError(t, err)
+		root := testutil.GenerateCid()
+		store := storage.NewDeferredStorageCar(t.TempDir(), root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeBlock, nil)
+		require.NoError(t, err)
+		_, err = l.Fetch(ctx, request, types.WithExpectedDigest(digest(multihash.SHA2_256, content)))
+		require.ErrorContains(t, err, "dag-scope")
+	})
+}
@@ -0,0 +1,66 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureStats(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(2)
+	req.NoError(mrn.MN.LinkAll())
+	rndReader := rand.New(rand.NewSource(0))
+	complete := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 1<<20)
+	broken := unixfs.GenerateFile(t, mrn.Remotes[1].LinkSystem, rndReader, 1<<20)
+	req.NoError(mrn.Remotes[1].Blockstore().DeleteBlock(ctx, broken.SelfCids[2]))
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	fetch := func(root cid.Cid) error {
+		store := storage.NewDeferredStorageCar(t.TempDir(), root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+		_, err = l.Fetch(ctx, request)
+		return err
+	}
+	req.NoError(fetch(complete.Root))
+	req.Error(fetch(testutil.GenerateCid()))
+	req.Error(fetch(broken.Root))
+
+	summary := l.FailureStats(time.Minute)
+	req.Equal("1m0s", summary.Window)
+	req.Equal(uint64(3), summary.Retrievals)
+	req.Equal(uint64(2), summary.Failures)
+	req.ElementsMatch([]lassie.FailureCount{
+		{Reason: lassie.FailureNoCandidates, Phase: types.ProgressFindingCandidates, Count: 1},
+		{Reason: lassie.FailureProvidersFailed, Phase: types.ProgressTransferring, Count: 1},
+	}, summary.Reasons)
+	req.Equal([]lassie.ProviderFailureCount{
+		{Protocol: multicodec.TransportIpfsGatewayHttp.String(), Class: lassie.ProviderFailureOther, Count: 1},
+	}, summary.ProviderFailures)
+}
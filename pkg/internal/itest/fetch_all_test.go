@@ -0,0 +1,108 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+type countingCandidateFinder struct {
+	retriever.CandidateFinder
+	lk      sync.Mutex
+	lookups map[cid.Cid]int
+}
+
+func (ccf *countingCandidateFinder) FindCandidatesAsync(ctx context.Context, c cid.Cid, cb func(types.RetrievalCandidate)) error {
+	ccf.lk.Lock()
+	ccf.lookups[c]++
+	ccf.lk.Unlock()
+	return ccf.CandidateFinder.FindCandidatesAsync(ctx, c, cb)
+}
+
+func TestFetchAll(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rndSeed := time.Now().UTC().UnixNano()
+	t.Logf("random seed: %d", rndSeed)
+	var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+
+	finder := &countingCandidateFinder{CandidateFinder: mrn.Finder, lookups: make(map[cid.Cid]int)}
+	lassie, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	// two requests, for different scopes, for each of a number of files, and
+	// one for content that can't be found
+	var requests []types.RetrievalRequest
+	var srcDatas []unixfs.DirEntry
+	for i := 0; i < 4; i++ {
+		srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 1<<20)
+		srcDatas = append(srcDatas, srcData)
+		for _, scope := range []trustlessutils.DagScope{trustlessutils.DagScopeAll, trustlessutils.DagScopeBlock} {
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", scope, nil)
+			req.NoError(err)
+			requests = append(requests, request)
+		}
+	}
+	missing := testutil.GenerateCid()
+	store := storage.NewDeferredStorageCar(t.TempDir(), missing)
+	defer store.Close()
+	request, err := types.NewRequestForPath(store, missing, "", trustlessutils.DagScopeAll, nil)
+	req.NoError(err)
+	requests = append(requests, request)
+
+	result := lassie.FetchAll(ctx, requests, types.WithBatchConcurrency(3))
+
+	req.Len(result.Results, len(requests))
+	req.Equal(len(requests)-1, result.Succeeded)
+	req.Equal(1, result.Failed)
+	var size, blocks uint64
+	for i, res := range result.Results {
+		req.Equal(requests[i].RetrievalID, res.Request.RetrievalID)
+		if i == len(requests)-1 {
+			req.Error(res.Err)
+			req.Nil(res.Stats)
+			continue
+		}
+		req.NoError(res.Err)
+		req.Equal(requests[i].Root, res.Stats.RootCid)
+		size += res.Stats.Size
+		blocks += res.Stats.Blocks
+	}
+	req.Equal(size, result.Size)
+	req.Equal(blocks, result.Blocks)
+
+	// each root was only looked up once
+	for _, srcData := range srcDatas {
+		req.Equal(1, finder.lookups[srcData.Root])
+	}
+	req.Equal(1, finder.lookups[missing])
+}
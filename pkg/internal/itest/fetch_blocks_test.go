@@ -0,0 +1,127 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchBlocks(t *testing.T) {
+	testCases := []struct {
+		name     string
+		protocol multicodec.Code
+	}{
+		{
+			name:     "bitswap",
+			protocol: multicodec.TransportBitswap,
+		},
+		{
+			name:     "graphsync",
+			protocol: multicodec.TransportGraphsyncFilecoinv1,
+		},
+		{
+			name:     "http",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			rndSeed := time.Now().UTC().UnixNano()
+			t.Logf("random seed: %d", rndSeed)
+			var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			switch testCase.protocol {
+			case multicodec.TransportBitswap:
+				mrn.AddBitswapPeers(1)
+			case multicodec.TransportGraphsyncFilecoinv1:
+				mrn.AddGraphsyncPeers(1)
+				mocknet.SetupRetrieval(t, mrn.Remotes[0])
+			case multicodec.TransportIpfsGatewayHttp:
+				mrn.AddHttpPeers(1)
+			}
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateDirectory(t, mrn.Remotes[0].LinkSystem, rndReader, 4<<20, false)
+
+			lassie, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithGlobalTimeout(5*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+
+			blocks := make(chan types.RetrievedBlock)
+			received := make(map[cid.Cid]types.RetrievedBlock)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for blk := range blocks {
+					received[blk.Cid] = blk
+				}
+			}()
+			_, err = lassie.FetchBlocks(ctx, request, blocks)
+			req.NoError(err)
+			<-done
+
+			// every block sent was stored with the same data, and the root was
+			// reached at the root of the traversal
+			for _, blk := range received {
+				data, err := store.Get(ctx, blk.Cid.KeyString())
+				req.NoError(err)
+				req.Equal(data, blk.Data)
+			}
+			req.Contains(received, srcData.Root)
+			req.Equal(0, received[srcData.Root].Path.Len())
+
+			// each file in the directory was reached at its own path
+			for _, child := range srcData.Children {
+				if child.Root == srcData.Root {
+					continue
+				}
+				blk, ok := received[child.Root]
+				req.True(ok)
+				req.NotZero(blk.Path.Len())
+			}
+
+			// and the blocks we received make up the whole directory
+			bag := &memstore.Store{}
+			for _, blk := range received {
+				req.NoError(bag.Put(ctx, blk.Cid.KeyString(), blk.Data))
+			}
+			linkSys := cidlink.DefaultLinkSystem()
+			linkSys.SetReadStorage(bag)
+			linkSys.NodeReifier = unixfsnode.Reify
+			linkSys.TrustedStorage = true
+			gotDir := unixfs.ToDirEntry(t, linkSys, srcData.Root, true)
+			unixfs.CompareDirEntries(t, srcData, gotDir)
+		})
+	}
+}
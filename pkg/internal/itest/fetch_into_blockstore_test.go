@@ -0,0 +1,99 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchIntoBlockstore(t *testing.T) {
+	testCases := []struct {
+		name     string
+		protocol multicodec.Code
+		batch    *storage.BlockstoreBatchConfig
+	}{
+		{
+			name:     "bitswap",
+			protocol: multicodec.TransportBitswap,
+		},
+		{
+			name:     "http",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+		},
+		{
+			name:     "bitswap, batched",
+			protocol: multicodec.TransportBitswap,
+			batch:    &storage.BlockstoreBatchConfig{MaxBlocks: 5},
+		},
+		{
+			name:     "http, batched",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+			batch:    &storage.BlockstoreBatchConfig{MaxBlocks: 5},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			rndSeed := time.Now().UTC().UnixNano()
+			t.Logf("random seed: %d", rndSeed)
+			var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			switch testCase.protocol {
+			case multicodec.TransportBitswap:
+				mrn.AddBitswapPeers(1)
+			case multicodec.TransportIpfsGatewayHttp:
+				mrn.AddHttpPeers(1)
+			}
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 4<<20)
+
+			opts := []lassie.LassieOption{
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithGlobalTimeout(5 * time.Second),
+			}
+			if testCase.batch != nil {
+				opts = append(opts, lassie.WithBlockstoreBatching(*testCase.batch))
+			}
+			lassie, err := lassie.NewLassie(ctx, opts...)
+			req.NoError(err)
+
+			bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+			request, err := types.NewRequestForPath(nil, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+			_, err = lassie.FetchIntoBlockstore(ctx, request, bs)
+			req.NoError(err)
+
+			// the blockstore should contain the whole file
+			linkSys := cidlink.DefaultLinkSystem()
+			linkSys.SetReadStorage(storage.NewBlockstoreStorage(bs))
+			linkSys.NodeReifier = unixfsnode.Reify
+			linkSys.TrustedStorage = true
+			gotDir := unixfs.ToDirEntry(t, linkSys, srcData.Root, true)
+			unixfs.CompareDirEntries(t, srcData, gotDir)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package itest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchNodes(t *testing.T) {
+	errStop := errors.New("seen enough")
+
+	testCases := []struct {
+		name      string
+		protocol  multicodec.Code
+		stopAfter int
+	}{
+		{
+			name:     "bitswap",
+			protocol: multicodec.TransportBitswap,
+		},
+		{
+			name:     "graphsync",
+			protocol: multicodec.TransportGraphsyncFilecoinv1,
+		},
+		{
+			name:     "http",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+		},
+		{
+			name:      "visitor error ends retrieval",
+			protocol:  multicodec.TransportIpfsGatewayHttp,
+			stopAfter: 3,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			rndSeed := time.Now().UTC().UnixNano()
+			t.Logf("random seed: %d", rndSeed)
+			var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			switch testCase.protocol {
+			case multicodec.TransportBitswap:
+				mrn.AddBitswapPeers(1)
+			case multicodec.TransportGraphsyncFilecoinv1:
+				mrn.AddGraphsyncPeers(1)
+				mocknet.SetupRetrieval(t, mrn.Remotes[0])
+			case multicodec.TransportIpfsGatewayHttp:
+				mrn.AddHttpPeers(1)
+			}
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateDirectory(t, mrn.Remotes[0].LinkSystem, rndReader, 4<<20, false)
+
+			lassie, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithGlobalTimeout(5*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+
+			visited := make(map[cid.Cid]datamodel.Path)
+			var order []cid.Cid
+			stats, err := lassie.FetchNodes(ctx, request, func(progress traversal.Progress, node datamodel.Node) error {
+				c := progress.LastBlock.Link.(cidlink.Link).Cid
+				req.NotContains(visited, c)
+				req.Equal(progress.Path, progress.LastBlock.Path)
+				visited[c] = progress.Path
+				order = append(order, c)
+				if testCase.stopAfter > 0 && len(order) == testCase.stopAfter {
+					return errStop
+				}
+				return nil
+			})
+
+			if testCase.stopAfter > 0 {
+				req.ErrorIs(err, errStop)
+				req.Nil(stats)
+				req.Len(order, testCase.stopAfter)
+				return
+			}
+			req.NoError(err)
+			req.NotNil(stats)
+
+			// the root is visited first, at the root of the traversal, and every
+			// block retrieved is visited once
+			req.Equal(srcData.Root, order[0])
+			req.Equal(0, visited[srcData.Root].Len())
+			req.Equal(stats.Blocks, uint64(len(order)))
+
+			// each file in the directory was reached at its own path
+			for _, child := range srcData.Children {
+				if child.Root == srcData.Root {
+					continue
+				}
+				path, ok := visited[child.Root]
+				req.True(ok)
+				req.NotZero(path.Len())
+			}
+		})
+	}
+}
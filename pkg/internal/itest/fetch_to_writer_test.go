@@ -0,0 +1,61 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchToWriter(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rndSeed := time.Now().UTC().UnixNano()
+	t.Logf("random seed: %d", rndSeed)
+	var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 4<<20)
+
+	lassie, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	var buf bytes.Buffer
+	stats, err := lassie.FetchToWriter(ctx, srcData.Root, "", trustlessutils.DagScopeAll, &buf)
+	req.NoError(err)
+	req.NotNil(stats)
+	req.NotEmpty(stats.RequestHash)
+
+	// the written CAR should have our root and contain the whole file
+	reader, err := storage.OpenReadable(bytes.NewReader(buf.Bytes()))
+	req.NoError(err)
+	req.Equal(srcData.Root, reader.Roots()[0])
+	linkSys := cidlink.DefaultLinkSystem()
+	linkSys.SetReadStorage(reader)
+	linkSys.NodeReifier = unixfsnode.Reify
+	linkSys.TrustedStorage = true
+	gotDir := unixfs.ToDirEntry(t, linkSys, srcData.Root, true)
+	unixfs.CompareDirEntries(t, srcData, gotDir)
+}
@@ -0,0 +1,117 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/responsecache"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpResponseCache(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+	cache, err := responsecache.New(t.TempDir(), 10<<20)
+	req.NoError(err)
+	server := httptest.NewServer(httpserver.NewHandler(l, httpserver.HttpServerConfig{TempDir: t.TempDir(), ResponseCache: cache}))
+	defer server.Close()
+
+	fetchFrom := func(server *httptest.Server, path string, headers map[string]string) (*http.Response, []byte) {
+		carReq, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		req.NoError(err)
+		carReq.Header.Set("Accept", "application/vnd.ipld.car")
+		for name, value := range headers {
+			carReq.Header.Set(name, value)
+		}
+		res, err := http.DefaultClient.Do(carReq)
+		req.NoError(err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		req.NoError(err)
+		req.Equal(http.StatusOK, res.StatusCode)
+		return res, body
+	}
+	fetch := func(path string, headers map[string]string) (*http.Response, []byte) {
+		return fetchFrom(server, path, headers)
+	}
+
+	path := "/ipfs/" + srcData.Root.String()
+	res, retrieved := fetch(path, nil)
+	req.Equal("miss", res.Header.Get(httpserver.HeaderCache))
+	req.NotEmpty(res.Header.Get(httpserver.HeaderRetrievalID))
+	requestHash := res.Header.Get(httpserver.HeaderRequestHash)
+	req.Equal(1, cache.Len())
+
+	// the same content, requested with an equivalent URL, is served from the
+	// cache
+	res, cached := fetch(path+"?dag-scope=all", nil)
+	req.Equal("hit", res.Header.Get(httpserver.HeaderCache))
+	req.Equal(retrieved, cached)
+	req.Empty(res.Header.Get(httpserver.HeaderRetrievalID))
+	req.Equal(requestHash, res.Header.Get(httpserver.HeaderRequestHash))
+	req.Equal("application/vnd.ipld.car;version=1;order=dfs;dups=y", res.Header.Get("Content-Type"))
+
+	// other content isn't
+	res, _ = fetch(path+"?dag-scope=entity", nil)
+	req.Equal("miss", res.Header.Get(httpserver.HeaderCache))
+	req.Equal(2, cache.Len())
+
+	// the client can ask for the content to be retrieved again
+	res, _ = fetch(path, map[string]string{"Cache-Control": "no-cache"})
+	req.Equal("miss", res.Header.Get(httpserver.HeaderCache))
+
+	// limited requests aren't cached
+	res, _ = fetch(path+"?blockLimit=2", nil)
+	req.Empty(res.Header.Get(httpserver.HeaderCache))
+	req.Equal(2, cache.Len())
+
+	// the block limit of the server doesn't prevent caching, nor does a client
+	// limit above it
+	limitedCache, err := responsecache.New(t.TempDir(), 10<<20)
+	req.NoError(err)
+	limitedServer := httptest.NewServer(httpserver.NewHandler(l, httpserver.HttpServerConfig{TempDir: t.TempDir(), ResponseCache: limitedCache, MaxBlocksPerRequest: 1000}))
+	defer limitedServer.Close()
+	res, _ = fetchFrom(limitedServer, path, nil)
+	req.Equal("miss", res.Header.Get(httpserver.HeaderCache))
+	req.Equal(1, limitedCache.Len())
+	res, cached = fetchFrom(limitedServer, path+"?blockLimit=2000", nil)
+	req.Equal("hit", res.Header.Get(httpserver.HeaderCache))
+	req.Equal(retrieved, cached)
+	res, _ = fetchFrom(limitedServer, path+"?blockLimit=2", nil)
+	req.Empty(res.Header.Get(httpserver.HeaderCache))
+
+	// but a response it cuts short isn't cached
+	truncatingCache, err := responsecache.New(t.TempDir(), 10<<20)
+	req.NoError(err)
+	truncatingServer := httptest.NewServer(httpserver.NewHandler(l, httpserver.HttpServerConfig{TempDir: t.TempDir(), ResponseCache: truncatingCache, MaxBlocksPerRequest: 2}))
+	defer truncatingServer.Close()
+	res, _ = fetchFrom(truncatingServer, path, nil)
+	req.Equal("miss", res.Header.Get(httpserver.HeaderCache))
+	req.Equal("budget-exceeded", res.Trailer.Get(httpserver.HeaderPartialResult))
+	req.Equal(0, truncatingCache.Len())
+}
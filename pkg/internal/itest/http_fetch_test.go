@@ -81,6 +81,7 @@ type bodyValidator func(*testing.T, unixfs.DirEntry, []byte)
 type response struct {
 	StatusCode int
 	Header     http.Header
+	Trailer    http.Header
 	Body       []byte
 }
 
@@ -106,6 +107,7 @@ func TestHttpFetch(t *testing.T) {
 		disableGraphsync      bool
 		expectNoCandidates    bool
 		expectUncleanEnd      bool
+		expectPartial         bool
 		expectUnauthorized    bool
 		expectAggregateEvents []aggregateeventrecorder.AggregateEvent
 		modifyHttpConfig      func(httpserver.HttpServerConfig) httpserver.HttpServerConfig
@@ -183,7 +185,7 @@ func TestHttpFetch(t *testing.T) {
 		{
 			name:             "graphsync max block limit",
 			graphsyncRemotes: 1,
-			expectUncleanEnd: true,
+			expectPartial:    true,
 			modifyHttpConfig: func(cfg httpserver.HttpServerConfig) httpserver.HttpServerConfig {
 				cfg.MaxBlocksPerRequest = 3
 				return cfg
@@ -194,7 +196,7 @@ func TestHttpFetch(t *testing.T) {
 		{
 			name:             "graphsync max block limit in request",
 			graphsyncRemotes: 1,
-			expectUncleanEnd: true,
+			expectPartial:    true,
 			modifyQueries: []queryModifier{
 				func(values url.Values, _ []testpeer.TestPeer) {
 					values.Add("blockLimit", "3")
@@ -204,9 +206,19 @@ func TestHttpFetch(t *testing.T) {
 			validateBodies: validateFirstThreeBlocksOnly,
 		},
 		{
-			name:             "bitswap max block limit",
-			bitswapRemotes:   1,
-			expectUncleanEnd: true,
+			name:             "graphsync within time limit in request",
+			graphsyncRemotes: 1,
+			modifyQueries: []queryModifier{
+				func(values url.Values, _ []testpeer.TestPeer) {
+					values.Add("timeLimit", "1m")
+				},
+			},
+			generate: singlePeerGenerator(unixfsSpec_largeShardedFile),
+		},
+		{
+			name:           "bitswap max block limit",
+			bitswapRemotes: 1,
+			expectPartial:  true,
 			modifyHttpConfig: func(cfg httpserver.HttpServerConfig) httpserver.HttpServerConfig {
 				cfg.MaxBlocksPerRequest = 3
 				return cfg
@@ -215,9 +227,9 @@ func TestHttpFetch(t *testing.T) {
 			validateBodies: validateFirstThreeBlocksOnly,
 		},
 		{
-			name:             "http max block limit",
-			httpRemotes:      1,
-			expectUncleanEnd: true,
+			name:          "http max block limit",
+			httpRemotes:   1,
+			expectPartial: true,
 			modifyHttpConfig: func(cfg httpserver.HttpServerConfig) httpserver.HttpServerConfig {
 				cfg.MaxBlocksPerRequest = 3
 				return cfg
@@ -965,7 +977,7 @@ func TestHttpFetch(t *testing.T) {
 					}
 					body := readAllBody(t, resp.Body, expectBodyReadError)
 					req.NoError(resp.Body.Close())
-					responseChan <- response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+					responseChan <- response{StatusCode: resp.StatusCode, Header: resp.Header, Trailer: resp.Trailer, Body: body}
 				}(i)
 			}
 
@@ -1008,6 +1020,11 @@ func TestHttpFetch(t *testing.T) {
 					}
 
 					verifyHeaders(t, resp, srcData[i].Root, paths[i], testCase.expectNoDups)
+					if testCase.expectPartial {
+						req.Equal("budget-exceeded", resp.Trailer.Get(httpserver.HeaderPartialResult))
+					} else {
+						req.Empty(resp.Trailer.Get(httpserver.HeaderPartialResult))
+					}
 
 					if DEBUG_DATA {
 						dstf, err := os.CreateTemp("", fmt.Sprintf("%s_received%d.car", strings.Replace(testCase.name, "/", "__", -1), i))
@@ -1110,6 +1127,7 @@ func verifyHeaders(t *testing.T, resp response, root cid.Cid, path string, expec
 	req.True(strings.HasPrefix(etagGot, etagStart), "ETag should start with [%s], got [%s]", etagStart, etagGot)
 	req.Equal(`"`, etagGot[len(etagGot)-1:], "ETag should end with a quote")
 	req.Equal(fmt.Sprintf("/ipfs/%s%s", root.String(), path), resp.Header.Get("X-Ipfs-Path"))
+	req.Len(resp.Header.Get(httpserver.HeaderRequestHash), 64)
 	requestId := resp.Header.Get("X-Trace-Id")
 	require.NotEmpty(t, requestId)
 	_, err := uuid.Parse(requestId)
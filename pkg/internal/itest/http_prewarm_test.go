@@ -0,0 +1,93 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpPrewarm(t *testing.T) {
+	testCases := []struct {
+		name    string
+		prewarm retriever.HttpPrewarm
+		// expectPrewarmed is whether each of two fetches in turn is prewarmed
+		expectPrewarmed []bool
+	}{
+		{
+			name:            "disabled",
+			expectPrewarmed: []bool{false, false},
+		},
+		{
+			name:            "every provider",
+			prewarm:         retriever.HttpPrewarm{Requests: 3},
+			expectPrewarmed: []bool{true, true},
+		},
+		{
+			name:            "known high latency",
+			prewarm:         retriever.HttpPrewarm{Requests: 3, MinLatency: time.Nanosecond},
+			expectPrewarmed: []bool{false, true},
+		},
+		{
+			name:            "low latency",
+			prewarm:         retriever.HttpPrewarm{Requests: 3, MinLatency: time.Hour},
+			expectPrewarmed: []bool{false, false},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			mrn.AddHttpPeers(1)
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+			l, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+				lassie.WithGlobalTimeout(5*time.Second),
+				lassie.WithHttpPrewarm(testCase.prewarm),
+			)
+			req.NoError(err)
+
+			for i, expectPrewarmed := range testCase.expectPrewarmed {
+				store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+				request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+				req.NoError(err)
+				stats, err := l.Fetch(ctx, request)
+				req.NoError(err, "fetch %d", i)
+				req.NoError(store.Close())
+				req.Equal(uint64(len(srcData.SelfCids)), stats.Blocks)
+
+				if !expectPrewarmed {
+					req.Nil(stats.Prewarm, "fetch %d", i)
+					continue
+				}
+				req.NotNil(stats.Prewarm, "fetch %d", i)
+				req.Equal(testCase.prewarm.Requests, stats.Prewarm.Requests)
+				req.Greater(stats.Prewarm.Bytes, uint64(0))
+				req.Greater(stats.Prewarm.Duration, time.Duration(0))
+				if i > 0 {
+					req.Greater(stats.Prewarm.Latency, time.Duration(0))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package itest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpProgressStream(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+	server := httptest.NewServer(httpserver.NewHandler(l, httpserver.HttpServerConfig{TempDir: t.TempDir()}))
+	defer server.Close()
+
+	// the stream is opened before the retrieval, with an ID chosen by the
+	// client
+	retrievalID, err := types.NewRetrievalID()
+	req.NoError(err)
+	streamReq, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/progress/"+retrievalID.String(), nil)
+	req.NoError(err)
+	stream, err := http.DefaultClient.Do(streamReq)
+	req.NoError(err)
+	defer stream.Body.Close()
+	req.Equal(http.StatusOK, stream.StatusCode)
+
+	type frame struct {
+		event string
+		data  map[string]interface{}
+	}
+	frames := make(chan []frame, 1)
+	go func() {
+		var read []frame
+		var current frame
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if event, ok := strings.CutPrefix(line, "event: "); ok {
+				current = frame{event: event}
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				_ = json.Unmarshal([]byte(data), &current.data)
+				read = append(read, current)
+			}
+		}
+		frames <- read
+	}()
+
+	carReq, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ipfs/"+srcData.Root.String(), nil)
+	req.NoError(err)
+	carReq.Header.Set("Accept", "application/vnd.ipld.car")
+	carReq.Header.Set(httpserver.HeaderRetrievalID, retrievalID.String())
+	res, err := http.DefaultClient.Do(carReq)
+	req.NoError(err)
+	_, err = io.Copy(io.Discard, res.Body)
+	req.NoError(err)
+	res.Body.Close()
+	req.Equal(http.StatusOK, res.StatusCode)
+	req.Equal(retrievalID.String(), res.Header.Get(httpserver.HeaderRetrievalID))
+
+	// the stream ends once the retrieval has finished
+	var read []frame
+	select {
+	case read = <-frames:
+	case <-ctx.Done():
+		req.FailNow("progress stream didn't end")
+	}
+	events := make([]string, 0, len(read))
+	for _, f := range read {
+		req.Equal(retrievalID.String(), f.data["retrievalId"])
+		events = append(events, f.event)
+	}
+	req.Contains(events, "started-finding-candidates")
+	req.Contains(events, "candidates-found")
+	req.Contains(events, "first-byte-received")
+	req.NotContains(events, "block-received")
+	last := read[len(read)-1]
+	req.Equal("progress", last.event)
+	req.Equal("finished", last.data["phase"])
+	req.Equal(float64(len(srcData.SelfCids)), last.data["blocksVerified"])
+	req.NotContains(last.data, "error")
+
+	// an invalid ID is rejected
+	carReq.Header.Set(httpserver.HeaderRetrievalID, "not-a-uuid")
+	res, err = http.DefaultClient.Do(carReq)
+	req.NoError(err)
+	res.Body.Close()
+	req.Equal(http.StatusBadRequest, res.StatusCode)
+}
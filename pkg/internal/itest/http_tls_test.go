@@ -0,0 +1,115 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpTLS(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, mathrand.New(mathrand.NewSource(0)), 1<<20)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	// a self-signed certificate for localhost, trusted by the client
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	req.NoError(err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	req.NoError(err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	req.NoError(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	req.NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	_, err = httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{Address: "127.0.0.1", TempDir: t.TempDir(), TLS: &httpserver.TLSConfig{CertFile: certFile}})
+	req.Error(err)
+	httpServer, err := httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{
+		Address: "127.0.0.1",
+		TempDir: t.TempDir(),
+		TLS:     &httpserver.TLSConfig{CertFile: certFile, KeyFile: keyFile},
+	})
+	req.NoError(err)
+	go func() { _ = httpServer.Start() }()
+	defer httpServer.Close()
+	_, port, err := net.SplitHostPort(httpServer.Addr())
+	req.NoError(err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+
+	getReq, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://localhost:%s/ipfs/%s", port, srcData.Root), nil)
+	req.NoError(err)
+	getReq.Header.Add("Accept", "application/vnd.ipld.car")
+	resp, err := client.Do(getReq)
+	req.NoError(err)
+	defer resp.Body.Close()
+	req.Equal(http.StatusOK, resp.StatusCode)
+	// HTTP/2 isn't offered, as responses may need to be cut short
+	req.Equal("http/1.1", resp.TLS.NegotiatedProtocol)
+	body, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	reader, err := storage.OpenReadable(bytes.NewReader(body))
+	req.NoError(err)
+	req.Equal(srcData.Root, reader.Roots()[0])
+
+	// plain HTTP isn't served
+	getReq, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://localhost:%s/healthz", port), nil)
+	req.NoError(err)
+	resp, err = http.DefaultClient.Do(getReq)
+	req.NoError(err)
+	defer resp.Body.Close()
+	req.Equal(http.StatusBadRequest, resp.StatusCode)
+}
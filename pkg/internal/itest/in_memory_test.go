@@ -0,0 +1,96 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-car/v2/storage"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemory(t *testing.T) {
+	// temporary files can't be created in a directory that doesn't exist, so
+	// any attempt to touch disk fails the fetch
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(tempDir, "missing"))
+
+	testCases := []struct {
+		name     string
+		inMemory bool
+	}{
+		{name: "temporary files"},
+		{name: "in memory", inMemory: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			mrn.AddBitswapPeers(1)
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+			opts := []lassie.LassieOption{
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+				lassie.WithGlobalTimeout(5 * time.Second),
+			}
+			if testCase.inMemory {
+				opts = append(opts, lassie.WithInMemory())
+			}
+			l, err := lassie.NewLassie(ctx, opts...)
+			req.NoError(err)
+			req.Equal(testCase.inMemory, l.InMemory())
+
+			var buf bytes.Buffer
+			stats, err := l.FetchToWriter(ctx, srcData.Root, "", trustlessutils.DagScopeAll, &buf)
+			if !testCase.inMemory {
+				req.ErrorContains(err, "no such file or directory")
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(srcData.SelfCids)), stats.Blocks)
+			reader, err := storage.OpenReadable(bytes.NewReader(buf.Bytes()))
+			req.NoError(err)
+			req.Equal(srcData.Root, reader.Roots()[0])
+
+			// the HTTP server refuses a temporary directory, and without one
+			// serves requests from memory
+			_, err = httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{Address: "127.0.0.1", TempDir: tempDir})
+			req.ErrorIs(err, lassie.ErrDiskAccess)
+			httpServer, err := httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{Address: "127.0.0.1"})
+			req.NoError(err)
+			go func() { _ = httpServer.Start() }()
+			defer httpServer.Close()
+
+			getReq, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s/ipfs/%s", httpServer.Addr(), srcData.Root), nil)
+			req.NoError(err)
+			getReq.Header.Add("Accept", "application/vnd.ipld.car")
+			resp, err := http.DefaultClient.Do(getReq)
+			req.NoError(err)
+			defer resp.Body.Close()
+			req.Equal(http.StatusOK, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			req.NoError(err)
+			req.Equal(buf.Bytes(), body)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// nameResolver resolves every name to the root it has been set to.
+type nameResolver struct {
+	lk   sync.Mutex
+	root cid.Cid
+}
+
+func (nr *nameResolver) Resolve(ctx context.Context, name string) (cid.Cid, datamodel.Path, error) {
+	nr.lk.Lock()
+	defer nr.lk.Unlock()
+	return nr.root, datamodel.Path{}, nil
+}
+
+func (nr *nameResolver) set(root cid.Cid) {
+	nr.lk.Lock()
+	defer nr.lk.Unlock()
+	nr.root = root
+}
+
+func TestIpnsStaleWhileRevalidate(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	first := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 256<<10)
+	second := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(1)), 256<<10)
+
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	req.NoError(err)
+	pid, err := peer.IDFromPrivateKey(key)
+	req.NoError(err)
+	name := ipns.NameFromPeer(pid)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	// every resolution is stale straight away, and remains servable while
+	// it's revalidated
+	resolver := &nameResolver{root: first.Root}
+	httpServer, err := httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{
+		Address:      "127.0.0.1",
+		TempDir:      t.TempDir(),
+		IpnsResolver: resolver,
+		IpnsMaxStale: time.Hour,
+	})
+	req.NoError(err)
+	go func() { _ = httpServer.Start() }()
+	defer httpServer.Close()
+
+	get := func(path string) (*http.Response, cid.Cid) {
+		getReq, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s%s", httpServer.Addr(), path), nil)
+		req.NoError(err)
+		getReq.Header.Add("Accept", "application/vnd.ipld.car")
+		resp, err := http.DefaultClient.Do(getReq)
+		req.NoError(err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		req.NoError(err)
+		if resp.StatusCode != http.StatusOK {
+			return resp, cid.Undef
+		}
+		reader, err := storage.OpenReadable(bytes.NewReader(body))
+		req.NoError(err)
+		return resp, reader.Roots()[0]
+	}
+	ipnsPath := "/ipns/" + name.String()
+
+	resp, root := get(ipnsPath)
+	req.Equal(http.StatusOK, resp.StatusCode)
+	req.Equal(first.Root, root)
+	req.Equal("public, max-age=0, stale-while-revalidate=3600", resp.Header.Get("Cache-Control"))
+	req.Equal(ipnsPath, resp.Header.Get("X-Ipfs-Path"))
+
+	// once the name is updated, the stale resolution is served while the
+	// name is resolved again in the background
+	resolver.set(second.Root)
+	resp, root = get(ipnsPath)
+	req.Equal(http.StatusOK, resp.StatusCode)
+	req.Equal(first.Root, root)
+	req.Eventually(func() bool {
+		_, root := get(ipnsPath)
+		return root == second.Root
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// /ipfs/ responses remain immutable
+	resp, _ = get("/ipfs/" + second.Root.String())
+	req.Equal(trustlesshttp.ResponseCacheControlHeader, resp.Header.Get("Cache-Control"))
+
+	// a CID that isn't a libp2p-key is neither an IPNS name nor a DNSLink domain
+	resp, _ = get("/ipns/bafkqaaa")
+	req.Equal(http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,88 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// subsystemsLogger records the subsystem and retrieval ID of each log.
+type subsystemsLogger struct {
+	lk   sync.Mutex
+	logs map[string][]string
+}
+
+func (s *subsystemsLogger) record(keysAndValues []interface{}) {
+	var subsystem, retrievalID string
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		switch keysAndValues[i] {
+		case logging.SubsystemKey:
+			subsystem = keysAndValues[i+1].(string)
+		case logging.RetrievalIDKey:
+			retrievalID = keysAndValues[i+1].(types.RetrievalID).String()
+		}
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.logs[subsystem] = append(s.logs[subsystem], retrievalID)
+}
+
+func (s *subsystemsLogger) Debugw(_ string, keysAndValues ...interface{}) { s.record(keysAndValues) }
+func (s *subsystemsLogger) Infow(_ string, keysAndValues ...interface{})  { s.record(keysAndValues) }
+func (s *subsystemsLogger) Warnw(_ string, keysAndValues ...interface{})  { s.record(keysAndValues) }
+func (s *subsystemsLogger) Errorw(_ string, keysAndValues ...interface{}) { s.record(keysAndValues) }
+
+func TestLogger(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+	recorder := &subsystemsLogger{logs: make(map[string][]string)}
+	defer logging.SetLogger(nil)
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+		lassie.WithLogger(recorder),
+		lassie.WithLogLevel("lassie/bitswap", logging.LevelInfo),
+	)
+	req.NoError(err)
+
+	store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+	defer store.Close()
+	request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+	req.NoError(err)
+	request.RetrievalID, err = types.NewRetrievalID()
+	req.NoError(err)
+	_, err = l.Fetch(ctx, request)
+	req.NoError(err)
+
+	recorder.lk.Lock()
+	defer recorder.lk.Unlock()
+	// the retriever's logs are all about this retrieval
+	req.NotEmpty(recorder.logs["lassie/retriever"])
+	for _, retrievalID := range recorder.logs["lassie/retriever"] {
+		req.Equal(request.RetrievalID.String(), retrievalID)
+	}
+	// the bitswap helpers only log at debug level
+	req.Empty(recorder.logs["lassie/bitswap"])
+}
@@ -0,0 +1,111 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(2)
+	req.NoError(mrn.MN.LinkAll())
+	rndReader := rand.New(rand.NewSource(0))
+	complete := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 1<<20)
+	broken := unixfs.GenerateFile(t, mrn.Remotes[1].LinkSystem, rndReader, 1<<20)
+	req.NoError(mrn.Remotes[1].Blockstore().DeleteBlock(ctx, broken.SelfCids[2]))
+
+	registry := prometheus.NewRegistry()
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+		lassie.WithMetricsRegisterer(registry),
+	)
+	req.NoError(err)
+
+	// a second instance can't register the same metrics
+	_, err = lassie.NewLassie(ctx, lassie.WithFinder(mrn.Finder), lassie.WithHost(mrn.Self), lassie.WithMetricsRegisterer(registry))
+	req.Error(err)
+
+	fetch := func(root cid.Cid) error {
+		store := storage.NewDeferredStorageCar(t.TempDir(), root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+		_, err = l.Fetch(ctx, request)
+		return err
+	}
+	req.NoError(fetch(complete.Root))
+	req.Error(fetch(testutil.GenerateCid()))
+	req.Error(fetch(broken.Root))
+
+	families, err := registry.Gather()
+	req.NoError(err)
+	metrics := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		metrics[family.GetName()] = family
+	}
+	// value sums the counters, or counts the observations of the histograms,
+	// of a family with the given labels
+	value := func(name string, labels ...string) float64 {
+		family, ok := metrics[name]
+		req.True(ok, "missing metric %s", name)
+		var sum float64
+	next:
+		for _, metric := range family.GetMetric() {
+			for i := 0; i < len(labels); i += 2 {
+				var found bool
+				for _, label := range metric.GetLabel() {
+					found = found || (label.GetName() == labels[i] && label.GetValue() == labels[i+1])
+				}
+				if !found {
+					continue next
+				}
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				sum += metric.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				sum += metric.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				sum += float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+		return sum
+	}
+
+	protocol := multicodec.TransportIpfsGatewayHttp.String()
+	req.Equal(1.0, value("lassie_retrievals_total", "result", "success"))
+	req.Equal(2.0, value("lassie_retrievals_total", "result", "failure"))
+	req.Equal(1.0, value("lassie_retrieval_failures_total", "reason", string(lassie.FailureNoCandidates), "phase", string(types.ProgressFindingCandidates)))
+	req.Equal(1.0, value("lassie_retrieval_failures_total", "reason", string(lassie.FailureProvidersFailed), "phase", string(types.ProgressTransferring)))
+	req.Equal(3.0, value("lassie_retrieval_duration_seconds"))
+	req.Equal(3.0, value("lassie_retrieval_candidates"))
+	req.Equal(2.0, value("lassie_retrieval_attempts_total", "protocol", protocol))
+	req.Equal(1.0, value("lassie_retrieval_attempt_failures_total", "protocol", protocol, "class", string(lassie.ProviderFailureOther)))
+	req.Equal(2.0, value("lassie_retrieval_ttfb_seconds", "protocol", protocol))
+	req.Greater(value("lassie_retrieval_received_bytes_total", "protocol", protocol), float64(1<<20))
+	req.Equal(1.0, value("lassie_retrieval_bandwidth_bytes_per_second"))
+	req.Equal(0.0, value("lassie_active_retrievals"))
+}
@@ -0,0 +1,192 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNestedCars(t *testing.T) {
+	rndReader := rand.New(rand.NewSource(0))
+
+	// inner.car holds a file, middle.car holds a directory with inner.car in
+	// it, and the fetched directory holds middle.car alongside a plain file
+	innerStore, innerLsys := memLinkSystem()
+	inner := unixfs.GenerateFile(t, innerLsys, rndReader, 512<<10)
+	innerCar := carBytes(t, innerStore, inner.Root, cid.Undef)
+	middleStore, middleLsys := memLinkSystem()
+	middleRoot := directory(t, middleLsys, map[string][]byte{"inner.car": innerCar})
+	middleCar := carBytes(t, middleStore, middleRoot, cid.Undef)
+	// broken.car is missing a block of the DAG under its root
+	brokenCar := carBytes(t, innerStore, inner.Root, inner.SelfCids[1])
+
+	testCases := []struct {
+		name           string
+		broken         bool
+		nestedCars     *types.NestedCarConfig
+		expectNested   uint64
+		expectErr      error
+		expectPresent  []cid.Cid
+		expectAbsent   []cid.Cid
+		expectComplete []cid.Cid
+	}{
+		{
+			name:         "disabled",
+			expectAbsent: []cid.Cid{middleRoot, inner.Root},
+		},
+		{
+			name:          "depth 1",
+			nestedCars:    &types.NestedCarConfig{MaxDepth: 1},
+			expectNested:  1,
+			expectPresent: []cid.Cid{middleRoot},
+			expectAbsent:  []cid.Cid{inner.Root},
+		},
+		{
+			name:           "depth 2",
+			nestedCars:     &types.NestedCarConfig{MaxDepth: 2},
+			expectNested:   2,
+			expectComplete: []cid.Cid{middleRoot, inner.Root},
+		},
+		{
+			name:         "larger than max bytes",
+			nestedCars:   &types.NestedCarConfig{MaxDepth: 2, MaxBytes: 1 << 10},
+			expectAbsent: []cid.Cid{middleRoot, inner.Root},
+		},
+		{
+			name:       "incomplete nested car",
+			broken:     true,
+			nestedCars: &types.NestedCarConfig{},
+			expectErr:  lassie.ErrInvalidNestedCar,
+		},
+		{
+			name:         "incomplete nested car, disabled",
+			broken:       true,
+			expectAbsent: []cid.Cid{inner.Root},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			mrn.AddHttpPeers(1)
+			req.NoError(mrn.MN.LinkAll())
+			files := map[string][]byte{"middle.car": middleCar}
+			if testCase.broken {
+				files = map[string][]byte{"broken.car": brokenCar}
+			}
+			files["plain.bin"] = make([]byte, 1<<10)
+			rndReader.Read(files["plain.bin"])
+			root := directory(t, mrn.Remotes[0].LinkSystem, files)
+
+			l, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+				lassie.WithGlobalTimeout(5*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+			var opts []types.FetchOption
+			if testCase.nestedCars != nil {
+				opts = append(opts, types.WithNestedCars(*testCase.nestedCars))
+			}
+			stats, err := l.Fetch(ctx, request, opts...)
+			if testCase.expectErr != nil {
+				req.ErrorIs(err, testCase.expectErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(testCase.expectNested, stats.NestedCars)
+
+			for _, c := range testCase.expectPresent {
+				has, err := store.Has(ctx, c.KeyString())
+				req.NoError(err)
+				req.True(has, "expected %s to be present", c)
+			}
+			for _, c := range testCase.expectAbsent {
+				has, err := store.Has(ctx, c.KeyString())
+				req.NoError(err)
+				req.False(has, "expected %s to be absent", c)
+			}
+			lsys := cidlink.DefaultLinkSystem()
+			lsys.SetReadStorage(store)
+			for _, c := range testCase.expectComplete {
+				_, err := traversal.Config{
+					Root:     c,
+					Selector: selectorparse.CommonSelector_ExploreAllRecursively,
+				}.Traverse(ctx, lsys, nil)
+				req.NoError(err, "expected the DAG under %s to be complete", c)
+			}
+		})
+	}
+}
+
+func memLinkSystem() (*memstore.Store, *linking.LinkSystem) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	return store, &lsys
+}
+
+// carBytes writes the blocks of the store to a CARv1 with the given root,
+// leaving out the skip block.
+func carBytes(t *testing.T, store *memstore.Store, root cid.Cid, skip cid.Cid) []byte {
+	var buf bytes.Buffer
+	w, err := carstorage.NewWritable(&buf, []cid.Cid{root}, car.WriteAsCarV1(true))
+	require.NoError(t, err)
+	for key, data := range store.Bag {
+		if skip.Defined() && key == skip.KeyString() {
+			continue
+		}
+		require.NoError(t, w.Put(context.Background(), key, data))
+	}
+	require.NoError(t, w.Finalize())
+	return buf.Bytes()
+}
+
+// directory builds a UnixFS directory of the given files.
+func directory(t *testing.T, lsys *linking.LinkSystem, files map[string][]byte) cid.Cid {
+	var entries []dagpb.PBLink
+	for name, content := range files {
+		link, size, err := builder.BuildUnixFSFile(bytes.NewReader(content), "", lsys)
+		require.NoError(t, err)
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), link)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	link, _, err := builder.BuildUnixFSDirectory(entries, lsys)
+	require.NoError(t, err)
+	return link.(cidlink.Link).Cid
+}
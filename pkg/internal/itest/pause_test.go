@@ -0,0 +1,154 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-unixfsnode"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPauseResume(t *testing.T) {
+	testCases := []struct {
+		name     string
+		protocol multicodec.Code
+	}{
+		{
+			name:     "bitswap",
+			protocol: multicodec.TransportBitswap,
+		},
+		{
+			name:     "graphsync",
+			protocol: multicodec.TransportGraphsyncFilecoinv1,
+		},
+		{
+			name:     "http",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			rndSeed := time.Now().UTC().UnixNano()
+			t.Logf("random seed: %d", rndSeed)
+			var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			switch testCase.protocol {
+			case multicodec.TransportBitswap:
+				mrn.AddBitswapPeers(1)
+			case multicodec.TransportGraphsyncFilecoinv1:
+				mrn.AddGraphsyncPeers(1)
+				mocknet.SetupRetrieval(t, mrn.Remotes[0])
+			case multicodec.TransportIpfsGatewayHttp:
+				mrn.AddHttpPeers(1)
+			}
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 4<<20)
+
+			// a provider timeout shorter than the pause, which mustn't apply
+			// while paused
+			lassie, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithProviderTimeout(100*time.Millisecond),
+				lassie.WithGlobalTimeout(10*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+
+			var failures atomic.Int32
+			handle := lassie.StartFetch(ctx, request, types.WithEventsCallback(func(event types.RetrievalEvent) {
+				if _, ok := event.(events.FailedRetrievalEvent); ok {
+					failures.Add(1)
+				}
+			}))
+			req.Equal(request.RetrievalID, handle.RetrievalID)
+			req.True(handle.Pause())
+			req.False(handle.Pause())
+			req.True(handle.Paused())
+
+			// the retrieval doesn't finish, or time out, while paused
+			select {
+			case <-handle.Done():
+				_, err := handle.Wait()
+				req.FailNow("retrieval finished while paused", "err: %v", err)
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			req.True(handle.Resume())
+			req.False(handle.Resume())
+			req.False(handle.Paused())
+			stats, err := handle.Wait()
+			req.NoError(err)
+			req.Equal(srcData.Root, stats.RootCid)
+			req.Zero(failures.Load())
+
+			linkSys := cidlink.DefaultLinkSystem()
+			linkSys.SetReadStorage(store)
+			linkSys.NodeReifier = unixfsnode.Reify
+			linkSys.TrustedStorage = true
+			gotFile := unixfs.ToDirEntry(t, linkSys, srcData.Root, true)
+			unixfs.CompareDirEntries(t, srcData, gotFile)
+		})
+	}
+
+	t.Run("cancel while paused", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		mrn := mocknet.NewMockRetrievalNet(ctx, t)
+		mrn.AddBitswapPeers(1)
+		req.NoError(mrn.MN.LinkAll())
+		srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 4<<20)
+
+		lassie, err := lassie.NewLassie(
+			ctx,
+			lassie.WithFinder(mrn.Finder),
+			lassie.WithHost(mrn.Self),
+			lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		)
+		req.NoError(err)
+
+		store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+
+		handle := lassie.StartFetch(ctx, request)
+		handle.Pause()
+		handle.Cancel()
+		select {
+		case <-handle.Done():
+		case <-time.After(5 * time.Second):
+			req.FailNow("retrieval didn't end when cancelled")
+		}
+		_, err = handle.Wait()
+		req.Error(err)
+	})
+}
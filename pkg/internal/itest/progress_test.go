@@ -0,0 +1,152 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	testCases := []struct {
+		name     string
+		protocol multicodec.Code
+	}{
+		{
+			name:     "bitswap",
+			protocol: multicodec.TransportBitswap,
+		},
+		{
+			name:     "graphsync",
+			protocol: multicodec.TransportGraphsyncFilecoinv1,
+		},
+		{
+			name:     "http",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			rndSeed := time.Now().UTC().UnixNano()
+			t.Logf("random seed: %d", rndSeed)
+			var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			switch testCase.protocol {
+			case multicodec.TransportBitswap:
+				mrn.AddBitswapPeers(1)
+			case multicodec.TransportGraphsyncFilecoinv1:
+				mrn.AddGraphsyncPeers(1)
+				mocknet.SetupRetrieval(t, mrn.Remotes[0])
+			case multicodec.TransportIpfsGatewayHttp:
+				mrn.AddHttpPeers(1)
+			}
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 4<<20)
+
+			lassie, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithGlobalTimeout(5*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+
+			var lk sync.Mutex
+			var updates []types.ProgressUpdate
+			stats, err := lassie.Fetch(ctx, request, types.WithProgress(func(update types.ProgressUpdate) {
+				lk.Lock()
+				defer lk.Unlock()
+				updates = append(updates, update)
+			}))
+			req.NoError(err)
+
+			lk.Lock()
+			defer lk.Unlock()
+			req.GreaterOrEqual(len(updates), 4)
+			req.Equal(types.ProgressFindingCandidates, updates[0].Phase)
+			req.Equal(types.ProgressConnecting, updates[1].Phase)
+			req.Equal(types.ProgressTransferring, updates[2].Phase)
+
+			// counters and phases never go backwards
+			for i := 1; i < len(updates); i++ {
+				req.Equal(request.RetrievalID, updates[i].RetrievalID)
+				req.GreaterOrEqual(updates[i].BytesReceived, updates[i-1].BytesReceived)
+				req.GreaterOrEqual(updates[i].BlocksVerified, updates[i-1].BlocksVerified)
+				req.GreaterOrEqual(updates[i].Elapsed, updates[i-1].Elapsed)
+			}
+
+			final := updates[len(updates)-1]
+			req.Equal(types.ProgressFinished, final.Phase)
+			req.NoError(final.Err)
+			req.Equal(stats.Blocks, final.BlocksVerified)
+			req.Equal(stats.Size, final.BytesVerified)
+			req.NotZero(final.BytesReceived)
+			req.Equal(testCase.protocol, final.Protocol)
+			if testCase.protocol != multicodec.TransportBitswap {
+				req.Equal(mrn.Remotes[0].ID, final.Provider)
+			}
+			req.Zero(final.ETA)
+		})
+	}
+
+	t.Run("failure", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		mrn := mocknet.NewMockRetrievalNet(ctx, t)
+		mrn.AddBitswapPeers(1)
+		req.NoError(mrn.MN.LinkAll())
+
+		lassie, err := lassie.NewLassie(
+			ctx,
+			lassie.WithFinder(mrn.Finder),
+			lassie.WithHost(mrn.Self),
+			lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+			lassie.WithGlobalTimeout(time.Second),
+		)
+		req.NoError(err)
+
+		missing := testutil.GenerateCid()
+		store := storage.NewDeferredStorageCar(t.TempDir(), missing)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, missing, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+
+		var updates []types.ProgressUpdate
+		_, err = lassie.Fetch(ctx, request, types.WithProgress(func(update types.ProgressUpdate) {
+			updates = append(updates, update)
+		}))
+		req.Error(err)
+		req.Equal(types.ProgressFindingCandidates, updates[0].Phase)
+		final := updates[len(updates)-1]
+		req.Equal(types.ProgressFinished, final.Phase)
+		req.Equal(err, final.Err)
+		req.Zero(final.BlocksVerified)
+	})
+}
@@ -0,0 +1,63 @@
+package itest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisableProtocol(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	root := directory(t, mrn.Remotes[0].LinkSystem, map[string][]byte{"file.txt": []byte("hello")})
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap, multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+	fetch := func() error {
+		store := storage.NewDeferredStorageCar(t.TempDir(), root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+		_, err = l.Fetch(ctx, request)
+		return err
+	}
+
+	req.ErrorIs(l.DisableProtocol(multicodec.TransportGraphsyncFilecoinv1), retriever.ErrProtocolNotConfigured)
+
+	req.NoError(l.DisableProtocol(multicodec.TransportIpfsGatewayHttp))
+	req.Equal([]lassie.ProtocolState{
+		{Protocol: "transport-bitswap", Enabled: true},
+		{Protocol: "transport-ipfs-gateway-http", Enabled: false},
+	}, l.Protocols())
+	req.NoError(l.CheckProtocols(ctx))
+	// the content is only available over HTTP
+	req.Error(fetch())
+
+	req.NoError(l.DisableProtocol(multicodec.TransportBitswap))
+	req.ErrorIs(l.CheckProtocols(ctx), lassie.ErrAllProtocolsDisabled)
+	req.ErrorIs(fetch(), retriever.ErrNoProtocolsEnabled)
+
+	req.NoError(l.EnableProtocol(multicodec.TransportIpfsGatewayHttp))
+	req.NoError(l.CheckProtocols(ctx))
+	req.NoError(fetch())
+}
@@ -0,0 +1,100 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// eventCollector records the events it receives as a subscriber.
+type eventCollector struct {
+	lk     sync.Mutex
+	events []types.RetrievalEvent
+}
+
+func (ec *eventCollector) subscriber(event types.RetrievalEvent) {
+	ec.lk.Lock()
+	defer ec.lk.Unlock()
+	ec.events = append(ec.events, event)
+}
+
+func (ec *eventCollector) collected() []types.RetrievalEvent {
+	ec.lk.Lock()
+	defer ec.lk.Unlock()
+	return append([]types.RetrievalEvent{}, ec.events...)
+}
+
+func TestRequestSubscriber(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+	all := &eventCollector{}
+	defer l.RegisterSubscriber(all.subscriber)()
+
+	retrievalID, err := types.NewRetrievalID()
+	req.NoError(err)
+	fetch := func(opts ...types.FetchOption) {
+		store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+		request.RetrievalID = retrievalID
+		_, err = l.Fetch(ctx, request, opts...)
+		req.NoError(err)
+	}
+	finished := func(collector *eventCollector) func() bool {
+		return func() bool {
+			collected := collector.collected()
+			if len(collected) == 0 {
+				return false
+			}
+			_, ok := collected[len(collected)-1].(events.FinishedEvent)
+			return ok
+		}
+	}
+
+	// two subscribers attached to the first retrieval receive all of its events
+	first, second := &eventCollector{}, &eventCollector{}
+	fetch(types.WithSubscriber(first.subscriber), types.WithSubscriber(second.subscriber))
+	req.Eventually(finished(first), time.Second, 10*time.Millisecond)
+	req.Eventually(finished(all), time.Second, 10*time.Millisecond)
+	req.Equal(all.collected(), first.collected())
+	req.Equal(all.collected(), second.collected())
+	_, ok := first.collected()[0].(events.StartedFetchEvent)
+	req.True(ok)
+
+	// a later retrieval, even with the same ID, isn't seen by the subscribers
+	// of the first, which have been unregistered
+	count := len(first.collected())
+	third := &eventCollector{}
+	fetch(types.WithSubscriber(third.subscriber))
+	req.Eventually(finished(third), time.Second, 10*time.Millisecond)
+	req.Len(first.collected(), count)
+	req.Len(second.collected(), count)
+}
@@ -0,0 +1,75 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/resultstore"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResults(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	file := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+	missing := testutil.GenerateCid()
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		lassie.WithGlobalTimeout(5*time.Second),
+		lassie.WithResultStore(resultstore.NewStore(resultstore.Config{Datastore: dssync.MutexWrap(datastore.NewMapDatastore())})),
+	)
+	req.NoError(err)
+
+	fetch := func(root cid.Cid) (types.RetrievalRequest, *types.RetrievalStats, error) {
+		store := storage.NewDeferredStorageCar(t.TempDir(), root)
+		defer store.Close()
+		request, err := types.NewRequestForPath(store, root, "", trustlessutils.DagScopeAll, nil)
+		req.NoError(err)
+		stats, err := l.Fetch(ctx, request)
+		return request, stats, err
+	}
+	fileRequest, stats, err := fetch(file.Root)
+	req.NoError(err)
+	missingRequest, _, err := fetch(missing)
+	req.Error(err)
+
+	results, err := l.QueryResults(ctx, resultstore.Query{})
+	req.NoError(err)
+	req.Len(results, 2)
+	req.Equal(missingRequest.RetrievalID, results[0].RetrievalID)
+	req.Equal(string(lassie.FailureNoCandidates), results[0].Outcome)
+	req.NotEmpty(results[0].Error)
+
+	results, err = l.QueryResults(ctx, resultstore.Query{Root: file.Root, Outcome: resultstore.OutcomeSuccess})
+	req.NoError(err)
+	req.Len(results, 1)
+	result := results[0]
+	req.Equal(fileRequest.RetrievalID, result.RetrievalID)
+	req.Equal(stats.RequestHash, result.RequestHash)
+	req.Equal(mrn.Remotes[0].ID, result.Provider)
+	req.Equal(multicodec.TransportIpfsGatewayHttp.String(), result.Protocol)
+	req.Equal(stats.Size, result.Size)
+	req.Equal(stats.Blocks, result.Blocks)
+}
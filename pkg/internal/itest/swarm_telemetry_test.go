@@ -0,0 +1,73 @@
+package itest
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmTelemetry(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rndSeed := time.Now().UTC().UnixNano()
+	t.Logf("random seed: %d", rndSeed)
+	var rndReader io.Reader = rand.New(rand.NewSource(rndSeed))
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rndReader, 1<<20)
+
+	lassie, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+		lassie.WithTelemetryInterval(10*time.Millisecond),
+	)
+	req.NoError(err)
+
+	telemetry := make(chan events.SwarmTelemetryEvent, 1)
+	lassie.RegisterSubscriber(func(event types.RetrievalEvent) {
+		if evt, ok := event.(events.SwarmTelemetryEvent); ok {
+			select {
+			case telemetry <- evt:
+			default:
+			}
+		}
+	})
+
+	store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+	defer store.Close()
+	request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+	req.NoError(err)
+	_, err = lassie.Fetch(ctx, request)
+	req.NoError(err)
+
+	// once the retrieval is done we should still be connected to the provider,
+	// with no bitswap session left running
+	req.Eventually(func() bool {
+		select {
+		case evt := <-telemetry:
+			req.Equal(types.SwarmTelemetryCode, evt.Code())
+			return evt.ConnectedPeers() >= 1 && evt.ActiveBitswapSessions() == 0
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,109 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempQuota(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddBitswapPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+		lassie.WithGlobalTimeout(5*time.Second),
+	)
+	req.NoError(err)
+
+	// a retrieval fails once its blocks would take the temporary storage
+	// beyond the quota
+	small, err := storage.NewTempQuota(256<<10, 0)
+	req.NoError(err)
+	_, err = l.FetchToWriter(ctx, srcData.Root, "", trustlessutils.DagScopeAll, io.Discard, types.WithTempStore(small.Factory(storage.NewInMemoryCarStoreFactory())))
+	req.ErrorIs(err, storage.ErrTempQuotaExceeded)
+	req.Zero(small.Used())
+
+	// the HTTP server holds back requests while the quota is near full
+	quota, err := storage.NewTempQuota(4<<20, 2<<20)
+	req.NoError(err)
+	httpServer, err := httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{
+		Address:          "127.0.0.1",
+		TempDir:          t.TempDir(),
+		TempQuota:        quota,
+		TempQuotaTimeout: 100 * time.Millisecond,
+	})
+	req.NoError(err)
+	go func() { _ = httpServer.Start() }()
+	defer httpServer.Close()
+
+	get := func(path string) *http.Response {
+		getReq, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s%s", httpServer.Addr(), path), nil)
+		req.NoError(err)
+		getReq.Header.Add("Accept", "application/vnd.ipld.car")
+		resp, err := http.DefaultClient.Do(getReq)
+		req.NoError(err)
+		return resp
+	}
+
+	// another retrieval holding blocks up to the high water mark
+	other := quota.Wrap(storage.NewDeferredStorageCarInMemory(srcData.Root))
+	for i := 0; i < 2; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 1<<20)
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data)
+		req.NoError(err)
+		req.NoError(other.Put(ctx, c.KeyString(), data))
+	}
+	resp := get("/ipfs/" + srcData.Root.String())
+	resp.Body.Close()
+	req.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	req.Equal("5", resp.Header.Get("Retry-After"))
+	resp = get("/readyz")
+	var health httpserver.HealthResponse
+	req.NoError(json.NewDecoder(resp.Body).Decode(&health))
+	resp.Body.Close()
+	var checked bool
+	for _, check := range health.Checks {
+		if check.Name == "temp-quota" {
+			checked = true
+			req.NotEmpty(check.Error)
+		}
+	}
+	req.True(checked)
+
+	// and serves them once there's room
+	req.NoError(other.Close())
+	resp = get("/ipfs/" + srcData.Root.String())
+	defer resp.Body.Close()
+	req.Equal(http.StatusOK, resp.StatusCode)
+	_, err = io.Copy(io.Discard, resp.Body)
+	req.NoError(err)
+	req.Eventually(func() bool { return quota.Used() == 0 }, time.Second, 10*time.Millisecond)
+}
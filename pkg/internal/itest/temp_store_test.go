@@ -0,0 +1,144 @@
+package itest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempStore(t *testing.T) {
+	// temporary files can't be created in a directory that doesn't exist, so
+	// any use of the default temporary storage fails the fetch
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	// memoryStore counts the stores it creates and closes
+	type memoryStore struct {
+		created atomic.Int32
+		closed  atomic.Int32
+		factory types.TempStoreFactory
+	}
+	newMemoryStore := func() *memoryStore {
+		ms := &memoryStore{}
+		ms.factory = storage.NewBlockstoreTempStoreFactory(func(root cid.Cid) (blockstore.Blockstore, func() error, error) {
+			ms.created.Add(1)
+			bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+			return bs, func() error { ms.closed.Add(1); return nil }, nil
+		})
+		return ms
+	}
+	errFactory := errors.New("no storage for you")
+	failing := func(root cid.Cid) (types.TempStore, error) { return nil, errFactory }
+
+	testCases := []struct {
+		name         string
+		instance     bool
+		request      bool
+		failInstance bool
+		expectErr    error
+	}{
+		{name: "instance", instance: true},
+		{name: "request", request: true},
+		{name: "request overrides instance", instance: true, failInstance: true, request: true},
+		{name: "failing instance", instance: true, failInstance: true, expectErr: errFactory},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			mrn.AddBitswapPeers(1)
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+			instanceStore, requestStore := newMemoryStore(), newMemoryStore()
+			opts := []lassie.LassieOption{
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{multicodec.TransportBitswap}),
+				lassie.WithGlobalTimeout(5 * time.Second),
+			}
+			if testCase.failInstance {
+				opts = append(opts, lassie.WithTempStore(failing))
+			} else if testCase.instance {
+				opts = append(opts, lassie.WithTempStore(instanceStore.factory))
+			}
+			l, err := lassie.NewLassie(ctx, opts...)
+			req.NoError(err)
+
+			var fetchOpts []types.FetchOption
+			if testCase.request {
+				fetchOpts = append(fetchOpts, types.WithTempStore(requestStore.factory))
+			}
+			var buf bytes.Buffer
+			stats, err := l.FetchToWriter(ctx, srcData.Root, "", trustlessutils.DagScopeAll, &buf, fetchOpts...)
+			if testCase.expectErr != nil {
+				req.ErrorIs(err, testCase.expectErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(srcData.SelfCids)), stats.Blocks)
+			reader, err := carstorage.OpenReadable(bytes.NewReader(buf.Bytes()))
+			req.NoError(err)
+			req.Equal(srcData.Root, reader.Roots()[0])
+
+			used := instanceStore
+			if testCase.request {
+				used = requestStore
+			}
+			req.Equal(int32(1), used.created.Load())
+			req.Equal(int32(1), used.closed.Load())
+			if !testCase.instance {
+				return
+			}
+
+			// the HTTP server uses the instance's temporary storage too
+			httpServer, err := httpserver.NewHttpServer(ctx, l, httpserver.HttpServerConfig{Address: "127.0.0.1"})
+			req.NoError(err)
+			go func() { _ = httpServer.Start() }()
+			defer httpServer.Close()
+
+			getReq, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s/ipfs/%s", httpServer.Addr(), srcData.Root), nil)
+			req.NoError(err)
+			getReq.Header.Add("Accept", "application/vnd.ipld.car")
+			resp, err := http.DefaultClient.Do(getReq)
+			req.NoError(err)
+			defer resp.Body.Close()
+			if testCase.failInstance {
+				req.Equal(http.StatusInternalServerError, resp.StatusCode)
+				return
+			}
+			req.Equal(http.StatusOK, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			req.NoError(err)
+			req.Equal(buf.Bytes(), body)
+			req.Equal(int32(2), instanceStore.created.Load())
+		})
+	}
+}
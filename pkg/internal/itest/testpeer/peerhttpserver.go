@@ -84,6 +84,8 @@ func (s *TestPeerHttpServer) Close() error {
 
 func MockIpfsHandler(ctx context.Context, lsys linking.LinkSystem) func(http.ResponseWriter, *http.Request) {
 	return func(res http.ResponseWriter, req *http.Request) {
+		// each request wraps the StorageReadOpener to write to its own response
+		lsys := lsys
 		urlPath := strings.Split(req.URL.Path, "/")[1:]
 
 		// validate CID path parameter
@@ -0,0 +1,189 @@
+package itest
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	httpserver "github.com/filecoin-project/lassie/pkg/server/http"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracesOnce sync.Once
+	traces     *testutil.RecordingTracerProvider
+)
+
+// recordTraces installs a recording tracer provider as the global provider.
+// The tracers lassie holds only delegate to the first provider installed, so
+// it's shared by every test and spans have to be picked out by trace.
+func recordTraces() *testutil.RecordingTracerProvider {
+	tracesOnce.Do(func() {
+		traces = testutil.NewRecordingTracerProvider()
+		otel.SetTracerProvider(traces)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return traces
+}
+
+// fetchSpan finds the Fetch span for a retrieval and the spans in its trace.
+func fetchSpan(t *testing.T, tp *testutil.RecordingTracerProvider, id types.RetrievalID) (*testutil.RecordedSpan, []*testutil.RecordedSpan) {
+	var fetch *testutil.RecordedSpan
+	for _, span := range tp.Named("Fetch") {
+		if v, ok := span.Attribute("retrievalId"); ok && v.AsString() == id.String() {
+			fetch = span
+		}
+	}
+	require.NotNil(t, fetch, "no Fetch span for retrieval")
+	var spans []*testutil.RecordedSpan
+	for _, span := range tp.Spans() {
+		if span.SpanContext().TraceID() == fetch.SpanContext().TraceID() {
+			spans = append(spans, span)
+		}
+	}
+	return fetch, spans
+}
+
+func TestTracing(t *testing.T) {
+	tp := recordTraces()
+
+	testCases := []struct {
+		name     string
+		protocol multicodec.Code
+		setup    func(t *testing.T, mrn *mocknet.MockRetrievalNet)
+	}{
+		{
+			name:     "http",
+			protocol: multicodec.TransportIpfsGatewayHttp,
+			setup:    func(t *testing.T, mrn *mocknet.MockRetrievalNet) { mrn.AddHttpPeers(1) },
+		},
+		{
+			name:     "bitswap",
+			protocol: multicodec.TransportBitswap,
+			setup:    func(t *testing.T, mrn *mocknet.MockRetrievalNet) { mrn.AddBitswapPeers(1) },
+		},
+		{
+			name:     "graphsync",
+			protocol: multicodec.TransportGraphsyncFilecoinv1,
+			setup: func(t *testing.T, mrn *mocknet.MockRetrievalNet) {
+				mrn.AddGraphsyncPeers(1)
+				mocknet.SetupRetrieval(t, mrn.Remotes[0])
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			mrn := mocknet.NewMockRetrievalNet(ctx, t)
+			testCase.setup(t, mrn)
+			req.NoError(mrn.MN.LinkAll())
+			srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+			l, err := lassie.NewLassie(
+				ctx,
+				lassie.WithFinder(mrn.Finder),
+				lassie.WithHost(mrn.Self),
+				lassie.WithProtocols([]multicodec.Code{testCase.protocol}),
+				lassie.WithGlobalTimeout(5*time.Second),
+			)
+			req.NoError(err)
+
+			store := storage.NewDeferredStorageCar(t.TempDir(), srcData.Root)
+			defer store.Close()
+			request, err := types.NewRequestForPath(store, srcData.Root, "", trustlessutils.DagScopeAll, nil)
+			req.NoError(err)
+			request.RetrievalID, err = types.NewRetrievalID()
+			req.NoError(err)
+			_, err = l.Fetch(ctx, request)
+			req.NoError(err)
+
+			fetch, spans := fetchSpan(t, tp, request.RetrievalID)
+			req.False(fetch.ParentID().IsValid())
+			byName := make(map[string]*testutil.RecordedSpan)
+			for _, span := range spans {
+				req.True(span.Ended(), "span %s not ended", span.Name())
+				req.NotEqual(codes.Error, span.Status(), "span %s failed", span.Name())
+				byName[span.Name()] = span
+			}
+			parentOf := func(child, parent string) {
+				req.Contains(byName, child)
+				req.Contains(byName, parent)
+				req.Equal(byName[parent].SpanContext().SpanID(), byName[child].ParentID(), "%s is not a child of %s", child, parent)
+			}
+			parentOf("FindCandidates", "Fetch")
+			parentOf("Retrieve", "Fetch")
+			protocol, ok := byName["Retrieve"].Attribute("protocol")
+			req.True(ok)
+			req.Equal(testCase.protocol.String(), protocol.AsString())
+			// bitswap retrieves from its whole session at once, the others from
+			// each provider in turn
+			if testCase.protocol == multicodec.TransportBitswap {
+				parentOf("Verify", "Retrieve")
+			} else {
+				parentOf("RetrieveFromProvider", "Retrieve")
+				parentOf("Connect", "RetrieveFromProvider")
+				parentOf("Verify", "RetrieveFromProvider")
+			}
+		})
+	}
+}
+
+func TestTracingFromHttpRequest(t *testing.T) {
+	tp := recordTraces()
+	req := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	mrn.AddHttpPeers(1)
+	req.NoError(mrn.MN.LinkAll())
+	srcData := unixfs.GenerateFile(t, mrn.Remotes[0].LinkSystem, rand.New(rand.NewSource(0)), 1<<20)
+
+	l, err := lassie.NewLassie(
+		ctx,
+		lassie.WithFinder(mrn.Finder),
+		lassie.WithHost(mrn.Self),
+		lassie.WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+	)
+	req.NoError(err)
+	handler := httpserver.NewHandler(l, httpserver.HttpServerConfig{TempDir: t.TempDir()})
+
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	req.NoError(err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	req.NoError(err)
+	request := httptest.NewRequest(http.MethodGet, "/ipfs/"+srcData.Root.String(), nil)
+	request.Header.Set("Accept", "application/vnd.ipld.car")
+	request.Header.Set("traceparent", "00-"+traceID.String()+"-"+spanID.String()+"-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request)
+	req.Equal(http.StatusOK, rr.Code)
+
+	var retrievalID types.RetrievalID
+	req.NoError(retrievalID.UnmarshalText([]byte(rr.Header().Get(httpserver.HeaderRetrievalID))))
+	fetch, spans := fetchSpan(t, tp, retrievalID)
+	req.Equal(traceID, fetch.SpanContext().TraceID())
+	req.Equal(spanID, fetch.ParentID())
+	req.Greater(len(spans), 1)
+}
@@ -5,14 +5,14 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/ipfs/go-log/v2"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
-var logger = log.Logger("lassie/lp2p/tspt/client")
+var logger = logging.Subsystem("lassie/lp2p/tspt/client")
 
 // TransportsProtocolID is the protocol for querying which retrieval transports
 // the Storage Provider supports (http, libp2p, etc)
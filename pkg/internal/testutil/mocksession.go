@@ -11,6 +11,7 @@ import (
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -78,9 +79,9 @@ func (ms *MockSession) VerifyMetricsAt(ctx context.Context, t *testing.T, afterS
 	require.ElementsMatch(t, expectedMetrics, metricsReceived)
 }
 
-func (ms *MockSession) GetStorageProviderTimeout(storageProviderId peer.ID) time.Duration {
+func (ms *MockSession) GetStorageProviderTimeout(storageProviderId peer.ID, protocol multicodec.Code) time.Duration {
 	if ms.actual != nil && ms.providerTimeout == 0 {
-		return ms.actual.GetStorageProviderTimeout(storageProviderId)
+		return ms.actual.GetStorageProviderTimeout(storageProviderId, protocol)
 	}
 	return ms.providerTimeout
 }
@@ -93,6 +94,20 @@ func (ms *MockSession) FilterIndexerCandidate(candidate types.RetrievalCandidate
 	return !blocked, candidate
 }
 
+func (ms *MockSession) IsAllowListed(storageProviderId peer.ID) bool {
+	if ms.actual != nil {
+		return ms.actual.IsAllowListed(storageProviderId)
+	}
+	return false
+}
+
+func (ms *MockSession) RecordAllowListedFailure(storageProviderId peer.ID) bool {
+	if ms.actual != nil {
+		return ms.actual.RecordAllowListedFailure(storageProviderId)
+	}
+	return false
+}
+
 func (ms *MockSession) RegisterRetrieval(retrievalId types.RetrievalID, cid cid.Cid, selector datamodel.Node) bool {
 	if ms.actual != nil {
 		return ms.actual.RegisterRetrieval(retrievalId, cid, selector)
@@ -136,32 +151,40 @@ func (ms *MockSession) RecordFirstByteTime(storageProviderId peer.ID, firstByteT
 	})
 }
 
-func (ms *MockSession) RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) error {
+func (ms *MockSession) RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) (time.Duration, error) {
+	var cooldown time.Duration
 	if ms.actual != nil {
-		if err := ms.actual.RecordFailure(retrievalId, storageProviderId); err != nil {
-			return err
+		var err error
+		if cooldown, err = ms.actual.RecordFailure(retrievalId, storageProviderId); err != nil {
+			return 0, err
 		}
 	}
 	ms.addMetric(SessionMetric{
 		Type:     SessionMetric_Failure,
 		Provider: storageProviderId,
 	})
-	return nil
+	return cooldown, nil
 }
 
-func (ms *MockSession) RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) {
+func (ms *MockSession) RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) bool {
+	var closed bool
 	if ms.actual != nil {
-		ms.actual.RecordSuccess(storageProviderId, bandwidthBytesPerSecond)
+		closed = ms.actual.RecordSuccess(storageProviderId, bandwidthBytesPerSecond)
 	}
 	ms.addMetric(SessionMetric{
 		Type:     SessionMetric_Success,
 		Provider: storageProviderId,
 		Value:    float64(bandwidthBytesPerSecond),
 	})
+	return closed
 }
 func (ms *MockSession) ChooseNextProvider(peers []peer.ID, metadata []metadata.Protocol) int {
+	return ms.ChooseNextProviderWithStrategy(peers, metadata, session.StrategyBalanced)
+}
+
+func (ms *MockSession) ChooseNextProviderWithStrategy(peers []peer.ID, metadata []metadata.Protocol, strategy session.Strategy) int {
 	if ms.actual != nil && len(ms.candidatePreferenceOrder) == 0 {
-		return ms.actual.ChooseNextProvider(peers, metadata)
+		return ms.actual.ChooseNextProviderWithStrategy(peers, metadata, strategy)
 	}
 	for _, candidate := range ms.candidatePreferenceOrder {
 		for i, peer := range peers {
@@ -179,3 +202,30 @@ func (ms *MockSession) addMetric(sm SessionMetric) {
 	case ms.metricsCh <- sm:
 	}
 }
+
+func (ms *MockSession) RecordContentSize(cid cid.Cid, selector datamodel.Node, size uint64) {
+	if ms.actual != nil {
+		ms.actual.RecordContentSize(cid, selector, size)
+	}
+}
+
+func (ms *MockSession) ChooseStrategy(cid cid.Cid, selector datamodel.Node, expectedSize uint64) session.Strategy {
+	if ms.actual != nil {
+		return ms.actual.ChooseStrategy(cid, selector, expectedSize)
+	}
+	return session.StrategyBalanced
+}
+
+func (ms *MockSession) RecordAffinity(key string, storageProviderId peer.ID) bool {
+	if ms.actual != nil {
+		return ms.actual.RecordAffinity(key, storageProviderId)
+	}
+	return false
+}
+
+func (ms *MockSession) ChooseAffinityProvider(key string, peers []peer.ID) (int, bool) {
+	if ms.actual != nil {
+		return ms.actual.ChooseAffinityProvider(key, peers)
+	}
+	return 0, false
+}
@@ -0,0 +1,179 @@
+package testutil
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ trace.TracerProvider = (*RecordingTracerProvider)(nil)
+
+// RecordingTracerProvider is a minimal OpenTelemetry TracerProvider that keeps
+// every span started with it, so tests can check the spans a retrieval
+// produces without an SDK and exporter.
+type RecordingTracerProvider struct {
+	lk     sync.Mutex
+	nextID uint64
+	spans  []*RecordedSpan
+}
+
+func NewRecordingTracerProvider() *RecordingTracerProvider {
+	return &RecordingTracerProvider{}
+}
+
+func (tp *RecordingTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{tp}
+}
+
+// Spans returns the spans started so far, in the order they were started.
+func (tp *RecordingTracerProvider) Spans() []*RecordedSpan {
+	tp.lk.Lock()
+	defer tp.lk.Unlock()
+	return append([]*RecordedSpan{}, tp.spans...)
+}
+
+// Named returns the spans started so far with the given name.
+func (tp *RecordingTracerProvider) Named(name string) []*RecordedSpan {
+	var spans []*RecordedSpan
+	for _, span := range tp.Spans() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+type recordingTracer struct {
+	tp *RecordingTracerProvider
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+
+	t.tp.lk.Lock()
+	t.tp.nextID++
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], t.tp.nextID)
+	traceID := parent.TraceID()
+	if !parent.IsValid() {
+		binary.BigEndian.PutUint64(traceID[8:], t.tp.nextID)
+	}
+	span := &RecordedSpan{
+		tp:       t.tp,
+		name:     name,
+		parentID: parent.SpanID(),
+		attrs:    append([]attribute.KeyValue{}, cfg.Attributes()...),
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.FlagsSampled,
+		}),
+	}
+	t.tp.spans = append(t.tp.spans, span)
+	t.tp.lk.Unlock()
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// RecordedSpan is a span started with a RecordingTracerProvider.
+type RecordedSpan struct {
+	tp       *RecordingTracerProvider
+	sc       trace.SpanContext
+	parentID trace.SpanID
+
+	lk     sync.Mutex
+	name   string
+	attrs  []attribute.KeyValue
+	status codes.Code
+	errs   []error
+	ended  bool
+}
+
+var _ trace.Span = (*RecordedSpan)(nil)
+
+func (s *RecordedSpan) End(...trace.SpanEndOption) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.ended = true
+}
+
+func (s *RecordedSpan) AddEvent(string, ...trace.EventOption) {}
+
+func (s *RecordedSpan) IsRecording() bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return !s.ended
+}
+
+func (s *RecordedSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *RecordedSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func (s *RecordedSpan) SetStatus(code codes.Code, _ string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.status = code
+}
+
+func (s *RecordedSpan) SetName(name string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.name = name
+}
+
+func (s *RecordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.attrs = append(s.attrs, kv...)
+}
+
+func (s *RecordedSpan) TracerProvider() trace.TracerProvider {
+	return s.tp
+}
+
+func (s *RecordedSpan) Name() string {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.name
+}
+
+// ParentID is the ID of the span this span was started within, which is
+// invalid for a root span.
+func (s *RecordedSpan) ParentID() trace.SpanID {
+	return s.parentID
+}
+
+// Attribute returns the last value set for the given attribute key.
+func (s *RecordedSpan) Attribute(key string) (attribute.Value, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for i := len(s.attrs) - 1; i >= 0; i-- {
+		if string(s.attrs[i].Key) == key {
+			return s.attrs[i].Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func (s *RecordedSpan) Status() codes.Code {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.status
+}
+
+func (s *RecordedSpan) Ended() bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.ended
+}
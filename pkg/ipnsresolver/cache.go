@@ -0,0 +1,146 @@
+package ipnsresolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// revalidateTimeout bounds a background revalidation, which isn't tied to the
+// request that triggered it.
+const revalidateTimeout = time.Minute
+
+// NameResolver resolves an IPNS name to the root CID and path that it points
+// to, as Resolver does.
+type NameResolver interface {
+	Resolve(ctx context.Context, name string) (cid.Cid, datamodel.Path, error)
+}
+
+var _ NameResolver = (*Resolver)(nil)
+var _ NameResolver = (*CachingResolver)(nil)
+
+// CachingResolver caches the resolutions of a NameResolver with
+// stale-while-revalidate semantics:
+//
+//   - a resolution younger than maxAge is returned as is
+//   - a resolution older than maxAge, but by no more than maxStale, is
+//     returned immediately while the name is resolved again in the background
+//     to update the cache
+//   - otherwise the name is resolved again before returning
+//
+// A failed background resolution leaves the cached resolution in place, to be
+// served until it is older than maxAge plus maxStale.
+type CachingResolver struct {
+	resolver NameResolver
+	maxAge   time.Duration
+	maxStale time.Duration
+	clock    clock.Clock
+
+	lk      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	root         cid.Cid
+	path         datamodel.Path
+	resolvedAt   time.Time
+	revalidating bool
+}
+
+// NewCachingResolver creates a new CachingResolver, caching the resolutions
+// of the given resolver within the given staleness bounds.
+func NewCachingResolver(resolver NameResolver, maxAge time.Duration, maxStale time.Duration) *CachingResolver {
+	return &CachingResolver{
+		resolver: resolver,
+		maxAge:   maxAge,
+		maxStale: maxStale,
+		clock:    clock.New(),
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+// Resolve resolves an IPNS name to the root CID and path that it points to,
+// from the cache where it is fresh enough.
+func (cr *CachingResolver) Resolve(ctx context.Context, name string) (cid.Cid, datamodel.Path, error) {
+	cr.lk.Lock()
+	if entry, ok := cr.entries[name]; ok {
+		age := cr.clock.Since(entry.resolvedAt)
+		switch {
+		case age < cr.maxAge:
+			cr.lk.Unlock()
+			return entry.root, entry.path, nil
+		case age < cr.maxAge+cr.maxStale:
+			if !entry.revalidating {
+				entry.revalidating = true
+				go cr.revalidate(name)
+			}
+			cr.lk.Unlock()
+			return entry.root, entry.path, nil
+		default:
+			delete(cr.entries, name)
+		}
+	}
+	cr.lk.Unlock()
+
+	root, path, err := cr.resolver.Resolve(ctx, name)
+	if err != nil {
+		return cid.Undef, datamodel.Path{}, err
+	}
+	cr.store(name, root, path)
+	return root, path, nil
+}
+
+func (cr *CachingResolver) revalidate(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	defer cancel()
+
+	root, path, err := cr.resolver.Resolve(ctx, name)
+	if err != nil {
+		logger.Debugw("failed to revalidate IPNS name", "name", name, "err", err)
+		cr.lk.Lock()
+		if entry, ok := cr.entries[name]; ok {
+			entry.revalidating = false
+		}
+		cr.lk.Unlock()
+		return
+	}
+	cr.store(name, root, path)
+}
+
+func (cr *CachingResolver) store(name string, root cid.Cid, path datamodel.Path) {
+	if cr.maxAge+cr.maxStale <= 0 {
+		return
+	}
+	cr.lk.Lock()
+	defer cr.lk.Unlock()
+	now := cr.clock.Now()
+	// drop the resolutions too old to be served, so that names that are no
+	// longer requested don't accumulate
+	for n, entry := range cr.entries {
+		if now.Sub(entry.resolvedAt) >= cr.maxAge+cr.maxStale {
+			delete(cr.entries, n)
+		}
+	}
+	cr.entries[name] = &cacheEntry{root: root, path: path, resolvedAt: now}
+}
+
+// Len returns the number of resolutions cached.
+func (cr *CachingResolver) Len() int {
+	cr.lk.Lock()
+	defer cr.lk.Unlock()
+	return len(cr.entries)
+}
+
+// Clear drops every cached resolution, so that each name is resolved again
+// when next requested, returning the number dropped.
+func (cr *CachingResolver) Clear() int {
+	cr.lk.Lock()
+	defer cr.lk.Unlock()
+	n := len(cr.entries)
+	cr.entries = make(map[string]*cacheEntry)
+	return n
+}
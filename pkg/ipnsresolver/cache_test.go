@@ -0,0 +1,107 @@
+package ipnsresolver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/stretchr/testify/require"
+)
+
+// mockNameResolver resolves names to its current root, counting resolutions.
+type mockNameResolver struct {
+	lk    sync.Mutex
+	root  cid.Cid
+	err   error
+	calls int
+}
+
+func (mr *mockNameResolver) Resolve(ctx context.Context, name string) (cid.Cid, datamodel.Path, error) {
+	mr.lk.Lock()
+	defer mr.lk.Unlock()
+	mr.calls++
+	if mr.err != nil {
+		return cid.Undef, datamodel.Path{}, mr.err
+	}
+	return mr.root, datamodel.ParsePath("a/b"), nil
+}
+
+func (mr *mockNameResolver) set(root cid.Cid, err error) {
+	mr.lk.Lock()
+	defer mr.lk.Unlock()
+	mr.root, mr.err = root, err
+}
+
+func (mr *mockNameResolver) resolutions() int {
+	mr.lk.Lock()
+	defer mr.lk.Unlock()
+	return mr.calls
+}
+
+func TestCachingResolver(t *testing.T) {
+	ctx := context.Background()
+	cid1 := testutil.GenerateCid()
+	cid2 := testutil.GenerateCid()
+	cid3 := testutil.GenerateCid()
+
+	mock := &mockNameResolver{root: cid1}
+	clock := clock.NewMock()
+	resolver := NewCachingResolver(mock, time.Minute, time.Hour)
+	resolver.clock = clock
+
+	resolve := func(expected cid.Cid) {
+		root, path, err := resolver.Resolve(ctx, "name")
+		require.NoError(t, err)
+		require.Equal(t, expected, root)
+		require.Equal(t, "a/b", path.String())
+	}
+
+	// the first resolution is cached and reused while fresh
+	resolve(cid1)
+	mock.set(cid2, nil)
+	clock.Add(30 * time.Second)
+	resolve(cid1)
+	require.Equal(t, 1, mock.resolutions())
+
+	// once stale, the cached resolution is served while the name is
+	// revalidated in the background, only once at a time
+	clock.Add(time.Minute)
+	resolve(cid1)
+	require.Eventually(t, func() bool {
+		root, _, err := resolver.Resolve(ctx, "name")
+		return err == nil && root == cid2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, mock.resolutions())
+
+	// a failed revalidation leaves the stale resolution in place
+	mock.set(cid3, errors.New("unavailable"))
+	clock.Add(2 * time.Minute)
+	resolve(cid2)
+	require.Eventually(t, func() bool { return mock.resolutions() == 3 }, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		resolver.lk.Lock()
+		defer resolver.lk.Unlock()
+		return !resolver.entries["name"].revalidating
+	}, time.Second, 10*time.Millisecond)
+
+	// beyond the staleness bound, the name is resolved before returning
+	clock.Add(2 * time.Hour)
+	_, _, err := resolver.Resolve(ctx, "name")
+	require.ErrorContains(t, err, "unavailable")
+	mock.set(cid3, nil)
+	resolve(cid3)
+	require.Equal(t, 5, mock.resolutions())
+
+	// clearing drops every cached resolution
+	require.Equal(t, 1, resolver.Len())
+	require.Equal(t, 1, resolver.Clear())
+	require.Equal(t, 0, resolver.Len())
+	resolve(cid3)
+	require.Equal(t, 6, mock.resolutions())
+}
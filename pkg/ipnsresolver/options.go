@@ -0,0 +1,124 @@
+package ipnsresolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type (
+	Option  func(*options) error
+	options struct {
+		gateways          []*url.URL
+		httpClient        *http.Client
+		httpClientTimeout time.Duration
+		httpUserAgent     string
+		txtResolver       TXTResolver
+		maxDepth          int
+	}
+
+	// TXTResolver looks up the TXT records of a domain, as net.Resolver does.
+	TXTResolver interface {
+		LookupTXT(ctx context.Context, name string) ([]string, error)
+	}
+)
+
+// DefaultMaxDepth is the default maximum number of names followed when
+// resolving a name, see WithMaxDepth.
+const DefaultMaxDepth = 32
+
+func newOptions(o ...Option) (*options, error) {
+	const defaultGateway = "https://trustless-gateway.link"
+	opts := options{
+		httpClient:        http.DefaultClient,
+		httpClientTimeout: 30 * time.Second,
+		httpUserAgent:     "lassie",
+		txtResolver:       net.DefaultResolver,
+		maxDepth:          DefaultMaxDepth,
+	}
+	for _, apply := range o {
+		if err := apply(&opts); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.gateways) == 0 {
+		gw, err := url.Parse(defaultGateway)
+		if err != nil {
+			return nil, err
+		}
+		opts.gateways = []*url.URL{gw}
+	}
+	return &opts, nil
+}
+
+// WithGateways sets the trustless gateways that IPNS records are fetched from.
+// Records are requested from all gateways and the valid record with the
+// highest sequence number is used.
+// Defaults to https://trustless-gateway.link if unspecified.
+func WithGateways(gateways ...*url.URL) Option {
+	return func(o *options) error {
+		for _, gw := range gateways {
+			if gw == nil || gw.Host == "" {
+				return errors.New("invalid gateway URL")
+			}
+		}
+		o.gateways = gateways
+		return nil
+	}
+}
+
+// WithHttpClient sets the http.Client used to contact the gateways.
+// Defaults to http.DefaultClient if unspecified.
+func WithHttpClient(c *http.Client) Option {
+	return func(o *options) error {
+		o.httpClient = c
+		return nil
+	}
+}
+
+// WithHttpClientTimeout sets the timeout for each request for an IPNS record.
+// Defaults to 30 seconds if unspecified.
+func WithHttpClientTimeout(t time.Duration) Option {
+	return func(o *options) error {
+		o.httpClientTimeout = t
+		return nil
+	}
+}
+
+// WithHttpUserAgent sets the User-Agent header value when contacting the
+// gateways. Setting this option to empty string will disable inclusion of
+// User-Agent header.
+// Defaults to "lassie" if unspecified.
+func WithHttpUserAgent(ua string) Option {
+	return func(o *options) error {
+		o.httpUserAgent = ua
+		return nil
+	}
+}
+
+// WithTXTResolver sets the resolver used to look up the DNSLink TXT records of
+// domains.
+// Defaults to net.DefaultResolver if unspecified.
+func WithTXTResolver(resolver TXTResolver) Option {
+	return func(o *options) error {
+		o.txtResolver = resolver
+		return nil
+	}
+}
+
+// WithMaxDepth sets the maximum number of names, IPNS names or DNSLink
+// domains, that are resolved in a chain before a name resolves to a CID, so
+// that long or recursive chains of names can't hold up the resolver.
+// Defaults to DefaultMaxDepth if unspecified.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) error {
+		if depth < 1 {
+			return errors.New("maximum depth must be at least 1")
+		}
+		o.maxDepth = depth
+		return nil
+	}
+}
@@ -0,0 +1,300 @@
+package ipnsresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/contentpath"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"go.uber.org/multierr"
+)
+
+var logger = logging.Subsystem("lassie/ipnsresolver")
+
+const MimeTypeIpnsRecord = "application/vnd.ipfs.ipns-record"
+
+var (
+	ErrNoRecord         = errors.New("no valid IPNS record found")
+	ErrStaleRecord      = errors.New("IPNS record sequence is older than a previously seen record")
+	ErrUnsupportedValue = errors.New("unsupported IPNS record value")
+	ErrNoDNSLink        = errors.New("no DNSLink TXT record found")
+	ErrInvalidName      = errors.New("not an IPNS name or DNSLink domain")
+	// ErrCycle and ErrMaxDepth are the reasons of a ChainError.
+	ErrCycle    = errors.New("name resolution cycle")
+	ErrMaxDepth = errors.New("name resolution exceeded the maximum depth")
+)
+
+// Hop is a single step in the resolution of a name, from an IPNS name or
+// DNSLink domain to the /ipfs/ or /ipns/ path it points to.
+type Hop struct {
+	Name  string
+	Value string
+	// TTL is how long the IPNS record may be cached for, zero for DNSLink as
+	// the system resolver doesn't report the TTL of TXT records.
+	TTL time.Duration
+}
+
+// ChainError is returned when a chain of names loops back on itself, wrapping
+// ErrCycle, or is longer than the maximum depth, wrapping ErrMaxDepth. It holds
+// the hops resolved before resolution stopped.
+type ChainError struct {
+	Err  error
+	Hops []Hop
+}
+
+func (e *ChainError) Error() string {
+	names := make([]string, 0, len(e.Hops))
+	for _, hop := range e.Hops {
+		names = append(names, hop.Name)
+	}
+	return fmt.Sprintf("%s: %s", e.Err, strings.Join(names, " -> "))
+}
+
+func (e *ChainError) Unwrap() error { return e.Err }
+
+// Resolver fetches signed IPNS records from trustless gateways using the
+// application/vnd.ipfs.ipns-record response format and validates them locally,
+// so the gateways don't need to be trusted for name resolution.
+//
+// The highest sequence number seen for each name is remembered and records
+// with a lower sequence number are rejected, preventing a gateway from rolling
+// a name back to an older value.
+//
+// A name may point to another IPNS name, or a DNSLink domain, which are
+// followed until a CID is reached, up to the maximum depth, see WithMaxDepth.
+type Resolver struct {
+	*options
+
+	lk          sync.Mutex
+	sequences   map[string]uint64
+	subscribers []*types.RetrievalEventSubscriber
+}
+
+// NewResolver creates a new Resolver with the given options.
+func NewResolver(o ...Option) (*Resolver, error) {
+	opts, err := newOptions(o...)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{
+		options:   opts,
+		sequences: make(map[string]uint64),
+	}, nil
+}
+
+// RegisterSubscriber registers a subscriber to receive an
+// events.NameResolvedEvent for each hop of each resolution. The returned
+// function can be called to unregister the subscriber.
+func (r *Resolver) RegisterSubscriber(subscriber types.RetrievalEventSubscriber) func() {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	sub := &subscriber
+	r.subscribers = append(r.subscribers, sub)
+	return func() {
+		r.lk.Lock()
+		defer r.lk.Unlock()
+		for i, s := range r.subscribers {
+			if s == sub {
+				r.subscribers = append(r.subscribers[:i:i], r.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Resolve resolves an IPNS name, in either peer ID or CID form, or a DNSLink
+// domain, to the root CID and path that it points to, following any further
+// names it points to. A chain of names that loops or is longer than the
+// maximum depth fails with a *ChainError.
+func (r *Resolver) Resolve(ctx context.Context, name string) (cid.Cid, datamodel.Path, error) {
+	var hops []Hop
+	var rest datamodel.Path
+	seen := make(map[string]struct{})
+	for {
+		if _, ok := seen[canonicalName(name)]; ok {
+			return cid.Undef, datamodel.Path{}, &ChainError{Err: ErrCycle, Hops: hops}
+		}
+		if len(hops) >= r.maxDepth {
+			return cid.Undef, datamodel.Path{}, &ChainError{Err: ErrMaxDepth, Hops: hops}
+		}
+		seen[canonicalName(name)] = struct{}{}
+
+		hop, err := r.resolveOnce(ctx, name)
+		if err != nil {
+			if len(hops) > 0 {
+				return cid.Undef, datamodel.Path{}, fmt.Errorf("failed to resolve %s after %d hops: %w", name, len(hops), err)
+			}
+			return cid.Undef, datamodel.Path{}, err
+		}
+		hops = append(hops, hop)
+		p, err := contentpath.ParsePath(hop.Value)
+		if err != nil {
+			return cid.Undef, datamodel.Path{}, fmt.Errorf("%w: %s: %v", ErrUnsupportedValue, hop.Value, err)
+		}
+		logger.Debugw("resolved name", "name", hop.Name, "value", hop.Value, "ttl", hop.TTL, "depth", len(hops))
+		r.dispatch(events.NameResolved(time.Now(), hop.Name, hop.Value, p.Root, hop.TTL, len(hops)))
+
+		rest = p.Path.Join(rest)
+		if p.Namespace == contentpath.NamespaceIPFS {
+			return p.Root, rest, nil
+		}
+		name = p.Name
+	}
+}
+
+// resolveOnce resolves a single IPNS name or DNSLink domain, without following
+// the name it may point to.
+func (r *Resolver) resolveOnce(ctx context.Context, name string) (Hop, error) {
+	if IsDNSLinkName(name) {
+		value, err := r.lookupDNSLink(ctx, name)
+		if err != nil {
+			return Hop{}, err
+		}
+		return Hop{Name: name, Value: value}, nil
+	}
+
+	ipnsName, err := ipns.NameFromString(name)
+	if err != nil {
+		return Hop{}, fmt.Errorf("%w: %s: %v", ErrInvalidName, name, err)
+	}
+	record, err := r.FetchRecord(ctx, ipnsName)
+	if err != nil {
+		return Hop{}, err
+	}
+	value, err := record.Value()
+	if err != nil {
+		return Hop{}, err
+	}
+	ttl, err := record.TTL()
+	if err != nil {
+		ttl = 0
+	}
+	return Hop{Name: name, Value: value.String(), TTL: ttl}, nil
+}
+
+// lookupDNSLink returns the /ipfs/ or /ipns/ path of the domain's DNSLink,
+// from the dnslink= TXT record of its _dnslink subdomain.
+func (r *Resolver) lookupDNSLink(ctx context.Context, domain string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.httpClientTimeout)
+	defer cancel()
+
+	txts, err := r.txtResolver.LookupTXT(ctx, "_dnslink."+domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrNoDNSLink, domain, err)
+	}
+	for _, txt := range txts {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(txt), "dnslink="); ok {
+			if strings.HasPrefix(value, "/ipfs/") || strings.HasPrefix(value, "/ipns/") {
+				return value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNoDNSLink, domain)
+}
+
+func (r *Resolver) dispatch(event types.RetrievalEvent) {
+	r.lk.Lock()
+	subscribers := append([]*types.RetrievalEventSubscriber(nil), r.subscribers...)
+	r.lk.Unlock()
+	for _, subscriber := range subscribers {
+		(*subscriber)(event)
+	}
+}
+
+// canonicalName returns a single form of a name that may be written several
+// ways, an IPNS name as a peer ID or CID, or a domain in any case, so that a
+// cycle through different forms of a name is detected.
+func canonicalName(name string) string {
+	if IsDNSLinkName(name) {
+		return strings.ToLower(strings.TrimSuffix(name, "."))
+	}
+	if ipnsName, err := ipns.NameFromString(name); err == nil {
+		return ipnsName.String()
+	}
+	return name
+}
+
+// IsDNSLinkName returns true if the name is a domain, which are resolved with
+// DNSLink rather than as IPNS names. IPNS names never contain a '.'.
+func IsDNSLinkName(name string) bool {
+	return strings.Contains(name, ".")
+}
+
+// FetchRecord fetches the IPNS record for the given name from each of the
+// configured gateways, returning the valid record with the highest sequence
+// number. Records are validated against the name, which checks the signature
+// and that the record has not expired.
+func (r *Resolver) FetchRecord(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	var best *ipns.Record
+	var bestSeq uint64
+	var errs error
+	for _, gateway := range r.gateways {
+		record, err := r.fetchFromGateway(ctx, gateway.String(), name)
+		if err == nil {
+			err = ipns.ValidateWithName(record, name)
+		}
+		var seq uint64
+		if err == nil {
+			seq, err = record.Sequence()
+		}
+		if err != nil {
+			logger.Debugw("failed to fetch IPNS record", "name", name.String(), "gateway", gateway.String(), "err", err)
+			errs = multierr.Append(errs, fmt.Errorf("%s: %w", gateway.String(), err))
+			continue
+		}
+		if best == nil || seq > bestSeq {
+			best, bestSeq = record, seq
+		}
+	}
+	if best == nil {
+		return nil, multierr.Combine(ErrNoRecord, errs)
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if seen, ok := r.sequences[name.String()]; ok && bestSeq < seen {
+		return nil, fmt.Errorf("%w: %d < %d", ErrStaleRecord, bestSeq, seen)
+	}
+	r.sequences[name.String()] = bestSeq
+	return best, nil
+}
+
+func (r *Resolver) fetchFromGateway(ctx context.Context, gateway string, name ipns.Name) (*ipns.Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.httpClientTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(gateway, "/")+"/ipns/"+name.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MimeTypeIpnsRecord)
+	if r.httpUserAgent != "" {
+		req.Header.Set("User-Agent", r.httpUserAgent)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %d", resp.StatusCode)
+	}
+	// records larger than the maximum are invalid, read one more byte so
+	// UnmarshalRecord can reject them
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(ipns.MaxRecordSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	return ipns.UnmarshalRecord(data)
+}
@@ -0,0 +1,290 @@
+package ipnsresolver_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	otherKey, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	name := ipns.NameFromPeer(pid)
+
+	cid1 := testutil.GenerateCid()
+	cid2 := testutil.GenerateCid()
+	mkRecord := func(key crypto.PrivKey, value path.Path, seq uint64, eol time.Time) []byte {
+		rec, err := ipns.NewRecord(key, value, seq, eol, time.Minute)
+		require.NoError(t, err)
+		byts, err := ipns.MarshalRecord(rec)
+		require.NoError(t, err)
+		return byts
+	}
+	valid1 := mkRecord(key, path.FromString("/ipfs/"+cid1.String()+"/a/b"), 1, time.Now().Add(time.Hour))
+	valid2 := mkRecord(key, path.FromCid(cid2), 2, time.Now().Add(time.Hour))
+	wrongKey := mkRecord(otherKey, path.FromCid(cid2), 3, time.Now().Add(time.Hour))
+	expired := mkRecord(key, path.FromCid(cid2), 4, time.Now().Add(-time.Hour))
+
+	mkGateway := func(record []byte) *url.URL {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			require.Equal(t, "/ipns/"+name.String(), req.URL.Path)
+			require.Equal(t, ipnsresolver.MimeTypeIpnsRecord, req.Header.Get("Accept"))
+			if record == nil {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			res.Header().Set("Content-Type", ipnsresolver.MimeTypeIpnsRecord)
+			_, _ = res.Write(record)
+		}))
+		t.Cleanup(server.Close)
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		return u
+	}
+
+	testCases := []struct {
+		name         string
+		gateways     [][]byte
+		expectedRoot string
+		expectedPath string
+		expectErr    error
+	}{
+		{
+			name:         "single gateway",
+			gateways:     [][]byte{valid1},
+			expectedRoot: cid1.String(),
+			expectedPath: "a/b",
+		},
+		{
+			name:         "highest sequence wins",
+			gateways:     [][]byte{valid1, valid2, nil},
+			expectedRoot: cid2.String(),
+		},
+		{
+			name:         "invalid records ignored",
+			gateways:     [][]byte{wrongKey, expired, valid1},
+			expectedRoot: cid1.String(),
+			expectedPath: "a/b",
+		},
+		{
+			name:      "no valid records",
+			gateways:  [][]byte{wrongKey, expired, nil},
+			expectErr: ipnsresolver.ErrNoRecord,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gateways := make([]*url.URL, 0, len(tc.gateways))
+			for _, record := range tc.gateways {
+				gateways = append(gateways, mkGateway(record))
+			}
+			resolver, err := ipnsresolver.NewResolver(ipnsresolver.WithGateways(gateways...))
+			require.NoError(t, err)
+			root, path, err := resolver.Resolve(ctx, name.String())
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRoot, root.String())
+			require.Equal(t, tc.expectedPath, path.String())
+		})
+	}
+
+	t.Run("rejects rollback", func(t *testing.T) {
+		record := valid2
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			_, _ = res.Write(record)
+		}))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		resolver, err := ipnsresolver.NewResolver(ipnsresolver.WithGateways(u))
+		require.NoError(t, err)
+		root, _, err := resolver.Resolve(ctx, name.String())
+		require.NoError(t, err)
+		require.Equal(t, cid2, root)
+		record = valid1
+		_, _, err = resolver.Resolve(ctx, name.String())
+		require.ErrorIs(t, err, ipnsresolver.ErrStaleRecord)
+	})
+}
+
+type txtRecords map[string][]string
+
+func (txt txtRecords) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := txt[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func TestResolverChains(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	names := make([]ipns.Name, 3)
+	keys := make([]crypto.PrivKey, 3)
+	for i := range names {
+		key, _, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		pid, err := peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+		names[i], keys[i] = ipns.NameFromPeer(pid), key
+	}
+	root := testutil.GenerateCid()
+
+	records := make(map[string][]byte)
+	setRecord := func(i int, value string) {
+		rec, err := ipns.NewRecord(keys[i], path.FromString(value), 1, time.Now().Add(time.Hour), time.Duration(i+1)*time.Minute)
+		require.NoError(t, err)
+		records[names[i].String()], err = ipns.MarshalRecord(rec)
+		require.NoError(t, err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		record, ok := records[strings.TrimPrefix(req.URL.Path, "/ipns/")]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = res.Write(record)
+	}))
+	defer server.Close()
+	gateway, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	dns := txtRecords{
+		"_dnslink.example.com":  {"v=spf1 -all", "dnslink=/ipfs/" + root.String() + "/c"},
+		"_dnslink.loop.example": {"dnslink=/ipns/" + names[2].String()},
+	}
+
+	testCases := []struct {
+		name         string
+		values       []string
+		maxDepth     int
+		resolve      string
+		expectedPath string
+		expectedHops []string
+		expectErr    error
+	}{
+		{
+			name:         "ipns to ipns to dnslink",
+			values:       []string{"/ipns/" + names[1].String() + "/b", "/ipns/example.com"},
+			resolve:      names[0].String(),
+			expectedPath: "c/b",
+			expectedHops: []string{names[0].String(), names[1].String(), "example.com"},
+		},
+		{
+			name:         "dnslink",
+			resolve:      "example.com",
+			expectedPath: "c",
+			expectedHops: []string{"example.com"},
+		},
+		{
+			name:         "within max depth",
+			values:       []string{"/ipns/" + names[1].String(), "/ipfs/" + root.String()},
+			maxDepth:     2,
+			resolve:      names[0].String(),
+			expectedHops: []string{names[0].String(), names[1].String()},
+		},
+		{
+			name:         "beyond max depth",
+			values:       []string{"/ipns/" + names[1].String(), "/ipns/example.com"},
+			maxDepth:     2,
+			resolve:      names[0].String(),
+			expectedHops: []string{names[0].String(), names[1].String()},
+			expectErr:    ipnsresolver.ErrMaxDepth,
+		},
+		{
+			name:         "cycle",
+			values:       []string{"/ipns/" + names[1].String(), "/ipns/" + names[0].String()},
+			resolve:      names[0].String(),
+			expectedHops: []string{names[0].String(), names[1].String()},
+			expectErr:    ipnsresolver.ErrCycle,
+		},
+		{
+			name:         "cycle through dnslink",
+			values:       []string{"", "", "/ipns/LOOP.example."},
+			resolve:      "loop.example",
+			expectedHops: []string{"loop.example", names[2].String()},
+			expectErr:    ipnsresolver.ErrCycle,
+		},
+		{
+			name:         "missing dnslink",
+			values:       []string{"/ipns/missing.example"},
+			resolve:      names[0].String(),
+			expectedHops: []string{names[0].String()},
+			expectErr:    ipnsresolver.ErrNoDNSLink,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for name := range records {
+				delete(records, name)
+			}
+			for i, value := range tc.values {
+				if value != "" {
+					setRecord(i, value)
+				}
+			}
+			opts := []ipnsresolver.Option{ipnsresolver.WithGateways(gateway), ipnsresolver.WithTXTResolver(dns)}
+			if tc.maxDepth > 0 {
+				opts = append(opts, ipnsresolver.WithMaxDepth(tc.maxDepth))
+			}
+			resolver, err := ipnsresolver.NewResolver(opts...)
+			require.NoError(t, err)
+			var hops []events.NameResolvedEvent
+			unregister := resolver.RegisterSubscriber(events.TypedSubscriber(func(event events.NameResolvedEvent) {
+				hops = append(hops, event)
+			}))
+			defer unregister()
+
+			resolved, p, err := resolver.Resolve(ctx, tc.resolve)
+			hopNames := make([]string, 0, len(hops))
+			for i, hop := range hops {
+				require.Equal(t, i+1, hop.Depth())
+				hopNames = append(hopNames, hop.Name())
+			}
+			require.Equal(t, tc.expectedHops, hopNames)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				var chainErr *ipnsresolver.ChainError
+				if errors.As(err, &chainErr) {
+					require.Len(t, chainErr.Hops, len(tc.expectedHops))
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, root, resolved)
+			require.Equal(t, tc.expectedPath, p.String())
+			last := hops[len(hops)-1]
+			require.Equal(t, root, last.RootCid())
+			if len(hops) > 1 {
+				require.Equal(t, time.Minute, hops[0].TTL())
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package lassie
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// DefaultAddrBackfillLookups and DefaultAddrBackfillTimeout are the budget of
+// address lookups made for each retrieval, see WithAddrBackfill.
+const (
+	DefaultAddrBackfillLookups = 16
+	DefaultAddrBackfillTimeout = 2 * time.Second
+)
+
+// addrBackfill fills in the defaults of the configured AddrBackfill, looking
+// up addresses in the libp2p host's peerstore before any configured Router.
+func (cfg *LassieConfig) addrBackfill(h *lazyHost) retriever.AddrBackfill {
+	backfill := cfg.AddrBackfill
+	if backfill.MaxLookups == 0 {
+		backfill.MaxLookups = DefaultAddrBackfillLookups
+	}
+	if backfill.Timeout == 0 {
+		backfill.Timeout = DefaultAddrBackfillTimeout
+	}
+	routers := peerRouters{peerstoreRouting{h}}
+	if backfill.Router != nil {
+		routers = append(routers, backfill.Router)
+	}
+	backfill.Router = routers
+	return backfill
+}
+
+// peerstoreRouting finds the addresses of peers in the peerstore of the libp2p
+// host, which knows the peers it has connected to, if it has been started.
+type peerstoreRouting struct {
+	h *lazyHost
+}
+
+func (pr peerstoreRouting) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	h := pr.h.Started()
+	if h == nil {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	addrs := h.Peerstore().Addrs(id)
+	if len(addrs) == 0 {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	return peer.AddrInfo{ID: id, Addrs: addrs}, nil
+}
+
+// peerRouters tries each PeerRouting in turn until one finds the peer's
+// addresses.
+type peerRouters []routing.PeerRouting
+
+func (prs peerRouters) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	lastErr := routing.ErrNotFound
+	for _, pr := range prs {
+		addrInfo, err := pr.FindPeer(ctx, id)
+		if err == nil && len(addrInfo.Addrs) > 0 {
+			return addrInfo, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return peer.AddrInfo{}, lastErr
+}
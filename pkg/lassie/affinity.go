@@ -0,0 +1,51 @@
+package lassie
+
+import (
+	"sync/atomic"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+// AffinityStats counts the successful retrievals with an affinity key, see
+// types.WithAffinityKey, that were served by a single provider, and how many
+// of those were served by a provider that had served an earlier retrieval
+// with the same key.
+type AffinityStats struct {
+	Retrievals uint64
+	Hits       uint64
+}
+
+// HitRate returns the fraction of retrievals that were affinity hits, or zero
+// if there were none.
+func (as AffinityStats) HitRate() float64 {
+	if as.Retrievals == 0 {
+		return 0
+	}
+	return float64(as.Hits) / float64(as.Retrievals)
+}
+
+type affinityCounter struct {
+	retrievals atomic.Uint64
+	hits       atomic.Uint64
+}
+
+// record counts a successful retrieval if it had an affinity key and was
+// served by a single provider.
+func (ac *affinityCounter) record(request types.RetrievalRequest, stats *types.RetrievalStats) {
+	if request.AffinityKey == "" || stats == nil || stats.StorageProviderId == "" {
+		return
+	}
+	ac.retrievals.Add(1)
+	if stats.AffinityHit {
+		ac.hits.Add(1)
+	}
+}
+
+// AffinityStats returns the affinity hits of the retrievals made by this
+// instance, see types.WithAffinityKey.
+func (l *Lassie) AffinityStats() AffinityStats {
+	return AffinityStats{
+		Retrievals: l.affinity.retrievals.Load(),
+		Hits:       l.affinity.hits.Load(),
+	}
+}
@@ -0,0 +1,179 @@
+package lassie
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/multiformats/go-multihash"
+)
+
+var errNotCached = errors.New("block not cached")
+
+// ErrNoBlockCache is returned by ExportBlockCache and ImportBlockCache when
+// Lassie isn't configured with a block cache, see WithBlockCache.
+var ErrNoBlockCache = errors.New("no block cache configured")
+
+// BlockCache returns the block cache the instance was configured with, see
+// WithBlockCache, or nil if it has none.
+func (l *Lassie) BlockCache() *blockcache.Cache {
+	return l.cfg.BlockCache
+}
+
+// ExportBlockCache writes the blocks of the block cache to w as a CAR bundle,
+// every block if no roots are given, otherwise those of the DAGs of the roots,
+// returning the number of blocks written. The bundle can be imported into the
+// block cache of another instance with ImportBlockCache. See
+// blockcache.Cache.Export.
+func (l *Lassie) ExportBlockCache(ctx context.Context, w io.Writer, roots ...cid.Cid) (int, error) {
+	if l.cfg.BlockCache == nil {
+		return 0, ErrNoBlockCache
+	}
+	return l.cfg.BlockCache.Export(ctx, w, roots...)
+}
+
+// ImportBlockCache adds the blocks of a CAR, such as a bundle written by
+// ExportBlockCache, to the block cache, returning the number of blocks read.
+// See blockcache.Cache.Import.
+func (l *Lassie) ImportBlockCache(ctx context.Context, r io.Reader) (int, error) {
+	if l.cfg.BlockCache == nil {
+		return 0, ErrNoBlockCache
+	}
+	return l.cfg.BlockCache.Import(ctx, r)
+}
+
+// fetchFromBlockCache serves the request from the block cache if every block
+// it needs is cached, copying them into the request's LinkSystem in the order
+// the traversal of the request loads them. If any is missing, or the request
+// has a LinkPolicy or would be ended early by its block or byte budget,
+// nothing is copied and nil stats are returned, for the request to be
+// retrieved from providers instead.
+func (l *Lassie) fetchFromBlockCache(ctx context.Context, request types.RetrievalRequest) (*types.RetrievalStats, error) {
+	if request.LinkPolicy != nil || request.LinkSystem.StorageWriteOpener == nil {
+		return nil, nil
+	}
+	start := time.Now()
+	cfg := traversal.Config{Root: request.Root, Selector: request.GetSelector()}
+
+	// a dry run first, so that nothing is written unless every block is cached
+	var blocks, size uint64
+	seen := make(map[cid.Cid]struct{})
+	lsys := l.blockCacheLinkSystem(func(lctx linking.LinkContext, lnk datamodel.Link, data []byte) error {
+		c := lnk.(cidlink.Link).Cid
+		if _, ok := seen[c]; ok {
+			return nil
+		}
+		seen[c] = struct{}{}
+		blocks++
+		size += uint64(len(data))
+		return nil
+	})
+	if _, err := cfg.Traverse(ctx, lsys, nil); err != nil {
+		if !errors.Is(err, errNotCached) {
+			logger.Debugw("Failed to traverse block cache", "root", request.Root, "err", err)
+		}
+		return nil, nil
+	}
+	if (request.MaxBlocks > 0 && blocks > request.MaxBlocks) || (request.MaxBytes > 0 && size > request.MaxBytes) {
+		return nil, nil
+	}
+
+	written := make(map[cid.Cid]struct{})
+	lsys = l.blockCacheLinkSystem(func(lctx linking.LinkContext, lnk datamodel.Link, data []byte) error {
+		c := lnk.(cidlink.Link).Cid
+		if _, ok := written[c]; ok {
+			return nil
+		}
+		written[c] = struct{}{}
+		w, commit, err := request.LinkSystem.StorageWriteOpener(lctx)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return commit(lnk)
+	})
+	if _, err := cfg.Traverse(ctx, lsys, nil); err != nil {
+		// blocks may have been evicted since the dry run, but some have been
+		// written, so the request can't be retrieved from providers instead
+		return nil, fmt.Errorf("failed to serve from block cache: %w", err)
+	}
+
+	duration := time.Since(start)
+	return &types.RetrievalStats{
+		RootCid:       request.Root,
+		Size:          size,
+		Blocks:        blocks,
+		Duration:      duration,
+		AverageSpeed:  uint64(float64(size) / duration.Seconds()),
+		TotalPayment:  big.Zero(),
+		AskPrice:      big.Zero(),
+		BlockCacheHit: true,
+	}, nil
+}
+
+// blockCacheLinkSystem returns a LinkSystem that loads blocks from the block
+// cache, verifying them against their CIDs, and passes each block loaded to
+// onLoad. Loading a block that isn't cached fails with errNotCached.
+func (l *Lassie) blockCacheLinkSystem(onLoad func(linking.LinkContext, datamodel.Link, []byte) error) linking.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		c := lnk.(cidlink.Link).Cid
+		if c.Prefix().MhType == multihash.IDENTITY {
+			decoded, err := multihash.Decode(c.Hash())
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(decoded.Digest), nil
+		}
+		data, ok := l.cfg.BlockCache.Get(c)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errNotCached, c)
+		}
+		if err := onLoad(lctx, lnk, data); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+	return lsys
+}
+
+// writeThroughBlockCache returns a copy of the LinkSystem that adds each block
+// verified and written to it to the block cache.
+func (l *Lassie) writeThroughBlockCache(lsys linking.LinkSystem) linking.LinkSystem {
+	bwo := lsys.StorageWriteOpener
+	if bwo == nil {
+		return lsys
+	}
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		var buf bytes.Buffer
+		return io.MultiWriter(w, &buf), func(lnk datamodel.Link) error {
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			if err := l.cfg.BlockCache.Put(lnk.(cidlink.Link).Cid, buf.Bytes()); err != nil {
+				logger.Warnw("Failed to add block to block cache", "cid", lnk, "err", err)
+			}
+			return nil
+		}, nil
+	}
+	return lsys
+}
@@ -0,0 +1,111 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrRetrievalCancelled is returned by Fetch when the retrieval was ended with
+// Cancel.
+var ErrRetrievalCancelled = errors.New("retrieval cancelled")
+
+// ActiveRetrieval describes a retrieval that is in progress.
+type ActiveRetrieval struct {
+	RetrievalID types.RetrievalID `json:"retrievalId"`
+	Root        cid.Cid           `json:"root"`
+	Path        string            `json:"path,omitempty"`
+	StartTime   time.Time         `json:"startTime"`
+}
+
+type activeRetrieval struct {
+	ActiveRetrieval
+	cancel context.CancelCauseFunc
+}
+
+// activeRetrievals tracks the retrievals in progress, by ID, so they can be
+// listed and cancelled by something other than their caller.
+type activeRetrievals struct {
+	lk         sync.Mutex
+	retrievals map[types.RetrievalID]*activeRetrieval
+}
+
+func newActiveRetrievals() *activeRetrievals {
+	return &activeRetrievals{retrievals: make(map[types.RetrievalID]*activeRetrieval)}
+}
+
+// register records the retrieval as active, returning a context that is
+// cancelled by cancel, and a function to call once the retrieval has ended.
+func (ar *activeRetrievals) register(ctx context.Context, request types.RetrievalRequest) (context.Context, func(), error) {
+	ar.lk.Lock()
+	defer ar.lk.Unlock()
+	if _, ok := ar.retrievals[request.RetrievalID]; ok {
+		return nil, nil, fmt.Errorf("%w: %s", retriever.ErrRetrievalAlreadyRunning, request.RetrievalID)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	ar.retrievals[request.RetrievalID] = &activeRetrieval{
+		ActiveRetrieval: ActiveRetrieval{
+			RetrievalID: request.RetrievalID,
+			Root:        request.Root,
+			Path:        request.Path,
+			StartTime:   time.Now(),
+		},
+		cancel: cancel,
+	}
+	return ctx, func() {
+		ar.lk.Lock()
+		delete(ar.retrievals, request.RetrievalID)
+		ar.lk.Unlock()
+		cancel(nil)
+	}, nil
+}
+
+func (ar *activeRetrievals) cancel(id types.RetrievalID) bool {
+	ar.lk.Lock()
+	defer ar.lk.Unlock()
+	retrieval, ok := ar.retrievals[id]
+	if ok {
+		retrieval.cancel(ErrRetrievalCancelled)
+	}
+	return ok
+}
+
+func (ar *activeRetrievals) count() int {
+	ar.lk.Lock()
+	defer ar.lk.Unlock()
+	return len(ar.retrievals)
+}
+
+func (ar *activeRetrievals) list() []ActiveRetrieval {
+	ar.lk.Lock()
+	defer ar.lk.Unlock()
+	list := make([]ActiveRetrieval, 0, len(ar.retrievals))
+	for _, retrieval := range ar.retrievals {
+		list = append(list, retrieval.ActiveRetrieval)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime)
+	})
+	return list
+}
+
+// Cancel ends the in-progress retrieval with the given ID, returning false if
+// there is no such retrieval. The Fetch performing the retrieval returns an
+// error wrapping ErrRetrievalCancelled. Retrieval IDs are included in the
+// events for a retrieval and are assigned by Fetch when the request doesn't
+// already have one.
+func (l *Lassie) Cancel(id types.RetrievalID) bool {
+	return l.active.cancel(id)
+}
+
+// ActiveRetrievals returns the retrievals in progress, oldest first.
+func (l *Lassie) ActiveRetrievals() []ActiveRetrieval {
+	return l.active.list()
+}
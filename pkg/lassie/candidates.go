@@ -0,0 +1,43 @@
+package lassie
+
+import (
+	"context"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+)
+
+// Candidate is a provider found for a CID by FindCandidates.
+type Candidate struct {
+	types.RetrievalCandidate
+	// Usable lists the protocols advertised by the candidate that a retrieval
+	// would currently use it for. It's empty if a retrieval would skip the
+	// candidate, such as when it's on a block list, its circuit breaker is
+	// open, or none of its protocols are enabled.
+	Usable []multicodec.Code
+}
+
+// FindCandidates runs only the candidate discovery of a retrieval of c,
+// returning each provider found without probing or retrieving from any of
+// them. As for a retrieval, candidates advertised without addresses have them
+// looked up, and those in blocked networks are dropped.
+func (l *Lassie) FindCandidates(ctx context.Context, c cid.Cid) ([]Candidate, error) {
+	found, err := l.finder.FindCandidates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]Candidate, 0, len(found))
+	for _, candidate := range found {
+		var usable []multicodec.Code
+		if accept, filtered := l.session.FilterIndexerCandidate(candidate); accept {
+			for _, protocol := range filtered.Metadata.Protocols() {
+				if l.retriever.IsProtocolEnabled(protocol) {
+					usable = append(usable, protocol)
+				}
+			}
+		}
+		candidates = append(candidates, Candidate{RetrievalCandidate: candidate, Usable: usable})
+	}
+	return candidates, nil
+}
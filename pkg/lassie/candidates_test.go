@@ -0,0 +1,51 @@
+package lassie
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCandidates(t *testing.T) {
+	ctx := context.Background()
+	root := testutil.GenerateCid()
+	peers := testutil.GeneratePeers(t, 3)
+	addrs := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/80/http")}
+	found := []types.RetrievalCandidate{
+		types.NewRetrievalCandidate(peers[0], addrs, root, &metadata.IpfsGatewayHttp{}),
+		types.NewRetrievalCandidate(peers[1], addrs, root, &metadata.GraphsyncFilecoinV1{PieceCID: root}, &metadata.IpfsGatewayHttp{}),
+		types.NewRetrievalCandidate(peers[2], addrs, root, &metadata.IpfsGatewayHttp{}),
+	}
+
+	lassie, err := NewLassie(ctx,
+		WithFinder(testutil.NewMockCandidateFinder(nil, map[cid.Cid][]types.RetrievalCandidate{root: found})),
+		WithProtocols([]multicodec.Code{multicodec.TransportIpfsGatewayHttp}),
+		WithProviderBlockList(map[peer.ID]bool{peers[2]: true}),
+	)
+	require.NoError(t, err)
+
+	candidates, err := lassie.FindCandidates(ctx, root)
+	require.NoError(t, err)
+	require.Len(t, candidates, 3)
+	for i, candidate := range candidates {
+		require.Equal(t, found[i].MinerPeer, candidate.MinerPeer)
+		require.Equal(t, found[i].Metadata.Protocols(), candidate.Metadata.Protocols())
+	}
+	require.Equal(t, []multicodec.Code{multicodec.TransportIpfsGatewayHttp}, candidates[0].Usable)
+	// graphsync isn't enabled
+	require.Equal(t, []multicodec.Code{multicodec.TransportIpfsGatewayHttp}, candidates[1].Usable)
+	// blocked
+	require.Empty(t, candidates[2].Usable)
+
+	candidates, err = lassie.FindCandidates(ctx, testutil.GenerateCid())
+	require.NoError(t, err)
+	require.Empty(t, candidates)
+}
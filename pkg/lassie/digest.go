@@ -0,0 +1,108 @@
+package lassie
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrDigestMismatch is returned by Fetch when the file retrieved for a request
+// with an expected digest, see types.WithExpectedDigest, doesn't hash to it.
+var ErrDigestMismatch = errors.New("file digest mismatch")
+
+var digestProtoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
+
+// checkDigestRequest returns an error if the request can't retrieve the whole
+// of a file to check against an expected digest.
+func checkDigestRequest(request types.RetrievalRequest) error {
+	if request.Scope == trustlessutils.DagScopeBlock {
+		return errors.New("an expected digest requires a dag-scope of all or entity")
+	}
+	if request.Bytes != nil && !request.Bytes.IsDefault() {
+		return errors.New("an expected digest can't be used with entity-bytes")
+	}
+	if request.LinkSystem.StorageReadOpener == nil {
+		return errors.New("an expected digest requires readable storage")
+	}
+	return nil
+}
+
+// verifyDigest reads the UnixFS file at the terminal of the request's path
+// from the request's LinkSystem and checks that its bytes hash to expected.
+func verifyDigest(ctx context.Context, request types.RetrievalRequest, stats *types.RetrievalStats, expected multihash.Multihash) error {
+	if stats.Partial {
+		return fmt.Errorf("%w: the retrieval was partial, the file is incomplete", ErrDigestMismatch)
+	}
+	decoded, err := multihash.Decode(expected)
+	if err != nil {
+		return err
+	}
+	hasher, err := multihash.GetHasher(decoded.Code)
+	if err != nil {
+		return err
+	}
+
+	lsys := request.LinkSystem
+	load := func(lnk datamodel.Link) (datamodel.Node, error) {
+		lnkCtx := linking.LinkContext{Ctx: ctx}
+		proto, err := digestProtoChooser(lnk, lnkCtx)
+		if err != nil {
+			return nil, err
+		}
+		node, err := lsys.Load(lnkCtx, lnk, proto)
+		if err != nil {
+			return nil, err
+		}
+		return unixfsnode.Reify(lnkCtx, node, &lsys)
+	}
+	node, err := load(cidlink.Link{Cid: request.Root})
+	if err != nil {
+		return fmt.Errorf("failed to load %s to verify its digest: %w", request.Root, err)
+	}
+	for _, segment := range datamodel.ParsePath(request.Path).Segments() {
+		if node, err = node.LookupBySegment(segment); err != nil {
+			return fmt.Errorf("failed to resolve %s to verify its digest: %w", request.Path, err)
+		}
+		if node.Kind() == datamodel.Kind_Link {
+			lnk, _ := node.AsLink()
+			if node, err = load(lnk); err != nil {
+				return fmt.Errorf("failed to load %s to verify its digest: %w", request.Path, err)
+			}
+		}
+	}
+
+	var rdr io.Reader
+	if lbn, ok := node.(datamodel.LargeBytesNode); ok {
+		if rdr, err = lbn.AsLargeBytes(); err != nil {
+			return err
+		}
+	} else if node.Kind() == datamodel.Kind_Bytes {
+		byts, err := node.AsBytes()
+		if err != nil {
+			return err
+		}
+		rdr = bytes.NewReader(byts)
+	} else {
+		return fmt.Errorf("%w: /%s is not a file", ErrDigestMismatch, request.Path)
+	}
+	if _, err := io.Copy(hasher, rdr); err != nil {
+		return fmt.Errorf("failed to read the file to verify its digest: %w", err)
+	}
+	if digest := hasher.Sum(nil); string(digest) != string(decoded.Digest) {
+		return fmt.Errorf("%w: expected %s:%s, got %s", ErrDigestMismatch, decoded.Name, hex.EncodeToString(decoded.Digest), hex.EncodeToString(digest))
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// FailureReason classifies why a retrieval failed.
+type FailureReason string
+
+const (
+	// FailureNoCandidates is a retrieval for which no providers were found.
+	FailureNoCandidates FailureReason = "no-candidates"
+	// FailureTimeout is a retrieval that ran out of time, either hitting the
+	// global timeout or timing out with every provider tried.
+	FailureTimeout FailureReason = "timeout"
+	// FailureProvidersFailed is a retrieval for which every provider tried
+	// failed, see FailureSummary#ProviderFailures for how.
+	FailureProvidersFailed FailureReason = "providers-failed"
+	// FailureCancelled is a retrieval that was cancelled by its caller or with
+	// Cancel.
+	FailureCancelled FailureReason = "cancelled"
+	// FailureOther is any other failure.
+	FailureOther FailureReason = "other"
+)
+
+// ProviderFailureClass classifies how a single provider failed to serve a
+// retrieval over a protocol.
+type ProviderFailureClass string
+
+const (
+	ProviderFailureConnect ProviderFailureClass = "connect"
+	ProviderFailureTimeout ProviderFailureClass = "timeout"
+	ProviderFailureOther   ProviderFailureClass = "other"
+)
+
+// FailureStatsRetention is the longest window over which failures are
+// aggregated by FailureStats.
+const FailureStatsRetention = time.Hour
+
+// failureBucketWidth is the granularity of the rolling windows.
+const failureBucketWidth = 10 * time.Second
+
+// FailureCount is the number of retrievals that failed for a reason in a
+// phase, the phase being the furthest the retrieval got before failing.
+type FailureCount struct {
+	Reason FailureReason       `json:"reason"`
+	Phase  types.ProgressPhase `json:"phase"`
+	Count  uint64              `json:"count"`
+}
+
+// ProviderFailureCount is the number of times providers failed in a way over
+// a protocol.
+type ProviderFailureCount struct {
+	Protocol string               `json:"protocol"`
+	Class    ProviderFailureClass `json:"class"`
+	Count    uint64               `json:"count"`
+}
+
+// FailureSummary aggregates the retrievals finished within a window of time.
+type FailureSummary struct {
+	Window           string                 `json:"window"`
+	Retrievals       uint64                 `json:"retrievals"`
+	Failures         uint64                 `json:"failures"`
+	Reasons          []FailureCount         `json:"reasons"`
+	ProviderFailures []ProviderFailureCount `json:"providerFailures"`
+}
+
+type failureKey struct {
+	reason FailureReason
+	phase  types.ProgressPhase
+}
+
+type providerFailureKey struct {
+	protocol multicodec.Code
+	class    ProviderFailureClass
+}
+
+type failureBucket struct {
+	start            time.Time
+	retrievals       uint64
+	failures         map[failureKey]uint64
+	providerFailures map[providerFailureKey]uint64
+}
+
+// failureStats aggregates the outcomes of retrievals into buckets of
+// failureBucketWidth, kept for FailureStatsRetention, and counts them in
+// OpenTelemetry counters labelled by reason, phase, protocol and class.
+type failureStats struct {
+	lk      sync.Mutex
+	buckets []*failureBucket // oldest first
+
+	retrievals       metric.Int64Counter
+	failures         metric.Int64Counter
+	providerFailures metric.Int64Counter
+}
+
+func newFailureStats() (*failureStats, error) {
+	retrievals, err := meter.Int64Counter("lassie.retrievals",
+		metric.WithDescription("Number of retrievals finished"))
+	if err != nil {
+		return nil, err
+	}
+	failures, err := meter.Int64Counter("lassie.retrieval.failures",
+		metric.WithDescription("Number of retrievals failed, by reason and the phase reached"))
+	if err != nil {
+		return nil, err
+	}
+	providerFailures, err := meter.Int64Counter("lassie.retrieval.provider_failures",
+		metric.WithDescription("Number of failed attempts to retrieve from a provider, by protocol and class of failure"))
+	if err != nil {
+		return nil, err
+	}
+	return &failureStats{
+		retrievals:       retrievals,
+		failures:         failures,
+		providerFailures: providerFailures,
+	}, nil
+}
+
+// bucket returns the bucket for the given time, dropping buckets older than
+// the retention. Must be called with the lock held.
+func (fs *failureStats) bucket(now time.Time) *failureBucket {
+	start := now.Truncate(failureBucketWidth)
+	if n := len(fs.buckets); n > 0 && fs.buckets[n-1].start.Equal(start) {
+		return fs.buckets[n-1]
+	}
+	var expired int
+	for expired < len(fs.buckets) && !fs.buckets[expired].start.After(start.Add(-FailureStatsRetention)) {
+		expired++
+	}
+	fs.buckets = append(fs.buckets[expired:], &failureBucket{
+		start:            start,
+		failures:         make(map[failureKey]uint64),
+		providerFailures: make(map[providerFailureKey]uint64),
+	})
+	return fs.buckets[len(fs.buckets)-1]
+}
+
+func (fs *failureStats) record(outcome *failureTracker, err error) {
+	ctx := context.Background()
+	fs.retrievals.Add(ctx, 1)
+	for key, count := range outcome.providerFailures {
+		fs.providerFailures.Add(ctx, int64(count), metric.WithAttributes(
+			attribute.String("protocol", key.protocol.String()),
+			attribute.String("class", string(key.class)),
+		))
+	}
+	var key failureKey
+	if err != nil {
+		key = failureKey{outcome.reason(err), outcome.currentPhase()}
+		fs.failures.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("reason", string(key.reason)),
+			attribute.String("phase", string(key.phase)),
+		))
+	}
+
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	bucket := fs.bucket(time.Now())
+	bucket.retrievals++
+	for key, count := range outcome.providerFailures {
+		bucket.providerFailures[key] += count
+	}
+	if err != nil {
+		bucket.failures[key]++
+	}
+}
+
+func (fs *failureStats) summary(window time.Duration) FailureSummary {
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	failures := make(map[failureKey]uint64)
+	providerFailures := make(map[providerFailureKey]uint64)
+	summary := FailureSummary{
+		Window:           window.String(),
+		Reasons:          []FailureCount{},
+		ProviderFailures: []ProviderFailureCount{},
+	}
+	since := time.Now().Add(-window)
+	for _, bucket := range fs.buckets {
+		if bucket.start.Add(failureBucketWidth).Before(since) {
+			continue
+		}
+		summary.Retrievals += bucket.retrievals
+		for key, count := range bucket.failures {
+			failures[key] += count
+			summary.Failures += count
+		}
+		for key, count := range bucket.providerFailures {
+			providerFailures[key] += count
+		}
+	}
+	for key, count := range failures {
+		summary.Reasons = append(summary.Reasons, FailureCount{Reason: key.reason, Phase: key.phase, Count: count})
+	}
+	for key, count := range providerFailures {
+		summary.ProviderFailures = append(summary.ProviderFailures, ProviderFailureCount{Protocol: key.protocol.String(), Class: key.class, Count: count})
+	}
+	sort.Slice(summary.Reasons, func(i, j int) bool {
+		a, b := summary.Reasons[i], summary.Reasons[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Reason != b.Reason {
+			return a.Reason < b.Reason
+		}
+		return a.Phase < b.Phase
+	})
+	sort.Slice(summary.ProviderFailures, func(i, j int) bool {
+		a, b := summary.ProviderFailures[i], summary.ProviderFailures[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Class < b.Class
+	})
+	return summary
+}
+
+// failureTracker follows the events of a single retrieval to record how far it
+// got and how its providers failed, or which protocol it succeeded over.
+type failureTracker struct {
+	ctx              context.Context
+	lk               sync.Mutex
+	phase            types.ProgressPhase
+	providerFailures map[providerFailureKey]uint64
+	succeeded        multicodec.Code
+}
+
+func newFailureTracker(ctx context.Context) *failureTracker {
+	return &failureTracker{
+		ctx:              ctx,
+		phase:            types.ProgressFindingCandidates,
+		providerFailures: make(map[providerFailureKey]uint64),
+	}
+}
+
+func (ft *failureTracker) onEvent(event types.RetrievalEvent) {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+	switch evt := event.(type) {
+	case events.StartedRetrievalEvent:
+		if ft.phase == types.ProgressFindingCandidates {
+			ft.phase = types.ProgressConnecting
+		}
+	case events.FirstByteEvent, events.BlockReceivedEvent:
+		ft.phase = types.ProgressTransferring
+	case events.FailedRetrievalEvent:
+		ft.providerFailures[providerFailureKey{evt.Protocol(), classifyProviderFailure(evt.ErrorMessage())}]++
+	case events.SucceededEvent:
+		ft.succeeded = evt.Protocol()
+	}
+}
+
+// succeededProtocol returns the protocol the retrieval succeeded over, if it
+// has.
+func (ft *failureTracker) succeededProtocol() (multicodec.Code, bool) {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+	return ft.succeeded, ft.succeeded != 0
+}
+
+// currentPhase returns the furthest phase the retrieval has reached.
+func (ft *failureTracker) currentPhase() types.ProgressPhase {
+	ft.lk.Lock()
+	defer ft.lk.Unlock()
+	return ft.phase
+}
+
+// reason classifies the error a retrieval failed with.
+func (ft *failureTracker) reason(err error) FailureReason {
+	switch {
+	case errors.Is(err, ErrRetrievalCancelled), errors.Is(ft.ctx.Err(), context.Canceled), errors.Is(err, context.Canceled):
+		return FailureCancelled
+	case errors.Is(ft.ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	case errors.Is(err, retriever.ErrNoCandidates):
+		return FailureNoCandidates
+	case errors.Is(err, retriever.ErrAllRetrievalsFailed), errors.Is(err, retriever.ErrAllQueriesFailed):
+		ft.lk.Lock()
+		defer ft.lk.Unlock()
+		var failures, timeouts uint64
+		for key, count := range ft.providerFailures {
+			failures += count
+			if key.class == ProviderFailureTimeout {
+				timeouts += count
+			}
+		}
+		if failures > 0 && timeouts == failures {
+			return FailureTimeout
+		}
+		return FailureProvidersFailed
+	default:
+		return FailureOther
+	}
+}
+
+func classifyProviderFailure(msg string) ProviderFailureClass {
+	switch {
+	case strings.Contains(msg, retriever.ErrConnectFailed.Error()):
+		return ProviderFailureConnect
+	case strings.Contains(msg, retriever.ErrRetrievalTimedOut.Error()),
+		strings.Contains(msg, "timeout"),
+		strings.Contains(msg, context.DeadlineExceeded.Error()):
+		return ProviderFailureTimeout
+	default:
+		return ProviderFailureOther
+	}
+}
+
+// FailureStats summarizes the retrievals finished by this instance within the
+// given window, up to FailureStatsRetention, counting failures by reason and
+// the phase they reached, and failed attempts to retrieve from providers by
+// protocol and class. The same counts are always available as
+// lassie.retrieval.* counters through the global OpenTelemetry meter provider.
+func (l *Lassie) FailureStats(window time.Duration) FailureSummary {
+	if window > FailureStatsRetention {
+		window = FailureStatsRetention
+	}
+	return l.failures.summary(window)
+}
@@ -0,0 +1,162 @@
+package lassie
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+)
+
+// FetchResult is the result of one of the retrievals of a batch.
+type FetchResult struct {
+	Request types.RetrievalRequest
+	Stats   *types.RetrievalStats
+	Err     error
+}
+
+// FetchAllResult is the aggregated result of a batch of retrievals.
+type FetchAllResult struct {
+	// Results holds the result of each retrieval, in the order of the
+	// requests.
+	Results   []FetchResult
+	Succeeded int
+	Failed    int
+	// Size and Blocks are totals across the successful retrievals.
+	Size     uint64
+	Blocks   uint64
+	Duration time.Duration
+}
+
+// FetchAll performs a batch of retrievals, running at most
+// DefaultBatchConcurrency of them at once unless a different limit is given
+// with types.WithBatchConcurrency. The options are applied to each of the
+// retrievals. Candidates for a root CID are only looked up once for the whole
+// batch and shared between the requests for that root; other state, such as
+// provider metrics, is shared by all retrievals of a Lassie instance.
+//
+// A failed retrieval doesn't end the batch, its error is recorded in its
+// result. Cancelling the context ends the retrievals in progress, and those
+// that haven't started fail with the context's error.
+func (l *Lassie) FetchAll(ctx context.Context, requests []types.RetrievalRequest, opts ...types.FetchOption) FetchAllResult {
+	concurrency := types.NewFetchConfig(opts...).BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	// end any candidate lookups still running when the batch is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = context.WithValue(ctx, candidateCacheKey{}, newCandidateCache(ctx))
+	startTime := time.Now()
+	result := FetchAllResult{Results: make([]FetchResult, len(requests))}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		i, request := i, request
+		result.Results[i].Request = request
+		select {
+		case <-ctx.Done():
+			result.Results[i].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result.Results[i].Stats, result.Results[i].Err = l.Fetch(ctx, request, opts...)
+		}()
+	}
+	wg.Wait()
+
+	for _, res := range result.Results {
+		if res.Err != nil {
+			result.Failed++
+			continue
+		}
+		result.Succeeded++
+		result.Size += res.Stats.Size
+		result.Blocks += res.Stats.Blocks
+	}
+	result.Duration = time.Since(startTime)
+	return result
+}
+
+type candidateCacheKey struct{}
+
+// candidateCache shares candidate lookups between the retrievals of a batch.
+// Lookups run on the batch's context, so a retrieval that gives up waiting
+// for one doesn't fail it for the other retrievals of the same root.
+type candidateCache struct {
+	ctx     context.Context
+	lk      sync.Mutex
+	lookups map[cid.Cid]*candidateLookup
+}
+
+type candidateLookup struct {
+	done       chan struct{}
+	lk         sync.Mutex
+	candidates []types.RetrievalCandidate
+	err        error
+}
+
+func newCandidateCache(ctx context.Context) *candidateCache {
+	return &candidateCache{ctx: ctx, lookups: make(map[cid.Cid]*candidateLookup)}
+}
+
+func (cc *candidateCache) find(ctx context.Context, finder retriever.CandidateFinder, c cid.Cid) ([]types.RetrievalCandidate, error) {
+	cc.lk.Lock()
+	lookup, ok := cc.lookups[c]
+	if !ok {
+		lookup = &candidateLookup{done: make(chan struct{})}
+		cc.lookups[c] = lookup
+		go func() {
+			defer close(lookup.done)
+			err := finder.FindCandidatesAsync(cc.ctx, c, func(candidate types.RetrievalCandidate) {
+				lookup.lk.Lock()
+				lookup.candidates = append(lookup.candidates, candidate)
+				lookup.lk.Unlock()
+			})
+			lookup.lk.Lock()
+			lookup.err = err
+			lookup.lk.Unlock()
+		}()
+	}
+	cc.lk.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-lookup.done:
+	}
+	lookup.lk.Lock()
+	defer lookup.lk.Unlock()
+	return lookup.candidates, lookup.err
+}
+
+// batchCandidateFinder looks up candidates through the candidate cache of a
+// batch of retrievals when the context carries one, or directly otherwise.
+type batchCandidateFinder struct {
+	retriever.CandidateFinder
+}
+
+func (bcf batchCandidateFinder) FindCandidates(ctx context.Context, c cid.Cid) ([]types.RetrievalCandidate, error) {
+	if cache, ok := ctx.Value(candidateCacheKey{}).(*candidateCache); ok {
+		return cache.find(ctx, bcf.CandidateFinder, c)
+	}
+	return bcf.CandidateFinder.FindCandidates(ctx, c)
+}
+
+func (bcf batchCandidateFinder) FindCandidatesAsync(ctx context.Context, c cid.Cid, cb func(types.RetrievalCandidate)) error {
+	if cache, ok := ctx.Value(candidateCacheKey{}).(*candidateCache); ok {
+		candidates, err := cache.find(ctx, bcf.CandidateFinder, c)
+		for _, candidate := range candidates {
+			cb(candidate)
+		}
+		return err
+	}
+	return bcf.CandidateFinder.FindCandidatesAsync(ctx, c, cb)
+}
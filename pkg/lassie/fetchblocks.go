@@ -0,0 +1,89 @@
+package lassie
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// FetchBlocks performs the retrieval described by the request, sending each
+// block on the blocks channel as soon as it has been verified, along with the
+// path at which the traversal reached it. The channel is closed when the
+// retrieval ends, after which the result of the retrieval is returned.
+//
+// Blocks are still stored in the request's LinkSystem, which the traversal
+// reads from, so it must have storage as it would for Fetch. If the request
+// has no PreloadLinkSystem, temporary storage, by default a CAR in the system
+// temporary directory, see WithTempStore, is used for it. A slow reader of the channel slows the retrieval down;
+// cancelling the context ends it.
+func (l *Lassie) FetchBlocks(
+	ctx context.Context,
+	request types.RetrievalRequest,
+	blocks chan<- types.RetrievedBlock,
+	opts ...types.FetchOption,
+) (*types.RetrievalStats, error) {
+	defer close(blocks)
+
+	request, cleanup, err := l.onVerifiedBlock(request, opts, func(lctx linking.LinkContext, lnk cidlink.Link, data []byte) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case blocks <- types.RetrievedBlock{Cid: lnk.Cid, Data: data, Path: lctx.LinkPath}:
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	return l.Fetch(ctx, request, opts...)
+}
+
+// onVerifiedBlock returns a copy of the request whose LinkSystem calls cb with
+// each block once it has been verified and stored, along with the context the
+// traversal reached it in; an error from cb fails the write. The returned
+// function releases any temporary storage set up for the request.
+func (l *Lassie) onVerifiedBlock(
+	request types.RetrievalRequest,
+	opts []types.FetchOption,
+	cb func(lctx linking.LinkContext, lnk cidlink.Link, data []byte) error,
+) (types.RetrievalRequest, func(), error) {
+	cleanup := func() {}
+
+	// with a preload LinkSystem, Bitswap writes blocks to the request's
+	// LinkSystem as the traversal reaches them, so we know their paths
+	if !request.HasPreloadLinkSystem() {
+		preloadStore, err := l.newTempStore(request.Root, opts)
+		if err != nil {
+			return request, nil, err
+		}
+		cleanup = func() { preloadStore.Close() }
+		request.PreloadLinkSystem = cidlink.DefaultLinkSystem()
+		request.PreloadLinkSystem.SetReadStorage(preloadStore)
+		request.PreloadLinkSystem.SetWriteStorage(preloadStore)
+		request.PreloadLinkSystem.TrustedStorage = true
+	}
+
+	bwo := request.LinkSystem.StorageWriteOpener
+	request.LinkSystem.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		var buf bytes.Buffer
+		return io.MultiWriter(w, &buf), func(lnk datamodel.Link) error {
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			return cb(lctx, lnk.(cidlink.Link), buf.Bytes())
+		}, nil
+	}
+
+	return request, cleanup, nil
+}
@@ -0,0 +1,118 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-unixfsnode"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// FetchIntoBlockstore performs the retrieval described by the request,
+// writing each verified block directly into bs rather than via a CAR. The
+// request's LinkSystem and PreloadLinkSystem are replaced; blocks already in
+// bs may be used to satisfy the request where the transport allows it.
+//
+// Blocks preloaded by Bitswap are held in temporary storage, by default a CAR
+// in the system temporary directory, see WithTempStore, until the traversal
+// reaches them, so only the blocks that are part of the requested DAG are
+// written to bs.
+//
+// When the instance is configured WithBlockstoreBatching, blocks are written
+// to bs in batches, all of which are committed before returning.
+func (l *Lassie) FetchIntoBlockstore(
+	ctx context.Context,
+	request types.RetrievalRequest,
+	bs blockstore.Blockstore,
+	opts ...types.FetchOption,
+) (stats *types.RetrievalStats, err error) {
+	var store types.ReadableWritableStorage = storage.NewBlockstoreStorage(bs)
+	if l.cfg.BlockstoreBatch != nil {
+		batching := storage.NewBatchingBlockstoreStorage(bs, *l.cfg.BlockstoreBatch)
+		defer func() {
+			// blocks verified before a failure are still committed
+			if closeErr := batching.Close(); closeErr != nil {
+				err = errors.Join(err, closeErr)
+			}
+			l.batches.add(batching.Stats())
+		}()
+		store = batching
+	}
+	request.LinkSystem = cidlink.DefaultLinkSystem()
+	request.LinkSystem.SetReadStorage(store)
+	request.LinkSystem.SetWriteStorage(store)
+	request.LinkSystem.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&request.LinkSystem)
+
+	preloadStore, err := l.newTempStore(request.Root, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer preloadStore.Close()
+	request.PreloadLinkSystem = cidlink.DefaultLinkSystem()
+	request.PreloadLinkSystem.SetReadStorage(preloadStore)
+	request.PreloadLinkSystem.SetWriteStorage(preloadStore)
+	request.PreloadLinkSystem.TrustedStorage = true
+
+	return l.Fetch(ctx, request, opts...)
+}
+
+// blockstoreBatchMetrics totals the commits made by FetchIntoBlockstore when
+// blocks are batched, for reporting through OpenTelemetry.
+type blockstoreBatchMetrics struct {
+	flushes     atomic.Uint64
+	blocks      atomic.Uint64
+	bytes       atomic.Uint64
+	flushTimeNs atomic.Int64
+}
+
+func (bm *blockstoreBatchMetrics) add(stats storage.BatchStats) {
+	bm.flushes.Add(stats.Flushes)
+	bm.blocks.Add(stats.Blocks)
+	bm.bytes.Add(stats.Bytes)
+	bm.flushTimeNs.Add(int64(stats.FlushTime))
+}
+
+// registerMetrics reports the totals through counters on the global
+// OpenTelemetry meter. The returned function unregisters the counters.
+func (bm *blockstoreBatchMetrics) registerMetrics() (func() error, error) {
+	flushes, err := meter.Int64ObservableCounter("lassie.blockstore.flushes",
+		metric.WithDescription("Number of batches of blocks committed to blockstores"))
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := meter.Int64ObservableCounter("lassie.blockstore.flushed_blocks",
+		metric.WithDescription("Number of blocks committed to blockstores in batches"))
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := meter.Int64ObservableCounter("lassie.blockstore.flushed_bytes",
+		metric.WithDescription("Number of bytes committed to blockstores in batches"),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+	flushTime, err := meter.Float64ObservableCounter("lassie.blockstore.flush_time",
+		metric.WithDescription("Time spent committing batches of blocks to blockstores"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	registration, err := meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		o.ObserveInt64(flushes, int64(bm.flushes.Load()))
+		o.ObserveInt64(blocks, int64(bm.blocks.Load()))
+		o.ObserveInt64(bytes, int64(bm.bytes.Load()))
+		o.ObserveFloat64(flushTime, time.Duration(bm.flushTimeNs.Load()).Seconds())
+		return nil
+	}, flushes, blocks, bytes, flushTime)
+	if err != nil {
+		return nil, err
+	}
+	return registration.Unregister, nil
+}
@@ -0,0 +1,87 @@
+package lassie
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+// FetchNodes performs the retrieval described by the request, decoding each
+// block into an IPLD node as soon as it has been verified and calling the
+// visitor with it, in the order the traversal reaches the blocks, so that
+// indexing and analytics pipelines can consume a DAG without walking a CAR of
+// it afterwards. The Path of the progress is the path at which the traversal
+// reached the block, and its LastBlock holds the block's link. Nodes are of
+// the data model, not reified by an ADL such as UnixFS, and each block is
+// visited once even where it appears more than once in the DAG.
+//
+// The visitor is called one block at a time and a slow visitor slows the
+// retrieval down. An error from the visitor ends the retrieval, without
+// trying other providers, and is returned as is. Budgets set with the
+// FetchOptions, and the errors of the retrieval, apply as they would to
+// Fetch. As with FetchBlocks, blocks are still stored in the request's
+// LinkSystem.
+func (l *Lassie) FetchNodes(
+	ctx context.Context,
+	request types.RetrievalRequest,
+	visitor traversal.VisitFn,
+	opts ...types.FetchOption,
+) (*types.RetrievalStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lk sync.Mutex
+	var visitErr error
+	visited := make(map[cid.Cid]struct{})
+	decoderChooser := request.LinkSystem.DecoderChooser
+
+	request, cleanup, err := l.onVerifiedBlock(request, opts, func(lctx linking.LinkContext, lnk cidlink.Link, data []byte) error {
+		lk.Lock()
+		defer lk.Unlock()
+		if visitErr != nil {
+			return visitErr
+		}
+		if _, ok := visited[lnk.Cid]; ok {
+			return nil
+		}
+		visited[lnk.Cid] = struct{}{}
+
+		decoder, err := decoderChooser(lnk)
+		if err != nil {
+			return err
+		}
+		nb := basicnode.Prototype.Any.NewBuilder()
+		if err := decoder(nb, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		progress := traversal.Progress{Path: lctx.LinkPath}
+		progress.LastBlock.Path = lctx.LinkPath
+		progress.LastBlock.Link = lnk
+		if err := visitor(progress, nb.Build()); err != nil {
+			visitErr = err
+			cancel()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	stats, err := l.Fetch(ctx, request, opts...)
+
+	lk.Lock()
+	defer lk.Unlock()
+	if visitErr != nil {
+		return nil, visitErr
+	}
+	return stats, err
+}
@@ -0,0 +1,67 @@
+package lassie
+
+import (
+	"context"
+	"io"
+
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage/deferred"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+// FetchToWriter retrieves the DAG at the path below the root CID, to the
+// given scope, and streams it to w as a CARv1 with the root CID as its only
+// root and without duplicate blocks. Blocks are buffered in temporary storage,
+// by default a CAR in the system temporary directory, see WithTempStore,
+// while the retrieval is in progress, so that traversals don't need to
+// re-fetch them, and nothing is written to w until the first block is
+// received.
+//
+// This is a convenience over Fetch for callers that don't need to manage the
+// LinkSystem and storage of the request themselves. If the retrieval fails
+// after blocks have been written to w the CAR will be incomplete.
+func (l *Lassie) FetchToWriter(
+	ctx context.Context,
+	root cid.Cid,
+	path string,
+	scope trustlessutils.DagScope,
+	w io.Writer,
+	opts ...types.FetchOption,
+) (*types.RetrievalStats, error) {
+	// hide any other capabilities of the writer, such as seeking on an
+	// os.File, which go-car would otherwise try to use
+	carWriter := deferred.NewDeferredCarWriterForStream(
+		struct{ io.Writer }{w},
+		[]cid.Cid{root},
+		car.WriteAsCarV1(true),
+		car.StoreIdentityCIDs(false),
+		car.UseWholeCIDs(false),
+	)
+	defer carWriter.Close()
+
+	tempStore, err := l.newTempStore(root, opts)
+	if err != nil {
+		return nil, err
+	}
+	carStore := storage.NewCachingTempStore(carWriter.BlockWriteOpener(), tempStore)
+	defer carStore.Close()
+
+	request, err := types.NewRequestForPath(carStore, root, path, scope, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup preload storage for bitswap, the temporary CAR store can set up a
+	// separate preload space in its storage
+	request.PreloadLinkSystem = cidlink.DefaultLinkSystem()
+	preloadStore := carStore.PreloadStore()
+	request.PreloadLinkSystem.SetReadStorage(preloadStore)
+	request.PreloadLinkSystem.SetWriteStorage(preloadStore)
+	request.PreloadLinkSystem.TrustedStorage = true
+
+	return l.Fetch(ctx, request, opts...)
+}
@@ -0,0 +1,62 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrHostNotListening    = errors.New("libp2p host has no listen addresses")
+	ErrRetrieverNotRunning = errors.New("retriever is not running")
+)
+
+// CheckHost returns an error if the libp2p host used by this Lassie instance
+// is not in a usable state. A host that has been closed no longer has any
+// listen addresses. A host that hasn't been started yet, because no retrieval
+// has needed it, is not considered to be in an unusable state.
+func (l *Lassie) CheckHost(ctx context.Context) error {
+	h := l.host.Started()
+	if h == nil {
+		return nil
+	}
+	if err := h.ID().Validate(); err != nil {
+		return err
+	}
+	if len(h.Network().ListenAddresses()) == 0 {
+		return ErrHostNotListening
+	}
+	return nil
+}
+
+// CheckRetriever returns an error if the retriever is not able to accept new
+// retrievals.
+func (l *Lassie) CheckRetriever(ctx context.Context) error {
+	if !l.retriever.IsRunning() {
+		return ErrRetrieverNotRunning
+	}
+	return nil
+}
+
+// CheckFinder returns an error if the candidate finder can't be reached. Only
+// finders that implement a `Ping(context.Context) error` method, such as the
+// default indexer candidate finder, are checked; others are assumed to be
+// reachable.
+func (l *Lassie) CheckFinder(ctx context.Context) error {
+	if pinger, ok := l.cfg.Finder.(interface {
+		Ping(context.Context) error
+	}); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// DescribeHost describes the state of the libp2p host used by this Lassie
+// instance, for health reports.
+func (l *Lassie) DescribeHost() string {
+	h := l.host.Started()
+	if h == nil {
+		return "not started"
+	}
+	return fmt.Sprintf("%d listen addresses, %d peers connected", len(h.Network().ListenAddresses()), len(h.Network().Peers()))
+}
@@ -0,0 +1,14 @@
+package lassie
+
+import "errors"
+
+// ErrDiskAccess is returned when constructing a component, such as the HTTP
+// server, for an in-memory Lassie instance, see WithInMemory, with a
+// configuration that would read or write files.
+var ErrDiskAccess = errors.New("configuration would access disk in in-memory mode")
+
+// InMemory returns true if the instance was configured with WithInMemory and
+// never touches disk.
+func (l *Lassie) InMemory() bool {
+	return l.cfg.InMemory
+}
@@ -2,32 +2,65 @@ package lassie
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"sync/atomic"
 	"time"
 
+	"github.com/filecoin-project/lassie/pkg/aggregateeventrecorder"
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/eventwebhook"
 	"github.com/filecoin-project/lassie/pkg/indexerlookup"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/net/client"
 	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/filecoin-project/lassie/pkg/receipts"
+	"github.com/filecoin-project/lassie/pkg/resultstore"
 	"github.com/filecoin-project/lassie/pkg/retriever"
 	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/storage"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/sync"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ types.Fetcher = &Lassie{}
 
+var logger = logging.Subsystem("lassie")
+
 const DefaultProviderTimeout = 20 * time.Second
 const DefaultBitswapConcurrency = 32
 const DefaultBitswapConcurrencyPerRetrieval = 12
+const DefaultMaxBlockSize = 2 << 20
+const DefaultBatchConcurrency = 8
 
 // Lassie represents a reusable retrieval client.
 type Lassie struct {
 	cfg       *LassieConfig
+	host      *lazyHost
 	retriever *retriever.Retriever
+	session   *session.Session
+	finder    retriever.CandidateFinder
+	active    *activeRetrievals
+	batches   *blockstoreBatchMetrics
+	failures  *failureStats
+	metrics   *prometheusMetrics
+	affinity  *affinityCounter
+	// providerLists is nil unless provider list sources are configured
+	providerLists *providerLists
+	// globalTimeout starts as the configured GlobalTimeout and may be changed
+	// with SetGlobalTimeout
+	globalTimeout atomic.Int64
 }
 
 // LassieConfig customizes the behavior of a Lassie instance.
@@ -36,13 +69,48 @@ type LassieConfig struct {
 	Host                           host.Host
 	ProviderTimeout                time.Duration
 	ConcurrentSPRetrievals         uint
+	ProviderConfigs                ProviderConfigs
 	GlobalTimeout                  time.Duration
 	Libp2pOptions                  []libp2p.Option
 	Protocols                      []multicodec.Code
 	ProviderBlockList              map[peer.ID]bool
 	ProviderAllowList              map[peer.ID]bool
+	ProviderListSources            *ProviderListSources
+	ProviderBlockedNetworks        []netip.Prefix
 	BitswapConcurrency             int
 	BitswapConcurrencyPerRetrieval int
+	BitswapSessionIdleTimeout      time.Duration
+	BitswapPreloadMaxBytes         uint64
+	BitswapPreloadSpillDir         string
+	RetrievalReceipts              bool
+	SmallContentThreshold          uint64
+	LargeContentThreshold          uint64
+	ScoringWeights                 *session.ScoringWeights
+	CircuitBreaker                 *session.CircuitBreaker
+	RetryPolicies                  retriever.RetryPolicies
+	Region                         string
+	RegionLocator                  retriever.RegionLocator
+	MaxBlockSize                   uint64
+	ProviderQueryLimits            types.ProviderQueryLimits
+	HttpRateLimits                 retriever.HttpRateLimits
+	HttpPrewarm                    retriever.HttpPrewarm
+	TelemetryInterval              time.Duration
+	BlockstoreBatch                *storage.BlockstoreBatchConfig
+	MetricsRegisterer              prometheus.Registerer
+	Logger                         logging.Logger
+	LogLevels                      map[string]logging.Level
+	InMemory                       bool
+	TempStore                      types.TempStoreFactory
+	InMemoryThreshold              uint64
+	BlockCache                     *blockcache.Cache
+	EventWebhook                   *eventwebhook.Config
+	AlertWebhook                   *eventwebhook.Config
+	AggregateEventRecorders        []aggregateeventrecorder.EventRecorderConfig
+	ReputationPersistence          *session.PersistConfig
+	AddrBackfill                   retriever.AddrBackfill
+	LatencyProbe                   *retriever.LatencyProbe
+	CapabilityTTL                  time.Duration
+	ResultStore                    *resultstore.Store
 }
 
 type LassieOption func(cfg *LassieConfig)
@@ -65,6 +133,13 @@ func NewLassieConfig(opts ...LassieOption) *LassieConfig {
 // NewLassieWithConfig creates a new Lassie instance with a custom
 // configuration.
 func NewLassieWithConfig(ctx context.Context, cfg *LassieConfig) (*Lassie, error) {
+	if cfg.Logger != nil {
+		logging.SetLogger(cfg.Logger)
+	}
+	for subsystem, level := range cfg.LogLevels {
+		logging.SetLevel(subsystem, level)
+	}
+
 	if cfg.Finder == nil {
 		var err error
 		cfg.Finder, err = indexerlookup.NewCandidateFinder(indexerlookup.WithHttpClient(&http.Client{}))
@@ -82,68 +157,258 @@ func NewLassieWithConfig(ctx context.Context, cfg *LassieConfig) (*Lassie, error
 	if cfg.BitswapConcurrencyPerRetrieval == 0 {
 		cfg.BitswapConcurrencyPerRetrieval = DefaultBitswapConcurrencyPerRetrieval
 	}
+	if cfg.MaxBlockSize == 0 {
+		cfg.MaxBlockSize = DefaultMaxBlockSize
+	}
+	if cfg.InMemory && cfg.BitswapPreloadSpillDir != "" {
+		return nil, fmt.Errorf("%w: preload spill directory %s", ErrDiskAccess, cfg.BitswapPreloadSpillDir)
+	}
 
 	datastore := sync.MutexWrap(datastore.NewMapDatastore())
 
-	if cfg.Host == nil {
-		var err error
-		cfg.Host, err = host.InitHost(ctx, cfg.Libp2pOptions)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	sessionConfig := session.DefaultConfig().
 		WithProviderBlockList(cfg.ProviderBlockList).
 		WithProviderAllowList(cfg.ProviderAllowList).
 		WithDefaultProviderConfig(session.ProviderConfig{
 			RetrievalTimeout:        cfg.ProviderTimeout,
 			MaxConcurrentRetrievals: cfg.ConcurrentSPRetrievals,
-		})
+			Protocols:               cfg.ProviderConfigs.Protocols,
+		}).
+		WithProviderConfigs(cfg.ProviderConfigs.Providers)
+	if cfg.SmallContentThreshold != 0 {
+		sessionConfig = sessionConfig.WithSmallContentThreshold(cfg.SmallContentThreshold)
+	}
+	if cfg.LargeContentThreshold != 0 {
+		sessionConfig = sessionConfig.WithLargeContentThreshold(cfg.LargeContentThreshold)
+	}
+	if err := cfg.RetryPolicies.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.ScoringWeights != nil {
+		if err := cfg.ScoringWeights.Validate(); err != nil {
+			return nil, err
+		}
+		sessionConfig = sessionConfig.WithScoringWeights(*cfg.ScoringWeights)
+	}
+	if cfg.Region != "" {
+		sessionConfig = sessionConfig.WithRegion(cfg.Region)
+	}
+	if cfg.CircuitBreaker != nil {
+		sessionConfig = sessionConfig.WithCircuitBreaker(*cfg.CircuitBreaker)
+	}
 	session := session.NewSession(sessionConfig, true)
+	var lists *providerLists
+	if cfg.ProviderListSources != nil {
+		lists = newProviderLists(*cfg.ProviderListSources, session)
+		if err := lists.reload(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ReputationPersistence != nil {
+		if err := session.Persist(ctx, *cfg.ReputationPersistence); err != nil {
+			return nil, err
+		}
+	}
 
 	if len(cfg.Protocols) == 0 {
 		cfg.Protocols = []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1, multicodec.TransportIpfsGatewayHttp}
 	}
 
-	protocolRetrievers := make(map[multicodec.Code]types.CandidateRetriever)
-	for _, protocol := range cfg.Protocols {
-		switch protocol {
-		case multicodec.TransportGraphsyncFilecoinv1:
-			retrievalClient, err := client.NewClient(ctx, datastore, cfg.Host)
-			if err != nil {
+	// the protocols each provider is identified as supporting are cached so
+	// that candidates it can't serve are skipped by later retrievals
+	var capabilities *retriever.CapabilityCache
+	if cfg.CapabilityTTL >= 0 {
+		ttl := cfg.CapabilityTTL
+		if ttl == 0 {
+			ttl = retriever.DefaultCapabilityTTL
+		}
+		capabilities = retriever.NewCapabilityCache(ttl)
+	}
+
+	// the libp2p host, and the retrievers that use it, are only started once
+	// a Bitswap or Graphsync retrieval has a candidate to retrieve from
+	telemetry := &swarmTelemetry{}
+	libp2pHost := newLazyHost(ctx, cfg.Host, cfg.Libp2pOptions, func(h host.Host) (map[multicodec.Code]types.CandidateRetriever, error) {
+		if capabilities != nil {
+			if err := capabilities.Watch(ctx, h); err != nil {
 				return nil, err
 			}
+		}
+		retrievers := make(map[multicodec.Code]types.CandidateRetriever)
+		var retrievalClient *client.RetrievalClient
+		var bitswapRetriever *retriever.BitswapRetriever
+		for _, protocol := range cfg.Protocols {
+			switch protocol {
+			case multicodec.TransportGraphsyncFilecoinv1:
+				var err error
+				retrievalClient, err = client.NewClient(ctx, datastore, h)
+				if err != nil {
+					return nil, err
+				}
 
-			if err := retrievalClient.AwaitReady(); err != nil { // wait for dt setup
-				return nil, err
+				if err := retrievalClient.AwaitReady(); err != nil { // wait for dt setup
+					return nil, err
+				}
+				retrievers[protocol] = retriever.NewGraphsyncRetriever(session, retrievalClient)
+			case multicodec.TransportBitswap:
+				bitswapRetriever = retriever.NewBitswapRetrieverFromHost(ctx, h, retriever.BitswapConfig{
+					BlockTimeout:            cfg.ProviderTimeout,
+					Concurrency:             cfg.BitswapConcurrency,
+					ConcurrencyPerRetrieval: cfg.BitswapConcurrencyPerRetrieval,
+					SessionIdleTimeout:      cfg.BitswapSessionIdleTimeout,
+					PreloadMaxBytes:         cfg.BitswapPreloadMaxBytes,
+					PreloadSpillDir:         cfg.BitswapPreloadSpillDir,
+				})
+				retrievers[protocol] = bitswapRetriever
+			}
+		}
+		telemetry.started(h, retrievalClient, bitswapRetriever)
+		return retrievers, nil
+	})
+
+	// a supplied host may already have connections that the retrievers need
+	// to know about, and receipts are signed with the host's identity, so in
+	// either case there's nothing to be gained from starting lazily
+	var libp2pRetrievers map[multicodec.Code]types.CandidateRetriever
+	if cfg.Host != nil || cfg.RetrievalReceipts {
+		var err error
+		libp2pRetrievers, err = libp2pHost.Retrievers()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	protocolRetrievers := make(map[multicodec.Code]types.CandidateRetriever)
+	for _, protocol := range cfg.Protocols {
+		switch protocol {
+		case multicodec.TransportGraphsyncFilecoinv1, multicodec.TransportBitswap:
+			if libp2pRetrievers != nil {
+				protocolRetrievers[protocol] = libp2pRetrievers[protocol]
+			} else {
+				protocol := protocol
+				protocolRetrievers[protocol] = retriever.NewLazyRetriever(func() (types.CandidateRetriever, error) {
+					retrievers, err := libp2pHost.Retrievers()
+					if err != nil {
+						return nil, err
+					}
+					return retrievers[protocol], nil
+				})
 			}
-			protocolRetrievers[protocol] = retriever.NewGraphsyncRetriever(session, retrievalClient)
-		case multicodec.TransportBitswap:
-			protocolRetrievers[protocol] = retriever.NewBitswapRetrieverFromHost(ctx, cfg.Host, retriever.BitswapConfig{
-				BlockTimeout:            cfg.ProviderTimeout,
-				Concurrency:             cfg.BitswapConcurrency,
-				ConcurrencyPerRetrieval: cfg.BitswapConcurrencyPerRetrieval,
-			})
 		case multicodec.TransportIpfsGatewayHttp:
-			protocolRetrievers[protocol] = retriever.NewHttpRetriever(session, http.DefaultClient)
+			protocolRetrievers[protocol] = retriever.NewHttpRetriever(session, http.DefaultClient, cfg.HttpRateLimits, cfg.HttpPrewarm)
 		}
 	}
 
-	retriever, err := retriever.NewRetriever(ctx, session, cfg.Finder, protocolRetrievers)
+	// candidates advertised without addresses are looked up before being
+	// passed on, rather than failing every retrieval attempt
+	var finder retriever.CandidateFinder = retriever.NewAddrBackfillCandidateFinder(batchCandidateFinder{cfg.Finder}, cfg.addrBackfill(libp2pHost))
+	// providers in blocked networks are dropped before anything else is done
+	// with them, such as probing them
+	if len(cfg.ProviderBlockedNetworks) > 0 {
+		finder = retriever.NewNetworkBlockCandidateFinder(finder, cfg.ProviderBlockedNetworks)
+	}
+	discoveryFinder := finder
+	// unknown providers are probed so that they aren't assumed to be average
+	finder = retriever.NewLatencyProbeCandidateFinder(finder, session, cfg.latencyProbe(libp2pHost))
+	// providers are located so that those in Lassie's region can be preferred
+	if cfg.Region != "" {
+		finder = retriever.NewRegionCandidateFinder(finder, session, cfg.RegionLocator)
+	}
+	retriever, err := retriever.NewRetriever(ctx, session, finder, protocolRetrievers, capabilities)
 	if err != nil {
 		return nil, err
 	}
+	retriever.SetRetryPolicies(cfg.RetryPolicies)
 	retriever.Start()
 
+	if cfg.RetrievalReceipts {
+		receiptSender, err := receipts.NewReceiptSender(ctx, libp2pHost.Started(), http.DefaultClient)
+		if err != nil {
+			return nil, err
+		}
+		retriever.RegisterSubscriber(receiptSender.RetrievalEventSubscriber())
+	}
+
+	if cfg.EventWebhook != nil {
+		publisher := eventwebhook.NewPublisher(ctx, *cfg.EventWebhook)
+		retriever.RegisterSubscriber(publisher.RetrievalEventSubscriber())
+	}
+
+	if cfg.AlertWebhook != nil {
+		publisher := eventwebhook.NewPublisher(ctx, *cfg.AlertWebhook)
+		retriever.RegisterSubscriber(publisher.RetrievalEventSubscriber())
+	}
+
+	for _, recorderCfg := range cfg.AggregateEventRecorders {
+		recorder := aggregateeventrecorder.NewAggregateEventRecorder(ctx, recorderCfg)
+		retriever.RegisterSubscriber(recorder.RetrievalEventSubscriber())
+	}
+
+	unregisterMetrics, err := telemetry.registerMetrics()
+	if err != nil {
+		return nil, err
+	}
+	failures, err := newFailureStats()
+	if err != nil {
+		_ = unregisterMetrics()
+		return nil, err
+	}
+	batches := &blockstoreBatchMetrics{}
+	unregisterBatchMetrics, err := batches.registerMetrics()
+	if err != nil {
+		_ = unregisterMetrics()
+		return nil, err
+	}
+	active := newActiveRetrievals()
+	var metrics *prometheusMetrics
+	if cfg.MetricsRegisterer != nil {
+		if metrics, err = newPrometheusMetrics(cfg.MetricsRegisterer, active); err != nil {
+			_ = unregisterMetrics()
+			_ = unregisterBatchMetrics()
+			return nil, err
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		_ = unregisterMetrics()
+		_ = unregisterBatchMetrics()
+		if metrics != nil {
+			metrics.unregister()
+		}
+	}()
+	if cfg.TelemetryInterval > 0 {
+		go telemetry.run(ctx, cfg.TelemetryInterval, retriever.DispatchEvent)
+	}
+	if lists != nil && cfg.ProviderListSources.RefreshInterval > 0 {
+		go lists.run(ctx)
+	}
+
 	lassie := &Lassie{
 		cfg:       cfg,
+		host:      libp2pHost,
 		retriever: retriever,
+		session:   session,
+		finder:    discoveryFinder,
+		active:    active,
+		batches:   batches,
+		failures:  failures,
+		metrics:   metrics,
+		affinity:  &affinityCounter{},
+
+		providerLists: lists,
 	}
+	lassie.globalTimeout.Store(int64(cfg.GlobalTimeout))
 
 	return lassie, nil
 }
 
+// SetGlobalTimeout changes the timeout of the retrievals that start from now
+// on, see WithGlobalTimeout, such as when a daemon reloads its configuration.
+// Retrievals in progress keep the timeout they started with.
+func (l *Lassie) SetGlobalTimeout(timeout time.Duration) {
+	l.globalTimeout.Store(int64(timeout))
+}
+
 // WithFinder allows you to specify a custom candidate finder.
 func WithFinder(finder retriever.CandidateFinder) LassieOption {
 	return func(cfg *LassieConfig) {
@@ -151,6 +416,18 @@ func WithFinder(finder retriever.CandidateFinder) LassieOption {
 	}
 }
 
+// WithContentSizeThresholds allows you to specify the sizes, in bytes, at or
+// below which content is considered small and at or above which content is
+// considered large. Candidates for small content are ordered by time to first
+// byte, candidates for large content are ordered with a preference for
+// bandwidth. A zero value leaves the default threshold in place.
+func WithContentSizeThresholds(small uint64, large uint64) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.SmallContentThreshold = small
+		cfg.LargeContentThreshold = large
+	}
+}
+
 // WithProviderTimeout allows you to specify a custom timeout for retrieving
 // data from a provider. Beyond this limit, when no data has been received,
 // the retrieval will fail.
@@ -168,7 +445,29 @@ func WithGlobalTimeout(timeout time.Duration) LassieOption {
 	}
 }
 
-// WithHost allows you to specify a custom libp2p host.
+// WithProviderConfigs overrides the provider timeout and the number of
+// concurrent retrievals per storage provider for retrievals over particular
+// protocols and from particular providers, see ProviderConfigs and
+// ParseProviderConfigs.
+func WithProviderConfigs(configs ProviderConfigs) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.ProviderConfigs = configs
+	}
+}
+
+// WithTelemetryInterval allows you to specify how often a SwarmTelemetryEvent,
+// describing the state of the libp2p swarm, is sent to subscribers. A zero
+// interval, the default, disables these events. The same state is always
+// available as gauges through the global OpenTelemetry meter provider.
+func WithTelemetryInterval(interval time.Duration) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.TelemetryInterval = interval
+	}
+}
+
+// WithHost allows you to specify a custom libp2p host. When no host is
+// supplied, one is created using the options given to WithLibp2pOpts the
+// first time a Bitswap or Graphsync retrieval needs it.
 func WithHost(host host.Host) LassieOption {
 	return func(cfg *LassieConfig) {
 		cfg.Host = host
@@ -214,6 +513,28 @@ func WithProviderAllowList(providerAllowList map[peer.ID]bool) LassieOption {
 	}
 }
 
+// WithProviderBlockedNetworks blocks the providers with an address in any of
+// the given networks, in addition to those blocked by peer ID, see
+// retriever.NetworkBlockCandidateFinder.
+func WithProviderBlockedNetworks(networks ...netip.Prefix) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.ProviderBlockedNetworks = networks
+	}
+}
+
+// WithProviderListSources loads a provider block list and allow list, of one
+// peer ID per line, from files or HTTP(S) URLs when Lassie is created, and
+// reloads them every RefreshInterval and on each call to
+// Lassie#ReloadProviderLists, so that providers can be blocked without
+// restarting Lassie. The loaded lists apply in addition to those of
+// WithProviderBlockList and WithProviderAllowList. Creating Lassie fails if
+// the lists can't be loaded, while a failed reload keeps the previous lists.
+func WithProviderListSources(sources ProviderListSources) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.ProviderListSources = &sources
+	}
+}
+
 // WithBitswapConcurrency allows you to specify a custom concurrency for bitswap
 // retrievals across all parallel retrievals in the same Lassie instance. This
 // is applied using a preloader during traversals. The default is 32.
@@ -232,17 +553,469 @@ func WithBitswapConcurrencyPerRetrieval(concurrency int) LassieOption {
 	}
 }
 
+// WithBitswapSessionPool shares a Bitswap session between retrievals that
+// start with the same set of providers, so that near-simultaneous retrievals
+// of clustered content reuse the peers and latencies that the session has
+// learned rather than each warming up sessions of its own. A session is kept
+// for idleTimeout after the last retrieval using it finishes. By default,
+// sessions aren't shared.
+func WithBitswapSessionPool(idleTimeout time.Duration) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.BitswapSessionIdleTimeout = idleTimeout
+	}
+}
+
+// WithBitswapPreloadBuffer bounds the blocks that the Bitswap preloader of
+// each retrieval fetches ahead of the traversal, which on a large DAG can
+// otherwise grow far faster than they are consumed, to maxBytes of temporary
+// storage. Beyond it, further blocks are written to a temporary CAR file in
+// spillDir, if it isn't empty, or otherwise preloading pauses until the
+// traversal catches up, at the cost of fetching the blocks it reaches first
+// one at a time. An in-memory instance, see WithInMemory, can't spill to
+// disk.
+func WithBitswapPreloadBuffer(maxBytes uint64, spillDir string) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.BitswapPreloadMaxBytes = maxBytes
+		cfg.BitswapPreloadSpillDir = spillDir
+	}
+}
+
+// WithRetrievalReceipts enables sending a signed receipt to providers after a
+// successful retrieval from them via HTTP or Graphsync. Receipts are signed
+// with the identity of the libp2p host.
+func WithRetrievalReceipts() LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.RetrievalReceipts = true
+	}
+}
+
+// WithEventWebhook publishes retrieval events, in batches of JSON, to the
+// webhook configured by the given eventwebhook.Config, until the context
+// passed to NewLassie is cancelled.
+func WithEventWebhook(cfg eventwebhook.Config) LassieOption {
+	return func(c *LassieConfig) {
+		c.EventWebhook = &cfg
+	}
+}
+
+// WithAlertWebhook publishes provider alerts, see events.ProviderAlertEvent,
+// to the webhook configured by the given eventwebhook.Config, separately from
+// any WithEventWebhook, so that they can be routed to an operator. Its Codes
+// are replaced with types.ProviderAlertCode.
+func WithAlertWebhook(cfg eventwebhook.Config) LassieOption {
+	return func(c *LassieConfig) {
+		cfg.Codes = []types.EventCode{types.ProviderAlertCode}
+		c.AlertWebhook = &cfg
+	}
+}
+
+// WithAggregateEventRecorder records an aggregate event summarising each
+// retrieval, in batches, with the Sink of the given EventRecorderConfig, or by
+// POSTing them to its EndpointURL when it has no Sink. It may be given more
+// than once to record the events with several sinks.
+func WithAggregateEventRecorder(cfg aggregateeventrecorder.EventRecorderConfig) LassieOption {
+	return func(c *LassieConfig) {
+		c.AggregateEventRecorders = append(c.AggregateEventRecorders, cfg)
+	}
+}
+
+// WithScoringWeights allows you to specify the weights that candidates are
+// scored with when choosing which to retrieve from, in place of those of
+// session.DefaultScoringWeights, for example to favour bandwidth over time to
+// first byte, or to ignore the verified deal and fast retrieval metadata of
+// Graphsync candidates.
+func WithScoringWeights(weights session.ScoringWeights) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.ScoringWeights = &weights
+	}
+}
+
+// WithCircuitBreaker replaces the circuit breaker that suspends retrievals
+// from a provider after consecutive failures, so that a flapping provider
+// doesn't fail every retrieval it is a candidate for, see
+// session.CircuitBreaker. The default is session.DefaultCircuitBreaker, and a
+// Threshold of 0 disables it.
+func WithCircuitBreaker(circuitBreaker session.CircuitBreaker) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.CircuitBreaker = &circuitBreaker
+	}
+}
+
+// WithRetryPolicies sets how many times, and after what backoff, a failed
+// attempt to retrieve from a Graphsync or HTTP candidate is retried before
+// moving on to other candidates, by default and per protocol or provider, see
+// retriever.RetryPolicies. Retrying trades the latency of a retrieval for its
+// chance of success when providers fail intermittently. The default is to
+// never retry.
+func WithRetryPolicies(policies retriever.RetryPolicies) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.RetryPolicies = policies
+	}
+}
+
+// WithRegion prefers candidates whose providers are in the given region, the
+// region that Lassie is running in, when they are otherwise comparable, such
+// as to meet time to first byte targets in CDN-style deployments. Providers are
+// located with the given RegionLocator, such as a retriever.RegionTable built
+// from a GeoIP database. The preference is weighted by the Region scoring
+// weight, see WithScoringWeights.
+func WithRegion(region string, locator retriever.RegionLocator) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.Region = region
+		cfg.RegionLocator = locator
+	}
+}
+
+// WithReputationPersistence saves the metrics that providers are scored by,
+// such as their time to first byte and success rate, to the datastore of the
+// given PersistConfig, and loads them from it when Lassie is started, so that
+// a restart doesn't lose what has been learnt about slow or broken providers.
+// Saved metrics decay with age, see session.PersistConfig. They are saved a
+// final time once the context Lassie was created with is cancelled, which
+// WaitReputationSaved waits for before the datastore is closed.
+func WithReputationPersistence(cfg session.PersistConfig) LassieOption {
+	return func(c *LassieConfig) {
+		c.ReputationPersistence = &cfg
+	}
+}
+
+// WithLatencyProbe probes the latency of candidates whose providers haven't
+// been connected to before, before they are retrieved from, so that they are
+// ordered by the round trip time measured rather than gambled on. HTTP
+// candidates are probed with a HEAD request and others with a libp2p ping,
+// unless the given LatencyProbe has a Prober of its own. The default budget is
+// DefaultLatencyProbes probes for each retrieval, of up to
+// DefaultLatencyProbeTimeout each, which delays unknown candidates by up to
+// the timeout.
+func WithLatencyProbe(probe retriever.LatencyProbe) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.LatencyProbe = &probe
+	}
+}
+
+// WithCapabilityTTL sets how long the libp2p protocols that a provider was
+// identified as supporting when connected to are relied on, during which
+// later retrievals skip its candidates for Graphsync or Bitswap if it doesn't
+// support them, reporting each with a CandidateSkippedEvent. The default is
+// retriever.DefaultCapabilityTTL, and a negative TTL disables the skipping.
+func WithCapabilityTTL(ttl time.Duration) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.CapabilityTTL = ttl
+	}
+}
+
+// WithResultStore stores the result of each retrieval as it finishes, its
+// outcome, the provider it was retrieved from and a summary of its stats, in
+// the given store, to be queried with QueryResults.
+func WithResultStore(store *resultstore.Store) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.ResultStore = store
+	}
+}
+
+// WithAddrBackfill configures the lookup of addresses for candidates that are
+// advertised to the indexer without any, which are otherwise dropped. The
+// libp2p host's peerstore is always consulted first, and the Router of the
+// given AddrBackfill, such as a DHT, after it. The default budget is
+// DefaultAddrBackfillLookups lookups for each retrieval, of up to
+// DefaultAddrBackfillTimeout each. A negative MaxLookups disables the lookups.
+func WithAddrBackfill(backfill retriever.AddrBackfill) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.AddrBackfill = backfill
+	}
+}
+
+// WithMaxBlockSize allows you to specify the maximum size, in bytes, of a
+// single block received from a provider over any protocol. Providers that send
+// larger blocks are treated as having failed the retrieval. The default is
+// 2 MiB.
+func WithMaxBlockSize(maxBlockSize uint64) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.MaxBlockSize = maxBlockSize
+	}
+}
+
+// WithProviderQueryLimits allows you to bound the number of candidates
+// accepted from the candidate finder, and the number of providers queried over
+// Graphsync and HTTP, for each retrieval. Zero values mean no limit, which is
+// the default.
+func WithProviderQueryLimits(limits types.ProviderQueryLimits) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.ProviderQueryLimits = limits
+	}
+}
+
+// WithHttpRateLimits allows you to limit the rate of requests made to each
+// HTTP provider, with a default limit applied to every provider and overrides
+// for particular hosts. Requests over the limit are delayed rather than
+// dropped, smoothing bursts from parallel retrievals so that rate limited
+// providers aren't tripped into responding with 429s. The default is no
+// limit.
+func WithHttpRateLimits(limits retriever.HttpRateLimits) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.HttpRateLimits = limits
+	}
+}
+
+// WithBlockstoreBatching allows you to batch the blocks written by
+// FetchIntoBlockstore into group commits, made with the Blockstore's PutMany,
+// rather than putting each block as it arrives. This greatly improves
+// throughput with Blockstores that commit each write in a transaction, such as
+// those backed by Badger or Pebble, at the cost of blocks only being visible
+// to other users of the Blockstore once their batch is committed. All blocks
+// are committed before FetchIntoBlockstore returns. The default is to put
+// each block as it arrives.
+func WithBlockstoreBatching(cfg storage.BlockstoreBatchConfig) LassieOption {
+	return func(lcfg *LassieConfig) {
+		lcfg.BlockstoreBatch = &cfg
+	}
+}
+
+// WithHttpPrewarm allows you to warm up the connections to high latency HTTP
+// providers before retrieving from them, by making a few small requests in
+// parallel so that the connections the retrieval reuses are past TCP or QUIC
+// slow start. This improves the throughput of large retrievals from distant
+// providers at the cost of the extra requests, which are reported in the
+// Prewarm of the RetrievalStats. The default is not to prewarm.
+func WithHttpPrewarm(prewarm retriever.HttpPrewarm) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.HttpPrewarm = prewarm
+	}
+}
+
+// WithInMemory guarantees that the Lassie instance never touches disk, for
+// environments with a read-only filesystem or strict data-handling rules. The
+// temporary storage used by FetchToWriter, FetchIntoBlockstore, FetchBlocks
+// and FetchNodes is held in memory rather than in CAR files in the system's
+// temporary directory, so memory use grows with the size of the content being
+// retrieved. Lassie's other state, its datastore and the peerstore of a host
+// it creates, is always in memory. Components built on the instance, such as
+// the HTTP server, fail to construct with ErrDiskAccess if configured to use
+// disk.
+func WithInMemory() LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.InMemory = true
+	}
+}
+
+// WithTempStore sets the storage that FetchToWriter, FetchIntoBlockstore,
+// FetchBlocks and FetchNodes use to hold blocks temporarily, in place of the
+// CAR files in the system's temporary directory, or in memory with
+// WithInMemory. Each retrieval gets a new TempStore from factory, which it
+// closes when it's done. The storage package has factories for CAR files in
+// a directory of choice, for memory and for any Blockstore, such as one over
+// a badger or flatfs datastore. It can be replaced for a single retrieval with
+// types.WithTempStore. An in-memory instance relies on factory not to touch
+// disk.
+func WithTempStore(factory types.TempStoreFactory) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.TempStore = factory
+	}
+}
+
+// WithInMemoryThreshold holds the temporary blocks of each retrieval made by
+// FetchToWriter, FetchIntoBlockstore, FetchBlocks and FetchNodes in memory
+// until they exceed threshold bytes, only then moving them to a CAR file in
+// the system's temporary directory, see storage.SpillingTempStore. Small
+// retrievals, the majority of those made by a gateway, then never create a
+// temporary file. It has no effect with WithInMemory or WithTempStore.
+func WithInMemoryThreshold(threshold uint64) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.InMemoryThreshold = threshold
+	}
+}
+
+// WithBlockCache adds every block retrieved by the instance to the cache, and
+// serves retrievals whose blocks are all in the cache from it, without
+// contacting any provider, see RetrievalStats#BlockCacheHit. A long-lived
+// instance, such as that of a daemon, serves more of its retrievals locally
+// the more content it has retrieved. Retrievals with a LinkPolicy are always
+// retrieved from providers, though their blocks are added to the cache.
+func WithBlockCache(cache *blockcache.Cache) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.BlockCache = cache
+	}
+}
+
+// WithLogger routes lassie's logs to the given structured logger, rather than
+// go-log, with the subsystem each came from and, for those about a retrieval,
+// its ID. Logging is shared by the whole process, so this is the same as
+// calling logging.SetLogger and applies to every Lassie instance.
+func WithLogger(logger logging.Logger) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.Logger = logger
+	}
+}
+
+// WithLogLevel sets the minimum level of the logs written by a subsystem, e.g.
+// "lassie/retriever". Like WithLogger, it applies to the whole process, see
+// logging.SetLevel.
+func WithLogLevel(subsystem string, level logging.Level) LassieOption {
+	return func(cfg *LassieConfig) {
+		if cfg.LogLevels == nil {
+			cfg.LogLevels = make(map[string]logging.Level)
+		}
+		cfg.LogLevels[subsystem] = level
+	}
+}
+
 // Fetch initiates a retrieval request and returns either some details about
 // the retrieval or an error. The request should contain all of the parameters
 // of the requested retrieval, including the LinkSystem where the blocks are
 // intended to be stored.
-func (l *Lassie) Fetch(ctx context.Context, request types.RetrievalRequest, opts ...types.FetchOption) (*types.RetrievalStats, error) {
-	var cancel context.CancelFunc
-	if l.cfg.GlobalTimeout != time.Duration(0) {
-		ctx, cancel = context.WithTimeout(ctx, l.cfg.GlobalTimeout)
+func (l *Lassie) Fetch(ctx context.Context, request types.RetrievalRequest, opts ...types.FetchOption) (stats *types.RetrievalStats, err error) {
+	fetchCfg := types.NewFetchConfig(opts...)
+	if request.RetrievalID == (types.RetrievalID{}) {
+		var err error
+		if request.RetrievalID, err = types.NewRetrievalID(); err != nil {
+			return nil, err
+		}
+	}
+	ctx, unregister, err := l.active.register(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer unregister()
+	ctx, span := tracer.Start(ctx, "Fetch", trace.WithAttributes(
+		attribute.String("retrievalId", request.RetrievalID.String()),
+		attribute.String("root", request.Root.String()),
+		attribute.String("path", request.Path),
+		attribute.String("dagScope", string(request.Scope)),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	cancelCtx := ctx
+	globalTimeout := time.Duration(l.globalTimeout.Load())
+	if fetchCfg.GlobalTimeout != time.Duration(0) {
+		globalTimeout = fetchCfg.GlobalTimeout
+	}
+	if globalTimeout != time.Duration(0) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, globalTimeout)
 		defer cancel()
 	}
-	return l.retriever.Retrieve(ctx, request, types.NewFetchConfig(opts...).EventsCallback)
+	if fetchCfg.ProviderTimeout != time.Duration(0) {
+		request.ProviderTimeout = fetchCfg.ProviderTimeout
+	}
+	if fetchCfg.MaxAttempts > 0 {
+		request.MaxAttempts = fetchCfg.MaxAttempts
+	}
+	if request.MaxBlockSize == 0 {
+		request.MaxBlockSize = l.cfg.MaxBlockSize
+	}
+	if request.QueryLimits == (types.ProviderQueryLimits{}) {
+		request.QueryLimits = l.cfg.ProviderQueryLimits
+	}
+	// use the lowest non-zero value for the block, byte and time budgets
+	if fetchCfg.MaxBlocks > 0 && (request.MaxBlocks == 0 || fetchCfg.MaxBlocks < request.MaxBlocks) {
+		request.MaxBlocks = fetchCfg.MaxBlocks
+	}
+	if fetchCfg.MaxBytes > 0 && (request.MaxBytes == 0 || fetchCfg.MaxBytes < request.MaxBytes) {
+		request.MaxBytes = fetchCfg.MaxBytes
+	}
+	if fetchCfg.MaxDuration > 0 && (request.MaxDuration == 0 || fetchCfg.MaxDuration < request.MaxDuration) {
+		request.MaxDuration = fetchCfg.MaxDuration
+	}
+	if len(fetchCfg.Protocols) > 0 {
+		request.Protocols = fetchCfg.Protocols
+	}
+	if fetchCfg.LinkPolicy != nil {
+		request.LinkPolicy = fetchCfg.LinkPolicy
+	}
+	if fetchCfg.PauseControl != nil {
+		request.PauseControl = fetchCfg.PauseControl
+	}
+	if fetchCfg.ProviderAllowList != nil {
+		request.ProviderAllowList = fetchCfg.ProviderAllowList
+	}
+	if fetchCfg.ProviderBlockList != nil {
+		request.ProviderBlockList = fetchCfg.ProviderBlockList
+	}
+	if fetchCfg.AffinityKey != "" {
+		request.AffinityKey = fetchCfg.AffinityKey
+	}
+	if fetchCfg.MaxDepth > 0 {
+		if request, err = request.WithMaxDepth(fetchCfg.MaxDepth); err != nil {
+			return nil, err
+		}
+	}
+	if fetchCfg.ExpectedDigest != nil {
+		if err := checkDigestRequest(request); err != nil {
+			return nil, err
+		}
+	}
+	requestHash, err := request.CanonicalHash()
+	if err != nil {
+		return nil, err
+	}
+	failures := newFailureTracker(ctx)
+	var metrics *fetchMetrics
+	if l.metrics != nil {
+		metrics = l.metrics.startFetch()
+	}
+	var subscribers *requestSubscribers
+	if len(fetchCfg.Subscribers) > 0 {
+		subscribers = l.registerRequestSubscribers(request.RetrievalID, fetchCfg.Subscribers)
+		defer subscribers.close()
+	}
+	var progress *progressTracker
+	if fetchCfg.Progress != nil {
+		progress = newProgressTracker(request, fetchCfg.Progress)
+		request.LinkSystem = progress.linkSystem(request.LinkSystem)
+	}
+	eventsCallback := func(event types.RetrievalEvent) {
+		failures.onEvent(event)
+		if metrics != nil {
+			metrics.onEvent(event)
+		}
+		if progress != nil {
+			progress.onEvent(event)
+		}
+		if subscribers != nil {
+			subscribers.onEvent(event)
+		}
+		if fetchCfg.EventsCallback != nil {
+			fetchCfg.EventsCallback(event)
+		}
+	}
+	if l.cfg.BlockCache != nil {
+		stats, err = l.fetchFromBlockCache(ctx, request)
+		request.LinkSystem = l.writeThroughBlockCache(request.LinkSystem)
+	}
+	if stats == nil && err == nil {
+		stats, err = l.retriever.Retrieve(ctx, request, eventsCallback)
+	}
+	if err != nil && errors.Is(context.Cause(cancelCtx), ErrRetrievalCancelled) {
+		err = fmt.Errorf("%w: %w", ErrRetrievalCancelled, err)
+	}
+	if err == nil && fetchCfg.NestedCars != nil {
+		stats.NestedCars, err = expandNestedCars(ctx, request, *fetchCfg.NestedCars)
+	}
+	if err == nil && fetchCfg.ExpectedDigest != nil {
+		err = verifyDigest(ctx, request, stats, fetchCfg.ExpectedDigest)
+	}
+	l.failures.record(failures, err)
+	if l.cfg.ResultStore != nil {
+		l.storeResult(request, requestHash, stats, failures, err)
+	}
+	if err == nil {
+		l.affinity.record(request, stats)
+	}
+	if metrics != nil {
+		metrics.finish(stats, failures, err)
+	}
+	if stats != nil {
+		stats.RequestHash = requestHash
+	}
+	if progress != nil {
+		progress.finish(err)
+	}
+	return stats, err
 }
 
 // RegisterSubscriber registers a subscriber to receive retrieval events.
@@ -250,3 +1023,41 @@ func (l *Lassie) Fetch(ctx context.Context, request types.RetrievalRequest, opts
 func (l *Lassie) RegisterSubscriber(subscriber types.RetrievalEventSubscriber) func() {
 	return l.retriever.RegisterSubscriber(subscriber)
 }
+
+// DispatchEvent dispatches an event that isn't part of a retrieval, such as an
+// events.NameResolvedEvent, to the subscribers of this instance.
+func (l *Lassie) DispatchEvent(event types.RetrievalEvent) {
+	l.retriever.DispatchEvent(event)
+}
+
+// FlushEvents waits until the events of this instance so far have been
+// delivered to its subscribers, or the context is done, so that they may be
+// recorded before shutting down. Events are otherwise delivered
+// asynchronously, after the retrievals they belong to have returned.
+func (l *Lassie) FlushEvents(ctx context.Context) error {
+	return l.retriever.FlushEvents(ctx)
+}
+
+// WaitReputationSaved waits until the provider metrics have been saved a final
+// time after the context this instance was created with is cancelled, see
+// WithReputationPersistence, or the given context is done. It returns
+// immediately without reputation persistence.
+func (l *Lassie) WaitReputationSaved(ctx context.Context) error {
+	return l.session.WaitPersisted(ctx)
+}
+
+// RegisterFilteredSubscriber registers a subscriber to receive only the
+// retrieval events matching the filter, such as those of a single retrieval
+// or protocol. The returned function can be called to unregister the
+// subscriber. events.TypedSubscriber can be used to receive events of a
+// single type.
+func (l *Lassie) RegisterFilteredSubscriber(filter events.Filter, subscriber types.RetrievalEventSubscriber) func() {
+	return l.retriever.RegisterSubscriber(events.FilteredSubscriber(filter, subscriber))
+}
+
+// SessionState returns a snapshot of the statistics that this instance holds
+// for each provider and scores them with, such as their success rates, times
+// to first byte and whether they're blocked, for dashboards and debugging.
+func (l *Lassie) SessionState() session.Snapshot {
+	return l.session.Snapshot()
+}
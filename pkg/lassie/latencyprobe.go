@@ -0,0 +1,88 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multicodec"
+)
+
+// DefaultLatencyProbes and DefaultLatencyProbeTimeout are the budget of
+// latency probes made for each retrieval, see WithLatencyProbe.
+const (
+	DefaultLatencyProbes       = 8
+	DefaultLatencyProbeTimeout = time.Second
+)
+
+var errNoProbe = errors.New("no way to probe candidate")
+
+// latencyProbe fills in the defaults of the configured LatencyProbe, probing
+// with an HTTP HEAD request or a libp2p ping if no Prober is configured.
+func (cfg *LassieConfig) latencyProbe(h *lazyHost) retriever.LatencyProbe {
+	if cfg.LatencyProbe == nil {
+		return retriever.LatencyProbe{}
+	}
+	probe := *cfg.LatencyProbe
+	if probe.Prober == nil {
+		probe.Prober = candidateProber{h: h, client: http.DefaultClient}
+	}
+	if probe.MaxProbes == 0 {
+		probe.MaxProbes = DefaultLatencyProbes
+	}
+	if probe.Timeout == 0 {
+		probe.Timeout = DefaultLatencyProbeTimeout
+	}
+	return probe
+}
+
+// candidateProber probes HTTP candidates with a HEAD request for the root, and
+// other candidates with a libp2p ping once the libp2p host has been started.
+// The host isn't started just to probe, so that retrievals satisfied entirely
+// over HTTP never pay the cost of starting it.
+type candidateProber struct {
+	h      *lazyHost
+	client *http.Client
+}
+
+func (cp candidateProber) Probe(ctx context.Context, candidate types.RetrievalCandidate) (time.Duration, error) {
+	if candidate.Metadata.Get(multicodec.TransportIpfsGatewayHttp) != nil {
+		candidateURL, err := candidate.ToURL()
+		if err != nil {
+			return 0, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("%s/ipfs/%s", candidateURL, candidate.RootCid), nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Accept", "application/vnd.ipld.car")
+		start := time.Now()
+		resp, err := cp.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		rtt := time.Since(start)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return rtt, nil
+	}
+
+	h := cp.h.Started()
+	if h == nil {
+		return 0, errNoProbe
+	}
+	h.Peerstore().AddAddrs(candidate.MinerPeer.ID, candidate.MinerPeer.Addrs, peerstore.TempAddrTTL)
+	select {
+	case result := <-ping.Ping(ctx, h, candidate.MinerPeer.ID):
+		return result.RTT, result.Error
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
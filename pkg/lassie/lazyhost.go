@@ -0,0 +1,65 @@
+package lassie
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multicodec"
+)
+
+// lazyHost holds the libp2p host and the retrievers that use it, starting
+// them the first time they're needed so that retrievals which are satisfied
+// entirely over HTTP never pay the cost of starting a host.
+//
+// The retrievers are started together, immediately after the host, as they
+// only learn of peers through the connections made after they start.
+type lazyHost struct {
+	lk         sync.Mutex
+	ctx        context.Context
+	opts       []libp2p.Option
+	start      func(host.Host) (map[multicodec.Code]types.CandidateRetriever, error)
+	h          host.Host
+	retrievers map[multicodec.Code]types.CandidateRetriever
+}
+
+func newLazyHost(
+	ctx context.Context,
+	h host.Host,
+	opts []libp2p.Option,
+	start func(host.Host) (map[multicodec.Code]types.CandidateRetriever, error),
+) *lazyHost {
+	return &lazyHost{ctx: ctx, opts: opts, start: start, h: h}
+}
+
+// Retrievers returns the retrievers that use the host, starting the host and
+// the retrievers if they haven't been started yet.
+func (lh *lazyHost) Retrievers() (map[multicodec.Code]types.CandidateRetriever, error) {
+	lh.lk.Lock()
+	defer lh.lk.Unlock()
+	if lh.retrievers != nil {
+		return lh.retrievers, nil
+	}
+	if lh.h == nil {
+		h, err := host.InitHost(lh.ctx, lh.opts)
+		if err != nil {
+			return nil, err
+		}
+		lh.h = h
+	}
+	retrievers, err := lh.start(lh.h)
+	if err != nil {
+		return nil, err
+	}
+	lh.retrievers = retrievers
+	return retrievers, nil
+}
+
+// Started returns the host if it has been started, or nil if it hasn't.
+func (lh *lazyHost) Started() host.Host {
+	lh.lk.Lock()
+	defer lh.lk.Unlock()
+	return lh.h
+}
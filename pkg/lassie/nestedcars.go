@@ -0,0 +1,223 @@
+package lassie
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipld/go-car/v2"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	ipldtraversal "github.com/ipld/go-ipld-prime/traversal"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ErrInvalidNestedCar is returned by Fetch when a nested CAR being expanded
+// can't be parsed, holds a block that doesn't match its CID or is missing
+// blocks of the DAGs under its roots.
+var ErrInvalidNestedCar = errors.New("invalid nested CAR")
+
+// nestedCarExpander finds CAR files in a DAG and expands them, see
+// types.WithNestedCars.
+type nestedCarExpander struct {
+	ctx      context.Context
+	cfg      types.NestedCarConfig
+	out      linking.LinkSystem
+	seen     map[cid.Cid]struct{}
+	expanded uint64
+}
+
+// expandNestedCars expands the nested CARs in the DAG retrieved for the
+// request, writing their blocks to the request's LinkSystem, and returns the
+// number of CARs expanded.
+func expandNestedCars(ctx context.Context, request types.RetrievalRequest, cfg types.NestedCarConfig) (uint64, error) {
+	if request.LinkSystem.StorageReadOpener == nil {
+		return 0, errors.New("expanding nested CARs requires readable storage")
+	}
+	e := &nestedCarExpander{
+		ctx:  ctx,
+		cfg:  cfg.WithDefaults(),
+		out:  request.LinkSystem,
+		seen: make(map[cid.Cid]struct{}),
+	}
+	// the retrieval may not have fetched the whole DAG under the root, e.g.
+	// for a path or a narrower scope, so blocks that are missing are skipped
+	if err := e.walk(request.LinkSystem, request.Root, 0, false); err != nil {
+		return e.expanded, err
+	}
+	return e.expanded, nil
+}
+
+// walk visits the DAG under root looking for files that are CARs. level is the
+// number of CARs the DAG is nested within, and complete is true when every
+// block of the DAG should be present.
+func (e *nestedCarExpander) walk(lsys linking.LinkSystem, root cid.Cid, level int, complete bool) error {
+	if _, ok := e.seen[root]; ok {
+		return nil
+	}
+	e.seen[root] = struct{}{}
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+
+	lnkCtx := linking.LinkContext{Ctx: e.ctx}
+	lnk := cidlink.Link{Cid: root}
+	switch multicodec.Code(root.Prefix().Codec) {
+	case multicodec.Raw:
+		// a raw block reached from anything but a file is a file of its own
+		raw, err := lsys.LoadRaw(lnkCtx, lnk)
+		if err != nil {
+			return e.missing(root, complete, err)
+		}
+		return e.expand(root, bytes.NewReader(raw), uint64(len(raw)), level+1, complete)
+	case multicodec.DagPb:
+		node, err := lsys.Load(lnkCtx, lnk, dagpb.Type.PBNode)
+		if err != nil {
+			return e.missing(root, complete, err)
+		}
+		pbNode := node.(dagpb.PBNode)
+		if pbNode.FieldData().Exists() {
+			ufsData, err := data.DecodeUnixFSData(pbNode.FieldData().Must().Bytes())
+			if err == nil && (ufsData.FieldDataType().Int() == data.Data_File || ufsData.FieldDataType().Int() == data.Data_Raw) {
+				f, err := file.NewUnixFSFile(e.ctx, pbNode, &lsys)
+				if err != nil {
+					return e.missing(root, complete, err)
+				}
+				rdr, err := f.AsLargeBytes()
+				if err != nil {
+					return e.missing(root, complete, err)
+				}
+				var size uint64
+				if ufsData.FieldFileSize().Exists() {
+					size = uint64(ufsData.FieldFileSize().Must().Int())
+				}
+				return e.expand(root, rdr, size, level+1, complete)
+			}
+		}
+		for iter := pbNode.FieldLinks().Iterator(); !iter.Done(); {
+			_, link := iter.Next()
+			if err := e.walk(lsys, link.FieldHash().Link().(cidlink.Link).Cid, level, complete); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		node, err := lsys.Load(lnkCtx, lnk, basicnode.Prototype.Any)
+		if err != nil {
+			return e.missing(root, complete, err)
+		}
+		links, err := ipldtraversal.SelectLinks(node)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if err := e.walk(lsys, link.(cidlink.Link).Cid, level, complete); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// missing handles a block of the DAG that couldn't be loaded.
+func (e *nestedCarExpander) missing(c cid.Cid, complete bool, err error) error {
+	if complete {
+		return fmt.Errorf("%w: failed to load %s: %w", ErrInvalidNestedCar, c, err)
+	}
+	return nil
+}
+
+// expand expands the file at c, at the given level of nesting, if it's a CAR
+// within the configured bounds.
+func (e *nestedCarExpander) expand(c cid.Cid, rdr io.ReadSeeker, size uint64, level int, complete bool) error {
+	if level > e.cfg.MaxDepth {
+		return nil
+	}
+	if size > e.cfg.MaxBytes {
+		return nil // left as it is, whether it's a CAR or not
+	}
+	if _, err := car.ReadVersion(rdr); err != nil {
+		return nil // not a CAR
+	}
+	if _, err := rdr.Seek(0, io.SeekStart); err != nil {
+		return e.missing(c, complete, err)
+	}
+	carBytes, err := io.ReadAll(io.LimitReader(rdr, int64(e.cfg.MaxBytes)+1))
+	if err != nil {
+		return e.missing(c, complete, err)
+	}
+	if uint64(len(carBytes)) > e.cfg.MaxBytes {
+		return nil
+	}
+
+	// the block reader checks each block against its CID
+	blockReader, err := car.NewBlockReader(bytes.NewReader(carBytes))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidNestedCar, c, err)
+	}
+	store := &memstore.Store{}
+	var blks []blocks.Block
+	for {
+		blk, err := blockReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidNestedCar, c, err)
+		}
+		key := blk.Cid().KeyString()
+		if has, _ := store.Has(e.ctx, key); has {
+			continue
+		}
+		if err := store.Put(e.ctx, key, blk.RawData()); err != nil {
+			return err
+		}
+		blks = append(blks, blk)
+	}
+
+	inner := cidlink.DefaultLinkSystem()
+	inner.SetReadStorage(store)
+	inner.TrustedStorage = true
+	for _, root := range blockReader.Roots {
+		if _, err := (traversal.Config{
+			Root:     root,
+			Selector: selectorparse.CommonSelector_ExploreAllRecursively,
+		}).Traverse(e.ctx, inner, nil); err != nil {
+			return fmt.Errorf("%w: %s: incomplete DAG under root %s: %w", ErrInvalidNestedCar, c, root, err)
+		}
+	}
+
+	lnkCtx := linking.LinkContext{Ctx: e.ctx}
+	for _, blk := range blks {
+		w, commit, err := e.out.StorageWriteOpener(lnkCtx)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(blk.RawData()); err != nil {
+			return err
+		}
+		if err := commit(cidlink.Link{Cid: blk.Cid()}); err != nil {
+			return err
+		}
+	}
+	e.expanded++
+
+	for _, root := range blockReader.Roots {
+		if err := e.walk(inner, root, level, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package lassie
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+)
+
+var progressPhaseOrder = map[types.ProgressPhase]int{
+	types.ProgressFindingCandidates: 0,
+	types.ProgressConnecting:        1,
+	types.ProgressTransferring:      2,
+	types.ProgressFinished:          3,
+}
+
+// progressTracker builds the ProgressUpdates for a retrieval from its events
+// and the blocks written to its LinkSystem, delivering them to the callback
+// one at a time.
+type progressTracker struct {
+	lk       sync.Mutex
+	callback func(types.ProgressUpdate)
+	start    time.Time
+	expected uint64 // expected size in bytes, zero if unknown
+	lastSent time.Time
+	update   types.ProgressUpdate
+}
+
+func newProgressTracker(request types.RetrievalRequest, callback func(types.ProgressUpdate)) *progressTracker {
+	var expected uint64
+	if request.Bytes != nil && request.Bytes.From >= 0 && request.Bytes.To != nil && *request.Bytes.To >= request.Bytes.From {
+		expected = uint64(*request.Bytes.To - request.Bytes.From + 1)
+	}
+	if request.MaxBytes > 0 && (expected == 0 || request.MaxBytes < expected) {
+		expected = request.MaxBytes
+	}
+	pt := &progressTracker{
+		callback: callback,
+		start:    time.Now(),
+		expected: expected,
+		update:   types.ProgressUpdate{RetrievalID: request.RetrievalID},
+	}
+	pt.lk.Lock()
+	defer pt.lk.Unlock()
+	pt.setPhase(types.ProgressFindingCandidates)
+	return pt
+}
+
+// setPhase moves the retrieval on to the given phase, sending an update if it
+// is a later phase than the current one. Must be called with the lock held.
+func (pt *progressTracker) setPhase(phase types.ProgressPhase) bool {
+	if pt.lastSent != (time.Time{}) && progressPhaseOrder[phase] <= progressPhaseOrder[pt.update.Phase] {
+		return false
+	}
+	pt.update.Phase = phase
+	pt.send()
+	return true
+}
+
+// send delivers the current state to the callback. Must be called with the
+// lock held.
+func (pt *progressTracker) send() {
+	now := time.Now()
+	pt.lastSent = now
+	pt.update.Elapsed = now.Sub(pt.start)
+	pt.update.ETA = 0
+	if pt.expected > 0 && pt.update.BytesVerified > 0 && pt.update.BytesVerified < pt.expected {
+		rate := float64(pt.update.BytesVerified) / pt.update.Elapsed.Seconds()
+		pt.update.ETA = time.Duration(float64(pt.expected-pt.update.BytesVerified) / rate * float64(time.Second))
+	}
+	pt.callback(pt.update)
+}
+
+// sendThrottled sends an update if none has been sent for ProgressInterval.
+// Must be called with the lock held.
+func (pt *progressTracker) sendThrottled() {
+	if pt.update.Phase == types.ProgressFinished || time.Since(pt.lastSent) < types.ProgressInterval {
+		return
+	}
+	pt.send()
+}
+
+func (pt *progressTracker) onEvent(event types.RetrievalEvent) {
+	pt.lk.Lock()
+	defer pt.lk.Unlock()
+	switch evt := event.(type) {
+	case events.StartedRetrievalEvent:
+		pt.setPhase(types.ProgressConnecting)
+	case events.FirstByteEvent:
+		pt.update.Provider = evt.ProviderId()
+		pt.update.Protocol = evt.Protocol()
+		pt.setPhase(types.ProgressTransferring)
+	case events.BlockReceivedEvent:
+		pt.update.BytesReceived += evt.ByteCount()
+		pt.update.Provider = evt.ProviderId()
+		pt.update.Protocol = evt.Protocol()
+		if !pt.setPhase(types.ProgressTransferring) {
+			pt.sendThrottled()
+		}
+	}
+}
+
+// linkSystem returns a copy of the LinkSystem that counts the blocks verified
+// and written to it.
+func (pt *progressTracker) linkSystem(lsys linking.LinkSystem) linking.LinkSystem {
+	bwo := lsys.StorageWriteOpener
+	if bwo == nil {
+		return lsys
+	}
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		cw := &countingWriter{w: w}
+		return cw, func(lnk datamodel.Link) error {
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			pt.lk.Lock()
+			defer pt.lk.Unlock()
+			pt.update.BlocksVerified++
+			pt.update.BytesVerified += cw.n
+			pt.sendThrottled()
+			return nil
+		}, nil
+	}
+	return lsys
+}
+
+func (pt *progressTracker) finish(err error) {
+	pt.lk.Lock()
+	defer pt.lk.Unlock()
+	pt.update.Err = err
+	pt.setPhase(types.ProgressFinished)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,174 @@
+package lassie
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// prometheusMetrics are the Prometheus collectors registered with the
+// LassieConfig's MetricsRegisterer, see WithMetricsRegisterer.
+type prometheusMetrics struct {
+	reg prometheus.Registerer
+
+	retrievals       *prometheus.CounterVec
+	failures         *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	candidates       prometheus.Histogram
+	attempts         *prometheus.CounterVec
+	attemptFailures  *prometheus.CounterVec
+	timeToFirstByte  *prometheus.HistogramVec
+	receivedBytes    *prometheus.CounterVec
+	bandwidth        prometheus.Histogram
+	activeRetrievals prometheus.GaugeFunc
+}
+
+func newPrometheusMetrics(reg prometheus.Registerer, active *activeRetrievals) (*prometheusMetrics, error) {
+	pm := &prometheusMetrics{
+		reg: reg,
+		retrievals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "retrievals_total",
+			Help:      "Number of retrievals finished, by result.",
+		}, []string{"result"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_failures_total",
+			Help:      "Number of retrievals failed, by reason and the phase reached.",
+		}, []string{"reason", "phase"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_duration_seconds",
+			Help:      "Duration of retrievals, by result.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+		}, []string{"result"}),
+		candidates: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_candidates",
+			Help:      "Number of candidates accepted from the candidate finder for each retrieval.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200},
+		}),
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_attempts_total",
+			Help:      "Number of attempts to retrieve from a provider, by protocol.",
+		}, []string{"protocol"}),
+		attemptFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_attempt_failures_total",
+			Help:      "Number of failed attempts to retrieve from a provider, by protocol and class of failure.",
+		}, []string{"protocol", "class"}),
+		timeToFirstByte: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_ttfb_seconds",
+			Help:      "Time from the start of an attempt to retrieve from a provider to its first byte, by protocol.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		}, []string{"protocol"}),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_received_bytes_total",
+			Help:      "Number of bytes received from providers, by protocol.",
+		}, []string{"protocol"}),
+		bandwidth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "lassie",
+			Name:      "retrieval_bandwidth_bytes_per_second",
+			Help:      "Average speed of successful retrievals.",
+			Buckets:   prometheus.ExponentialBuckets(64<<10, 2, 12),
+		}),
+		activeRetrievals: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "lassie",
+			Name:      "active_retrievals",
+			Help:      "Number of retrievals in progress.",
+		}, func() float64 {
+			return float64(active.count())
+		}),
+	}
+	for i, collector := range pm.collectors() {
+		if err := reg.Register(collector); err != nil {
+			for _, registered := range pm.collectors()[:i] {
+				reg.Unregister(registered)
+			}
+			return nil, err
+		}
+	}
+	return pm, nil
+}
+
+func (pm *prometheusMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		pm.retrievals,
+		pm.failures,
+		pm.duration,
+		pm.candidates,
+		pm.attempts,
+		pm.attemptFailures,
+		pm.timeToFirstByte,
+		pm.receivedBytes,
+		pm.bandwidth,
+		pm.activeRetrievals,
+	}
+}
+
+func (pm *prometheusMetrics) unregister() {
+	for _, collector := range pm.collectors() {
+		pm.reg.Unregister(collector)
+	}
+}
+
+// fetchMetrics follows the events of a single retrieval for the Prometheus
+// metrics.
+type fetchMetrics struct {
+	pm         *prometheusMetrics
+	start      time.Time
+	candidates atomic.Uint64
+}
+
+func (pm *prometheusMetrics) startFetch() *fetchMetrics {
+	return &fetchMetrics{pm: pm, start: time.Now()}
+}
+
+func (fm *fetchMetrics) onEvent(event types.RetrievalEvent) {
+	switch evt := event.(type) {
+	case events.CandidatesFilteredEvent:
+		fm.candidates.Add(uint64(len(evt.Candidates())))
+	case events.StartedRetrievalEvent:
+		fm.pm.attempts.WithLabelValues(evt.Protocol().String()).Inc()
+	case events.FirstByteEvent:
+		fm.pm.timeToFirstByte.WithLabelValues(evt.Protocol().String()).Observe(evt.Duration().Seconds())
+	case events.BlockReceivedEvent:
+		fm.pm.receivedBytes.WithLabelValues(evt.Protocol().String()).Add(float64(evt.ByteCount()))
+	case events.FailedRetrievalEvent:
+		fm.pm.attemptFailures.WithLabelValues(evt.Protocol().String(), string(classifyProviderFailure(evt.ErrorMessage()))).Inc()
+	}
+}
+
+func (fm *fetchMetrics) finish(stats *types.RetrievalStats, outcome *failureTracker, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+		fm.pm.failures.WithLabelValues(string(outcome.reason(err)), string(outcome.currentPhase())).Inc()
+	} else if stats != nil {
+		fm.pm.bandwidth.Observe(float64(stats.AverageSpeed))
+	}
+	fm.pm.retrievals.WithLabelValues(result).Inc()
+	fm.pm.duration.WithLabelValues(result).Observe(time.Since(fm.start).Seconds())
+	fm.pm.candidates.Observe(float64(fm.candidates.Load()))
+}
+
+// WithMetricsRegisterer registers Prometheus collectors for retrievals with
+// the given Registerer: the number of retrievals and their duration by
+// result, failures by reason and phase, the candidates found for each
+// retrieval, attempts to retrieve from providers and their failures by
+// protocol and class, time to first byte and bytes received by protocol, the
+// speed of successful retrievals and the number of retrievals in progress.
+// NewLassie fails if the collectors can't be registered, e.g. because another
+// instance has registered them with the same Registerer. They are unregistered
+// once the context passed to NewLassie is done.
+func WithMetricsRegisterer(reg prometheus.Registerer) LassieOption {
+	return func(cfg *LassieConfig) {
+		cfg.MetricsRegisterer = reg
+	}
+}
@@ -0,0 +1,66 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+// ErrAllProtocolsDisabled is returned by CheckProtocols when every protocol
+// this instance was configured with has been disabled.
+var ErrAllProtocolsDisabled = errors.New("all protocols are disabled")
+
+// ProtocolState describes whether one of the protocols this instance was
+// configured with is currently enabled.
+type ProtocolState struct {
+	Protocol string `json:"protocol"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// Protocols returns the state of each of the protocols this instance was
+// configured with, see WithProtocols, ordered by their multicodec code.
+func (l *Lassie) Protocols() []ProtocolState {
+	protocols := l.retriever.Protocols()
+	states := make([]ProtocolState, 0, len(protocols))
+	for _, protocol := range protocols {
+		states = append(states, ProtocolState{Protocol: protocol.String(), Enabled: l.retriever.IsProtocolEnabled(protocol)})
+	}
+	return states
+}
+
+// DisableProtocol stops new retrievals from using one of the protocols this
+// instance was configured with, for example to turn off Graphsync during an
+// incident, until it is enabled again with EnableProtocol. Retrievals already
+// in progress are unaffected, and retrievals requesting only disabled
+// protocols fail with retriever.ErrNoProtocolsEnabled. It returns an error
+// wrapping retriever.ErrProtocolNotConfigured if the instance wasn't
+// configured with the protocol.
+func (l *Lassie) DisableProtocol(protocol multicodec.Code) error {
+	if err := l.retriever.SetProtocolEnabled(protocol, false); err != nil {
+		return err
+	}
+	logger.Infow("disabled protocol", "protocol", protocol)
+	return nil
+}
+
+// EnableProtocol enables a protocol disabled with DisableProtocol for new
+// retrievals.
+func (l *Lassie) EnableProtocol(protocol multicodec.Code) error {
+	if err := l.retriever.SetProtocolEnabled(protocol, true); err != nil {
+		return err
+	}
+	logger.Infow("enabled protocol", "protocol", protocol)
+	return nil
+}
+
+// CheckProtocols returns ErrAllProtocolsDisabled if no protocol is enabled, so
+// that no retrieval can succeed.
+func (l *Lassie) CheckProtocols(ctx context.Context) error {
+	for _, state := range l.Protocols() {
+		if state.Enabled {
+			return nil
+		}
+	}
+	return ErrAllProtocolsDisabled
+}
@@ -0,0 +1,118 @@
+package lassie
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ProviderConfigs override the retrieval timeout and concurrency limit of
+// Lassie, see WithProviderTimeout and WithConcurrentSPRetrievals, for
+// retrievals over particular protocols and from particular providers, see
+// session.ProviderConfig.
+type ProviderConfigs struct {
+	// Protocols override the defaults for retrievals over each protocol.
+	Protocols map[multicodec.Code]session.ProviderConfig
+	// Providers override the defaults, and those of Protocols, for
+	// retrievals from each provider, including for particular protocols with
+	// their own Protocols.
+	Providers map[peer.ID]session.ProviderConfig
+}
+
+type providerConfigJson struct {
+	RetrievalTimeout        string                        `json:"retrievalTimeout,omitempty"`
+	MaxConcurrentRetrievals uint                          `json:"maxConcurrentRetrievals,omitempty"`
+	Protocols               map[string]providerConfigJson `json:"protocols,omitempty"`
+}
+
+type providerConfigsJson struct {
+	Protocols map[string]providerConfigJson `json:"protocols,omitempty"`
+	Providers map[string]providerConfigJson `json:"providers,omitempty"`
+}
+
+// ParseProviderConfigs reads ProviderConfigs from JSON, such as a daemon's
+// provider config file, of the form:
+//
+//	{
+//	  "protocols": {
+//	    "http": { "retrievalTimeout": "5s", "maxConcurrentRetrievals": 8 }
+//	  },
+//	  "providers": {
+//	    "12D3KooW...": {
+//	      "retrievalTimeout": "1m",
+//	      "protocols": { "graphsync": { "retrievalTimeout": "5m" } }
+//	    }
+//	  }
+//	}
+//
+// Protocols are named as they are for WithProtocols, and timeouts are Go
+// durations.
+func ParseProviderConfigs(r io.Reader) (ProviderConfigs, error) {
+	var parsed providerConfigsJson
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return ProviderConfigs{}, fmt.Errorf("invalid provider configs: %w", err)
+	}
+	protocols, err := parseProtocolProviderConfigs(parsed.Protocols)
+	if err != nil {
+		return ProviderConfigs{}, err
+	}
+	configs := ProviderConfigs{Protocols: protocols}
+	for id, cfg := range parsed.Providers {
+		providerId, err := peer.Decode(id)
+		if err != nil {
+			return ProviderConfigs{}, fmt.Errorf("invalid provider config, %q is not a peer ID: %w", id, err)
+		}
+		providerCfg, err := cfg.providerConfig()
+		if err != nil {
+			return ProviderConfigs{}, fmt.Errorf("invalid provider config for %s: %w", id, err)
+		}
+		if providerCfg.Protocols, err = parseProtocolProviderConfigs(cfg.Protocols); err != nil {
+			return ProviderConfigs{}, fmt.Errorf("invalid provider config for %s: %w", id, err)
+		}
+		if configs.Providers == nil {
+			configs.Providers = make(map[peer.ID]session.ProviderConfig)
+		}
+		configs.Providers[providerId] = providerCfg
+	}
+	return configs, nil
+}
+
+func parseProtocolProviderConfigs(parsed map[string]providerConfigJson) (map[multicodec.Code]session.ProviderConfig, error) {
+	if len(parsed) == 0 {
+		return nil, nil
+	}
+	configs := make(map[multicodec.Code]session.ProviderConfig, len(parsed))
+	for name, cfg := range parsed {
+		protocols, err := types.ParseProtocolsString(name)
+		if err != nil || len(protocols) != 1 {
+			return nil, fmt.Errorf("invalid provider config, unknown protocol %q", name)
+		}
+		if len(cfg.Protocols) > 0 {
+			return nil, fmt.Errorf("invalid provider config for %s, protocols can't be nested", name)
+		}
+		if configs[protocols[0]], err = cfg.providerConfig(); err != nil {
+			return nil, fmt.Errorf("invalid provider config for %s: %w", name, err)
+		}
+	}
+	return configs, nil
+}
+
+func (pcj providerConfigJson) providerConfig() (session.ProviderConfig, error) {
+	cfg := session.ProviderConfig{MaxConcurrentRetrievals: pcj.MaxConcurrentRetrievals}
+	if pcj.RetrievalTimeout != "" {
+		timeout, err := time.ParseDuration(pcj.RetrievalTimeout)
+		if err != nil || timeout <= 0 {
+			return session.ProviderConfig{}, fmt.Errorf("invalid retrieval timeout %q", pcj.RetrievalTimeout)
+		}
+		cfg.RetrievalTimeout = timeout
+	}
+	return cfg, nil
+}
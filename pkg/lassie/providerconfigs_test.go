@@ -0,0 +1,54 @@
+package lassie
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProviderConfigs(t *testing.T) {
+	provider := testutil.GeneratePeers(t, 1)[0]
+
+	configs, err := ParseProviderConfigs(strings.NewReader(`{
+		"protocols": {
+			"http": { "retrievalTimeout": "5s", "maxConcurrentRetrievals": 8 }
+		},
+		"providers": {
+			"` + provider.String() + `": {
+				"maxConcurrentRetrievals": 1,
+				"protocols": { "graphsync": { "retrievalTimeout": "5m" } }
+			}
+		}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, ProviderConfigs{
+		Protocols: map[multicodec.Code]session.ProviderConfig{
+			multicodec.TransportIpfsGatewayHttp: {RetrievalTimeout: 5 * time.Second, MaxConcurrentRetrievals: 8},
+		},
+		Providers: map[peer.ID]session.ProviderConfig{
+			provider: {
+				MaxConcurrentRetrievals: 1,
+				Protocols: map[multicodec.Code]session.ProviderConfig{
+					multicodec.TransportGraphsyncFilecoinv1: {RetrievalTimeout: 5 * time.Minute},
+				},
+			},
+		},
+	}, configs)
+
+	for _, invalid := range []string{
+		`{"protocols": {"ftp": {}}}`,
+		`{"protocols": {"http": {"retrievalTimeout": "soon"}}}`,
+		`{"protocols": {"http": {"protocols": {"http": {}}}}}`,
+		`{"providers": {"not a peer": {}}}`,
+		`{"providers": {"` + provider.String() + `": {"timeout": "5s"}}}`,
+	} {
+		_, err := ParseProviderConfigs(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,145 @@
+package lassie
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrNoProviderListSources is returned when reloading provider lists that
+// weren't configured with WithProviderListSources.
+var ErrNoProviderListSources = errors.New("no provider list sources configured")
+
+// ProviderListSources are the files or HTTP(S) URLs that a provider block list
+// and allow list are loaded from, see WithProviderListSources. Either may be
+// empty.
+type ProviderListSources struct {
+	BlockList string
+	AllowList string
+	// RefreshInterval is how often the lists are reloaded, 0 loads them only
+	// when Lassie is created and when Lassie#ReloadProviderLists is called.
+	RefreshInterval time.Duration
+	// HttpClient fetches lists from URLs, http.DefaultClient if nil.
+	HttpClient *http.Client
+}
+
+// ParseProviderList reads a provider list of one peer ID per line. Blank lines
+// and lines starting with "#" are ignored.
+func ParseProviderList(r io.Reader) (map[peer.ID]bool, error) {
+	list := make(map[peer.ID]bool)
+	scanner := bufio.NewScanner(r)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, err := peer.Decode(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider list line %d: %w", line, err)
+		}
+		list[id] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// providerLists loads the provider lists from their sources into the session.
+type providerLists struct {
+	sources ProviderListSources
+	session *session.Session
+
+	lk sync.Mutex
+}
+
+func newProviderLists(sources ProviderListSources, session *session.Session) *providerLists {
+	if sources.HttpClient == nil {
+		sources.HttpClient = http.DefaultClient
+	}
+	return &providerLists{sources: sources, session: session}
+}
+
+// reload loads both lists and sets them on the session, leaving the previous
+// lists in place if either fails to load.
+func (pl *providerLists) reload(ctx context.Context) error {
+	pl.lk.Lock()
+	defer pl.lk.Unlock()
+	blockList, err := pl.load(ctx, pl.sources.BlockList)
+	if err != nil {
+		return fmt.Errorf("failed to load provider block list: %w", err)
+	}
+	allowList, err := pl.load(ctx, pl.sources.AllowList)
+	if err != nil {
+		return fmt.Errorf("failed to load provider allow list: %w", err)
+	}
+	pl.session.SetProviderLists(blockList, allowList)
+	logger.Infow("Loaded provider lists", "blocked", len(blockList), "allowed", len(allowList))
+	return nil
+}
+
+func (pl *providerLists) load(ctx context.Context, source string) (map[peer.ID]bool, error) {
+	if source == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseProviderList(f)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := pl.sources.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %s from %s", res.Status, source)
+	}
+	return ParseProviderList(res.Body)
+}
+
+// run reloads the lists every RefreshInterval until the context is cancelled.
+func (pl *providerLists) run(ctx context.Context) {
+	ticker := time.NewTicker(pl.sources.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := pl.reload(ctx); err != nil {
+				logger.Warnw("Failed to reload provider lists, keeping the previous lists", "err", err)
+			}
+		}
+	}
+}
+
+// ReloadProviderLists reloads the provider block list and allow list from
+// their sources, see WithProviderListSources, such as when a daemon receives
+// a SIGHUP. If either fails to load, the previous lists are kept and the
+// error is returned.
+func (l *Lassie) ReloadProviderLists(ctx context.Context) error {
+	if l.providerLists == nil {
+		return ErrNoProviderListSources
+	}
+	return l.providerLists.reload(ctx)
+}
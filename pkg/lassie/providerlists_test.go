@@ -0,0 +1,74 @@
+package lassie
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProviderList(t *testing.T) {
+	peers := testutil.GeneratePeers(t, 2)
+
+	list, err := ParseProviderList(strings.NewReader("# blocked\n" + peers[0].String() + "\n\n  " + peers[1].String() + "  \n"))
+	require.NoError(t, err)
+	require.Equal(t, map[peer.ID]bool{peers[0]: true, peers[1]: true}, list)
+
+	_, err = ParseProviderList(strings.NewReader(peers[0].String() + "\nnot a peer\n"))
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestProviderListsReload(t *testing.T) {
+	ctx := context.Background()
+	peers := testutil.GeneratePeers(t, 3)
+
+	blockListPath := filepath.Join(t.TempDir(), "blocked")
+	require.NoError(t, os.WriteFile(blockListPath, []byte(peers[0].String()+"\n"), 0644))
+	allowList := peers[0].String() + "\n" + peers[1].String() + "\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowList == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(allowList))
+	}))
+	defer server.Close()
+
+	sess := session.NewSession(nil, false)
+	lists := newProviderLists(ProviderListSources{BlockList: blockListPath, AllowList: server.URL}, sess)
+	acceptable := func() []bool {
+		var result []bool
+		for _, p := range peers {
+			ok, _ := sess.FilterIndexerCandidate(types.RetrievalCandidate{
+				MinerPeer: peer.AddrInfo{ID: p},
+				Metadata:  metadata.Default.New(&metadata.Bitswap{}),
+			})
+			result = append(result, ok)
+		}
+		return result
+	}
+
+	require.Equal(t, []bool{true, true, true}, acceptable())
+	require.NoError(t, lists.reload(ctx))
+	require.Equal(t, []bool{false, true, false}, acceptable())
+
+	// a failed reload keeps the previous lists
+	allowList = ""
+	require.ErrorContains(t, lists.reload(ctx), "allow list")
+	require.Equal(t, []bool{false, true, false}, acceptable())
+
+	require.NoError(t, os.WriteFile(blockListPath, []byte("# nothing blocked\n"), 0644))
+	allowList = peers[2].String() + "\n"
+	require.NoError(t, lists.reload(ctx))
+	require.Equal(t, []bool{false, false, true}, acceptable())
+}
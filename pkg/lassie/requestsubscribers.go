@@ -0,0 +1,49 @@
+package lassie
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+// requestSubscribers are the subscribers attached to a single retrieval with
+// types.WithSubscriber. Events are dispatched asynchronously, so rather than
+// being unregistered when Fetch returns, which could lose the events still
+// queued, they are unregistered once they have received the retrieval's
+// FinishedEvent, the last to be dispatched.
+type requestSubscribers struct {
+	unregister func()
+	once       sync.Once
+	finished   atomic.Bool
+}
+
+func (l *Lassie) registerRequestSubscribers(retrievalID types.RetrievalID, subscribers []types.RetrievalEventSubscriber) *requestSubscribers {
+	rs := &requestSubscribers{}
+	rs.unregister = l.RegisterFilteredSubscriber(events.Filter{RetrievalIDs: []types.RetrievalID{retrievalID}}, func(event types.RetrievalEvent) {
+		for _, subscriber := range subscribers {
+			subscriber(event)
+		}
+		if _, ok := event.(events.FinishedEvent); ok {
+			rs.once.Do(rs.unregister)
+		}
+	})
+	return rs
+}
+
+// onEvent is called with each event of the retrieval as it is dispatched.
+func (rs *requestSubscribers) onEvent(event types.RetrievalEvent) {
+	if _, ok := event.(events.FinishedEvent); ok {
+		rs.finished.Store(true)
+	}
+}
+
+// close is called once the retrieval has ended, unregistering the subscribers
+// straight away if the retrieval ended before a FinishedEvent was dispatched
+// for them to wait for.
+func (rs *requestSubscribers) close() {
+	if !rs.finished.Load() {
+		rs.once.Do(rs.unregister)
+	}
+}
@@ -0,0 +1,64 @@
+package lassie
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/resultstore"
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+// ErrNoResultStore is returned by QueryResults when Lassie isn't configured
+// with a result store, see WithResultStore.
+var ErrNoResultStore = errors.New("retrieval results are not being stored")
+
+// resultStoreTimeout bounds the time taken to store the result of a
+// retrieval, which is done as it returns.
+const resultStoreTimeout = 5 * time.Second
+
+// storeResult stores the result of a finished retrieval in the result store.
+// Errors are logged rather than failing the retrieval.
+func (l *Lassie) storeResult(request types.RetrievalRequest, requestHash string, stats *types.RetrievalStats, outcome *failureTracker, err error) {
+	result := resultstore.Result{
+		RetrievalID: request.RetrievalID,
+		RequestHash: requestHash,
+		Root:        request.Root,
+		Path:        request.Path,
+		Outcome:     resultstore.OutcomeSuccess,
+		FinishedAt:  time.Now(),
+	}
+	if err != nil {
+		result.Outcome = string(outcome.reason(err))
+		result.Error = err.Error()
+	}
+	if protocol, ok := outcome.succeededProtocol(); ok {
+		result.Protocol = protocol.String()
+	}
+	if stats != nil {
+		result.Provider = stats.StorageProviderId
+		result.Size = stats.Size
+		result.Blocks = stats.Blocks
+		result.Duration = stats.Duration.String()
+		result.TimeToFirstByte = stats.TimeToFirstByte.String()
+	}
+
+	// the retrieval's context may have been cancelled, which is an outcome
+	// worth storing
+	ctx, cancel := context.WithTimeout(context.Background(), resultStoreTimeout)
+	defer cancel()
+	if err := l.cfg.ResultStore.Put(ctx, result); err != nil {
+		logger.Warnw("Failed to store retrieval result", "retrievalId", request.RetrievalID, "err", err)
+	}
+}
+
+// QueryResults returns the stored results of finished retrievals matching the
+// query, most recent first, such as the last successful retrieval of a root
+// and the provider it was retrieved from. Lassie must be configured with a
+// result store, see WithResultStore, otherwise ErrNoResultStore is returned.
+func (l *Lassie) QueryResults(ctx context.Context, query resultstore.Query) ([]resultstore.Result, error) {
+	if l.cfg.ResultStore == nil {
+		return nil, ErrNoResultStore
+	}
+	return l.cfg.ResultStore.Query(ctx, query)
+}
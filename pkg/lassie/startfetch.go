@@ -0,0 +1,76 @@
+package lassie
+
+import (
+	"context"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+// RetrievalHandle controls a retrieval started with StartFetch.
+type RetrievalHandle struct {
+	RetrievalID types.RetrievalID
+
+	pause  *types.PauseControl
+	cancel context.CancelFunc
+	done   chan struct{}
+	stats  *types.RetrievalStats
+	err    error
+}
+
+// StartFetch starts a retrieval in the background, as Fetch would perform it,
+// returning a handle that can be used to pause, resume or cancel it and to
+// wait for its result. See types.PauseControl for what pausing a retrieval
+// does for each protocol.
+func (l *Lassie) StartFetch(ctx context.Context, request types.RetrievalRequest, opts ...types.FetchOption) *RetrievalHandle {
+	if request.RetrievalID == (types.RetrievalID{}) {
+		// an ID from the random source can only fail to be generated if the
+		// source is broken, in which case Fetch reports the error
+		request.RetrievalID, _ = types.NewRetrievalID()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	handle := &RetrievalHandle{
+		RetrievalID: request.RetrievalID,
+		pause:       types.NewPauseControl(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	opts = append(opts, types.WithPauseControl(handle.pause))
+	go func() {
+		defer close(handle.done)
+		defer cancel()
+		handle.stats, handle.err = l.Fetch(ctx, request, opts...)
+	}()
+	return handle
+}
+
+// Pause pauses the retrieval, returning false if it was already paused.
+func (h *RetrievalHandle) Pause() bool {
+	return h.pause.Pause()
+}
+
+// Resume resumes the retrieval, returning false if it wasn't paused.
+func (h *RetrievalHandle) Resume() bool {
+	return h.pause.Resume()
+}
+
+// Paused returns whether the retrieval is paused.
+func (h *RetrievalHandle) Paused() bool {
+	return h.pause.Paused()
+}
+
+// Cancel ends the retrieval, whether or not it is paused.
+func (h *RetrievalHandle) Cancel() {
+	h.cancel()
+}
+
+// Done returns a channel that is closed when the retrieval has finished.
+func (h *RetrievalHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the retrieval has finished and returns its result, as
+// Fetch would.
+func (h *RetrievalHandle) Wait() (*types.RetrievalStats, error) {
+	<-h.done
+	return h.stats, h.err
+}
@@ -0,0 +1,111 @@
+package lassie
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/net/client"
+	"github.com/filecoin-project/lassie/pkg/net/host"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.Meter("lassie")
+var tracer = otel.Tracer("lassie")
+
+// swarmTelemetry samples the state of the libp2p swarm used for Bitswap and
+// Graphsync retrievals, so that retrieval failures can be correlated with
+// swarm-level conditions. Until the host is started, every sample is zero.
+type swarmTelemetry struct {
+	lk               sync.Mutex
+	host             host.Host
+	graphsyncClient  *client.RetrievalClient
+	bitswapRetriever *retriever.BitswapRetriever
+}
+
+// started records the host and the retrievers using it once they have been
+// started; the retrievers may be nil if their protocol isn't enabled.
+func (st *swarmTelemetry) started(h host.Host, graphsyncClient *client.RetrievalClient, bitswapRetriever *retriever.BitswapRetriever) {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	st.host = h
+	st.graphsyncClient = graphsyncClient
+	st.bitswapRetriever = bitswapRetriever
+}
+
+func (st *swarmTelemetry) sample(ctx context.Context, at time.Time) events.SwarmTelemetryEvent {
+	st.lk.Lock()
+	h, graphsyncClient, bitswapRetriever := st.host, st.graphsyncClient, st.bitswapRetriever
+	st.lk.Unlock()
+
+	var connectedPeers, bitswapSessions, graphsyncChannels, dials int
+	if h != nil {
+		connectedPeers = len(h.Network().Peers())
+	}
+	if bitswapRetriever != nil {
+		bitswapSessions = bitswapRetriever.ActiveRetrievals()
+	}
+	if graphsyncClient != nil {
+		// a failure to list the channels is reported as none being open
+		graphsyncChannels, _ = graphsyncClient.OpenChannels(ctx)
+		dials = graphsyncClient.DialsInProgress()
+	}
+	return events.SwarmTelemetry(at, connectedPeers, bitswapSessions, graphsyncChannels, dials)
+}
+
+// registerMetrics reports the swarm state through gauges on the global
+// OpenTelemetry meter, sampled whenever the metrics are collected. The
+// returned function unregisters the gauges.
+func (st *swarmTelemetry) registerMetrics() (func() error, error) {
+	connectedPeers, err := meter.Int64ObservableGauge("lassie.swarm.connected_peers",
+		metric.WithDescription("Number of peers connected to the libp2p host"))
+	if err != nil {
+		return nil, err
+	}
+	bitswapSessions, err := meter.Int64ObservableGauge("lassie.swarm.bitswap_sessions",
+		metric.WithDescription("Number of Bitswap retrievals in progress"))
+	if err != nil {
+		return nil, err
+	}
+	graphsyncChannels, err := meter.Int64ObservableGauge("lassie.swarm.graphsync_channels",
+		metric.WithDescription("Number of open Graphsync data transfer channels"))
+	if err != nil {
+		return nil, err
+	}
+	dials, err := meter.Int64ObservableGauge("lassie.swarm.dials_in_progress",
+		metric.WithDescription("Number of connections to Graphsync providers being established"))
+	if err != nil {
+		return nil, err
+	}
+	registration, err := meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		sample := st.sample(ctx, time.Now())
+		o.ObserveInt64(connectedPeers, int64(sample.ConnectedPeers()))
+		o.ObserveInt64(bitswapSessions, int64(sample.ActiveBitswapSessions()))
+		o.ObserveInt64(graphsyncChannels, int64(sample.OpenGraphsyncChannels()))
+		o.ObserveInt64(dials, int64(sample.DialsInProgress()))
+		return nil
+	}, connectedPeers, bitswapSessions, graphsyncChannels, dials)
+	if err != nil {
+		return nil, err
+	}
+	return registration.Unregister, nil
+}
+
+// run dispatches a SwarmTelemetryEvent every interval until the context is
+// cancelled.
+func (st *swarmTelemetry) run(ctx context.Context, interval time.Duration, dispatch func(types.RetrievalEvent)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			dispatch(st.sample(ctx, now))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package lassie
+
+import (
+	"os"
+
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+)
+
+// TempStore returns the factory of the temporary storage the instance was
+// configured with, see WithTempStore, or nil if it uses the default.
+func (l *Lassie) TempStore() types.TempStoreFactory {
+	return l.cfg.TempStore
+}
+
+// InMemoryThreshold returns the size up to which the temporary blocks of each
+// retrieval are held in memory, see WithInMemoryThreshold, or zero if they
+// aren't.
+func (l *Lassie) InMemoryThreshold() uint64 {
+	return l.cfg.InMemoryThreshold
+}
+
+// newTempStore creates the temporary storage that the Fetch variants use to
+// hold blocks that aren't written straight to the caller: the retrieval's own,
+// see types.WithTempStore, the instance's, see WithTempStore, or by default a
+// CAR in the system's temporary directory, held in memory up to the instance's
+// InMemoryThreshold, or, for an in-memory instance, in memory.
+func (l *Lassie) newTempStore(root cid.Cid, opts []types.FetchOption) (types.TempStore, error) {
+	if factory := types.NewFetchConfig(opts...).TempStore; factory != nil {
+		return factory(root)
+	}
+	if l.cfg.TempStore != nil {
+		return l.cfg.TempStore(root)
+	}
+	if l.cfg.InMemory {
+		return storage.NewDeferredStorageCarInMemory(root), nil
+	}
+	if l.cfg.InMemoryThreshold > 0 {
+		return storage.NewSpillingTempStore(os.TempDir(), root, l.cfg.InMemoryThreshold), nil
+	}
+	return storage.NewDeferredStorageCar(os.TempDir(), root), nil
+}
@@ -0,0 +1,224 @@
+// Package logging provides the loggers used throughout lassie. By default logs
+// are written with go-log, under the name of each subsystem, so they can be
+// configured with GOLOG_LOG_LEVEL and go-log's SetLogLevel as before.
+// Embedders can route them to a logger of their own with SetLogger.
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SubsystemKey is the key of the key/value pair naming the subsystem a log
+// came from, passed first to a Logger set with SetLogger.
+const SubsystemKey = "subsystem"
+
+// RetrievalIDKey is the key used to correlate logs with the retrieval they
+// belong to, see SubsystemLogger.With.
+const RetrievalIDKey = "retrievalId"
+
+// Logger is a structured logger that lassie's logs can be routed to with
+// SetLogger. Each method takes a message followed by alternating keys and
+// values. A *zap.SugaredLogger satisfies it as it is, and NewSlogLogger adapts
+// a *slog.Logger.
+type Logger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// Level is the severity of a log.
+type Level int8
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", l)
+	}
+}
+
+type sink struct {
+	Logger
+}
+
+var (
+	current atomic.Value // sink
+
+	levelsLk sync.RWMutex
+	levels   = make(map[string]Level)
+)
+
+// SetLogger routes the logs of every subsystem to the given logger, with the
+// name of the subsystem under SubsystemKey. Passing nil restores the default
+// of writing logs with go-log.
+func SetLogger(logger Logger) {
+	current.Store(sink{logger})
+}
+
+// SetLevel sets the minimum level of the logs written by a subsystem, e.g.
+// "lassie/retriever". Without one, every log is passed to a Logger set with
+// SetLogger, which is left to filter them itself, while go-log applies its
+// own configuration.
+func SetLevel(subsystem string, level Level) {
+	levelsLk.Lock()
+	levels[subsystem] = level
+	levelsLk.Unlock()
+	// the subsystem may not have been created yet, in which case Subsystem
+	// applies the level
+	_ = log.SetLogLevel(subsystem, level.String())
+}
+
+// UnsetLevel removes the level set with SetLevel for a subsystem, so that its
+// logs are again all passed to a Logger set with SetLogger, and restores the
+// level go-log was configured with for it, e.g. with GOLOG_LOG_LEVEL.
+func UnsetLevel(subsystem string) {
+	levelsLk.Lock()
+	delete(levels, subsystem)
+	levelsLk.Unlock()
+	cfg := log.GetConfig()
+	level, ok := cfg.SubsystemLevels[subsystem]
+	if !ok {
+		level = cfg.Level
+	}
+	_ = log.SetLogLevel(subsystem, zapcore.Level(level).String())
+}
+
+// ParseLevel parses the name of a Level, as returned by its String method.
+func ParseLevel(s string) (Level, error) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(s, level.String()) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Levels returns the levels set with SetLevel, by subsystem.
+func Levels() map[string]Level {
+	levelsLk.RLock()
+	defer levelsLk.RUnlock()
+	copied := make(map[string]Level, len(levels))
+	for subsystem, level := range levels {
+		copied[subsystem] = level
+	}
+	return copied
+}
+
+// Subsystems returns the names of the subsystems that have logged or may
+// log, lassie's and those of the libraries logging with go-log, sorted.
+func Subsystems() []string {
+	subsystems := log.GetSubsystems()
+	sort.Strings(subsystems)
+	return subsystems
+}
+
+func levelOf(subsystem string) (Level, bool) {
+	levelsLk.RLock()
+	defer levelsLk.RUnlock()
+	level, ok := levels[subsystem]
+	return level, ok
+}
+
+// SubsystemLogger writes the logs of a subsystem to the Logger set with
+// SetLogger, or to go-log by default.
+type SubsystemLogger struct {
+	name          string
+	golog         *zap.SugaredLogger
+	keysAndValues []interface{}
+}
+
+// Subsystem returns the logger for the named subsystem, typically held in a
+// package level variable.
+func Subsystem(name string) *SubsystemLogger {
+	// skip the frames of SubsystemLogger so go-log reports the caller
+	golog := log.WithSkip(log.Logger(name), 2)
+	if level, ok := levelOf(name); ok {
+		_ = log.SetLogLevel(name, level.String())
+	}
+	return &SubsystemLogger{name: name, golog: &golog.SugaredLogger}
+}
+
+// With returns a logger that adds the given keys and values to each log, e.g.
+// RetrievalIDKey and the ID of a retrieval to correlate its logs.
+func (l *SubsystemLogger) With(keysAndValues ...interface{}) *SubsystemLogger {
+	bound := make([]interface{}, 0, len(l.keysAndValues)+len(keysAndValues))
+	bound = append(append(bound, l.keysAndValues...), keysAndValues...)
+	return &SubsystemLogger{
+		name:          l.name,
+		golog:         l.golog.With(keysAndValues...),
+		keysAndValues: bound,
+	}
+}
+
+func (l *SubsystemLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+func (l *SubsystemLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+func (l *SubsystemLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+func (l *SubsystemLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l *SubsystemLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	s, _ := current.Load().(sink)
+	if s.Logger == nil {
+		switch level {
+		case LevelDebug:
+			l.golog.Debugw(msg, keysAndValues...)
+		case LevelInfo:
+			l.golog.Infow(msg, keysAndValues...)
+		case LevelWarn:
+			l.golog.Warnw(msg, keysAndValues...)
+		default:
+			l.golog.Errorw(msg, keysAndValues...)
+		}
+		return
+	}
+
+	if min, ok := levelOf(l.name); ok && level < min {
+		return
+	}
+	all := make([]interface{}, 0, 2+len(l.keysAndValues)+len(keysAndValues))
+	all = append(all, SubsystemKey, l.name)
+	all = append(append(all, l.keysAndValues...), keysAndValues...)
+	switch level {
+	case LevelDebug:
+		s.Debugw(msg, all...)
+	case LevelInfo:
+		s.Infow(msg, all...)
+	case LevelWarn:
+		s.Warnw(msg, all...)
+	default:
+		s.Errorw(msg, all...)
+	}
+}
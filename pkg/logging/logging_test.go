@@ -0,0 +1,140 @@
+package logging_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	level         logging.Level
+	msg           string
+	keysAndValues []interface{}
+}
+
+type recordingLogger struct {
+	lk      sync.Mutex
+	entries []entry
+}
+
+func (r *recordingLogger) record(level logging.Level, msg string, keysAndValues []interface{}) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.entries = append(r.entries, entry{level, msg, keysAndValues})
+}
+
+func (r *recordingLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	r.record(logging.LevelDebug, msg, keysAndValues)
+}
+
+func (r *recordingLogger) Infow(msg string, keysAndValues ...interface{}) {
+	r.record(logging.LevelInfo, msg, keysAndValues)
+}
+
+func (r *recordingLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	r.record(logging.LevelWarn, msg, keysAndValues)
+}
+
+func (r *recordingLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	r.record(logging.LevelError, msg, keysAndValues)
+}
+
+func TestSubsystemLogger(t *testing.T) {
+	testCases := []struct {
+		name     string
+		levels   map[string]logging.Level
+		log      func(l *logging.SubsystemLogger)
+		expected []entry
+	}{
+		{
+			name: "levels",
+			log: func(l *logging.SubsystemLogger) {
+				l.Debugw("debug", "a", 1)
+				l.Infow("info")
+				l.Warnw("warn", "b", "c")
+				l.Errorw("error")
+			},
+			expected: []entry{
+				{logging.LevelDebug, "debug", []interface{}{logging.SubsystemKey, "test/levels", "a", 1}},
+				{logging.LevelInfo, "info", []interface{}{logging.SubsystemKey, "test/levels"}},
+				{logging.LevelWarn, "warn", []interface{}{logging.SubsystemKey, "test/levels", "b", "c"}},
+				{logging.LevelError, "error", []interface{}{logging.SubsystemKey, "test/levels"}},
+			},
+		},
+		{
+			name: "with",
+			log: func(l *logging.SubsystemLogger) {
+				withID := l.With(logging.RetrievalIDKey, "id")
+				withID.With("peer", "p").Infow("nested", "a", 1)
+				withID.Infow("bound")
+				l.Infow("unbound")
+			},
+			expected: []entry{
+				{logging.LevelInfo, "nested", []interface{}{logging.SubsystemKey, "test/with", logging.RetrievalIDKey, "id", "peer", "p", "a", 1}},
+				{logging.LevelInfo, "bound", []interface{}{logging.SubsystemKey, "test/with", logging.RetrievalIDKey, "id"}},
+				{logging.LevelInfo, "unbound", []interface{}{logging.SubsystemKey, "test/with"}},
+			},
+		},
+		{
+			name:   "minimum level",
+			levels: map[string]logging.Level{"test/minimum level": logging.LevelWarn},
+			log: func(l *logging.SubsystemLogger) {
+				l.Debugw("debug")
+				l.Infow("info")
+				l.With("a", 1).Warnw("warn")
+				l.Errorw("error")
+			},
+			expected: []entry{
+				{logging.LevelWarn, "warn", []interface{}{logging.SubsystemKey, "test/minimum level", "a", 1}},
+				{logging.LevelError, "error", []interface{}{logging.SubsystemKey, "test/minimum level"}},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			recorder := &recordingLogger{}
+			logging.SetLogger(recorder)
+			defer logging.SetLogger(nil)
+			for subsystem, level := range testCase.levels {
+				logging.SetLevel(subsystem, level)
+			}
+
+			testCase.log(logging.Subsystem("test/" + testCase.name))
+			require.Equal(t, testCase.expected, recorder.entries)
+		})
+	}
+}
+
+func TestSetLoggerNil(t *testing.T) {
+	recorder := &recordingLogger{}
+	logging.SetLogger(recorder)
+	logger := logging.Subsystem("test/restore")
+	logger.Infow("recorded")
+	logging.SetLogger(nil)
+	logger.Infow("not recorded")
+	require.Len(t, recorder.entries, 1)
+}
+
+func TestLevels(t *testing.T) {
+	for _, level := range []logging.Level{logging.LevelDebug, logging.LevelInfo, logging.LevelWarn, logging.LevelError} {
+		parsed, err := logging.ParseLevel(level.String())
+		require.NoError(t, err)
+		require.Equal(t, level, parsed)
+	}
+	parsed, err := logging.ParseLevel("WARN")
+	require.NoError(t, err)
+	require.Equal(t, logging.LevelWarn, parsed)
+	_, err = logging.ParseLevel("loud")
+	require.Error(t, err)
+
+	logging.Subsystem("test/levels-listed")
+	require.Contains(t, logging.Subsystems(), "test/levels-listed")
+	logging.SetLevel("test/levels-listed", logging.LevelError)
+	require.Equal(t, logging.LevelError, logging.Levels()["test/levels-listed"])
+	logging.UnsetLevel("test/levels-listed")
+	require.NotContains(t, logging.Levels(), "test/levels-listed")
+}
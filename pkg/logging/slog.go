@@ -0,0 +1,33 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger adapts a *slog.Logger to a Logger for SetLogger.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger}
+}
+
+func (l slogLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, keysAndValues...)
+}
+
+func (l slogLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, keysAndValues...)
+}
+
+func (l slogLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, keysAndValues...)
+}
+
+func (l slogLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, keysAndValues...)
+}
@@ -0,0 +1,32 @@
+//go:build go1.21
+
+package logging_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logging.SetLogger(logging.NewSlogLogger(slog.New(handler)))
+	defer logging.SetLogger(nil)
+
+	logger := logging.Subsystem("test/slog").With(logging.RetrievalIDKey, "id")
+	logger.Debugw("filtered by the handler")
+	logger.Warnw("routed", "a", 1)
+	require.Equal(t, "level=WARN msg=routed subsystem=test/slog retrievalId=id a=1\n", buf.String())
+}
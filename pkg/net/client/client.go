@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
@@ -21,6 +22,7 @@ import (
 
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/retriever"
 	"github.com/filecoin-project/lassie/pkg/types"
 
@@ -29,8 +31,6 @@ import (
 	graphsync "github.com/ipfs/go-graphsync/impl"
 	gsnetwork "github.com/ipfs/go-graphsync/network"
 
-	"github.com/ipfs/go-log/v2"
-
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 
@@ -39,7 +39,7 @@ import (
 )
 
 // Logging
-var logger = log.Logger("lassie/client")
+var logger = logging.Subsystem("lassie/client")
 var tracer trace.Tracer = otel.Tracer("lassie")
 
 const RetrievalQueryProtocol = "/fil/retrieval/qry/1.0.0"
@@ -62,9 +62,10 @@ const messageSendRetries = 2
 const sendMessageTimeout = 2 * time.Minute
 
 type RetrievalClient struct {
-	dataTransfer datatransfer.Manager
-	host         host.Host
-	ready        *ready.ReadyManager
+	dataTransfer    datatransfer.Manager
+	host            host.Host
+	ready           *ready.ReadyManager
+	dialsInProgress atomic.Int64
 }
 
 type Config struct {
@@ -173,9 +174,27 @@ func (rc *RetrievalClient) AwaitReady() error {
 }
 
 func (rc *RetrievalClient) Connect(ctx context.Context, peerAddr peer.AddrInfo) error {
+	rc.dialsInProgress.Add(1)
+	defer rc.dialsInProgress.Add(-1)
 	return rc.host.Connect(ctx, peerAddr)
 }
 
+// DialsInProgress returns the number of connections to Graphsync providers
+// that are currently being established.
+func (rc *RetrievalClient) DialsInProgress() int {
+	return int(rc.dialsInProgress.Load())
+}
+
+// OpenChannels returns the number of data transfer channels, each carrying a
+// Graphsync retrieval, that are currently in progress.
+func (rc *RetrievalClient) OpenChannels(ctx context.Context) (int, error) {
+	channels, err := rc.dataTransfer.InProgressChannels(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(channels), nil
+}
+
 func (rc *RetrievalClient) RetrieveFromPeer(
 	ctx context.Context,
 	linkSystem ipld.LinkSystem,
@@ -186,7 +205,8 @@ func (rc *RetrievalClient) RetrieveFromPeer(
 	eventsCallback datatransfer.Subscriber,
 	gracefulShutdownRequested <-chan struct{},
 ) (*types.RetrievalStats, error) {
-	logger.Infof("Starting retrieval with miner peer ID: %s", peerID)
+	log := logger.With("peer", peerID, "payloadCid", proposal.PayloadCID)
+	log.Infow("Starting retrieval")
 
 	ctx, span := tracer.Start(ctx, "rcRetrieveContent")
 	defer span.End()
@@ -245,18 +265,14 @@ func (rc *RetrievalClient) RetrieveFromPeer(
 			lastVoucher := state.LastVoucherResult()
 			resType, err := retrievaltypes.DealResponseFromNode(lastVoucher.Voucher)
 			if err != nil {
-				logger.Errorf("unexpected voucher result received: %s", err.Error())
+				log.Errorw("unexpected voucher result received", "err", err)
 				return
 			}
-			if len(resType.Message) != 0 {
-				logger.Debugf("Received deal response voucher result %s (%v): %s\n\t%+v", resType.Status, resType.Status, resType.Message, resType)
-			} else {
-				logger.Debugf("Received deal response voucher result %s (%v)\n\t%+v", resType.Status, resType.Status, resType)
-			}
+			log.Debugw("Received deal response voucher result", "status", resType.Status, "message", resType.Message, "response", resType)
 
 			switch resType.Status {
 			case retrievaltypes.DealStatusAccepted:
-				logger.Info("Deal accepted")
+				log.Infow("Deal accepted")
 			case retrievaltypes.DealStatusFundsNeeded, retrievaltypes.DealStatusFundsNeededLastPayment:
 				finish(fmt.Errorf("provider requested payment"))
 				return
@@ -334,7 +350,7 @@ awaitfinished:
 				return nil, fmt.Errorf("data transfer failed: %w", err)
 			}
 
-			logger.Debugf("data transfer for retrieval complete")
+			log.Debugw("data transfer for retrieval complete")
 			break awaitfinished
 		case <-gracefulShutdownRequested:
 			go func() {
@@ -349,7 +365,7 @@ awaitfinished:
 	// here indicates a data transfer error that was not properly reported
 	if _, err := linkSystem.StorageReadOpener(ipld.LinkContext{}, cidlink.Link{Cid: rootCid}); err != nil {
 		if nf, ok := err.(interface{ NotFound() bool }); !ok || !nf.NotFound() {
-			logger.Errorf("could not query block store: %w", err)
+			log.Errorw("could not query block store", "err", err)
 		}
 		return nil, errors.New("data transfer failed: unconfirmed block transfer")
 	}
@@ -0,0 +1,78 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// GenerateIdentity generates a new Ed25519 private key for use as the
+// identity of a libp2p host.
+func GenerateIdentity() (crypto.PrivKey, error) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	return key, err
+}
+
+// LoadIdentity reads a private key, in the libp2p protobuf encoding, from the
+// file at path.
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// WriteIdentity writes a private key, in the libp2p protobuf encoding, to the
+// file at path, readable only by its owner. The key is written to a temporary
+// file that replaces path once complete, so an existing identity is never
+// left partially written.
+func WriteIdentity(path string, key crypto.PrivKey) error {
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadOrCreateIdentity loads the private key from the file at path, or, if
+// there is no such file, generates a new key and writes it there so that the
+// same identity is used next time.
+func LoadOrCreateIdentity(path string) (crypto.PrivKey, error) {
+	key, err := LoadIdentity(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+	key, err = GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteIdentity(path, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,48 @@
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity")
+
+	_, err := LoadIdentity(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// created on first use, then loaded
+	key, err := LoadOrCreateIdentity(path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	loaded, err := LoadOrCreateIdentity(path)
+	require.NoError(t, err)
+	require.True(t, key.Equals(loaded))
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	loadedID, err := peer.IDFromPrivateKey(loaded)
+	require.NoError(t, err)
+	require.Equal(t, id, loadedID)
+
+	// replaced
+	newKey, err := GenerateIdentity()
+	require.NoError(t, err)
+	require.NoError(t, WriteIdentity(path, newKey))
+	loaded, err = LoadIdentity(path)
+	require.NoError(t, err)
+	require.True(t, newKey.Equals(loaded))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files should be left behind")
+
+	// not a key
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0600))
+	_, err = LoadOrCreateIdentity(path)
+	require.ErrorContains(t, err, "invalid identity")
+}
@@ -0,0 +1,269 @@
+package receipts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/build"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multicodec"
+)
+
+var logger = logging.Subsystem("lassie/receipts")
+
+const (
+	// ProtocolID is the libp2p protocol used to deliver receipts to Graphsync
+	// providers, as the JSON of a SignedReceipt on a stream of its own. A
+	// receipt is only known once the retrieval has been verified, after the
+	// Graphsync request has completed and can no longer carry an extension.
+	ProtocolID = protocol.ID("/lassie/retrieval-receipt/1.0.0")
+	// HttpPath is the path, relative to the provider's HTTP address, that
+	// receipts are POSTed to for HTTP providers.
+	HttpPath = "/retrieval-receipt"
+
+	sendTimeout = 5 * time.Second // The timeout for delivering a single receipt
+)
+
+var ErrInvalidSignature = errors.New("invalid receipt signature")
+
+// Receipt is an acknowledgement that a retrieval from a provider was
+// successfully completed and verified.
+type Receipt struct {
+	RetrievalID string    `json:"retrievalId"`
+	RootCid     string    `json:"rootCid"`
+	ProviderID  string    `json:"providerId"`
+	Protocol    string    `json:"protocol"`
+	Bytes       uint64    `json:"bytes"`
+	Blocks      uint64    `json:"blocks"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SignedReceipt is a Receipt signed by the retrieving client's libp2p
+// identity, the provider can verify it using the public key embedded in the
+// Signer peer ID.
+type SignedReceipt struct {
+	Receipt   Receipt `json:"receipt"`
+	Signer    string  `json:"signer"`
+	Signature []byte  `json:"signature"`
+}
+
+// SigningBytes returns the canonical encoding of the receipt that is signed:
+// a DAG-CBOR map keyed by the receipt's JSON field names, with the bytes and
+// blocks as integers and the timestamp as an RFC 3339 string in UTC with
+// nanosecond precision. Unlike its JSON, which the provider may decode and
+// re-encode differently, any implementation can reproduce it from the fields
+// of the receipt to verify the signature.
+func (r Receipt) SigningBytes() ([]byte, error) {
+	node, err := qp.BuildMap(basicnode.Prototype.Map, 7, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "retrievalId", qp.String(r.RetrievalID))
+		qp.MapEntry(ma, "rootCid", qp.String(r.RootCid))
+		qp.MapEntry(ma, "providerId", qp.String(r.ProviderID))
+		qp.MapEntry(ma, "protocol", qp.String(r.Protocol))
+		qp.MapEntry(ma, "bytes", qp.Int(int64(r.Bytes)))
+		qp.MapEntry(ma, "blocks", qp.Int(int64(r.Blocks)))
+		qp.MapEntry(ma, "timestamp", qp.String(r.Timestamp.UTC().Format(time.RFC3339Nano)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(node, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign creates a SignedReceipt for the receipt using the given private key,
+// signing its SigningBytes.
+func Sign(key crypto.PrivKey, receipt Receipt) (SignedReceipt, error) {
+	signer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return SignedReceipt{}, err
+	}
+	data, err := receipt.SigningBytes()
+	if err != nil {
+		return SignedReceipt{}, err
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return SignedReceipt{}, err
+	}
+	return SignedReceipt{Receipt: receipt, Signer: signer.String(), Signature: sig}, nil
+}
+
+// Verify checks that the signature on the SigningBytes of the receipt was
+// produced by the Signer.
+func (sr SignedReceipt) Verify() error {
+	signer, err := peer.Decode(sr.Signer)
+	if err != nil {
+		return fmt.Errorf("%w: bad signer: %v", ErrInvalidSignature, err)
+	}
+	pubKey, err := signer.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("%w: can't extract public key: %v", ErrInvalidSignature, err)
+	}
+	data, err := sr.Receipt.SigningBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := pubKey.Verify(data, sr.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ReceiptSender delivers signed receipts to providers after successful
+// retrievals. Receipts are sent over HTTP to HTTP providers and over a libp2p
+// stream to Graphsync providers. Bitswap retrievals are not attributable to a
+// single provider so no receipt is sent for them.
+type ReceiptSender struct {
+	ctx    context.Context
+	host   host.Host
+	client *http.Client
+	key    crypto.PrivKey
+
+	lk         sync.Mutex
+	candidates map[types.RetrievalID]map[peer.ID]types.RetrievalCandidate
+}
+
+// NewReceiptSender creates a new ReceiptSender that signs receipts with the
+// identity of the given host.
+func NewReceiptSender(ctx context.Context, h host.Host, client *http.Client) (*ReceiptSender, error) {
+	key := h.Peerstore().PrivKey(h.ID())
+	if key == nil {
+		return nil, errors.New("no private key available for host")
+	}
+	return &ReceiptSender{
+		ctx:        ctx,
+		host:       h,
+		client:     client,
+		key:        key,
+		candidates: make(map[types.RetrievalID]map[peer.ID]types.RetrievalCandidate),
+	}, nil
+}
+
+// RetrievalEventSubscriber returns a RetrievalEventSubscriber that sends a
+// receipt to each provider that successfully serves a retrieval.
+func (rs *ReceiptSender) RetrievalEventSubscriber() types.RetrievalEventSubscriber {
+	return func(event types.RetrievalEvent) {
+		rs.lk.Lock()
+		defer rs.lk.Unlock()
+
+		switch ret := event.(type) {
+		case events.CandidatesFilteredEvent:
+			candidates, ok := rs.candidates[ret.RetrievalId()]
+			if !ok {
+				candidates = make(map[peer.ID]types.RetrievalCandidate)
+				rs.candidates[ret.RetrievalId()] = candidates
+			}
+			for _, candidate := range ret.Candidates() {
+				candidates[candidate.MinerPeer.ID] = candidate
+			}
+		case events.SucceededEvent:
+			if ret.Protocol() == multicodec.TransportBitswap {
+				return
+			}
+			candidate, ok := rs.candidates[ret.RetrievalId()][ret.ProviderId()]
+			if !ok {
+				logger.Debugw("no candidate found for successful retrieval, not sending receipt", logging.RetrievalIDKey, ret.RetrievalId(), "providerId", ret.ProviderId())
+				return
+			}
+			receipt := Receipt{
+				RetrievalID: ret.RetrievalId().String(),
+				RootCid:     ret.RootCid().String(),
+				ProviderID:  ret.ProviderId().String(),
+				Protocol:    ret.Protocol().String(),
+				Bytes:       ret.ReceivedBytesSize(),
+				Blocks:      ret.ReceivedCidsCount(),
+				Timestamp:   ret.Time(),
+			}
+			go rs.send(candidate, ret.Protocol(), receipt)
+		case events.FinishedEvent:
+			delete(rs.candidates, ret.RetrievalId())
+		}
+	}
+}
+
+func (rs *ReceiptSender) send(candidate types.RetrievalCandidate, protocol multicodec.Code, receipt Receipt) {
+	signed, err := Sign(rs.key, receipt)
+	if err != nil {
+		logger.Errorw("failed to sign receipt", logging.RetrievalIDKey, receipt.RetrievalID, "err", err)
+		return
+	}
+	data, err := json.Marshal(signed)
+	if err != nil {
+		logger.Errorw("failed to encode receipt", logging.RetrievalIDKey, receipt.RetrievalID, "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(rs.ctx, sendTimeout)
+	defer cancel()
+
+	switch protocol {
+	case multicodec.TransportIpfsGatewayHttp:
+		err = rs.sendHttp(ctx, candidate, data)
+	case multicodec.TransportGraphsyncFilecoinv1:
+		err = rs.sendLibp2p(ctx, candidate, data)
+	default:
+		return
+	}
+	if err != nil {
+		logger.Debugw("failed to deliver receipt", logging.RetrievalIDKey, receipt.RetrievalID, "providerId", receipt.ProviderID, "err", err)
+		return
+	}
+	logger.Debugw("delivered receipt", logging.RetrievalIDKey, receipt.RetrievalID, "providerId", receipt.ProviderID)
+}
+
+func (rs *ReceiptSender) sendHttp(ctx context.Context, candidate types.RetrievalCandidate, data []byte) error {
+	candidateURL, err := candidate.ToURL()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, candidateURL.String()+HttpPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", build.UserAgent)
+	resp, err := rs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("provider responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (rs *ReceiptSender) sendLibp2p(ctx context.Context, candidate types.RetrievalCandidate, data []byte) error {
+	stream, err := rs.host.NewStream(ctx, candidate.MinerPeer.ID, ProtocolID)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(data); err != nil {
+		_ = stream.Reset()
+		return err
+	}
+	return stream.Close()
+}
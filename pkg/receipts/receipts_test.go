@@ -0,0 +1,123 @@
+package receipts_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/receipts"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	receipt := receipts.Receipt{
+		RetrievalID: "abc",
+		RootCid:     testutil.GenerateCid().String(),
+		ProviderID:  testutil.GeneratePeers(t, 1)[0].String(),
+		Protocol:    multicodec.TransportIpfsGatewayHttp.String(),
+		Bytes:       100,
+		Blocks:      2,
+		Timestamp:   time.Unix(1000, 0).UTC(),
+	}
+	signed, err := receipts.Sign(key, receipt)
+	require.NoError(t, err)
+	require.NoError(t, signed.Verify())
+
+	// round-trip through JSON
+	byts, err := json.Marshal(signed)
+	require.NoError(t, err)
+	var decoded receipts.SignedReceipt
+	require.NoError(t, json.Unmarshal(byts, &decoded))
+	require.NoError(t, decoded.Verify())
+
+	// the signature covers the receipt's fields rather than their encoding,
+	// so it verifies whatever the timestamp's location
+	local := signed
+	local.Receipt.Timestamp = receipt.Timestamp.In(time.FixedZone("UTC+1", 3600))
+	require.NoError(t, local.Verify())
+
+	// and the signed bytes are canonical DAG-CBOR
+	signedBytes, err := receipt.SigningBytes()
+	require.NoError(t, err)
+	node, err := ipld.Decode(signedBytes, dagcbor.Decode)
+	require.NoError(t, err)
+	reencoded, err := ipld.Encode(node, dagcbor.Encode)
+	require.NoError(t, err)
+	require.Equal(t, signedBytes, reencoded)
+	timestamp, err := node.LookupByString("timestamp")
+	require.NoError(t, err)
+	timestampString, err := timestamp.AsString()
+	require.NoError(t, err)
+	require.Equal(t, "1970-01-01T00:16:40Z", timestampString)
+
+	// tampered
+	decoded.Receipt.Bytes = 200
+	require.ErrorIs(t, decoded.Verify(), receipts.ErrInvalidSignature)
+}
+
+func TestReceiptSenderHttp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan receipts.SignedReceipt, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, receipts.HttpPath, req.URL.Path)
+		var signed receipts.SignedReceipt
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&signed))
+		received <- signed
+	}))
+	defer server.Close()
+
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer h.Close()
+
+	sender, err := receipts.NewReceiptSender(ctx, h, server.Client())
+	require.NoError(t, err)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	addr, err := maurl.FromURL(serverURL)
+	require.NoError(t, err)
+
+	rid, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	root := testutil.GenerateCid()
+	provider := testutil.GeneratePeers(t, 1)[0]
+	candidate := types.NewRetrievalCandidate(provider, []multiaddr.Multiaddr{addr}, root, &metadata.IpfsGatewayHttp{})
+
+	subscriber := sender.RetrievalEventSubscriber()
+	subscriber(events.CandidatesFiltered(time.Now(), rid, root, []types.RetrievalCandidate{candidate}))
+	subscriber(events.Success(time.Now(), rid, candidate, 1010, 3, time.Second, multicodec.TransportIpfsGatewayHttp))
+	subscriber(events.Finished(time.Now(), rid, types.RetrievalCandidate{RootCid: root}))
+
+	select {
+	case <-ctx.Done():
+		require.FailNow(t, "did not receive receipt")
+	case signed := <-received:
+		require.NoError(t, signed.Verify())
+		require.Equal(t, h.ID().String(), signed.Signer)
+		require.Equal(t, rid.String(), signed.Receipt.RetrievalID)
+		require.Equal(t, root.String(), signed.Receipt.RootCid)
+		require.Equal(t, provider.String(), signed.Receipt.ProviderID)
+		require.Equal(t, uint64(1010), signed.Receipt.Bytes)
+		require.Equal(t, uint64(3), signed.Receipt.Blocks)
+	}
+}
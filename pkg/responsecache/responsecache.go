@@ -0,0 +1,260 @@
+/*
+Package responsecache keeps complete responses on disk, bounded in total size,
+so that repeated requests for the same content can be served without
+retrieving it again. Responses are keyed by a string such as the canonical
+hash of the request that produced them, see
+types.RetrievalRequest#CanonicalHash, and the least recently used are evicted
+first once the cache is full.
+*/
+package responsecache
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+)
+
+var logger = logging.Subsystem("lassie/responsecache")
+
+var ErrInvalidKey = errors.New("cache keys may only contain letters, digits, '-' and '_'")
+
+const (
+	entrySuffix = ".car"
+	tempSuffix  = ".tmp"
+)
+
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type entry struct {
+	key  string
+	size uint64
+}
+
+// Cache is a size-bounded cache of responses in a directory. It is safe for
+// concurrent use, including reads of responses that are being evicted.
+type Cache struct {
+	dir     string
+	maxSize uint64
+
+	lk      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // most recently used at the front
+	size    uint64
+}
+
+// New returns a Cache of up to maxSize bytes of responses in dir, creating the
+// directory if needed. Responses already in the directory, from an earlier
+// Cache, are kept, the least recently modified being the first evicted.
+func New(dir string, maxSize uint64) (*Cache, error) {
+	if maxSize == 0 {
+		return nil, errors.New("cache size must be greater than zero")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var existing []os.FileInfo
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(dirEntry.Name(), tempSuffix):
+			// an incomplete response from a Cache that didn't shut down cleanly
+			if err := os.Remove(filepath.Join(dir, dirEntry.Name())); err != nil {
+				logger.Warnw("Failed to remove incomplete response", "file", dirEntry.Name(), "err", err)
+			}
+		case strings.HasSuffix(dirEntry.Name(), entrySuffix):
+			info, err := dirEntry.Info()
+			if err != nil {
+				return nil, err
+			}
+			existing = append(existing, info)
+		}
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].ModTime().Before(existing[j].ModTime()) })
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	for _, info := range existing {
+		c.add(strings.TrimSuffix(info.Name(), entrySuffix), uint64(info.Size()))
+	}
+	c.evict()
+	return c, nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+entrySuffix)
+}
+
+// Get opens the response for the key, returning it and its size, or false if
+// there is none. The caller must close the returned file.
+func (c *Cache) Get(key string) (*os.File, uint64, bool) {
+	if !validKey.MatchString(key) {
+		return nil, 0, false
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		logger.Warnw("Failed to open cached response", "key", key, "err", err)
+		c.remove(elem)
+		return nil, 0, false
+	}
+	c.lru.MoveToFront(elem)
+	return f, elem.Value.(*entry).size, true
+}
+
+// Size returns the total size of the responses in the cache.
+func (c *Cache) Size() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.size
+}
+
+// Len returns the number of responses in the cache.
+func (c *Cache) Len() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.lru.Len()
+}
+
+// Clear removes every response from the cache, returning the number removed.
+// Responses being written are added once they are committed.
+func (c *Cache) Clear() int {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	n := c.lru.Len()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return n
+}
+
+// add records a response in the directory as the most recently used. Must be
+// called with the lock held.
+func (c *Cache) add(key string, size uint64) {
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*entry).size
+		elem.Value.(*entry).size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&entry{key: key, size: size})
+	}
+	c.size += size
+}
+
+// remove drops a response from the cache. Must be called with the lock held.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if err := os.Remove(c.path(e.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warnw("Failed to remove cached response", "key", e.key, "err", err)
+	}
+}
+
+// evict drops the least recently used responses until the cache is within its
+// size. Must be called with the lock held.
+func (c *Cache) evict() {
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// NewWriter starts writing the response for the key. The response is only
+// added to the cache once the Writer is committed, replacing any response
+// already cached for the key.
+func (c *Cache) NewWriter(key string) (*Writer, error) {
+	if !validKey.MatchString(key) {
+		return nil, ErrInvalidKey
+	}
+	f, err := os.CreateTemp(c.dir, key+"-*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{cache: c, key: key, file: f}, nil
+}
+
+// Writer writes a response to the cache. Writes never fail, so that a Writer
+// can be used alongside the writer of the response itself, for example with
+// io.MultiWriter; if the response can't be written, or is larger than the
+// cache, it is discarded on Commit.
+type Writer struct {
+	cache *Cache
+	key   string
+	file  *os.File
+	size  uint64
+	err   error
+}
+
+var _ io.Writer = (*Writer)(nil)
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	if w.size+uint64(len(p)) > w.cache.maxSize {
+		w.err = fmt.Errorf("response is larger than the cache size of %d bytes", w.cache.maxSize)
+		return len(p), nil
+	}
+	n, err := w.file.Write(p)
+	w.size += uint64(n)
+	if err != nil {
+		w.err = err
+	}
+	return len(p), nil
+}
+
+// Commit adds the response written to the cache, evicting the least recently
+// used responses to make room for it.
+func (w *Writer) Commit() error {
+	err := w.file.Close()
+	if w.err != nil {
+		err = w.err
+	}
+	w.cache.lk.Lock()
+	defer w.cache.lk.Unlock()
+	if err == nil {
+		// renamed with the lock held, so that the eviction of a response
+		// already cached for the key can't remove this one
+		err = os.Rename(w.file.Name(), w.cache.path(w.key))
+	}
+	if err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+	w.cache.add(w.key, w.size)
+	w.cache.evict()
+	return nil
+}
+
+// Abort discards the response written.
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	if err := os.Remove(w.file.Name()); err != nil {
+		logger.Warnw("Failed to remove incomplete response", "file", w.file.Name(), "err", err)
+	}
+}
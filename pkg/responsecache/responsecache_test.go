@@ -0,0 +1,137 @@
+package responsecache_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/responsecache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := responsecache.New(dir, 10)
+	require.NoError(t, err)
+
+	put := func(key string, data string) {
+		w, err := cache.NewWriter(key)
+		require.NoError(t, err)
+		_, err = io.Copy(w, strings.NewReader(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Commit())
+	}
+	get := func(key string) (string, bool) {
+		f, size, ok := cache.Get(key)
+		if !ok {
+			return "", false
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(data)), size)
+		return string(data), true
+	}
+
+	_, ok := get("a")
+	require.False(t, ok)
+
+	put("a", "aaaa")
+	put("b", "bbbb")
+	data, ok := get("a")
+	require.True(t, ok)
+	require.Equal(t, "aaaa", data)
+	require.Equal(t, uint64(8), cache.Size())
+
+	// b is the least recently used and is evicted to make room for c
+	put("c", "cccc")
+	_, ok = get("b")
+	require.False(t, ok)
+	_, ok = get("a")
+	require.True(t, ok)
+	_, ok = get("c")
+	require.True(t, ok)
+	require.Equal(t, 2, cache.Len())
+	require.Equal(t, uint64(8), cache.Size())
+
+	// replacing a response
+	put("a", "AA")
+	data, _ = get("a")
+	require.Equal(t, "AA", data)
+	require.Equal(t, uint64(6), cache.Size())
+
+	// a response larger than the cache isn't kept
+	w, err := cache.NewWriter("d")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("ddddddddddd"))
+	require.NoError(t, err)
+	require.Error(t, w.Commit())
+	_, ok = get("d")
+	require.False(t, ok)
+
+	// an aborted response isn't kept
+	w, err = cache.NewWriter("e")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("e"))
+	require.NoError(t, err)
+	w.Abort()
+	_, ok = get("e")
+	require.False(t, ok)
+
+	_, err = cache.NewWriter("../escape")
+	require.ErrorIs(t, err, responsecache.ErrInvalidKey)
+	_, _, ok = cache.Get("../escape")
+	require.False(t, ok)
+
+	// no temporary files are left behind
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// clearing removes every response and its file
+	require.Equal(t, 2, cache.Clear())
+	require.Equal(t, 0, cache.Len())
+	require.Equal(t, uint64(0), cache.Size())
+	_, ok = get("a")
+	require.False(t, ok)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestCacheReopen(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := responsecache.New(dir, 10)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		w, err := cache.NewWriter(key)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("xxx"))
+		require.NoError(t, err)
+		require.NoError(t, w.Commit())
+	}
+	// a is the oldest
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.car"), old, old))
+	// an incomplete response left behind
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "d-123.tmp"), []byte("x"), 0644))
+
+	// reopened smaller, the oldest response is evicted
+	cache, err = responsecache.New(dir, 6)
+	require.NoError(t, err)
+	require.Equal(t, 2, cache.Len())
+	_, _, ok := cache.Get("a")
+	require.False(t, ok)
+	f, size, ok := cache.Get("b")
+	require.True(t, ok)
+	require.Equal(t, uint64(3), size)
+	f.Close()
+	_, err = os.Stat(filepath.Join(dir, "d-123.tmp"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = responsecache.New(dir, 0)
+	require.Error(t, err)
+}
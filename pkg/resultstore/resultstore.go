@@ -0,0 +1,260 @@
+// Package resultstore keeps the results of finished retrievals in a
+// datastore, so that questions such as when some content was last retrieved
+// successfully, and from which provider, can be answered without an external
+// log pipeline.
+package resultstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// OutcomeSuccess is the Outcome of a successful retrieval. A failed
+	// retrieval has the lassie.FailureReason it failed with as its Outcome.
+	OutcomeSuccess = "success"
+
+	DefaultRetention  = 30 * 24 * time.Hour
+	DefaultQueryLimit = 100
+
+	// pruneInterval is the least time between removals of expired results.
+	pruneInterval = time.Hour
+)
+
+var (
+	// results are keyed by the time they finished, and indexed by root
+	byTimeKey = datastore.NewKey("/results/time")
+	byRootKey = datastore.NewKey("/results/root")
+)
+
+// Result summarises a finished retrieval.
+type Result struct {
+	RetrievalID     types.RetrievalID `json:"retrievalId"`
+	RequestHash     string            `json:"requestHash,omitempty"`
+	Root            cid.Cid           `json:"root"`
+	Path            string            `json:"path,omitempty"`
+	Outcome         string            `json:"outcome"`
+	Error           string            `json:"error,omitempty"`
+	Provider        peer.ID           `json:"provider,omitempty"`
+	Protocol        string            `json:"protocol,omitempty"`
+	Size            uint64            `json:"size,omitempty"`
+	Blocks          uint64            `json:"blocks,omitempty"`
+	Duration        string            `json:"duration,omitempty"`
+	TimeToFirstByte string            `json:"timeToFirstByte,omitempty"`
+	FinishedAt      time.Time         `json:"finishedAt"`
+}
+
+// Query selects results. Each non-zero field narrows the results to those
+// matching it.
+type Query struct {
+	Root        cid.Cid
+	RequestHash string
+	Outcome     string
+	// Since and Until bound the time the retrievals finished at, Since being
+	// inclusive and Until exclusive.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of results returned, defaults to
+	// DefaultQueryLimit.
+	Limit int
+}
+
+// Config configures a Store.
+type Config struct {
+	// Datastore is where results are kept, under /results/.
+	Datastore datastore.Batching
+	// Retention is how long results are kept for. Defaults to
+	// DefaultRetention.
+	Retention time.Duration
+	// Clock is an optional clock, if nil, the system clock will be used.
+	Clock clock.Clock
+}
+
+// Store keeps the results of retrievals, removing those older than its
+// retention as new results are put.
+type Store struct {
+	cfg Config
+
+	lk        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewStore creates a new Store.
+func NewStore(cfg Config) *Store {
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+	return &Store{cfg: cfg}
+}
+
+// Put stores a result.
+func (s *Store) Put(ctx context.Context, result Result) error {
+	if err := s.maybePrune(ctx); err != nil {
+		return fmt.Errorf("failed to remove expired results: %w", err)
+	}
+
+	byts, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	name := resultName(result.FinishedAt, result.RetrievalID)
+	batch, err := s.cfg.Datastore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(ctx, byTimeKey.ChildString(name), byts); err != nil {
+		return err
+	}
+	if result.Root.Defined() {
+		if err := batch.Put(ctx, rootKey(result.Root).ChildString(name), nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+// Query returns the results matching the query, most recent first.
+func (s *Store) Query(ctx context.Context, q Query) ([]Result, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	prefix := byTimeKey
+	if q.Root.Defined() {
+		prefix = rootKey(q.Root)
+	}
+	qr, err := s.cfg.Datastore.Query(ctx, query.Query{
+		Prefix:   prefix.String(),
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKeyDescending{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer qr.Close()
+
+	results := []Result{}
+	for entry := range qr.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		name := datastore.RawKey(entry.Key).BaseNamespace()
+		finishedAt, err := finishedAt(name)
+		if err != nil {
+			return nil, err
+		}
+		if !q.Until.IsZero() && !finishedAt.Before(q.Until) {
+			continue
+		}
+		if !q.Since.IsZero() && finishedAt.Before(q.Since) {
+			// results are in descending order, the rest are older still
+			break
+		}
+		byts, err := s.cfg.Datastore.Get(ctx, byTimeKey.ChildString(name))
+		if err != nil {
+			if errors.Is(err, datastore.ErrNotFound) {
+				// removed since the query began
+				continue
+			}
+			return nil, err
+		}
+		var result Result
+		if err := json.Unmarshal(byts, &result); err != nil {
+			return nil, err
+		}
+		if (q.RequestHash != "" && result.RequestHash != q.RequestHash) || (q.Outcome != "" && result.Outcome != q.Outcome) {
+			continue
+		}
+		results = append(results, result)
+		if len(results) == q.Limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// maybePrune removes the results older than the retention, at most once each
+// pruneInterval.
+func (s *Store) maybePrune(ctx context.Context) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	now := s.cfg.Clock.Now()
+	if !s.lastPrune.IsZero() && now.Sub(s.lastPrune) < pruneInterval {
+		return nil
+	}
+	s.lastPrune = now
+
+	qr, err := s.cfg.Datastore.Query(ctx, query.Query{
+		Prefix: byTimeKey.String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer qr.Close()
+
+	batch, err := s.cfg.Datastore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	expiry := now.Add(-s.cfg.Retention)
+	for entry := range qr.Next() {
+		if entry.Error != nil {
+			return entry.Error
+		}
+		key := datastore.RawKey(entry.Key)
+		finishedAt, err := finishedAt(key.BaseNamespace())
+		if err != nil {
+			return err
+		}
+		if !finishedAt.Before(expiry) {
+			break
+		}
+		if err := batch.Delete(ctx, key); err != nil {
+			return err
+		}
+		var result Result
+		if err := json.Unmarshal(entry.Value, &result); err == nil && result.Root.Defined() {
+			if err := batch.Delete(ctx, rootKey(result.Root).ChildString(key.BaseNamespace())); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Commit(ctx)
+}
+
+// rootKey is the prefix of the index of results by root, which is normalised
+// to CIDv1 so that either version of a CID finds the same results.
+func rootKey(root cid.Cid) datastore.Key {
+	return byRootKey.ChildString(cid.NewCidV1(root.Type(), root.Hash()).String())
+}
+
+// resultName is the name of a result in the datastore, which sorts by the
+// time the retrieval finished.
+func resultName(finishedAt time.Time, id types.RetrievalID) string {
+	return fmt.Sprintf("%020d-%s", finishedAt.UnixNano(), id)
+}
+
+func finishedAt(name string) (time.Time, error) {
+	nanos, _, _ := strings.Cut(name, "-")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid result key %q", name)
+	}
+	return time.Unix(0, n), nil
+}
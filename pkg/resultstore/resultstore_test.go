@@ -0,0 +1,116 @@
+package resultstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/resultstore"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rootV0 := cid.MustParse("QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u")
+	root := cid.NewCidV1(cid.DagProtobuf, rootV0.Hash())
+	other := cid.MustParse("bafkqaalb")
+
+	clk := clock.NewMock()
+	start := clk.Now()
+	var results []resultstore.Result
+	for i, r := range []struct {
+		root    cid.Cid
+		outcome string
+	}{
+		{root, resultstore.OutcomeSuccess},
+		{other, resultstore.OutcomeSuccess},
+		{root, "timeout"},
+		{rootV0, resultstore.OutcomeSuccess},
+		{other, "no-candidates"},
+	} {
+		id, err := types.NewRetrievalID()
+		require.NoError(t, err)
+		results = append(results, resultstore.Result{
+			RetrievalID: id,
+			RequestHash: r.root.String(),
+			Root:        r.root,
+			Outcome:     r.outcome,
+			FinishedAt:  start.Add(time.Duration(i) * time.Minute).UTC(),
+		})
+	}
+
+	testCases := []struct {
+		name     string
+		query    resultstore.Query
+		expected []int
+	}{
+		{
+			name:     "all, most recent first",
+			expected: []int{4, 3, 2, 1, 0},
+		},
+		{
+			name:     "by root, either CID version",
+			query:    resultstore.Query{Root: rootV0},
+			expected: []int{3, 2, 0},
+		},
+		{
+			name:     "by root and outcome",
+			query:    resultstore.Query{Root: root, Outcome: resultstore.OutcomeSuccess},
+			expected: []int{3, 0},
+		},
+		{
+			name:     "by request hash",
+			query:    resultstore.Query{RequestHash: other.String()},
+			expected: []int{4, 1},
+		},
+		{
+			name:     "by time range",
+			query:    resultstore.Query{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)},
+			expected: []int{2, 1},
+		},
+		{
+			name:     "limited",
+			query:    resultstore.Query{Outcome: resultstore.OutcomeSuccess, Limit: 2},
+			expected: []int{3, 1},
+		},
+	}
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	store := resultstore.NewStore(resultstore.Config{Datastore: ds, Retention: time.Hour, Clock: clk})
+	for _, result := range results {
+		require.NoError(t, store.Put(ctx, result))
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			found, err := store.Query(ctx, testCase.query)
+			require.NoError(t, err)
+			expected := []resultstore.Result{}
+			for _, i := range testCase.expected {
+				expected = append(expected, results[i])
+			}
+			require.Equal(t, expected, found)
+		})
+	}
+
+	// results older than the retention are removed as new results are put
+	clk.Add(time.Hour + 2*time.Minute + 30*time.Second)
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	latest := resultstore.Result{RetrievalID: id, Root: other, Outcome: resultstore.OutcomeSuccess, FinishedAt: clk.Now().UTC()}
+	require.NoError(t, store.Put(ctx, latest))
+	found, err := store.Query(ctx, resultstore.Query{})
+	require.NoError(t, err)
+	require.Equal(t, []resultstore.Result{latest, results[4], results[3]}, found)
+	found, err = store.Query(ctx, resultstore.Query{Root: root})
+	require.NoError(t, err)
+	require.Equal(t, []resultstore.Result{results[3]}, found)
+}
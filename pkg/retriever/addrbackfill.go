@@ -0,0 +1,126 @@
+package retriever
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// AddrBackfill configures the lookup of addresses for candidates that are
+// advertised without any, which couldn't otherwise be retrieved from. For each
+// retrieval, the addresses of up to MaxLookups such candidates are looked up
+// with the Router, concurrently and for at most Timeout each. Candidates whose
+// addresses aren't found are dropped, as are those beyond MaxLookups.
+//
+// A nil Router or a MaxLookups of zero, the default, disables the lookups.
+type AddrBackfill struct {
+	Router     routing.PeerRouting
+	MaxLookups int
+	Timeout    time.Duration
+}
+
+func (ab AddrBackfill) enabled() bool {
+	return ab.Router != nil && ab.MaxLookups > 0
+}
+
+var _ CandidateFinder = AddrBackfillCandidateFinder{}
+
+// AddrBackfillCandidateFinder wraps a CandidateFinder, backfilling the
+// addresses of the candidates it finds without any, see AddrBackfill.
+type AddrBackfillCandidateFinder struct {
+	CandidateFinder
+	backfill AddrBackfill
+}
+
+// NewAddrBackfillCandidateFinder returns a new AddrBackfillCandidateFinder
+// for the given CandidateFinder.
+func NewAddrBackfillCandidateFinder(finder CandidateFinder, backfill AddrBackfill) AddrBackfillCandidateFinder {
+	return AddrBackfillCandidateFinder{CandidateFinder: finder, backfill: backfill}
+}
+
+func (abf AddrBackfillCandidateFinder) FindCandidates(ctx context.Context, c cid.Cid) ([]types.RetrievalCandidate, error) {
+	if !abf.backfill.enabled() {
+		return abf.CandidateFinder.FindCandidates(ctx, c)
+	}
+	found, err := abf.CandidateFinder.FindCandidates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]types.RetrievalCandidate, 0, len(found))
+	backfiller := abf.newBackfiller(ctx, func(candidate types.RetrievalCandidate) {
+		candidates = append(candidates, candidate)
+	})
+	for _, candidate := range found {
+		backfiller.onCandidate(candidate)
+	}
+	backfiller.wait()
+	return candidates, nil
+}
+
+func (abf AddrBackfillCandidateFinder) FindCandidatesAsync(ctx context.Context, c cid.Cid, cb func(types.RetrievalCandidate)) error {
+	if !abf.backfill.enabled() {
+		return abf.CandidateFinder.FindCandidatesAsync(ctx, c, cb)
+	}
+	backfiller := abf.newBackfiller(ctx, cb)
+	err := abf.CandidateFinder.FindCandidatesAsync(ctx, c, backfiller.onCandidate)
+	// candidates may still be delivered until the lookups have finished
+	backfiller.wait()
+	return err
+}
+
+// addrBackfiller passes on the candidates of a single query, looking up the
+// addresses of those without any within the query's budget of lookups.
+type addrBackfiller struct {
+	ctx      context.Context
+	backfill AddrBackfill
+	cb       func(types.RetrievalCandidate)
+
+	lk      sync.Mutex // serialises calls to cb and guards lookups
+	lookups int
+	wg      sync.WaitGroup
+}
+
+func (abf AddrBackfillCandidateFinder) newBackfiller(ctx context.Context, cb func(types.RetrievalCandidate)) *addrBackfiller {
+	return &addrBackfiller{ctx: ctx, backfill: abf.backfill, cb: cb}
+}
+
+func (ab *addrBackfiller) onCandidate(candidate types.RetrievalCandidate) {
+	ab.lk.Lock()
+	defer ab.lk.Unlock()
+	if len(candidate.MinerPeer.Addrs) > 0 {
+		ab.cb(candidate)
+		return
+	}
+	if ab.lookups >= ab.backfill.MaxLookups {
+		logger.Debugw("Dropping candidate without addresses, address lookup budget exhausted", "providerId", candidate.MinerPeer.ID)
+		return
+	}
+	ab.lookups++
+	ab.wg.Add(1)
+	go func() {
+		defer ab.wg.Done()
+		ctx := ab.ctx
+		if ab.backfill.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, ab.backfill.Timeout)
+			defer cancel()
+		}
+		addrInfo, err := ab.backfill.Router.FindPeer(ctx, candidate.MinerPeer.ID)
+		if err != nil || len(addrInfo.Addrs) == 0 {
+			logger.Debugw("Dropping candidate without addresses, none found", "providerId", candidate.MinerPeer.ID, "err", err)
+			return
+		}
+		candidate.MinerPeer.Addrs = addrInfo.Addrs
+		ab.lk.Lock()
+		defer ab.lk.Unlock()
+		ab.cb(candidate)
+	}()
+}
+
+func (ab *addrBackfiller) wait() {
+	ab.wg.Wait()
+}
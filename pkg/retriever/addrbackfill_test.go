@@ -0,0 +1,137 @@
+package retriever_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// mockPeerRouting knows the addresses of some peers, and blocks until the
+// lookup times out for the peers in hang.
+type mockPeerRouting struct {
+	addrs map[peer.ID][]multiaddr.Multiaddr
+	hang  map[peer.ID]bool
+
+	lk      sync.Mutex
+	lookups []peer.ID
+}
+
+func (mpr *mockPeerRouting) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	mpr.lk.Lock()
+	mpr.lookups = append(mpr.lookups, id)
+	mpr.lk.Unlock()
+	if mpr.hang[id] {
+		<-ctx.Done()
+		return peer.AddrInfo{}, ctx.Err()
+	}
+	addrs, ok := mpr.addrs[id]
+	if !ok {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	return peer.AddrInfo{ID: id, Addrs: addrs}, nil
+}
+
+func TestAddrBackfillCandidateFinder(t *testing.T) {
+	c := testutil.GenerateCid()
+	// the first two candidates have addresses, the rest don't
+	candidates := testutil.GenerateRetrievalCandidatesForCID(t, 6, c)
+	for i := 2; i < len(candidates); i++ {
+		candidates[i].MinerPeer.Addrs = nil
+	}
+	backfilled := func(i int) types.RetrievalCandidate {
+		candidate := candidates[i]
+		candidate.MinerPeer.Addrs = []multiaddr.Multiaddr{testutil.GenerateMultiaddr()}
+		return candidate
+	}
+	known := []types.RetrievalCandidate{backfilled(2), backfilled(3)}
+
+	testCases := []struct {
+		name            string
+		maxLookups      int
+		timeout         time.Duration
+		hang            []int
+		expected        []types.RetrievalCandidate
+		expectedLookups int
+	}{
+		{
+			name:     "disabled",
+			expected: candidates,
+		},
+		{
+			name:            "backfills known peers",
+			maxLookups:      10,
+			expected:        append([]types.RetrievalCandidate{candidates[0], candidates[1]}, known...),
+			expectedLookups: 4,
+		},
+		{
+			name:            "bounded number of lookups",
+			maxLookups:      1,
+			expected:        []types.RetrievalCandidate{candidates[0], candidates[1], known[0]},
+			expectedLookups: 1,
+		},
+		{
+			name:            "bounded lookup time",
+			maxLookups:      10,
+			timeout:         50 * time.Millisecond,
+			hang:            []int{3},
+			expected:        []types.RetrievalCandidate{candidates[0], candidates[1], known[0]},
+			expectedLookups: 4,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			for _, async := range []bool{false, true} {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				router := &mockPeerRouting{
+					addrs: map[peer.ID][]multiaddr.Multiaddr{},
+					hang:  map[peer.ID]bool{},
+				}
+				for _, candidate := range known {
+					router.addrs[candidate.MinerPeer.ID] = candidate.MinerPeer.Addrs
+				}
+				for _, i := range testCase.hang {
+					router.hang[candidates[i].MinerPeer.ID] = true
+				}
+				var backfill retriever.AddrBackfill
+				if testCase.maxLookups > 0 {
+					backfill = retriever.AddrBackfill{Router: router, MaxLookups: testCase.maxLookups, Timeout: testCase.timeout}
+				}
+				finder := retriever.NewAddrBackfillCandidateFinder(
+					testutil.NewMockCandidateFinder(nil, map[cid.Cid][]types.RetrievalCandidate{c: candidates}),
+					backfill,
+				)
+
+				var found []types.RetrievalCandidate
+				if async {
+					var lk sync.Mutex
+					err := finder.FindCandidatesAsync(ctx, c, func(candidate types.RetrievalCandidate) {
+						lk.Lock()
+						defer lk.Unlock()
+						found = append(found, candidate)
+					})
+					require.NoError(t, err)
+				} else {
+					var err error
+					found, err = finder.FindCandidates(ctx, c)
+					require.NoError(t, err)
+				}
+				require.ElementsMatch(t, testCase.expected, found)
+				require.Len(t, router.lookups, testCase.expectedLookups)
+			}
+		})
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type FilterIndexerCandidate func(types.RetrievalCandidate) (bool, types.RetrievalCandidate)
@@ -21,6 +23,7 @@ type FilterIndexerCandidate func(types.RetrievalCandidate) (bool, types.Retrieva
 type AssignableCandidateFinder struct {
 	filterIndexerCandidate FilterIndexerCandidate
 	candidateFinder        CandidateFinder
+	capabilities           *CapabilityCache
 	clock                  clock.Clock
 }
 
@@ -32,28 +35,68 @@ func NewAssignableCandidateFinder(candidateFinder CandidateFinder, filterIndexer
 func NewAssignableCandidateFinderWithClock(candidateFinder CandidateFinder, filterIndexerCandidate FilterIndexerCandidate, clock clock.Clock) AssignableCandidateFinder {
 	return AssignableCandidateFinder{candidateFinder: candidateFinder, filterIndexerCandidate: filterIndexerCandidate, clock: clock}
 }
-func (acf AssignableCandidateFinder) FindCandidates(ctx context.Context, request types.RetrievalRequest, eventsCallback func(types.RetrievalEvent), onCandidates func([]types.RetrievalCandidate)) error {
+
+// WithCapabilities returns a copy of the AssignableCandidateFinder that skips
+// the protocols of candidates whose providers the given CapabilityCache
+// records as being unable to serve them.
+func (acf AssignableCandidateFinder) WithCapabilities(capabilities *CapabilityCache) AssignableCandidateFinder {
+	acf.capabilities = capabilities
+	return acf
+}
+
+func (acf AssignableCandidateFinder) FindCandidates(ctx context.Context, request types.RetrievalRequest, eventsCallback func(types.RetrievalEvent), onCandidates func([]types.RetrievalCandidate)) (err error) {
+	ctx, span := tracer.Start(ctx, "FindCandidates", trace.WithAttributes(
+		attribute.String("retrievalId", request.RetrievalID.String()),
+		attribute.String("root", request.Root.String()),
+	))
+	var totalCandidates atomic.Uint64
+	defer func() {
+		span.SetAttributes(attribute.Int64("candidates", int64(totalCandidates.Load())))
+		endSpan(span, err)
+	}()
+
 	ctx, cancelCtx := context.WithCancel(ctx)
 	defer cancelCtx()
 
 	eventsCallback(events.StartedFindingCandidates(acf.clock.Now(), request.RetrievalID, request.Root))
 
-	var totalCandidates atomic.Uint64
 	candidateBuffer := candidatebuffer.NewCandidateBuffer(func(candidates []types.RetrievalCandidate) {
 		eventsCallback(events.CandidatesFound(acf.clock.Now(), request.RetrievalID, request.Root, candidates))
 
 		acceptableCandidates := make([]types.RetrievalCandidate, 0)
 		for _, candidate := range candidates {
+			// the request's own allow and block lists apply on top of the
+			// session's
+			if !request.IsAcceptableProvider(candidate.MinerPeer.ID) {
+				continue
+			}
 			hasFilterCandidateFn := acf.filterIndexerCandidate != nil
 			keepCandidate := true
 			if hasFilterCandidateFn {
 				keepCandidate, candidate = acf.filterIndexerCandidate(candidate)
 			}
+			if keepCandidate && acf.capabilities != nil {
+				keepCandidate, candidate = acf.capabilities.filterCandidate(acf.clock.Now(), request.RetrievalID, candidate, eventsCallback)
+			}
 			if keepCandidate {
 				acceptableCandidates = append(acceptableCandidates, candidate)
 			}
 		}
 
+		// stop accepting candidates once the limit is reached, the finder may
+		// still be streaming results but they are ignored
+		if maxCandidates := uint64(request.QueryLimits.MaxCandidates); maxCandidates > 0 {
+			remaining := maxCandidates - totalCandidates.Load()
+			if uint64(len(acceptableCandidates)) > remaining {
+				logger.Debugw("Candidate limit reached, ignoring further candidates",
+					"retrievalId", request.RetrievalID,
+					"maxCandidates", maxCandidates,
+					"ignored", uint64(len(acceptableCandidates))-remaining,
+				)
+				acceptableCandidates = acceptableCandidates[:remaining]
+			}
+		}
+
 		if len(acceptableCandidates) == 0 {
 			return
 		}
@@ -63,7 +106,7 @@ func (acf AssignableCandidateFinder) FindCandidates(ctx context.Context, request
 		onCandidates(acceptableCandidates)
 	}, acf.clock)
 
-	err := candidateBuffer.BufferStream(ctx, func(ctx context.Context, onNextCandidate candidatebuffer.OnNextCandidate) error {
+	err = candidateBuffer.BufferStream(ctx, func(ctx context.Context, onNextCandidate candidatebuffer.OnNextCandidate) error {
 		if len(request.FixedPeers) > 0 {
 			return sendFixedPeers(request.Root, request.FixedPeers, onNextCandidate)
 		}
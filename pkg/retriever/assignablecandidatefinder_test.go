@@ -27,7 +27,10 @@ func TestAssignableCandidateFinder(t *testing.T) {
 		candidateResults   map[cid.Cid][]string
 		candidateError     error
 		filteredPeers      []string
+		requestAllowList   []string
+		requestBlockList   []string
 		fixedPeers         map[cid.Cid][]string
+		maxCandidates      uint
 		expectedEvents     map[cid.Cid][]types.EventCode
 		expectedCandidates map[cid.Cid][]string
 		expectedErrors     map[cid.Cid]error
@@ -110,6 +113,56 @@ func TestAssignableCandidateFinder(t *testing.T) {
 				cid2: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
 			},
 		},
+		{
+			name: "request block list",
+			candidateResults: map[cid.Cid][]string{
+				cid1: {"fiz", "bang", "booz"},
+				cid2: {"apples", "oranges", "cheese"},
+			},
+			filteredPeers:    []string{"fiz"},
+			requestBlockList: []string{"bang", "oranges"},
+			expectedCandidates: map[cid.Cid][]string{
+				cid1: {"booz"},
+				cid2: {"apples", "cheese"},
+			},
+			expectedEvents: map[cid.Cid][]types.EventCode{
+				cid1: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
+				cid2: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
+			},
+		},
+		{
+			name: "request allow list",
+			candidateResults: map[cid.Cid][]string{
+				cid1: {"fiz", "bang", "booz"},
+				cid2: {"apples", "oranges", "cheese"},
+			},
+			filteredPeers:    []string{"fiz"},
+			requestAllowList: []string{"fiz", "booz", "cheese"},
+			expectedCandidates: map[cid.Cid][]string{
+				cid1: {"booz"},
+				cid2: {"cheese"},
+			},
+			expectedEvents: map[cid.Cid][]types.EventCode{
+				cid1: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
+				cid2: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
+			},
+		},
+		{
+			name: "candidate limit",
+			candidateResults: map[cid.Cid][]string{
+				cid1: {"fiz", "bang", "booz"},
+				cid2: {"apples"},
+			},
+			maxCandidates: 2,
+			expectedCandidates: map[cid.Cid][]string{
+				cid1: {"fiz", "bang"},
+				cid2: {"apples"},
+			},
+			expectedEvents: map[cid.Cid][]types.EventCode{
+				cid1: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
+				cid2: {types.StartedFindingCandidatesCode, types.CandidatesFoundCode, types.CandidatesFilteredCode},
+			},
+		},
 		{
 			name: "fixed peers",
 			candidateResults: map[cid.Cid][]string{
@@ -155,6 +208,18 @@ func TestAssignableCandidateFinder(t *testing.T) {
 				}
 				allFixedPeers[c] = fixedPeers
 			}
+			toPeerSet := func(peers []string) map[peer.ID]bool {
+				if peers == nil {
+					return nil
+				}
+				set := make(map[peer.ID]bool, len(peers))
+				for _, p := range peers {
+					set[peer.ID(p)] = true
+				}
+				return set
+			}
+			allowList := toPeerSet(testCase.requestAllowList)
+			blockList := toPeerSet(testCase.requestBlockList)
 			candidateFinder := testutil.NewMockCandidateFinder(testCase.candidateError, allCandidateResults)
 			isAcceptableStorageProvider := func(candidate types.RetrievalCandidate) (bool, types.RetrievalCandidate) {
 				for _, filteredPeer := range testCase.filteredPeers {
@@ -186,10 +251,13 @@ func TestAssignableCandidateFinder(t *testing.T) {
 			}
 
 			err = retrievalCandidateFinder.FindCandidates(ctx, types.RetrievalRequest{
-				RetrievalID: rid1,
-				Request:     trustlessutils.Request{Root: cid1},
-				LinkSystem:  cidlink.DefaultLinkSystem(),
-				FixedPeers:  allFixedPeers[cid1],
+				RetrievalID:       rid1,
+				Request:           trustlessutils.Request{Root: cid1},
+				LinkSystem:        cidlink.DefaultLinkSystem(),
+				FixedPeers:        allFixedPeers[cid1],
+				QueryLimits:       types.ProviderQueryLimits{MaxCandidates: testCase.maxCandidates},
+				ProviderAllowList: allowList,
+				ProviderBlockList: blockList,
 			}, retrievalCollector, candidateCollector)
 			if err != nil {
 				receivedErrors[cid1] = err
@@ -200,10 +268,13 @@ func TestAssignableCandidateFinder(t *testing.T) {
 			req.NoError(err)
 			candidates = nil
 			err = retrievalCandidateFinder.FindCandidates(ctx, types.RetrievalRequest{
-				RetrievalID: rid2,
-				Request:     trustlessutils.Request{Root: cid2},
-				LinkSystem:  cidlink.DefaultLinkSystem(),
-				FixedPeers:  allFixedPeers[cid2],
+				RetrievalID:       rid2,
+				Request:           trustlessutils.Request{Root: cid2},
+				LinkSystem:        cidlink.DefaultLinkSystem(),
+				FixedPeers:        allFixedPeers[cid2],
+				QueryLimits:       types.ProviderQueryLimits{MaxCandidates: testCase.maxCandidates},
+				ProviderAllowList: allowList,
+				ProviderBlockList: blockList,
 			}, retrievalCollector, candidateCollector)
 			if err != nil {
 				receivedErrors[cid2] = err
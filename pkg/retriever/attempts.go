@@ -0,0 +1,71 @@
+package retriever
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type attemptLimiterKey struct{}
+
+// attemptLimiter bounds the number of distinct providers a retrieval attempts,
+// across all of its protocols, see RetrievalRequest#MaxAttempts. It's shared
+// with the protocol retrievers through the retrieval's context. A nil
+// attemptLimiter allows every provider.
+type attemptLimiter struct {
+	maxAttempts uint
+	lk          sync.Mutex
+	attempted   map[peer.ID]struct{}
+}
+
+// withAttemptLimiter returns a context carrying a new attemptLimiter allowing
+// maxAttempts providers, or the given context if there's no limit.
+func withAttemptLimiter(ctx context.Context, maxAttempts uint) context.Context {
+	if maxAttempts == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, attemptLimiterKey{}, &attemptLimiter{
+		maxAttempts: maxAttempts,
+		attempted:   make(map[peer.ID]struct{}),
+	})
+}
+
+func attemptLimiterFrom(ctx context.Context) *attemptLimiter {
+	al, _ := ctx.Value(attemptLimiterKey{}).(*attemptLimiter)
+	return al
+}
+
+// allow returns true if the provider may be attempted, either because it has
+// already been attempted, possibly over another protocol, or because there
+// are attempts left, in which case one is used.
+func (al *attemptLimiter) allow(p peer.ID) bool {
+	if al == nil {
+		return true
+	}
+	al.lk.Lock()
+	defer al.lk.Unlock()
+	if _, ok := al.attempted[p]; ok {
+		return true
+	}
+	if uint(len(al.attempted)) >= al.maxAttempts {
+		return false
+	}
+	al.attempted[p] = struct{}{}
+	return true
+}
+
+// filter returns the candidates that may be attempted, see allow.
+func (al *attemptLimiter) filter(candidates []types.RetrievalCandidate) []types.RetrievalCandidate {
+	if al == nil {
+		return candidates
+	}
+	allowed := make([]types.RetrievalCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if al.allow(candidate.MinerPeer.ID) {
+			allowed = append(allowed, candidate)
+		}
+	}
+	return allowed
+}
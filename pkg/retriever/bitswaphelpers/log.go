@@ -1,5 +1,5 @@
 package bitswaphelpers
 
-import "github.com/ipfs/go-log/v2"
+import "github.com/filecoin-project/lassie/pkg/logging"
 
-var logger = log.Logger("lassie/bitswap")
+var logger = logging.Subsystem("lassie/bitswap")
@@ -13,6 +13,7 @@ import (
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 // ErrNotSupported indicates an operation not supported by the MultiBlockstore
@@ -28,6 +29,13 @@ type contextKey string
 
 const peerIdContextKey = contextKey("traceableBlock.peerId")
 
+// PeerFromContext returns the peer that sent the block being written, for a
+// context passed to a LinkSystem registered with the MultiBlockstore
+func PeerFromContext(ctx context.Context) (peer.ID, bool) {
+	p, ok := ctx.Value(peerIdContextKey).(peer.ID)
+	return p, ok
+}
+
 // MultiBlockstore creates a blockstore based on one or more linkystems, extracting the target linksystem for each request
 // from the retrieval id context key
 type MultiBlockstore struct {
@@ -132,7 +140,7 @@ func (mbs *MultiBlockstore) PutMany(ctx context.Context, blks []blocks.Block) er
 		if traceableBlock, ok := blk.(traceability.Block); ok {
 			lctx = context.WithValue(lctx, peerIdContextKey, traceableBlock.From)
 		} else {
-			logger.Warn("Got untraceable block from bitswap")
+			logger.Warnw("Got untraceable block from bitswap")
 		}
 		w, commit, err := lsys.StorageWriteOpener(linking.LinkContext{Ctx: lctx})
 		if err != nil {
@@ -20,6 +20,7 @@ type preloadingLink struct {
 	loadSyncer sync.Once
 	loaded     chan struct{}
 	err        error
+	skipped    bool // not preloaded as the buffer was full
 }
 
 type PreloadCachingStorage struct {
@@ -36,12 +37,37 @@ type PreloadCachingStorage struct {
 	preloadsLk sync.RWMutex
 	preloads   map[ipld.Link]*preloadingLink
 
+	maxBuffered     uint64
+	spillLinkSystem *linking.LinkSystem
+	bufferLk        sync.Mutex
+	buffered        uint64
+	bufferedSizes   map[string]uint64 // blocks in the cache not yet loaded
+	spilled         map[string]struct{}
+	skippedPreloads int
+
 	loadCount      int
 	preloadedHits  int
 	preloadingHits int
 	preloadMisses  int
 }
 
+// PreloadOption configures a PreloadCachingStorage.
+type PreloadOption func(*PreloadCachingStorage)
+
+// WithPreloadBuffer bounds the size of the blocks held in the cacheLinkSystem
+// that the traversal hasn't loaded yet, which, for a large DAG, the preloader
+// can fill far faster than the traversal consumes them. Once they reach
+// maxBytes, further blocks are written to spill, such as a temporary CAR on
+// disk, if it isn't nil, or otherwise no more are preloaded until the
+// traversal catches up, leaving it to fetch those it reaches first itself. A
+// maxBytes of zero doesn't bound the buffer.
+func WithPreloadBuffer(maxBytes uint64, spill *linking.LinkSystem) PreloadOption {
+	return func(cs *PreloadCachingStorage) {
+		cs.maxBuffered = maxBytes
+		cs.spillLinkSystem = spill
+	}
+}
+
 type PreloadStats struct {
 	// LoadCount is the number of times the Loader was called
 	LoadCount int
@@ -60,6 +86,16 @@ type PreloadStats struct {
 	// queued to be preloaded. This should only happen once per traversal (the
 	// root).
 	PreloadMisses int
+	// BufferedBytes is the size of the blocks in the cache that haven't been
+	// loaded by the traversal yet
+	BufferedBytes uint64
+	// Spilled is the number of blocks written to the spill LinkSystem as the
+	// buffer was full, see WithPreloadBuffer
+	Spilled int
+	// SkippedPreloads is the number of links that weren't preloaded as the
+	// buffer was full and there was nowhere to spill them, see
+	// WithPreloadBuffer
+	SkippedPreloads int
 }
 
 // PreloadedPercent returns the percentage of loads that were hits in the
@@ -93,19 +129,29 @@ func (s PreloadStats) Print() {
 	fmt.Printf("%25s: %v\n", "preloaded hits", s.PreloadedHits)
 	fmt.Printf("%25s: %v\n", "preloading hits", s.PreloadingHits)
 	fmt.Printf("%25s: %v\n", "preload misses", s.PreloadMisses)
+	fmt.Printf("%25s: %v\n", "buffered bytes", s.BufferedBytes)
+	fmt.Printf("%25s: %v\n", "spilled", s.Spilled)
+	fmt.Printf("%25s: %v\n", "skipped preloads", s.SkippedPreloads)
 	fmt.Printf("%25s: %v%%\n", "preloaded hit percent", s.PreloadedPercent())
 	fmt.Printf("%25s: %v%%\n", "preloading hit percent", s.PreloadingPercent())
 }
 
 // GetStats returns the current stats for the PreloadCachingStorage.
 func (cs *PreloadCachingStorage) GetStats() PreloadStats {
+	cs.preloadsLk.RLock()
+	defer cs.preloadsLk.RUnlock()
+	cs.bufferLk.Lock()
+	defer cs.bufferLk.Unlock()
 	return PreloadStats{
-		LoadCount:      cs.loadCount,
-		ActivePreloads: len(cs.preloads),
-		NotFound:       len(cs.notFound),
-		PreloadedHits:  cs.preloadedHits,
-		PreloadingHits: cs.preloadingHits,
-		PreloadMisses:  cs.preloadMisses,
+		LoadCount:       cs.loadCount,
+		ActivePreloads:  len(cs.preloads),
+		NotFound:        len(cs.notFound),
+		PreloadedHits:   cs.preloadedHits,
+		PreloadingHits:  cs.preloadingHits,
+		PreloadMisses:   cs.preloadMisses,
+		BufferedBytes:   cs.buffered,
+		Spilled:         len(cs.spilled),
+		SkippedPreloads: cs.skippedPreloads,
 	}
 }
 
@@ -124,11 +170,15 @@ func (cs *PreloadCachingStorage) GetStats() PreloadStats {
 //
 // The fetcher is used by both the preloader and the loader to fetch blocks. It
 // should be able to fetch blocks in a thread-safe manner.
+//
+// The blocks held in the cacheLinkSystem are unbounded unless configured with
+// WithPreloadBuffer.
 func NewPreloadCachingStorage(
 	parentLinkSystem linking.LinkSystem,
 	cacheLinkSystem linking.LinkSystem,
 	fetcher linking.BlockReadOpener,
 	workGroup groupworkpool.Group,
+	opts ...PreloadOption,
 ) (*PreloadCachingStorage, error) {
 	cs := &PreloadCachingStorage{
 		fetcher:          fetcher,
@@ -137,6 +187,19 @@ func NewPreloadCachingStorage(
 		cacheLinkSystem:  cacheLinkSystem,
 		notFound:         make(map[string]struct{}),
 		preloads:         make(map[ipld.Link]*preloadingLink),
+		bufferedSizes:    make(map[string]uint64),
+		spilled:          make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(cs)
+	}
+	if cs.maxBuffered > 0 {
+		// account for every block written to the cache, whether by the
+		// preloader or by bitswap directly, until the traversal loads it
+		cls := cacheLinkSystem
+		cls.StorageReadOpener = cs.bufferReadOpener(cacheLinkSystem.StorageReadOpener)
+		cls.StorageWriteOpener = cs.bufferWriteOpener(cacheLinkSystem.StorageWriteOpener)
+		cs.cacheLinkSystem = cls
 	}
 	// LinkSystem for traversal is a copy of the parent but with the read
 	// operation replaced with our multi-functional loader
@@ -186,18 +249,26 @@ func (cs *PreloadCachingStorage) Preloader(preloadCtx preload.PreloadContext, li
 
 	// check parent
 	if has, err := linkSystemHas(cs.parentLinkSystem, linkCtx, link.Link); err != nil {
-		logger.Errorf("parent LinkSystem block existence check failed: %s", err.Error())
+		logger.Errorw("parent LinkSystem block existence check failed", "link", link.Link, "err", err)
 	} else if has {
 		return
 	}
 
 	// check cache
 	if has, err := linkSystemHas(cs.cacheLinkSystem, linkCtx, link.Link); err != nil {
-		logger.Errorf("cache LinkSystem block existence check failed: %s", err.Error())
+		logger.Errorw("cache LinkSystem block existence check failed", "link", link.Link, "err", err)
 	} else if has {
 		return
 	}
 
+	// with nowhere to spill to, stop preloading until the traversal catches up
+	if cs.spillLinkSystem == nil && cs.bufferFull() {
+		cs.bufferLk.Lock()
+		cs.skippedPreloads++
+		cs.bufferLk.Unlock()
+		return
+	}
+
 	// haven't seen this link before, queue for preloading
 	pl := &preloadingLink{
 		loaded: make(chan struct{}),
@@ -208,7 +279,7 @@ func (cs *PreloadCachingStorage) Preloader(preloadCtx preload.PreloadContext, li
 	logger.Debugw("queueing preload link", "link", link.Link.String())
 	cs.workGroup.Enqueue(func() {
 		logger.Debugw("executing preload link", "link", link.Link.String())
-		cs.preloadLink(pl, linkCtx, link.Link)
+		cs.preloadLink(pl, linkCtx, link.Link, false)
 	})
 }
 
@@ -236,6 +307,7 @@ func (cs *PreloadCachingStorage) Loader(linkCtx linking.LinkContext, link ipld.L
 
 	// 1. Check the parent LinkSystem
 	if r, err := linkSystemGetStream(cs.parentLinkSystem, linkCtx, link); r != nil && err == nil {
+		cs.unbuffer(link)
 		return r, nil // found in parent, return
 	} else if err != nil {
 		if nf, ok := err.(interface{ NotFound() bool }); !ok || !nf.NotFound() {
@@ -279,18 +351,7 @@ func (cs *PreloadCachingStorage) Loader(linkCtx linking.LinkContext, link ipld.L
 		cs.preloadMisses++
 		// load directly from the fetcher, if it can be fetched
 		logger.Debugw("preload miss, fetching directly", "link", link)
-		r, err := cs.fetcher(linkCtx, link)
-		if err != nil {
-			if nf, ok := err.(interface{ NotFound() bool }); ok && nf.NotFound() {
-				cs.preloadsLk.Lock()
-				cs.notFound[string(link.(cidlink.Link).Cid.Hash())] = struct{}{}
-				cs.preloadsLk.Unlock()
-			}
-			return nil, err
-		}
-		logger.Debugw("load link successfully from after cache miss", "link", link)
-		// loaded from fetcher, write to parent and return
-		return cs.loadToParent(r, linkCtx, link)
+		return cs.fetchToParent(linkCtx, link)
 	}
 
 	// 4b. If the block is in the preload list
@@ -300,7 +361,7 @@ func (cs *PreloadCachingStorage) Loader(linkCtx linking.LinkContext, link ipld.L
 	// noop if it's in progress with the preloader; either way we wait on
 	// pl.loaded.
 	logger.Debugw("preload hit link, fetching directly via preload queue", "link", link.String())
-	cs.preloadLink(pl, linkCtx, link)
+	cs.preloadLink(pl, linkCtx, link, true)
 
 	select {
 	case <-linkCtx.Ctx.Done():
@@ -309,6 +370,11 @@ func (cs *PreloadCachingStorage) Loader(linkCtx linking.LinkContext, link ipld.L
 		if pl.err != nil {
 			return nil, pl.err
 		}
+		if pl.skipped {
+			// the preloader got to it first, while the buffer was full
+			logger.Debugw("preload skipped, fetching directly", "link", link)
+			return cs.fetchToParent(linkCtx, link)
+		}
 		// TODO: if an abstracted form of this code is extracted from here, we
 		// should probably make affordance to allow a "delete" of the preload
 		// entry since it shouldn't be needed in the preloader anymore. For the
@@ -332,6 +398,23 @@ func (cs *PreloadCachingStorage) Loader(linkCtx linking.LinkContext, link ipld.L
 	}
 }
 
+// fetchToParent loads a block from the fetcher and pipes it to the parent
+// LinkSystem, see loadToParent.
+func (cs *PreloadCachingStorage) fetchToParent(linkCtx linking.LinkContext, link ipld.Link) (io.Reader, error) {
+	r, err := cs.fetcher(linkCtx, link)
+	if err != nil {
+		if nf, ok := err.(interface{ NotFound() bool }); ok && nf.NotFound() {
+			cs.preloadsLk.Lock()
+			cs.notFound[string(link.(cidlink.Link).Cid.Hash())] = struct{}{}
+			cs.preloadsLk.Unlock()
+		}
+		return nil, err
+	}
+	logger.Debugw("load link successfully from after cache miss", "link", link)
+	// loaded from fetcher, write to parent and return
+	return cs.loadToParent(r, linkCtx, link)
+}
+
 // Load a block from a reader and pipe it to the parent LinkSystem and return as
 // a reader for the traverser.
 func (cs *PreloadCachingStorage) loadToParent(reader io.Reader, linkCtx linking.LinkContext, link ipld.Link) (io.Reader, error) {
@@ -346,13 +429,24 @@ func (cs *PreloadCachingStorage) loadToParent(reader io.Reader, linkCtx linking.
 	if err = c(link); err != nil {
 		return nil, err
 	}
+	cs.unbuffer(link)
 	return bytes.NewBuffer(byts), nil
 }
 
-func (cs *PreloadCachingStorage) preloadLink(pl *preloadingLink, linkCtx linking.LinkContext, link ipld.Link) {
+// preloadLink fetches a block into the cache, unless the buffer is full and
+// the traversal doesn't need the block yet, in which case it's skipped.
+func (cs *PreloadCachingStorage) preloadLink(pl *preloadingLink, linkCtx linking.LinkContext, link ipld.Link, needed bool) {
 	pl.loadSyncer.Do(func() {
-		logger.Debugw("preloadLink fetching", "link", link.String())
 		defer close(pl.loaded)
+		if !needed && cs.spillLinkSystem == nil && cs.bufferFull() {
+			logger.Debugw("preloadLink skipping, buffer full", "link", link.String())
+			cs.bufferLk.Lock()
+			cs.skippedPreloads++
+			cs.bufferLk.Unlock()
+			pl.skipped = true
+			return
+		}
+		logger.Debugw("preloadLink fetching", "link", link.String())
 		reader, err := cs.fetcher(linkCtx, link)
 		if err != nil {
 			if nf, ok := err.(interface{ NotFound() bool }); ok && nf.NotFound() {
@@ -386,6 +480,85 @@ func (cs *PreloadCachingStorage) preloadLink(pl *preloadingLink, linkCtx linking
 	})
 }
 
+// bufferFull returns true if the blocks in the cache not yet loaded by the
+// traversal have reached the maximum, see WithPreloadBuffer.
+func (cs *PreloadCachingStorage) bufferFull() bool {
+	if cs.maxBuffered == 0 {
+		return false
+	}
+	cs.bufferLk.Lock()
+	defer cs.bufferLk.Unlock()
+	return cs.buffered >= cs.maxBuffered
+}
+
+// unbuffer releases a block loaded by the traversal from the buffer.
+func (cs *PreloadCachingStorage) unbuffer(link ipld.Link) {
+	if cs.maxBuffered == 0 {
+		return
+	}
+	key := link.Binary()
+	cs.bufferLk.Lock()
+	defer cs.bufferLk.Unlock()
+	if size, ok := cs.bufferedSizes[key]; ok {
+		cs.buffered -= size
+		delete(cs.bufferedSizes, key)
+	}
+}
+
+// bufferWriteOpener counts the blocks written to the cache against the
+// buffer, writing them to the spill LinkSystem instead once it's full.
+func (cs *PreloadCachingStorage) bufferWriteOpener(cacheWriteOpener linking.BlockWriteOpener) linking.BlockWriteOpener {
+	return func(linkCtx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		if cs.spillLinkSystem != nil && cs.bufferFull() {
+			w, commit, err := cs.spillLinkSystem.StorageWriteOpener(linkCtx)
+			if err != nil {
+				return nil, nil, err
+			}
+			return w, func(link ipld.Link) error {
+				if err := commit(link); err != nil {
+					return err
+				}
+				cs.bufferLk.Lock()
+				cs.spilled[link.Binary()] = struct{}{}
+				cs.bufferLk.Unlock()
+				return nil
+			}, nil
+		}
+		w, commit, err := cacheWriteOpener(linkCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+		var size uint64
+		ccw := &cumulativeCountWriter{w, 0, commit, func(count uint64) { size = count }}
+		return ccw, func(link ipld.Link) error {
+			if err := ccw.Commit(link); err != nil {
+				return err
+			}
+			cs.bufferLk.Lock()
+			defer cs.bufferLk.Unlock()
+			if _, ok := cs.bufferedSizes[link.Binary()]; !ok {
+				cs.bufferedSizes[link.Binary()] = size
+				cs.buffered += size
+			}
+			return nil
+		}, nil
+	}
+}
+
+// bufferReadOpener reads the blocks written to the spill LinkSystem from it,
+// and the rest from the cache.
+func (cs *PreloadCachingStorage) bufferReadOpener(cacheReadOpener linking.BlockReadOpener) linking.BlockReadOpener {
+	return func(linkCtx linking.LinkContext, link ipld.Link) (io.Reader, error) {
+		cs.bufferLk.Lock()
+		_, spilled := cs.spilled[link.Binary()]
+		cs.bufferLk.Unlock()
+		if spilled {
+			return cs.spillLinkSystem.StorageReadOpener(linkCtx, link)
+		}
+		return cacheReadOpener(linkCtx, link)
+	}
+}
+
 // linkSystemHas is a Has() for a LinkSystem.
 func linkSystemHas(linkSys linking.LinkSystem, linkCtx linking.LinkContext, link ipld.Link) (bool, error) {
 	if linkSys.StorageReadOpener != nil {
@@ -0,0 +1,130 @@
+package bitswaphelpers_test
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/retriever/bitswaphelpers"
+	"github.com/filecoin-project/lassie/pkg/retriever/bitswaphelpers/groupworkpool"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/linking/preload"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// queuedGroup holds the work enqueued by the preloader until it's run
+type queuedGroup struct {
+	queue []groupworkpool.WorkFunc
+}
+
+func (qg *queuedGroup) Enqueue(work groupworkpool.WorkFunc) {
+	qg.queue = append(qg.queue, work)
+}
+
+func (qg *queuedGroup) run() {
+	for _, work := range qg.queue {
+		work()
+	}
+	qg.queue = nil
+}
+
+func TestPreloadCachingStorageBuffer(t *testing.T) {
+	ctx := context.Background()
+
+	// blocks of 1KiB each
+	source := &memstore.Store{}
+	var links []ipld.Link
+	var blocks [][]byte
+	for i := 0; i < 5; i++ {
+		data := make([]byte, 1024)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data)
+		require.NoError(t, err)
+		require.NoError(t, source.Put(ctx, c.KeyString(), data))
+		links = append(links, cidlink.Link{Cid: c})
+		blocks = append(blocks, data)
+	}
+	sourceLinkSys := cidlink.DefaultLinkSystem()
+	sourceLinkSys.SetReadStorage(source)
+
+	newLinkSys := func() linking.LinkSystem {
+		store := storage.NewDeferredStorageCarInMemory(links[0].(cidlink.Link).Cid)
+		t.Cleanup(func() { store.Close() })
+		lsys := cidlink.DefaultLinkSystem()
+		lsys.SetReadStorage(store)
+		lsys.SetWriteStorage(store)
+		return lsys
+	}
+	preloadAll := func(cs *bitswaphelpers.PreloadCachingStorage, links []ipld.Link) {
+		for _, link := range links {
+			cs.Preloader(preload.PreloadContext{Ctx: ctx, BasePath: datamodel.NewPath(nil)}, preload.Link{Link: link})
+		}
+	}
+	load := func(cs *bitswaphelpers.PreloadCachingStorage, i int) {
+		r, err := cs.Loader(linking.LinkContext{Ctx: ctx}, links[i])
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, blocks[i], data)
+	}
+
+	t.Run("paused", func(t *testing.T) {
+		group := &queuedGroup{}
+		cs, err := bitswaphelpers.NewPreloadCachingStorage(newLinkSys(), newLinkSys(), sourceLinkSys.StorageReadOpener, group, bitswaphelpers.WithPreloadBuffer(2<<10, nil))
+		require.NoError(t, err)
+
+		// preloading stops once the buffer is full
+		preloadAll(cs, links[:4])
+		group.run()
+		stats := cs.GetStats()
+		require.Equal(t, uint64(2<<10), stats.BufferedBytes)
+		require.Equal(t, 2, stats.SkippedPreloads)
+		preloadAll(cs, links[4:])
+		require.Equal(t, 3, cs.GetStats().SkippedPreloads)
+
+		// and the traversal gets every block, preloaded or not, releasing
+		// those preloaded from the buffer
+		for i := range links {
+			load(cs, i)
+		}
+		stats = cs.GetStats()
+		require.Equal(t, uint64(0), stats.BufferedBytes)
+		require.Equal(t, 2, stats.PreloadedHits)
+	})
+
+	t.Run("spilled", func(t *testing.T) {
+		group := &queuedGroup{}
+		spill := newLinkSys()
+		cs, err := bitswaphelpers.NewPreloadCachingStorage(newLinkSys(), newLinkSys(), sourceLinkSys.StorageReadOpener, group, bitswaphelpers.WithPreloadBuffer(2<<10, &spill))
+		require.NoError(t, err)
+
+		// preloading continues into the spill LinkSystem once the buffer is
+		// full
+		preloadAll(cs, links)
+		group.run()
+		stats := cs.GetStats()
+		require.Equal(t, uint64(2<<10), stats.BufferedBytes)
+		require.Equal(t, 3, stats.Spilled)
+		require.Zero(t, stats.SkippedPreloads)
+		for _, link := range links[2:] {
+			_, err := spill.StorageReadOpener(linking.LinkContext{Ctx: ctx}, link)
+			require.NoError(t, err)
+		}
+
+		for i := range links {
+			load(cs, i)
+		}
+		stats = cs.GetStats()
+		require.Equal(t, uint64(0), stats.BufferedBytes)
+		require.Equal(t, len(links), stats.PreloadedHits)
+	})
+}
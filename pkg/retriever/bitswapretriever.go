@@ -12,8 +12,10 @@ import (
 	"github.com/benbjohnson/clock"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/retriever/bitswaphelpers"
 	"github.com/filecoin-project/lassie/pkg/retriever/bitswaphelpers/groupworkpool"
+	"github.com/filecoin-project/lassie/pkg/storage"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/boxo/bitswap/client"
 	"github.com/ipfs/boxo/bitswap/network"
@@ -28,6 +30,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 )
 
@@ -64,6 +68,8 @@ type BitswapRetriever struct {
 	// this is purely for testing purposes, to ensure that we receive all candidates
 	awaitReceivedCandidates chan<- struct{}
 	groupWorkPool           groupworkpool.GroupWorkPool
+	activeRetrievals        atomic.Int64
+	sessions                *bitswapSessionPool
 }
 
 const shortenedDelay = 4 * time.Millisecond
@@ -73,6 +79,18 @@ type BitswapConfig struct {
 	BlockTimeout            time.Duration
 	Concurrency             int
 	ConcurrencyPerRetrieval int
+	// SessionIdleTimeout, if non-zero, shares a Bitswap session between
+	// retrievals that start with the same set of providers, keeping it for
+	// this long after the last of them finishes. Otherwise each block is
+	// requested with a session of its own.
+	SessionIdleTimeout time.Duration
+	// PreloadMaxBytes, if non-zero, bounds the size of the blocks that the
+	// preloader of each retrieval holds in its temporary storage before the
+	// traversal reaches them. Beyond it, further blocks are written to a
+	// temporary CAR in PreloadSpillDir, if set, or otherwise preloading
+	// pauses until the traversal catches up.
+	PreloadMaxBytes uint64
+	PreloadSpillDir string
 }
 
 // NewBitswapRetrieverFromHost constructs a new bitswap retriever for the given libp2p host
@@ -109,6 +127,11 @@ func NewBitswapRetrieverFromDeps(
 	gwp := groupworkpool.New(cfg.Concurrency, cfg.ConcurrencyPerRetrieval)
 	gwp.Start(ctx)
 
+	var sessions *bitswapSessionPool
+	if cfg.SessionIdleTimeout > 0 {
+		sessions = newBitswapSessionPool(ctx, bsrv, clock, cfg.SessionIdleTimeout)
+	}
+
 	return &BitswapRetriever{
 		bstore:                  bstore,
 		inProgressCids:          inProgressCids,
@@ -118,6 +141,7 @@ func NewBitswapRetrieverFromDeps(
 		cfg:                     cfg,
 		awaitReceivedCandidates: awaitReceivedCandidates,
 		groupWorkPool:           gwp,
+		sessions:                sessions,
 	}
 }
 
@@ -129,13 +153,19 @@ func (br *BitswapRetriever) Retrieve(
 ) types.CandidateRetrieval {
 	return &bitswapRetrieval{
 		BitswapRetriever: br,
-		bsGetter:         blockservice.NewSession(ctx, br.blockService),
+		bsGetter:         br.blockService,
 		ctx:              ctx,
 		request:          request,
 		events:           events,
 	}
 }
 
+// ActiveRetrievals returns the number of Bitswap retrievals that are currently
+// in progress.
+func (br *BitswapRetriever) ActiveRetrievals() int {
+	return int(br.activeRetrievals.Load())
+}
+
 type bitswapRetrieval struct {
 	*BitswapRetriever
 	bsGetter blockservice.BlockGetter
@@ -146,9 +176,16 @@ type bitswapRetrieval struct {
 
 // RetrieveFromCandidates retrieves via bitswap, with providers fed from the
 // given asyncCandidates.
-func (br *bitswapRetrieval) RetrieveFromAsyncCandidates(ayncCandidates types.InboundAsyncCandidates) (*types.RetrievalStats, error) {
-	ctx, cancelCtx := context.WithCancel(br.ctx)
+func (br *bitswapRetrieval) RetrieveFromAsyncCandidates(ayncCandidates types.InboundAsyncCandidates) (stats *types.RetrievalStats, err error) {
+	ctx, span := tracer.Start(br.ctx, "Retrieve", trace.WithAttributes(
+		attribute.String("retrievalId", br.request.RetrievalID.String()),
+		attribute.String("protocol", multicodec.TransportBitswap.String()),
+	))
+	defer func() { endSpan(span, err) }()
+	ctx, cancelCtx := context.WithCancel(ctx)
 	defer cancelCtx()
+	br.activeRetrievals.Add(1)
+	defer br.activeRetrievals.Add(-1)
 
 	// Perform the retrieval in a goroutine and use retrievalShared{} to handle
 	// event collection and dispatching on this goroutine; a bitswap traversal
@@ -159,21 +196,49 @@ func (br *bitswapRetrieval) RetrieveFromAsyncCandidates(ayncCandidates types.Inb
 	return collectResults(ctx, shared, br.events)
 }
 
+// preloadOptions returns the options bounding the preloader's buffer, see
+// BitswapConfig#PreloadMaxBytes, along with a function to close the temporary
+// CAR it spills to, which is only created once written to.
+func (br *bitswapRetrieval) preloadOptions() ([]bitswaphelpers.PreloadOption, func() error) {
+	if br.cfg.PreloadMaxBytes == 0 {
+		return nil, func() error { return nil }
+	}
+	if br.cfg.PreloadSpillDir == "" {
+		return []bitswaphelpers.PreloadOption{bitswaphelpers.WithPreloadBuffer(br.cfg.PreloadMaxBytes, nil)}, func() error { return nil }
+	}
+	spillStore := storage.NewDeferredStorageCar(br.cfg.PreloadSpillDir, br.request.Root)
+	spillLinkSys := cidlink.DefaultLinkSystem()
+	spillLinkSys.SetReadStorage(spillStore)
+	spillLinkSys.SetWriteStorage(spillStore)
+	spillLinkSys.TrustedStorage = true
+	return []bitswaphelpers.PreloadOption{bitswaphelpers.WithPreloadBuffer(br.cfg.PreloadMaxBytes, &spillLinkSys)}, spillStore.Close
+}
+
 func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates types.InboundAsyncCandidates, shared *retrievalShared) {
 	selector := br.request.GetSelector()
 	startTime := br.clock.Now()
 	// this is a hack cause we aren't able to track bitswap fetches per peer for now, so instead we just create a single peer for all events
 	bitswapCandidate := types.NewRetrievalCandidate(peer.ID(""), nil, br.request.Root, metadata.Bitswap{})
 
-	logger.Debugw("Starting bitswap retrieval", "retrievalID", br.request.RetrievalID, "root", br.request.Root)
+	log := logger.With(logging.RetrievalIDKey, br.request.RetrievalID, "root", br.request.Root)
+	log.Debugw("Starting bitswap retrieval")
 
 	// setup the linksystem to record bytes & blocks written -- since this isn't automatic w/o go-data-transfer
 	retrievalCtx, cancel := context.WithCancel(ctx)
 	var lastBytesReceivedTimer *clock.Timer
 	var doneLk sync.Mutex
 	var timedOut bool
-	if br.cfg.BlockTimeout != 0 {
-		lastBytesReceivedTimer = br.clock.AfterFunc(br.cfg.BlockTimeout, func() {
+	blockTimeout := br.cfg.BlockTimeout
+	if br.request.ProviderTimeout != 0 {
+		blockTimeout = br.request.ProviderTimeout
+	}
+	if blockTimeout != 0 {
+		lastBytesReceivedTimer = br.clock.AfterFunc(blockTimeout, func() {
+			if br.request.PauseControl.Paused() {
+				// nothing is requested while paused, so we can't time out
+				lastBytesReceivedTimer.Reset(blockTimeout)
+				return
+			}
 			cancel()
 			doneLk.Lock()
 			timedOut = true
@@ -201,25 +266,38 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 		}
 		// reset the timer
 		if bytesWritten > 0 && lastBytesReceivedTimer != nil {
-			lastBytesReceivedTimer.Reset(br.cfg.BlockTimeout)
+			lastBytesReceivedTimer.Reset(blockTimeout)
 		}
 	}
 
-	// setup providers for this retrieval
-	hasCandidates, nextCandidates, err := ayncCandidates.Next(retrievalCtx)
-	if !hasCandidates || err != nil {
-		cancel()
-		// we never received any candidates, so we give up on bitswap retrieval
-		logger.Debugw("No bitswap candidates received", "retrievalID", br.request.RetrievalID, "root", br.request.Root)
-		shared.sendResult(ctx, retrievalResult{Err: ErrNoCandidates, AllFinished: true})
-		return
+	// setup providers for this retrieval, skipping those beyond the
+	// retrieval's limit of attempted providers
+	attempts := attemptLimiterFrom(ctx)
+	var nextCandidates []types.RetrievalCandidate
+	for len(nextCandidates) == 0 {
+		hasCandidates, candidates, err := ayncCandidates.Next(retrievalCtx)
+		if !hasCandidates || err != nil {
+			cancel()
+			// we never received any candidates, so we give up on bitswap retrieval
+			log.Debugw("No bitswap candidates received")
+			shared.sendResult(ctx, retrievalResult{Err: ErrNoCandidates, AllFinished: true})
+			return
+		}
+		nextCandidates = attempts.filter(candidates)
 	}
 
 	shared.sendEvent(ctx, events.StartedRetrieval(br.clock.Now(), br.request.RetrievalID, bitswapCandidate, multicodec.TransportBitswap))
 
+	// share a session with other retrievals from the same providers
+	if br.sessions != nil {
+		var release func()
+		br.bsGetter, release = br.sessions.acquire(nextCandidates)
+		defer release()
+	}
+
 	// set initial providers, then start a goroutine to add more as they come in
 	br.routing.AddProviders(br.request.RetrievalID, nextCandidates)
-	logger.Debugf("Adding %d initial bitswap provider(s)", len(nextCandidates))
+	log.Debugw("Adding initial bitswap providers", "providerCount", len(nextCandidates))
 	go func() {
 		for {
 			hasCandidates, nextCandidates, err := ayncCandidates.Next(retrievalCtx)
@@ -232,8 +310,12 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 				}
 				return
 			}
+			nextCandidates = attempts.filter(nextCandidates)
+			if len(nextCandidates) == 0 {
+				continue
+			}
 			br.routing.AddProviders(br.request.RetrievalID, nextCandidates)
-			logger.Debugf("Adding %d more bitswap provider(s)", len(nextCandidates))
+			log.Debugw("Adding more bitswap providers", "providerCount", len(nextCandidates))
 		}
 	}()
 
@@ -243,13 +325,37 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 
 	loader := br.loader(ctx, shared)
 
+	// reject oversized blocks before they are stored; bitswap doesn't tie a
+	// block to a candidate so the sender is reported via a failure event
+	onBlockTooLarge := func(lctx linking.LinkContext) {
+		from, ok := bitswaphelpers.PeerFromContext(lctx.Ctx)
+		if !ok {
+			return
+		}
+		log.Warnw("Rejected oversized block", "peer", from, "maxBlockSize", br.request.MaxBlockSize)
+		shared.sendEvent(ctx, events.FailedRetrieval(
+			br.clock.Now(),
+			br.request.RetrievalID,
+			types.RetrievalCandidate{RootCid: br.request.Root, MinerPeer: peer.AddrInfo{ID: from}},
+			multicodec.TransportBitswap,
+			ErrBlockTooLarge.Error(),
+		))
+	}
+
 	if br.request.HasPreloadLinkSystem() {
+		preloadOpts, closeSpill := br.preloadOptions()
+		defer func() {
+			if err := closeSpill(); err != nil {
+				log.Warnw("Failed to remove preload spill storage", "err", err)
+			}
+		}()
 		var err error
 		storage, err := bitswaphelpers.NewPreloadCachingStorage(
 			br.request.LinkSystem,
 			br.request.PreloadLinkSystem,
 			loader,
 			br.groupWorkPool.AddGroup(retrievalCtx),
+			preloadOpts...,
 		)
 		if err != nil {
 			cancel()
@@ -259,24 +365,45 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 		preloader = storage.Preloader
 		traversalLinkSys = *storage.TraversalLinkSystem
 
+		bitswapLinkSys := limitBlockSize(*storage.BitswapLinkSystem, br.request.MaxBlockSize, onBlockTooLarge)
 		br.bstore.AddLinkSystem(
 			br.request.RetrievalID,
-			bitswaphelpers.NewByteCountingLinkSystem(storage.BitswapLinkSystem, blockWrittenCb),
+			bitswaphelpers.NewByteCountingLinkSystem(&bitswapLinkSys, blockWrittenCb),
 		)
 	} else {
+		bitswapLinkSys := limitBlockSize(br.request.LinkSystem, br.request.MaxBlockSize, onBlockTooLarge)
 		br.bstore.AddLinkSystem(
 			br.request.RetrievalID,
-			bitswaphelpers.NewByteCountingLinkSystem(&br.request.LinkSystem, blockWrittenCb),
+			bitswaphelpers.NewByteCountingLinkSystem(&bitswapLinkSys, blockWrittenCb),
 		)
 		traversalLinkSys.StorageReadOpener = loader
 	}
 
-	// run the retrieval
-	_, err = traversal.Config{
+	// consult the link policy before each link is fetched
+	traversalLinkSys.StorageReadOpener = policyReadOpener(br.request.LinkPolicy, traversalLinkSys.StorageReadOpener)
+	preloader = policyPreloader(br.request.LinkPolicy, preloader)
+
+	// stop requesting blocks while paused, restarting the timeout on resume
+	traversalLinkSys.StorageReadOpener = pauseReadOpener(retrievalCtx, br.request.PauseControl, traversalLinkSys.StorageReadOpener, func() {
+		if lastBytesReceivedTimer != nil {
+			lastBytesReceivedTimer.Reset(blockTimeout)
+		}
+	})
+	preloader = pausePreloader(retrievalCtx, br.request.PauseControl, preloader)
+
+	// run the retrieval, the traversal verifies each block as it's loaded
+	verifyCtx, verifySpan := tracer.Start(retrievalCtx, "Verify")
+	_, err := traversal.Config{
 		Root:      br.request.Root,
 		Selector:  selector,
 		MaxBlocks: br.request.MaxBlocks,
-	}.Traverse(retrievalCtx, traversalLinkSys, preloader)
+	}.Traverse(verifyCtx, traversalLinkSys, preloader)
+	err = ignoreLinkPolicySkip(err)
+	verifySpan.SetAttributes(
+		attribute.Int64("blocks", int64(blockCount.Load())),
+		attribute.Int64("bytes", int64(totalWritten.Load())),
+	)
+	endSpan(verifySpan, err)
 
 	cancel()
 
@@ -284,7 +411,7 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 	br.routing.RemoveProviders(br.request.RetrievalID)
 	br.bstore.RemoveLinkSystem(br.request.RetrievalID)
 	if err != nil {
-		logger.Debugw("Traversal/retrieval error, cleaning up", "err", err)
+		log.Debugw("Traversal/retrieval error, cleaning up", "err", err)
 		// check for timeout on the local context
 		// TODO: post 1.19 replace timedOut and doneLk with WithCancelCause and use DeadlineExceeded cause:
 		// if errors.Is(retrievalCtx.Err(), context.Canceled) && errors.Is(context.Cause(retrievalCtx), context.DeadlineExceeded) {
@@ -295,7 +422,7 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 				fmt.Errorf(
 					"%w after %s",
 					ErrRetrievalTimedOut,
-					br.cfg.BlockTimeout,
+					blockTimeout,
 				),
 			)
 		}
@@ -311,7 +438,7 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 	duration := br.clock.Since(startTime)
 	speed := uint64(float64(totalWritten.Load()) / duration.Seconds())
 
-	logger.Debugw("Bitswap retrieval success", "retrievalID", br.request.RetrievalID, "root", br.request.Root, "duration", duration, "bytes", totalWritten.Load(), "blocks", blockCount.Load(), "speed", speed)
+	log.Debugw("Bitswap retrieval success", "duration", duration, "bytes", totalWritten.Load(), "blocks", blockCount.Load(), "speed", speed)
 
 	// record success
 	shared.sendEvent(ctx, events.Success(
@@ -338,6 +465,7 @@ func (br *bitswapRetrieval) runRetrieval(ctx context.Context, ayncCandidates typ
 }
 
 func (br *bitswapRetrieval) loader(ctx context.Context, shared *retrievalShared) func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+	log := logger.With(logging.RetrievalIDKey, br.request.RetrievalID, "root", br.request.Root)
 	return func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
 		cidLink, ok := lnk.(cidlink.Link)
 		if !ok {
@@ -349,12 +477,12 @@ func (br *bitswapRetrieval) loader(ctx context.Context, shared *retrievalShared)
 			return nil, lctx.Ctx.Err()
 		default:
 		}
-		blk, err := br.blockService.GetBlock(lctx.Ctx, cidLink.Cid)
+		blk, err := br.bsGetter.GetBlock(lctx.Ctx, cidLink.Cid)
 		br.inProgressCids.Dec(cidLink.Cid, br.request.RetrievalID)
 		if err != nil {
 			return nil, err
 		}
-		logger.Debugw("Got block from bitswap", "retrievalID", br.request.RetrievalID, "root", br.request.Root, "block", cidLink.Cid, "size", len(blk.RawData()))
+		log.Debugw("Got block from bitswap", "block", cidLink.Cid, "size", len(blk.RawData()))
 		return bytes.NewReader(blk.RawData()), nil
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,9 +16,9 @@ import (
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/boxo/bitswap/client/traceability"
 	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/exchange"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
-	exchange "github.com/ipfs/go-ipfs-exchange-interface"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
@@ -59,6 +60,7 @@ func TestBitswapRetriever(t *testing.T) {
 	depth10Selector := ssb.ExploreRecursive(selector.RecursionLimitDepth(10),
 		ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
 	remoteBlockDuration := 50 * time.Millisecond
+	sharedCandidates := testutil.GenerateRetrievalCandidates(t, 5)
 
 	testCases := []struct {
 		name               string
@@ -71,6 +73,7 @@ func TestBitswapRetriever(t *testing.T) {
 		expectedErrors     map[cid.Cid]string
 		expectedCids       []cid.Cid
 		expectedRemoteCids map[cid.Cid][]cid.Cid
+		expectedSessions   int
 		cfg                retriever.BitswapConfig
 	}{
 		{
@@ -253,6 +256,84 @@ func TestBitswapRetriever(t *testing.T) {
 			},
 			expectedCandidates: map[cid.Cid][]types.RetrievalCandidate{},
 		},
+		{
+			name: "session shared by retrievals from the same providers",
+			remoteLinkSystems: map[cid.Cid]*linking.LinkSystem{
+				cid1: makeLsys(tbc1.AllBlocks(), false),
+				cid2: makeLsys(tbc2.AllBlocks(), false),
+			},
+			expectedCandidates: map[cid.Cid][]types.RetrievalCandidate{
+				cid1: sharedCandidates,
+				cid2: sharedCandidates,
+			},
+			expectedEvents: map[cid.Cid][]types.EventCode{
+				cid1: append(append([]types.EventCode{types.StartedRetrievalCode, types.FirstByteCode}, repeatCode(types.BlockReceivedCode, 100)...), types.SuccessCode),
+				cid2: append(append([]types.EventCode{types.StartedRetrievalCode, types.FirstByteCode}, repeatCode(types.BlockReceivedCode, 100)...), types.SuccessCode),
+			},
+			expectedStats: map[cid.Cid]*types.RetrievalStats{
+				cid1: {
+					RootCid:      cid1,
+					Size:         sizeOf(tbc1.AllBlocks()),
+					Blocks:       100,
+					Duration:     remoteBlockDuration * 100,
+					AverageSpeed: uint64(float64(sizeOf(tbc1.AllBlocks())) / (remoteBlockDuration * 100).Seconds()),
+					TotalPayment: big.Zero(),
+					AskPrice:     big.Zero(),
+				},
+				cid2: {
+					RootCid:      cid2,
+					Size:         sizeOf(tbc2.AllBlocks()),
+					Blocks:       100,
+					Duration:     remoteBlockDuration * 100,
+					AverageSpeed: uint64(float64(sizeOf(tbc2.AllBlocks())) / (remoteBlockDuration * 100).Seconds()),
+					TotalPayment: big.Zero(),
+					AskPrice:     big.Zero(),
+				},
+			},
+			expectedSessions: 1,
+			cfg: retriever.BitswapConfig{
+				SessionIdleTimeout: time.Hour,
+			},
+		},
+		{
+			name: "separate sessions for retrievals from different providers",
+			remoteLinkSystems: map[cid.Cid]*linking.LinkSystem{
+				cid1: makeLsys(tbc1.AllBlocks(), false),
+				cid2: makeLsys(tbc2.AllBlocks(), false),
+			},
+			expectedCandidates: map[cid.Cid][]types.RetrievalCandidate{
+				cid1: testutil.GenerateRetrievalCandidates(t, 5),
+				cid2: testutil.GenerateRetrievalCandidates(t, 7),
+			},
+			expectedEvents: map[cid.Cid][]types.EventCode{
+				cid1: append(append([]types.EventCode{types.StartedRetrievalCode, types.FirstByteCode}, repeatCode(types.BlockReceivedCode, 100)...), types.SuccessCode),
+				cid2: append(append([]types.EventCode{types.StartedRetrievalCode, types.FirstByteCode}, repeatCode(types.BlockReceivedCode, 100)...), types.SuccessCode),
+			},
+			expectedStats: map[cid.Cid]*types.RetrievalStats{
+				cid1: {
+					RootCid:      cid1,
+					Size:         sizeOf(tbc1.AllBlocks()),
+					Blocks:       100,
+					Duration:     remoteBlockDuration * 100,
+					AverageSpeed: uint64(float64(sizeOf(tbc1.AllBlocks())) / (remoteBlockDuration * 100).Seconds()),
+					TotalPayment: big.Zero(),
+					AskPrice:     big.Zero(),
+				},
+				cid2: {
+					RootCid:      cid2,
+					Size:         sizeOf(tbc2.AllBlocks()),
+					Blocks:       100,
+					Duration:     remoteBlockDuration * 100,
+					AverageSpeed: uint64(float64(sizeOf(tbc2.AllBlocks())) / (remoteBlockDuration * 100).Seconds()),
+					TotalPayment: big.Zero(),
+					AskPrice:     big.Zero(),
+				},
+			},
+			expectedSessions: 2,
+			cfg: retriever.BitswapConfig{
+				SessionIdleTimeout: time.Hour,
+			},
+		},
 		{
 			name: "timeout",
 			remoteLinkSystems: map[cid.Cid]*linking.LinkSystem{
@@ -504,6 +585,7 @@ func TestBitswapRetriever(t *testing.T) {
 						expectedCandidatesRemoved[rid2] = struct{}{}
 					}
 					req.Equal(expectedCandidatesRemoved, mir.candidatesRemoved)
+					req.Equal(testCase.expectedSessions, int(exchange.sessions.Load()))
 					if testCase.expectedCids != nil {
 						req.ElementsMatch(testCase.expectedCids, mipc.incremented)
 						req.ElementsMatch(testCase.expectedCids, mipc.decremented)
@@ -520,7 +602,8 @@ func TestBitswapRetriever(t *testing.T) {
 }
 
 type mockExchange struct {
-	getLsys func(ctx context.Context) (*linking.LinkSystem, error)
+	getLsys  func(ctx context.Context) (*linking.LinkSystem, error)
+	sessions atomic.Int32
 }
 
 // GetBlock returns the block associated with a given key.
@@ -560,6 +643,7 @@ func (me *mockExchange) Close() error {
 }
 
 func (me *mockExchange) NewSession(_ context.Context) exchange.Fetcher {
+	me.sessions.Add(1)
 	return me
 }
 
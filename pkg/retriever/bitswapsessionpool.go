@@ -0,0 +1,107 @@
+package retriever
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockservice"
+)
+
+// bitswapSessionPool shares Bitswap sessions between retrievals from the same
+// set of providers, so that near-simultaneous retrievals of clustered content,
+// such as a gateway serving the blocks of a popular DAG, benefit from the peers
+// and latencies that a session has already learned rather than each warming
+// up a fresh one. A session is kept for the pool's idle timeout after the last
+// retrieval using it finishes.
+type bitswapSessionPool struct {
+	ctx          context.Context
+	blockService blockservice.BlockService
+	clock        clock.Clock
+	idleTimeout  time.Duration
+
+	lk       sync.Mutex
+	sessions map[string]*pooledBitswapSession
+}
+
+type pooledBitswapSession struct {
+	getter blockservice.BlockGetter
+	cancel context.CancelFunc
+	refs   int
+	idle   *clock.Timer
+}
+
+func newBitswapSessionPool(ctx context.Context, blockService blockservice.BlockService, clock clock.Clock, idleTimeout time.Duration) *bitswapSessionPool {
+	return &bitswapSessionPool{
+		ctx:          ctx,
+		blockService: blockService,
+		clock:        clock,
+		idleTimeout:  idleTimeout,
+		sessions:     make(map[string]*pooledBitswapSession),
+	}
+}
+
+// acquire returns the session for retrieving from the given providers,
+// creating it if there isn't one, and the function to call when the retrieval
+// is done with it.
+func (bsp *bitswapSessionPool) acquire(candidates []types.RetrievalCandidate) (blockservice.BlockGetter, func()) {
+	key := providerSetKey(candidates)
+
+	bsp.lk.Lock()
+	defer bsp.lk.Unlock()
+	session, ok := bsp.sessions[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(bsp.ctx)
+		session = &pooledBitswapSession{getter: blockservice.NewSession(ctx, bsp.blockService), cancel: cancel}
+		bsp.sessions[key] = session
+	} else if session.idle != nil {
+		session.idle.Stop()
+		session.idle = nil
+	}
+	session.refs++
+
+	var once sync.Once
+	return session.getter, func() { once.Do(func() { bsp.release(key, session) }) }
+}
+
+func (bsp *bitswapSessionPool) release(key string, session *pooledBitswapSession) {
+	bsp.lk.Lock()
+	defer bsp.lk.Unlock()
+	session.refs--
+	if session.refs > 0 {
+		return
+	}
+	var idle *clock.Timer
+	idle = bsp.clock.AfterFunc(bsp.idleTimeout, func() {
+		bsp.lk.Lock()
+		defer bsp.lk.Unlock()
+		// acquired again, and maybe released again, while the timer fired
+		if session.idle != idle {
+			return
+		}
+		delete(bsp.sessions, key)
+		session.cancel()
+	})
+	session.idle = idle
+}
+
+// providerSetKey identifies the set of providers of the given candidates,
+// regardless of their order or duplicates.
+func providerSetKey(candidates []types.RetrievalCandidate) string {
+	ids := make([]string, 0, len(candidates))
+	seen := make(map[string]struct{}, len(candidates))
+	for _, candidate := range candidates {
+		id := string(candidate.MinerPeer.ID)
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, "\x00")
+}
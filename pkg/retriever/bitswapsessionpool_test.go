@@ -0,0 +1,53 @@
+package retriever
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitswapSessionPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := clock.NewMock()
+	bsrv := blockservice.New(blockstore.NewBlockstore(datastore.NewMapDatastore()), nil)
+	pool := newBitswapSessionPool(ctx, bsrv, clock, time.Minute)
+	candidates := testutil.GenerateRetrievalCandidates(t, 3)
+	reordered := []types.RetrievalCandidate{candidates[2], candidates[0], candidates[1], candidates[0]}
+
+	// retrievals from the same providers share a session, in any order
+	session1, release1 := pool.acquire(candidates)
+	session2, release2 := pool.acquire(reordered)
+	require.Same(t, session1, session2)
+	other, releaseOther := pool.acquire(candidates[1:])
+	require.NotSame(t, session1, other)
+	releaseOther()
+
+	// an idle session is kept until the idle timeout
+	release1()
+	release2()
+	release2() // releasing twice has no effect
+	clock.Add(time.Minute - time.Second)
+	session3, release3 := pool.acquire(candidates)
+	require.Same(t, session1, session3)
+	release3()
+	clock.Add(time.Minute - time.Second)
+	session4, release4 := pool.acquire(candidates)
+	require.Same(t, session1, session4)
+	release4()
+
+	clock.Add(time.Minute)
+	session5, release5 := pool.acquire(candidates)
+	defer release5()
+	require.NotSame(t, session1, session5)
+	// the other session expired too
+	require.Len(t, pool.sessions, 1)
+}
@@ -0,0 +1,58 @@
+package retriever
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/linking"
+)
+
+// ErrBlockTooLarge indicates that a provider sent a block larger than the
+// request's MaxBlockSize
+var ErrBlockTooLarge = errors.New("block exceeds maximum block size")
+
+// limitBlockSize returns a copy of the LinkSystem whose writes fail with
+// ErrBlockTooLarge as soon as a block grows beyond maxBlockSize bytes; no
+// bytes of an oversized block are passed on to the wrapped writer beyond the
+// limit. onTooLarge, if not nil, is called with the LinkContext of each
+// rejected write. A maxBlockSize of zero leaves the LinkSystem unchanged.
+func limitBlockSize(lsys linking.LinkSystem, maxBlockSize uint64, onTooLarge func(linking.LinkContext)) linking.LinkSystem {
+	if maxBlockSize == 0 || lsys.StorageWriteOpener == nil {
+		return lsys
+	}
+	bwo := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		lw := &limitedBlockWriter{w: w, max: maxBlockSize}
+		if onTooLarge != nil {
+			lw.onTooLarge = func() { onTooLarge(lctx) }
+		}
+		return lw, commit, nil
+	}
+	return lsys
+}
+
+type limitedBlockWriter struct {
+	w          io.Writer
+	n          uint64
+	max        uint64
+	onTooLarge func()
+	exceeded   bool
+}
+
+func (lw *limitedBlockWriter) Write(p []byte) (int, error) {
+	if lw.n+uint64(len(p)) > lw.max {
+		if !lw.exceeded && lw.onTooLarge != nil {
+			lw.onTooLarge()
+		}
+		lw.exceeded = true
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrBlockTooLarge, lw.max)
+	}
+	n, err := lw.w.Write(p)
+	lw.n += uint64(n)
+	return n, err
+}
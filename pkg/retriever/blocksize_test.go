@@ -0,0 +1,85 @@
+package retriever
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitBlockSize(t *testing.T) {
+	blk := testutil.GenerateBlocksOfSize(1, 100)[0]
+
+	testCases := []struct {
+		name          string
+		maxBlockSize  uint64
+		chunks        int
+		expectTooBig  bool
+		expectWritten int
+	}{
+		{
+			name:          "no limit",
+			maxBlockSize:  0,
+			chunks:        1,
+			expectWritten: 100,
+		},
+		{
+			name:          "at limit",
+			maxBlockSize:  100,
+			chunks:        1,
+			expectWritten: 100,
+		},
+		{
+			name:         "over limit",
+			maxBlockSize: 99,
+			chunks:       1,
+			expectTooBig: true,
+		},
+		{
+			name:          "over limit in chunks",
+			maxBlockSize:  60,
+			chunks:        4,
+			expectTooBig:  true,
+			expectWritten: 50,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := &memstore.Store{}
+			lsys := cidlink.DefaultLinkSystem()
+			lsys.SetWriteStorage(store)
+
+			var rejected int
+			lsys = limitBlockSize(lsys, tc.maxBlockSize, func(linking.LinkContext) { rejected++ })
+
+			w, commit, err := lsys.StorageWriteOpener(linking.LinkContext{})
+			require.NoError(t, err)
+			var written int
+			data := blk.RawData()
+			chunkSize := len(data) / tc.chunks
+			for i := 0; i < len(data) && err == nil; i += chunkSize {
+				var n int
+				n, err = w.Write(data[i : i+chunkSize])
+				written += n
+			}
+			require.Equal(t, tc.expectWritten, written)
+
+			if tc.expectTooBig {
+				require.ErrorIs(t, err, ErrBlockTooLarge)
+				require.Equal(t, 1, rejected)
+				require.Empty(t, store.Bag)
+				return
+			}
+			require.NoError(t, err)
+			require.Zero(t, rejected)
+			require.NoError(t, commit(cidlink.Link{Cid: blk.Cid()}))
+			require.True(t, bytes.Equal(data, store.Bag[string(blk.Cid().Hash())]))
+		})
+	}
+}
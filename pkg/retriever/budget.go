@@ -0,0 +1,191 @@
+package retriever
+
+import (
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// retrievalBudget tracks the unique blocks, and their bytes, written to the
+// LinkSystem of a retrieval and rejects writes that would go beyond the
+// request's MaxBlocks or MaxBytes. Blocks that have already been written may
+// be written again, as may happen when a retrieval moves on to another
+// candidate, without counting against the budget.
+type retrievalBudget struct {
+	maxBlocks  uint64
+	maxBytes   uint64
+	onExceeded func()
+	lk         sync.Mutex
+	seen       map[cid.Cid]struct{}
+	blocks     uint64
+	bytes      uint64
+	exhausted  bool
+}
+
+func newRetrievalBudget(maxBlocks uint64, maxBytes uint64, onExceeded func()) *retrievalBudget {
+	return &retrievalBudget{
+		maxBlocks:  maxBlocks,
+		maxBytes:   maxBytes,
+		onExceeded: onExceeded,
+		seen:       make(map[cid.Cid]struct{}),
+	}
+}
+
+// wrapWriteOpener returns a BlockWriteOpener that only commits blocks to the
+// wrapped BlockWriteOpener while they fit within the budget.
+func (rb *retrievalBudget) wrapWriteOpener(bwo linking.BlockWriteOpener) linking.BlockWriteOpener {
+	return func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		cw := &countingWriter{w: w}
+		return cw, func(lnk datamodel.Link) error {
+			if err := rb.add(lnk.(cidlink.Link).Cid, cw.n); err != nil {
+				return err
+			}
+			return commit(lnk)
+		}, nil
+	}
+}
+
+func (rb *retrievalBudget) add(c cid.Cid, size uint64) error {
+	rb.lk.Lock()
+	defer rb.lk.Unlock()
+
+	if _, ok := rb.seen[c]; ok {
+		return nil
+	}
+	if (rb.maxBlocks > 0 && rb.blocks >= rb.maxBlocks) || (rb.maxBytes > 0 && rb.bytes+size > rb.maxBytes) {
+		rb.exhausted = true
+		rb.onExceeded()
+		return ErrBudgetExceeded
+	}
+	rb.seen[c] = struct{}{}
+	rb.blocks++
+	rb.bytes += size
+	// the protocols enforce MaxBlocks themselves by stopping their traversal
+	// before loading another block, so the budget is exhausted here too
+	if rb.maxBlocks > 0 && rb.blocks >= rb.maxBlocks {
+		rb.exhausted = true
+	}
+	return nil
+}
+
+// expire exhausts the budget, such as when the request's MaxDuration has
+// elapsed, ending the retrieval with the blocks stored so far.
+func (rb *retrievalBudget) expire() {
+	rb.lk.Lock()
+	defer rb.lk.Unlock()
+	if rb.exhausted {
+		return
+	}
+	rb.exhausted = true
+	rb.onExceeded()
+}
+
+// isExhausted returns true if the budget has been reached, the number of
+// blocks and bytes stored within the budget are also returned.
+func (rb *retrievalBudget) isExhausted() (bool, uint64, uint64) {
+	rb.lk.Lock()
+	defer rb.lk.Unlock()
+	return rb.exhausted, rb.blocks, rb.bytes
+}
+
+// elapsedBudgetTaper is the fraction of a request's MaxDuration, at its end,
+// over which the depth of the links that are fetched is reduced.
+const elapsedBudgetTaper = 0.5
+
+// elapsedBudget reduces the depth of the DAG that a retrieval fetches as the
+// end of its MaxDuration approaches, so that it completes the shallower parts
+// of the DAG rather than going ever deeper into one branch. Through the taper
+// at the end of the duration the depth allowed falls from the deepest link
+// seen so far to none, while links along the request's path are always
+// fetched.
+type elapsedBudget struct {
+	clock    clock.Clock
+	start    time.Time
+	duration time.Duration
+	path     datamodel.Path
+	deepest  atomic.Int64
+}
+
+func newElapsedBudget(clock clock.Clock, request types.RetrievalRequest) *elapsedBudget {
+	return &elapsedBudget{
+		clock:    clock,
+		start:    clock.Now(),
+		duration: request.MaxDuration,
+		path:     datamodel.ParsePath(request.Path),
+	}
+}
+
+// linkPolicy returns a LinkPolicy that consults the given policy, if any, and
+// then skips the links deeper than the budget allows at the time.
+func (eb *elapsedBudget) linkPolicy(policy types.LinkPolicy) types.LinkPolicy {
+	return func(path datamodel.Path, c cid.Cid) types.LinkDecision {
+		if policy != nil {
+			if decision := policy(path, c); decision != types.LinkContinue {
+				return decision
+			}
+		}
+		depth := int64(path.Len())
+		for deepest := eb.deepest.Load(); depth > deepest; deepest = eb.deepest.Load() {
+			if eb.deepest.CompareAndSwap(deepest, depth) {
+				break
+			}
+		}
+		if eb.onPath(path) || depth <= eb.allowedDepth() {
+			return types.LinkContinue
+		}
+		return types.LinkSkip
+	}
+}
+
+// allowedDepth returns the depth of the deepest links that may be fetched
+// at the time.
+func (eb *elapsedBudget) allowedDepth() int64 {
+	taper := time.Duration(float64(eb.duration) * elapsedBudgetTaper)
+	remaining := eb.duration - eb.clock.Since(eb.start)
+	if remaining >= taper {
+		return math.MaxInt64
+	}
+	if remaining <= 0 || taper <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(eb.deepest.Load()) * float64(remaining) / float64(taper)))
+}
+
+// onPath returns whether the link is at, or on the way to, the request's
+// path.
+func (eb *elapsedBudget) onPath(path datamodel.Path) bool {
+	if path.Len() > eb.path.Len() {
+		return false
+	}
+	segments := eb.path.Segments()
+	for i, segment := range path.Segments() {
+		if segment.String() != segments[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package retriever
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrievalBudget(t *testing.T) {
+	blocks := testutil.GenerateBlocksOfSize(4, 100)
+
+	testCases := []struct {
+		name           string
+		maxBlocks      uint64
+		maxBytes       uint64
+		writes         []int
+		expectStored   []int
+		expectExceeded bool
+		expectBlocks   uint64
+		expectBytes    uint64
+	}{
+		{
+			name:         "within block budget",
+			maxBlocks:    4,
+			writes:       []int{0, 1, 2, 3},
+			expectStored: []int{0, 1, 2, 3},
+			expectBlocks: 4,
+			expectBytes:  400,
+		},
+		{
+			name:           "exceeds block budget",
+			maxBlocks:      2,
+			writes:         []int{0, 1, 2, 3},
+			expectStored:   []int{0, 1},
+			expectExceeded: true,
+			expectBlocks:   2,
+			expectBytes:    200,
+		},
+		{
+			name:           "exceeds byte budget",
+			maxBytes:       250,
+			writes:         []int{0, 1, 2, 3},
+			expectStored:   []int{0, 1},
+			expectExceeded: true,
+			expectBlocks:   2,
+			expectBytes:    200,
+		},
+		{
+			name:         "duplicates don't count",
+			maxBlocks:    2,
+			maxBytes:     200,
+			writes:       []int{0, 1, 0, 1, 1},
+			expectStored: []int{0, 1},
+			expectBlocks: 2,
+			expectBytes:  200,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := &memstore.Store{}
+			lsys := cidlink.DefaultLinkSystem()
+			lsys.SetWriteStorage(store)
+
+			var exceeded bool
+			budget := newRetrievalBudget(tc.maxBlocks, tc.maxBytes, func() { exceeded = true })
+			bwo := budget.wrapWriteOpener(lsys.StorageWriteOpener)
+
+			for _, i := range tc.writes {
+				w, commit, err := bwo(linking.LinkContext{})
+				require.NoError(t, err)
+				_, err = w.Write(blocks[i].RawData())
+				require.NoError(t, err)
+				err = commit(cidlink.Link{Cid: blocks[i].Cid()})
+				if err != nil {
+					require.ErrorIs(t, err, ErrBudgetExceeded)
+				}
+			}
+
+			require.Equal(t, tc.expectExceeded, exceeded)
+			exhausted, blockCount, byteCount := budget.isExhausted()
+			require.Equal(t, tc.expectExceeded || (tc.maxBlocks > 0 && blockCount >= tc.maxBlocks), exhausted)
+			require.Equal(t, tc.expectBlocks, blockCount)
+			require.Equal(t, tc.expectBytes, byteCount)
+
+			stored := make([]cid.Cid, 0, len(tc.expectStored))
+			for _, i := range tc.expectStored {
+				stored = append(stored, blocks[i].Cid())
+			}
+			require.Len(t, store.Bag, len(stored))
+			for _, c := range stored {
+				has, err := store.Has(context.Background(), cidlink.Link{Cid: c}.Binary())
+				require.NoError(t, err)
+				require.True(t, has)
+			}
+		})
+	}
+}
+
+func TestElapsedBudget(t *testing.T) {
+	clock := clock.NewMock()
+	c := testutil.GenerateCid()
+	budget := newElapsedBudget(clock, types.RetrievalRequest{
+		Request:     trustlessutils.Request{Root: c, Path: "a/b"},
+		MaxDuration: 10 * time.Second,
+	})
+	policy := budget.linkPolicy(func(path datamodel.Path, _ cid.Cid) types.LinkDecision {
+		if path.String() == "abort" {
+			return types.LinkAbort
+		}
+		return types.LinkContinue
+	})
+
+	steps := []struct {
+		elapsed  time.Duration
+		path     string
+		expected types.LinkDecision
+	}{
+		{0, "a/c/d/e/f", types.LinkContinue},
+		{0, "a/c/d/e/f/g/h/i", types.LinkContinue},
+		{0, "abort", types.LinkAbort},
+		// the taper starts halfway through, from the deepest link seen, 8
+		{5 * time.Second, "a/c/d/e/f/g/h/i", types.LinkContinue},
+		{6 * time.Second, "a/c/d/e/f/g/h", types.LinkContinue},
+		{6 * time.Second, "a/c/d/e/f/g/h/i", types.LinkSkip},
+		{9 * time.Second, "a/c", types.LinkContinue},
+		{9 * time.Second, "a/c/d", types.LinkSkip},
+		{9 * time.Second, "abort", types.LinkAbort},
+		// links along the path are always fetched
+		{10 * time.Second, "a", types.LinkContinue},
+		{10 * time.Second, "a/b", types.LinkContinue},
+		{10 * time.Second, "a/c", types.LinkSkip},
+		{10 * time.Second, "a/b/c", types.LinkSkip},
+	}
+	for _, step := range steps {
+		clock.Set(time.Unix(0, 0).Add(step.elapsed))
+		require.Equal(t, step.expected, policy(datamodel.ParsePath(step.path), c), "%s at %s", step.path, step.elapsed)
+	}
+
+	var exceeded int
+	retrievalBudget := newRetrievalBudget(0, 0, func() { exceeded++ })
+	retrievalBudget.expire()
+	retrievalBudget.expire()
+	require.Equal(t, 1, exceeded)
+	exhausted, _, _ := retrievalBudget.isExhausted()
+	require.True(t, exhausted)
+}
@@ -0,0 +1,194 @@
+package retriever
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multicodec"
+)
+
+// DefaultCapabilityTTL is how long the protocols that a provider was last
+// identified as supporting are relied on, see CapabilityCache.
+const DefaultCapabilityTTL = time.Hour
+
+// requiredProtocols are the libp2p protocols needed to retrieve with each
+// transport protocol, one of each group being needed.
+var requiredProtocols = map[multicodec.Code][][]protocol.ID{
+	multicodec.TransportGraphsyncFilecoinv1: {
+		{gsnet.ProtocolGraphsync_2_0_0},
+		{datatransfer.ProtocolDataTransfer1_2},
+	},
+	multicodec.TransportBitswap: {
+		{bsnet.ProtocolBitswap, bsnet.ProtocolBitswapOneOne, bsnet.ProtocolBitswapOneZero, bsnet.ProtocolBitswapNoVers},
+	},
+}
+
+// CapabilityCache caches the libp2p protocols that each provider supports, as
+// reported by the identify protocol when the libp2p host connects to it, so
+// that later retrievals can skip the candidates of providers that
+// demonstrably can't serve a transport protocol, such as a provider that no
+// longer runs Graphsync, rather than failing an attempt on each. A provider's
+// protocols are relied on for the cache's TTL after it was last identified,
+// and providers that haven't been identified are never skipped.
+type CapabilityCache struct {
+	clock clock.Clock
+	ttl   time.Duration
+
+	lk        sync.RWMutex
+	providers map[peer.ID]capabilities
+}
+
+type capabilities struct {
+	protocols  map[protocol.ID]struct{}
+	identified time.Time
+}
+
+// NewCapabilityCache returns a new CapabilityCache that relies on the
+// protocols of a provider for the given TTL.
+func NewCapabilityCache(ttl time.Duration) *CapabilityCache {
+	return NewCapabilityCacheWithClock(ttl, clock.New())
+}
+
+// NewCapabilityCacheWithClock returns a new CapabilityCache using the given
+// clock to expire providers' protocols.
+func NewCapabilityCacheWithClock(ttl time.Duration, clock clock.Clock) *CapabilityCache {
+	return &CapabilityCache{clock: clock, ttl: ttl, providers: make(map[peer.ID]capabilities)}
+}
+
+// Record records the libp2p protocols that a provider was identified as
+// supporting, replacing any recorded earlier. An empty list is ignored, as it
+// says more about a failed identification than about the provider.
+func (cc *CapabilityCache) Record(id peer.ID, protocols []protocol.ID) {
+	if len(protocols) == 0 {
+		return
+	}
+	set := make(map[protocol.ID]struct{}, len(protocols))
+	for _, p := range protocols {
+		set[p] = struct{}{}
+	}
+	cc.lk.Lock()
+	defer cc.lk.Unlock()
+	cc.providers[id] = capabilities{protocols: set, identified: cc.clock.Now()}
+}
+
+// Unsupported returns the reason that a provider can't serve the given
+// transport protocol, and true, if it was identified within the TTL as
+// supporting none of a group of the libp2p protocols that the transport
+// protocol needs.
+func (cc *CapabilityCache) Unsupported(id peer.ID, transport multicodec.Code) (string, bool) {
+	required, ok := requiredProtocols[transport]
+	if !ok {
+		return "", false
+	}
+	cc.lk.RLock()
+	caps, ok := cc.providers[id]
+	cc.lk.RUnlock()
+	if !ok || cc.clock.Since(caps.identified) > cc.ttl {
+		return "", false
+	}
+	for _, group := range required {
+		if !caps.supportsAny(group) {
+			names := make([]string, 0, len(group))
+			for _, p := range group {
+				names = append(names, string(p))
+			}
+			return fmt.Sprintf("provider does not support %s", strings.Join(names, " or ")), true
+		}
+	}
+	return "", false
+}
+
+func (c capabilities) supportsAny(protocols []protocol.ID) bool {
+	for _, p := range protocols {
+		if _, ok := c.protocols[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch records the protocols of the peers that the host is connected to,
+// and of each peer it identifies or whose protocols change from then on, until
+// the context is cancelled.
+func (cc *CapabilityCache) Watch(ctx context.Context, h host.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerProtocolsUpdated),
+	})
+	if err != nil {
+		return err
+	}
+	record := func(id peer.ID) {
+		protocols, err := h.Peerstore().GetProtocols(id)
+		if err != nil {
+			logger.Debugw("Failed to get protocols of peer", "peer", id, "err", err)
+			return
+		}
+		cc.Record(id, protocols)
+	}
+	for _, id := range h.Network().Peers() {
+		record(id)
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch evt := evt.(type) {
+				case event.EvtPeerIdentificationCompleted:
+					record(evt.Peer)
+				case event.EvtPeerProtocolsUpdated:
+					record(evt.Peer)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// filterCandidate removes the protocols of a candidate that its provider
+// demonstrably can't serve, reporting each with a CandidateSkippedEvent, and
+// returns false if none remain.
+func (cc *CapabilityCache) filterCandidate(
+	at time.Time,
+	retrievalId types.RetrievalID,
+	candidate types.RetrievalCandidate,
+	eventsCallback func(types.RetrievalEvent),
+) (bool, types.RetrievalCandidate) {
+	protocols := candidate.Metadata.Protocols()
+	supported := make([]metadata.Protocol, 0, len(protocols))
+	for _, protocol := range protocols {
+		if reason, unsupported := cc.Unsupported(candidate.MinerPeer.ID, protocol); unsupported {
+			eventsCallback(events.CandidateSkipped(at, retrievalId, candidate, protocol, reason))
+			continue
+		}
+		supported = append(supported, candidate.Metadata.Get(protocol))
+	}
+	if len(supported) == len(protocols) {
+		return true, candidate
+	}
+	return len(supported) != 0, types.RetrievalCandidate{
+		MinerPeer: candidate.MinerPeer,
+		RootCid:   candidate.RootCid,
+		Metadata:  metadata.Default.New(supported...),
+	}
+}
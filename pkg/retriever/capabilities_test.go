@@ -0,0 +1,182 @@
+package retriever_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	"github.com/ipfs/go-cid"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilityCacheUnsupported(t *testing.T) {
+	graphsyncProtocols := []protocol.ID{gsnet.ProtocolGraphsync_2_0_0, datatransfer.ProtocolDataTransfer1_2}
+	bitswapProtocols := []protocol.ID{bsnet.ProtocolBitswapOneOne}
+
+	testCases := []struct {
+		name        string
+		protocols   []protocol.ID
+		elapsed     time.Duration
+		transport   multicodec.Code
+		unsupported bool
+	}{
+		{
+			name:      "graphsync supported",
+			protocols: graphsyncProtocols,
+			transport: multicodec.TransportGraphsyncFilecoinv1,
+		},
+		{
+			name:        "graphsync without data transfer",
+			protocols:   []protocol.ID{gsnet.ProtocolGraphsync_2_0_0},
+			transport:   multicodec.TransportGraphsyncFilecoinv1,
+			unsupported: true,
+		},
+		{
+			name:        "graphsync on bitswap provider",
+			protocols:   bitswapProtocols,
+			transport:   multicodec.TransportGraphsyncFilecoinv1,
+			unsupported: true,
+		},
+		{
+			name:      "bitswap supported by any version",
+			protocols: bitswapProtocols,
+			transport: multicodec.TransportBitswap,
+		},
+		{
+			name:        "bitswap on graphsync provider",
+			protocols:   graphsyncProtocols,
+			transport:   multicodec.TransportBitswap,
+			unsupported: true,
+		},
+		{
+			name:      "http never skipped",
+			protocols: bitswapProtocols,
+			transport: multicodec.TransportIpfsGatewayHttp,
+		},
+		{
+			name:      "not identified",
+			transport: multicodec.TransportBitswap,
+		},
+		{
+			name:      "identified too long ago",
+			protocols: graphsyncProtocols,
+			elapsed:   2 * time.Minute,
+			transport: multicodec.TransportBitswap,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			clock := clock.NewMock()
+			cache := retriever.NewCapabilityCacheWithClock(time.Minute, clock)
+			id := testutil.GeneratePeers(t, 1)[0]
+			cache.Record(id, testCase.protocols)
+			clock.Add(testCase.elapsed)
+			reason, unsupported := cache.Unsupported(id, testCase.transport)
+			require.Equal(t, testCase.unsupported, unsupported)
+			if unsupported {
+				require.Contains(t, reason, "provider does not support")
+			} else {
+				require.Empty(t, reason)
+			}
+		})
+	}
+}
+
+func TestCapabilityCacheWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New()
+	defer mn.Close()
+	self, err := mn.GenPeer()
+	require.NoError(t, err)
+	provider, err := mn.GenPeer()
+	require.NoError(t, err)
+	provider.SetStreamHandler(bsnet.ProtocolBitswap, func(s network.Stream) { s.Close() })
+	require.NoError(t, mn.LinkAll())
+
+	cache := retriever.NewCapabilityCache(time.Hour)
+	require.NoError(t, cache.Watch(ctx, self))
+	_, err = mn.ConnectPeers(self.ID(), provider.ID())
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, unsupported := cache.Unsupported(provider.ID(), multicodec.TransportGraphsyncFilecoinv1)
+		return unsupported
+	}, time.Second, 10*time.Millisecond)
+	_, unsupported := cache.Unsupported(provider.ID(), multicodec.TransportBitswap)
+	require.False(t, unsupported)
+}
+
+func TestAssignableCandidateFinderWithCapabilities(t *testing.T) {
+	ctx := context.Background()
+	c := testutil.GenerateCid()
+	candidates := testutil.GenerateRetrievalCandidatesForCID(t, 3, c, &metadata.Bitswap{}, &metadata.GraphsyncFilecoinV1{})
+
+	cache := retriever.NewCapabilityCache(time.Hour)
+	// bitswap only, graphsync only, and not identified
+	cache.Record(candidates[0].MinerPeer.ID, []protocol.ID{bsnet.ProtocolBitswap})
+	cache.Record(candidates[1].MinerPeer.ID, []protocol.ID{gsnet.ProtocolGraphsync_2_0_0, datatransfer.ProtocolDataTransfer1_2})
+
+	finder := retriever.NewAssignableCandidateFinder(
+		testutil.NewMockCandidateFinder(nil, map[cid.Cid][]types.RetrievalCandidate{c: candidates}),
+		func(candidate types.RetrievalCandidate) (bool, types.RetrievalCandidate) { return true, candidate },
+	).WithCapabilities(cache)
+
+	rid, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	var skipped []events.CandidateSkippedEvent
+	var found []types.RetrievalCandidate
+	err = finder.FindCandidates(ctx, types.RetrievalRequest{
+		RetrievalID: rid,
+		Request:     trustlessutils.Request{Root: c},
+		LinkSystem:  cidlink.DefaultLinkSystem(),
+	}, func(evt types.RetrievalEvent) {
+		if evt, ok := evt.(events.CandidateSkippedEvent); ok {
+			skipped = append(skipped, evt)
+		}
+	}, func(incoming []types.RetrievalCandidate) {
+		found = append(found, incoming...)
+	})
+	require.NoError(t, err)
+
+	protocols := make(map[peer.ID][]multicodec.Code)
+	for _, candidate := range found {
+		protocols[candidate.MinerPeer.ID] = candidate.Metadata.Protocols()
+	}
+	require.Equal(t, map[peer.ID][]multicodec.Code{
+		candidates[0].MinerPeer.ID: {multicodec.TransportBitswap},
+		candidates[1].MinerPeer.ID: {multicodec.TransportGraphsyncFilecoinv1},
+		candidates[2].MinerPeer.ID: {multicodec.TransportBitswap, multicodec.TransportGraphsyncFilecoinv1},
+	}, protocols)
+
+	require.Len(t, skipped, 2)
+	skippedProtocols := make(map[peer.ID]multicodec.Code)
+	for _, evt := range skipped {
+		require.Equal(t, types.CandidateSkippedCode, evt.Code())
+		require.NotEmpty(t, evt.Reason())
+		skippedProtocols[evt.ProviderId()] = evt.Protocol()
+	}
+	require.Equal(t, map[peer.ID]multicodec.Code{
+		candidates[0].MinerPeer.ID: multicodec.TransportGraphsyncFilecoinv1,
+		candidates[1].MinerPeer.ID: multicodec.TransportBitswap,
+	}, skippedProtocols)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/multierr"
 )
 
@@ -143,11 +144,10 @@ func (pg *ProtocolGraphsync) Retrieve(
 		ss = string(byts)
 	}
 
-	logger.Infof(
-		"Attempting retrieval from SP %s for %s (with selector: [%s])",
-		candidate.MinerPeer.ID,
-		candidate.RootCid,
-		ss,
+	retrieval.log.Infow("Attempting retrieval from SP",
+		"storageProviderId", candidate.MinerPeer.ID,
+		"root", candidate.RootCid,
+		"selector", ss,
 	)
 
 	params, err := retrievaltypes.NewParamsV1(big.Zero(), 0, 0, selector, nil, big.Zero())
@@ -174,6 +174,12 @@ func (pg *ProtocolGraphsync) Retrieve(
 	if timeout != 0 {
 		lastBytesReceivedTimer = retrieval.parallelPeerRetriever.Clock.AfterFunc(timeout, func() {
 			doneLk.Lock()
+			if retrieval.request.PauseControl.Paused() {
+				// blocks aren't accepted while paused, so we can't time out
+				lastBytesReceivedTimer.Reset(timeout)
+				doneLk.Unlock()
+				return
+			}
 			done = true
 			timedOut = true
 			doneLk.Unlock()
@@ -221,9 +227,22 @@ func (pg *ProtocolGraphsync) Retrieve(
 		}
 	}
 
+	// hold up blocks while paused, restarting the timeout on resume
+	lsys := pauseLinkSystem(retrieveCtx, retrieval.request.LinkSystem, retrieval.request.PauseControl, func() {
+		if lastBytesReceivedTimer != nil {
+			doneLk.Lock()
+			if !done {
+				lastBytesReceivedTimer.Reset(timeout)
+			}
+			doneLk.Unlock()
+		}
+	})
+
+	// graphsync verifies each block against the selector as it's received
+	verifyCtx, span := tracer.Start(retrieveCtx, "Verify")
 	stats, err := pg.Client.RetrieveFromPeer(
-		retrieveCtx,
-		retrieval.request.LinkSystem,
+		verifyCtx,
+		applyLinkPolicy(limitBlockSize(lsys, retrieval.request.MaxBlockSize, nil), retrieval.request.LinkPolicy),
 		candidate.MinerPeer.ID,
 		proposal,
 		selector,
@@ -231,6 +250,13 @@ func (pg *ProtocolGraphsync) Retrieve(
 		eventsSubscriber,
 		gracefulShutdownChan,
 	)
+	if stats != nil {
+		span.SetAttributes(
+			attribute.Int64("blocks", int64(stats.Blocks)),
+			attribute.Int64("bytes", int64(stats.Size)),
+		)
+	}
+	endSpan(span, err)
 
 	if timedOut {
 		return nil, multierr.Append(ErrRetrievalFailed,
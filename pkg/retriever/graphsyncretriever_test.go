@@ -968,6 +968,38 @@ func TestRetrievalSelector(t *testing.T) {
 	require.Same(t, selector, rr.Selector)
 }
 
+func TestRetrievalProviderTimeout(t *testing.T) {
+	retrievalID := types.RetrievalID(uuid.New())
+	peerFoo := peer.ID("foo")
+	cid1 := cid.MustParse("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+	mockClient := testutil.NewMockClient(
+		map[string]testutil.DelayedConnectReturn{"foo": {Err: nil, Delay: 500 * time.Millisecond}},
+		map[string]testutil.DelayedClientReturn{"foo": {ResultStats: &types.RetrievalStats{StorageProviderId: peerFoo, Size: 2}, Delay: 0}},
+		clock.New(),
+	)
+
+	scfg := session.DefaultConfig().
+		WithDefaultProviderConfig(session.ProviderConfig{
+			RetrievalTimeout: time.Second,
+		}).
+		WithoutRandomness()
+	session := session.NewSession(scfg, true)
+	cfg := retriever.NewGraphsyncRetriever(session, mockClient)
+
+	// the request's timeout overrides the session's, failing the connection
+	retrieval := cfg.Retrieve(context.Background(), types.RetrievalRequest{
+		Request:         trustlessutils.Request{Root: cid1},
+		RetrievalID:     retrievalID,
+		LinkSystem:      cidlink.DefaultLinkSystem(),
+		ProviderTimeout: 20 * time.Millisecond,
+	}, nil)
+	start := time.Now()
+	stats, err := retrieval.RetrieveFromAsyncCandidates(makeAsyncCandidates(t, []types.RetrievalCandidate{types.NewRetrievalCandidate(peerFoo, nil, cid.Undef, &metadata.GraphsyncFilecoinV1{})}))
+	require.ErrorIs(t, err, retriever.ErrConnectFailed)
+	require.Nil(t, stats)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
 func TestDuplicateRetreivals(t *testing.T) {
 	retrievalID := types.RetrievalID(uuid.New())
 	peerFoo := peer.ID("foo")
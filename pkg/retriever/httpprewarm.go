@@ -0,0 +1,123 @@
+package retriever
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HttpPrewarm configures the warming of connections to high latency HTTP
+// providers. A single large transfer from a distant provider spends much of
+// its time in TCP or QUIC slow start, waiting on round trips for the
+// congestion window to grow. Before retrieving from a provider whose latest
+// time to first byte was at least MinLatency, Requests small requests, for
+// just the root block, are made to it in parallel so that the connections the
+// retrieval goes on to reuse have already grown their windows.
+//
+// Providers that haven't been retrieved from yet have no known latency and
+// are only warmed if MinLatency is zero. A Requests of zero, the default,
+// disables prewarming.
+type HttpPrewarm struct {
+	Requests   int
+	MinLatency time.Duration
+}
+
+// httpPrewarmer remembers the latency of each HTTP provider host to decide
+// which are worth warming up.
+type httpPrewarmer struct {
+	cfg       HttpPrewarm
+	lk        sync.Mutex
+	latencies map[string]time.Duration
+}
+
+func newHttpPrewarmer(cfg HttpPrewarm) *httpPrewarmer {
+	return &httpPrewarmer{
+		cfg:       cfg,
+		latencies: make(map[string]time.Duration),
+	}
+}
+
+// recordLatency records the time to first byte of a retrieval from the host.
+func (pw *httpPrewarmer) recordLatency(host string, latency time.Duration) {
+	if pw.cfg.Requests <= 0 {
+		return
+	}
+	pw.lk.Lock()
+	defer pw.lk.Unlock()
+	pw.latencies[host] = latency
+}
+
+// shouldPrewarm returns whether the host should be warmed up, along with its
+// latest latency, which is zero if it isn't known.
+func (pw *httpPrewarmer) shouldPrewarm(host string) (time.Duration, bool) {
+	if pw.cfg.Requests <= 0 {
+		return 0, false
+	}
+	pw.lk.Lock()
+	defer pw.lk.Unlock()
+	latency, known := pw.latencies[host]
+	if pw.cfg.MinLatency > 0 && (!known || latency < pw.cfg.MinLatency) {
+		return latency, false
+	}
+	return latency, true
+}
+
+// prewarm makes the configured number of requests for the root block of the
+// request to the candidate in parallel, reading each response in full so
+// that its connection is left open for reuse. Failures are ignored, as
+// prewarming is only an optimisation, but aren't counted in the stats.
+func (ph *ProtocolHttp) prewarm(ctx context.Context, request types.RetrievalRequest, candidate types.RetrievalCandidate, latency time.Duration) *types.PrewarmStats {
+	ctx, span := tracer.Start(ctx, "Prewarm", trace.WithAttributes(
+		attribute.Int("requests", ph.prewarmer.cfg.Requests),
+		attribute.Int64("latencyMs", latency.Milliseconds()),
+	))
+	defer span.End()
+
+	blockRequest := request
+	blockRequest.Request = trustlessutils.Request{
+		Root:  request.Root,
+		Scope: trustlessutils.DagScopeBlock,
+	}
+	start := ph.Clock.Now()
+	var completed atomic.Int64
+	var received atomic.Uint64
+	var wg sync.WaitGroup
+	for i := 0; i < ph.prewarmer.cfg.Requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ph.beginRequest(ctx, blockRequest, candidate)
+			if err != nil {
+				logger.Debugw("prewarm request failed", logging.RetrievalIDKey, request.RetrievalID, "storageProviderId", candidate.MinerPeer.ID, "err", err)
+				return
+			}
+			defer resp.Body.Close()
+			// the root block can't be larger than the maximum block size, if
+			// the response is then the connection isn't worth reusing
+			read, err := io.Copy(io.Discard, io.LimitReader(resp.Body, int64(request.MaxBlockSize)+(64<<10)))
+			received.Add(uint64(read))
+			if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return
+			}
+			completed.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	stats := &types.PrewarmStats{
+		Requests: int(completed.Load()),
+		Bytes:    received.Load(),
+		Duration: ph.Clock.Since(start),
+		Latency:  latency,
+	}
+	span.SetAttributes(attribute.Int("completed", stats.Requests), attribute.Int64("bytes", int64(stats.Bytes)))
+	return stats
+}
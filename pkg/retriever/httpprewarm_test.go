@@ -0,0 +1,77 @@
+package retriever
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpPrewarmer(t *testing.T) {
+	type recorded struct {
+		host    string
+		latency time.Duration
+	}
+	farAndNear := []recorded{{"example.com", 150 * time.Millisecond}, {"near.example.com", time.Millisecond}}
+
+	testCases := []struct {
+		name          string
+		cfg           HttpPrewarm
+		recorded      []recorded
+		host          string
+		expectPrewarm bool
+		expectLatency time.Duration
+	}{
+		{
+			name:     "disabled",
+			recorded: []recorded{{"example.com", time.Second}},
+			host:     "example.com",
+		},
+		{
+			name:          "no minimum latency",
+			cfg:           HttpPrewarm{Requests: 2},
+			host:          "example.com",
+			expectPrewarm: true,
+		},
+		{
+			name: "unknown latency",
+			cfg:  HttpPrewarm{Requests: 2, MinLatency: 100 * time.Millisecond},
+			host: "example.com",
+		},
+		{
+			name:          "high latency",
+			cfg:           HttpPrewarm{Requests: 2, MinLatency: 100 * time.Millisecond},
+			recorded:      farAndNear,
+			host:          "example.com",
+			expectPrewarm: true,
+			expectLatency: 150 * time.Millisecond,
+		},
+		{
+			name:          "low latency",
+			cfg:           HttpPrewarm{Requests: 2, MinLatency: 100 * time.Millisecond},
+			recorded:      farAndNear,
+			host:          "near.example.com",
+			expectLatency: time.Millisecond,
+		},
+		{
+			name:          "latest latency",
+			cfg:           HttpPrewarm{Requests: 2, MinLatency: 100 * time.Millisecond},
+			recorded:      []recorded{{"example.com", time.Second}, {"example.com", 50 * time.Millisecond}},
+			host:          "example.com",
+			expectLatency: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			pw := newHttpPrewarmer(tc.cfg)
+			for _, r := range tc.recorded {
+				pw.recordLatency(r.host, r.latency)
+			}
+			latency, prewarm := pw.shouldPrewarm(tc.host)
+			require.Equal(t, tc.expectPrewarm, prewarm)
+			require.Equal(t, tc.expectLatency, latency)
+		})
+	}
+}
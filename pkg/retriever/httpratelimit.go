@@ -0,0 +1,149 @@
+package retriever
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// HttpRateLimit is a token bucket limit on the rate of requests made to a
+// single HTTP provider. A RequestsPerSecond of zero means no limit. Burst is
+// the number of requests that may be made at once before the rate applies,
+// it defaults to 1.
+type HttpRateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// HttpRateLimits configures the outbound request rate limits of the HTTP
+// retriever. Default applies to each provider separately, keyed by host, and
+// Hosts overrides it for particular providers, keyed by either "host:port" or
+// just "host".
+type HttpRateLimits struct {
+	Default HttpRateLimit
+	Hosts   map[string]HttpRateLimit
+}
+
+// httpRateLimiter holds a token bucket for each HTTP provider host so that
+// requests to the same provider from parallel retrievals are smoothed out
+// rather than arriving in bursts.
+type httpRateLimiter struct {
+	limits  HttpRateLimits
+	clock   clock.Clock
+	lk      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newHttpRateLimiter(limits HttpRateLimits, clock clock.Clock) *httpRateLimiter {
+	return &httpRateLimiter{
+		limits:  limits,
+		clock:   clock,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Wait blocks until a request may be made to the host, or until the context
+// is done, in which case the context's error is returned.
+func (rl *httpRateLimiter) Wait(ctx context.Context, host string) error {
+	bucket := rl.bucket(host)
+	if bucket == nil {
+		return nil
+	}
+	delay := bucket.reserve(rl.clock.Now())
+	if delay <= 0 {
+		return nil
+	}
+	logger.Debugw("Delaying HTTP request for rate limit", "host", host, "delay", delay)
+	timer := rl.clock.Timer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		bucket.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (rl *httpRateLimiter) bucket(host string) *tokenBucket {
+	limit := rl.limitFor(host)
+	if limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	bucket, ok := rl.buckets[host]
+	if !ok {
+		bucket = newTokenBucket(limit, rl.clock.Now())
+		rl.buckets[host] = bucket
+	}
+	return bucket
+}
+
+func (rl *httpRateLimiter) limitFor(host string) HttpRateLimit {
+	if limit, ok := rl.limits.Hosts[host]; ok {
+		return limit
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if limit, ok := rl.limits.Hosts[hostname]; ok {
+			return limit
+		}
+	}
+	return rl.limits.Default
+}
+
+// tokenBucket hands out reservations rather than rejecting requests over the
+// limit; the token count goes negative when requests are queued, and each
+// reservation is told how long to wait for its token.
+type tokenBucket struct {
+	lk     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit HttpRateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.RequestsPerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it.
+func (tb *tokenBucket) reserve(now time.Time) time.Duration {
+	tb.lk.Lock()
+	defer tb.lk.Unlock()
+	if now.After(tb.last) {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+	}
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// cancel returns the token of a reservation that won't be used.
+func (tb *tokenBucket) cancel() {
+	tb.lk.Lock()
+	defer tb.lk.Unlock()
+	tb.tokens++
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
@@ -0,0 +1,110 @@
+package retriever
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpRateLimiter(t *testing.T) {
+	limits := HttpRateLimits{
+		Default: HttpRateLimit{RequestsPerSecond: 2},
+		Hosts: map[string]HttpRateLimit{
+			"fast.example.com":      {RequestsPerSecond: 10, Burst: 3},
+			"slow.example.com:8080": {RequestsPerSecond: 0.5},
+			"free.example.com":      {},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		limits       HttpRateLimits
+		host         string
+		expectDelays []time.Duration
+	}{
+		{
+			name:         "no limits",
+			host:         "example.com",
+			expectDelays: []time.Duration{0, 0, 0, 0},
+		},
+		{
+			name:         "default limit",
+			limits:       limits,
+			host:         "example.com",
+			expectDelays: []time.Duration{0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond},
+		},
+		{
+			name:         "host override with burst",
+			limits:       limits,
+			host:         "fast.example.com",
+			expectDelays: []time.Duration{0, 0, 0, 100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:         "host override with port",
+			limits:       limits,
+			host:         "slow.example.com:8080",
+			expectDelays: []time.Duration{0, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:         "hostname override applies to any port",
+			limits:       limits,
+			host:         "fast.example.com:443",
+			expectDelays: []time.Duration{0, 0, 0, 100 * time.Millisecond},
+		},
+		{
+			name:         "host override without limit",
+			limits:       limits,
+			host:         "free.example.com",
+			expectDelays: []time.Duration{0, 0, 0, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			clock := clock.NewMock()
+			rl := newHttpRateLimiter(tc.limits, clock)
+			for i, expectDelay := range tc.expectDelays {
+				bucket := rl.bucket(tc.host)
+				if expectDelay == 0 && bucket == nil {
+					continue
+				}
+				require.NotNil(t, bucket)
+				require.Equal(t, expectDelay, bucket.reserve(clock.Now()), "request %d", i)
+			}
+		})
+	}
+}
+
+func TestHttpRateLimiterWait(t *testing.T) {
+	ctx := context.Background()
+	clock := clock.NewMock()
+	rl := newHttpRateLimiter(HttpRateLimits{Default: HttpRateLimit{RequestsPerSecond: 1}}, clock)
+
+	// the first request uses the burst
+	require.NoError(t, rl.Wait(ctx, "example.com"))
+
+	// the second waits for a token
+	waited := make(chan error)
+	go func() { waited <- rl.Wait(ctx, "example.com") }()
+	require.Eventually(t, func() bool {
+		clock.Add(100 * time.Millisecond)
+		select {
+		case err := <-waited:
+			require.NoError(t, err)
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	require.GreaterOrEqual(t, clock.Now().Sub(time.Unix(0, 0)), time.Second)
+
+	// a cancelled wait returns its token
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, rl.Wait(cctx, "example.com"), context.Canceled)
+	require.Equal(t, time.Duration(0), rl.bucket("example.com").reserve(clock.Now().Add(time.Second)))
+}
@@ -1,6 +1,7 @@
 package retriever
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -12,12 +13,21 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/lassie/pkg/build"
 	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
 	trustlesshttp "github.com/ipld/go-trustless-utils/http"
 	"github.com/ipld/go-trustless-utils/traversal"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 var (
@@ -43,12 +53,18 @@ var _ TransportProtocol = &ProtocolHttp{}
 type ProtocolHttp struct {
 	Client *http.Client
 	Clock  clock.Clock
+
+	rateLimiter *httpRateLimiter
+	prewarmer   *httpPrewarmer
 }
 
 // NewHttpRetriever makes a new CandidateRetriever for verified CAR HTTP
-// retrievals (transport-ipfs-gateway-http).
-func NewHttpRetriever(session Session, client *http.Client) types.CandidateRetriever {
-	return NewHttpRetrieverWithDeps(session, client, clock.New(), nil, HttpDefaultInitialWait, false)
+// retrievals (transport-ipfs-gateway-http). Requests to each provider are
+// limited to the rate given by rateLimits, the zero value imposes no limits,
+// and connections to high latency providers are warmed up according to
+// prewarm, the zero value disables it.
+func NewHttpRetriever(session Session, client *http.Client, rateLimits HttpRateLimits, prewarm HttpPrewarm) types.CandidateRetriever {
+	return NewHttpRetrieverWithDeps(session, client, clock.New(), nil, HttpDefaultInitialWait, false, rateLimits, prewarm)
 }
 
 func NewHttpRetrieverWithDeps(
@@ -58,11 +74,15 @@ func NewHttpRetrieverWithDeps(
 	awaitReceivedCandidates chan<- struct{},
 	initialPause time.Duration,
 	noDirtyClose bool,
+	rateLimits HttpRateLimits,
+	prewarm HttpPrewarm,
 ) types.CandidateRetriever {
 	return &parallelPeerRetriever{
 		Protocol: &ProtocolHttp{
-			Client: client,
-			Clock:  clock,
+			Client:      client,
+			Clock:       clock,
+			rateLimiter: newHttpRateLimiter(rateLimits, clock),
+			prewarmer:   newHttpPrewarmer(prewarm),
 		},
 		Session:                 session,
 		Clock:                   clock,
@@ -105,6 +125,17 @@ func (ph *ProtocolHttp) Retrieve(
 	// by requesting but not reading bodies (or delayed reading which may result in
 	// timeouts).
 
+	var host string
+	if candidateURL, err := candidate.ToURL(); err == nil {
+		host = candidateURL.Host
+	}
+	// warm up the connection to a high latency provider first, the time
+	// spent doing so is recorded in its own stats rather than the retrieval's
+	var prewarmStats *types.PrewarmStats
+	if latency, ok := ph.prewarmer.shouldPrewarm(host); ok && host != "" {
+		prewarmStats = ph.prewarm(ctx, retrieval.request, candidate, latency)
+	}
+
 	retrievalStart := ph.Clock.Now()
 
 	resp, err := ph.beginRequest(ctx, retrieval.request, candidate)
@@ -126,11 +157,32 @@ func (ph *ProtocolHttp) Retrieve(
 	var ttfb time.Duration
 	rdr := newTimeToFirstByteReader(resp.Body, func() {
 		ttfb = retrieval.Clock.Since(retrievalStart)
+		ph.prewarmer.recordLatency(host, ttfb)
 		shared.sendEvent(ctx, events.FirstByte(retrieval.Clock.Now(), retrieval.request.RetrievalID, candidate, ttfb, multicodec.TransportIpfsGatewayHttp))
 	})
+	// An explicit selector can't be sent over HTTP, so we fetch using the
+	// path and scope of the request and then prune the result locally to
+	// just the blocks the selector matches.
+	verifyLsys := retrieval.request.LinkSystem
+	var pruneStore *memstore.Store
+	if retrieval.request.Selector != nil {
+		pruneStore = &memstore.Store{}
+		verifyLsys = cidlink.DefaultLinkSystem()
+		verifyLsys.SetReadStorage(pruneStore)
+		verifyLsys.SetWriteStorage(pruneStore)
+		verifyLsys.TrustedStorage = true
+		unixfsnode.AddUnixFSReificationToLinkSystem(&verifyLsys)
+	}
+	verifyLsys = limitBlockSize(verifyLsys, retrieval.request.MaxBlockSize, nil)
+	verifyLsys = pauseLinkSystem(ctx, verifyLsys, retrieval.request.PauseControl, nil)
+	if pruneStore == nil {
+		// the link policy is applied while pruning when there's a selector
+		verifyLsys = applyLinkPolicy(verifyLsys, retrieval.request.LinkPolicy)
+	}
+
 	cfg := traversal.Config{
 		Root:               retrieval.request.Root,
-		Selector:           retrieval.request.GetSelector(),
+		Selector:           retrieval.request.Request.Selector(),
 		ExpectDuplicatesIn: expectDuplicates,
 		// write out the same as we get in  so we're not causing waste here,
 		// dealing with the actual output duplicates requirements can be done
@@ -142,11 +194,23 @@ func (ph *ProtocolHttp) Retrieve(
 		},
 	}
 
-	traversalResult, err := cfg.VerifyCar(ctx, rdr, retrieval.request.LinkSystem)
+	verifyCtx, span := tracer.Start(ctx, "Verify")
+	traversalResult, err := cfg.VerifyCar(verifyCtx, rdr, verifyLsys)
+	span.SetAttributes(
+		attribute.Int64("blocks", int64(traversalResult.BlocksIn)),
+		attribute.Int64("bytes", int64(traversalResult.BytesIn)),
+	)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
 
+	if pruneStore != nil {
+		if err := pruneToSelector(ctx, retrieval.request, pruneStore); err != nil {
+			return nil, err
+		}
+	}
+
 	duration := retrieval.Clock.Since(retrievalStart)
 	speed := uint64(float64(traversalResult.BytesIn) / duration.Seconds())
 
@@ -161,36 +225,77 @@ func (ph *ProtocolHttp) Retrieve(
 		NumPayments:       0,
 		AskPrice:          big.Zero(),
 		TimeToFirstByte:   ttfb,
+		Prewarm:           prewarmStats,
 	}, nil
 }
 
+// pruneToSelector runs the request's explicit selector over the blocks
+// fetched into the store, copying each block it loads into the request's
+// LinkSystem.
+func pruneToSelector(ctx context.Context, request types.RetrievalRequest, store *memstore.Store) error {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	lsys.StorageReadOpener = policyReadOpener(request.LinkPolicy, func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		data, err := store.Get(lctx.Ctx, lnk.Binary())
+		if err != nil {
+			return nil, err
+		}
+		w, commit, err := request.LinkSystem.StorageWriteOpener(lctx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := commit(lnk); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	})
+	cfg := traversal.Config{
+		Root:      request.Root,
+		Selector:  request.Selector,
+		MaxBlocks: request.MaxBlocks,
+	}
+	if _, err := cfg.Traverse(ctx, lsys, nil); ignoreLinkPolicySkip(err) != nil {
+		return fmt.Errorf("failed to apply selector to HTTP response: %w", err)
+	}
+	return nil
+}
+
 func (ph *ProtocolHttp) beginRequest(ctx context.Context, request types.RetrievalRequest, candidate types.RetrievalCandidate) (resp *http.Response, err error) {
 	var req *http.Request
 	req, err = makeRequest(ctx, request, candidate)
-	logger.Debugf("HTTP request: %s", req.URL.String())
-	if err == nil {
-		resp, err = ph.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = ph.rateLimiter.Wait(ctx, req.URL.Host); err != nil {
+		return nil, err
 	}
-	return resp, err
+	logger.Debugw("HTTP request", logging.RetrievalIDKey, request.RetrievalID, "url", req.URL)
+	// providers that trace their own work can join the retrieval's trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return ph.Client.Do(req)
 }
 
 func makeRequest(ctx context.Context, request types.RetrievalRequest, candidate types.RetrievalCandidate) (*http.Request, error) {
 	candidateURL, err := candidate.ToURL()
 	if err != nil {
-		logger.Warnf("Couldn't construct a url for miner %s: %v", candidate.MinerPeer.ID, err)
+		logger.Warnw("Couldn't construct a url for miner", logging.RetrievalIDKey, request.RetrievalID, "storageProviderId", candidate.MinerPeer.ID, "err", err)
 		return nil, fmt.Errorf("%w: %v", ErrNoHttpForPeer, err)
 	}
 
 	path, err := request.Request.UrlPath()
 	if err != nil {
-		logger.Warnf("Couldn't construct a url path for request: %v", err)
+		logger.Warnw("Couldn't construct a url path for request", logging.RetrievalIDKey, request.RetrievalID, "err", err)
 		return nil, fmt.Errorf("%w: %v", ErrBadPathForRequest, err)
 	}
 
 	reqURL := fmt.Sprintf("%s/ipfs/%s%s", candidateURL, request.Root, path)
 	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
-		logger.Warnf("Couldn't construct a http request %s: %v", candidate.MinerPeer.ID, err)
+		logger.Warnw("Couldn't construct a http request", logging.RetrievalIDKey, request.RetrievalID, "storageProviderId", candidate.MinerPeer.ID, "err", err)
 		return nil, fmt.Errorf("%w for peer %s: %v", ErrBadPathForRequest, candidate.MinerPeer.ID, err)
 	}
 	req.Header.Add("Accept", trustlesshttp.DefaultContentType().String()) // prefer duplicates
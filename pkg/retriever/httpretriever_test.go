@@ -25,6 +25,8 @@ import (
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
@@ -74,19 +76,26 @@ func TestHTTPRetriever(t *testing.T) {
 	rid2 := types.RetrievalID(uuid.New())
 	remoteBlockDuration := 50 * time.Millisecond
 	allSelector := selectorparse.CommonSelector_ExploreAllRecursively
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	tbc1Depth10 := ssb.ExploreRecursive(selector.RecursionLimitDepth(10),
+		ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("Parents", ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+		})).Node()
 	initialPause := 10 * time.Millisecond
 	startTime := time.Now().Add(time.Hour)
 	testCases := []struct {
-		name           string
-		requests       map[cid.Cid]types.RetrievalID
-		requestPath    map[cid.Cid]string
-		requestScope   map[cid.Cid]trustlessutils.DagScope
-		remotes        map[cid.Cid][]testutil.MockRoundTripRemote
-		sendDuplicates map[cid.Cid]bool // will default to true
-		expectedStats  map[cid.Cid]*types.RetrievalStats
-		expectedErrors map[cid.Cid]struct{}
-		expectedCids   map[cid.Cid][]cid.Cid // expected in this order
-		expectSequence []testutil.ExpectedActionsAtTime
+		name            string
+		requests        map[cid.Cid]types.RetrievalID
+		requestPath     map[cid.Cid]string
+		requestScope    map[cid.Cid]trustlessutils.DagScope
+		requestSelector map[cid.Cid]datamodel.Node
+		remotes         map[cid.Cid][]testutil.MockRoundTripRemote
+		sendDuplicates  map[cid.Cid]bool // will default to true
+		maxBlockSize    uint64
+		expectedStats   map[cid.Cid]*types.RetrievalStats
+		expectedErrors  map[cid.Cid]struct{}
+		expectedCids    map[cid.Cid][]cid.Cid // expected in this order
+		expectSequence  []testutil.ExpectedActionsAtTime
 	}{
 		{
 			name:     "single, one peer, success",
@@ -159,6 +168,78 @@ func TestHTTPRetriever(t *testing.T) {
 				},
 			}...),
 		},
+		{
+			name:            "single, one peer, explicit selector pruned locally",
+			requests:        map[cid.Cid]types.RetrievalID{cid1: rid1},
+			requestSelector: map[cid.Cid]datamodel.Node{cid1: tbc1Depth10},
+			remotes: map[cid.Cid][]testutil.MockRoundTripRemote{
+				cid1: {
+					{
+						Peer:       cid1Cands[0].MinerPeer,
+						LinkSystem: *makeLsys(tbc1.AllBlocks(), false),
+						Selector:   allSelector,
+						RespondAt:  startTime.Add(initialPause + time.Millisecond*40),
+					},
+				},
+			},
+			expectedCids: map[cid.Cid][]cid.Cid{cid1: tbc1Cids[:10]},
+			expectedStats: map[cid.Cid]*types.RetrievalStats{
+				cid1: {
+					RootCid:           cid1,
+					StorageProviderId: cid1Cands[0].MinerPeer.ID,
+					Size:              sizeOf(tbc1.AllBlocks()),
+					Blocks:            100,
+					Duration:          40*time.Millisecond + remoteBlockDuration*100,
+					AverageSpeed:      uint64(float64(sizeOf(tbc1.AllBlocks())) / (40*time.Millisecond + remoteBlockDuration*100).Seconds()),
+					TimeToFirstByte:   40 * time.Millisecond,
+					TotalPayment:      big.Zero(),
+					AskPrice:          big.Zero(),
+				},
+			},
+			expectSequence: append(append([]testutil.ExpectedActionsAtTime{
+				{
+					AfterStart: 0,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.StartedRetrieval(startTime, rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp),
+						events.ConnectedToProvider(startTime, rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_Connect, Provider: cid1Cands[0].MinerPeer.ID, Duration: 0},
+					},
+				},
+				{
+					AfterStart:         initialPause,
+					ReceivedRetrievals: []peer.ID{cid1Cands[0].MinerPeer.ID},
+				},
+				{
+					AfterStart: initialPause + time.Millisecond*40,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.FirstByte(startTime.Add(initialPause+time.Millisecond*40), rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), time.Millisecond*40, multicodec.TransportIpfsGatewayHttp),
+						events.BlockReceived(startTime.Add(initialPause+time.Millisecond*40), rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp, uint64(len(tbc1.Blocks(0, 1)[0].RawData()))),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_FirstByte, Provider: cid1Cands[0].MinerPeer.ID, Duration: time.Millisecond * 40},
+					},
+				},
+			},
+				testutil.BlockReceivedActions(startTime, initialPause+time.Millisecond*40+remoteBlockDuration, rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp, remoteBlockDuration, tbc1.Blocks(1, 100))...), []testutil.ExpectedActionsAtTime{
+				{
+					AfterStart: initialPause + time.Millisecond*40 + remoteBlockDuration*100,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.Success(startTime.Add(initialPause+time.Millisecond*40+remoteBlockDuration*100), rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), sizeOf(tbc2.AllBlocks()), 100, 40*time.Millisecond+remoteBlockDuration*100, multicodec.TransportIpfsGatewayHttp),
+					},
+					ServedRetrievals: []testutil.RemoteStats{
+						{
+							Peer:      cid1Cands[0].MinerPeer.ID,
+							Root:      cid1,
+							ByteCount: sizeOf(tbc1.AllBlocks()),
+							Blocks:    tbc1Cids,
+						},
+					},
+					CompletedRetrievals: []peer.ID{cid1Cands[0].MinerPeer.ID},
+				},
+			}...),
+		},
 		{
 			name:     "two parallel, one peer each, success",
 			requests: map[cid.Cid]types.RetrievalID{cid1: rid1, cid2: rid2},
@@ -608,6 +689,64 @@ func TestHTTPRetriever(t *testing.T) {
 				},
 			}...),
 		},
+		{
+			name:         "single, one peer, oversized block",
+			requests:     map[cid.Cid]types.RetrievalID{cid1: rid1},
+			maxBlockSize: 10,
+			remotes: map[cid.Cid][]testutil.MockRoundTripRemote{
+				cid1: {
+					{
+						Peer:       cid1Cands[0].MinerPeer,
+						LinkSystem: *makeLsys(tbc1.AllBlocks()[0:1], false),
+						Selector:   allSelector,
+						RespondAt:  startTime.Add(initialPause + time.Millisecond*40),
+					},
+				},
+			},
+			expectedErrors: map[cid.Cid]struct{}{
+				cid1: {},
+			},
+			expectSequence: []testutil.ExpectedActionsAtTime{
+				{
+					AfterStart: 0,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.StartedRetrieval(startTime, rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp),
+						events.ConnectedToProvider(startTime, rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_Connect, Provider: cid1Cands[0].MinerPeer.ID},
+					},
+				},
+				{
+					AfterStart:         initialPause,
+					ReceivedRetrievals: []peer.ID{cid1Cands[0].MinerPeer.ID},
+				},
+				{
+					AfterStart: initialPause + time.Millisecond*40,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.FirstByte(startTime.Add(initialPause+time.Millisecond*40), rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), time.Millisecond*40, multicodec.TransportIpfsGatewayHttp),
+						events.BlockReceived(startTime.Add(initialPause+time.Millisecond*40), rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp, uint64(len(tbc1.Blocks(0, 1)[0].RawData()))),
+						events.FailedRetrieval(startTime.Add(initialPause+time.Millisecond*40), rid1, toCandidate(cid1, cid1Cands[0].MinerPeer), multicodec.TransportIpfsGatewayHttp, "failed to load root node: failed to load root CID: block exceeds maximum block size: more than 10 bytes"),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_FirstByte, Provider: cid1Cands[0].MinerPeer.ID, Duration: time.Millisecond * 40},
+						{Type: testutil.SessionMetric_Failure, Provider: cid1Cands[0].MinerPeer.ID},
+					},
+				},
+				{
+					AfterStart: initialPause + time.Millisecond*40 + remoteBlockDuration,
+					ServedRetrievals: []testutil.RemoteStats{
+						{
+							Peer:      cid1Cands[0].MinerPeer.ID,
+							Root:      cid1,
+							ByteCount: sizeOf(tbc1.AllBlocks()[0:1]),
+							Blocks:    tbc1Cids[0:1],
+							Err:       struct{}{},
+						},
+					},
+				},
+			},
+		},
 		{
 			name:        "single, funky path",
 			requests:    map[cid.Cid]types.RetrievalID{funkyBlocks[0].Cid(): rid1},
@@ -886,7 +1025,7 @@ func TestHTTPRetriever(t *testing.T) {
 			mockSession := testutil.NewMockSession(ctx)
 			mockSession.SetCandidatePreferenceOrder(append(cid1Cands, cid2Cands...))
 			mockSession.SetProviderTimeout(10 * time.Second)
-			retriever := retriever.NewHttpRetrieverWithDeps(mockSession, client, clock, nil, initialPause, true)
+			retriever := retriever.NewHttpRetrieverWithDeps(mockSession, client, clock, nil, initialPause, true, retriever.HttpRateLimits{}, retriever.HttpPrewarm{})
 
 			blockAccounting := make([]*blockAccounter, 0)
 			expectedCids := make([][]cid.Cid, 0)
@@ -913,7 +1052,9 @@ func TestHTTPRetriever(t *testing.T) {
 							Path:  testCase.requestPath[c],
 							Scope: testCase.requestScope[c],
 						},
-						LinkSystem: *lsys,
+						LinkSystem:   *lsys,
+						Selector:     testCase.requestSelector[c],
+						MaxBlockSize: testCase.maxBlockSize,
 					}
 					candidates := toCandidates(c, testCase.remotes[c])
 					return retriever.Retrieve(context.Background(), request, eventsCb).
@@ -0,0 +1,151 @@
+package retriever
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Prober measures the round trip time to a candidate, such as with a libp2p
+// ping or an HTTP HEAD request.
+type Prober interface {
+	Probe(ctx context.Context, candidate types.RetrievalCandidate) (time.Duration, error)
+}
+
+// LatencyProbe configures the probing of candidates whose providers haven't
+// been connected to before, so that the session has a measure of their
+// latency to order them by rather than assuming they are average. For each
+// retrieval, up to MaxProbes such candidates are probed with the Prober,
+// concurrently and for at most Timeout each, and are passed on once their
+// probe has finished, whether or not it succeeded. The round trip time of a
+// successful probe is recorded as the provider's connect time.
+//
+// A nil Prober or a MaxProbes of zero, the default, disables probing.
+type LatencyProbe struct {
+	Prober    Prober
+	MaxProbes int
+	Timeout   time.Duration
+}
+
+func (lp LatencyProbe) enabled() bool {
+	return lp.Prober != nil && lp.MaxProbes > 0
+}
+
+// ProbeSession is the part of the Session that probed latencies are recorded
+// with.
+type ProbeSession interface {
+	FilterIndexerCandidate(candidate types.RetrievalCandidate) (bool, types.RetrievalCandidate)
+	HasConnectTime(storageProviderId peer.ID) bool
+	RecordConnectTime(storageProviderId peer.ID, connectTime time.Duration)
+}
+
+var _ CandidateFinder = LatencyProbeCandidateFinder{}
+
+// LatencyProbeCandidateFinder wraps a CandidateFinder, probing the latency of
+// the unknown providers of the candidates it finds, see LatencyProbe.
+type LatencyProbeCandidateFinder struct {
+	CandidateFinder
+	session ProbeSession
+	probe   LatencyProbe
+}
+
+// NewLatencyProbeCandidateFinder returns a new LatencyProbeCandidateFinder
+// for the given CandidateFinder, recording probed latencies with the session.
+func NewLatencyProbeCandidateFinder(finder CandidateFinder, session ProbeSession, probe LatencyProbe) LatencyProbeCandidateFinder {
+	return LatencyProbeCandidateFinder{CandidateFinder: finder, session: session, probe: probe}
+}
+
+func (lpf LatencyProbeCandidateFinder) FindCandidates(ctx context.Context, c cid.Cid) ([]types.RetrievalCandidate, error) {
+	if !lpf.probe.enabled() {
+		return lpf.CandidateFinder.FindCandidates(ctx, c)
+	}
+	found, err := lpf.CandidateFinder.FindCandidates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]types.RetrievalCandidate, 0, len(found))
+	prober := lpf.newProber(ctx, func(candidate types.RetrievalCandidate) {
+		candidates = append(candidates, candidate)
+	})
+	for _, candidate := range found {
+		prober.onCandidate(candidate)
+	}
+	prober.wait()
+	return candidates, nil
+}
+
+func (lpf LatencyProbeCandidateFinder) FindCandidatesAsync(ctx context.Context, c cid.Cid, cb func(types.RetrievalCandidate)) error {
+	if !lpf.probe.enabled() {
+		return lpf.CandidateFinder.FindCandidatesAsync(ctx, c, cb)
+	}
+	prober := lpf.newProber(ctx, cb)
+	err := lpf.CandidateFinder.FindCandidatesAsync(ctx, c, prober.onCandidate)
+	// candidates may still be delivered until the probes have finished
+	prober.wait()
+	return err
+}
+
+// latencyProber passes on the candidates of a single query, probing those of
+// unknown providers within the query's budget of probes.
+type latencyProber struct {
+	ctx     context.Context
+	session ProbeSession
+	probe   LatencyProbe
+	cb      func(types.RetrievalCandidate)
+
+	lk     sync.Mutex // serialises calls to cb and guards probed
+	probed map[peer.ID]struct{}
+	wg     sync.WaitGroup
+}
+
+func (lpf LatencyProbeCandidateFinder) newProber(ctx context.Context, cb func(types.RetrievalCandidate)) *latencyProber {
+	return &latencyProber{
+		ctx:     ctx,
+		session: lpf.session,
+		probe:   lpf.probe,
+		cb:      cb,
+		probed:  make(map[peer.ID]struct{}),
+	}
+}
+
+func (lp *latencyProber) onCandidate(candidate types.RetrievalCandidate) {
+	lp.lk.Lock()
+	defer lp.lk.Unlock()
+	id := candidate.MinerPeer.ID
+	if _, ok := lp.probed[id]; ok || len(lp.probed) >= lp.probe.MaxProbes || lp.session.HasConnectTime(id) {
+		lp.cb(candidate)
+		return
+	}
+	if accept, _ := lp.session.FilterIndexerCandidate(candidate); !accept {
+		lp.cb(candidate)
+		return
+	}
+	lp.probed[id] = struct{}{}
+	lp.wg.Add(1)
+	go func() {
+		defer lp.wg.Done()
+		ctx := lp.ctx
+		if lp.probe.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, lp.probe.Timeout)
+			defer cancel()
+		}
+		rtt, err := lp.probe.Prober.Probe(ctx, candidate)
+		if err != nil {
+			logger.Debugw("Failed to probe latency of candidate", "providerId", id, "err", err)
+		} else {
+			lp.session.RecordConnectTime(id, rtt)
+		}
+		lp.lk.Lock()
+		defer lp.lk.Unlock()
+		lp.cb(candidate)
+	}()
+}
+
+func (lp *latencyProber) wait() {
+	lp.wg.Wait()
+}
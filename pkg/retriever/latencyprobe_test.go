@@ -0,0 +1,137 @@
+package retriever_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// mockProber measures a fixed round trip time for each peer, fails for the
+// peers in fail, and blocks until the probe times out for the peers in hang.
+type mockProber struct {
+	fail map[peer.ID]bool
+	hang map[peer.ID]bool
+
+	lk     sync.Mutex
+	probes []peer.ID
+}
+
+func (mp *mockProber) Probe(ctx context.Context, candidate types.RetrievalCandidate) (time.Duration, error) {
+	mp.lk.Lock()
+	mp.probes = append(mp.probes, candidate.MinerPeer.ID)
+	mp.lk.Unlock()
+	if mp.hang[candidate.MinerPeer.ID] {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	if mp.fail[candidate.MinerPeer.ID] {
+		return 0, errors.New("unreachable")
+	}
+	return 50 * time.Millisecond, nil
+}
+
+func TestLatencyProbeCandidateFinder(t *testing.T) {
+	c := testutil.GenerateCid()
+	// the first candidate's provider has been connected to before
+	candidates := testutil.GenerateRetrievalCandidatesForCID(t, 5, c)
+
+	testCases := []struct {
+		name             string
+		maxProbes        int
+		timeout          time.Duration
+		fail             []int
+		hang             []int
+		expectedProbes   int
+		expectedMeasured []int
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:             "probes unknown providers",
+			maxProbes:        10,
+			fail:             []int{2},
+			expectedProbes:   4,
+			expectedMeasured: []int{1, 3, 4},
+		},
+		{
+			name:             "bounded number of probes",
+			maxProbes:        2,
+			expectedProbes:   2,
+			expectedMeasured: []int{1, 2},
+		},
+		{
+			name:             "bounded probe time",
+			maxProbes:        10,
+			timeout:          50 * time.Millisecond,
+			hang:             []int{3},
+			expectedProbes:   4,
+			expectedMeasured: []int{1, 2, 4},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			for _, async := range []bool{false, true} {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				prober := &mockProber{fail: map[peer.ID]bool{}, hang: map[peer.ID]bool{}}
+				for _, i := range testCase.fail {
+					prober.fail[candidates[i].MinerPeer.ID] = true
+				}
+				for _, i := range testCase.hang {
+					prober.hang[candidates[i].MinerPeer.ID] = true
+				}
+				var probe retriever.LatencyProbe
+				if testCase.maxProbes > 0 {
+					probe = retriever.LatencyProbe{Prober: prober, MaxProbes: testCase.maxProbes, Timeout: testCase.timeout}
+				}
+				sess := session.NewSession(session.DefaultConfig(), true)
+				sess.RecordConnectTime(candidates[0].MinerPeer.ID, time.Second)
+				finder := retriever.NewLatencyProbeCandidateFinder(
+					testutil.NewMockCandidateFinder(nil, map[cid.Cid][]types.RetrievalCandidate{c: candidates}),
+					sess,
+					probe,
+				)
+
+				var found []types.RetrievalCandidate
+				if async {
+					var lk sync.Mutex
+					err := finder.FindCandidatesAsync(ctx, c, func(candidate types.RetrievalCandidate) {
+						lk.Lock()
+						defer lk.Unlock()
+						found = append(found, candidate)
+					})
+					require.NoError(t, err)
+				} else {
+					var err error
+					found, err = finder.FindCandidates(ctx, c)
+					require.NoError(t, err)
+				}
+				// every candidate is passed on, probed or not
+				require.ElementsMatch(t, candidates, found)
+				require.Len(t, prober.probes, testCase.expectedProbes)
+				require.NotContains(t, prober.probes, candidates[0].MinerPeer.ID)
+				var measured []int
+				for i := 1; i < len(candidates); i++ {
+					if sess.HasConnectTime(candidates[i].MinerPeer.ID) {
+						measured = append(measured, i)
+					}
+				}
+				require.Equal(t, testCase.expectedMeasured, measured)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package retriever
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+var _ types.CandidateRetriever = (*LazyRetriever)(nil)
+
+// LazyRetriever is a CandidateRetriever that defers constructing the
+// retriever it wraps until a retrieval receives its first candidate. This
+// allows expensive setup, such as starting a libp2p host, to be skipped
+// entirely when no candidates for the protocol are ever found.
+//
+// If construction fails, the retrieval fails with that error and construction
+// is attempted again on the next retrieval that receives a candidate.
+type LazyRetriever struct {
+	lk        sync.Mutex
+	init      func() (types.CandidateRetriever, error)
+	retriever types.CandidateRetriever
+}
+
+// NewLazyRetriever creates a LazyRetriever that calls init to construct the
+// underlying retriever the first time one is needed.
+func NewLazyRetriever(init func() (types.CandidateRetriever, error)) *LazyRetriever {
+	return &LazyRetriever{init: init}
+}
+
+func (lr *LazyRetriever) get() (types.CandidateRetriever, error) {
+	lr.lk.Lock()
+	defer lr.lk.Unlock()
+	if lr.retriever == nil {
+		retriever, err := lr.init()
+		if err != nil {
+			return nil, err
+		}
+		lr.retriever = retriever
+	}
+	return lr.retriever, nil
+}
+
+func (lr *LazyRetriever) Retrieve(ctx context.Context, request types.RetrievalRequest, events func(types.RetrievalEvent)) types.CandidateRetrieval {
+	return &lazyRetrieval{
+		lr:      lr,
+		ctx:     ctx,
+		request: request,
+		events:  events,
+	}
+}
+
+type lazyRetrieval struct {
+	lr      *LazyRetriever
+	ctx     context.Context
+	request types.RetrievalRequest
+	events  func(types.RetrievalEvent)
+}
+
+func (lr *lazyRetrieval) RetrieveFromAsyncCandidates(asyncCandidates types.InboundAsyncCandidates) (*types.RetrievalStats, error) {
+	hasCandidates, candidates, err := asyncCandidates.Next(lr.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCandidates {
+		return nil, ErrNoCandidates
+	}
+
+	retriever, err := lr.lr.get()
+	if err != nil {
+		return nil, err
+	}
+
+	// replay the candidates we've already consumed, then pass through the rest
+	inbound, outbound := types.MakeAsyncCandidates(1)
+	outbound <- candidates
+	go func() {
+		defer close(outbound)
+		for {
+			hasCandidates, candidates, err := asyncCandidates.Next(lr.ctx)
+			if !hasCandidates || err != nil {
+				return
+			}
+			if err := outbound.SendNext(lr.ctx, candidates); err != nil {
+				return
+			}
+		}
+	}()
+
+	return retriever.Retrieve(lr.ctx, lr.request, lr.events).RetrieveFromAsyncCandidates(inbound)
+}
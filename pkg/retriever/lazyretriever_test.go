@@ -0,0 +1,106 @@
+package retriever
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/stretchr/testify/require"
+)
+
+type collectingRetriever struct {
+	received [][]types.RetrievalCandidate
+}
+
+func (cr *collectingRetriever) Retrieve(ctx context.Context, request types.RetrievalRequest, events func(types.RetrievalEvent)) types.CandidateRetrieval {
+	return cr
+}
+
+func (cr *collectingRetriever) RetrieveFromAsyncCandidates(asyncCandidates types.InboundAsyncCandidates) (*types.RetrievalStats, error) {
+	for {
+		hasCandidates, candidates, err := asyncCandidates.Next(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if !hasCandidates {
+			return &types.RetrievalStats{}, nil
+		}
+		cr.received = append(cr.received, candidates)
+	}
+}
+
+func TestLazyRetriever(t *testing.T) {
+	root := testutil.GenerateCid()
+	peers := testutil.GeneratePeers(t, 3)
+	candidate := func(i int) types.RetrievalCandidate {
+		return types.NewRetrievalCandidate(peers[i], nil, root, &metadata.Bitswap{})
+	}
+	initErr := errors.New("init failed")
+
+	testCases := []struct {
+		name        string
+		batches     [][]types.RetrievalCandidate
+		initErrs    []error
+		expectInits int
+		expectErrs  []error
+	}{
+		{
+			name:        "no candidates",
+			batches:     nil,
+			expectInits: 0,
+			expectErrs:  []error{ErrNoCandidates, ErrNoCandidates},
+		},
+		{
+			name:        "candidates",
+			batches:     [][]types.RetrievalCandidate{{candidate(0)}, {candidate(1), candidate(2)}},
+			expectInits: 1,
+			expectErrs:  []error{nil, nil},
+		},
+		{
+			name:        "init failure is retried",
+			batches:     [][]types.RetrievalCandidate{{candidate(0)}},
+			initErrs:    []error{initErr},
+			expectInits: 2,
+			expectErrs:  []error{initErr, nil},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			var inits int
+			cr := &collectingRetriever{}
+			lr := NewLazyRetriever(func() (types.CandidateRetriever, error) {
+				inits++
+				if len(tc.initErrs) >= inits {
+					return nil, tc.initErrs[inits-1]
+				}
+				return cr, nil
+			})
+
+			for _, expectErr := range tc.expectErrs {
+				cr.received = nil
+				incoming, outgoing := types.MakeAsyncCandidates(len(tc.batches))
+				for _, batch := range tc.batches {
+					outgoing <- batch
+				}
+				close(outgoing)
+
+				stats, err := lr.Retrieve(ctx, types.RetrievalRequest{}, func(types.RetrievalEvent) {}).RetrieveFromAsyncCandidates(incoming)
+				if expectErr != nil {
+					require.ErrorIs(t, err, expectErr)
+					require.Nil(t, stats)
+					continue
+				}
+				require.NoError(t, err)
+				require.NotNil(t, stats)
+				require.Equal(t, tc.batches, cr.received)
+			}
+			require.Equal(t, tc.expectInits, inits)
+		})
+	}
+}
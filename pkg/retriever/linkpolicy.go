@@ -0,0 +1,141 @@
+package retriever
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/linking/preload"
+	ipldtraversal "github.com/ipld/go-ipld-prime/traversal"
+)
+
+// ErrLinkPolicyAbort indicates that the request's LinkPolicy ended the
+// retrieval upon encountering a link
+var ErrLinkPolicyAbort = errors.New("retrieval aborted by link policy")
+
+// checkLinkPolicy consults the policy for a link, returning nil if the link
+// should be loaded, a traversal.SkipMe if it should be skipped or an
+// ErrLinkPolicyAbort error. The root of the DAG, at the empty path, is not
+// subject to the policy.
+func checkLinkPolicy(policy types.LinkPolicy, path datamodel.Path, lnk datamodel.Link) error {
+	if policy == nil || path.Len() == 0 {
+		return nil
+	}
+	cl, ok := lnk.(cidlink.Link)
+	if !ok {
+		return nil
+	}
+	switch policy(path, cl.Cid) {
+	case types.LinkSkip:
+		return ipldtraversal.SkipMe{}
+	case types.LinkAbort:
+		return fmt.Errorf("%w: %s at /%s", ErrLinkPolicyAbort, cl.Cid, path)
+	default:
+		return nil
+	}
+}
+
+// policyReadOpener wraps a BlockReadOpener used by a local traversal so that
+// the policy is consulted before each link is loaded. Skipped links are not
+// loaded and the traversal doesn't descend below them.
+func policyReadOpener(policy types.LinkPolicy, bro linking.BlockReadOpener) linking.BlockReadOpener {
+	if policy == nil {
+		return bro
+	}
+	return func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		if err := checkLinkPolicy(policy, lctx.LinkPath, lnk); err != nil {
+			return nil, err
+		}
+		return bro(lctx, lnk)
+	}
+}
+
+// ignoreLinkPolicySkip filters out the error of a traversal that succeeded but
+// skipped links because of the link policy; the go-trustless-utils traversal
+// records the last block load error, including a SkipMe, and returns it after
+// a successful walk.
+func ignoreLinkPolicySkip(err error) error {
+	if errors.Is(err, ipldtraversal.SkipMe{}) {
+		return nil
+	}
+	return err
+}
+
+// policyPreloader wraps a preload.Loader so that links the policy doesn't
+// allow are not preloaded. Aborting is left to the traversal proper.
+func policyPreloader(policy types.LinkPolicy, loader preload.Loader) preload.Loader {
+	if policy == nil || loader == nil {
+		return loader
+	}
+	return func(pctx preload.PreloadContext, l preload.Link) {
+		if checkLinkPolicy(policy, pctx.BasePath.AppendSegment(l.Segment), l.Link) != nil {
+			return
+		}
+		loader(pctx, l)
+	}
+}
+
+// applyLinkPolicy returns a copy of the LinkSystem that consults the policy
+// for each block written to it, for use where the provider drives the
+// traversal and blocks arrive whether we want them or not. Blocks of skipped
+// links, and of all links below them, are received but not committed to the
+// wrapped LinkSystem; an abort fails the write with ErrLinkPolicyAbort.
+func applyLinkPolicy(lsys linking.LinkSystem, policy types.LinkPolicy) linking.LinkSystem {
+	if policy == nil || lsys.StorageWriteOpener == nil {
+		return lsys
+	}
+	skipped := &skippedPaths{}
+	bwo := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := bwo(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, func(lnk datamodel.Link) error {
+			if skipped.covers(lctx.LinkPath) {
+				return nil
+			}
+			err := checkLinkPolicy(policy, lctx.LinkPath, lnk)
+			if _, ok := err.(ipldtraversal.SkipMe); ok {
+				skipped.add(lctx.LinkPath)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return commit(lnk)
+		}, nil
+	}
+	return lsys
+}
+
+// skippedPaths records the paths skipped by a LinkPolicy so that the blocks
+// below them can be skipped too.
+type skippedPaths struct {
+	lk    sync.Mutex
+	paths []string
+}
+
+func (sp *skippedPaths) add(path datamodel.Path) {
+	sp.lk.Lock()
+	defer sp.lk.Unlock()
+	sp.paths = append(sp.paths, path.String())
+}
+
+func (sp *skippedPaths) covers(path datamodel.Path) bool {
+	sp.lk.Lock()
+	defer sp.lk.Unlock()
+	p := path.String()
+	for _, skipped := range sp.paths {
+		if p == skipped || strings.HasPrefix(p, skipped+"/") {
+			return true
+		}
+	}
+	return false
+}
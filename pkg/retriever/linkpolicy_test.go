@@ -0,0 +1,189 @@
+package retriever
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkPolicy(t *testing.T) {
+	ctx := context.Background()
+	rndReader := rand.New(rand.NewSource(2051))
+
+	srcStore := &memstore.Store{}
+	srcLsys := cidlink.DefaultLinkSystem()
+	srcLsys.TrustedStorage = true
+	srcLsys.SetReadStorage(srcStore)
+	srcLsys.SetWriteStorage(srcStore)
+	dir := testutil.GenerateNoDupes(func() unixfs.DirEntry {
+		return unixfs.GenerateDirectory(t, &srcLsys, rndReader, 1<<20, false)
+	})
+	require.NotEmpty(t, dir.Children)
+
+	allCids := toCids(testutil.ToBlocks(t, srcLsys, dir.Root, selectorparse.CommonSelector_ExploreAllRecursively))
+	skipped := dir.Children[0]
+	skippedCids := toCids(testutil.ToBlocks(t, srcLsys, skipped.Root, selectorparse.CommonSelector_ExploreAllRecursively))
+	var remainingCids []cid.Cid
+	for _, c := range allCids {
+		if !containsCid(skippedCids, c) {
+			remainingCids = append(remainingCids, c)
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		policy      func(c cid.Cid) types.LinkDecision
+		expectCids  []cid.Cid
+		expectAbort bool
+	}{
+		{
+			name:       "nil policy",
+			expectCids: allCids,
+		},
+		{
+			name:       "continue",
+			policy:     func(cid.Cid) types.LinkDecision { return types.LinkContinue },
+			expectCids: allCids,
+		},
+		{
+			name: "skip subtree",
+			policy: func(c cid.Cid) types.LinkDecision {
+				if c == skipped.Root {
+					return types.LinkSkip
+				}
+				return types.LinkContinue
+			},
+			expectCids: remainingCids,
+		},
+		{
+			name: "abort",
+			policy: func(c cid.Cid) types.LinkDecision {
+				if c == skipped.Root {
+					return types.LinkAbort
+				}
+				return types.LinkContinue
+			},
+			expectAbort: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		for _, local := range []bool{true, false} {
+			local := local
+			name := tc.name + "/provider traversal"
+			if local {
+				name = tc.name + "/local traversal"
+			}
+			t.Run(name, func(t *testing.T) {
+				var policy types.LinkPolicy
+				var consulted int
+				if tc.policy != nil {
+					policy = func(path datamodel.Path, c cid.Cid) types.LinkDecision {
+						require.NotZero(t, path.Len())
+						consulted++
+						return tc.policy(c)
+					}
+				}
+
+				dstStore := &memstore.Store{}
+				dstLsys := cidlink.DefaultLinkSystem()
+				dstLsys.TrustedStorage = true
+				dstLsys.SetReadStorage(dstStore)
+				dstLsys.SetWriteStorage(dstStore)
+
+				var fetched []cid.Cid
+				if !local {
+					dstLsys = applyLinkPolicy(dstLsys, policy)
+				}
+				fetch := func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+					c := lnk.(cidlink.Link).Cid
+					fetched = append(fetched, c)
+					data, err := srcStore.Get(ctx, lnk.Binary())
+					if err != nil {
+						return nil, err
+					}
+					w, commit, err := dstLsys.StorageWriteOpener(lctx)
+					if err != nil {
+						return nil, err
+					}
+					if _, err := w.Write(data); err != nil {
+						return nil, err
+					}
+					if err := commit(lnk); err != nil {
+						return nil, err
+					}
+					return bytes.NewReader(data), nil
+				}
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.TrustedStorage = true
+				lsys.StorageReadOpener = fetch
+				if local {
+					lsys.StorageReadOpener = policyReadOpener(policy, fetch)
+				}
+
+				_, err := traversal.Config{
+					Root:     dir.Root,
+					Selector: selectorparse.CommonSelector_ExploreAllRecursively,
+				}.Traverse(ctx, lsys, nil)
+				err = ignoreLinkPolicySkip(err)
+
+				if tc.expectAbort {
+					require.ErrorIs(t, err, ErrLinkPolicyAbort)
+					has, err := dstStore.Has(ctx, cidlink.Link{Cid: skipped.Root}.Binary())
+					require.NoError(t, err)
+					require.False(t, has)
+					return
+				}
+				require.NoError(t, err)
+				if policy != nil {
+					require.NotZero(t, consulted)
+				}
+				require.Len(t, dstStore.Bag, len(tc.expectCids))
+				for _, c := range tc.expectCids {
+					has, err := dstStore.Has(ctx, cidlink.Link{Cid: c}.Binary())
+					require.NoError(t, err)
+					require.True(t, has)
+				}
+				if local {
+					// skipped links are never fetched by a local traversal
+					require.Equal(t, tc.expectCids, fetched)
+				} else {
+					require.Equal(t, allCids, fetched)
+				}
+			})
+		}
+	}
+}
+
+func toCids(blks []blocks.Block) []cid.Cid {
+	cids := make([]cid.Cid, 0, len(blks))
+	for _, blk := range blks {
+		cids = append(cids, blk.Cid())
+	}
+	return cids
+}
+
+func containsCid(cids []cid.Cid, c cid.Cid) bool {
+	for _, other := range cids {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
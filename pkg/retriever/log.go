@@ -1,5 +1,20 @@
 package retriever
 
-import "github.com/ipfs/go-log/v2"
+import (
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
 
-var logger = log.Logger("lassie/retriever")
+var logger = logging.Subsystem("lassie/retriever")
+var tracer trace.Tracer = otel.Tracer("lassie")
+
+// endSpan ends the span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,96 @@
+package retriever
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var _ CandidateFinder = NetworkBlockCandidateFinder{}
+
+// NetworkBlockCandidateFinder wraps a CandidateFinder, dropping the candidates
+// that have an address in any of a set of blocked networks, such as to comply
+// with rules that forbid retrieving from certain IP ranges whatever the peer
+// ID of the provider. DNS addresses are resolved to check the IP addresses
+// they resolve to, and a candidate with a DNS address that can't be resolved
+// is dropped, as it can't be shown to be outside of the blocked networks.
+// Candidates without addresses are passed on.
+type NetworkBlockCandidateFinder struct {
+	CandidateFinder
+	blocked  []netip.Prefix
+	resolver *madns.Resolver
+}
+
+// NewNetworkBlockCandidateFinder returns a new NetworkBlockCandidateFinder for
+// the given CandidateFinder, blocking the given networks.
+func NewNetworkBlockCandidateFinder(finder CandidateFinder, blocked []netip.Prefix) NetworkBlockCandidateFinder {
+	return NetworkBlockCandidateFinder{CandidateFinder: finder, blocked: blocked, resolver: madns.DefaultResolver}
+}
+
+func (nbf NetworkBlockCandidateFinder) FindCandidates(ctx context.Context, c cid.Cid) ([]types.RetrievalCandidate, error) {
+	found, err := nbf.CandidateFinder.FindCandidates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]types.RetrievalCandidate, 0, len(found))
+	for _, candidate := range found {
+		if !nbf.isBlocked(ctx, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+func (nbf NetworkBlockCandidateFinder) FindCandidatesAsync(ctx context.Context, c cid.Cid, cb func(types.RetrievalCandidate)) error {
+	return nbf.CandidateFinder.FindCandidatesAsync(ctx, c, func(candidate types.RetrievalCandidate) {
+		if !nbf.isBlocked(ctx, candidate) {
+			cb(candidate)
+		}
+	})
+}
+
+// isBlocked returns whether any of the candidate's addresses, or the addresses
+// they resolve to, is in a blocked network.
+func (nbf NetworkBlockCandidateFinder) isBlocked(ctx context.Context, candidate types.RetrievalCandidate) bool {
+	for _, addr := range candidate.MinerPeer.Addrs {
+		addrs := []ma.Multiaddr{addr}
+		if madns.Matches(addr) {
+			resolved, err := nbf.resolver.Resolve(ctx, addr)
+			if err != nil || len(resolved) == 0 {
+				logger.Debugw("Dropping candidate, address could not be resolved to check blocked networks", "providerId", candidate.MinerPeer.ID, "addr", addr, "err", err)
+				return true
+			}
+			addrs = resolved
+		}
+		for _, addr := range addrs {
+			if network, ok := nbf.blockedNetwork(addr); ok {
+				logger.Debugw("Dropping candidate with an address in a blocked network", "providerId", candidate.MinerPeer.ID, "addr", addr, "network", network)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (nbf NetworkBlockCandidateFinder) blockedNetwork(addr ma.Multiaddr) (netip.Prefix, bool) {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	ipAddr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ipAddr = ipAddr.Unmap()
+	for _, network := range nbf.blocked {
+		if network.Contains(ipAddr) {
+			return network, true
+		}
+	}
+	return netip.Prefix{}, false
+}
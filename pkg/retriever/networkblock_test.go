@@ -0,0 +1,70 @@
+package retriever
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkBlockCandidateFinder(t *testing.T) {
+	c := testutil.GenerateCid()
+	candidates := testutil.GenerateRetrievalCandidatesForCID(t, 7, c)
+	addrs := [][]string{
+		{"/ip4/203.0.113.10/tcp/80/http"},
+		{"/ip4/198.51.100.1/tcp/80/http", "/ip4/203.0.113.200/tcp/4001"},
+		{"/ip6/2001:db8::1/tcp/80/http"},
+		{"/dns4/blocked.example.com/tcp/80/http"},
+		{"/dns4/allowed.example.com/tcp/80/http"},
+		{"/dns4/unknown.example.com/tcp/80/http"},
+		{},
+	}
+	for i, candidateAddrs := range addrs {
+		candidates[i].MinerPeer.Addrs = nil
+		for _, addr := range candidateAddrs {
+			candidates[i].MinerPeer.Addrs = append(candidates[i].MinerPeer.Addrs, multiaddr.StringCast(addr))
+		}
+	}
+	resolver, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		IP: map[string][]net.IPAddr{
+			"blocked.example.com": {{IP: net.ParseIP("203.0.113.50")}},
+			"allowed.example.com": {{IP: net.ParseIP("198.51.100.2")}},
+		},
+	}))
+	require.NoError(t, err)
+
+	for _, async := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		finder := NewNetworkBlockCandidateFinder(
+			testutil.NewMockCandidateFinder(nil, map[cid.Cid][]types.RetrievalCandidate{c: candidates}),
+			[]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8::/32")},
+		)
+		finder.resolver = resolver
+		var found []types.RetrievalCandidate
+		if async {
+			err := finder.FindCandidatesAsync(ctx, c, func(candidate types.RetrievalCandidate) {
+				found = append(found, candidate)
+			})
+			require.NoError(t, err)
+		} else {
+			var err error
+			found, err = finder.FindCandidates(ctx, c)
+			require.NoError(t, err)
+		}
+		foundIds := make([]peer.ID, 0, len(found))
+		for _, candidate := range found {
+			foundIds = append(foundIds, candidate.MinerPeer.ID)
+		}
+		require.ElementsMatch(t, []peer.ID{candidates[4].MinerPeer.ID, candidates[6].MinerPeer.ID}, foundIds)
+	}
+}
@@ -9,15 +9,20 @@ import (
 
 	"github.com/benbjohnson/clock"
 	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/retriever/prioritywaitqueue"
+	"github.com/filecoin-project/lassie/pkg/session"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-trustless-utils/traversal"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type GetStorageProviderTimeout func(peer peer.ID) time.Duration
+type GetStorageProviderTimeout func(peer peer.ID, protocol multicodec.Code) time.Duration
 
 // TransportProtocol implements the protocol-specific portions of a parallel-
 // peer retriever. It is responsible for communicating with individual peers
@@ -72,6 +77,16 @@ type retrieval struct {
 	eventsCallback     func(types.RetrievalEvent)
 	candidateMetadata  map[peer.ID]metadata.Protocol
 	candidateMetdataLk sync.RWMutex
+	strategy           session.Strategy
+	// log correlates logs with the retrieval and its protocol
+	log *logging.SubsystemLogger
+	// traceCtx carries the span of the retrieval across all candidates, it
+	// is set before any candidate is run
+	traceCtx context.Context
+	// queried counts the candidates started, guarded by candidateMetdataLk
+	queried uint
+	// attempts bounds the providers attempted across all protocols
+	attempts *attemptLimiter
 }
 
 type retrievalResult struct {
@@ -91,17 +106,30 @@ func (cfg *parallelPeerRetriever) Retrieve(
 	if eventsCallback == nil {
 		eventsCallback = func(re types.RetrievalEvent) {}
 	}
+	strategy := cfg.Session.ChooseStrategy(retrievalRequest.Root, retrievalRequest.GetSelector(), retrievalRequest.ExpectedSize)
+	log := logger.With(logging.RetrievalIDKey, retrievalRequest.RetrievalID, "protocol", cfg.Protocol.Code().String())
+	log.Debugw("chose candidate ordering strategy", "strategy", strategy.String())
 	return &retrieval{
 		parallelPeerRetriever: cfg,
 		ctx:                   ctx,
 		request:               retrievalRequest,
 		eventsCallback:        eventsCallback,
 		candidateMetadata:     make(map[peer.ID]metadata.Protocol),
+		strategy:              strategy,
+		log:                   log,
+		attempts:              attemptLimiterFrom(ctx),
 	}
 }
 
-func (retrieval *retrieval) RetrieveFromAsyncCandidates(asyncCandidates types.InboundAsyncCandidates) (*types.RetrievalStats, error) {
-	ctx, cancelCtx := context.WithCancel(retrieval.ctx)
+func (retrieval *retrieval) RetrieveFromAsyncCandidates(asyncCandidates types.InboundAsyncCandidates) (stats *types.RetrievalStats, err error) {
+	traceCtx, span := tracer.Start(retrieval.ctx, "Retrieve", trace.WithAttributes(
+		attribute.String("retrievalId", retrieval.request.RetrievalID.String()),
+		attribute.String("protocol", retrieval.Protocol.Code().String()),
+		attribute.String("strategy", retrieval.strategy.String()),
+	))
+	defer func() { endSpan(span, err) }()
+	retrieval.traceCtx = traceCtx
+	ctx, cancelCtx := context.WithCancel(traceCtx)
 
 	pwqOpts := []prioritywaitqueue.Option[peer.ID]{prioritywaitqueue.WithClock[peer.ID](retrieval.Clock)}
 	if retrieval.QueueInitialPause > 0 {
@@ -147,7 +175,7 @@ func (retrieval *retrieval) RetrieveFromAsyncCandidates(asyncCandidates types.In
 		retrieval.eventsCallback(evt)
 	}
 
-	stats, err := collectResults(ctx, shared, eventsCallback)
+	stats, err = collectResults(ctx, shared, eventsCallback)
 	cancelCtx()
 	// optimistically try to wait for all routines to finish
 	if retrieval.noDirtyClose {
@@ -156,11 +184,7 @@ func (retrieval *retrieval) RetrieveFromAsyncCandidates(asyncCandidates types.In
 		select {
 		case <-finishAll:
 		case <-retrieval.Clock.After(100 * time.Millisecond):
-			logger.Errorf(
-				"Possible leak: unable to successfully cancel all %s retrieval attempts for %s within 100ms",
-				retrieval.Protocol.Code().String(),
-				retrieval.request.Root.String(),
-			)
+			retrieval.log.Errorw("Possible leak: unable to successfully cancel all retrieval attempts within 100ms", "root", retrieval.request.Root)
 		}
 	}
 	return stats, err
@@ -180,7 +204,25 @@ func (retrieval *retrieval) candidateChooser(peers []peer.ID) int {
 	}
 	retrieval.candidateMetdataLk.RUnlock()
 
-	return retrieval.Session.ChooseNextProvider(peers, metadata)
+	_, span := tracer.Start(retrieval.traceCtx, "ChooseProvider", trace.WithAttributes(
+		attribute.Int("candidates", len(peers)),
+	))
+	defer span.End()
+	// providers that served earlier retrievals with the same affinity key come
+	// first, the rest are ordered by the strategy
+	var chosen int
+	var affinity bool
+	if retrieval.request.AffinityKey != "" {
+		chosen, affinity = retrieval.Session.ChooseAffinityProvider(retrieval.request.AffinityKey, peers)
+		span.SetAttributes(attribute.Bool("affinity", affinity))
+	}
+	if !affinity {
+		chosen = retrieval.Session.ChooseNextProviderWithStrategy(peers, metadata, retrieval.strategy)
+	}
+	if chosen >= 0 && chosen < len(peers) {
+		span.SetAttributes(attribute.String("storageProviderId", peers[chosen].String()))
+	}
+	return chosen
 }
 
 // filterCandidates is needed because we can receive duplicate candidates in
@@ -203,14 +245,30 @@ func (retrieval *retrieval) filterCandidates(ctx context.Context, asyncCandidate
 	retrieval.candidateMetdataLk.Lock()
 	defer retrieval.candidateMetdataLk.Unlock()
 
+	maxQueries := retrieval.request.QueryLimits.MaxQueries(retrieval.Protocol.Code())
 	for _, candidate := range candidates {
 		// update or add new candidate metadata
 		currMetadata, seenCandidate := retrieval.candidateMetadata[candidate.MinerPeer.ID]
+		if !seenCandidate && maxQueries > 0 && retrieval.queried >= maxQueries {
+			retrieval.log.Debugw("Query limit reached, ignoring candidate",
+				"maxQueries", maxQueries,
+				"storageProviderId", candidate.MinerPeer.ID,
+			)
+			continue
+		}
+		if !seenCandidate && !retrieval.attempts.allow(candidate.MinerPeer.ID) {
+			retrieval.log.Debugw("Attempt limit reached, ignoring candidate",
+				"maxAttempts", retrieval.request.MaxAttempts,
+				"storageProviderId", candidate.MinerPeer.ID,
+			)
+			continue
+		}
 		newMetadata := candidate.Metadata.Get(multicodec.Code(retrieval.Protocol.Code()))
 		candidateMetadata := retrieval.Protocol.GetMergedMetadata(retrieval.request.Root, currMetadata, newMetadata)
 		retrieval.candidateMetadata[candidate.MinerPeer.ID] = candidateMetadata
 		// if it's a new candidate, include it, otherwise don't start a new retrieval for it
 		if !seenCandidate {
+			retrieval.queried++
 			filtered = append(filtered, candidate)
 		}
 	}
@@ -220,18 +278,77 @@ func (retrieval *retrieval) filterCandidates(ctx context.Context, asyncCandidate
 
 // runRetrievalCandidate is a singular CID:SP retrieval, expected to be run in a goroutine
 // and coordinate with other candidate retrievals to block and only attempt one
-// retrieval-proper at a time.
+// retrieval-proper at a time. A failed attempt is retried according to the
+// RetryPolicy for the candidate's provider and protocol.
 func (retrieval *retrieval) runRetrievalCandidate(
 	ctx context.Context,
 	shared *retrievalShared,
 	candidate types.RetrievalCandidate,
 ) {
-	timeout := retrieval.Session.GetStorageProviderTimeout(candidate.MinerPeer.ID)
+	timeout := retrieval.Session.GetStorageProviderTimeout(candidate.MinerPeer.ID, retrieval.Protocol.Code())
+	if retrieval.request.ProviderTimeout != 0 {
+		timeout = retrieval.request.ProviderTimeout
+	}
+	policy := retryPolicyFrom(ctx, candidate.MinerPeer.ID, retrieval.Protocol.Code())
 
 	var stats *types.RetrievalStats
 	var retrievalErr error
 	var done func()
 
+	ctx, span := tracer.Start(ctx, "RetrieveFromProvider", trace.WithAttributes(
+		attribute.String("protocol", retrieval.Protocol.Code().String()),
+		attribute.String("storageProviderId", candidate.MinerPeer.ID.String()),
+	))
+	defer func() { endSpan(span, retrievalErr) }()
+
+	for retry := uint(0); ; retry++ {
+		var retryable bool
+		stats, retrievalErr, retryable, done = retrieval.attemptRetrievalCandidate(ctx, shared, candidate, timeout)
+		if !retryable || retry >= policy.MaxRetries || !shared.canSendResult() {
+			break
+		}
+		if done != nil {
+			done() // let other candidates run while we back off
+			done = nil
+		}
+		retrieval.log.Debugw("Retrying SP", "storageProviderId", candidate.MinerPeer.ID, "retry", retry+1, "err", retrievalErr)
+		if !retrieval.waitToRetry(ctx, policy, retry+1) {
+			break
+		}
+		// the failure removed the provider from the retrieval
+		if err := retrieval.Session.AddToRetrieval(retrieval.request.RetrievalID, []peer.ID{candidate.MinerPeer.ID}); err != nil {
+			retrieval.log.Errorw("Error adding SP back to retrieval", "storageProviderId", candidate.MinerPeer.ID, "err", err)
+			break
+		}
+	}
+
+	if shared.canSendResult() {
+		if retrievalErr != nil {
+			if ctx.Err() != nil { // cancelled, don't report the error
+				shared.sendResult(ctx, retrievalResult{PeerID: candidate.MinerPeer.ID})
+			} else {
+				// an error of some kind to report
+				shared.sendResult(ctx, retrievalResult{PeerID: candidate.MinerPeer.ID, Err: retrievalErr})
+			}
+		} else { // success, we have stats and no errors
+			shared.sendResult(ctx, retrievalResult{PeerID: candidate.MinerPeer.ID, Stats: stats})
+		}
+	} // else nothing to do, we were cancelled
+
+	if done != nil {
+		done() // allow prioritywaitqueue to move on to next candidate
+	}
+}
+
+// attemptRetrievalCandidate makes a single attempt to connect to and retrieve
+// from the candidate, returning whether a failure may be retried, and the
+// function to release the candidate's place in the queue, if it took one.
+func (retrieval *retrieval) attemptRetrievalCandidate(
+	ctx context.Context,
+	shared *retrievalShared,
+	candidate types.RetrievalCandidate,
+	timeout time.Duration,
+) (stats *types.RetrievalStats, retrievalErr error, retryable bool, done func()) {
 	shared.sendEvent(ctx, events.StartedRetrieval(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code()))
 	connectCtx := ctx
 	if timeout != 0 {
@@ -241,76 +358,118 @@ func (retrieval *retrieval) runRetrievalCandidate(
 	}
 
 	// Setup in parallel
+	connectCtx, connectSpan := tracer.Start(connectCtx, "Connect")
 	connectTime, err := retrieval.Protocol.Connect(connectCtx, retrieval, candidate)
+	endSpan(connectSpan, err)
 	if err != nil {
 		// Exclude the case where the context was cancelled by the parent, which likely means that
 		// another protocol has succeeded.
 		if !errors.Is(ctx.Err(), context.Canceled) {
-			logger.Warnf("Failed to connect to SP %s on protocol %s: %v", candidate.MinerPeer.ID, retrieval.Protocol.Code().String(), err)
+			retrieval.log.Warnw("Failed to connect to SP", "storageProviderId", candidate.MinerPeer.ID, "err", err)
 			retrievalErr = fmt.Errorf("%w: %v", ErrConnectFailed, err)
-			if err := retrieval.Session.RecordFailure(retrieval.request.RetrievalID, candidate.MinerPeer.ID); err != nil {
-				logger.Errorf("Error recording retrieval failure on protocol %s: %v", retrieval.Protocol.Code().String(), err)
-			}
 			shared.sendEvent(ctx, events.FailedRetrieval(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code(), retrievalErr.Error()))
+			retryable = retrieval.recordFailure(ctx, shared, candidate, retrievalErr.Error())
 		}
-	} else {
-		shared.sendEvent(ctx, events.ConnectedToProvider(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code()))
-
-		retrieval.Session.RecordConnectTime(candidate.MinerPeer.ID, connectTime)
-
-		// Form a queue and run retrieval in serial
-		done = shared.waitQueue.Wait(candidate.MinerPeer.ID)
-
-		if shared.canSendResult() { // move on to retrieval
-			stats, retrievalErr = retrieval.Protocol.Retrieve(ctx, retrieval, shared, timeout, candidate)
-
-			if retrievalErr != nil {
-				// Exclude the case where the context was cancelled by the parent, which likely
-				// means that another protocol has succeeded.
-				if !errors.Is(ctx.Err(), context.Canceled) {
-					msg := retrievalErr.Error()
-					if errors.Is(retrievalErr, ErrRetrievalTimedOut) {
-						msg = fmt.Sprintf("timeout after %s", timeout)
-					}
-					shared.sendEvent(ctx, events.FailedRetrieval(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code(), msg))
-					if err := retrieval.Session.RecordFailure(retrieval.request.RetrievalID, candidate.MinerPeer.ID); err != nil {
-						logger.Errorf("Error recording retrieval failure for protocol %s: %v", retrieval.Protocol.Code().String(), err)
-					}
-				}
-			} else {
-				shared.sendEvent(ctx, events.Success(
-					retrieval.parallelPeerRetriever.Clock.Now(),
-					retrieval.request.RetrievalID,
-					candidate,
-					stats.Size,
-					stats.Blocks,
-					stats.Duration,
-					retrieval.Protocol.Code(),
-				))
-				seconds := stats.Duration.Seconds()
-				if seconds == 0 { // avoid a divide by zero
-					seconds = 1
-				}
-				bandwidthBytesPerSecond := float64(stats.Size) / seconds
-				retrieval.Session.RecordSuccess(candidate.MinerPeer.ID, uint64(bandwidthBytesPerSecond))
-			}
-		} // else we didn't get to retrieval because we were cancelled
+		return nil, retrievalErr, retryable, nil
 	}
 
-	if shared.canSendResult() {
-		if retrievalErr != nil {
-			if ctx.Err() != nil { // cancelled, don't report the error
-				shared.sendResult(ctx, retrievalResult{PeerID: candidate.MinerPeer.ID})
-			} else {
-				// an error of some kind to report
-				shared.sendResult(ctx, retrievalResult{PeerID: candidate.MinerPeer.ID, Err: retrievalErr})
+	shared.sendEvent(ctx, events.ConnectedToProvider(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code()))
+
+	retrieval.Session.RecordConnectTime(candidate.MinerPeer.ID, connectTime)
+
+	// Form a queue and run retrieval in serial
+	done = shared.waitQueue.Wait(candidate.MinerPeer.ID)
+
+	if !shared.canSendResult() { // we didn't get to retrieval because we were cancelled
+		return nil, nil, false, done
+	}
+
+	// don't start retrieving from a provider while paused
+	if _, retrievalErr = retrieval.request.PauseControl.Wait(ctx); retrievalErr == nil {
+		stats, retrievalErr = retrieval.Protocol.Retrieve(ctx, retrieval, shared, timeout, candidate)
+	}
+
+	if retrievalErr != nil {
+		// Exclude the case where the context was cancelled by the parent, which likely
+		// means that another protocol has succeeded.
+		if !errors.Is(ctx.Err(), context.Canceled) {
+			msg := retrievalErr.Error()
+			if errors.Is(retrievalErr, ErrRetrievalTimedOut) {
+				msg = fmt.Sprintf("timeout after %s", timeout)
 			}
-		} else { // success, we have stats and no errors
-			shared.sendResult(ctx, retrievalResult{PeerID: candidate.MinerPeer.ID, Stats: stats})
+			shared.sendEvent(ctx, events.FailedRetrieval(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code(), msg))
+			if isInvalidData(retrievalErr) {
+				retrieval.alertIfAllowListed(ctx, shared, candidate, events.ProviderAlertInvalidData, msg)
+			}
+			retryable = retrieval.recordFailure(ctx, shared, candidate, msg)
 		}
-	} // else nothing to do, we were cancelled
+		return nil, retrievalErr, retryable, done
+	}
 
-	if done != nil {
-		done() // allow prioritywaitqueue to move on to next candidate
+	shared.sendEvent(ctx, events.Success(
+		retrieval.parallelPeerRetriever.Clock.Now(),
+		retrieval.request.RetrievalID,
+		candidate,
+		stats.Size,
+		stats.Blocks,
+		stats.Duration,
+		retrieval.Protocol.Code(),
+	))
+	seconds := stats.Duration.Seconds()
+	if seconds == 0 { // avoid a divide by zero
+		seconds = 1
 	}
+	bandwidthBytesPerSecond := float64(stats.Size) / seconds
+	if retrieval.Session.RecordSuccess(candidate.MinerPeer.ID, uint64(bandwidthBytesPerSecond)) {
+		shared.sendEvent(ctx, events.CircuitBreakerClosed(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code()))
+	}
+	return stats, nil, false, done
+}
+
+// recordFailure records a failure to retrieve from the candidate with the
+// session, emitting an event if it opened the circuit breaker of the
+// candidate's provider, and an alert if an allowlisted provider has failed
+// persistently. It returns false if the provider shouldn't be retried because
+// the breaker opened or the failure couldn't be recorded.
+func (retrieval *retrieval) recordFailure(ctx context.Context, shared *retrievalShared, candidate types.RetrievalCandidate, errorMessage string) bool {
+	if retrieval.Session.RecordAllowListedFailure(candidate.MinerPeer.ID) {
+		retrieval.alert(ctx, shared, candidate, events.ProviderAlertPersistentFailure, errorMessage)
+	}
+	cooldown, err := retrieval.Session.RecordFailure(retrieval.request.RetrievalID, candidate.MinerPeer.ID)
+	if err != nil {
+		retrieval.log.Errorw("Error recording retrieval failure", "storageProviderId", candidate.MinerPeer.ID, "err", err)
+		return false
+	}
+	if cooldown > 0 {
+		retrieval.log.Infow("Circuit breaker opened for SP", "storageProviderId", candidate.MinerPeer.ID, "cooldown", cooldown)
+		shared.sendEvent(ctx, events.CircuitBreakerOpened(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code(), cooldown))
+		return false
+	}
+	return true
+}
+
+// alertIfAllowListed emits a provider alert if the candidate's provider is
+// allowlisted, as its failures need an operator's attention rather than only
+// deprioritising it.
+func (retrieval *retrieval) alertIfAllowListed(ctx context.Context, shared *retrievalShared, candidate types.RetrievalCandidate, reason events.ProviderAlertReason, errorMessage string) {
+	if retrieval.Session.IsAllowListed(candidate.MinerPeer.ID) {
+		retrieval.alert(ctx, shared, candidate, reason, errorMessage)
+	}
+}
+
+// alert emits a provider alert for the candidate's provider.
+func (retrieval *retrieval) alert(ctx context.Context, shared *retrievalShared, candidate types.RetrievalCandidate, reason events.ProviderAlertReason, errorMessage string) {
+	retrieval.log.Warnw("Alerting on allowlisted SP", "storageProviderId", candidate.MinerPeer.ID, "reason", reason, "err", errorMessage)
+	shared.sendEvent(ctx, events.ProviderAlert(retrieval.parallelPeerRetriever.Clock.Now(), retrieval.request.RetrievalID, candidate, retrieval.Protocol.Code(), reason, errorMessage))
+}
+
+// isInvalidData returns whether a retrieval failed because the provider served
+// data that doesn't verify against the request, rather than failing to serve
+// it at all.
+func isInvalidData(err error) bool {
+	return errors.Is(err, traversal.ErrMalformedCar) ||
+		errors.Is(err, traversal.ErrBadVersion) ||
+		errors.Is(err, traversal.ErrBadRoots) ||
+		errors.Is(err, traversal.ErrUnexpectedBlock) ||
+		errors.Is(err, traversal.ErrExtraneousBlock)
 }
@@ -0,0 +1,145 @@
+package retriever
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/google/uuid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+)
+
+func TestProviderAlerts(t *testing.T) {
+	testCases := []struct {
+		name             string
+		err              error
+		allowListed      bool
+		breakerThreshold uint
+		alertThreshold   uint
+		expectedReasons  []events.ProviderAlertReason
+	}{
+		{
+			name:            "not allowlisted",
+			err:             fmt.Errorf("%w: bafy != bafz", traversal.ErrUnexpectedBlock),
+			expectedReasons: []events.ProviderAlertReason{},
+		},
+		{
+			name:            "allowlisted, invalid data",
+			err:             fmt.Errorf("%w: bafy != bafz", traversal.ErrUnexpectedBlock),
+			allowListed:     true,
+			expectedReasons: []events.ProviderAlertReason{events.ProviderAlertInvalidData},
+		},
+		{
+			name:            "allowlisted, malformed CAR",
+			err:             multierr.Combine(traversal.ErrMalformedCar, errors.New("unexpected EOF")),
+			allowListed:     true,
+			expectedReasons: []events.ProviderAlertReason{events.ProviderAlertInvalidData},
+		},
+		{
+			name:            "allowlisted, failure",
+			err:             errors.New("connection reset"),
+			allowListed:     true,
+			expectedReasons: []events.ProviderAlertReason{},
+		},
+		{
+			name:             "allowlisted, persistent failure",
+			err:              errors.New("connection reset"),
+			allowListed:      true,
+			breakerThreshold: 1,
+			alertThreshold:   1,
+			expectedReasons:  []events.ProviderAlertReason{events.ProviderAlertPersistentFailure},
+		},
+		{
+			name:            "allowlisted, persistent failure without a circuit breaker",
+			err:             errors.New("connection reset"),
+			allowListed:     true,
+			alertThreshold:  1,
+			expectedReasons: []events.ProviderAlertReason{events.ProviderAlertPersistentFailure},
+		},
+		{
+			name:             "allowlisted, persistent failure without alerts",
+			err:              errors.New("connection reset"),
+			allowListed:      true,
+			breakerThreshold: 1,
+			expectedReasons:  []events.ProviderAlertReason{},
+		},
+		{
+			name:             "allowlisted, persistent invalid data",
+			err:              fmt.Errorf("%w: bafy != bafz", traversal.ErrUnexpectedBlock),
+			allowListed:      true,
+			breakerThreshold: 1,
+			alertThreshold:   1,
+			expectedReasons:  []events.ProviderAlertReason{events.ProviderAlertInvalidData, events.ProviderAlertPersistentFailure},
+		},
+		{
+			name:             "not allowlisted, persistent failure",
+			err:              errors.New("connection reset"),
+			breakerThreshold: 1,
+			alertThreshold:   1,
+			expectedReasons:  []events.ProviderAlertReason{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			c := testutil.GenerateCid()
+			candidate := testutil.GenerateRetrievalCandidatesForCID(t, 1, c, &metadata.IpfsGatewayHttp{})[0]
+			cfg := session.DefaultConfig().
+				WithCircuitBreaker(session.CircuitBreaker{Threshold: testCase.breakerThreshold, Cooldown: time.Minute}).
+				WithAllowListAlertThreshold(testCase.alertThreshold)
+			sess := session.NewSession(cfg, true)
+			if testCase.allowListed {
+				sess.SetProviderLists(nil, map[peer.ID]bool{candidate.MinerPeer.ID: true})
+			}
+			rid := types.RetrievalID(uuid.New())
+			require.True(t, sess.RegisterRetrieval(rid, c, selectorparse.CommonSelector_ExploreAllRecursively))
+			require.NoError(t, sess.AddToRetrieval(rid, []peer.ID{candidate.MinerPeer.ID}))
+
+			protocol := &flakyProtocol{failures: 1, err: testCase.err}
+			ppr := &parallelPeerRetriever{Protocol: protocol, Session: sess, Clock: clock.New(), noDirtyClose: true}
+
+			var lk sync.Mutex
+			reasons := make([]events.ProviderAlertReason, 0)
+			incoming, outgoing := types.MakeAsyncCandidates(1)
+			require.NoError(t, outgoing.SendNext(ctx, []types.RetrievalCandidate{candidate}))
+			close(outgoing)
+			_, err := ppr.Retrieve(ctx, types.RetrievalRequest{
+				Request:     trustlessutils.Request{Root: c},
+				RetrievalID: rid,
+				LinkSystem:  cidlink.DefaultLinkSystem(),
+			}, func(evt types.RetrievalEvent) {
+				lk.Lock()
+				defer lk.Unlock()
+				if alert, ok := evt.(events.ProviderAlertEvent); ok {
+					require.Equal(t, candidate.MinerPeer.ID, alert.ProviderId())
+					require.Equal(t, testCase.err.Error(), alert.ErrorMessage())
+					reasons = append(reasons, alert.Reason())
+				}
+			}).RetrieveFromAsyncCandidates(incoming)
+			require.Error(t, err)
+
+			lk.Lock()
+			defer lk.Unlock()
+			require.Equal(t, testCase.expectedReasons, reasons)
+		})
+	}
+}
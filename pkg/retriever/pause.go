@@ -0,0 +1,70 @@
+package retriever
+
+import (
+	"context"
+	"io"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/linking/preload"
+)
+
+// pauseReadOpener wraps a BlockReadOpener used by a local traversal so that
+// no links are loaded, and therefore no blocks requested, while the retrieval
+// is paused. onResume, if set, is called when a load continues after a pause.
+func pauseReadOpener(ctx context.Context, pc *types.PauseControl, bro linking.BlockReadOpener, onResume func()) linking.BlockReadOpener {
+	if pc == nil {
+		return bro
+	}
+	return func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		if err := waitForResume(ctx, pc, onResume); err != nil {
+			return nil, err
+		}
+		return bro(lctx, lnk)
+	}
+}
+
+// pausePreloader wraps a preload.Loader so that nothing is preloaded while the
+// retrieval is paused.
+func pausePreloader(ctx context.Context, pc *types.PauseControl, loader preload.Loader) preload.Loader {
+	if pc == nil || loader == nil {
+		return loader
+	}
+	return func(pctx preload.PreloadContext, l preload.Link) {
+		if _, err := pc.Wait(ctx); err != nil {
+			return
+		}
+		loader(pctx, l)
+	}
+}
+
+// pauseLinkSystem returns a copy of the LinkSystem that holds up each block
+// written to it while the retrieval is paused, for use where the provider
+// drives the traversal; not accepting blocks holds back the provider through
+// flow control on the connection. onResume, if set, is called when a write
+// continues after a pause.
+func pauseLinkSystem(ctx context.Context, lsys linking.LinkSystem, pc *types.PauseControl, onResume func()) linking.LinkSystem {
+	if pc == nil || lsys.StorageWriteOpener == nil {
+		return lsys
+	}
+	bwo := lsys.StorageWriteOpener
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		if err := waitForResume(ctx, pc, onResume); err != nil {
+			return nil, nil, err
+		}
+		return bwo(lctx)
+	}
+	return lsys
+}
+
+func waitForResume(ctx context.Context, pc *types.PauseControl, onResume func()) error {
+	waited, err := pc.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if waited && onResume != nil {
+		onResume()
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package retriever
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// RegionLocator looks up the region that a candidate's provider is located
+// in, such as from a GeoIP database, returning false if it isn't known.
+type RegionLocator interface {
+	Region(candidate types.RetrievalCandidate) (string, bool)
+}
+
+var _ RegionLocator = (*RegionTable)(nil)
+
+// RegionTable is a RegionLocator that locates providers by their peer ID, or
+// by the IP addresses they are dialed on, in a table of providers and network
+// prefixes, the most specific prefix matching an address winning.
+type RegionTable struct {
+	providers map[peer.ID]string
+	networks  []regionNetwork
+}
+
+type regionNetwork struct {
+	prefix netip.Prefix
+	region string
+}
+
+// NewRegionTable returns an empty RegionTable.
+func NewRegionTable() *RegionTable {
+	return &RegionTable{providers: make(map[peer.ID]string)}
+}
+
+// ParseRegionTable reads a RegionTable from CSV-style lines of a peer ID or
+// network prefix, such as "203.0.113.0/24", followed by a comma and the
+// region, in the manner of a GeoIP database export. Blank lines and lines
+// starting with "#" are ignored.
+func ParseRegionTable(r io.Reader) (*RegionTable, error) {
+	table := NewRegionTable()
+	scanner := bufio.NewScanner(r)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, region, ok := strings.Cut(text, ",")
+		key, region = strings.TrimSpace(key), strings.TrimSpace(region)
+		if !ok || key == "" || region == "" {
+			return nil, fmt.Errorf("invalid region table line %d: expected <peer id or network>,<region>", line)
+		}
+		if prefix, err := netip.ParsePrefix(key); err == nil {
+			table.AddNetwork(prefix, region)
+			continue
+		}
+		id, err := peer.Decode(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid region table line %d: %q is neither a peer ID nor a network", line, key)
+		}
+		table.AddProvider(id, region)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// AddProvider records the region of a provider, which takes precedence over
+// the regions of the networks it is dialed on.
+func (rt *RegionTable) AddProvider(id peer.ID, region string) {
+	rt.providers[id] = region
+}
+
+// AddNetwork records the region of the addresses within a network prefix.
+func (rt *RegionTable) AddNetwork(prefix netip.Prefix, region string) {
+	rt.networks = append(rt.networks, regionNetwork{prefix: prefix.Masked(), region: region})
+	// most specific first, so that the first match is the best
+	sort.SliceStable(rt.networks, func(i, j int) bool {
+		return rt.networks[i].prefix.Bits() > rt.networks[j].prefix.Bits()
+	})
+}
+
+func (rt *RegionTable) Region(candidate types.RetrievalCandidate) (string, bool) {
+	if region, ok := rt.providers[candidate.MinerPeer.ID]; ok {
+		return region, true
+	}
+	for _, addr := range candidate.MinerPeer.Addrs {
+		ip, err := manet.ToIP(addr)
+		if err != nil {
+			continue
+		}
+		ipAddr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		ipAddr = ipAddr.Unmap()
+		for _, network := range rt.networks {
+			if network.prefix.Contains(ipAddr) {
+				return network.region, true
+			}
+		}
+	}
+	return "", false
+}
+
+// RegionSession is the part of the Session that the regions of providers are
+// recorded with.
+type RegionSession interface {
+	RecordRegion(storageProviderId peer.ID, region string)
+}
+
+var _ CandidateFinder = RegionCandidateFinder{}
+
+// RegionCandidateFinder wraps a CandidateFinder, recording the region of the
+// provider of each candidate it finds with the session, so that providers in
+// the same region as Lassie can be preferred.
+type RegionCandidateFinder struct {
+	CandidateFinder
+	session RegionSession
+	locator RegionLocator
+}
+
+// NewRegionCandidateFinder returns a new RegionCandidateFinder for the given
+// CandidateFinder, locating providers with the given RegionLocator. A nil
+// RegionLocator locates no providers.
+func NewRegionCandidateFinder(finder CandidateFinder, session RegionSession, locator RegionLocator) RegionCandidateFinder {
+	return RegionCandidateFinder{CandidateFinder: finder, session: session, locator: locator}
+}
+
+func (rf RegionCandidateFinder) FindCandidates(ctx context.Context, c cid.Cid) ([]types.RetrievalCandidate, error) {
+	candidates, err := rf.CandidateFinder.FindCandidates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		rf.locate(candidate)
+	}
+	return candidates, nil
+}
+
+func (rf RegionCandidateFinder) FindCandidatesAsync(ctx context.Context, c cid.Cid, cb func(types.RetrievalCandidate)) error {
+	if rf.locator == nil {
+		return rf.CandidateFinder.FindCandidatesAsync(ctx, c, cb)
+	}
+	return rf.CandidateFinder.FindCandidatesAsync(ctx, c, func(candidate types.RetrievalCandidate) {
+		rf.locate(candidate)
+		cb(candidate)
+	})
+}
+
+func (rf RegionCandidateFinder) locate(candidate types.RetrievalCandidate) {
+	if rf.locator == nil {
+		return
+	}
+	if region, ok := rf.locator.Region(candidate); ok {
+		rf.session.RecordRegion(candidate.MinerPeer.ID, region)
+	}
+}
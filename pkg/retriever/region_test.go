@@ -0,0 +1,96 @@
+package retriever_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// mockRegionSession records the regions of providers.
+type mockRegionSession struct {
+	lk      sync.Mutex
+	regions map[peer.ID]string
+}
+
+func (mrs *mockRegionSession) RecordRegion(storageProviderId peer.ID, region string) {
+	mrs.lk.Lock()
+	defer mrs.lk.Unlock()
+	mrs.regions[storageProviderId] = region
+}
+
+func TestRegionCandidateFinder(t *testing.T) {
+	c := testutil.GenerateCid()
+	candidates := testutil.GenerateRetrievalCandidatesForCID(t, 5, c)
+	addrs := []string{
+		"/ip4/203.0.113.10/tcp/80/http",
+		"/ip4/203.0.113.200/tcp/80/http",
+		"/ip6/2001:db8::1/tcp/80/http",
+		"/dns4/example.com/tcp/80/http",
+		"/ip4/198.51.100.1/tcp/80/http",
+	}
+	for i, addr := range addrs {
+		candidates[i].MinerPeer.Addrs = []multiaddr.Multiaddr{multiaddr.StringCast(addr)}
+	}
+
+	table, err := retriever.ParseRegionTable(strings.NewReader(strings.Join([]string{
+		"# network or peer id, region",
+		"203.0.113.0/24, eu-west",
+		"203.0.113.128/25, eu-central",
+		"2001:db8::/32, us-east",
+		"",
+		candidates[4].MinerPeer.ID.String() + ",ap-south",
+	}, "\n")))
+	require.NoError(t, err)
+
+	expected := map[peer.ID]string{
+		candidates[0].MinerPeer.ID: "eu-west",
+		candidates[1].MinerPeer.ID: "eu-central",
+		candidates[2].MinerPeer.ID: "us-east",
+		candidates[4].MinerPeer.ID: "ap-south",
+	}
+
+	for _, async := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sess := &mockRegionSession{regions: make(map[peer.ID]string)}
+		finder := retriever.NewRegionCandidateFinder(
+			testutil.NewMockCandidateFinder(nil, map[cid.Cid][]types.RetrievalCandidate{c: candidates}),
+			sess,
+			table,
+		)
+		var found []types.RetrievalCandidate
+		if async {
+			err := finder.FindCandidatesAsync(ctx, c, func(candidate types.RetrievalCandidate) {
+				found = append(found, candidate)
+			})
+			require.NoError(t, err)
+		} else {
+			var err error
+			found, err = finder.FindCandidates(ctx, c)
+			require.NoError(t, err)
+		}
+		require.ElementsMatch(t, candidates, found)
+		require.Equal(t, expected, sess.regions)
+	}
+}
+
+func TestParseRegionTable(t *testing.T) {
+	for _, table := range []string{
+		"203.0.113.0/24",
+		"203.0.113.0/24,",
+		"not-a-network,eu-west",
+	} {
+		_, err := retriever.ParseRegionTable(strings.NewReader(table))
+		require.Error(t, err, table)
+	}
+}
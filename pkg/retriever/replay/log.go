@@ -0,0 +1,5 @@
+package replay
+
+import "github.com/filecoin-project/lassie/pkg/logging"
+
+var logger = logging.Subsystem("lassie/replay")
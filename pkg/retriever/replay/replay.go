@@ -0,0 +1,481 @@
+// Package replay replays recorded retrievals through a model of Lassie's
+// retrieval orchestration, offline and on a simulated clock, to show which
+// decisions it would make under a given configuration.
+//
+// Candidates are found at the times they were recorded, and the providers
+// behave according to the Scenario's model of their latencies and failures.
+// Provider selection is made by a real session.Session, so the scoring
+// weights, content size thresholds and what is learnt about providers over
+// the course of the Scenario all apply as they would in a running Lassie.
+// Each protocol retrieves from one provider at a time, choosing between the
+// candidates it has connected to, and the protocols race each other as the
+// retriever does.
+//
+// Bitswap candidates are not replayed, as Bitswap retrieves from all of its
+// providers at once rather than choosing between them.
+package replay
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"go.uber.org/multierr"
+)
+
+// Epoch is the simulated time that a replay starts at.
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Config is the configuration to replay a Scenario with, the settings that
+// can be tuned against it.
+type Config struct {
+	// Session holds the scoring weights and content size thresholds used to
+	// choose between providers. If nil, session.DefaultConfig() is used.
+	Session *session.Config
+	// Protocols are the protocols enabled, all of them if empty.
+	Protocols []multicodec.Code
+	// ProviderTimeout is the time allowed to connect to a provider, and
+	// without receiving data from it, before it is considered failed. Zero is
+	// no timeout.
+	ProviderTimeout time.Duration
+	// GlobalTimeout is the time allowed for each retrieval. Zero is no
+	// timeout.
+	GlobalTimeout time.Duration
+	// InitialPause is the time each protocol waits, once it has connected to
+	// its first candidate, for others to connect so that it can choose between
+	// them.
+	InitialPause time.Duration
+	// QueryLimits limits the number of providers queried for each protocol.
+	QueryLimits types.ProviderQueryLimits
+	// Seed seeds the weighted random choice between providers, the same seed
+	// replays the same decisions. Not used when Session has its own Random.
+	Seed int64
+}
+
+// DefaultConfig returns a Config matching Lassie's defaults.
+func DefaultConfig() Config {
+	return Config{
+		Session:         session.DefaultConfig(),
+		ProviderTimeout: 20 * time.Second,
+		InitialPause:    retriever.GraphsyncDefaultInitialWait,
+	}
+}
+
+// Result is the outcome of replaying each of a Scenario's retrievals.
+type Result struct {
+	Retrievals []RetrievalResult
+}
+
+// RetrievalResult is the outcome of replaying a Retrieval.
+type RetrievalResult struct {
+	RetrievalID types.RetrievalID
+	Root        cid.Cid
+	// Strategy is the strategy chosen to order the candidates.
+	Strategy session.Strategy
+	// Start is the simulated time the retrieval started at.
+	Start time.Time
+	// Events are the retrieval events that would have been emitted for each
+	// candidate, in order.
+	Events []types.RetrievalEvent
+	// Provider and Protocol are where the retrieval succeeded from.
+	Provider peer.ID
+	Protocol multicodec.Code
+	Duration time.Duration
+	Err      error
+}
+
+// Replay replays each of the Scenario's retrievals in turn with the given
+// Config.
+func Replay(scenario Scenario, cfg Config) (Result, error) {
+	sessionCfg := session.DefaultConfig()
+	if cfg.Session != nil {
+		sessionCfg = cfg.Session
+	}
+	seeded := *sessionCfg
+	if seeded.Random == nil {
+		seeded.Random = rand.New(rand.NewSource(cfg.Seed))
+	}
+	// the session runs on simulated time, so that circuit breakers cool down
+	// as they would have
+	clk := clock.NewMock()
+	seeded.Clock = clk
+	sess := session.NewSession(&seeded, true)
+
+	result := Result{}
+	now := Epoch
+	for i, retrieval := range scenario.Retrievals {
+		rr, err := replayRetrieval(sess, clk, scenario.Providers, cfg, retrieval, now)
+		if err != nil {
+			return Result{}, fmt.Errorf("retrieval %d: %w", i, err)
+		}
+		result.Retrievals = append(result.Retrievals, rr)
+		now = now.Add(rr.Duration)
+	}
+	return result, nil
+}
+
+// attempt is a retrieval from a single candidate over a single protocol.
+type attempt struct {
+	candidate types.RetrievalCandidate
+	protocol  multicodec.Code
+	behavior  Behavior
+	started   time.Time
+}
+
+// protocolQueue models the parallel-peer retriever for one protocol: every
+// candidate is connected to at once, and the connected candidates then
+// queue to retrieve, one at a time, in the order the session chooses.
+type protocolQueue struct {
+	protocol     multicodec.Code
+	metadata     map[peer.ID]metadata.Protocol
+	queried      uint
+	waiting      []*attempt
+	running      *attempt
+	next         *attempt
+	pausing      bool
+	pauseStarted bool
+}
+
+// simulation is the state of a single replayed retrieval.
+type simulation struct {
+	session    *session.Session
+	clock      *clock.Mock
+	providers  map[peer.ID]Behavior
+	cfg        Config
+	retrieval  Retrieval
+	selector   datamodel.Node
+	strategy   session.Strategy
+	queues     map[multicodec.Code]*protocolQueue
+	candidates uint
+	schedule   schedule
+	now        time.Time
+	result     *RetrievalResult
+	errs       error
+	timedOut   bool
+	done       bool
+}
+
+func replayRetrieval(sess *session.Session, clk *clock.Mock, providers map[peer.ID]Behavior, cfg Config, retrieval Retrieval, start time.Time) (RetrievalResult, error) {
+	retrievalID, err := types.NewRetrievalID()
+	if err != nil {
+		return RetrievalResult{}, err
+	}
+	request := trustlessutils.Request{Root: retrieval.Root, Scope: trustlessutils.DagScopeAll}
+	result := RetrievalResult{
+		RetrievalID: retrievalID,
+		Root:        retrieval.Root,
+		Start:       start,
+	}
+	clk.Set(start)
+	sim := &simulation{
+		session:   sess,
+		clock:     clk,
+		providers: providers,
+		cfg:       cfg,
+		retrieval: retrieval,
+		selector:  request.Selector(),
+		queues:    make(map[multicodec.Code]*protocolQueue),
+		now:       start,
+		result:    &result,
+	}
+	sim.strategy = sess.ChooseStrategy(retrieval.Root, sim.selector, retrieval.ExpectedSize)
+	result.Strategy = sim.strategy
+
+	if !sess.RegisterRetrieval(result.RetrievalID, retrieval.Root, sim.selector) {
+		return RetrievalResult{}, fmt.Errorf("%w: %s", retriever.ErrRetrievalAlreadyRunning, retrieval.Root)
+	}
+	defer func() {
+		_ = sess.EndRetrieval(result.RetrievalID)
+	}()
+
+	for _, candidate := range retrieval.Candidates {
+		rc, err := candidate.retrievalCandidate(retrieval.Root)
+		if err != nil {
+			return RetrievalResult{}, err
+		}
+		sim.at(time.Duration(candidate.FoundAfter), func() { sim.candidateFound(rc) })
+	}
+	if cfg.GlobalTimeout > 0 {
+		sim.at(cfg.GlobalTimeout, func() {
+			sim.timedOut = true
+			sim.done = true
+		})
+	}
+
+	for !sim.done && sim.schedule.Len() > 0 {
+		next := heap.Pop(&sim.schedule).(*scheduled)
+		sim.now = next.at
+		sim.clock.Set(next.at)
+		next.fn()
+	}
+
+	result.Duration = sim.now.Sub(start)
+	if result.Provider == "" {
+		switch {
+		case sim.timedOut:
+			result.Err = multierr.Append(sim.errs, fmt.Errorf("global timeout after %s: %w", cfg.GlobalTimeout, context.DeadlineExceeded))
+		case len(sim.queues) == 0:
+			result.Err = retriever.ErrNoCandidates
+		default:
+			result.Err = multierr.Append(sim.errs, retriever.ErrAllRetrievalsFailed)
+		}
+	} else {
+		sess.RecordContentSize(retrieval.Root, sim.selector, retrieval.Size)
+	}
+	return result, nil
+}
+
+// at schedules fn to run after the given delay.
+func (sim *simulation) at(delay time.Duration, fn func()) {
+	heap.Push(&sim.schedule, &scheduled{at: sim.now.Add(delay), seq: sim.schedule.seq, fn: fn})
+	sim.schedule.seq++
+}
+
+func (sim *simulation) emit(event types.RetrievalEvent) {
+	sim.result.Events = append(sim.result.Events, event)
+}
+
+func (sim *simulation) enabled(protocol multicodec.Code) bool {
+	if protocol == multicodec.TransportBitswap {
+		return false
+	}
+	if len(sim.cfg.Protocols) == 0 {
+		return true
+	}
+	for _, p := range sim.cfg.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func (sim *simulation) timeout(provider peer.ID, protocol multicodec.Code) time.Duration {
+	if sim.cfg.ProviderTimeout != 0 {
+		return sim.cfg.ProviderTimeout
+	}
+	return sim.session.GetStorageProviderTimeout(provider, protocol)
+}
+
+func (sim *simulation) candidateFound(candidate types.RetrievalCandidate) {
+	keep, candidate := sim.session.FilterIndexerCandidate(candidate)
+	if !keep {
+		return
+	}
+	if max := sim.cfg.QueryLimits.MaxCandidates; max > 0 && sim.candidates >= max {
+		return
+	}
+	sim.candidates++
+	for _, protocol := range candidate.Metadata.Protocols() {
+		if !sim.enabled(protocol) {
+			continue
+		}
+		queue, ok := sim.queues[protocol]
+		if !ok {
+			queue = &protocolQueue{protocol: protocol, metadata: make(map[peer.ID]metadata.Protocol)}
+			sim.queues[protocol] = queue
+		}
+		if _, seen := queue.metadata[candidate.MinerPeer.ID]; seen {
+			queue.metadata[candidate.MinerPeer.ID] = candidate.Metadata.Get(protocol)
+			continue
+		}
+		if max := sim.cfg.QueryLimits.MaxQueries(protocol); max > 0 && queue.queried >= max {
+			continue
+		}
+		queue.queried++
+		queue.metadata[candidate.MinerPeer.ID] = candidate.Metadata.Get(protocol)
+		if err := sim.session.AddToRetrieval(sim.result.RetrievalID, []peer.ID{candidate.MinerPeer.ID}); err != nil {
+			logger.Errorw("failed to add provider to retrieval", logging.RetrievalIDKey, sim.result.RetrievalID, "err", err)
+		}
+		sim.connect(queue, &attempt{
+			candidate: candidate,
+			protocol:  protocol,
+			behavior:  sim.providers[candidate.MinerPeer.ID],
+			started:   sim.now,
+		})
+	}
+}
+
+func (sim *simulation) connect(queue *protocolQueue, a *attempt) {
+	sim.emit(events.StartedRetrieval(sim.now, sim.result.RetrievalID, a.candidate, a.protocol))
+	latency := time.Duration(a.behavior.ConnectLatency)
+	timeout := sim.timeout(a.candidate.MinerPeer.ID, a.protocol)
+	switch {
+	case timeout != 0 && latency > timeout:
+		sim.at(timeout, func() { sim.fail(a, fmt.Errorf("%w: %s", retriever.ErrConnectFailed, context.DeadlineExceeded)) })
+	case a.behavior.ConnectError != "":
+		sim.at(latency, func() { sim.fail(a, fmt.Errorf("%w: %s", retriever.ErrConnectFailed, a.behavior.ConnectError)) })
+	default:
+		sim.at(latency, func() {
+			sim.emit(events.ConnectedToProvider(sim.now, sim.result.RetrievalID, a.candidate, a.protocol))
+			sim.session.RecordConnectTime(a.candidate.MinerPeer.ID, latency)
+			sim.wait(queue, a)
+		})
+	}
+}
+
+// wait queues a connected candidate to retrieve from, following the
+// priority wait queue used by the retriever.
+func (sim *simulation) wait(queue *protocolQueue, a *attempt) {
+	queue.waiting = append(queue.waiting, a)
+	if sim.cfg.InitialPause > 0 && !queue.pauseStarted {
+		queue.pauseStarted = true
+		queue.pausing = true
+		sim.at(sim.cfg.InitialPause, func() {
+			queue.pausing = false
+			if queue.next == nil {
+				sim.chooseNext(queue)
+			}
+			sim.runNext(queue)
+		})
+	}
+	if queue.pausing {
+		return
+	}
+	if queue.next == nil {
+		sim.chooseNext(queue)
+	}
+	sim.runNext(queue)
+}
+
+func (sim *simulation) chooseNext(queue *protocolQueue) {
+	if len(queue.waiting) < 2 {
+		queue.next = nil
+		return
+	}
+	peers := make([]peer.ID, 0, len(queue.waiting))
+	mds := make([]metadata.Protocol, 0, len(queue.waiting))
+	for _, a := range queue.waiting {
+		peers = append(peers, a.candidate.MinerPeer.ID)
+		mds = append(mds, queue.metadata[a.candidate.MinerPeer.ID])
+	}
+	queue.next = queue.waiting[sim.session.ChooseNextProviderWithStrategy(peers, mds, sim.strategy)]
+}
+
+func (sim *simulation) runNext(queue *protocolQueue) {
+	if queue.running != nil || len(queue.waiting) == 0 {
+		return
+	}
+	run := queue.next
+	if len(queue.waiting) == 1 {
+		run = queue.waiting[0]
+	}
+	if run == nil {
+		return
+	}
+	for i, a := range queue.waiting {
+		if a == run {
+			queue.waiting = append(queue.waiting[:i], queue.waiting[i+1:]...)
+			break
+		}
+	}
+	queue.running = run
+	sim.retrieve(queue, run)
+}
+
+func (sim *simulation) retrieve(queue *protocolQueue, a *attempt) {
+	finished := func(err error) {
+		if err != nil {
+			sim.fail(a, err)
+		} else {
+			sim.succeed(a)
+		}
+		queue.running = nil
+		sim.chooseNext(queue)
+		sim.runNext(queue)
+	}
+
+	firstByte := time.Duration(a.behavior.FirstByteLatency)
+	timeout := sim.timeout(a.candidate.MinerPeer.ID, a.protocol)
+	if timeout != 0 && firstByte > timeout {
+		sim.at(timeout, func() { finished(fmt.Errorf("%w: timeout after %s", retriever.ErrRetrievalTimedOut, timeout)) })
+		return
+	}
+	start := sim.now
+	sim.at(firstByte, func() {
+		sim.emit(events.FirstByte(sim.now, sim.result.RetrievalID, a.candidate, sim.now.Sub(start), a.protocol))
+		sim.session.RecordFirstByteTime(a.candidate.MinerPeer.ID, sim.now.Sub(start))
+	})
+	transfer := a.behavior.transferTime(sim.retrieval.Size)
+	if a.behavior.RetrievalError != "" && time.Duration(a.behavior.FailAfter) <= transfer {
+		sim.at(firstByte+time.Duration(a.behavior.FailAfter), func() {
+			finished(fmt.Errorf("%w: %s", retriever.ErrRetrievalFailed, a.behavior.RetrievalError))
+		})
+		return
+	}
+	sim.at(firstByte+transfer, func() { finished(nil) })
+}
+
+func (sim *simulation) fail(a *attempt, err error) {
+	sim.errs = multierr.Append(sim.errs, err)
+	msg := err.Error()
+	if errors.Is(err, retriever.ErrRetrievalTimedOut) {
+		msg = fmt.Sprintf("timeout after %s", sim.timeout(a.candidate.MinerPeer.ID, a.protocol))
+	}
+	sim.emit(events.FailedRetrieval(sim.now, sim.result.RetrievalID, a.candidate, a.protocol, msg))
+	cooldown, err := sim.session.RecordFailure(sim.result.RetrievalID, a.candidate.MinerPeer.ID)
+	if err != nil {
+		logger.Errorw("failed to record failure", logging.RetrievalIDKey, sim.result.RetrievalID, "err", err)
+	} else if cooldown > 0 {
+		sim.emit(events.CircuitBreakerOpened(sim.now, sim.result.RetrievalID, a.candidate, a.protocol, cooldown))
+	}
+}
+
+func (sim *simulation) succeed(a *attempt) {
+	duration := sim.now.Sub(a.started)
+	sim.emit(events.Success(sim.now, sim.result.RetrievalID, a.candidate, sim.retrieval.Size, 0, duration, a.protocol))
+	seconds := duration.Seconds()
+	if seconds == 0 { // avoid a divide by zero
+		seconds = 1
+	}
+	if sim.session.RecordSuccess(a.candidate.MinerPeer.ID, uint64(float64(sim.retrieval.Size)/seconds)) {
+		sim.emit(events.CircuitBreakerClosed(sim.now, sim.result.RetrievalID, a.candidate, a.protocol))
+	}
+	sim.result.Provider = a.candidate.MinerPeer.ID
+	sim.result.Protocol = a.protocol
+	sim.done = true
+}
+
+// scheduled is a simulated event, run at a point in simulated time.
+type scheduled struct {
+	at  time.Time
+	seq int
+	fn  func()
+}
+
+// schedule is a heap of scheduled events, ordered by time and then by the
+// order they were scheduled in.
+type schedule struct {
+	items []*scheduled
+	seq   int
+}
+
+func (s schedule) Len() int { return len(s.items) }
+func (s schedule) Less(i, j int) bool {
+	if s.items[i].at.Equal(s.items[j].at) {
+		return s.items[i].seq < s.items[j].seq
+	}
+	return s.items[i].at.Before(s.items[j].at)
+}
+func (s schedule) Swap(i, j int)       { s.items[i], s.items[j] = s.items[j], s.items[i] }
+func (s *schedule) Push(x interface{}) { s.items = append(s.items, x.(*scheduled)) }
+func (s *schedule) Pop() interface{} {
+	last := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return last
+}
@@ -0,0 +1,447 @@
+package replay_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/retriever/replay"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	peers := testutil.GeneratePeers(t, 3)
+	roots := testutil.GenerateCids(2)
+	ms := func(n int) replay.Duration { return replay.Duration(time.Duration(n) * time.Millisecond) }
+
+	// peers[0] is found first but is slow to send its first byte, peers[1]
+	// is found later and is fast
+	slowFirst := replay.Scenario{
+		Retrievals: []replay.Retrieval{{
+			Root: roots[0],
+			Size: 1 << 20,
+			Candidates: []replay.Candidate{
+				{Provider: peers[0], Protocols: "http"},
+				{Provider: peers[1], Protocols: "http", FoundAfter: ms(100)},
+			},
+		}},
+		Providers: map[peer.ID]replay.Behavior{
+			peers[0]: {ConnectLatency: ms(10), FirstByteLatency: ms(2000), Bandwidth: 1 << 20},
+			peers[1]: {ConnectLatency: ms(5), FirstByteLatency: ms(50), Bandwidth: 1 << 20},
+		},
+	}
+
+	testCases := []struct {
+		name             string
+		scenario         replay.Scenario
+		cfg              func(cfg *replay.Config)
+		expectedSequence [][]string
+		expectedProvider []int
+		expectedErr      []error
+	}{
+		{
+			name:     "long provider timeout waits for slow provider",
+			scenario: slowFirst,
+			cfg:      func(cfg *replay.Config) { cfg.ProviderTimeout = 5 * time.Second },
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"10ms connected-to-provider 0",
+				"100ms started-retrieval 1",
+				"105ms connected-to-provider 1",
+				"2.01s first-byte-received 0",
+				"3.01s success 0",
+			}},
+			expectedProvider: []int{0},
+			expectedErr:      []error{nil},
+		},
+		{
+			name:     "short provider timeout moves on to fast provider",
+			scenario: slowFirst,
+			cfg:      func(cfg *replay.Config) { cfg.ProviderTimeout = time.Second },
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"10ms connected-to-provider 0",
+				"100ms started-retrieval 1",
+				"105ms connected-to-provider 1",
+				"1.01s failed-retrieval 0",
+				"1.06s first-byte-received 1",
+				"2.06s success 1",
+			}},
+			expectedProvider: []int{1},
+			expectedErr:      []error{nil},
+		},
+		{
+			name:     "query limit stops fast provider being tried",
+			scenario: slowFirst,
+			cfg: func(cfg *replay.Config) {
+				cfg.ProviderTimeout = time.Second
+				cfg.QueryLimits = types.ProviderQueryLimits{MaxHttpQueries: 1}
+			},
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"10ms connected-to-provider 0",
+				"1.01s failed-retrieval 0",
+			}},
+			expectedProvider: []int{-1},
+			expectedErr:      []error{retriever.ErrRetrievalTimedOut},
+		},
+		{
+			name:     "initial pause lets fast provider be chosen",
+			scenario: slowFirst,
+			cfg: func(cfg *replay.Config) {
+				cfg.ProviderTimeout = 5 * time.Second
+				cfg.InitialPause = 200 * time.Millisecond
+				cfg.Session = cfg.Session.WithoutRandomness()
+			},
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"10ms connected-to-provider 0",
+				"100ms started-retrieval 1",
+				"105ms connected-to-provider 1",
+				"260ms first-byte-received 1",
+				"1.26s success 1",
+			}},
+			expectedProvider: []int{1},
+			expectedErr:      []error{nil},
+		},
+		{
+			name:     "global timeout",
+			scenario: slowFirst,
+			cfg: func(cfg *replay.Config) {
+				cfg.ProviderTimeout = 5 * time.Second
+				cfg.GlobalTimeout = time.Second
+			},
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"10ms connected-to-provider 0",
+				"100ms started-retrieval 1",
+				"105ms connected-to-provider 1",
+			}},
+			expectedProvider: []int{-1},
+			expectedErr:      []error{context.DeadlineExceeded},
+		},
+		{
+			name: "all fail",
+			scenario: replay.Scenario{
+				Retrievals: []replay.Retrieval{{
+					Root: roots[0],
+					Size: 1 << 20,
+					Candidates: []replay.Candidate{
+						{Provider: peers[0], Protocols: "graphsync"},
+						{Provider: peers[1], Protocols: "http"},
+					},
+				}},
+				Providers: map[peer.ID]replay.Behavior{
+					peers[0]: {ConnectLatency: ms(10), ConnectError: "connection refused"},
+					peers[1]: {ConnectLatency: ms(20), FirstByteLatency: ms(30), Bandwidth: 1 << 20, RetrievalError: "bad block", FailAfter: ms(500)},
+				},
+			},
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"0s started-retrieval 1",
+				"10ms failed-retrieval 0",
+				"20ms connected-to-provider 1",
+				"50ms first-byte-received 1",
+				"550ms failed-retrieval 1",
+			}},
+			expectedProvider: []int{-1},
+			expectedErr:      []error{retriever.ErrAllRetrievalsFailed},
+		},
+		{
+			name: "protocols race",
+			scenario: replay.Scenario{
+				Retrievals: []replay.Retrieval{{
+					Root: roots[0],
+					Size: 1 << 20,
+					Candidates: []replay.Candidate{
+						{Provider: peers[0], Protocols: "graphsync"},
+						{Provider: peers[1], Protocols: "http,bitswap"},
+					},
+				}},
+				Providers: map[peer.ID]replay.Behavior{
+					peers[0]: {ConnectLatency: ms(10), FirstByteLatency: ms(10), Bandwidth: 1 << 20},
+					peers[1]: {ConnectLatency: ms(10), FirstByteLatency: ms(10), Bandwidth: 2 << 20},
+				},
+			},
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"0s started-retrieval 1",
+				"10ms connected-to-provider 0",
+				"10ms connected-to-provider 1",
+				"20ms first-byte-received 0",
+				"20ms first-byte-received 1",
+				"520ms success 1",
+			}},
+			expectedProvider: []int{1},
+			expectedErr:      []error{nil},
+		},
+		{
+			name: "protocols restricted",
+			scenario: replay.Scenario{
+				Retrievals: []replay.Retrieval{{
+					Root: roots[0],
+					Size: 1 << 20,
+					Candidates: []replay.Candidate{
+						{Provider: peers[0], Protocols: "graphsync"},
+						{Provider: peers[1], Protocols: "http,bitswap"},
+					},
+				}},
+				Providers: map[peer.ID]replay.Behavior{
+					peers[0]: {ConnectLatency: ms(10), FirstByteLatency: ms(10), Bandwidth: 1 << 20},
+					peers[1]: {ConnectLatency: ms(10), FirstByteLatency: ms(10), Bandwidth: 2 << 20},
+				},
+			},
+			cfg: func(cfg *replay.Config) {
+				cfg.Protocols = []multicodec.Code{multicodec.TransportGraphsyncFilecoinv1}
+			},
+			expectedSequence: [][]string{{
+				"0s started-retrieval 0",
+				"10ms connected-to-provider 0",
+				"20ms first-byte-received 0",
+				"1.02s success 0",
+			}},
+			expectedProvider: []int{0},
+			expectedErr:      []error{nil},
+		},
+		{
+			name: "bitswap only",
+			scenario: replay.Scenario{
+				Retrievals: []replay.Retrieval{{
+					Root:       roots[0],
+					Candidates: []replay.Candidate{{Provider: peers[0], Protocols: "bitswap"}},
+				}},
+			},
+			expectedSequence: [][]string{nil},
+			expectedProvider: []int{-1},
+			expectedErr:      []error{retriever.ErrNoCandidates},
+		},
+		{
+			name: "failures inform later retrievals",
+			scenario: replay.Scenario{
+				Retrievals: []replay.Retrieval{
+					{
+						Root: roots[0],
+						Candidates: []replay.Candidate{
+							{Provider: peers[0], Protocols: "http"},
+							{Provider: peers[1], Protocols: "http", FoundAfter: ms(100)},
+						},
+					},
+					{
+						Root: roots[1],
+						Candidates: []replay.Candidate{
+							{Provider: peers[0], Protocols: "http"},
+							{Provider: peers[1], Protocols: "http"},
+						},
+					},
+				},
+				Providers: map[peer.ID]replay.Behavior{
+					peers[0]: {ConnectLatency: ms(10), FirstByteLatency: ms(10), RetrievalError: "bad block"},
+					peers[1]: {ConnectLatency: ms(10), FirstByteLatency: ms(10)},
+				},
+			},
+			cfg: func(cfg *replay.Config) {
+				cfg.InitialPause = 50 * time.Millisecond
+				cfg.Session = cfg.Session.WithoutRandomness()
+			},
+			expectedSequence: [][]string{
+				{
+					"0s started-retrieval 0",
+					"10ms connected-to-provider 0",
+					"70ms first-byte-received 0",
+					"70ms failed-retrieval 0",
+					"100ms started-retrieval 1",
+					"110ms connected-to-provider 1",
+					"120ms first-byte-received 1",
+					"120ms success 1",
+				},
+				{
+					"0s started-retrieval 0",
+					"0s started-retrieval 1",
+					"10ms connected-to-provider 0",
+					"10ms connected-to-provider 1",
+					"70ms first-byte-received 1",
+					"70ms success 1",
+				},
+			},
+			expectedProvider: []int{1, 1},
+			expectedErr:      []error{nil, nil},
+		},
+		{
+			name: "circuit breaker suspends failing provider",
+			scenario: replay.Scenario{
+				Retrievals: []replay.Retrieval{
+					{
+						Root:       roots[0],
+						Candidates: []replay.Candidate{{Provider: peers[0], Protocols: "http"}},
+					},
+					{
+						Root:       roots[1],
+						Candidates: []replay.Candidate{{Provider: peers[0], Protocols: "http"}},
+					},
+				},
+				Providers: map[peer.ID]replay.Behavior{
+					peers[0]: {ConnectLatency: ms(10), ConnectError: "connection refused"},
+				},
+			},
+			cfg: func(cfg *replay.Config) {
+				cfg.Session = cfg.Session.WithCircuitBreaker(session.CircuitBreaker{Threshold: 1, Cooldown: time.Minute})
+			},
+			expectedSequence: [][]string{
+				{
+					"0s started-retrieval 0",
+					"10ms failed-retrieval 0",
+					"10ms circuit-breaker-opened 0",
+				},
+				nil,
+			},
+			expectedProvider: []int{-1, -1},
+			expectedErr:      []error{retriever.ErrAllRetrievalsFailed, retriever.ErrNoCandidates},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			cfg := replay.DefaultConfig()
+			cfg.InitialPause = 0
+			if testCase.cfg != nil {
+				testCase.cfg(&cfg)
+			}
+			result, err := replay.Replay(testCase.scenario, cfg)
+			req.NoError(err)
+			req.Len(result.Retrievals, len(testCase.scenario.Retrievals))
+
+			for i, rr := range result.Retrievals {
+				req.Equal(testCase.scenario.Retrievals[i].Root, rr.Root)
+				if testCase.expectedSequence != nil {
+					req.Equal(testCase.expectedSequence[i], sequence(rr, peers), "retrieval %d", i)
+				}
+				if testCase.expectedErr[i] != nil {
+					req.ErrorIs(rr.Err, testCase.expectedErr[i])
+				} else {
+					req.NoError(rr.Err)
+				}
+				if testCase.expectedProvider[i] >= 0 {
+					req.Equal(peers[testCase.expectedProvider[i]], rr.Provider)
+				} else if testCase.expectedErr[i] != nil {
+					req.Empty(rr.Provider)
+				}
+				if i > 0 {
+					prev := result.Retrievals[i-1]
+					req.Equal(prev.Start.Add(prev.Duration), rr.Start)
+				}
+			}
+		})
+	}
+
+	t.Run("same seed, same decisions", func(t *testing.T) {
+		scenario := replay.Scenario{Providers: map[peer.ID]replay.Behavior{}}
+		for i := 0; i < 10; i++ {
+			retrieval := replay.Retrieval{Root: testutil.GenerateCid(), Size: 1 << 20}
+			for j, p := range peers {
+				retrieval.Candidates = append(retrieval.Candidates, replay.Candidate{Provider: p, Protocols: "http"})
+				scenario.Providers[p] = replay.Behavior{ConnectLatency: ms(10), FirstByteLatency: ms(10 * (j + 1)), Bandwidth: uint64(j+1) << 20}
+			}
+			scenario.Retrievals = append(scenario.Retrievals, retrieval)
+		}
+		cfg := replay.DefaultConfig()
+		cfg.Seed = 1234
+		providers := func() []string {
+			result, err := replay.Replay(scenario, cfg)
+			require.NoError(t, err)
+			var providers []string
+			for _, rr := range result.Retrievals {
+				providers = append(providers, rr.Provider.String())
+			}
+			return providers
+		}
+		require.Equal(t, providers(), providers())
+	})
+
+	t.Run("default session config unchanged", func(t *testing.T) {
+		sessionCfg := session.DefaultConfig()
+		cfg := replay.DefaultConfig()
+		cfg.Session = sessionCfg
+		_, err := replay.Replay(slowFirst, cfg)
+		require.NoError(t, err)
+		require.Nil(t, sessionCfg.Random)
+	})
+}
+
+func TestReadScenario(t *testing.T) {
+	peers := testutil.GeneratePeers(t, 1)
+	root := testutil.GenerateCid()
+
+	scenario, err := replay.ReadScenario(strings.NewReader(fmt.Sprintf(`{
+		"retrievals": [{
+			"root": {"/": "%s"},
+			"size": 1024,
+			"candidates": [{"provider": "%s", "protocols": "graphsync,http", "foundAfter": "150ms", "verifiedDeal": true}]
+		}],
+		"providers": {
+			"%s": {"connectLatency": "20ms", "firstByteLatency": "1.5s", "bandwidth": 1048576, "retrievalError": "oops", "failAfter": "2s"}
+		}
+	}`, root, peers[0], peers[0])))
+	require.NoError(t, err)
+	require.Equal(t, replay.Scenario{
+		Retrievals: []replay.Retrieval{{
+			Root: root,
+			Size: 1024,
+			Candidates: []replay.Candidate{{
+				Provider:     peers[0],
+				Protocols:    "graphsync,http",
+				FoundAfter:   replay.Duration(150 * time.Millisecond),
+				VerifiedDeal: true,
+			}},
+		}},
+		Providers: map[peer.ID]replay.Behavior{
+			peers[0]: {
+				ConnectLatency:   replay.Duration(20 * time.Millisecond),
+				FirstByteLatency: replay.Duration(1500 * time.Millisecond),
+				Bandwidth:        1 << 20,
+				RetrievalError:   "oops",
+				FailAfter:        replay.Duration(2 * time.Second),
+			},
+		},
+	}, scenario)
+
+	_, err = replay.ReadScenario(strings.NewReader(`{"providers": {"` + peers[0].String() + `": {"connectLatency": 20}}}`))
+	require.ErrorContains(t, err, "duration should be a string")
+
+	_, err = replay.ReadScenario(strings.NewReader(`{"retrievals": [{"size": 1}]}`))
+	require.ErrorContains(t, err, "retrieval 0 has no root CID")
+
+	_, err = replay.Replay(replay.Scenario{Retrievals: []replay.Retrieval{{
+		Root:       root,
+		Candidates: []replay.Candidate{{Provider: peers[0], Protocols: "carrier-pigeon"}},
+	}}}, replay.DefaultConfig())
+	require.ErrorContains(t, err, "unrecognized protocol: carrier-pigeon")
+}
+
+// sequence summarises the events of a replayed retrieval, as the time since
+// the retrieval started, the event code and the index of the provider.
+func sequence(rr replay.RetrievalResult, peers []peer.ID) []string {
+	var seq []string
+	for _, event := range rr.Events {
+		provider := -1
+		if pe, ok := event.(events.EventWithProviderID); ok {
+			for i, p := range peers {
+				if p == pe.ProviderId() {
+					provider = i
+				}
+			}
+		}
+		seq = append(seq, fmt.Sprintf("%s %s %d", event.Time().Sub(rr.Start), event.Code(), provider))
+	}
+	return seq
+}
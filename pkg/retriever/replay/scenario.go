@@ -0,0 +1,153 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+)
+
+// Scenario is a recorded set of retrievals, and a model of how the providers
+// that were candidates for them behave, to be replayed.
+type Scenario struct {
+	// Retrievals are replayed one after the other, in order, sharing the
+	// session so that what is learnt about providers in one retrieval informs
+	// the decisions made in the next.
+	Retrievals []Retrieval `json:"retrievals"`
+	// Providers describes how each provider behaves. A provider without a
+	// Behavior connects, responds and transfers instantly.
+	Providers map[peer.ID]Behavior `json:"providers"`
+}
+
+// Retrieval is a recorded retrieval of a root CID and the candidates that were
+// found for it.
+type Retrieval struct {
+	Root cid.Cid `json:"root"`
+	// Size is the number of bytes transferred by a successful retrieval.
+	Size uint64 `json:"size"`
+	// ExpectedSize is the size hint given with the request, if any, see
+	// types.RetrievalRequest#ExpectedSize.
+	ExpectedSize uint64      `json:"expectedSize,omitempty"`
+	Candidates   []Candidate `json:"candidates"`
+}
+
+// Candidate is a provider found for a Retrieval.
+type Candidate struct {
+	Provider peer.ID `json:"provider"`
+	// Protocols is a comma separated list of the protocols the provider was
+	// found for: "bitswap", "graphsync" and "http".
+	Protocols string `json:"protocols"`
+	// FoundAfter is the time from the start of the retrieval until the
+	// candidate was found.
+	FoundAfter Duration `json:"foundAfter,omitempty"`
+	// VerifiedDeal and FastRetrieval are the Graphsync metadata flags of the
+	// candidate, which are taken into account when scoring it.
+	VerifiedDeal  bool `json:"verifiedDeal,omitempty"`
+	FastRetrieval bool `json:"fastRetrieval,omitempty"`
+}
+
+// Behavior models how a provider responds to a retrieval.
+type Behavior struct {
+	// ConnectLatency is the time taken to connect to the provider.
+	ConnectLatency Duration `json:"connectLatency,omitempty"`
+	// ConnectError, if set, is the error connecting to the provider fails
+	// with once ConnectLatency has passed.
+	ConnectError string `json:"connectError,omitempty"`
+	// FirstByteLatency is the time from starting a retrieval with the
+	// provider until the first byte is received.
+	FirstByteLatency Duration `json:"firstByteLatency,omitempty"`
+	// Bandwidth is the rate in bytes per second that the provider transfers
+	// data at, once the first byte has been received. Zero is unlimited.
+	Bandwidth uint64 `json:"bandwidth,omitempty"`
+	// RetrievalError, if set, is the error a retrieval from the provider fails
+	// with, FailAfter the first byte has been received. A retrieval that
+	// completes before then succeeds.
+	RetrievalError string   `json:"retrievalError,omitempty"`
+	FailAfter      Duration `json:"failAfter,omitempty"`
+}
+
+// transferTime returns the time the provider takes to transfer size bytes
+// after the first byte.
+func (b Behavior) transferTime(size uint64) time.Duration {
+	if b.Bandwidth == 0 {
+		return 0
+	}
+	return time.Duration(float64(size) / float64(b.Bandwidth) * float64(time.Second))
+}
+
+// retrievalCandidate converts the Candidate to a RetrievalCandidate for the
+// given root.
+func (c Candidate) retrievalCandidate(root cid.Cid) (types.RetrievalCandidate, error) {
+	protocols, err := types.ParseProtocolsString(c.Protocols)
+	if err != nil {
+		return types.RetrievalCandidate{}, fmt.Errorf("candidate %s: %w", c.Provider, err)
+	}
+	mds := make([]metadata.Protocol, 0, len(protocols))
+	for _, protocol := range protocols {
+		switch protocol {
+		case multicodec.TransportBitswap:
+			mds = append(mds, &metadata.Bitswap{})
+		case multicodec.TransportGraphsyncFilecoinv1:
+			mds = append(mds, &metadata.GraphsyncFilecoinV1{
+				PieceCID:      root,
+				VerifiedDeal:  c.VerifiedDeal,
+				FastRetrieval: c.FastRetrieval,
+			})
+		case multicodec.TransportIpfsGatewayHttp:
+			mds = append(mds, &metadata.IpfsGatewayHttp{})
+		}
+	}
+	return types.NewRetrievalCandidate(c.Provider, nil, root, mds...), nil
+}
+
+// ReadScenario reads a JSON encoded Scenario.
+func ReadScenario(r io.Reader) (Scenario, error) {
+	var scenario Scenario
+	if err := json.NewDecoder(r).Decode(&scenario); err != nil {
+		return Scenario{}, fmt.Errorf("failed to decode scenario: %w", err)
+	}
+	for i, retrieval := range scenario.Retrievals {
+		if !retrieval.Root.Defined() {
+			return Scenario{}, fmt.Errorf("retrieval %d has no root CID", i)
+		}
+	}
+	return scenario, nil
+}
+
+// LoadScenario reads a JSON encoded Scenario from a file.
+func LoadScenario(path string) (Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+	defer f.Close()
+	return ReadScenario(f)
+}
+
+// Duration is a time.Duration that is encoded in JSON as a string, such as
+// "1.5s" or "300ms".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration should be a string, such as \"300ms\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -4,19 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/benbjohnson/clock"
 	"github.com/dustin/go-humanize"
 	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
 	"github.com/filecoin-project/lassie/pkg/retriever/combinators"
+	"github.com/filecoin-project/lassie/pkg/session"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"golang.org/x/exp/slices"
 )
 
 var (
@@ -33,11 +39,16 @@ var (
 	ErrAllQueriesFailed            = errors.New("all queries failed")
 	ErrRetrievalTimedOut           = errors.New("retrieval timed out")
 	ErrRetrievalAlreadyRunning     = errors.New("retrieval already running for CID")
+	ErrBudgetExceeded              = errors.New("retrieval budget exceeded")
+	ErrNoProtocolsEnabled          = errors.New("none of the requested protocols are enabled")
+	ErrProtocolNotConfigured       = errors.New("protocol is not configured")
 )
 
 type Session interface {
-	GetStorageProviderTimeout(storageProviderId peer.ID) time.Duration
+	GetStorageProviderTimeout(storageProviderId peer.ID, protocol multicodec.Code) time.Duration
 	FilterIndexerCandidate(candidate types.RetrievalCandidate) (bool, types.RetrievalCandidate)
+	IsAllowListed(storageProviderId peer.ID) bool
+	RecordAllowListedFailure(storageProviderId peer.ID) bool
 
 	RegisterRetrieval(retrievalId types.RetrievalID, cid cid.Cid, selector datamodel.Node) bool
 	AddToRetrieval(retrievalId types.RetrievalID, storageProviderIds []peer.ID) error
@@ -45,10 +56,15 @@ type Session interface {
 
 	RecordConnectTime(storageProviderId peer.ID, connectTime time.Duration)
 	RecordFirstByteTime(storageProviderId peer.ID, firstByteTime time.Duration)
-	RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) error
-	RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64)
+	RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) (time.Duration, error)
+	RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) bool
 
 	ChooseNextProvider(peers []peer.ID, metadata []metadata.Protocol) int
+	ChooseNextProviderWithStrategy(peers []peer.ID, metadata []metadata.Protocol, strategy session.Strategy) int
+	RecordContentSize(cid cid.Cid, selector datamodel.Node, size uint64)
+	ChooseStrategy(cid cid.Cid, selector datamodel.Node, expectedSize uint64) session.Strategy
+	RecordAffinity(key string, storageProviderId peer.ID) bool
+	ChooseAffinityProvider(key string, peers []peer.ID) (int, bool)
 }
 
 type Retriever struct {
@@ -58,6 +74,11 @@ type Retriever struct {
 	session      Session
 	clock        clock.Clock
 	protocols    []multicodec.Code
+	retries      RetryPolicies
+
+	// the protocols disabled at runtime, see SetProtocolEnabled
+	disabledLk sync.RWMutex
+	disabled   map[multicodec.Code]struct{}
 }
 
 type CandidateFinder interface {
@@ -66,7 +87,18 @@ type CandidateFinder interface {
 }
 
 type eventStats struct {
-	failedCount int64
+	failedCount      int64
+	indexerQueries   atomic.Uint64
+	graphsyncQueries atomic.Uint64
+	httpQueries      atomic.Uint64
+}
+
+// setQueryCounts records the number of queries issued for the retrieval on
+// its stats
+func (es *eventStats) setQueryCounts(stats *types.RetrievalStats) {
+	stats.IndexerQueries = es.indexerQueries.Load()
+	stats.GraphsyncQueries = es.graphsyncQueries.Load()
+	stats.HttpQueries = es.httpQueries.Load()
 }
 
 func NewRetriever(
@@ -74,8 +106,9 @@ func NewRetriever(
 	session Session,
 	candidateFinder CandidateFinder,
 	protocolRetrievers map[multicodec.Code]types.CandidateRetriever,
+	capabilities *CapabilityCache,
 ) (*Retriever, error) {
-	return NewRetrieverWithClock(ctx, session, candidateFinder, protocolRetrievers, clock.New())
+	return NewRetrieverWithClock(ctx, session, candidateFinder, protocolRetrievers, capabilities, clock.New())
 }
 
 func NewRetrieverWithClock(
@@ -83,19 +116,22 @@ func NewRetrieverWithClock(
 	session Session,
 	candidateFinder CandidateFinder,
 	protocolRetrievers map[multicodec.Code]types.CandidateRetriever,
+	capabilities *CapabilityCache,
 	clock clock.Clock,
 ) (*Retriever, error) {
 	retriever := &Retriever{
 		eventManager: events.NewEventManager(ctx),
 		session:      session,
 		clock:        clock,
+		disabled:     make(map[multicodec.Code]struct{}),
 	}
 	retriever.protocols = []multicodec.Code{}
 	for protocol := range protocolRetrievers {
 		retriever.protocols = append(retriever.protocols, protocol)
 	}
+	sort.Slice(retriever.protocols, func(i, j int) bool { return retriever.protocols[i] < retriever.protocols[j] })
 	retriever.executor = combinators.RetrieverWithCandidateFinder{
-		CandidateFinder: NewAssignableCandidateFinderWithClock(candidateFinder, session.FilterIndexerCandidate, clock),
+		CandidateFinder: NewAssignableCandidateFinderWithClock(candidateFinder, session.FilterIndexerCandidate, clock).WithCapabilities(capabilities),
 		CandidateRetriever: combinators.SplitRetriever[multicodec.Code]{
 			AsyncCandidateSplitter: combinators.NewAsyncCandidateSplitter(retriever.protocols, NewProtocolSplitter),
 			CandidateRetrievers:    protocolRetrievers,
@@ -106,6 +142,57 @@ func NewRetrieverWithClock(
 	return retriever, nil
 }
 
+// SetRetryPolicies sets how failed attempts to retrieve from candidates are
+// retried, which is never by default. It must be called before Start.
+func (retriever *Retriever) SetRetryPolicies(policies RetryPolicies) {
+	retriever.retries = policies
+}
+
+// Protocols returns the protocols that the retriever was configured with,
+// whether or not they're currently enabled.
+func (retriever *Retriever) Protocols() []multicodec.Code {
+	return append([]multicodec.Code(nil), retriever.protocols...)
+}
+
+// SetProtocolEnabled enables or disables one of the configured protocols.
+// Retrievals started while a protocol is disabled don't use it, those already
+// in progress are unaffected. It returns ErrProtocolNotConfigured if the
+// retriever wasn't configured with the protocol.
+func (retriever *Retriever) SetProtocolEnabled(protocol multicodec.Code, enabled bool) error {
+	if !slices.Contains(retriever.protocols, protocol) {
+		return fmt.Errorf("%w: %s", ErrProtocolNotConfigured, protocol)
+	}
+	retriever.disabledLk.Lock()
+	defer retriever.disabledLk.Unlock()
+	if enabled {
+		delete(retriever.disabled, protocol)
+	} else {
+		retriever.disabled[protocol] = struct{}{}
+	}
+	return nil
+}
+
+// IsProtocolEnabled returns true if the protocol is configured and hasn't been
+// disabled with SetProtocolEnabled.
+func (retriever *Retriever) IsProtocolEnabled(protocol multicodec.Code) bool {
+	retriever.disabledLk.RLock()
+	defer retriever.disabledLk.RUnlock()
+	_, disabled := retriever.disabled[protocol]
+	return !disabled && slices.Contains(retriever.protocols, protocol)
+}
+
+func (retriever *Retriever) enabledProtocols() []multicodec.Code {
+	retriever.disabledLk.RLock()
+	defer retriever.disabledLk.RUnlock()
+	enabled := make([]multicodec.Code, 0, len(retriever.protocols))
+	for _, protocol := range retriever.protocols {
+		if _, disabled := retriever.disabled[protocol]; !disabled {
+			enabled = append(enabled, protocol)
+		}
+	}
+	return enabled
+}
+
 // Start will start the retriever events system
 func (retriever *Retriever) Start() {
 	retriever.eventManager.Start()
@@ -117,6 +204,12 @@ func (retriever *Retriever) Stop() chan struct{} {
 	return retriever.eventManager.Stop()
 }
 
+// IsRunning returns true if the retriever has been started and has not yet
+// been stopped.
+func (retriever *Retriever) IsRunning() bool {
+	return retriever.eventManager.IsStarted() && !retriever.eventManager.IsStopped()
+}
+
 // RegisterSubscriber registers a subscriber to receive all events fired during the
 // process of making a retrieval, including the process of querying available
 // storage providers to find compatible ones to attempt retrieval from.
@@ -124,6 +217,18 @@ func (retriever *Retriever) RegisterSubscriber(subscriber types.RetrievalEventSu
 	return retriever.eventManager.RegisterSubscriber(subscriber)
 }
 
+// DispatchEvent dispatches an event to all subscribers. It is used for events
+// that aren't fired by a retrieval, such as swarm telemetry.
+func (retriever *Retriever) DispatchEvent(event types.RetrievalEvent) {
+	retriever.eventManager.DispatchEvent(event)
+}
+
+// FlushEvents waits until the events fired so far have been delivered to the
+// subscribers, or the context is done.
+func (retriever *Retriever) FlushEvents(ctx context.Context) error {
+	return retriever.eventManager.Flush(ctx)
+}
+
 // Retrieve attempts to retrieve the given CID using the configured
 // CandidateFinder to find storage providers that should have the CID.
 func (retriever *Retriever) Retrieve(
@@ -135,12 +240,23 @@ func (retriever *Retriever) Retrieve(
 	if !retriever.eventManager.IsStarted() {
 		return nil, ErrRetrieverNotStarted
 	}
+	if err := request.ValidateByteRange(); err != nil {
+		return nil, err
+	}
+	if err := request.ValidateSelector(); err != nil {
+		return nil, err
+	}
+	protocols := request.GetSupportedProtocols(retriever.enabledProtocols())
+	if len(protocols) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoProtocolsEnabled, request.Protocols)
+	}
 	if !retriever.session.RegisterRetrieval(request.RetrievalID, request.Root, request.GetSelector()) {
 		return nil, fmt.Errorf("%w: %s", ErrRetrievalAlreadyRunning, request.Root)
 	}
+	log := logger.With(logging.RetrievalIDKey, request.RetrievalID, "root", request.Root)
 	defer func() {
 		if err := retriever.session.EndRetrieval(request.RetrievalID); err != nil {
-			logger.Errorf("failed to end retrieval tracking for %s: %s", request.Root, err.Error())
+			log.Errorw("failed to end retrieval tracking", "err", err)
 		}
 	}()
 
@@ -162,7 +278,31 @@ func (retriever *Retriever) Retrieve(
 	descriptor = strings.TrimPrefix(descriptor, "/ipfs/"+request.Root.String())
 
 	// Emit a StartedFetch event signaling that the Lassie fetch has started
-	onRetrievalEvent(events.StartedFetch(retriever.clock.Now(), request.RetrievalID, request.Root, descriptor, request.GetSupportedProtocols(retriever.protocols)...))
+	onRetrievalEvent(events.StartedFetch(retriever.clock.Now(), request.RetrievalID, request.Root, descriptor, protocols...))
+
+	// enforce the block and byte budget on the blocks we store, ending the
+	// retrieval once a block beyond the budget is encountered
+	var budget *retrievalBudget
+	if request.MaxBlocks > 0 || request.MaxBytes > 0 || request.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		budget = newRetrievalBudget(request.MaxBlocks, request.MaxBytes, cancel)
+		request.LinkSystem.StorageWriteOpener = budget.wrapWriteOpener(request.LinkSystem.StorageWriteOpener)
+	}
+	// and the time budget, fetching less of the depth of the DAG as the time
+	// runs out and ending the retrieval once it has
+	if request.MaxDuration > 0 {
+		request.LinkPolicy = newElapsedBudget(retriever.clock, request).linkPolicy(request.LinkPolicy)
+		expiry := retriever.clock.AfterFunc(request.MaxDuration, budget.expire)
+		defer expiry.Stop()
+	}
+	// share the limit on the providers attempted between the protocols
+	ctx = withAttemptLimiter(ctx, request.MaxAttempts)
+	ctx = withRetryPolicies(ctx, retriever.retries)
+	startTime := retriever.clock.Now()
+	// only split the retrieval between the protocols enabled as it started
+	request.Protocols = protocols
 
 	// retrieve, note that we could get a successful retrieval
 	// (retrievalStats!=nil) _and_ also an error return because there may be
@@ -178,20 +318,47 @@ func (retriever *Retriever) Retrieve(
 	onRetrievalEvent(events.Finished(retriever.clock.Now(), request.RetrievalID, types.RetrievalCandidate{RootCid: request.Root}))
 
 	if err != nil && retrievalStats == nil {
-		return nil, err
+		if budget == nil {
+			return nil, err
+		}
+		exhausted, blocks, bytes := budget.isExhausted()
+		if !exhausted {
+			return nil, err
+		}
+		if blocks == 0 {
+			return nil, fmt.Errorf("%w: the root block wasn't retrieved within the budget", ErrBudgetExceeded)
+		}
+		log.Infow("Retrieval budget reached, ending with a partial result",
+			"blocks", blocks,
+			"bytes", humanize.IBytes(bytes),
+			"err", err,
+		)
+		partialStats := &types.RetrievalStats{
+			RootCid:  request.Root,
+			Size:     bytes,
+			Blocks:   blocks,
+			Duration: retriever.clock.Since(startTime),
+			Partial:  true,
+		}
+		eventStats.setQueryCounts(partialStats)
+		return partialStats, nil
+	}
+
+	eventStats.setQueryCounts(retrievalStats)
+
+	retriever.session.RecordContentSize(request.Root, request.GetSelector(), retrievalStats.Size)
+	// Bitswap retrievals aren't served by a single provider to have an
+	// affinity with
+	if request.AffinityKey != "" && retrievalStats.StorageProviderId != peer.ID("") {
+		retrievalStats.AffinityHit = retriever.session.RecordAffinity(request.AffinityKey, retrievalStats.StorageProviderId)
 	}
 
 	// success
-	logger.Infof(
-		"Successfully retrieved from miner %s for %s\n"+
-			"\tDuration: %s\n"+
-			"\tBytes Received: %s\n"+
-			"\tTotal Payment: %s",
-		retrievalStats.StorageProviderId,
-		request.Root,
-		retrievalStats.Duration,
-		humanize.IBytes(retrievalStats.Size),
-		types.FIL(retrievalStats.TotalPayment),
+	log.Infow("Successfully retrieved",
+		"storageProviderId", retrievalStats.StorageProviderId,
+		"duration", retrievalStats.Duration,
+		"bytesReceived", humanize.IBytes(retrievalStats.Size),
+		"totalPayment", types.FIL(retrievalStats.TotalPayment),
 	)
 
 	return retrievalStats, nil
@@ -213,6 +380,15 @@ func makeOnRetrievalEvent(
 		logEvent(event)
 
 		switch ret := event.(type) {
+		case events.StartedFindingCandidatesEvent:
+			eventStats.indexerQueries.Add(1)
+		case events.StartedRetrievalEvent:
+			switch ret.Protocol() {
+			case multicodec.TransportGraphsyncFilecoinv1:
+				eventStats.graphsyncQueries.Add(1)
+			case multicodec.TransportIpfsGatewayHttp:
+				eventStats.httpQueries.Add(1)
+			}
 		case events.CandidatesFilteredEvent:
 			handleCandidatesFilteredEvent(retrievalId, session, retrievalCid, ret)
 		case events.FailedRetrievalEvent:
@@ -234,11 +410,11 @@ func handleFailureEvent(
 	event events.FailedRetrievalEvent,
 ) {
 	eventStats.failedCount++
-	logger.Warnf(
-		"Failed to retrieve from miner %s for %s: %s",
-		event.ProviderId(),
-		event.RootCid(),
-		event.ErrorMessage(),
+	logger.Warnw("Failed to retrieve from miner",
+		logging.RetrievalIDKey, retrievalId,
+		"storageProviderId", event.ProviderId(),
+		"root", event.RootCid(),
+		"errorMessage", event.ErrorMessage(),
 	)
 }
 
@@ -254,7 +430,7 @@ func handleCandidatesFilteredEvent(
 			ids = append(ids, c.MinerPeer.ID)
 		}
 		if err := session.AddToRetrieval(retrievalId, ids); err != nil {
-			logger.Errorf("failed to add storage providers to tracked retrieval for %s: %s", retrievalCid, err.Error())
+			logger.Errorw("failed to add storage providers to tracked retrieval", logging.RetrievalIDKey, retrievalId, "root", retrievalCid, "err", err)
 		}
 	}
 }
@@ -273,7 +449,8 @@ func logEvent(event types.RetrievalEvent) {
 			kv = append(kv, key, kva[i+1])
 		}
 	}
-	logadd("code", event.Code(),
+	logadd(logging.RetrievalIDKey, event.RetrievalId(),
+		"code", event.Code(),
 		"rootCid", event.RootCid(),
 		"storageProviderId", events.Identifier(event))
 	switch tevent := event.(type) {
@@ -290,6 +467,12 @@ func logEvent(event types.RetrievalEvent) {
 		logadd("bytes", tevent.ByteCount())
 	case events.FailedEvent:
 		logadd("errorMessage", tevent.ErrorMessage())
+	case events.CandidateSkippedEvent:
+		logadd("protocol", tevent.Protocol(), "reason", tevent.Reason())
+	case events.CircuitBreakerOpenedEvent:
+		logadd("protocol", tevent.Protocol(), "cooldown", tevent.Cooldown())
+	case events.ProviderAlertEvent:
+		logadd("protocol", tevent.Protocol(), "reason", tevent.Reason(), "errorMessage", tevent.ErrorMessage())
 	case events.SucceededEvent:
 		logadd("receivedSize", tevent.ReceivedBytesSize())
 	}
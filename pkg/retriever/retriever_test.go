@@ -34,7 +34,7 @@ func TestRetrieverStart(t *testing.T) {
 	gsretriever := NewGraphsyncRetriever(session, client)
 	ret, err := NewRetriever(context.Background(), session, candidateFinder, map[multicodec.Code]types.CandidateRetriever{
 		multicodec.TransportGraphsyncFilecoinv1: gsretriever,
-	})
+	}, nil)
 	require.NoError(t, err)
 
 	// --- run ---
@@ -47,6 +47,29 @@ func TestRetrieverStart(t *testing.T) {
 	require.Nil(t, result)
 }
 
+func TestRetrieverProtocolsNotEnabled(t *testing.T) {
+	candidateFinder := &testutil.MockCandidateFinder{}
+	client := &testutil.MockClient{}
+	session := session.NewSession(nil, true)
+	gsretriever := NewGraphsyncRetriever(session, client)
+	ret, err := NewRetriever(context.Background(), session, candidateFinder, map[multicodec.Code]types.CandidateRetriever{
+		multicodec.TransportGraphsyncFilecoinv1: gsretriever,
+	}, nil)
+	require.NoError(t, err)
+	ret.Start()
+	defer ret.Stop()
+
+	// --- run ---
+	result, err := ret.Retrieve(context.Background(), types.RetrievalRequest{
+		LinkSystem:  cidlink.DefaultLinkSystem(),
+		RetrievalID: types.RetrievalID(uuid.New()),
+		Request:     trustlessutils.Request{Root: cid.MustParse("bafkqaalb")},
+		Protocols:   []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportIpfsGatewayHttp},
+	}, func(types.RetrievalEvent) {})
+	require.ErrorIs(t, err, ErrNoProtocolsEnabled)
+	require.Nil(t, result)
+}
+
 func TestRetriever(t *testing.T) {
 	rid := types.RetrievalID(uuid.New())
 	cid1 := cid.MustParse("bafkqaalb")
@@ -66,7 +89,10 @@ func TestRetriever(t *testing.T) {
 		returns_retrievals map[string]testutil.DelayedClientReturn
 		cancelAfter        time.Duration
 		successfulPeer     peer.ID
+		queryLimits        types.ProviderQueryLimits
+		maxAttempts        uint
 		err                error
+		expectedQueries    uint64
 		expectedSequence   []testutil.ExpectedActionsAtTime
 	}{
 		{
@@ -136,6 +162,156 @@ func TestRetriever(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:        "query limit reached",
+			queryLimits: types.ProviderQueryLimits{MaxGraphsyncQueries: 1},
+			candidates: []types.RetrievalCandidate{
+				{MinerPeer: peer.AddrInfo{ID: peerA}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+				{MinerPeer: peer.AddrInfo{ID: peerB}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+			},
+			returns_connected: map[string]testutil.DelayedConnectReturn{
+				string(peerA): {Err: nil, Delay: time.Millisecond * 20},
+				string(peerB): {Err: nil, Delay: time.Millisecond * 20},
+			},
+			returns_retrievals: map[string]testutil.DelayedClientReturn{
+				string(peerA): {ResultStats: &types.RetrievalStats{
+					StorageProviderId: peerA,
+					Size:              1,
+					Blocks:            2,
+					Duration:          3 * time.Second,
+					TotalPayment:      big.Zero(),
+					RootCid:           cid1,
+					AskPrice:          abi.NewTokenAmount(0),
+				}, Delay: time.Millisecond * 5},
+			},
+			expectedQueries: 1,
+			expectedSequence: []testutil.ExpectedActionsAtTime{
+				{
+					AfterStart: 0,
+					CandidatesDiscovered: []testutil.DiscoveredCandidate{
+						{
+							Cid:       cid1,
+							Candidate: types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peerA}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+						},
+						{
+							Cid:       cid1,
+							Candidate: types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peerB}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+						},
+					},
+					ReceivedConnections: []peer.ID{peerA},
+					ExpectedEvents: []types.RetrievalEvent{
+						events.StartedFetch(startTime, rid, cid1, "?dag-scope=all&dups=n", multicodec.TransportGraphsyncFilecoinv1),
+						events.StartedFindingCandidates(startTime, rid, cid1),
+						events.CandidatesFound(startTime, rid, cid1, []types.RetrievalCandidate{types.NewRetrievalCandidate(peerA, nil, cid1), types.NewRetrievalCandidate(peerB, nil, cid1)}),
+						events.CandidatesFiltered(startTime, rid, cid1, []types.RetrievalCandidate{types.NewRetrievalCandidate(peerA, nil, cid1), types.NewRetrievalCandidate(peerB, nil, cid1)}),
+						events.StartedRetrieval(startTime, rid, types.NewRetrievalCandidate(peerA, nil, cid1), multicodec.TransportGraphsyncFilecoinv1),
+					},
+				},
+				{
+					AfterStart: 20 * time.Millisecond,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.ConnectedToProvider(startTime.Add(20*time.Millisecond), rid, types.NewRetrievalCandidate(peerA, nil, cid1), multicodec.TransportGraphsyncFilecoinv1),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_Connect, Provider: peerA, Duration: 20 * time.Millisecond},
+					},
+				},
+				{
+					AfterStart:         20*time.Millisecond + initialPause,
+					ReceivedRetrievals: []peer.ID{peerA},
+				},
+				{
+					AfterStart: 25*time.Millisecond + initialPause,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.Proposed(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1)),
+						events.Accepted(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1)),
+						events.FirstByte(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1), 5*time.Millisecond, multicodec.TransportGraphsyncFilecoinv1),
+						events.BlockReceived(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1), multicodec.TransportGraphsyncFilecoinv1, 100),
+						events.Success(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1), 1, 2, 3*time.Second, multicodec.TransportGraphsyncFilecoinv1),
+						events.Finished(startTime.Add(25*time.Millisecond+initialPause), rid, types.RetrievalCandidate{RootCid: cid1}),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_FirstByte, Provider: peerA, Duration: 5 * time.Millisecond},
+						{Type: testutil.SessionMetric_Success, Provider: peerA, Value: math.Trunc(1.0 / float64((3 * time.Second).Milliseconds()))},
+					},
+				},
+			},
+		},
+		{
+			name:        "attempt limit reached",
+			maxAttempts: 1,
+			candidates: []types.RetrievalCandidate{
+				{MinerPeer: peer.AddrInfo{ID: peerA}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+				{MinerPeer: peer.AddrInfo{ID: peerB}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+			},
+			returns_connected: map[string]testutil.DelayedConnectReturn{
+				string(peerA): {Err: nil, Delay: time.Millisecond * 20},
+				string(peerB): {Err: nil, Delay: time.Millisecond * 20},
+			},
+			returns_retrievals: map[string]testutil.DelayedClientReturn{
+				string(peerA): {ResultStats: &types.RetrievalStats{
+					StorageProviderId: peerA,
+					Size:              1,
+					Blocks:            2,
+					Duration:          3 * time.Second,
+					TotalPayment:      big.Zero(),
+					RootCid:           cid1,
+					AskPrice:          abi.NewTokenAmount(0),
+				}, Delay: time.Millisecond * 5},
+			},
+			expectedQueries: 1,
+			expectedSequence: []testutil.ExpectedActionsAtTime{
+				{
+					AfterStart: 0,
+					CandidatesDiscovered: []testutil.DiscoveredCandidate{
+						{
+							Cid:       cid1,
+							Candidate: types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peerA}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+						},
+						{
+							Cid:       cid1,
+							Candidate: types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peerB}, RootCid: cid1, Metadata: metadata.Default.New(&metadata.GraphsyncFilecoinV1{})},
+						},
+					},
+					ReceivedConnections: []peer.ID{peerA},
+					ExpectedEvents: []types.RetrievalEvent{
+						events.StartedFetch(startTime, rid, cid1, "?dag-scope=all&dups=n", multicodec.TransportGraphsyncFilecoinv1),
+						events.StartedFindingCandidates(startTime, rid, cid1),
+						events.CandidatesFound(startTime, rid, cid1, []types.RetrievalCandidate{types.NewRetrievalCandidate(peerA, nil, cid1), types.NewRetrievalCandidate(peerB, nil, cid1)}),
+						events.CandidatesFiltered(startTime, rid, cid1, []types.RetrievalCandidate{types.NewRetrievalCandidate(peerA, nil, cid1), types.NewRetrievalCandidate(peerB, nil, cid1)}),
+						events.StartedRetrieval(startTime, rid, types.NewRetrievalCandidate(peerA, nil, cid1), multicodec.TransportGraphsyncFilecoinv1),
+					},
+				},
+				{
+					AfterStart: 20 * time.Millisecond,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.ConnectedToProvider(startTime.Add(20*time.Millisecond), rid, types.NewRetrievalCandidate(peerA, nil, cid1), multicodec.TransportGraphsyncFilecoinv1),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_Connect, Provider: peerA, Duration: 20 * time.Millisecond},
+					},
+				},
+				{
+					AfterStart:         20*time.Millisecond + initialPause,
+					ReceivedRetrievals: []peer.ID{peerA},
+				},
+				{
+					AfterStart: 25*time.Millisecond + initialPause,
+					ExpectedEvents: []types.RetrievalEvent{
+						events.Proposed(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1)),
+						events.Accepted(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1)),
+						events.FirstByte(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1), 5*time.Millisecond, multicodec.TransportGraphsyncFilecoinv1),
+						events.BlockReceived(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1), multicodec.TransportGraphsyncFilecoinv1, 100),
+						events.Success(startTime.Add(25*time.Millisecond+initialPause), rid, types.NewRetrievalCandidate(peerA, nil, cid1), 1, 2, 3*time.Second, multicodec.TransportGraphsyncFilecoinv1),
+						events.Finished(startTime.Add(25*time.Millisecond+initialPause), rid, types.RetrievalCandidate{RootCid: cid1}),
+					},
+					ExpectedMetrics: []testutil.SessionMetric{
+						{Type: testutil.SessionMetric_FirstByte, Provider: peerA, Duration: 5 * time.Millisecond},
+						{Type: testutil.SessionMetric_Success, Provider: peerA, Value: math.Trunc(1.0 / float64((3 * time.Second).Milliseconds()))},
+					},
+				},
+			},
+		},
 		{
 			name: "single candidate and successful retrieval, w/ path & dups & scope",
 			candidates: []types.RetrievalCandidate{
@@ -814,7 +990,7 @@ func TestRetriever(t *testing.T) {
 			// --- create ---
 			ret, err := NewRetrieverWithClock(context.Background(), session, candidateFinder, map[multicodec.Code]types.CandidateRetriever{
 				multicodec.TransportGraphsyncFilecoinv1: gsretriever,
-			}, clock)
+			}, nil, clock)
 			require.NoError(t, err)
 
 			// --- start ---
@@ -843,6 +1019,8 @@ func TestRetriever(t *testing.T) {
 							Scope:      tc.scope,
 							Duplicates: tc.dups,
 						},
+						QueryLimits: tc.queryLimits,
+						MaxAttempts: tc.maxAttempts,
 					}, cb)
 				}},
 			)
@@ -864,6 +1042,10 @@ func TestRetriever(t *testing.T) {
 					}
 				}
 				require.Equal(t, client.GetRetrievalReturns()[successfulPeer].ResultStats, result)
+				require.Equal(t, uint64(1), result.IndexerQueries)
+				if tc.expectedQueries > 0 {
+					require.Equal(t, tc.expectedQueries, result.GraphsyncQueries)
+				}
 			} else {
 				require.ErrorIs(t, tc.err, err)
 			}
@@ -920,7 +1102,7 @@ func TestLinkSystemPerRequest(t *testing.T) {
 	// --- create ---
 	ret, err := NewRetrieverWithClock(context.Background(), session, candidateFinder, map[multicodec.Code]types.CandidateRetriever{
 		multicodec.TransportGraphsyncFilecoinv1: gsretriever,
-	}, clock)
+	}, nil, clock)
 	require.NoError(t, err)
 
 	// --- start ---
@@ -0,0 +1,138 @@
+package retriever
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+)
+
+// DefaultRetryMultiplier is the factor that the backoff between retries grows
+// by when a RetryPolicy doesn't set one.
+const DefaultRetryMultiplier = 2.0
+
+// RetryPolicy is how a failed attempt to retrieve from a candidate is retried
+// before the candidate is given up on. MaxRetries of zero, the default, makes
+// a single attempt. The first retry waits for Backoff, and each following
+// retry waits Multiplier times longer than the last, up to MaxBackoff. Jitter
+// is the fraction, from 0 to 1, by which each wait is randomly lengthened or
+// shortened, so that retrievals that failed together don't retry together.
+type RetryPolicy struct {
+	MaxRetries uint
+	Backoff    time.Duration
+	// MaxBackoff is the longest wait between retries, 0 is no limit.
+	MaxBackoff time.Duration
+	// Multiplier defaults to DefaultRetryMultiplier.
+	Multiplier float64
+	Jitter     float64
+}
+
+// Validate returns an error if the multiplier or jitter are out of range.
+func (rp RetryPolicy) Validate() error {
+	if rp.Backoff < 0 || rp.MaxBackoff < 0 {
+		return errors.New("retry backoff must not be negative")
+	}
+	if rp.Multiplier != 0 && rp.Multiplier < 1 {
+		return errors.New("retry multiplier must be at least 1")
+	}
+	if rp.Jitter < 0 || rp.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Delay returns the time to wait before the given retry, the first being 1,
+// with a random value in [0, 1) to apply the jitter with.
+func (rp RetryPolicy) Delay(retry uint, random float64) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier == 0 {
+		multiplier = DefaultRetryMultiplier
+	}
+	delay := float64(rp.Backoff)
+	for i := uint(1); i < retry; i++ {
+		if rp.MaxBackoff > 0 && delay >= float64(rp.MaxBackoff) {
+			break
+		}
+		delay *= multiplier
+	}
+	if rp.MaxBackoff > 0 && delay > float64(rp.MaxBackoff) {
+		delay = float64(rp.MaxBackoff)
+	}
+	delay *= 1 + rp.Jitter*(2*random-1)
+	return time.Duration(delay)
+}
+
+// RetryPolicies configures the retries of failed attempts to retrieve from
+// candidates over Graphsync and HTTP; Bitswap retrieves from all of its
+// candidates together and isn't retried. Default applies to every candidate,
+// Protocols overrides it for a protocol and Providers for a provider,
+// whatever the protocol.
+type RetryPolicies struct {
+	Default   RetryPolicy
+	Protocols map[multicodec.Code]RetryPolicy
+	Providers map[peer.ID]RetryPolicy
+}
+
+// Validate returns an error if any of the policies is invalid.
+func (rp RetryPolicies) Validate() error {
+	if err := rp.Default.Validate(); err != nil {
+		return err
+	}
+	for _, policy := range rp.Protocols {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, policy := range rp.Providers {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// For returns the policy for retrieving from a provider over a protocol.
+func (rp RetryPolicies) For(provider peer.ID, protocol multicodec.Code) RetryPolicy {
+	if policy, ok := rp.Providers[provider]; ok {
+		return policy
+	}
+	if policy, ok := rp.Protocols[protocol]; ok {
+		return policy
+	}
+	return rp.Default
+}
+
+type retryPoliciesKey struct{}
+
+// withRetryPolicies returns a context carrying the retry policies of a
+// retrieval to its protocol retrievers.
+func withRetryPolicies(ctx context.Context, policies RetryPolicies) context.Context {
+	return context.WithValue(ctx, retryPoliciesKey{}, policies)
+}
+
+// retryPolicyFrom returns the policy for retrieving from a provider over a
+// protocol in a retrieval, which doesn't retry if the context carries none.
+func retryPolicyFrom(ctx context.Context, provider peer.ID, protocol multicodec.Code) RetryPolicy {
+	policies, _ := ctx.Value(retryPoliciesKey{}).(RetryPolicies)
+	return policies.For(provider, protocol)
+}
+
+// waitToRetry waits for the delay before a retry, returning false if the
+// context is done first.
+func (retrieval *retrieval) waitToRetry(ctx context.Context, policy RetryPolicy, retry uint) bool {
+	delay := policy.Delay(retry, rand.Float64())
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := retrieval.parallelPeerRetriever.Clock.Timer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
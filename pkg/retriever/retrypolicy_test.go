@@ -0,0 +1,241 @@
+package retriever
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/session"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   RetryPolicy
+		retry    uint
+		random   float64
+		expected time.Duration
+	}{
+		{
+			name:     "first retry",
+			policy:   RetryPolicy{Backoff: time.Second},
+			retry:    1,
+			random:   0.5,
+			expected: time.Second,
+		},
+		{
+			name:     "doubles by default",
+			policy:   RetryPolicy{Backoff: time.Second},
+			retry:    4,
+			random:   0.5,
+			expected: 8 * time.Second,
+		},
+		{
+			name:     "multiplier",
+			policy:   RetryPolicy{Backoff: time.Second, Multiplier: 1.5},
+			retry:    3,
+			random:   0.5,
+			expected: 2250 * time.Millisecond,
+		},
+		{
+			name:     "max backoff",
+			policy:   RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second},
+			retry:    100,
+			random:   0.5,
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "shortened by jitter",
+			policy:   RetryPolicy{Backoff: time.Second, Jitter: 0.5},
+			retry:    1,
+			random:   0,
+			expected: 500 * time.Millisecond,
+		},
+		{
+			name:     "lengthened by jitter",
+			policy:   RetryPolicy{Backoff: time.Second, Jitter: 0.5},
+			retry:    1,
+			random:   0.75,
+			expected: 1250 * time.Millisecond,
+		},
+		{
+			name:     "no backoff",
+			policy:   RetryPolicy{Jitter: 1},
+			retry:    3,
+			random:   0.9,
+			expected: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, testCase.policy.Delay(testCase.retry, testCase.random))
+		})
+	}
+}
+
+func TestRetryPoliciesFor(t *testing.T) {
+	peers := testutil.GeneratePeers(t, 2)
+	policies := RetryPolicies{
+		Default:   RetryPolicy{MaxRetries: 1},
+		Protocols: map[multicodec.Code]RetryPolicy{multicodec.TransportIpfsGatewayHttp: {MaxRetries: 2}},
+		Providers: map[peer.ID]RetryPolicy{peers[0]: {MaxRetries: 3}},
+	}
+	require.Equal(t, uint(3), policies.For(peers[0], multicodec.TransportIpfsGatewayHttp).MaxRetries)
+	require.Equal(t, uint(3), policies.For(peers[0], multicodec.TransportGraphsyncFilecoinv1).MaxRetries)
+	require.Equal(t, uint(2), policies.For(peers[1], multicodec.TransportIpfsGatewayHttp).MaxRetries)
+	require.Equal(t, uint(1), policies.For(peers[1], multicodec.TransportGraphsyncFilecoinv1).MaxRetries)
+	require.Zero(t, RetryPolicies{}.For(peers[1], multicodec.TransportIpfsGatewayHttp).MaxRetries)
+}
+
+func TestRetryPoliciesValidate(t *testing.T) {
+	require.NoError(t, RetryPolicies{}.Validate())
+	require.NoError(t, RetryPolicies{Default: RetryPolicy{MaxRetries: 2, Backoff: time.Second, Multiplier: 1, Jitter: 1}}.Validate())
+	require.Error(t, RetryPolicies{Default: RetryPolicy{Jitter: 1.5}}.Validate())
+	require.Error(t, RetryPolicies{Default: RetryPolicy{Multiplier: 0.5}}.Validate())
+	require.Error(t, RetryPolicies{Protocols: map[multicodec.Code]RetryPolicy{
+		multicodec.TransportIpfsGatewayHttp: {Backoff: -time.Second},
+	}}.Validate())
+}
+
+// flakyProtocol is a TransportProtocol whose retrievals fail a number of
+// times, with err if set, before succeeding.
+type flakyProtocol struct {
+	failures int
+	err      error
+
+	lk       sync.Mutex
+	attempts int
+}
+
+func (fp *flakyProtocol) Code() multicodec.Code {
+	return multicodec.TransportIpfsGatewayHttp
+}
+
+func (fp *flakyProtocol) GetMergedMetadata(cid cid.Cid, currentMetadata, newMetadata metadata.Protocol) metadata.Protocol {
+	return newMetadata
+}
+
+func (fp *flakyProtocol) Connect(ctx context.Context, retrieval *retrieval, candidate types.RetrievalCandidate) (time.Duration, error) {
+	return 0, nil
+}
+
+func (fp *flakyProtocol) Retrieve(
+	ctx context.Context,
+	retrieval *retrieval,
+	shared *retrievalShared,
+	timeout time.Duration,
+	candidate types.RetrievalCandidate,
+) (*types.RetrievalStats, error) {
+	fp.lk.Lock()
+	defer fp.lk.Unlock()
+	fp.attempts++
+	if fp.attempts <= fp.failures {
+		if fp.err != nil {
+			return nil, fp.err
+		}
+		return nil, errors.New("flaky")
+	}
+	return &types.RetrievalStats{StorageProviderId: candidate.MinerPeer.ID, Size: 1}, nil
+}
+
+func TestRetryFailedCandidate(t *testing.T) {
+	testCases := []struct {
+		name             string
+		failures         int
+		retries          uint
+		breakerThreshold uint
+		expectedAttempts int
+		expectSuccess    bool
+	}{
+		{
+			name:             "no retries",
+			failures:         1,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "succeeds on retry",
+			failures:         2,
+			retries:          2,
+			expectedAttempts: 3,
+			expectSuccess:    true,
+		},
+		{
+			name:             "retries exhausted",
+			failures:         3,
+			retries:          2,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "not retried after circuit breaker opens",
+			failures:         3,
+			retries:          5,
+			breakerThreshold: 2,
+			expectedAttempts: 2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			cfg := session.DefaultConfig().WithCircuitBreaker(session.CircuitBreaker{Threshold: testCase.breakerThreshold, Cooldown: time.Minute})
+			sess := session.NewSession(cfg, true)
+			rid := types.RetrievalID(uuid.New())
+			c := testutil.GenerateCid()
+			candidate := testutil.GenerateRetrievalCandidatesForCID(t, 1, c, &metadata.IpfsGatewayHttp{})[0]
+			require.True(t, sess.RegisterRetrieval(rid, c, selectorparse.CommonSelector_ExploreAllRecursively))
+			require.NoError(t, sess.AddToRetrieval(rid, []peer.ID{candidate.MinerPeer.ID}))
+
+			protocol := &flakyProtocol{failures: testCase.failures}
+			ppr := &parallelPeerRetriever{Protocol: protocol, Session: sess, Clock: clock.New(), noDirtyClose: true}
+			ctx = withRetryPolicies(ctx, RetryPolicies{Default: RetryPolicy{MaxRetries: testCase.retries, Backoff: time.Millisecond, Jitter: 0.5}})
+
+			var lk sync.Mutex
+			codes := make(map[types.EventCode]int)
+			incoming, outgoing := types.MakeAsyncCandidates(1)
+			require.NoError(t, outgoing.SendNext(ctx, []types.RetrievalCandidate{candidate}))
+			close(outgoing)
+			stats, err := ppr.Retrieve(ctx, types.RetrievalRequest{
+				Request:     trustlessutils.Request{Root: c},
+				RetrievalID: rid,
+				LinkSystem:  cidlink.DefaultLinkSystem(),
+			}, func(evt types.RetrievalEvent) {
+				lk.Lock()
+				defer lk.Unlock()
+				codes[evt.Code()]++
+			}).RetrieveFromAsyncCandidates(incoming)
+
+			require.Equal(t, testCase.expectedAttempts, protocol.attempts)
+			require.Equal(t, testCase.expectedAttempts, codes[types.StartedRetrievalCode])
+			if testCase.expectSuccess {
+				require.NoError(t, err)
+				require.Equal(t, candidate.MinerPeer.ID, stats.StorageProviderId)
+				require.Equal(t, testCase.failures, codes[types.FailedRetrievalCode])
+			} else {
+				require.Error(t, err)
+				require.Equal(t, testCase.expectedAttempts, codes[types.FailedRetrievalCode])
+			}
+			if testCase.breakerThreshold > 0 {
+				require.Equal(t, 1, codes[types.CircuitBreakerOpenedCode])
+			}
+		})
+	}
+}
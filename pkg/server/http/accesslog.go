@@ -0,0 +1,140 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/accesslog"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+)
+
+type accessEntryKey struct{}
+
+// accessRecord is the access log entry of a request being served, which the
+// handlers add the details of the retrieval to.
+type accessRecord struct {
+	lk    sync.Mutex
+	entry accesslog.Entry
+}
+
+// recordAccess updates the access log entry of the request, if it is logged.
+func recordAccess(ctx context.Context, update func(entry *accesslog.Entry)) {
+	record, ok := ctx.Value(accessEntryKey{}).(*accessRecord)
+	if !ok {
+		return
+	}
+	record.lk.Lock()
+	defer record.lk.Unlock()
+	update(&record.entry)
+}
+
+// recordRetrieval adds the retrieval serving the request to its access log
+// entry.
+func recordRetrieval(ctx context.Context, request types.RetrievalRequest) {
+	recordAccess(ctx, func(entry *accesslog.Entry) {
+		entry.RetrievalID = request.RetrievalID.String()
+		entry.Root = request.Root.String()
+		entry.Path = request.Path
+		entry.Scope = string(request.Scope)
+	})
+}
+
+// accessLogSubscriber wraps a retrieval event subscriber, which may be nil, so
+// that the provider and protocol that the retrieval succeeded with are added
+// to the request's access log entry.
+func accessLogSubscriber(ctx context.Context, next types.RetrievalEventSubscriber) types.RetrievalEventSubscriber {
+	if ctx.Value(accessEntryKey{}) == nil {
+		return next
+	}
+	return func(event types.RetrievalEvent) {
+		if succeeded, ok := event.(events.SucceededEvent); ok {
+			recordAccess(ctx, func(entry *accesslog.Entry) {
+				entry.Provider = succeeded.ProviderId().String()
+				entry.Protocol = succeeded.Protocol().String()
+			})
+		}
+		if next != nil {
+			next(event)
+		}
+	}
+}
+
+// accessLogMiddleware writes an entry to the access log for each request once
+// it has been served.
+func accessLogMiddleware(next http.Handler, log *accesslog.Log) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		clientIP := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			clientIP = host
+		}
+		record := &accessRecord{entry: accesslog.Entry{
+			Time:     start,
+			ClientIP: clientIP,
+			Method:   r.Method,
+			URL:      r.URL.RequestURI(),
+		}}
+		lw := &loggedResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, record)))
+
+		record.lk.Lock()
+		entry := record.entry
+		record.lk.Unlock()
+		entry.Status = lw.status
+		if entry.Status == 0 {
+			// nothing was written, net/http responds with 200
+			entry.Status = http.StatusOK
+		}
+		entry.Bytes = lw.bytes
+		entry.Duration = time.Since(start).String()
+		if err := log.Write(entry); err != nil {
+			logger.Warnw("failed to write access log", "err", err)
+		}
+	})
+}
+
+// loggedResponseWriter records the status and size of a response for the
+// access log.
+type loggedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  uint64
+}
+
+func (w *loggedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += uint64(n)
+	return n, err
+}
+
+func (w *loggedResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack allows a logged response to be terminated early, as an /ipfs/
+// response is on a failed retrieval.
+func (w *loggedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("unable to access hijack interface")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,89 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/accesslog"
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/internal/mockfetcher"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	rawLp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.Raw),
+		MhType:   uint64(multicodec.Sha2_256),
+		MhLength: 32,
+	}}
+	node := basicnode.NewBytes([]byte("hello world"))
+	lsys := cidlink.DefaultLinkSystem()
+	link, err := lsys.ComputeLink(rawLp, node)
+	require.NoError(t, err)
+	root := link.(cidlink.Link).Cid
+	provider := peer.ID("provider")
+
+	fetcher := mockfetcher.NewMockFetcher()
+	fetcher.FetchFunc = func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+		cb(events.Success(time.Now(), r.RetrievalID, types.NewRetrievalCandidate(provider, nil, r.Root), 11, 1, time.Millisecond, multicodec.TransportBitswap))
+		if _, err := r.LinkSystem.Store(linking.LinkContext{}, rawLp, node); err != nil {
+			return nil, err
+		}
+		return &types.RetrievalStats{RootCid: r.Root, Size: 11, Blocks: 1}, nil
+	}
+
+	var buf bytes.Buffer
+	handler := accessLogMiddleware(http.HandlerFunc(IpfsHandler(fetcher, HttpServerConfig{TempDir: t.TempDir()})), accesslog.New(&buf))
+	serve := func(method string, path string) (*httptest.ResponseRecorder, accesslog.Entry) {
+		buf.Reset()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Accept", "application/vnd.ipld.car")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var entry accesslog.Entry
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		require.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("\n")))
+		return rr, entry
+	}
+
+	path := "/ipfs/" + root.String() + "?dag-scope=block"
+	rr, entry := serve(http.MethodGet, path)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "192.0.2.1", entry.ClientIP)
+	require.Equal(t, http.MethodGet, entry.Method)
+	require.Equal(t, path, entry.URL)
+	require.Equal(t, http.StatusOK, entry.Status)
+	require.Equal(t, uint64(rr.Body.Len()), entry.Bytes)
+	require.NotEmpty(t, entry.Duration)
+	require.WithinDuration(t, time.Now(), entry.Time, time.Minute)
+	require.Equal(t, rr.Header().Get(HeaderRetrievalID), entry.RetrievalID)
+	require.Equal(t, root.String(), entry.Root)
+	require.Empty(t, entry.Path)
+	require.Equal(t, "block", entry.Scope)
+	require.Equal(t, provider.String(), entry.Provider)
+	require.Equal(t, multicodec.TransportBitswap.String(), entry.Protocol)
+	require.Empty(t, entry.Cache)
+
+	// a request that's turned away has no retrieval
+	rr, entry = serve(http.MethodPost, path)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	require.Equal(t, http.StatusMethodNotAllowed, entry.Status)
+	require.Equal(t, uint64(rr.Body.Len()), entry.Bytes)
+	require.Empty(t, entry.RetrievalID)
+	require.Empty(t, entry.Root)
+	require.Empty(t, entry.Provider)
+}
@@ -0,0 +1,357 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/responsecache"
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/exp/slices"
+)
+
+const adminRetrievalsPath = "/admin/retrievals"
+
+// AdminRetrievalsHandler returns a handler for administering the retrievals in
+// progress. A GET of /admin/retrievals responds with a JSON array of the
+// active retrievals, oldest first, and a DELETE of
+// /admin/retrievals/{retrievalId} cancels a retrieval, responding with 204 if
+// it was cancelled or 404 if there is no such retrieval in progress.
+func AdminRetrievalsHandler(l *lassie.Lassie) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		id := strings.Trim(strings.TrimPrefix(req.URL.Path, adminRetrievalsPath), "/")
+		if id == "" {
+			if !checkGet(req, res, statusLogger) {
+				return
+			}
+			res.Header().Set("Content-Type", "application/json")
+			res.Header().Set("Cache-Control", "no-store")
+			res.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(res).Encode(l.ActiveRetrievals()); err != nil {
+				logger.Debugw("failed to write active retrievals response", "err", err)
+			}
+			statusLogger.logStatus(http.StatusOK, "OK")
+			return
+		}
+
+		if req.Method != http.MethodDelete {
+			res.Header().Add("Allow", http.MethodDelete)
+			errorResponse(res, statusLogger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		var retrievalID types.RetrievalID
+		if err := retrievalID.UnmarshalText([]byte(id)); err != nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, errors.New("invalid retrieval ID"))
+			return
+		}
+		if !l.Cancel(retrievalID) {
+			errorResponse(res, statusLogger, http.StatusNotFound, errors.New("no such retrieval in progress"))
+			return
+		}
+		logger.Infow("cancelled retrieval", logging.RetrievalIDKey, retrievalID)
+		res.WriteHeader(http.StatusNoContent)
+		statusLogger.logStatus(http.StatusNoContent, "Cancelled")
+	}
+}
+
+const adminProtocolsPath = "/admin/protocols"
+
+// adminProtocolRequest is the body of a PUT of /admin/protocols/{protocol}.
+type adminProtocolRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// AdminProtocolsHandler returns a handler for enabling and disabling protocols
+// at runtime. A GET of /admin/protocols responds with the JSON of
+// lassie.Protocols, and a PUT of /admin/protocols/{protocol}, named as in the
+// protocols query parameter, with a body of {"enabled": false} disables the
+// protocol for new retrievals until a body of {"enabled": true} enables it
+// again, responding with the updated states. A protocol the Lassie instance
+// wasn't configured with is responded to with 404.
+func AdminProtocolsHandler(l *lassie.Lassie) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		name := strings.Trim(strings.TrimPrefix(req.URL.Path, adminProtocolsPath), "/")
+		if name == "" {
+			if !checkGet(req, res, statusLogger) {
+				return
+			}
+			writeProtocols(res, statusLogger, l)
+			return
+		}
+
+		if req.Method != http.MethodPut {
+			res.Header().Add("Allow", http.MethodPut)
+			errorResponse(res, statusLogger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		protocols, err := types.ParseProtocolsString(name)
+		if err != nil || len(protocols) != 1 {
+			errorResponse(res, statusLogger, http.StatusBadRequest, fmt.Errorf("invalid protocol %q", name))
+			return
+		}
+		var body adminProtocolRequest
+		if err := json.NewDecoder(io.LimitReader(req.Body, 1<<10)).Decode(&body); err != nil || body.Enabled == nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, errors.New(`invalid body, expected {"enabled": true} or {"enabled": false}`))
+			return
+		}
+		if *body.Enabled {
+			err = l.EnableProtocol(protocols[0])
+		} else {
+			err = l.DisableProtocol(protocols[0])
+		}
+		if errors.Is(err, retriever.ErrProtocolNotConfigured) {
+			errorResponse(res, statusLogger, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			errorResponse(res, statusLogger, http.StatusInternalServerError, err)
+			return
+		}
+		writeProtocols(res, statusLogger, l)
+	}
+}
+
+func writeProtocols(res http.ResponseWriter, statusLogger *statusLogger, l *lassie.Lassie) {
+	writeAdminJSON(res, statusLogger, l.Protocols())
+}
+
+// adminSessionPath serves SessionStateHandler alongside the other admin
+// endpoints, for when they're served on a listener of their own.
+const adminSessionPath = "/admin/session"
+
+const adminCachesPath = "/admin/caches"
+
+// adminCacheState describes a cache in the responses of /admin/caches.
+type adminCacheState struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`
+	Size    uint64 `json:"size,omitempty"`
+}
+
+// adminCacheImport is the response to a POST of
+// /admin/caches/blocks/import.
+type adminCacheImport struct {
+	Imported int `json:"imported"`
+	adminCacheState
+}
+
+type flushableCache struct {
+	name  string
+	len   func() int
+	size  func() uint64
+	clear func() int
+}
+
+// AdminCachesHandler returns a handler for flushing the server's caches, the
+// "responses" of the ResponseCache, the "blocks" of the Lassie instance's
+// block cache and the "ipns" resolutions of the IpnsResolver, any of which may
+// be nil if it isn't configured. A GET of /admin/caches responds with a JSON
+// array of the caches, with the number of entries in each and, for responses
+// and blocks, their total size, and a DELETE of
+// /admin/caches/{name} empties the cache, responding with its state before it
+// was flushed. A cache that isn't configured is responded to with 404.
+//
+// The block cache can also be copied to another instance. A GET of
+// /admin/caches/blocks/export responds with a CAR bundle of every cached
+// block, or of the cached blocks of the DAGs of the roots given with "root"
+// query parameters, see blockcache.Cache.Export, and a POST of
+// /admin/caches/blocks/import adds the blocks of the CAR in the body to the
+// cache, responding with the number of blocks imported and the cache's state.
+func AdminCachesHandler(responseCache *responsecache.Cache, blockCache *blockcache.Cache, ipnsCache *ipnsresolver.CachingResolver) func(http.ResponseWriter, *http.Request) {
+	var caches []flushableCache
+	if responseCache != nil {
+		caches = append(caches, flushableCache{"responses", responseCache.Len, responseCache.Size, responseCache.Clear})
+	}
+	if blockCache != nil {
+		caches = append(caches, flushableCache{"blocks", blockCache.Len, blockCache.Size, blockCache.Clear})
+	}
+	if ipnsCache != nil {
+		caches = append(caches, flushableCache{"ipns", ipnsCache.Len, func() uint64 { return 0 }, ipnsCache.Clear})
+	}
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		name := strings.Trim(strings.TrimPrefix(req.URL.Path, adminCachesPath), "/")
+		if name == "" {
+			if !checkGet(req, res, statusLogger) {
+				return
+			}
+			states := make([]adminCacheState, 0, len(caches))
+			for _, cache := range caches {
+				states = append(states, adminCacheState{Name: cache.name, Entries: cache.len(), Size: cache.size()})
+			}
+			writeAdminJSON(res, statusLogger, states)
+			return
+		}
+
+		switch name {
+		case "blocks/export", "blocks/import":
+			if blockCache == nil {
+				errorResponse(res, statusLogger, http.StatusNotFound, errors.New("no such cache \"blocks\""))
+				return
+			}
+			if name == "blocks/export" {
+				exportBlockCache(res, req, statusLogger, blockCache)
+			} else {
+				importBlockCache(res, req, statusLogger, blockCache)
+			}
+			return
+		}
+
+		if req.Method != http.MethodDelete {
+			res.Header().Add("Allow", http.MethodDelete)
+			errorResponse(res, statusLogger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		for _, cache := range caches {
+			if cache.name == name {
+				size := cache.size()
+				flushed := cache.clear()
+				logger.Infow("flushed cache", "cache", name, "entries", flushed)
+				writeAdminJSON(res, statusLogger, adminCacheState{Name: name, Entries: flushed, Size: size})
+				return
+			}
+		}
+		errorResponse(res, statusLogger, http.StatusNotFound, fmt.Errorf("no such cache %q", name))
+	}
+}
+
+func exportBlockCache(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger, blockCache *blockcache.Cache) {
+	if !checkGet(req, res, statusLogger) {
+		return
+	}
+	var roots []cid.Cid
+	for _, root := range req.URL.Query()["root"] {
+		c, err := cid.Parse(root)
+		if err != nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, fmt.Errorf("invalid root %q: %w", root, err))
+			return
+		}
+		roots = append(roots, c)
+	}
+	res.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	res.Header().Set("Content-Disposition", `attachment; filename="blocks.car"`)
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusOK)
+	// the status is sent with the first block, so a failure part way through
+	// can only be logged, leaving the bundle truncated
+	exported, err := blockCache.Export(req.Context(), res, roots...)
+	if err != nil {
+		logger.Warnw("failed to export block cache", "exported", exported, "err", err)
+	}
+	logger.Infow("exported block cache", "roots", roots, "blocks", exported)
+	statusLogger.logStatus(http.StatusOK, "OK")
+}
+
+func importBlockCache(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger, blockCache *blockcache.Cache) {
+	if req.Method != http.MethodPost {
+		res.Header().Add("Allow", http.MethodPost)
+		errorResponse(res, statusLogger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	imported, err := blockCache.Import(req.Context(), req.Body)
+	if errors.Is(err, blockcache.ErrInvalidCar) {
+		errorResponse(res, statusLogger, http.StatusBadRequest, fmt.Errorf("imported %d block(s): %w", imported, err))
+		return
+	} else if err != nil {
+		errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("imported %d block(s): %w", imported, err))
+		return
+	}
+	logger.Infow("imported block cache", "blocks", imported)
+	writeAdminJSON(res, statusLogger, adminCacheImport{
+		Imported:        imported,
+		adminCacheState: adminCacheState{Name: "blocks", Entries: blockCache.Len(), Size: blockCache.Size()},
+	})
+}
+
+const adminLogLevelsPath = "/admin/log-levels"
+
+// adminLogLevel describes the level of a logging subsystem in the responses of
+// /admin/log-levels, and is the body of a PUT of
+// /admin/log-levels/{subsystem}.
+type adminLogLevel struct {
+	Subsystem string `json:"subsystem,omitempty"`
+	Level     string `json:"level,omitempty"`
+}
+
+// AdminLogLevelsHandler returns a handler for adjusting log levels at runtime.
+// A GET of /admin/log-levels responds with a JSON array of the logging
+// subsystems, with the level of those whose level has been set, and a PUT of
+// /admin/log-levels/{subsystem}, e.g. /admin/log-levels/lassie/retriever, with
+// a body of {"level": "debug"} sets the subsystem's level, see
+// logging.SetLevel, responding with the updated levels. A subsystem that
+// doesn't exist is responded to with 404.
+func AdminLogLevelsHandler() func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		subsystem := strings.Trim(strings.TrimPrefix(req.URL.Path, adminLogLevelsPath), "/")
+		if subsystem == "" {
+			if !checkGet(req, res, statusLogger) {
+				return
+			}
+			writeLogLevels(res, statusLogger)
+			return
+		}
+
+		if req.Method != http.MethodPut {
+			res.Header().Add("Allow", http.MethodPut)
+			errorResponse(res, statusLogger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		var body adminLogLevel
+		if err := json.NewDecoder(io.LimitReader(req.Body, 1<<10)).Decode(&body); err != nil || body.Level == "" {
+			errorResponse(res, statusLogger, http.StatusBadRequest, errors.New(`invalid body, expected {"level": "debug|info|warn|error"}`))
+			return
+		}
+		level, err := logging.ParseLevel(body.Level)
+		if err != nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, err)
+			return
+		}
+		if !slices.Contains(logging.Subsystems(), subsystem) {
+			errorResponse(res, statusLogger, http.StatusNotFound, fmt.Errorf("no such logging subsystem %q", subsystem))
+			return
+		}
+		logging.SetLevel(subsystem, level)
+		logger.Infow("set log level", "subsystem", subsystem, "level", level)
+		writeLogLevels(res, statusLogger)
+	}
+}
+
+func writeLogLevels(res http.ResponseWriter, statusLogger *statusLogger) {
+	levels := logging.Levels()
+	subsystems := logging.Subsystems()
+	states := make([]adminLogLevel, 0, len(subsystems))
+	for _, subsystem := range subsystems {
+		state := adminLogLevel{Subsystem: subsystem}
+		if level, ok := levels[subsystem]; ok {
+			state.Level = level.String()
+		}
+		states = append(states, state)
+	}
+	writeAdminJSON(res, statusLogger, states)
+}
+
+func writeAdminJSON(res http.ResponseWriter, statusLogger *statusLogger, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		logger.Debugw("failed to write admin response", "err", err)
+	}
+	statusLogger.logStatus(http.StatusOK, "OK")
+}
@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/blockcache"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminCachesHandlerBlockBundles(t *testing.T) {
+	blk := func(data string) cid.Cid {
+		c, err := cid.V1Builder{Codec: uint64(multicodec.Raw), MhType: multihash.SHA2_256}.Sum([]byte(data))
+		require.NoError(t, err)
+		return c
+	}
+	source, err := blockcache.New(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	a, b := blk("aaaa"), blk("bbbb")
+	require.NoError(t, source.Put(a, []byte("aaaa")))
+	require.NoError(t, source.Put(b, []byte("bbbb")))
+	target, err := blockcache.New(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+
+	serve := func(blockCache *blockcache.Cache, method string, path string, body io.Reader) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(AdminCachesHandler(nil, blockCache, nil)).ServeHTTP(rr, httptest.NewRequest(method, path, body))
+		return rr
+	}
+
+	// every block
+	rr := serve(source, http.MethodGet, adminCachesPath+"/blocks/export", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "application/vnd.ipld.car; version=1", rr.Header().Get("Content-Type"))
+	rr = serve(target, http.MethodPost, adminCachesPath+"/blocks/import", rr.Body)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var imported adminCacheImport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &imported))
+	require.Equal(t, adminCacheImport{Imported: 2, adminCacheState: adminCacheState{Name: "blocks", Entries: 2, Size: 8}}, imported)
+	require.True(t, target.Has(a))
+	require.True(t, target.Has(b))
+
+	// the DAG of a root
+	target.Clear()
+	rr = serve(source, http.MethodGet, adminCachesPath+"/blocks/export?root="+b.String(), nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serve(target, http.MethodPost, adminCachesPath+"/blocks/import", rr.Body)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.False(t, target.Has(a))
+	require.True(t, target.Has(b))
+
+	rr = serve(source, http.MethodGet, adminCachesPath+"/blocks/export?root=nope", nil)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = serve(source, http.MethodPost, adminCachesPath+"/blocks/export", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	rr = serve(target, http.MethodGet, adminCachesPath+"/blocks/import", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	rr = serve(target, http.MethodPost, adminCachesPath+"/blocks/import", strings.NewReader("not a car"))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = serve(nil, http.MethodGet, adminCachesPath+"/blocks/export", nil)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,373 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/accesslog"
+)
+
+// APIKey is a key that a tenant of a shared server authorizes with, using the
+// Bearer scheme, along with the limits on the tenant's use of the server.
+type APIKey struct {
+	// Name identifies the tenant in the usage reported by /admin/api-keys and
+	// in the access log, so that the key itself is never revealed.
+	Name string
+	Key  string
+	// RateLimit, if set, limits the requests and response bandwidth of the
+	// key in place of RateLimits.Token.
+	RateLimit RateLimit
+	// Quota caps the requests and egress of the key in each period.
+	Quota Quota
+}
+
+// Quota caps the use of an APIKey. A request made once the key has used its
+// Requests or EgressBytes in the current Period is responded to with 429 and
+// a Retry-After header for the start of the next period, or without one if
+// there is no Period, in which case the quota lasts until the key's usage is
+// reset with the admin API. Zero means no cap. A response in progress isn't
+// cut off when the key goes over its EgressBytes.
+type Quota struct {
+	Requests    uint64
+	EgressBytes uint64
+	Period      time.Duration
+}
+
+// apiKeyJSON is the JSON of an APIKey, see ParseAPIKeys.
+type apiKeyJSON struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	RateLimit RateLimit `json:"rateLimit"`
+	Quota     struct {
+		Requests    uint64 `json:"requests,omitempty"`
+		EgressBytes uint64 `json:"egressBytes,omitempty"`
+		Period      string `json:"period,omitempty"`
+	} `json:"quota"`
+}
+
+// ParseAPIKeys parses the APIKeys of a server's tenants from JSON, such as:
+//
+//	[
+//	  {
+//	    "name": "acme",
+//	    "key": "acme-secret-key",
+//	    "rateLimit": {"requestsPerSecond": 10, "bytesPerSecond": 10485760},
+//	    "quota": {"requests": 100000, "egressBytes": 1099511627776, "period": "24h"}
+//	  }
+//	]
+//
+// Every key must have a name and a key, each unique.
+func ParseAPIKeys(r io.Reader) ([]APIKey, error) {
+	var parsed []apiKeyJSON
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("invalid API keys: %w", err)
+	}
+	keys := make([]APIKey, 0, len(parsed))
+	names := make(map[string]struct{}, len(parsed))
+	secrets := make(map[string]struct{}, len(parsed))
+	for _, p := range parsed {
+		if p.Name == "" || p.Key == "" {
+			return nil, errors.New("invalid API keys: every key needs a name and a key")
+		}
+		if _, ok := names[p.Name]; ok {
+			return nil, fmt.Errorf("invalid API keys: duplicate name %q", p.Name)
+		}
+		if _, ok := secrets[p.Key]; ok {
+			return nil, fmt.Errorf("invalid API keys: %s has the key of another", p.Name)
+		}
+		names[p.Name], secrets[p.Key] = struct{}{}, struct{}{}
+		limit := p.RateLimit
+		if limit.RequestsPerSecond < 0 || limit.RequestBurst < 0 || limit.BytesPerSecond < 0 || limit.ByteBurst < 0 {
+			return nil, fmt.Errorf("invalid API keys: the rates and bursts of %s can't be negative", p.Name)
+		}
+		key := APIKey{
+			Name:      p.Name,
+			Key:       p.Key,
+			RateLimit: limit,
+			Quota:     Quota{Requests: p.Quota.Requests, EgressBytes: p.Quota.EgressBytes},
+		}
+		if p.Quota.Period != "" {
+			period, err := time.ParseDuration(p.Quota.Period)
+			if err != nil || period <= 0 {
+				return nil, fmt.Errorf("invalid API keys: invalid quota period %q of %s", p.Quota.Period, p.Name)
+			}
+			key.Quota.Period = period
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// withAPIKeys returns the limits with the rate limits of the API keys that
+// have their own.
+func (limits RateLimits) withAPIKeys(keys []APIKey) RateLimits {
+	if len(keys) == 0 {
+		return limits
+	}
+	tokens := make(map[string]RateLimit, len(limits.Tokens)+len(keys))
+	for token, limit := range limits.Tokens {
+		tokens[token] = limit
+	}
+	for _, key := range keys {
+		if key.RateLimit != (RateLimit{}) {
+			tokens[key.Key] = key.RateLimit
+		}
+	}
+	limits.Tokens = tokens
+	return limits
+}
+
+// APIKeyUsage is the usage of an APIKey, as reported by /admin/api-keys.
+type APIKeyUsage struct {
+	Name string `json:"name"`
+	// Requests and EgressBytes are the usage of the current quota period,
+	// which started at PeriodStart and, if the quota has a period, ends at
+	// PeriodEnd.
+	Requests    uint64     `json:"requests"`
+	EgressBytes uint64     `json:"egressBytes"`
+	PeriodStart time.Time  `json:"periodStart"`
+	PeriodEnd   *time.Time `json:"periodEnd,omitempty"`
+	// QuotaRequests and QuotaEgressBytes are the key's quota, zero if it has
+	// none.
+	QuotaRequests    uint64 `json:"quotaRequests,omitempty"`
+	QuotaEgressBytes uint64 `json:"quotaEgressBytes,omitempty"`
+	// TotalRequests and TotalEgressBytes are the usage since the server
+	// started.
+	TotalRequests    uint64 `json:"totalRequests"`
+	TotalEgressBytes uint64 `json:"totalEgressBytes"`
+}
+
+// tenant accounts for the usage of an APIKey.
+type tenant struct {
+	key   APIKey
+	clock clock.Clock
+
+	lk               sync.Mutex
+	periodStart      time.Time
+	requests         uint64
+	egressBytes      uint64
+	totalRequests    uint64
+	totalEgressBytes uint64
+}
+
+// roll starts a new quota period if the current one has ended.
+func (t *tenant) roll(now time.Time) {
+	if period := t.key.Quota.Period; period > 0 && now.Sub(t.periodStart) >= period {
+		t.periodStart = t.periodStart.Add(now.Sub(t.periodStart) / period * period)
+		t.requests, t.egressBytes = 0, 0
+	}
+}
+
+// begin counts a request, unless the key has used its quota, in which case it
+// returns false and how long until the next period, zero if there's none.
+func (t *tenant) begin() (bool, time.Duration) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	now := t.clock.Now()
+	t.roll(now)
+	quota := t.key.Quota
+	if (quota.Requests > 0 && t.requests >= quota.Requests) || (quota.EgressBytes > 0 && t.egressBytes >= quota.EgressBytes) {
+		if quota.Period == 0 {
+			return false, 0
+		}
+		return false, t.periodStart.Add(quota.Period).Sub(now)
+	}
+	t.requests++
+	t.totalRequests++
+	return true, 0
+}
+
+func (t *tenant) addEgress(n int) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.roll(t.clock.Now())
+	t.egressBytes += uint64(n)
+	t.totalEgressBytes += uint64(n)
+}
+
+func (t *tenant) usage() APIKeyUsage {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.roll(t.clock.Now())
+	usage := APIKeyUsage{
+		Name:             t.key.Name,
+		Requests:         t.requests,
+		EgressBytes:      t.egressBytes,
+		PeriodStart:      t.periodStart,
+		QuotaRequests:    t.key.Quota.Requests,
+		QuotaEgressBytes: t.key.Quota.EgressBytes,
+		TotalRequests:    t.totalRequests,
+		TotalEgressBytes: t.totalEgressBytes,
+	}
+	if t.key.Quota.Period > 0 {
+		end := t.periodStart.Add(t.key.Quota.Period)
+		usage.PeriodEnd = &end
+	}
+	return usage
+}
+
+// reset starts a new quota period, returning the usage before the reset.
+func (t *tenant) reset() APIKeyUsage {
+	usage := t.usage()
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.periodStart = t.clock.Now()
+	t.requests, t.egressBytes = 0, 0
+	return usage
+}
+
+// apiKeys holds the tenants of a server, by key and in the order they were
+// configured.
+type apiKeys struct {
+	byKey   map[string]*tenant
+	tenants []*tenant
+}
+
+func newAPIKeys(keys []APIKey, clock clock.Clock) *apiKeys {
+	ak := &apiKeys{byKey: make(map[string]*tenant, len(keys))}
+	now := clock.Now()
+	for _, key := range keys {
+		t := &tenant{key: key, clock: clock, periodStart: now}
+		ak.byKey[key.Key] = t
+		ak.tenants = append(ak.tenants, t)
+	}
+	return ak
+}
+
+// tenantOf returns the tenant whose key the request authorizes with, if any.
+func (ak *apiKeys) tenantOf(r *http.Request) (*tenant, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, false
+	}
+	for key, t := range ak.byKey {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func (ak *apiKeys) named(name string) (*tenant, bool) {
+	for _, t := range ak.tenants {
+		if t.key.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// apiKeyMiddleware accounts for the requests and egress of the API keys that
+// requests authorize with, responding with 429 to those made once a key has
+// used its quota. The health endpoints aren't counted.
+func apiKeyMiddleware(next http.Handler, keys *apiKeys) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, ok := keys.tenantOf(r)
+		if !ok || isHealthPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recordAccess(r.Context(), func(entry *accesslog.Entry) {
+			entry.APIKey = t.key.Name
+		})
+		if ok, retryAfter := t.begin(); !ok {
+			logger.Debugw("request over quota", "path", r.URL.Path, "api_key", t.key.Name)
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, "Quota Exceeded")
+			return
+		}
+		next.ServeHTTP(&meteredResponseWriter{ResponseWriter: w, tenant: t}, r)
+	})
+}
+
+// meteredResponseWriter counts the bytes of a response towards the egress of
+// the tenant it is for.
+type meteredResponseWriter struct {
+	http.ResponseWriter
+	tenant *tenant
+}
+
+func (w *meteredResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.tenant.addEgress(n)
+	return n, err
+}
+
+func (w *meteredResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack allows a metered response to be terminated early, as an /ipfs/
+// response is on a failed retrieval.
+func (w *meteredResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("unable to access hijack interface")
+	}
+	return hijacker.Hijack()
+}
+
+const adminAPIKeysPath = "/admin/api-keys"
+
+// adminAPIKeysHandler returns a handler for the usage of API keys. A GET of
+// /admin/api-keys responds with a JSON array of the APIKeyUsage of each key,
+// a GET of /admin/api-keys/{name} with that of a single key, and a DELETE of
+// /admin/api-keys/{name} resets the key's usage, starting a new quota period,
+// responding with its usage before it was reset. A key that isn't configured
+// is responded to with 404.
+func adminAPIKeysHandler(keys *apiKeys) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		name := strings.Trim(strings.TrimPrefix(req.URL.Path, adminAPIKeysPath), "/")
+		if name == "" {
+			if !checkGet(req, res, statusLogger) {
+				return
+			}
+			usages := make([]APIKeyUsage, 0, len(keys.tenants))
+			for _, t := range keys.tenants {
+				usages = append(usages, t.usage())
+			}
+			writeAdminJSON(res, statusLogger, usages)
+			return
+		}
+
+		if req.Method != http.MethodGet && req.Method != http.MethodDelete {
+			res.Header().Add("Allow", http.MethodGet)
+			res.Header().Add("Allow", http.MethodDelete)
+			errorResponse(res, statusLogger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		t, ok := keys.named(name)
+		if !ok {
+			errorResponse(res, statusLogger, http.StatusNotFound, fmt.Errorf("no such API key %q", name))
+			return
+		}
+		if req.Method == http.MethodGet {
+			writeAdminJSON(res, statusLogger, t.usage())
+			return
+		}
+		usage := t.reset()
+		logger.Infow("reset API key usage", "api_key", name, "requests", usage.Requests, "egress_bytes", usage.EgressBytes)
+		writeAdminJSON(res, statusLogger, usage)
+	}
+}
@@ -0,0 +1,153 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys(strings.NewReader(`[
+		{"name": "acme", "key": "acme-key", "rateLimit": {"requestsPerSecond": 10}, "quota": {"requests": 1000, "egressBytes": 1048576, "period": "24h"}},
+		{"name": "internal", "key": "internal-key"}
+	]`))
+	require.NoError(t, err)
+	require.Equal(t, []APIKey{
+		{Name: "acme", Key: "acme-key", RateLimit: RateLimit{RequestsPerSecond: 10}, Quota: Quota{Requests: 1000, EgressBytes: 1048576, Period: 24 * time.Hour}},
+		{Name: "internal", Key: "internal-key"},
+	}, keys)
+
+	// keys with rate limits of their own override the token limit
+	limits := RateLimits{Token: RateLimit{RequestsPerSecond: 1}, Tokens: map[string]RateLimit{"trusted": {}}}
+	require.Equal(t, RateLimits{
+		Token:  RateLimit{RequestsPerSecond: 1},
+		Tokens: map[string]RateLimit{"trusted": {}, "acme-key": {RequestsPerSecond: 10}},
+	}, limits.withAPIKeys(keys))
+	require.Len(t, limits.Tokens, 1)
+
+	for input, wantErr := range map[string]string{
+		`[{"name": "acme", "key": "acme-key", "quota": {"bytes": 5}}]`: "unknown field",
+		`[{"name": "acme"}]`: "needs a name and a key",
+		`[{"name": "acme", "key": "one"}, {"name": "acme", "key": "two"}]`:              "duplicate name",
+		`[{"name": "acme", "key": "one"}, {"name": "other", "key": "one"}]`:             "other has the key of another",
+		`[{"name": "acme", "key": "one", "rateLimit": {"requestsPerSecond": -1}}]`:      "can't be negative",
+		`[{"name": "acme", "key": "one", "quota": {"requests": 5, "period": "daily"}}]`: "invalid quota period",
+		`[{"name": "acme", "key": "one", "quota": {"requests": 5, "period": "-1h"}}]`:   "invalid quota period",
+	} {
+		_, err := ParseAPIKeys(strings.NewReader(input))
+		require.ErrorContains(t, err, wantErr, input)
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	clk := clock.NewMock()
+	keys := newAPIKeys([]APIKey{
+		{Name: "requests", Key: "requests-key", Quota: Quota{Requests: 2, Period: time.Hour}},
+		{Name: "egress", Key: "egress-key", Quota: Quota{EgressBytes: 10}},
+	}, clk)
+	handler := apiKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789ab"))
+	}), keys)
+	serve := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a request quota, renewed every period
+	require.Equal(t, http.StatusOK, serve("/ipfs/bafkqaaa", "requests-key").Code)
+	clk.Add(15 * time.Minute)
+	require.Equal(t, http.StatusOK, serve("/ipfs/bafkqaaa", "requests-key").Code)
+	rr := serve("/ipfs/bafkqaaa", "requests-key")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "2700", rr.Header().Get("Retry-After"))
+	// the health endpoints and other clients aren't counted
+	require.Equal(t, http.StatusOK, serve("/healthz", "requests-key").Code)
+	require.Equal(t, http.StatusOK, serve("/ipfs/bafkqaaa", "").Code)
+	require.Equal(t, http.StatusOK, serve("/ipfs/bafkqaaa", "other-key").Code)
+	clk.Add(time.Hour)
+	require.Equal(t, http.StatusOK, serve("/ipfs/bafkqaaa", "requests-key").Code)
+
+	usage := keys.byKey["requests-key"].usage()
+	require.Equal(t, uint64(1), usage.Requests)
+	require.Equal(t, uint64(12), usage.EgressBytes)
+	require.Equal(t, uint64(3), usage.TotalRequests)
+	require.Equal(t, uint64(36), usage.TotalEgressBytes)
+	require.Equal(t, clk.Now().Add(-15*time.Minute), usage.PeriodStart)
+	require.Equal(t, clk.Now().Add(45*time.Minute), *usage.PeriodEnd)
+
+	// an egress quota without a period lasts until it's reset, the response
+	// that goes over it isn't cut off
+	rr = serve("/ipfs/bafkqaaa", "egress-key")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "0123456789ab", rr.Body.String())
+	rr = serve("/ipfs/bafkqaaa", "egress-key")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Empty(t, rr.Header().Get("Retry-After"))
+	clk.Add(24 * time.Hour)
+	require.Equal(t, http.StatusTooManyRequests, serve("/ipfs/bafkqaaa", "egress-key").Code)
+	keys.byKey["egress-key"].reset()
+	require.Equal(t, http.StatusOK, serve("/ipfs/bafkqaaa", "egress-key").Code)
+}
+
+func TestAdminAPIKeysHandler(t *testing.T) {
+	clk := clock.NewMock()
+	keys := newAPIKeys([]APIKey{
+		{Name: "acme", Key: "acme-key", Quota: Quota{Requests: 100}},
+		{Name: "internal", Key: "internal-key"},
+	}, clk)
+	ok, _ := keys.byKey["acme-key"].begin()
+	require.True(t, ok)
+	keys.byKey["acme-key"].addEgress(1024)
+	handler := http.HandlerFunc(adminAPIKeysHandler(keys))
+	serve := func(method string, path string) (*httptest.ResponseRecorder, interface{}) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		var body interface{}
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		}
+		return rr, body
+	}
+
+	rr, body := serve(http.MethodGet, adminAPIKeysPath)
+	require.Equal(t, http.StatusOK, rr.Code)
+	usages := body.([]interface{})
+	require.Len(t, usages, 2)
+	require.Equal(t, "acme", usages[0].(map[string]interface{})["name"])
+	require.Equal(t, float64(1), usages[0].(map[string]interface{})["requests"])
+	require.Equal(t, float64(1024), usages[0].(map[string]interface{})["egressBytes"])
+	require.Equal(t, float64(100), usages[0].(map[string]interface{})["quotaRequests"])
+	require.Equal(t, "internal", usages[1].(map[string]interface{})["name"])
+	require.NotContains(t, rr.Body.String(), "acme-key")
+
+	rr, body = serve(http.MethodGet, adminAPIKeysPath+"/internal")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, float64(0), body.(map[string]interface{})["requests"])
+
+	// a reset responds with the usage before it
+	rr, body = serve(http.MethodDelete, adminAPIKeysPath+"/acme")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, float64(1), body.(map[string]interface{})["requests"])
+	usage := keys.byKey["acme-key"].usage()
+	require.Zero(t, usage.Requests)
+	require.Zero(t, usage.EgressBytes)
+	require.Equal(t, uint64(1), usage.TotalRequests)
+
+	rr, _ = serve(http.MethodDelete, adminAPIKeysPath+"/nobody")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	rr, _ = serve(http.MethodPost, adminAPIKeysPath+"/acme")
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	rr, _ = serve(http.MethodDelete, adminAPIKeysPath)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
@@ -0,0 +1,304 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTConfig configures the validation of JSON Web Tokens presented by clients
+// with the Bearer scheme, see HttpServerConfig.JWT. A token is accepted if its
+// signature verifies with the Secret or PublicKey, it isn't expired or not yet
+// valid, and it has each of the configured claims.
+type JWTConfig struct {
+	// Secret verifies tokens signed with HMAC, the HS256, HS384 and HS512
+	// algorithms.
+	Secret []byte
+	// PublicKey verifies tokens signed with the matching private key: an
+	// *rsa.PublicKey for RS256, RS384, RS512, PS256, PS384 and PS512, an
+	// *ecdsa.PublicKey for ES256, ES384 and ES512, or an ed25519.PublicKey for
+	// EdDSA. See ParseJWTPublicKey.
+	PublicKey crypto.PublicKey
+	// Issuer, if set, must equal the token's "iss" claim.
+	Issuer string
+	// Audience, if set, must equal the token's "aud" claim or be one of them.
+	Audience string
+	// Claims are other claims the token must have, each either equal to the
+	// given value or an array containing it, e.g. {"scope": "retrieve"}.
+	Claims map[string]string
+	// Leeway allows for clock skew between the token's issuer and the server
+	// when checking the "exp" and "nbf" claims.
+	Leeway time.Duration
+}
+
+// ParseJWTPublicKey parses a PEM encoded public key for JWTConfig.PublicKey,
+// either a PKIX "PUBLIC KEY", a PKCS #1 "RSA PUBLIC KEY" or the key of a
+// "CERTIFICATE".
+func ParseJWTPublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// verify returns an error if the token isn't valid at the given time.
+func (cfg *JWTConfig) verify(token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed token signature: %w", err)
+	}
+	if err := cfg.verifySignature(header.Alg, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return fmt.Errorf("malformed token claims: %w", err)
+	}
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(cfg.Leeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(cfg.Leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	if cfg.Issuer != "" && claims["iss"] != cfg.Issuer {
+		return errors.New("token has the wrong issuer")
+	}
+	if cfg.Audience != "" && !hasClaim(claims["aud"], cfg.Audience) {
+		return errors.New("token has the wrong audience")
+	}
+	for name, value := range cfg.Claims {
+		if !hasClaim(claims[name], value) {
+			return fmt.Errorf("token lacks the %s claim %q", name, value)
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the signature with the configured key for the
+// algorithm, which must be of the kind the algorithm uses so that a token can't
+// choose how its signature is checked.
+func (cfg *JWTConfig) verifySignature(alg string, signed []byte, signature []byte) error {
+	if alg == "EdDSA" {
+		key, ok := cfg.PublicKey.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, signed, signature) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	var valid bool
+	switch alg[:2] {
+	case "HS":
+		if len(cfg.Secret) > 0 {
+			mac := hmac.New(hash.New, cfg.Secret)
+			mac.Write(signed)
+			valid = hmac.Equal(mac.Sum(nil), signature)
+		}
+	case "RS":
+		if key, ok := cfg.PublicKey.(*rsa.PublicKey); ok {
+			valid = rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		}
+	case "PS":
+		if key, ok := cfg.PublicKey.(*rsa.PublicKey); ok {
+			valid = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case "ES":
+		// the signature is r and s concatenated, each the size of the curve
+		if key, ok := cfg.PublicKey.(*ecdsa.PublicKey); ok {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(signature) == 2*size && hash.Size() == curveHashSize(key) {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				valid = ecdsa.Verify(key, digest, r, s)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	if !valid {
+		return errors.New("invalid token signature")
+	}
+	return nil
+}
+
+// curveHashSize returns the size of the hash that ES256, ES384 and ES512 pair
+// with the key's curve, P-256, P-384 and P-521 respectively.
+func curveHashSize(key *ecdsa.PublicKey) int {
+	switch key.Curve.Params().BitSize {
+	case 256:
+		return crypto.SHA256.Size()
+	case 384:
+		return crypto.SHA384.Size()
+	case 521:
+		return crypto.SHA512.Size()
+	default:
+		return 0
+	}
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// numericDate returns the time of a claim holding seconds since the epoch, and
+// whether the claim is present.
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("malformed %s claim", name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("malformed %s claim: %w", name, err)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
+
+// hasClaim returns true if the claim is the value, or an array containing it.
+func hasClaim(claim interface{}, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, c := range claim {
+			if c == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requiresAuthorization returns true if the configuration has access tokens,
+// API keys or JWT validation that clients must authorize with.
+func (cfg HttpServerConfig) requiresAuthorization() bool {
+	return cfg.AccessToken != "" || len(cfg.AccessTokens) > 0 || len(cfg.APIKeys) > 0 || cfg.JWT != nil
+}
+
+// tokenAuthorizer returns a function that reports whether a Bearer token is
+// one of the configured access tokens or API keys or a valid JWT, or nil if
+// the configuration doesn't require authorization.
+func tokenAuthorizer(cfg HttpServerConfig) func(token string) bool {
+	if !cfg.requiresAuthorization() {
+		return nil
+	}
+	// copied, so that appending doesn't write to the config's array
+	accessTokens := append([]string(nil), cfg.AccessTokens...)
+	if cfg.AccessToken != "" {
+		accessTokens = append([]string{cfg.AccessToken}, accessTokens...)
+	}
+	for _, key := range cfg.APIKeys {
+		accessTokens = append(accessTokens, key.Key)
+	}
+	return func(token string) bool {
+		if token == "" {
+			return false
+		}
+		for _, accessToken := range accessTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(accessToken)) == 1 {
+				return true
+			}
+		}
+		if cfg.JWT != nil {
+			if err := cfg.JWT.verify(token, time.Now()); err != nil {
+				logger.Debugw("rejected bearer token", "err", err)
+				return false
+			}
+			return true
+		}
+		return false
+	}
+}
+
+// authorizationMiddleware only passes on requests that authorize with the
+// Bearer scheme and one of the configured access tokens or API keys or a valid
+// JWT, other than for the health endpoints.
+func authorizationMiddleware(next http.Handler, cfg HttpServerConfig) http.Handler {
+	authorizes := tokenAuthorizer(cfg)
+	authorized := func(r *http.Request) bool {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && authorizes(token)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) || authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Unauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "Unauthorized")
+	})
+}
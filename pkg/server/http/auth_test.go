@@ -0,0 +1,295 @@
+package httpserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		cfg       JWTConfig
+		alg       string
+		key       crypto.Signer
+		claims    map[string]interface{}
+		expectErr string
+	}{
+		{
+			name:   "HS256",
+			cfg:    JWTConfig{Secret: secret},
+			alg:    "HS256",
+			claims: map[string]interface{}{"exp": now.Unix() + 60},
+		},
+		{
+			name:   "HS512",
+			cfg:    JWTConfig{Secret: secret},
+			alg:    "HS512",
+			claims: map[string]interface{}{},
+		},
+		{
+			name:      "HS256, wrong secret",
+			cfg:       JWTConfig{Secret: []byte("other")},
+			alg:       "HS256",
+			claims:    map[string]interface{}{},
+			expectErr: "invalid token signature",
+		},
+		{
+			name:   "RS256",
+			cfg:    JWTConfig{PublicKey: &rsaKey.PublicKey},
+			alg:    "RS256",
+			key:    rsaKey,
+			claims: map[string]interface{}{},
+		},
+		{
+			name:   "PS384",
+			cfg:    JWTConfig{PublicKey: &rsaKey.PublicKey},
+			alg:    "PS384",
+			key:    rsaKey,
+			claims: map[string]interface{}{},
+		},
+		{
+			name:   "ES256",
+			cfg:    JWTConfig{PublicKey: &ecKey.PublicKey},
+			alg:    "ES256",
+			key:    ecKey,
+			claims: map[string]interface{}{},
+		},
+		{
+			name:   "EdDSA",
+			cfg:    JWTConfig{PublicKey: edPublic},
+			alg:    "EdDSA",
+			key:    edKey,
+			claims: map[string]interface{}{},
+		},
+		{
+			name:      "HS256 with a public key",
+			cfg:       JWTConfig{PublicKey: &rsaKey.PublicKey},
+			alg:       "HS256",
+			claims:    map[string]interface{}{},
+			expectErr: "invalid token signature",
+		},
+		{
+			name:      "none",
+			cfg:       JWTConfig{Secret: secret},
+			alg:       "none",
+			claims:    map[string]interface{}{},
+			expectErr: "unsupported token algorithm",
+		},
+		{
+			name:      "expired",
+			cfg:       JWTConfig{Secret: secret},
+			alg:       "HS256",
+			claims:    map[string]interface{}{"exp": now.Unix()},
+			expectErr: "token is expired",
+		},
+		{
+			name:   "expired, within leeway",
+			cfg:    JWTConfig{Secret: secret, Leeway: time.Minute},
+			alg:    "HS256",
+			claims: map[string]interface{}{"exp": now.Unix() - 30},
+		},
+		{
+			name:      "not valid yet",
+			cfg:       JWTConfig{Secret: secret},
+			alg:       "HS256",
+			claims:    map[string]interface{}{"nbf": now.Unix() + 30},
+			expectErr: "token is not valid yet",
+		},
+		{
+			name:      "malformed exp",
+			cfg:       JWTConfig{Secret: secret},
+			alg:       "HS256",
+			claims:    map[string]interface{}{"exp": "tomorrow"},
+			expectErr: "malformed exp claim",
+		},
+		{
+			name:   "issuer and audience",
+			cfg:    JWTConfig{Secret: secret, Issuer: "auth.example.com", Audience: "lassie"},
+			alg:    "HS256",
+			claims: map[string]interface{}{"iss": "auth.example.com", "aud": []string{"other", "lassie"}},
+		},
+		{
+			name:      "wrong issuer",
+			cfg:       JWTConfig{Secret: secret, Issuer: "auth.example.com"},
+			alg:       "HS256",
+			claims:    map[string]interface{}{"iss": "evil.example.com"},
+			expectErr: "wrong issuer",
+		},
+		{
+			name:      "wrong audience",
+			cfg:       JWTConfig{Secret: secret, Audience: "lassie"},
+			alg:       "HS256",
+			claims:    map[string]interface{}{"aud": "other"},
+			expectErr: "wrong audience",
+		},
+		{
+			name:   "claims",
+			cfg:    JWTConfig{Secret: secret, Claims: map[string]string{"scope": "retrieve", "tier": "gold"}},
+			alg:    "HS256",
+			claims: map[string]interface{}{"scope": []string{"retrieve", "admin"}, "tier": "gold"},
+		},
+		{
+			name:      "missing claim",
+			cfg:       JWTConfig{Secret: secret, Claims: map[string]string{"scope": "retrieve"}},
+			alg:       "HS256",
+			claims:    map[string]interface{}{"scope": "admin"},
+			expectErr: `lacks the scope claim "retrieve"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			token := signJWT(t, testCase.alg, secret, testCase.key, testCase.claims)
+			err := testCase.cfg.verify(token, now)
+			if testCase.expectErr != "" {
+				require.ErrorContains(t, err, testCase.expectErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		cfg := JWTConfig{Secret: secret}
+		require.ErrorContains(t, cfg.verify("secret", now), "malformed token")
+		require.ErrorContains(t, cfg.verify("a.b.c", now), "malformed token header")
+	})
+}
+
+func TestParseJWTPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+	key, err := ParseJWTPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.True(t, ecKey.PublicKey.Equal(key))
+
+	_, err = ParseJWTPublicKey([]byte("not a key"))
+	require.ErrorContains(t, err, "no PEM encoded public key")
+	_, err = ParseJWTPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.ErrorContains(t, err, "unsupported PEM block type")
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	secret := []byte("secret")
+	jwt := signJWT(t, "HS256", secret, nil, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	expired := signJWT(t, "HS256", secret, nil, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})
+	cfg := HttpServerConfig{AccessToken: "one", AccessTokens: []string{"two"}, APIKeys: []APIKey{{Name: "acme", Key: "acme-key"}}, JWT: &JWTConfig{Secret: secret}}
+	handler := authorizationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cfg)
+
+	testCases := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{"access token", "/ipfs/bafkqaaa", "Bearer one", http.StatusOK},
+		{"another access token", "/ipfs/bafkqaaa", "Bearer two", http.StatusOK},
+		{"api key", "/ipfs/bafkqaaa", "Bearer acme-key", http.StatusOK},
+		{"jwt", "/ipfs/bafkqaaa", "Bearer " + jwt, http.StatusOK},
+		{"expired jwt", "/ipfs/bafkqaaa", "Bearer " + expired, http.StatusUnauthorized},
+		{"wrong token", "/ipfs/bafkqaaa", "Bearer three", http.StatusUnauthorized},
+		{"wrong scheme", "/ipfs/bafkqaaa", "Basic one", http.StatusUnauthorized},
+		{"no authorization", "/ipfs/bafkqaaa", "", http.StatusUnauthorized},
+		{"health", "/healthz", "", http.StatusOK},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+			if testCase.authorization != "" {
+				req.Header.Set("Authorization", testCase.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, testCase.wantStatus, rr.Code)
+			if testCase.wantStatus == http.StatusUnauthorized {
+				require.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// the API keys of one config aren't accepted by another sharing its
+	// access tokens
+	accessTokens := make([]string, 1, 2)
+	accessTokens[0] = "two"
+	acme := tokenAuthorizer(HttpServerConfig{AccessTokens: accessTokens, APIKeys: []APIKey{{Name: "acme", Key: "acme-key"}}})
+	other := tokenAuthorizer(HttpServerConfig{AccessTokens: accessTokens, APIKeys: []APIKey{{Name: "other", Key: "other-key"}}})
+	require.True(t, acme("acme-key"))
+	require.False(t, acme("other-key"))
+	require.True(t, other("other-key"))
+	require.Equal(t, []string{"two", ""}, accessTokens[:2])
+}
+
+// signJWT encodes the claims as a token signed with the algorithm, using the
+// secret for HMAC and otherwise the key.
+func signJWT(t *testing.T, alg string, secret []byte, key crypto.Signer, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": alg, "typ": "JWT"}) + "." + encode(claims)
+
+	var signature []byte
+	hash := jwtHashes[alg[len(alg)-3:]]
+	switch {
+	case alg == "none":
+	case alg == "EdDSA":
+		var err error
+		signature, err = key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+		require.NoError(t, err)
+	case alg[:2] == "HS":
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	default:
+		hasher := hash.New()
+		hasher.Write([]byte(signed))
+		digest := hasher.Sum(nil)
+		var err error
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+			require.NoError(t, err)
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature = make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+		case *rsa.PrivateKey:
+			if alg[:2] == "PS" {
+				signature, err = rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			} else {
+				signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+			}
+		}
+		require.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/lassie/pkg/build"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/multiformats/go-multicodec"
+)
+
+const (
+	mimeTypeJson    = "application/json"
+	mimeTypeCbor    = "application/cbor"
+	mimeTypeDagJson = "application/vnd.ipld.dag-json"
+	mimeTypeDagCbor = "application/vnd.ipld.dag-cbor"
+)
+
+// conversionCodec describes a response format that requires the daemon to
+// decode the requested block and re-encode it, rather than returning a CAR.
+type conversionCodec struct {
+	mimeType string
+	codec    multicodec.Code
+	encoder  ipld.Encoder
+}
+
+var conversionCodecs = map[string]conversionCodec{
+	mimeTypeJson:    {mimeTypeJson, multicodec.DagJson, dagjson.Encode},
+	mimeTypeDagJson: {mimeTypeDagJson, multicodec.DagJson, dagjson.Encode},
+	mimeTypeCbor:    {mimeTypeCbor, multicodec.DagCbor, dagcbor.Encode},
+	mimeTypeDagCbor: {mimeTypeDagCbor, multicodec.DagCbor, dagcbor.Encode},
+}
+
+var conversionFormats = map[string]string{
+	"json":     mimeTypeJson,
+	"dag-json": mimeTypeDagJson,
+	"cbor":     mimeTypeCbor,
+	"dag-cbor": mimeTypeDagCbor,
+}
+
+// isUnixFSCodec returns true for codecs that make up UnixFS data, which we
+// don't support converting.
+func isUnixFSCodec(c cid.Cid) bool {
+	codec := multicodec.Code(c.Prefix().Codec)
+	return codec == multicodec.DagPb || codec == multicodec.Raw
+}
+
+// serveConversion fetches the block at the end of the requested path and
+// responds with the node it contains, encoded according to the
+// conversionCodec.
+func serveConversion(fetcher types.Fetcher, cfg HttpServerConfig, cc conversionCodec, res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) {
+	ok, rootCid, path := decodeUrlPath(res, req, statusLogger)
+	if !ok {
+		return
+	}
+
+	if isUnixFSCodec(rootCid) {
+		errorResponse(res, statusLogger, http.StatusNotAcceptable, fmt.Errorf("conversion to %s is not supported for UnixFS data", cc.mimeType))
+		return
+	}
+
+	ok, request := newRetrievalRequest(cfg, res, req, statusLogger, trustlessutils.Request{
+		Root:  rootCid,
+		Path:  path.String(),
+		Scope: trustlessutils.DagScopeBlock,
+	})
+	if !ok {
+		return
+	}
+
+	ok, fetchOpts := decodeGlobalTimeout(res, req, statusLogger)
+	if !ok {
+		return
+	}
+	fetchOpts = append(fetchOpts, types.WithEventsCallback(accessLogSubscriber(req.Context(), nil)))
+
+	store := &memstore.Store{}
+	request.LinkSystem.SetWriteStorage(store)
+	request.LinkSystem.SetReadStorage(store)
+
+	logger.Debugw("fetching for conversion",
+		logging.RetrievalIDKey, request.RetrievalID,
+		"root", request.Root.String(),
+		"path", request.Path,
+		"format", cc.mimeType,
+	)
+
+	if _, err := fetcher.Fetch(req.Context(), request, fetchOpts...); err != nil {
+		fetchErrorResponse(res, statusLogger, err)
+		return
+	}
+
+	node, blockCid, err := loadPathNode(req, request.LinkSystem, rootCid, path)
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("failed to load node: %w", err))
+		return
+	}
+	if isUnixFSCodec(blockCid) {
+		errorResponse(res, statusLogger, http.StatusNotAcceptable, fmt.Errorf("conversion to %s is not supported for UnixFS data", cc.mimeType))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := cc.encoder(node, &buf); err != nil {
+		errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("failed to encode %s: %w", cc.mimeType, err))
+		return
+	}
+
+	res.Header().Set("Server", build.UserAgent)
+	res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
+	res.Header().Set("Content-Type", cc.mimeType)
+	res.Header().Set("Etag", fmt.Sprintf(`"%s.%s"`, blockCid, cc.codec))
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("X-Ipfs-Path", trustlessutils.PathEscape(req.URL.Path))
+	statusLogger.logStatus(200, "OK")
+	if _, err := res.Write(buf.Bytes()); err != nil {
+		logger.Debugw("failed to write conversion response", "err", err)
+	}
+}
+
+// loadPathNode walks the path from the root using the blocks that have been
+// fetched into the LinkSystem, returning the node at the end of the path and
+// the CID of the block that contains it.
+func loadPathNode(req *http.Request, lsys linking.LinkSystem, rootCid cid.Cid, path datamodel.Path) (datamodel.Node, cid.Cid, error) {
+	lctx := linking.LinkContext{Ctx: req.Context()}
+	rootNode, err := lsys.Load(lctx, cidlink.Link{Cid: rootCid}, basicnode.Prototype.Any)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	var node datamodel.Node
+	blockCid := rootCid
+	err = traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:        req.Context(),
+			LinkSystem: lsys,
+			LinkTargetNodePrototypeChooser: func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error) {
+				return basicnode.Prototype.Any, nil
+			},
+		},
+	}.Focus(rootNode, path, func(prog traversal.Progress, n datamodel.Node) error {
+		node = n
+		if prog.LastBlock.Link != nil {
+			blockCid = prog.LastBlock.Link.(cidlink.Link).Cid
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	return node, blockCid, nil
+}
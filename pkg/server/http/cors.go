@@ -0,0 +1,175 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMethods are the methods CORSConfig allows when none are given.
+var DefaultCORSMethods = []string{http.MethodGet, http.MethodHead}
+
+// DefaultCORSHeaders are the request headers CORSConfig allows when none are
+// given, those that Lassie reads.
+var DefaultCORSHeaders = []string{
+	"Accept",
+	"Authorization",
+	"X-Request-Id",
+	"X-Lassie-Retrieval-Id",
+	"X-Lassie-Provider-Allow-List",
+	"X-Lassie-Provider-Block-List",
+}
+
+// corsExposedHeaders are the response headers that browsers let scripts read,
+// beyond the CORS-safelisted ones.
+var corsExposedHeaders = []string{
+	"Accept-Ranges",
+	"Content-Disposition",
+	"Content-Length",
+	"Etag",
+	"Retry-After",
+	"Server-Timing",
+	"X-Ipfs-Path",
+	"X-Lassie-Cache",
+	"X-Lassie-Request-Hash",
+	"X-Lassie-Retrieval-Id",
+	"X-Trace-Id",
+	"X-Lassie-Partial-Result",
+}
+
+// CORSConfig configures Cross-Origin Resource Sharing, so that scripts in web
+// pages from other origins, such as browser based dApps, can fetch from the
+// server.
+type CORSConfig struct {
+	// AllowedOrigins are the origins that may make cross-origin requests,
+	// such as "https://app.example.com". "*" allows any origin, and a
+	// wildcard subdomain such as "https://*.example.com" any origin under it.
+	AllowedOrigins []string
+	// AllowedMethods are the methods cross-origin requests may use,
+	// DefaultCORSMethods if empty.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers cross-origin requests may send,
+	// DefaultCORSHeaders if empty. "*" allows any header.
+	AllowedHeaders []string
+	// MaxAge is how long browsers may cache the response to a preflight
+	// request; zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// allowsOrigin returns true if the origin may make cross-origin requests.
+func (cfg *CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+			strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *CORSConfig) allowsAnyOrigin() bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *CORSConfig) methods() []string {
+	if len(cfg.AllowedMethods) == 0 {
+		return DefaultCORSMethods
+	}
+	return cfg.AllowedMethods
+}
+
+func (cfg *CORSConfig) headers() []string {
+	if len(cfg.AllowedHeaders) == 0 {
+		return DefaultCORSHeaders
+	}
+	return cfg.AllowedHeaders
+}
+
+// allowsHeaders returns true if each of the comma separated headers of a
+// preflight request's Access-Control-Request-Headers may be sent.
+func (cfg *CORSConfig) allowsHeaders(requested string) bool {
+	headers := cfg.headers()
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if !containsFold(headers, header) && !containsFold(headers, "*") {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware adds CORS headers to the responses to requests from allowed
+// origins, and responds to their preflight requests. Preflight requests are
+// answered before authorization, as browsers send them without credentials,
+// while other responses, including 401 and 429, carry the headers so that
+// scripts can read them.
+func corsMiddleware(next http.Handler, cfg *CORSConfig) http.Handler {
+	methods := strings.Join(cfg.methods(), ", ")
+	exposed := strings.Join(corsExposedHeaders, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !cfg.allowsOrigin(origin) {
+			if preflight {
+				// without CORS headers the browser rejects the request
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cfg.allowsAnyOrigin() {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", exposed)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if !containsFold(cfg.methods(), r.Header.Get("Access-Control-Request-Method")) || !cfg.allowsHeaders(requestedHeaders) {
+			w.Header().Del("Access-Control-Allow-Origin")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		if requestedHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,175 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served", "true")
+	})
+
+	testCases := []struct {
+		name        string
+		cfg         CORSConfig
+		method      string
+		headers     map[string]string
+		wantStatus  int
+		wantServed  bool
+		wantHeaders map[string]string
+	}{
+		{
+			name:        "no origin",
+			cfg:         CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method:      http.MethodGet,
+			wantStatus:  http.StatusOK,
+			wantServed:  true,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:       "allowed origin",
+			cfg:        CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method:     http.MethodGet,
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusOK,
+			wantServed: true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "https://app.example.com",
+				"Vary":                        "Origin",
+			},
+		},
+		{
+			name:        "other origin",
+			cfg:         CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus:  http.StatusOK,
+			wantServed:  true,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:        "any origin",
+			cfg:         CORSConfig{AllowedOrigins: []string{"*"}},
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://app.example.com"},
+			wantStatus:  http.StatusOK,
+			wantServed:  true,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		{
+			name:        "wildcard subdomain",
+			cfg:         CORSConfig{AllowedOrigins: []string{"https://*.example.com"}},
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://app.example.com"},
+			wantStatus:  http.StatusOK,
+			wantServed:  true,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com"},
+		},
+		{
+			name:        "wildcard subdomain, other domain",
+			cfg:         CORSConfig{AllowedOrigins: []string{"https://*.example.com"}},
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://example.org"},
+			wantStatus:  http.StatusOK,
+			wantServed:  true,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: time.Hour},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "accept, authorization",
+			},
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, HEAD",
+				"Access-Control-Allow-Headers": "accept, authorization",
+				"Access-Control-Max-Age":       "3600",
+			},
+		},
+		{
+			name:   "preflight, method not allowed",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+		{
+			name:   "preflight, configured method",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowedMethods: []string{"GET", "DELETE"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Methods": "GET, DELETE", "Access-Control-Max-Age": ""},
+		},
+		{
+			name:   "preflight, header not allowed",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "x-custom",
+			},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight, any header",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowedHeaders: []string{"*"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "x-custom",
+			},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Headers": "x-custom"},
+		},
+		{
+			name:        "options without preflight",
+			cfg:         CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			method:      http.MethodOptions,
+			headers:     map[string]string{"Origin": "https://app.example.com"},
+			wantStatus:  http.StatusOK,
+			wantServed:  true,
+			wantHeaders: map[string]string{"Access-Control-Allow-Methods": ""},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(testCase.method, "/ipfs/bafkqaaa", nil)
+			for name, value := range testCase.headers {
+				req.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			corsMiddleware(handler, &testCase.cfg).ServeHTTP(rr, req)
+			require.Equal(t, testCase.wantStatus, rr.Code)
+			require.Equal(t, testCase.wantServed, rr.Header().Get("X-Served") == "true")
+			for name, value := range testCase.wantHeaders {
+				require.Equal(t, value, rr.Header().Get(name), name)
+			}
+			if testCase.wantServed && rr.Header().Get("Access-Control-Allow-Origin") != "" {
+				require.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "X-Lassie-Retrieval-Id")
+			}
+		})
+	}
+}
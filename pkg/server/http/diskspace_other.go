@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package httpserver
+
+// diskSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func diskSpace(path string) (free uint64, total uint64, err error) {
+	return 0, 0, errDiskSpaceUnsupported
+}
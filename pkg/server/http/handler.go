@@ -0,0 +1,297 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync/atomic"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Middleware wraps an http.Handler, typically to perform some work before or
+// after the wrapped handler serves a request.
+type Middleware func(http.Handler) http.Handler
+
+type handlerOptions struct {
+	middleware []Middleware
+	pathPrefix string
+	pprof      bool
+	admin      bool
+	metrics    prometheus.Gatherer
+}
+
+// HandlerOption configures the handler returned by NewHandler.
+type HandlerOption func(*handlerOptions)
+
+// WithMiddleware adds middleware around the handler. Middleware is applied in
+// the order given, the first being the outermost, and sees each request before
+// the path prefix is stripped and before access token authorization.
+func WithMiddleware(middleware ...Middleware) HandlerOption {
+	return func(o *handlerOptions) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// WithPathPrefix mounts the handler's routes under the given prefix, e.g. a
+// prefix of "/lassie" serves retrievals from "/lassie/ipfs/{cid}". Requests
+// that don't start with the prefix receive a 404.
+func WithPathPrefix(prefix string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.pathPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithPprof enables or disables the /debug/pprof/ endpoints. They are disabled
+// by default.
+func WithPprof(enabled bool) HandlerOption {
+	return func(o *handlerOptions) {
+		o.pprof = enabled
+	}
+}
+
+// WithAdmin enables or disables the /admin/ endpoints for listing and
+// cancelling retrievals in progress, enabling and disabling protocols, viewing
+// provider statistics, flushing caches, adjusting log levels and reporting the
+// usage of API keys, see AdminRetrievalsHandler, AdminProtocolsHandler,
+// AdminCachesHandler, AdminLogLevelsHandler and HttpServerConfig.APIKeys. They
+// are disabled by default and require one of the
+// HttpServerConfig.AdminAccessTokens when configured, or otherwise the access
+// token. With API keys or JWTs, which tenants hold, and no AdminAccessTokens,
+// they respond with 403 Forbidden.
+func WithAdmin(enabled bool) HandlerOption {
+	return func(o *handlerOptions) {
+		o.admin = enabled
+	}
+}
+
+// WithMetrics serves the metrics collected by the given Gatherer, typically the
+// prometheus.Registry passed to lassie.WithMetricsRegisterer, in the
+// Prometheus exposition format at /metrics. A nil Gatherer, the default,
+// serves no /metrics endpoint.
+func WithMetrics(gatherer prometheus.Gatherer) HandlerOption {
+	return func(o *handlerOptions) {
+		o.metrics = gatherer
+	}
+}
+
+// NewHandler creates an http.Handler serving Lassie's gateway endpoints,
+// /ipfs/, /ipns/ when an IpnsResolver is configured, /healthz, /readyz,
+// /stats/failures and /stats/session, so that they may be mounted within an existing HTTP server
+// rather than run with NewHttpServer.
+func NewHandler(lassie *lassie.Lassie, cfg HttpServerConfig, opts ...HandlerOption) http.Handler {
+	handler, _ := newHandlers(lassie, cfg, opts...)
+	return handler
+}
+
+// adminAuthorization authorizes the admin endpoints served alongside the
+// gateway: with the AdminAccessTokens, if any, and otherwise with the
+// gateway's access tokens. API keys and JWTs are held by tenants rather than
+// operators, so with either configured and no AdminAccessTokens, the admin
+// endpoints are forbidden to everyone.
+func adminAuthorization(adminMux http.Handler, cfg HttpServerConfig) http.Handler {
+	if len(cfg.AdminAccessTokens) > 0 {
+		return authorizationMiddleware(adminMux, HttpServerConfig{AccessTokens: cfg.AdminAccessTokens})
+	}
+	if len(cfg.APIKeys) > 0 || cfg.JWT != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, "Forbidden: admin endpoints require AdminAccessTokens when API keys or JWTs are accepted")
+		})
+	}
+	return adminMux
+}
+
+// newHandlers creates the handler of NewHandler, and a handler serving only
+// the admin endpoints, which administers the same retrievals and caches, to be
+// served on a listener of its own, see HttpServerConfig.AdminAddress.
+func newHandlers(lassie *lassie.Lassie, cfg HttpServerConfig, opts ...HandlerOption) (http.Handler, http.Handler) {
+	options := handlerOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if lassie.InMemory() {
+		cfg.InMemory = true
+	}
+	if cfg.TempStore == nil {
+		cfg.TempStore = lassie.TempStore()
+	}
+	if cfg.InMemoryThreshold == 0 {
+		cfg.InMemoryThreshold = lassie.InMemoryThreshold()
+	}
+
+	mux := http.NewServeMux()
+
+	// Routes
+	cfg.progress = newProgressStreams()
+	var inflight atomic.Int64
+	queue := newRetrievalQueue(cfg.MaxConcurrentRetrievals, cfg.MaxQueuedRetrievals, cfg.QueueTimeout)
+	mux.HandleFunc("/ipfs/", limitRetrievals(queue, trackInflight(&inflight, IpfsHandler(lassie, cfg))))
+	var ipnsCache *ipnsresolver.CachingResolver
+	if cfg.IpnsResolver != nil {
+		ipnsCache = newIpnsCache(cfg)
+		mux.HandleFunc("/ipns/", limitRetrievals(queue, trackInflight(&inflight, ipnsHandler(lassie, cfg, ipnsCache))))
+	}
+
+	// Health endpoints, /healthz checks that the process is live and /readyz
+	// additionally checks the dependencies needed to serve retrievals
+	liveness := []HealthCheck{
+		{Name: "libp2p", Check: lassie.CheckHost, Detail: lassie.DescribeHost},
+		{Name: "retriever", Check: lassie.CheckRetriever},
+	}
+	readiness := append(append([]HealthCheck{}, liveness...),
+		HealthCheck{Name: "indexer", Check: lassie.CheckFinder},
+		HealthCheck{Name: "scheduler", Check: func(ctx context.Context) error {
+			if err := checkCapacity(&inflight, cfg.MaxConcurrentRequests)(ctx); err != nil {
+				return err
+			}
+			return checkQueue(queue)(ctx)
+		}, Detail: func() string {
+			detail := fmt.Sprintf("%d requests in flight, %d retrievals active", inflight.Load(), len(lassie.ActiveRetrievals()))
+			if queue != nil {
+				detail += fmt.Sprintf(", %d queued", queue.waiting())
+			}
+			return detail
+		}},
+		HealthCheck{Name: "protocols", Check: lassie.CheckProtocols},
+	)
+	// an in-memory server, or one with its own temporary storage, has no
+	// temporary directory to depend on
+	if !cfg.InMemory && cfg.TempStore == nil {
+		readiness = append(readiness, HealthCheck{
+			Name: "datastore",
+			Check: func(ctx context.Context) error {
+				if err := checkTempDirWritable(cfg.TempDir)(ctx); err != nil {
+					return err
+				}
+				return checkTempSpace(cfg.TempDir, cfg.MinTempSpace)(ctx)
+			},
+			Detail: tempSpaceDetail(cfg.TempDir),
+		})
+	}
+	if cfg.TempQuota != nil {
+		readiness = append(readiness, HealthCheck{
+			Name:   "temp-quota",
+			Check:  checkTempQuota(cfg.TempQuota),
+			Detail: tempQuotaDetail(cfg.TempQuota),
+		})
+	}
+	mux.HandleFunc("/healthz", HealthHandler(liveness...))
+	mux.HandleFunc("/readyz", HealthHandler(readiness...))
+
+	// Aggregated failure reasons, for fleet dashboards
+	mux.HandleFunc("/stats/failures", FailureStatsHandler(lassie))
+
+	// Per-provider statistics, for dashboards and debugging
+	mux.HandleFunc("/stats/session", SessionStateHandler(lassie))
+
+	// Protocols enabled and disabled at runtime
+	mux.HandleFunc("/stats/protocols", ProtocolsHandler(lassie))
+
+	// Progress of retrievals whose ID the client chose, as Server-Sent Events
+	mux.HandleFunc(progressPath+"/", progressHandler(cfg.progress))
+
+	// Results of finished retrievals, when they're stored
+	mux.HandleFunc("/results", ResultsHandler(lassie))
+
+	// Prometheus metrics
+	if options.metrics != nil {
+		mux.Handle("/metrics", promhttp.HandlerFor(options.metrics, promhttp.HandlerOpts{}))
+	}
+
+	// Admin endpoints
+	keys := newAPIKeys(cfg.APIKeys, clock.New())
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc(adminRetrievalsPath, AdminRetrievalsHandler(lassie))
+	adminMux.HandleFunc(adminRetrievalsPath+"/", AdminRetrievalsHandler(lassie))
+	adminMux.HandleFunc(adminProtocolsPath, AdminProtocolsHandler(lassie))
+	adminMux.HandleFunc(adminProtocolsPath+"/", AdminProtocolsHandler(lassie))
+	adminMux.HandleFunc(adminSessionPath, SessionStateHandler(lassie))
+	adminMux.HandleFunc(adminCachesPath, AdminCachesHandler(cfg.ResponseCache, lassie.BlockCache(), ipnsCache))
+	adminMux.HandleFunc(adminCachesPath+"/", AdminCachesHandler(cfg.ResponseCache, lassie.BlockCache(), ipnsCache))
+	adminMux.HandleFunc(adminLogLevelsPath, AdminLogLevelsHandler())
+	adminMux.HandleFunc(adminLogLevelsPath+"/", AdminLogLevelsHandler())
+	adminMux.HandleFunc(adminAPIKeysPath, adminAPIKeysHandler(keys))
+	adminMux.HandleFunc(adminAPIKeysPath+"/", adminAPIKeysHandler(keys))
+	adminOwnTokens := options.admin && len(cfg.AdminAccessTokens) > 0
+	if options.admin {
+		mux.Handle("/admin/", adminAuthorization(adminMux, cfg))
+	}
+
+	// Handle pprof endpoints
+	if options.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	handler := servertiming.Middleware(mux, nil)
+
+	// account for the usage of API keys once they're authorized
+	if len(cfg.APIKeys) > 0 {
+		handler = apiKeyMiddleware(handler, keys)
+	}
+
+	if cfg.requiresAuthorization() {
+		gateway, authorized := handler, authorizationMiddleware(handler, cfg)
+		handler = authorized
+		if adminOwnTokens {
+			// the admin endpoints are authorized with the admin tokens alone
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/admin/") {
+					gateway.ServeHTTP(w, r)
+					return
+				}
+				authorized.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	// rate limit before authorization, so that clients can't make unlimited
+	// attempts at guessing tokens
+	limiter := cfg.rateLimiter
+	if limits := cfg.RateLimits.withAPIKeys(cfg.APIKeys); limiter == nil && !limits.isZero() {
+		limiter = newRateLimiter(limits, tokenAuthorizer(cfg), clock.New())
+	}
+	if limiter != nil {
+		handler = rateLimitMiddleware(handler, limiter)
+	}
+
+	// answer preflight requests before they're rate limited or authorized,
+	// and let scripts read the responses of those that are turned away
+	if cfg.CORS != nil {
+		handler = corsMiddleware(handler, cfg.CORS)
+	}
+
+	if options.pathPrefix != "" {
+		handler = http.StripPrefix(options.pathPrefix, handler)
+	}
+
+	// log every request, with its path as received
+	if cfg.AccessLog != nil {
+		handler = accessLogMiddleware(handler, cfg.AccessLog)
+	}
+
+	for i := len(options.middleware) - 1; i >= 0; i-- {
+		handler = options.middleware[i](handler)
+	}
+
+	var adminHandler http.Handler = adminMux
+	if len(cfg.AdminAccessTokens) > 0 {
+		adminHandler = authorizationMiddleware(adminHandler, HttpServerConfig{AccessTokens: cfg.AdminAccessTokens})
+	}
+	if cfg.AccessLog != nil {
+		adminHandler = accessLogMiddleware(adminHandler, cfg.AccessLog)
+	}
+
+	return handler, adminHandler
+}
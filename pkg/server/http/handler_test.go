@@ -0,0 +1,402 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/responsecache"
+	"github.com/filecoin-project/lassie/pkg/resultstore"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	require.NoError(t, mrn.MN.LinkAll())
+	registry := prometheus.NewRegistry()
+	results := resultstore.NewStore(resultstore.Config{Datastore: dssync.MutexWrap(datastore.NewMapDatastore())})
+	lassie, err := lassie.NewLassie(ctx, lassie.WithHost(mrn.Self), lassie.WithFinder(mrn.Finder), lassie.WithMetricsRegisterer(registry), lassie.WithResultStore(results))
+	require.NoError(t, err)
+
+	responseCache, err := responsecache.New(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+
+	header := func(name, value string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Add(name, value)
+				next.ServeHTTP(res, req)
+			})
+		}
+	}
+
+	tests := []struct {
+		name          string
+		cfg           HttpServerConfig
+		opts          []HandlerOption
+		method        string
+		path          string
+		body          string
+		authorization string
+		headers       map[string]string
+		wantStatus    int
+		wantOrder     []string
+		wantBody      string
+	}{
+		{
+			name:       "default routes",
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "pprof disabled by default",
+			path:       "/debug/pprof/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "pprof enabled",
+			opts:       []HandlerOption{WithPprof(true)},
+			path:       "/debug/pprof/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "path prefix",
+			opts:       []HandlerOption{WithPathPrefix("/lassie/")},
+			path:       "/lassie/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "path prefix, unprefixed request",
+			opts:       []HandlerOption{WithPathPrefix("/lassie")},
+			path:       "/healthz",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "middleware order",
+			opts:       []HandlerOption{WithMiddleware(header("X-Order", "first"), header("X-Order", "second")), WithMiddleware(header("X-Order", "third"))},
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+			wantOrder:  []string{"first", "second", "third"},
+		},
+		{
+			name:       "middleware runs before authorization",
+			cfg:        HttpServerConfig{AccessToken: "secret"},
+			opts:       []HandlerOption{WithMiddleware(header("X-Order", "first"))},
+			path:       "/ipfs/bafkqaaa",
+			wantStatus: http.StatusUnauthorized,
+			wantOrder:  []string{"first"},
+		},
+		{
+			name:          "authorization with path prefix",
+			cfg:           HttpServerConfig{AccessToken: "secret"},
+			opts:          []HandlerOption{WithPathPrefix("/lassie")},
+			path:          "/lassie/ipfs/bafkqaaa?format=bogus",
+			authorization: "Bearer secret",
+			wantStatus:    http.StatusBadRequest,
+		},
+		{
+			name:       "cors preflight without authorization",
+			cfg:        HttpServerConfig{AccessToken: "secret", CORS: &CORSConfig{AllowedOrigins: []string{"https://app.example.com"}}},
+			method:     http.MethodOptions,
+			path:       "/ipfs/bafkqaaa",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET"},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "cors with authorization",
+			cfg:        HttpServerConfig{AccessToken: "secret", CORS: &CORSConfig{AllowedOrigins: []string{"https://app.example.com"}}},
+			path:       "/ipfs/bafkqaaa",
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "failure stats",
+			path:       "/stats/failures",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "failure stats, invalid window",
+			path:       "/stats/failures?window=2h",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "session state",
+			path:       "/stats/session",
+			wantStatus: http.StatusOK,
+			wantBody:   `"providers":[]`,
+		},
+		{
+			name:       "results",
+			path:       "/results?root=bafkqaaa&outcome=success&since=2023-09-01T12:00:00Z&limit=10",
+			wantStatus: http.StatusOK,
+			wantBody:   "[]",
+		},
+		{
+			name:       "results, invalid since",
+			path:       "/results?since=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "metrics disabled by default",
+			path:       "/metrics",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "metrics enabled",
+			opts:       []HandlerOption{WithMetrics(registry)},
+			path:       "/metrics",
+			wantStatus: http.StatusOK,
+			wantBody:   "lassie_active_retrievals 0",
+		},
+		{
+			name:       "admin disabled by default",
+			path:       "/admin/retrievals",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "admin enabled",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/retrievals",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:          "admin with access token",
+			cfg:           HttpServerConfig{AccessToken: "secret"},
+			opts:          []HandlerOption{WithAdmin(true)},
+			path:          "/admin/retrievals",
+			authorization: "Bearer secret",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "admin forbidden to api key tenants",
+			cfg:           HttpServerConfig{APIKeys: []APIKey{{Name: "acme", Key: "acme-key"}}},
+			opts:          []HandlerOption{WithAdmin(true)},
+			path:          "/admin/retrievals",
+			authorization: "Bearer acme-key",
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "admin with admin tokens, api key tenant",
+			cfg:           HttpServerConfig{APIKeys: []APIKey{{Name: "acme", Key: "acme-key"}}, AdminAccessTokens: []string{"admin"}},
+			opts:          []HandlerOption{WithAdmin(true)},
+			path:          "/admin/retrievals",
+			authorization: "Bearer acme-key",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "admin with admin tokens",
+			cfg:           HttpServerConfig{APIKeys: []APIKey{{Name: "acme", Key: "acme-key"}}, AdminAccessTokens: []string{"admin"}},
+			opts:          []HandlerOption{WithAdmin(true)},
+			path:          "/admin/retrievals",
+			authorization: "Bearer admin",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "admin tokens aren't gateway tokens",
+			cfg:           HttpServerConfig{APIKeys: []APIKey{{Name: "acme", Key: "acme-key"}}, AdminAccessTokens: []string{"admin"}},
+			opts:          []HandlerOption{WithAdmin(true)},
+			path:          "/ipfs/bafkqaaa",
+			authorization: "Bearer admin",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "admin cancel unknown retrieval",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodDelete,
+			path:       "/admin/retrievals/6f4e8a1c-3d0b-4b5e-9c7a-2f1d0e8b9a63",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "admin cancel invalid retrieval ID",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodDelete,
+			path:       "/admin/retrievals/bogus",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "admin cancel requires DELETE",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/retrievals/6f4e8a1c-3d0b-4b5e-9c7a-2f1d0e8b9a63",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "protocol stats",
+			path:       "/stats/protocols",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"protocol":"transport-graphsync-filecoinv1","enabled":true}`,
+		},
+		{
+			name:       "admin disable protocol",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/protocols/graphsync",
+			body:       `{"enabled": false}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"protocol":"transport-graphsync-filecoinv1","enabled":false}`,
+		},
+		{
+			name:       "disabled protocol stats",
+			path:       "/stats/protocols",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"protocol":"transport-graphsync-filecoinv1","enabled":false}`,
+		},
+		{
+			name:       "admin enable protocol",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/protocols/graphsync",
+			body:       `{"enabled": true}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"protocol":"transport-graphsync-filecoinv1","enabled":true}`,
+		},
+		{
+			name:       "admin protocols",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/protocols",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"protocol":"transport-bitswap","enabled":true}`,
+		},
+		{
+			name:       "admin unknown protocol",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/protocols/ftp",
+			body:       `{"enabled": false}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "admin protocol without state",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/protocols/http",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "admin protocol requires PUT",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/protocols/http",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "admin session",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/session",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "admin caches without caches",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/caches",
+			wantStatus: http.StatusOK,
+			wantBody:   "[]",
+		},
+		{
+			name:       "admin caches",
+			cfg:        HttpServerConfig{ResponseCache: responseCache},
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/caches",
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"name":"responses","entries":0}]`,
+		},
+		{
+			name:       "admin flush cache",
+			cfg:        HttpServerConfig{ResponseCache: responseCache},
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodDelete,
+			path:       "/admin/caches/responses",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"name":"responses","entries":0}`,
+		},
+		{
+			name:       "admin flush cache not configured",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodDelete,
+			path:       "/admin/caches/ipns",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "admin flush cache requires DELETE",
+			cfg:        HttpServerConfig{ResponseCache: responseCache},
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPost,
+			path:       "/admin/caches/responses",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "admin log levels",
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/log-levels",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"subsystem":"lassie/httpserver"}`,
+		},
+		{
+			name:       "admin set log level",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/log-levels/lassie/httpserver",
+			body:       `{"level": "INFO"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"subsystem":"lassie/httpserver","level":"info"}`,
+		},
+		{
+			name:       "admin set invalid log level",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/log-levels/lassie/httpserver",
+			body:       `{"level": "loud"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "admin set log level of unknown subsystem",
+			opts:       []HandlerOption{WithAdmin(true)},
+			method:     http.MethodPut,
+			path:       "/admin/log-levels/lassie/nonexistent",
+			body:       `{"level": "debug"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "admin requires authorization",
+			cfg:        HttpServerConfig{AccessToken: "secret"},
+			opts:       []HandlerOption{WithAdmin(true)},
+			path:       "/admin/retrievals",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.TempDir = t.TempDir()
+			handler := NewHandler(lassie, tt.cfg, tt.opts...)
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			require.Equal(t, tt.wantOrder, rr.Header().Values("X-Order"))
+			require.Contains(t, rr.Body.String(), tt.wantBody)
+		})
+	}
+}
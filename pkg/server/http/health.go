@@ -0,0 +1,209 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/filecoin-project/lassie/pkg/storage"
+)
+
+// HealthCheckTimeout is the maximum time a single health check may take
+// before it is considered to have failed.
+const HealthCheckTimeout = 5 * time.Second
+
+var errDiskSpaceUnsupported = errors.New("free disk space can't be determined on this platform")
+
+// HealthCheck is a named check of a dependency of the server, returning an
+// error if the dependency is unhealthy. Detail, if set, describes the current
+// state of the dependency, such as its usage, and is reported whether or not
+// the check passes.
+type HealthCheck struct {
+	Name   string
+	Check  func(ctx context.Context) error
+	Detail func() string
+}
+
+// HealthCheckResult is the JSON representation of the result of a single
+// HealthCheck.
+type HealthCheckResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthResponse is the JSON body returned by the health and readiness
+// endpoints.
+type HealthResponse struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+const (
+	healthStatusOk    = "ok"
+	healthStatusError = "error"
+)
+
+// HealthHandler returns a handler that runs the given checks concurrently and
+// responds with a JSON HealthResponse detailing the result of each. The status
+// code is 200 if all checks pass and 503 otherwise.
+func HealthHandler(checks ...HealthCheck) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+
+		results := make([]HealthCheckResult, len(checks))
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func(i int, check HealthCheck) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(req.Context(), HealthCheckTimeout)
+				defer cancel()
+				start := time.Now()
+				err := check.Check(ctx)
+				results[i] = HealthCheckResult{
+					Name:     check.Name,
+					Status:   healthStatusOk,
+					Duration: time.Since(start).String(),
+				}
+				if err != nil {
+					results[i].Status = healthStatusError
+					results[i].Error = err.Error()
+				}
+				if check.Detail != nil {
+					results[i].Detail = check.Detail()
+				}
+			}(i, check)
+		}
+		wg.Wait()
+
+		response := HealthResponse{Status: healthStatusOk, Checks: results}
+		code := http.StatusOK
+		for _, result := range results {
+			if result.Status != healthStatusOk {
+				response.Status = healthStatusError
+				code = http.StatusServiceUnavailable
+				logger.Warnw("health check failed", "path", req.URL.Path, "check", result.Name, "err", result.Error)
+			}
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		res.WriteHeader(code)
+		if err := json.NewEncoder(res).Encode(response); err != nil {
+			logger.Debugw("failed to write health response", "err", err)
+		}
+		statusLogger.logStatus(code, http.StatusText(code))
+	}
+}
+
+// checkTempDirWritable returns a check that verifies that temporary files, used
+// to stage retrieved blocks, can be created in the given directory.
+func checkTempDirWritable(tempDir string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(tempDir, "lassie-readyz-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write([]byte("ok")); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// checkTempSpace returns a check that fails when the filesystem of the given
+// directory has less than min bytes free for temporary files. A minimum of
+// zero means there is no minimum.
+func checkTempSpace(tempDir string, min uint64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if min == 0 {
+			return nil
+		}
+		free, _, err := diskSpace(tempDir)
+		if err != nil {
+			return err
+		}
+		if free < min {
+			return fmt.Errorf("%s free in temporary directory, below the minimum of %s", humanize.IBytes(free), humanize.IBytes(min))
+		}
+		return nil
+	}
+}
+
+// tempSpaceDetail describes the space available for temporary files in the
+// given directory, or nothing if it can't be determined.
+func tempSpaceDetail(tempDir string) func() string {
+	return func() string {
+		free, total, err := diskSpace(tempDir)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s free of %s", humanize.IBytes(free), humanize.IBytes(total))
+	}
+}
+
+// checkTempQuota returns a check that fails when the temporary storage in use
+// is at or above the quota's high water mark, so that new retrievals would be
+// held back.
+func checkTempQuota(quota *storage.TempQuota) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if !quota.Admits() {
+			return fmt.Errorf("%s of temporary storage in use, at or above the high water mark of %s", humanize.IBytes(quota.Used()), humanize.IBytes(quota.HighWater()))
+		}
+		return nil
+	}
+}
+
+// tempQuotaDetail describes the temporary storage in use against its quota.
+func tempQuotaDetail(quota *storage.TempQuota) func() string {
+	return func() string {
+		return fmt.Sprintf("%s of %s in use", humanize.IBytes(quota.Used()), humanize.IBytes(quota.Max()))
+	}
+}
+
+// checkCapacity returns a check that fails when the number of in-flight
+// retrieval requests has reached the maximum. A maximum of zero means there is
+// no limit.
+func checkCapacity(inflight *atomic.Int64, max uint) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if max == 0 {
+			return nil
+		}
+		if current := inflight.Load(); current >= int64(max) {
+			return fmt.Errorf("%d of %d concurrent requests in flight", current, max)
+		}
+		return nil
+	}
+}
+
+// trackInflight wraps a handler, counting the number of requests it is
+// currently serving.
+func trackInflight(inflight *atomic.Int64, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		inflight.Add(1)
+		defer inflight.Add(-1)
+		next(res, req)
+	}
+}
+
+// isHealthPath returns true for paths that are served without authorization so
+// that orchestrators can probe them.
+func isHealthPath(path string) bool {
+	return path == "/healthz" || path == "/readyz"
+}
@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("nope") }
+
+	tests := []struct {
+		name         string
+		method       string
+		checks       []HealthCheck
+		wantStatus   int
+		wantResponse HealthResponse
+	}{
+		{
+			name:         "no checks",
+			method:       http.MethodGet,
+			wantStatus:   http.StatusOK,
+			wantResponse: HealthResponse{Status: "ok", Checks: []HealthCheckResult{}},
+		},
+		{
+			name:       "all checks pass",
+			method:     http.MethodGet,
+			checks:     []HealthCheck{{Name: "a", Check: ok}, {Name: "b", Check: ok}},
+			wantStatus: http.StatusOK,
+			wantResponse: HealthResponse{Status: "ok", Checks: []HealthCheckResult{
+				{Name: "a", Status: "ok"},
+				{Name: "b", Status: "ok"},
+			}},
+		},
+		{
+			name:       "one check fails",
+			method:     http.MethodGet,
+			checks:     []HealthCheck{{Name: "a", Check: ok}, {Name: "b", Check: fail}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantResponse: HealthResponse{Status: "error", Checks: []HealthCheckResult{
+				{Name: "a", Status: "ok"},
+				{Name: "b", Status: "error", Error: "nope"},
+			}},
+		},
+		{
+			name:       "details",
+			method:     http.MethodGet,
+			checks:     []HealthCheck{{Name: "a", Check: ok, Detail: func() string { return "3 active" }}, {Name: "b", Check: fail, Detail: func() string { return "full" }}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantResponse: HealthResponse{Status: "error", Checks: []HealthCheckResult{
+				{Name: "a", Status: "ok", Detail: "3 active"},
+				{Name: "b", Status: "error", Error: "nope", Detail: "full"},
+			}},
+		},
+		{
+			name:       "non-GET request",
+			method:     http.MethodPost,
+			checks:     []HealthCheck{{Name: "a", Check: ok}},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/readyz", nil)
+			rr := httptest.NewRecorder()
+			HealthHandler(tt.checks...)(rr, req)
+
+			require.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusMethodNotAllowed {
+				return
+			}
+			require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			var response HealthResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			for i := range response.Checks {
+				require.NotEmpty(t, response.Checks[i].Duration)
+				response.Checks[i].Duration = ""
+			}
+			require.Equal(t, tt.wantResponse, response)
+		})
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	var inflight atomic.Int64
+	require.NoError(t, checkCapacity(&inflight, 0)(context.Background()))
+	require.NoError(t, checkCapacity(&inflight, 2)(context.Background()))
+
+	inflight.Store(2)
+	require.NoError(t, checkCapacity(&inflight, 0)(context.Background()))
+	require.EqualError(t, checkCapacity(&inflight, 2)(context.Background()), "2 of 2 concurrent requests in flight")
+}
+
+func TestCheckTempDirWritable(t *testing.T) {
+	require.NoError(t, checkTempDirWritable(t.TempDir())(context.Background()))
+	require.Error(t, checkTempDirWritable(filepath.Join(t.TempDir(), "missing"))(context.Background()))
+}
+
+func TestCheckTempSpace(t *testing.T) {
+	tempDir := t.TempDir()
+	free, total, err := diskSpace(tempDir)
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	require.NotZero(t, total)
+	require.NoError(t, checkTempSpace(tempDir, 0)(context.Background()))
+	require.NoError(t, checkTempSpace(tempDir, 1)(context.Background()))
+	require.ErrorContains(t, checkTempSpace(tempDir, free+total)(context.Background()), "below the minimum")
+	require.Contains(t, tempSpaceDetail(tempDir)(), " free of ")
+	require.Empty(t, tempSpaceDetail(filepath.Join(tempDir, "missing"))())
+}
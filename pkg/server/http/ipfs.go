@@ -4,23 +4,66 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/filecoin-project/lassie/pkg/accesslog"
 	"github.com/filecoin-project/lassie/pkg/build"
+	"github.com/filecoin-project/lassie/pkg/contentpath"
+	"github.com/filecoin-project/lassie/pkg/globpath"
 	"github.com/filecoin-project/lassie/pkg/heyfil"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/responsecache"
 	"github.com/filecoin-project/lassie/pkg/retriever"
 	"github.com/filecoin-project/lassie/pkg/storage"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipld/go-car/v2/storage/deferred"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	trustlesshttp "github.com/ipld/go-trustless-utils/http"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// HeaderPartialResult is the HTTP trailer set on a response when the
+// retrieval was ended early because the request's blockLimit, byteLimit or
+// timeLimit budget was reached.
+const HeaderPartialResult = "X-Lassie-Partial-Result"
+
+// HeaderRequestHash is the HTTP response header carrying the canonical hash
+// of the request, see types.RetrievalRequest#CanonicalHash, which clients may
+// use as a cache key for the response.
+const HeaderRequestHash = "X-Lassie-Request-Hash"
+
+// HeaderCache is the HTTP response header reporting whether a response was
+// served from the ResponseCache, "hit", or retrieved, "miss", when the server
+// has one and the request may be cached.
+const HeaderCache = "X-Lassie-Cache"
+
+// HeaderRetrievalID is the HTTP response header carrying the ID of the
+// retrieval serving the response, which may be used to cancel it through the
+// admin endpoints. A client may also send it as a request header, with a UUID,
+// to choose the retrieval's ID itself, so that it can follow the retrieval's
+// progress at /progress/{retrievalId} before the response starts.
+const HeaderRetrievalID = "X-Lassie-Retrieval-Id"
+
+// HeaderProviderAllowList and HeaderProviderBlockList are request headers
+// carrying comma separated peer IDs of the providers that may, or may not, be
+// used for the retrieval, in addition to the daemon's own lists.
+const (
+	HeaderProviderAllowList = "X-Lassie-Provider-Allow-List"
+	HeaderProviderBlockList = "X-Lassie-Provider-Block-List"
 )
 
 func IpfsHandler(fetcher types.Fetcher, cfg HttpServerConfig) func(http.ResponseWriter, *http.Request) {
@@ -31,7 +74,29 @@ func IpfsHandler(fetcher types.Fetcher, cfg HttpServerConfig) func(http.Response
 			return
 		}
 
-		ok, request := decodeRetrievalRequest(cfg, res, req, statusLogger)
+		// a request for no content is not found, whatever its Accept header
+		if ok, _, _ := decodeUrlPath(res, req, statusLogger); !ok {
+			return
+		}
+
+		// the representation of the content depends on the Accept header, so
+		// caches must not serve a response to requests with another
+		res.Header().Add("Vary", "Accept")
+		format, status, err := negotiateFormat(req)
+		if err != nil {
+			errorResponse(res, statusLogger, status, err)
+			return
+		}
+		if format.conversion != nil {
+			serveConversion(fetcher, cfg, *format.conversion, res, req, statusLogger)
+			return
+		}
+		if format.isRaw() {
+			serveRawBlock(fetcher, cfg, res, req, statusLogger)
+			return
+		}
+
+		ok, request := decodeRetrievalRequest(cfg, format.contentType, res, req, statusLogger)
 		if !ok {
 			return
 		}
@@ -41,26 +106,132 @@ func IpfsHandler(fetcher types.Fetcher, cfg HttpServerConfig) func(http.Response
 			return
 		}
 
+		ok, depth := decodeDepth(res, req, statusLogger, request)
+		if !ok {
+			return
+		}
+
+		ok, timeoutOpts := decodeGlobalTimeout(res, req, statusLogger)
+		if !ok {
+			return
+		}
+
+		glob := req.URL.Query().Get("glob") == "y"
+		if glob && depth > 0 {
+			errorResponse(res, statusLogger, http.StatusBadRequest, errors.New("depth can't be used with glob"))
+			return
+		}
+		if glob || depth > 0 {
+			// duplicates are on by default for CAR responses but can't be
+			// supported for custom selectors, respond with dups=n instead
+			request.Duplicates = false
+		}
+
+		// the Etag describes the request as made, before any glob expansion
+		etag := request.Etag()
+		if depth > 0 {
+			etag = etagWithSuffix(etag, fmt.Sprintf("depth-%d", depth))
+		}
+		if glob {
+			etag = etagWithSuffix(etag, "glob")
+			var err error
+			if request, err = globpath.Expand(req.Context(), fetcher, request); err != nil {
+				globErrorResponse(res, statusLogger, err)
+				return
+			}
+		}
+
+		// the request hash describes the content as retrieved, after any glob
+		// expansion and with the depth limit applied, so that it matches the
+		// RequestHash of the retrieval's stats
+		requestHash, err := requestHashWithDepth(request, depth)
+		if err != nil {
+			errorResponse(res, statusLogger, http.StatusInternalServerError, err)
+			return
+		}
+
+		log := logger.With(logging.RetrievalIDKey, request.RetrievalID)
+
 		// TODO: this needs to be propagated through the request, perhaps on
 		// RetrievalRequest or we decode it as a UUID and override RetrievalID?
 		requestId := req.Header.Get("X-Request-Id")
 		if requestId == "" {
 			requestId = request.RetrievalID.String()
 		} else {
-			logger.Debugw("custom X-Request-Id fore retrieval", "request_id", requestId, "retrieval_id", request.RetrievalID)
+			log.Debugw("custom X-Request-Id fore retrieval", "request_id", requestId)
+		}
+
+		// a complete response for the same content may be served from the
+		// cache, unless the client limits the request below the server's own
+		// block limit, expands a glob or has a progress stream, or asks for the
+		// content to be retrieved again. A response cut short by the server's
+		// limit is partial, and isn't cached.
+		cacheable := cfg.ResponseCache != nil && !glob && request.MaxBlocks == cfg.MaxBlocksPerRequest && request.MaxBytes == 0 && req.Header.Get(HeaderRetrievalID) == ""
+		if cacheable && !strings.Contains(req.Header.Get("Cache-Control"), "no-cache") {
+			if cached, size, ok := cfg.ResponseCache.Get(requestHash); ok {
+				defer cached.Close()
+				setCarResponseHeaders(res, req, request, fileName, etag, requestHash)
+				res.Header().Set("Content-Length", strconv.FormatUint(size, 10))
+				res.Header().Set("X-Trace-Id", requestId)
+				res.Header().Set(HeaderCache, "hit")
+				recordAccess(req.Context(), func(entry *accesslog.Entry) { entry.Cache = "hit" })
+				res.WriteHeader(http.StatusOK)
+				statusLogger.logStatus(200, "OK (cached)")
+				if _, err := io.Copy(res, cached); err != nil {
+					log.Debugw("unable to write cached response", "err", err)
+				}
+				return
+			}
+		}
+		if cfg.TempQuota != nil {
+			if err := cfg.TempQuota.Admit(req.Context(), cfg.TempQuotaTimeout); err != nil {
+				if req.Context().Err() != nil {
+					// the client has gone away, there's no one to respond to
+					log.Debugw("client went away while waiting for temporary storage", "path", req.URL.Path)
+					return
+				}
+				res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(queueRetryAfter.Seconds()))))
+				errorResponse(res, statusLogger, http.StatusServiceUnavailable, fmt.Errorf("%w, try again later", err))
+				return
+			}
+		}
+		var tempStore types.TempStore
+		if cfg.TempStore != nil {
+			if tempStore, err = cfg.TempStore(request.Root); err != nil {
+				errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("failed to create temporary store: %w", err))
+				return
+			}
+		} else if cfg.InMemory {
+			tempStore = storage.NewDeferredStorageCarInMemory(request.Root)
+		} else if cfg.InMemoryThreshold > 0 {
+			tempStore = storage.NewSpillingTempStore(cfg.TempDir, request.Root, cfg.InMemoryThreshold)
+		} else {
+			tempStore = storage.NewDeferredStorageCar(cfg.TempDir, request.Root)
+		}
+		if cfg.TempQuota != nil {
+			tempStore = cfg.TempQuota.Wrap(tempStore)
+		}
+
+		var out io.Writer = res
+		var cacheWriter *responsecache.Writer
+		if cacheable {
+			if cacheWriter, err = cfg.ResponseCache.NewWriter(requestHash); err != nil {
+				log.Warnw("unable to cache response", "err", err)
+			} else {
+				out = io.MultiWriter(res, cacheWriter)
+			}
 		}
 
-		tempStore := storage.NewDeferredStorageCar(cfg.TempDir, request.Root)
 		var carWriter storage.DeferredWriter
 		if request.Duplicates {
-			carWriter = storage.NewDuplicateAdderCarForStream(req.Context(), res, request.Root, request.Path, request.Scope, request.Bytes, tempStore)
+			carWriter = storage.NewDuplicateAdderCarForStream(req.Context(), out, request.Root, request.Path, request.Scope, request.Bytes, tempStore)
 		} else {
-			carWriter = deferred.NewDeferredCarWriterForStream(res, []cid.Cid{request.Root})
+			carWriter = deferred.NewDeferredCarWriterForStream(out, []cid.Cid{request.Root})
 		}
 		carStore := storage.NewCachingTempStore(carWriter.BlockWriteOpener(), tempStore)
 		defer func() {
 			if err := carStore.Close(); err != nil {
-				logger.Errorf("error closing temp store: %s", err)
+				log.Errorw("error closing temp store", "err", err)
 			}
 		}()
 
@@ -81,68 +252,111 @@ func IpfsHandler(fetcher types.Fetcher, cfg HttpServerConfig) func(http.Response
 
 		carWriter.OnPut(func(int) {
 			// called once we start writing blocks into the CAR (on the first Put())
-			res.Header().Set("Server", build.UserAgent) // "lassie/vx.y.z-<git commit hash>"
-			res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
-			res.Header().Set("Accept-Ranges", "none")
-			res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
-			res.Header().Set("Content-Type", trustlesshttp.DefaultContentType().WithDuplicates(request.Duplicates).String())
-			res.Header().Set("Etag", request.Etag())
-			res.Header().Set("X-Content-Type-Options", "nosniff")
-			res.Header().Set("X-Ipfs-Path", trustlessutils.PathEscape(req.URL.Path))
+			setCarResponseHeaders(res, req, request, fileName, etag, requestHash)
+			res.Header().Set(HeaderRetrievalID, request.RetrievalID.String())
 			res.Header().Set("X-Trace-Id", requestId)
+			res.Header().Set("Trailer", HeaderPartialResult)
+			if cacheable {
+				res.Header().Set(HeaderCache, "miss")
+				recordAccess(req.Context(), func(entry *accesslog.Entry) { entry.Cache = "miss" })
+			}
 			statusLogger.logStatus(200, "OK")
 			close(bytesWritten)
 		}, true)
 
-		logger.Debugw("fetching",
-			"retrieval_id", request.RetrievalID,
+		log.Debugw("fetching",
 			"root", request.Root.String(),
 			"path", request.Path,
 			"dag-scope", request.Scope,
 			"entity-bytes", request.Bytes,
 			"dups", request.Duplicates,
 			"maxBlocks", request.MaxBlocks,
+			"maxBytes", request.MaxBytes,
+			"depth", depth,
 		)
 
-		stats, err := fetcher.Fetch(req.Context(), request, types.WithEventsCallback(servertimingsSubscriber(req, bytesWritten)))
+		fetchOpts := append([]types.FetchOption{types.WithEventsCallback(accessLogSubscriber(req.Context(), servertimingsSubscriber(req, bytesWritten)))}, timeoutOpts...)
+		if depth > 0 {
+			fetchOpts = append(fetchOpts, types.WithMaxDepth(depth))
+		}
+		// only a retrieval whose ID the client chose can have a progress
+		// stream
+		if cfg.progress != nil && req.Header.Get(HeaderRetrievalID) != "" {
+			fetchOpts = append(fetchOpts, cfg.progress.fetchOptions()...)
+		}
+		// continue the caller's trace, if the request carries one, so the
+		// retrieval's spans are attributed to it
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		stats, err := fetcher.Fetch(ctx, request, fetchOpts...)
 
 		// force all blocks to flush
-		if cerr := carWriter.Close(); cerr != nil && !errors.Is(cerr, context.Canceled) {
-			logger.Infof("error closing car writer: %s", cerr)
+		cerr := carWriter.Close()
+		if cerr != nil && !errors.Is(cerr, context.Canceled) {
+			log.Infow("error closing car writer", "err", cerr)
+		}
+		if cacheWriter != nil {
+			// only a complete response is cached
+			if err == nil && cerr == nil && !stats.Partial {
+				if err := cacheWriter.Commit(); err != nil {
+					log.Debugw("response not cached", "err", err)
+				}
+			} else {
+				cacheWriter.Abort()
+			}
 		}
 
 		if err != nil {
 			select {
 			case <-bytesWritten:
-				logger.Debugw("unclean close", "cid", request.Root, "retrievalID", request.RetrievalID)
+				log.Debugw("unclean close", "cid", request.Root)
 				if err := closeWithUnterminatedChunk(res); err != nil {
-					logger.Infow("unable to send early termination", "err", err)
+					log.Infow("unable to send early termination", "err", err)
 				}
 				return
 			default:
 			}
-			if errors.Is(err, retriever.ErrNoCandidates) {
-				errorResponse(res, statusLogger, http.StatusBadGateway, errors.New("no candidates found"))
-			} else {
-				errorResponse(res, statusLogger, http.StatusGatewayTimeout, fmt.Errorf("failed to fetch CID: %w", err))
-			}
+			fetchErrorResponse(res, statusLogger, err)
 			return
 		}
 
-		logger.Debugw("successfully fetched",
-			"retrieval_id", request.RetrievalID,
+		if stats.Partial {
+			// the CAR is complete up to the budget, signal that it's a
+			// subset of the requested DAG
+			res.Header().Set(HeaderPartialResult, "budget-exceeded")
+		}
+
+		log.Debugw("successfully fetched",
 			"root", request.Root.String(),
 			"path", request.Path,
 			"dag-scope", request.Scope,
 			"entity-bytes", request.Bytes,
 			"dups", request.Duplicates,
 			"maxBlocks", request.MaxBlocks,
+			"maxBytes", request.MaxBytes,
+			"partial", stats.Partial,
 			"duration", stats.Duration,
 			"bytes", stats.Size,
+			"indexerQueries", stats.IndexerQueries,
+			"graphsyncQueries", stats.GraphsyncQueries,
+			"httpQueries", stats.HttpQueries,
 		)
 	}
 }
 
+// setCarResponseHeaders sets the headers of a CAR response to the request,
+// whether it is retrieved or served from the ResponseCache.
+func setCarResponseHeaders(res http.ResponseWriter, req *http.Request, request types.RetrievalRequest, fileName string, etag string, requestHash string) {
+	res.Header().Set("Server", build.UserAgent) // "lassie/vx.y.z-<git commit hash>"
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	res.Header().Set("Accept-Ranges", "none")
+	res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
+	res.Header().Set("Content-Type", trustlesshttp.DefaultContentType().WithDuplicates(request.Duplicates).String())
+	res.Header().Set("Etag", etag)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("X-Ipfs-Path", trustlessutils.PathEscape(req.URL.Path))
+	res.Header().Set(HeaderRequestHash, requestHash)
+}
+
 func checkGet(req *http.Request, res http.ResponseWriter, statusLogger *statusLogger) bool {
 	// filter out everything but GET requests
 	if req.Method == http.MethodGet {
@@ -153,37 +367,31 @@ func checkGet(req *http.Request, res http.ResponseWriter, statusLogger *statusLo
 	return false
 }
 
-func decodeRequest(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) (bool, trustlessutils.Request) {
-	rootCid, path, err := trustlesshttp.ParseUrlPath(req.URL.Path)
+func decodeUrlPath(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) (bool, cid.Cid, datamodel.Path) {
+	p, err := contentpath.ParsePath(req.URL.Path)
+	if err == nil && p.Namespace != contentpath.NamespaceIPFS {
+		err = contentpath.ErrNotContentPath
+	}
 	if err != nil {
-		if errors.Is(err, trustlesshttp.ErrPathNotFound) {
-			errorResponse(res, statusLogger, http.StatusNotFound, err)
-		} else if errors.Is(err, trustlesshttp.ErrBadCid) {
-			errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		if errors.Is(err, contentpath.ErrNotContentPath) {
+			errorResponse(res, statusLogger, http.StatusNotFound, trustlesshttp.ErrPathNotFound)
+		} else if errors.Is(err, contentpath.ErrBadCid) {
+			errorResponse(res, statusLogger, http.StatusBadRequest, contentpath.ErrBadCid)
 		} else {
 			errorResponse(res, statusLogger, http.StatusInternalServerError, err)
 		}
-		return false, trustlessutils.Request{}
+		return false, cid.Undef, datamodel.Path{}
 	}
+	return true, p.Root, p.Path
+}
 
-	accepts, err := trustlesshttp.CheckFormat(req)
-	if err != nil {
-		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+// decodeRequest decodes the trustless request for a CAR of the negotiated
+// content type.
+func decodeRequest(accept trustlesshttp.ContentType, res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) (bool, trustlessutils.Request) {
+	ok, rootCid, path := decodeUrlPath(res, req, statusLogger)
+	if !ok {
 		return false, trustlessutils.Request{}
 	}
-	// TODO: accepts[0] should be acceptable but it may be for a
-	// application/ipld.vnd.raw (IsRaw()) which we don't currently support; we
-	// should add support for it in the daemon and allow accepts[0] to be chosen.
-	var accept trustlesshttp.ContentType
-	for _, a := range accepts {
-		if a.IsCar() {
-			accept = a
-			break
-		}
-	}
-	if !accept.IsCar() {
-		errorResponse(res, statusLogger, http.StatusNotAcceptable, fmt.Errorf("invalid Accept header or format parameter; unsupported %q", req.Header.Get("Accept")))
-	}
 
 	dagScope, err := trustlesshttp.ParseScope(req)
 	if err != nil {
@@ -206,12 +414,18 @@ func decodeRequest(res http.ResponseWriter, req *http.Request, statusLogger *sta
 	}
 }
 
-func decodeRetrievalRequest(cfg HttpServerConfig, res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) (bool, types.RetrievalRequest) {
-	ok, request := decodeRequest(res, req, statusLogger)
+func decodeRetrievalRequest(cfg HttpServerConfig, accept trustlesshttp.ContentType, res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) (bool, types.RetrievalRequest) {
+	ok, request := decodeRequest(accept, res, req, statusLogger)
 	if !ok {
 		return false, types.RetrievalRequest{}
 	}
+	return newRetrievalRequest(cfg, res, req, statusLogger, request)
+}
 
+// newRetrievalRequest builds a RetrievalRequest for the given trustless
+// request, applying the protocol, provider and block limit parameters found in
+// the query string.
+func newRetrievalRequest(cfg HttpServerConfig, res http.ResponseWriter, req *http.Request, statusLogger *statusLogger, request trustlessutils.Request) (bool, types.RetrievalRequest) {
 	protocols, err := parseProtocols(req)
 	if err != nil {
 		errorResponse(res, statusLogger, http.StatusBadRequest, err)
@@ -236,8 +450,45 @@ func decodeRetrievalRequest(cfg HttpServerConfig, res http.ResponseWriter, req *
 		maxBlocks = cfg.MaxBlocksPerRequest
 	}
 
-	retrievalId, err := types.NewRetrievalID()
+	// extract byte limit from query param as needed
+	var maxBytes uint64
+	if req.URL.Query().Has("byteLimit") {
+		if parsedByteLimit, err := strconv.ParseUint(req.URL.Query().Get("byteLimit"), 10, 64); err == nil {
+			maxBytes = parsedByteLimit
+		}
+	}
+
+	// extract time limit, after which a partial result is served
+	maxDuration, err := parseTimeout(req, "timeLimit")
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		return false, types.RetrievalRequest{}
+	}
+
+	providerTimeout, err := parseTimeout(req, "providerTimeout")
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		return false, types.RetrievalRequest{}
+	}
+
+	allowList, err := parsePeerIDHeader(req, HeaderProviderAllowList)
 	if err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		return false, types.RetrievalRequest{}
+	}
+	blockList, err := parsePeerIDHeader(req, HeaderProviderBlockList)
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		return false, types.RetrievalRequest{}
+	}
+
+	var retrievalId types.RetrievalID
+	if id := req.Header.Get(HeaderRetrievalID); id != "" {
+		if err := retrievalId.UnmarshalText([]byte(id)); err != nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, fmt.Errorf("invalid %s header: %w", HeaderRetrievalID, err))
+			return false, types.RetrievalRequest{}
+		}
+	} else if retrievalId, err = types.NewRetrievalID(); err != nil {
 		errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("failed to generate retrieval ID: %w", err))
 		return false, types.RetrievalRequest{}
 	}
@@ -246,14 +497,83 @@ func decodeRetrievalRequest(cfg HttpServerConfig, res http.ResponseWriter, req *
 	linkSystem.TrustedStorage = true
 	unixfsnode.AddUnixFSReificationToLinkSystem(&linkSystem)
 
-	return true, types.RetrievalRequest{
-		Request:     request,
-		RetrievalID: retrievalId,
-		LinkSystem:  linkSystem,
-		Protocols:   protocols,
-		FixedPeers:  fixedPeers,
-		MaxBlocks:   maxBlocks,
+	retrievalRequest := types.RetrievalRequest{
+		Request:           request,
+		RetrievalID:       retrievalId,
+		LinkSystem:        linkSystem,
+		Protocols:         protocols,
+		FixedPeers:        fixedPeers,
+		MaxBlocks:         maxBlocks,
+		MaxBytes:          maxBytes,
+		MaxDuration:       maxDuration,
+		ProviderTimeout:   providerTimeout,
+		ProviderAllowList: allowList,
+		ProviderBlockList: blockList,
+	}
+	recordRetrieval(req.Context(), retrievalRequest)
+	return true, retrievalRequest
+}
+
+// parseTimeout parses an optional duration query parameter, such as "30s".
+func parseTimeout(req *http.Request, name string) (time.Duration, error) {
+	if !req.URL.Query().Has(name) {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(req.URL.Query().Get(name))
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return timeout, nil
+}
+
+// decodeGlobalTimeout parses the optional globalTimeout query parameter into
+// FetchOptions that override the timeout for the entire retrieval.
+func decodeGlobalTimeout(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) (bool, []types.FetchOption) {
+	timeout, err := parseTimeout(req, "globalTimeout")
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		return false, nil
+	}
+	if timeout == 0 {
+		return true, nil
+	}
+	return true, []types.FetchOption{types.WithGlobalTimeout(timeout)}
+}
+
+// requestHashWithDepth returns the canonical hash of the request once the
+// depth limit, if any, has been applied to it.
+func requestHashWithDepth(request types.RetrievalRequest, depth uint64) (string, error) {
+	if depth > 0 {
+		var err error
+		if request, err = request.WithMaxDepth(depth); err != nil {
+			return "", err
+		}
+	}
+	return request.CanonicalHash()
+}
+
+// decodeDepth parses the optional depth query parameter, checking that it can
+// be applied to the request.
+func decodeDepth(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger, request types.RetrievalRequest) (bool, uint64) {
+	if !req.URL.Query().Has("depth") {
+		return true, 0
+	}
+	depth, err := strconv.ParseUint(req.URL.Query().Get("depth"), 10, 64)
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, errors.New("invalid depth parameter"))
+		return false, 0
+	}
+	if _, err := request.WithMaxDepth(depth); err != nil {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+		return false, 0
 	}
+	return true, depth
+}
+
+// etagWithSuffix extends a quoted Etag to distinguish responses for requests
+// with parameters that aren't part of the Trustless Gateway specification.
+func etagWithSuffix(etag string, suffix string) string {
+	return strings.TrimSuffix(etag, `"`) + "." + suffix + `"`
 }
 
 func decodeFilename(res http.ResponseWriter, req *http.Request, statusLogger *statusLogger, root cid.Cid) (bool, string) {
@@ -281,7 +601,7 @@ func newStatusLogger(method string, path string) *statusLogger {
 
 // logStatus logs the method, path, status code and message
 func (l statusLogger) logStatus(statusCode int, message string) {
-	logger.Infof("%s\t%s\t%d: %s\n", l.method, l.path, statusCode, message)
+	logger.Infow("response", "method", l.method, "path", l.path, "status", statusCode, "message", message)
 }
 
 func parseProtocols(req *http.Request) ([]multicodec.Code, error) {
@@ -309,12 +629,68 @@ func parseProviders(req *http.Request) ([]peer.AddrInfo, error) {
 	return nil, nil
 }
 
+// parsePeerIDHeader parses the comma separated peer IDs in each instance of
+// the named header into a set, returning nil if the header isn't present.
+func parsePeerIDHeader(req *http.Request, name string) (map[peer.ID]bool, error) {
+	values := req.Header.Values(name)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	peers := make(map[peer.ID]bool)
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			peerID, err := peer.Decode(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s header", name)
+			}
+			peers[peerID] = true
+		}
+	}
+	return peers, nil
+}
+
 // errorResponse logs and replies to the request with the status code and error
 func errorResponse(res http.ResponseWriter, statusLogger *statusLogger, code int, err error) {
 	statusLogger.logStatus(code, err.Error())
 	http.Error(res, err.Error(), code)
 }
 
+// fetchErrorResponse replies to the request with an appropriate status code
+// for an error returned by a Fetch
+func fetchErrorResponse(res http.ResponseWriter, statusLogger *statusLogger, err error) {
+	if errors.Is(err, retriever.ErrNoCandidates) {
+		errorResponse(res, statusLogger, http.StatusBadGateway, errors.New("no candidates found"))
+	} else if errors.Is(err, retriever.ErrNoProtocolsEnabled) {
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+	} else if errors.Is(err, retriever.ErrRetrievalAlreadyRunning) {
+		errorResponse(res, statusLogger, http.StatusConflict, err)
+	} else if errors.Is(err, lassie.ErrRetrievalCancelled) {
+		errorResponse(res, statusLogger, http.StatusServiceUnavailable, err)
+	} else {
+		errorResponse(res, statusLogger, http.StatusGatewayTimeout, fmt.Errorf("failed to fetch CID: %w", err))
+	}
+}
+
+// globErrorResponse replies to the request with an appropriate status code
+// for an error returned by a glob path expansion
+func globErrorResponse(res http.ResponseWriter, statusLogger *statusLogger, err error) {
+	switch {
+	case errors.Is(err, globpath.ErrNoMatches):
+		errorResponse(res, statusLogger, http.StatusNotFound, err)
+	case errors.Is(err, globpath.ErrGlobWithByteRange),
+		errors.Is(err, globpath.ErrGlobWithDuplicates),
+		errors.Is(err, globpath.ErrGlobWithSelector),
+		errors.Is(err, path.ErrBadPattern):
+		errorResponse(res, statusLogger, http.StatusBadRequest, err)
+	default:
+		fetchErrorResponse(res, statusLogger, err)
+	}
+}
+
 // closeWithUnterminatedChunk attempts to take control of the the http conn and terminate the stream early
 func closeWithUnterminatedChunk(res http.ResponseWriter) error {
 	hijacker, ok := res.(http.Hijacker)
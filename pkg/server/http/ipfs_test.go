@@ -3,18 +3,51 @@ package httpserver
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/filecoin-project/lassie/pkg/internal/mockfetcher"
 	"github.com/filecoin-project/lassie/pkg/retriever"
 	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
 
 func TestIpfsHandler(t *testing.T) {
+	cborNode := fluent.MustBuildMap(basicnode.Prototype.Map, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("hello").AssignString("world")
+		ma.AssembleEntry("num").AssignInt(42)
+	})
+	cborLp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.DagCbor),
+		MhType:   uint64(multicodec.Sha2_256),
+		MhLength: 32,
+	}}
+	lsys := cidlink.DefaultLinkSystem()
+	cborLink, err := lsys.ComputeLink(cborLp, cborNode)
+	require.NoError(t, err)
+	cborCid := cborLink.(cidlink.Link).Cid
+	cborBytes, err := ipld.Encode(cborNode, dagcbor.Encode)
+	require.NoError(t, err)
+	storeCborNode := func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+		_, err := r.LinkSystem.Store(linking.LinkContext{Ctx: ctx}, cborLp, cborNode)
+		require.NoError(t, err)
+		return &types.RetrievalStats{}, nil
+	}
+
 	tests := []struct {
 		name             string
 		fetchFunc        func(ctx context.Context, request types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error)
@@ -42,37 +75,184 @@ func TestIpfsHandler(t *testing.T) {
 			wantBody:   "not found\n",
 		},
 		{
-			name:       "400 on invalid Accept header - mime type",
+			name:       "406 on invalid Accept header - mime type",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			headers:    map[string]string{"Accept": "text/html"},
+			wantStatus: http.StatusNotAcceptable,
+			wantBody:   "invalid Accept header; unsupported: \"text/html\"\n",
+		},
+		{
+			name:       "406 on dag-json conversion of UnixFS root",
 			method:     "GET",
 			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
-			headers:    map[string]string{"Accept": "application/json"},
+			headers:    map[string]string{"Accept": "application/vnd.ipld.dag-json"},
+			wantStatus: http.StatusNotAcceptable,
+			wantBody:   "conversion to application/vnd.ipld.dag-json is not supported for UnixFS data\n",
+		},
+		{
+			name:    "dag-json conversion of dag-cbor root via Accept header",
+			method:  "GET",
+			path:    "/ipfs/" + cborCid.String(),
+			headers: map[string]string{"Accept": "application/vnd.ipld.dag-json"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.Equal(t, cborCid, r.Root)
+				require.Equal(t, trustlessutils.DagScopeBlock, r.Scope)
+				_, err := r.LinkSystem.Store(linking.LinkContext{Ctx: ctx}, cborLp, cborNode)
+				require.NoError(t, err)
+				return &types.RetrievalStats{}, nil
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.dag-json"},
+			wantBody:    `{"hello":"world","num":42}`,
+		},
+		{
+			name:   "dag-cbor conversion of dag-cbor path via format parameter",
+			method: "GET",
+			path:   "/ipfs/" + cborCid.String() + "/hello?format=dag-cbor",
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.Equal(t, "hello", r.Path)
+				_, err := r.LinkSystem.Store(linking.LinkContext{Ctx: ctx}, cborLp, cborNode)
+				require.NoError(t, err)
+				return &types.RetrievalStats{}, nil
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.dag-cbor"},
+			wantBody:    "eworld",
+		},
+		{
+			name:    "502 on conversion when no candidates can be found",
+			method:  "GET",
+			path:    "/ipfs/" + cborCid.String(),
+			headers: map[string]string{"Accept": "application/json"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				return nil, retriever.ErrNoCandidates
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "no candidates found\n",
+		},
+		{
+			name:    "400 when none of the requested protocols are enabled",
+			method:  "GET",
+			path:    "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?protocols=graphsync",
+			headers: map[string]string{"Accept": "application/vnd.ipld.car"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.Equal(t, []multicodec.Code{multicodec.TransportGraphsyncFilecoinv1}, r.Protocols)
+				return nil, fmt.Errorf("%w: %v", retriever.ErrNoProtocolsEnabled, r.Protocols)
+			},
 			wantStatus: http.StatusBadRequest,
-			wantBody:   "invalid Accept header; unsupported: \"application/json\"\n",
+			wantBody:   "none of the requested protocols are enabled: [transport-graphsync-filecoinv1]\n",
 		},
 		{
-			name:       "400 on invalid Accept header - bad dups",
+			name:       "406 on invalid Accept header - bad dups",
 			method:     "GET",
 			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			headers:    map[string]string{"Accept": "application/vnd.ipld.car;dups=invalid"},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusNotAcceptable,
 			wantBody:   "invalid Accept header; unsupported: \"application/vnd.ipld.car;dups=invalid\"\n",
 		},
 		{
-			name:       "400 on invalid Accept header - bad version",
+			name:       "406 on invalid Accept header - bad version",
 			method:     "GET",
 			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			headers:    map[string]string{"Accept": "application/vnd.ipld.car;version=2"},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusNotAcceptable,
 			wantBody:   "invalid Accept header; unsupported: \"application/vnd.ipld.car;version=2\"\n",
 		},
 		{
-			name:       "400 on invalid Accept header - bad order",
+			name:       "406 on invalid Accept header - bad order",
 			method:     "GET",
 			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
 			headers:    map[string]string{"Accept": "application/vnd.ipld.car;order=invalid"},
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusNotAcceptable,
 			wantBody:   "invalid Accept header; unsupported: \"application/vnd.ipld.car;order=invalid\"\n",
 		},
+		{
+			name:       "406 on CAR with a q of 0",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car;q=0"},
+			wantStatus: http.StatusNotAcceptable,
+			wantBody:   "invalid Accept header; unsupported: \"application/vnd.ipld.car;q=0\"\n",
+		},
+		{
+			name:    "raw block via Accept header",
+			method:  "GET",
+			path:    "/ipfs/" + cborCid.String(),
+			headers: map[string]string{"Accept": "application/vnd.ipld.raw"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.Equal(t, cborCid, r.Root)
+				require.Equal(t, trustlessutils.DagScopeBlock, r.Scope)
+				return storeCborNode(ctx, r, cb)
+			},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type": "application/vnd.ipld.raw",
+				"Etag":         `"` + cborCid.String() + `.raw"`,
+				"Vary":         "Accept",
+			},
+			wantBody: string(cborBytes),
+		},
+		{
+			name:        "raw block via format parameter",
+			method:      "GET",
+			path:        "/ipfs/" + cborCid.String() + "?format=raw",
+			fetchFunc:   storeCborNode,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.raw"},
+			wantBody:    string(cborBytes),
+		},
+		{
+			name:       "400 on raw block with a path",
+			method:     "GET",
+			path:       "/ipfs/" + cborCid.String() + "/hello",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.raw"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "a path can't be requested as a raw block, request a CAR instead\n",
+		},
+		{
+			name:        "most preferred of the acceptable formats",
+			method:      "GET",
+			path:        "/ipfs/" + cborCid.String(),
+			headers:     map[string]string{"Accept": "text/html, application/vnd.ipld.car;q=0.5, application/vnd.ipld.dag-json;q=0.9"},
+			fetchFunc:   storeCborNode,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.dag-json"},
+			wantBody:    `{"hello":"world","num":42}`,
+		},
+		{
+			name:    "CAR with the dups of the Accept header via format parameter",
+			method:  "GET",
+			path:    "/ipfs/" + cborCid.String() + "?format=car",
+			headers: map[string]string{"Accept": "application/vnd.ipld.car;dups=n"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.False(t, r.Duplicates)
+				return storeCborNode(ctx, r, cb)
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.car;version=1;order=dfs;dups=n", "Vary": "Accept"},
+		},
+		{
+			name:    "CAR of unknown order",
+			method:  "GET",
+			path:    "/ipfs/" + cborCid.String(),
+			headers: map[string]string{"Accept": "application/vnd.ipld.car;order=unk;dups=y"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.True(t, r.Duplicates)
+				return storeCborNode(ctx, r, cb)
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.car;version=1;order=dfs;dups=y"},
+		},
+		{
+			name:        "CAR via wildcard",
+			method:      "GET",
+			path:        "/ipfs/" + cborCid.String(),
+			headers:     map[string]string{"Accept": "*/*"},
+			fetchFunc:   storeCborNode,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/vnd.ipld.car;version=1;order=dfs;dups=y"},
+		},
 		{
 			name:       "400 on invalid format query param",
 			method:     "GET",
@@ -143,6 +323,114 @@ func TestIpfsHandler(t *testing.T) {
 			wantStatus: http.StatusBadRequest,
 			wantBody:   "invalid entity-bytes parameter\n",
 		},
+		{
+			name:       "400 on glob query parameter with entity-bytes",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/logs/*?glob=y&entity-bytes=0:100",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "glob paths can't be used with a byte range\n",
+		},
+		{
+			name:       "400 on invalid depth query parameter",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?depth=nope",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid depth parameter\n",
+		},
+		{
+			name:    "providerTimeout query parameter",
+			method:  "GET",
+			path:    "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?providerTimeout=5s&globalTimeout=1m",
+			headers: map[string]string{"Accept": "application/vnd.ipld.car"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.Equal(t, 5*time.Second, r.ProviderTimeout)
+				return nil, retriever.ErrNoCandidates
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "no candidates found\n",
+		},
+		{
+			name:   "provider allow and block list headers",
+			method: "GET",
+			path:   "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			headers: map[string]string{
+				"Accept":                       "application/vnd.ipld.car",
+				"X-Lassie-Provider-Allow-List": "12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4, 12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys",
+				"X-Lassie-Provider-Block-List": "12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys",
+			},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				allowed, err := peer.Decode("12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4")
+				require.NoError(t, err)
+				blocked, err := peer.Decode("12D3KooWPNbkEgjdBNeaCGpsgCrPRETe4uBZf1ShFXStobdN18ys")
+				require.NoError(t, err)
+				require.Len(t, r.ProviderAllowList, 2)
+				require.Equal(t, map[peer.ID]bool{blocked: true}, r.ProviderBlockList)
+				require.True(t, r.IsAcceptableProvider(allowed))
+				require.False(t, r.IsAcceptableProvider(blocked))
+				require.False(t, r.IsAcceptableProvider(peer.ID("other")))
+				return nil, retriever.ErrNoCandidates
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "no candidates found\n",
+		},
+		{
+			name:   "400 on invalid provider block list header",
+			method: "GET",
+			path:   "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4",
+			headers: map[string]string{
+				"Accept":                       "application/vnd.ipld.car",
+				"X-Lassie-Provider-Block-List": "not-a-peer",
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid X-Lassie-Provider-Block-List header\n",
+		},
+		{
+			name:       "400 on invalid providerTimeout query parameter",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?providerTimeout=soon",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid providerTimeout parameter\n",
+		},
+		{
+			name:       "400 on invalid globalTimeout query parameter",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?globalTimeout=-1s",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid globalTimeout parameter\n",
+		},
+		{
+			name:       "400 on depth query parameter with dag-scope=entity",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4?depth=1&dag-scope=entity",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid depth limit: can only be used with dag-scope=all and no entity-bytes\n",
+		},
+		{
+			name:       "400 on depth query parameter with glob",
+			method:     "GET",
+			path:       "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/*?depth=1&glob=y",
+			headers:    map[string]string{"Accept": "application/vnd.ipld.car"},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "depth can't be used with glob\n",
+		},
+		{
+			name:    "502 on glob query parameter when the directory has no candidates",
+			method:  "GET",
+			path:    "/ipfs/bafybeic56z3yccnla3cutmvqsn5zy3g24muupcsjtoyp3pu5pm5amurjx4/logs/*?glob=y",
+			headers: map[string]string{"Accept": "application/vnd.ipld.car"},
+			fetchFunc: func(ctx context.Context, r types.RetrievalRequest, cb func(types.RetrievalEvent)) (*types.RetrievalStats, error) {
+				require.Equal(t, "logs", r.Path)
+				require.Equal(t, trustlessutils.DagScopeEntity, r.Scope)
+				return nil, retriever.ErrNoCandidates
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "no candidates found\n",
+		},
 		{
 			name:    "404 when no candidates can be found",
 			method:  "GET",
@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/contentpath"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/ipns"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+// IpnsHandler serves /ipns/ requests by resolving the name with the
+// configured HttpServerConfig.IpnsResolver, caching the resolution within the
+// IpnsMaxAge and IpnsMaxStale bounds, and then serving the content it points
+// to as IpfsHandler does. As the name may later point elsewhere, the response
+// may only be cached by clients for as long as the resolution.
+func IpnsHandler(fetcher types.Fetcher, cfg HttpServerConfig) func(http.ResponseWriter, *http.Request) {
+	return ipnsHandler(fetcher, cfg, newIpnsCache(cfg))
+}
+
+// newIpnsCache returns the cache of the resolutions of cfg.IpnsResolver.
+func newIpnsCache(cfg HttpServerConfig) *ipnsresolver.CachingResolver {
+	return ipnsresolver.NewCachingResolver(cfg.IpnsResolver, cfg.IpnsMaxAge, cfg.IpnsMaxStale)
+}
+
+func ipnsHandler(fetcher types.Fetcher, cfg HttpServerConfig, resolver *ipnsresolver.CachingResolver) func(http.ResponseWriter, *http.Request) {
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(cfg.IpnsMaxAge.Seconds()))
+	if cfg.IpnsMaxStale > 0 {
+		cacheControl += fmt.Sprintf(", stale-while-revalidate=%d", int(cfg.IpnsMaxStale.Seconds()))
+	}
+	ipfsHandler := IpfsHandler(fetcher, cfg)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+
+		p, err := contentpath.ParsePath(req.URL.Path)
+		if err == nil && p.Namespace != contentpath.NamespaceIPNS {
+			err = contentpath.ErrNotContentPath
+		}
+		if err != nil {
+			errorResponse(res, statusLogger, http.StatusNotFound, trustlesshttp.ErrPathNotFound)
+			return
+		}
+		if _, err := ipns.NameFromString(p.Name); err != nil && !ipnsresolver.IsDNSLinkName(p.Name) {
+			errorResponse(res, statusLogger, http.StatusBadRequest, fmt.Errorf("invalid IPNS name %q: %w", p.Name, err))
+			return
+		}
+
+		start := time.Now()
+		root, path, err := resolver.Resolve(req.Context(), p.Name)
+		if err != nil {
+			errorResponse(res, statusLogger, http.StatusBadGateway, fmt.Errorf("failed to resolve /ipns/%s: %w", p.Name, err))
+			return
+		}
+		logger.Debugw("resolved IPNS name", "name", p.Name, "root", root, "path", path, "duration", time.Since(start))
+
+		ipfsReq := req.Clone(req.Context())
+		ipfsReq.URL.Path = p.Resolved(root, path).String()
+		ipfsReq.URL.RawPath = ""
+		ipfsHandler(&ipnsResponseWriter{
+			ResponseWriter: res,
+			ipnsPath:       trustlessutils.PathEscape(req.URL.Path),
+			cacheControl:   cacheControl,
+		}, ipfsReq)
+	}
+}
+
+// ipnsResponseWriter adjusts the headers of a successful /ipfs/ response to
+// describe the /ipns/ request it serves.
+type ipnsResponseWriter struct {
+	http.ResponseWriter
+	ipnsPath     string
+	cacheControl string
+}
+
+func (w *ipnsResponseWriter) setHeaders() {
+	header := w.ResponseWriter.Header()
+	if header.Get("Cache-Control") != "" {
+		header.Set("Cache-Control", w.cacheControl)
+	}
+	if header.Get("X-Ipfs-Path") != "" {
+		header.Set("X-Ipfs-Path", w.ipnsPath)
+	}
+}
+
+func (w *ipnsResponseWriter) WriteHeader(statusCode int) {
+	w.setHeaders()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *ipnsResponseWriter) Write(b []byte) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack allows an /ipns/ response to be terminated early, as an /ipfs/
+// response is on a failed retrieval.
+func (w *ipnsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("unable to access hijack interface")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+// responseFormat is the representation that a request is responded with: a
+// CAR, with the dups parameter of its contentType, a raw block, or a block
+// converted to another codec.
+type responseFormat struct {
+	contentType trustlesshttp.ContentType
+	conversion  *conversionCodec
+}
+
+func (f responseFormat) isRaw() bool {
+	return f.conversion == nil && f.contentType.IsRaw()
+}
+
+func carFormat() responseFormat {
+	return responseFormat{contentType: trustlesshttp.DefaultContentType()}
+}
+
+func rawFormat() responseFormat {
+	return responseFormat{contentType: trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)}
+}
+
+// negotiateFormat chooses the responseFormat of a request from its format
+// query parameter, which takes precedence, or else its Accept header,
+// returning the status to respond with if there is none that the server can
+// respond with. A format=car request takes the parameters of the CAR from the
+// Accept header, if it has them.
+func negotiateFormat(req *http.Request) (responseFormat, int, error) {
+	accept := req.Header.Get("Accept")
+	if format := req.URL.Query().Get("format"); format != "" {
+		switch format {
+		case trustlesshttp.FormatParameterCar:
+			for _, accepted := range acceptedFormats(accept) {
+				if accepted.conversion == nil && accepted.contentType.MimeType == trustlesshttp.MimeTypeCar {
+					return accepted, 0, nil
+				}
+			}
+			return carFormat(), 0, nil
+		case trustlesshttp.FormatParameterRaw:
+			return rawFormat(), 0, nil
+		}
+		if mimeType, ok := conversionFormats[format]; ok {
+			cc := conversionCodecs[mimeType]
+			return responseFormat{conversion: &cc}, 0, nil
+		}
+		return responseFormat{}, http.StatusBadRequest, fmt.Errorf("invalid format parameter; unsupported: %q", format)
+	}
+
+	if accept == "" {
+		return responseFormat{}, http.StatusBadRequest, errors.New("neither a valid Accept header nor format parameter were provided")
+	}
+	accepted := acceptedFormats(accept)
+	if len(accepted) == 0 {
+		return responseFormat{}, http.StatusNotAcceptable, fmt.Errorf("invalid Accept header; unsupported: %q", accept)
+	}
+	return accepted[0], 0, nil
+}
+
+// acceptedFormats returns the formats of the media types in an Accept header
+// that the server can respond with, most preferred first. The */* and
+// application/* wildcards are a CAR. A media type with a q of 0 isn't
+// acceptable, and neither is a CAR with a version, order or dups parameter
+// that the server can't satisfy; an order of unk is satisfied by the
+// depth-first order of every CAR the server responds with.
+func acceptedFormats(accept string) []responseFormat {
+	var accepted []responseFormat
+	for _, mediaType := range strings.Split(accept, ",") {
+		if strings.TrimSpace(mediaType) == "" {
+			continue
+		}
+		var format responseFormat
+		if contentTypes := trustlesshttp.ParseAccept(mediaType); len(contentTypes) == 1 {
+			format.contentType = contentTypes[0]
+			if format.contentType.IsCar() {
+				format.contentType.MimeType = trustlesshttp.MimeTypeCar
+			}
+		} else if cc, ok := conversionCodecs[strings.TrimSpace(strings.Split(mediaType, ";")[0])]; ok {
+			quality, ok := parseQuality(mediaType)
+			if !ok {
+				continue
+			}
+			format = responseFormat{contentType: trustlesshttp.ContentType{MimeType: cc.mimeType, Quality: quality}, conversion: &cc}
+		} else {
+			continue
+		}
+		if format.contentType.Quality > 0 {
+			accepted = append(accepted, format)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].contentType.Quality > accepted[j].contentType.Quality
+	})
+	return accepted
+}
+
+// parseQuality returns the q parameter of a media type, 1 if it has none.
+func parseQuality(mediaType string) (float32, bool) {
+	for _, param := range strings.Split(mediaType, ";")[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.TrimSpace(name) != "q" {
+			continue
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+		if err != nil || quality < 0 || quality > 1 {
+			return 0, false
+		}
+		return float32(quality), true
+	}
+	return 1, true
+}
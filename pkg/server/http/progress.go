@@ -0,0 +1,317 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const progressPath = "/progress"
+
+const (
+	// progressWaitTimeout is how long a progress stream waits for its
+	// retrieval to start before giving up.
+	progressWaitTimeout = time.Minute
+	// progressKeepAlive is the interval of the comments that keep an idle
+	// progress stream from being closed by proxies.
+	progressKeepAlive = 15 * time.Second
+	// progressMaxPending is the number of frames a stream holds for a slow
+	// client, beyond which its oldest event frames are dropped.
+	progressMaxPending = 256
+	// progressRetention is how long the frames of a finished retrieval are
+	// kept for streams that are opened after it finished.
+	progressRetention = time.Minute
+)
+
+// progressFrame is a single Server-Sent Event of a progress stream, an event
+// named after the retrieval event code, "progress" for a
+// types.ProgressUpdate, or "error".
+type progressFrame struct {
+	event string
+	data  interface{}
+}
+
+// ProgressUpdateFrame is the data of a "progress" event, a
+// types.ProgressUpdate.
+type ProgressUpdateFrame struct {
+	RetrievalID    types.RetrievalID   `json:"retrievalId"`
+	Phase          types.ProgressPhase `json:"phase"`
+	BytesReceived  uint64              `json:"bytesReceived"`
+	BlocksVerified uint64              `json:"blocksVerified"`
+	BytesVerified  uint64              `json:"bytesVerified"`
+	Provider       peer.ID             `json:"provider,omitempty"`
+	Protocol       string              `json:"protocol,omitempty"`
+	ElapsedMs      int64               `json:"elapsedMs"`
+	EtaMs          int64               `json:"etaMs,omitempty"`
+	Error          string              `json:"error,omitempty"`
+}
+
+// RetrievalEventFrame is the data of an event named after the code of a
+// retrieval event, such as "candidates-found" or "first-byte".
+type RetrievalEventFrame struct {
+	RetrievalID types.RetrievalID `json:"retrievalId"`
+	Code        types.EventCode   `json:"code"`
+	Time        time.Time         `json:"time"`
+	Provider    string            `json:"provider,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	Candidates  *int              `json:"candidates,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+func newProgressUpdateFrame(update types.ProgressUpdate) progressFrame {
+	frame := ProgressUpdateFrame{
+		RetrievalID:    update.RetrievalID,
+		Phase:          update.Phase,
+		BytesReceived:  update.BytesReceived,
+		BlocksVerified: update.BlocksVerified,
+		BytesVerified:  update.BytesVerified,
+		Provider:       update.Provider,
+		ElapsedMs:      update.Elapsed.Milliseconds(),
+		EtaMs:          update.ETA.Milliseconds(),
+	}
+	if update.Protocol != 0 {
+		frame.Protocol = update.Protocol.String()
+	}
+	if update.Err != nil {
+		frame.Error = update.Err.Error()
+	}
+	return progressFrame{event: "progress", data: frame}
+}
+
+func newRetrievalEventFrame(event types.RetrievalEvent) progressFrame {
+	frame := RetrievalEventFrame{
+		RetrievalID: event.RetrievalId(),
+		Code:        event.Code(),
+		Time:        event.Time(),
+		Provider:    events.Identifier(event),
+	}
+	if evt, ok := event.(events.EventWithProtocol); ok {
+		frame.Protocol = evt.Protocol().String()
+	}
+	if evt, ok := event.(events.EventWithCandidates); ok {
+		candidates := len(evt.Candidates())
+		frame.Candidates = &candidates
+	}
+	if evt, ok := event.(events.EventWithErrorMessage); ok {
+		frame.Error = evt.ErrorMessage()
+	}
+	return progressFrame{event: string(event.Code()), data: frame}
+}
+
+// progressStream holds the frames of a retrieval for its progress streams.
+type progressStream struct {
+	frames   []progressFrame
+	dropped  int // the number of frames dropped from the start of frames
+	finished time.Time
+	watchers map[*progressWatcher]struct{}
+}
+
+// progressStreams delivers the progress of the retrievals of the /ipfs/ and
+// /ipns/ endpoints to the progress streams of /progress/{retrievalId}. The
+// frames of a retrieval are held from when it starts, or a stream is opened
+// for it, until a minute after it has finished, so that a stream opened
+// shortly before or after the retrieval starts sees all of it.
+type progressStreams struct {
+	lk      sync.Mutex
+	streams map[types.RetrievalID]*progressStream
+}
+
+func newProgressStreams() *progressStreams {
+	return &progressStreams{streams: make(map[types.RetrievalID]*progressStream)}
+}
+
+// stream returns the stream of the retrieval, creating it if needed, and
+// drops those that finished more than progressRetention ago. Must be called
+// with the lock held.
+func (ps *progressStreams) stream(id types.RetrievalID) *progressStream {
+	now := time.Now()
+	for streamID, stream := range ps.streams {
+		if len(stream.watchers) == 0 && !stream.finished.IsZero() && now.Sub(stream.finished) > progressRetention {
+			delete(ps.streams, streamID)
+		}
+	}
+	stream, ok := ps.streams[id]
+	if !ok {
+		stream = &progressStream{watchers: make(map[*progressWatcher]struct{})}
+		ps.streams[id] = stream
+	}
+	return stream
+}
+
+func (ps *progressStreams) publish(id types.RetrievalID, frame progressFrame, finished bool) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	stream := ps.stream(id)
+	if !stream.finished.IsZero() {
+		// the "finished" progress event is always the last of a stream
+		return
+	}
+	if len(stream.frames) >= progressMaxPending {
+		// drop the oldest frame, a client that far behind is better served by
+		// the most recent ones
+		stream.frames = stream.frames[1:]
+		stream.dropped++
+	}
+	stream.frames = append(stream.frames, frame)
+	if finished {
+		stream.finished = time.Now()
+	}
+	for watcher := range stream.watchers {
+		select {
+		case watcher.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// fetchOptions returns the options that publish the progress and events of a
+// retrieval.
+func (ps *progressStreams) fetchOptions() []types.FetchOption {
+	return []types.FetchOption{
+		types.WithProgress(func(update types.ProgressUpdate) {
+			ps.publish(update.RetrievalID, newProgressUpdateFrame(update), update.Phase == types.ProgressFinished)
+		}),
+		types.WithSubscriber(func(event types.RetrievalEvent) {
+			// the "progress" events count the blocks received
+			if event.Code() != types.BlockReceivedCode {
+				ps.publish(event.RetrievalId(), newRetrievalEventFrame(event), false)
+			}
+		}),
+	}
+}
+
+// progressWatcher reads the frames of a retrieval for a single progress
+// stream.
+type progressWatcher struct {
+	ps     *progressStreams
+	id     types.RetrievalID
+	stream *progressStream
+	next   int // the index of the next frame, counting dropped frames
+	notify chan struct{}
+}
+
+// watch registers a progress stream for the retrieval. The watcher must be
+// closed once the stream is.
+func (ps *progressStreams) watch(id types.RetrievalID) *progressWatcher {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	watcher := &progressWatcher{ps: ps, id: id, stream: ps.stream(id), notify: make(chan struct{}, 1)}
+	watcher.stream.watchers[watcher] = struct{}{}
+	return watcher
+}
+
+// take returns the frames published since the last call, whether anything
+// has been published at all and whether the retrieval has finished.
+func (w *progressWatcher) take() (frames []progressFrame, started bool, finished bool) {
+	w.ps.lk.Lock()
+	defer w.ps.lk.Unlock()
+	if w.next < w.stream.dropped {
+		w.next = w.stream.dropped
+	}
+	frames = append(frames, w.stream.frames[w.next-w.stream.dropped:]...)
+	w.next = w.stream.dropped + len(w.stream.frames)
+	return frames, w.next > 0, !w.stream.finished.IsZero()
+}
+
+func (w *progressWatcher) close() {
+	w.ps.lk.Lock()
+	defer w.ps.lk.Unlock()
+	delete(w.stream.watchers, w)
+	if len(w.stream.watchers) == 0 && w.next == 0 && w.stream.finished.IsZero() {
+		// nothing was published, the retrieval didn't start
+		delete(w.ps.streams, w.id)
+	}
+}
+
+// progressHandler returns a handler streaming the progress of a retrieval of
+// the /ipfs/ or /ipns/ endpoints of the same handler, as Server-Sent Events.
+// A GET of /progress/{retrievalId} responds with a text/event-stream of the
+// retrieval's events, each named after its event code, and "progress" events
+// of its types.ProgressUpdates, ending with the "progress" event of the
+// "finished" phase. A client chooses the ID of its retrieval with the
+// X-Lassie-Retrieval-Id request header, and may open the stream before
+// making the request; if the retrieval doesn't start within a minute, the
+// stream ends with an "error" event.
+func progressHandler(progress *progressStreams) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+		var retrievalID types.RetrievalID
+		id := strings.Trim(strings.TrimPrefix(req.URL.Path, progressPath), "/")
+		if err := retrievalID.UnmarshalText([]byte(id)); err != nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, errors.New("invalid retrieval ID"))
+			return
+		}
+		flusher, ok := res.(http.Flusher)
+		if !ok {
+			errorResponse(res, statusLogger, http.StatusInternalServerError, errors.New("streaming is not supported"))
+			return
+		}
+
+		watcher := progress.watch(retrievalID)
+		defer watcher.close()
+
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-store")
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		statusLogger.logStatus(http.StatusOK, "OK")
+
+		keepAlive := time.NewTicker(progressKeepAlive)
+		defer keepAlive.Stop()
+		waitTimeout := time.NewTimer(progressWaitTimeout)
+		defer waitTimeout.Stop()
+		for {
+			frames, started, finished := watcher.take()
+			for _, frame := range frames {
+				if err := writeProgressFrame(res, frame); err != nil {
+					logger.Debugw("failed to write progress", logging.RetrievalIDKey, retrievalID, "err", err)
+					return
+				}
+			}
+			flusher.Flush()
+			if finished {
+				return
+			}
+			select {
+			case <-req.Context().Done():
+				return
+			case <-watcher.notify:
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-waitTimeout.C:
+				if !started {
+					_ = writeProgressFrame(res, progressFrame{event: "error", data: map[string]string{
+						"error": fmt.Sprintf("retrieval %s did not start within %s", retrievalID, progressWaitTimeout),
+					}})
+					flusher.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func writeProgressFrame(res http.ResponseWriter, frame progressFrame) error {
+	data, err := json.Marshal(frame.data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", frame.event, data)
+	return err
+}
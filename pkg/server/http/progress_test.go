@@ -0,0 +1,110 @@
+package httpserver
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/events"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressStreams(t *testing.T) {
+	progress := newProgressStreams()
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	root := cid.MustParse("bafkqaaa")
+
+	early := progress.watch(id)
+	frames, started, finished := early.take()
+	require.Empty(t, frames)
+	require.False(t, started)
+	require.False(t, finished)
+
+	progress.publish(id, newRetrievalEventFrame(events.StartedFindingCandidates(time.Now(), id, root)), false)
+	progress.publish(id, newProgressUpdateFrame(types.ProgressUpdate{RetrievalID: id, Phase: types.ProgressTransferring, BytesVerified: 10, Protocol: multicodec.TransportBitswap}), false)
+	select {
+	case <-early.notify:
+	default:
+		require.Fail(t, "watcher not notified")
+	}
+	frames, started, finished = early.take()
+	require.Len(t, frames, 2)
+	require.Equal(t, "started-finding-candidates", frames[0].event)
+	require.Equal(t, "progress", frames[1].event)
+	require.Equal(t, "transport-bitswap", frames[1].data.(ProgressUpdateFrame).Protocol)
+	require.True(t, started)
+	require.False(t, finished)
+
+	// a stream opened after the retrieval started sees all of it
+	progress.publish(id, newProgressUpdateFrame(types.ProgressUpdate{RetrievalID: id, Phase: types.ProgressFinished}), true)
+	late := progress.watch(id)
+	frames, _, finished = late.take()
+	require.Len(t, frames, 3)
+	require.True(t, finished)
+	frames, _, finished = early.take()
+	require.Len(t, frames, 1)
+	require.True(t, finished)
+	early.close()
+	late.close()
+
+	// a slow stream misses the oldest frames
+	other, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	slow := progress.watch(other)
+	defer slow.close()
+	for i := 0; i < progressMaxPending+10; i++ {
+		progress.publish(other, newProgressUpdateFrame(types.ProgressUpdate{RetrievalID: other, BlocksVerified: uint64(i)}), false)
+	}
+	frames, _, _ = slow.take()
+	require.Len(t, frames, progressMaxPending)
+	require.Equal(t, uint64(10), frames[0].data.(ProgressUpdateFrame).BlocksVerified)
+
+	// a stream for a retrieval that never started leaves nothing behind
+	unknown, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	progress.watch(unknown).close()
+	require.NotContains(t, progress.streams, unknown)
+}
+
+func TestProgressHandler(t *testing.T) {
+	progress := newProgressStreams()
+	server := httptest.NewServer(http.HandlerFunc(progressHandler(progress)))
+	defer server.Close()
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+
+	res, err := http.Get(server.URL + progressPath + "/not-an-id")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Get(server.URL + progressPath + "/" + id.String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	progress.publish(id, newProgressUpdateFrame(types.ProgressUpdate{RetrievalID: id, Phase: types.ProgressConnecting}), false)
+	progress.publish(id, newProgressUpdateFrame(types.ProgressUpdate{RetrievalID: id, Phase: types.ProgressFinished, Elapsed: time.Second}), true)
+
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, []string{
+		"event: progress",
+		`data: {"retrievalId":"` + id.String() + `","phase":"connecting","bytesReceived":0,"blocksVerified":0,"bytesVerified":0,"elapsedMs":0}`,
+		"",
+		"event: progress",
+		`data: {"retrievalId":"` + id.String() + `","phase":"finished","bytesReceived":0,"blocksVerified":0,"bytesVerified":0,"elapsedMs":1000}`,
+		"",
+	}, lines)
+}
@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// queueRetryAfter is the Retry-After of a response to a request that couldn't
+// be admitted by a retrievalQueue.
+const queueRetryAfter = 5 * time.Second
+
+var (
+	errQueueFull    = errors.New("too many retrievals in progress and queued")
+	errQueueTimeout = errors.New("timed out waiting for a retrieval slot")
+)
+
+// retrievalQueue admits up to a maximum number of concurrent retrievals,
+// holding up to maxQueued more until a retrieval finishes, so that a spike in
+// traffic is served at the rate the server can sustain rather than exhausting
+// its memory. A request that can't be queued, or waits in the queue longer
+// than the timeout, isn't served.
+type retrievalQueue struct {
+	slots     chan struct{}
+	maxQueued int64
+	timeout   time.Duration
+	queued    atomic.Int64
+}
+
+// newRetrievalQueue returns a retrievalQueue admitting max concurrent
+// retrievals, or nil if max is zero. A timeout of zero queues a request until
+// it is cancelled.
+func newRetrievalQueue(max uint, maxQueued uint, timeout time.Duration) *retrievalQueue {
+	if max == 0 {
+		return nil
+	}
+	return &retrievalQueue{
+		slots:     make(chan struct{}, max),
+		maxQueued: int64(maxQueued),
+		timeout:   timeout,
+	}
+}
+
+// acquire waits for a retrieval slot, returning a function that frees it once
+// the retrieval is done.
+func (q *retrievalQueue) acquire(ctx context.Context) (func(), error) {
+	release := func() { <-q.slots }
+	select {
+	case q.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if q.queued.Add(1) > q.maxQueued {
+		q.queued.Add(-1)
+		return nil, errQueueFull
+	}
+	defer q.queued.Add(-1)
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case q.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, errQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// running returns the number of retrievals holding a slot.
+func (q *retrievalQueue) running() int {
+	return len(q.slots)
+}
+
+// waiting returns the number of requests waiting for a slot.
+func (q *retrievalQueue) waiting() int64 {
+	return q.queued.Load()
+}
+
+// limitRetrievals wraps a handler so that each request waits for a slot of the
+// queue, responding with 503 and a Retry-After header if none frees up in
+// time. A nil queue doesn't limit the handler.
+func limitRetrievals(queue *retrievalQueue, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	if queue == nil {
+		return next
+	}
+	return func(res http.ResponseWriter, req *http.Request) {
+		release, err := queue.acquire(req.Context())
+		if err != nil {
+			if req.Context().Err() != nil {
+				// the client has gone away, there's no one to respond to
+				logger.Debugw("client went away while queued", "path", req.URL.Path)
+				return
+			}
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(queueRetryAfter.Seconds()))))
+			errorResponse(res, newStatusLogger(req.Method, req.URL.Path), http.StatusServiceUnavailable, fmt.Errorf("%w, try again later", err))
+			return
+		}
+		defer release()
+		next(res, req)
+	}
+}
+
+// checkQueue returns a check that fails when the queue can't admit another
+// request without rejecting it, as every slot is taken and the queue is full.
+// A nil queue never fails.
+func checkQueue(queue *retrievalQueue) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if queue == nil {
+			return nil
+		}
+		if running := queue.running(); running == cap(queue.slots) && queue.waiting() >= queue.maxQueued {
+			return fmt.Errorf("%d of %d concurrent retrievals running and %d of %d queued", running, cap(queue.slots), queue.waiting(), queue.maxQueued)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrievalQueue(t *testing.T) {
+	ctx := context.Background()
+	require.Nil(t, newRetrievalQueue(0, 10, time.Second))
+
+	queue := newRetrievalQueue(2, 1, 50*time.Millisecond)
+	release1, err := queue.acquire(ctx)
+	require.NoError(t, err)
+	release2, err := queue.acquire(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, queue.running())
+	require.NoError(t, checkQueue(queue)(ctx))
+
+	// a queued request times out if no slot frees up
+	_, err = queue.acquire(ctx)
+	require.ErrorIs(t, err, errQueueTimeout)
+	require.Equal(t, int64(0), queue.waiting())
+
+	// a queued request is admitted once a slot frees up, and beyond it the
+	// queue is full
+	admitted := make(chan func(), 1)
+	go func() {
+		release, err := queue.acquire(ctx)
+		require.NoError(t, err)
+		admitted <- release
+	}()
+	require.Eventually(t, func() bool { return queue.waiting() == 1 }, time.Second, time.Millisecond)
+	require.EqualError(t, checkQueue(queue)(ctx), "2 of 2 concurrent retrievals running and 1 of 1 queued")
+	_, err = queue.acquire(ctx)
+	require.ErrorIs(t, err, errQueueFull)
+	release1()
+	var release3 func()
+	select {
+	case release3 = <-admitted:
+	case <-time.After(time.Second):
+		require.FailNow(t, "queued request not admitted")
+	}
+	require.Equal(t, 2, queue.running())
+	require.Equal(t, int64(0), queue.waiting())
+
+	// a queued request gives up when its context is done
+	other := newRetrievalQueue(1, 1, 0)
+	_, err = other.acquire(ctx)
+	require.NoError(t, err)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = other.acquire(cancelCtx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	release2()
+	release3()
+	require.Equal(t, 0, queue.running())
+}
+
+func TestLimitRetrievals(t *testing.T) {
+	unblock := make(chan struct{})
+	started := make(chan struct{}, 1)
+	handler := limitRetrievals(newRetrievalQueue(1, 0, 0), func(res http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-unblock
+		res.WriteHeader(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil))
+		done <- rr.Code
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "5", rr.Header().Get("Retry-After"))
+	require.Contains(t, rr.Body.String(), "too many retrievals in progress and queued")
+
+	close(unblock)
+	require.Equal(t, http.StatusOK, <-done)
+
+	// without a queue the handler isn't limited
+	rr = httptest.NewRecorder()
+	limitRetrievals(nil, func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusTeapot)
+	})(rr, httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil))
+	require.Equal(t, http.StatusTeapot, rr.Code)
+}
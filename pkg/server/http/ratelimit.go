@@ -0,0 +1,354 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// rateLimitSweepInterval is how often the buckets of clients that have used
+// none of their limits are dropped, so that the buckets of clients that are no
+// longer seen don't accumulate.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit is a token bucket limit on the requests of a single client, and on
+// the bytes of the responses to them. A rate of zero means no limit. Bursts
+// are the number of requests, or bytes, that a client may use at once before
+// the rate applies; a RequestBurst of zero defaults to 1 and a ByteBurst of
+// zero to one second's worth of bytes.
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	RequestBurst      int     `json:"requestBurst,omitempty"`
+	BytesPerSecond    float64 `json:"bytesPerSecond,omitempty"`
+	ByteBurst         int64   `json:"byteBurst,omitempty"`
+}
+
+// RateLimits configures the limits on the clients of the server, each of which
+// is identified by its IP address and, when it authorizes with the Bearer
+// scheme, by its token. A request must be within the limits of both. Only the
+// tokens in Tokens and those the server authorizes, its access tokens, API keys
+// and valid JWTs, are limited by token; a request with any other token is
+// limited by its IP address alone, so that clients can't escape their limits
+// by making up new tokens.
+//
+// A request over a request rate limit, or made while the responses to a client
+// have used more than its byte burst, is responded to with 429 and a
+// Retry-After header. Responses over a bandwidth limit are slowed down.
+type RateLimits struct {
+	IP    RateLimit `json:"ip"`
+	Token RateLimit `json:"token"`
+	// Tokens overrides Token for particular tokens, such as those of trusted
+	// clients.
+	Tokens map[string]RateLimit `json:"tokens,omitempty"`
+}
+
+// ParseRateLimits parses RateLimits from JSON, such as:
+//
+//	{
+//	  "ip": {"requestsPerSecond": 5, "requestBurst": 20},
+//	  "token": {"requestsPerSecond": 50, "bytesPerSecond": 10485760},
+//	  "tokens": {"trusted-client-token": {}}
+//	}
+func ParseRateLimits(r io.Reader) (RateLimits, error) {
+	var limits RateLimits
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&limits); err != nil {
+		return RateLimits{}, fmt.Errorf("invalid rate limits: %w", err)
+	}
+	for _, limit := range append([]RateLimit{limits.IP, limits.Token}, tokenLimits(limits)...) {
+		if limit.RequestsPerSecond < 0 || limit.RequestBurst < 0 || limit.BytesPerSecond < 0 || limit.ByteBurst < 0 {
+			return RateLimits{}, errors.New("invalid rate limits: rates and bursts can't be negative")
+		}
+	}
+	return limits, nil
+}
+
+func tokenLimits(limits RateLimits) []RateLimit {
+	tokens := make([]RateLimit, 0, len(limits.Tokens))
+	for _, limit := range limits.Tokens {
+		tokens = append(tokens, limit)
+	}
+	return tokens
+}
+
+// isZero returns true if there are no limits.
+func (limits RateLimits) isZero() bool {
+	return limits.IP == RateLimit{} && limits.Token == RateLimit{} && len(limits.Tokens) == 0
+}
+
+// rateLimiter holds the token buckets of each client.
+type rateLimiter struct {
+	clock clock.Clock
+	// authorizes reports whether a token is one the server authorizes, and so
+	// may have a bucket of its own; nil if none are
+	authorizes func(token string) bool
+
+	lk        sync.Mutex
+	limits    RateLimits
+	buckets   map[string]*clientBuckets
+	lastSweep time.Time
+}
+
+// clientBuckets are the request and byte buckets of a single client, either of
+// which is nil if it isn't limited.
+type clientBuckets struct {
+	requests *bucket
+	bytes    *bucket
+}
+
+func newRateLimiter(limits RateLimits, authorizes func(token string) bool, clock clock.Clock) *rateLimiter {
+	return &rateLimiter{
+		limits:     limits,
+		authorizes: authorizes,
+		clock:      clock,
+		buckets:    make(map[string]*clientBuckets),
+		lastSweep:  clock.Now(),
+	}
+}
+
+// setLimits replaces the limits. Clients start afresh with full buckets for
+// the new limits.
+func (rl *rateLimiter) setLimits(limits RateLimits) {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	rl.limits = limits
+	rl.buckets = make(map[string]*clientBuckets)
+}
+
+func (rl *rateLimiter) currentLimits() RateLimits {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	return rl.limits
+}
+
+// clientsOf returns the buckets of the IP address and token of the request.
+func (rl *rateLimiter) clientsOf(r *http.Request) []*clientBuckets {
+	limits := rl.currentLimits()
+	clients := make([]*clientBuckets, 0, 2)
+	if limits.IP != (RateLimit{}) {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ip = host
+		}
+		clients = append(clients, rl.client("ip:"+ip, limits.IP))
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		limit, ok := limits.Tokens[token]
+		if !ok {
+			if rl.authorizes == nil || !rl.authorizes(token) {
+				// no bucket for a token that won't be authorized, so that the
+				// buckets can't be grown at will
+				return clients
+			}
+			limit = limits.Token
+		}
+		if limit != (RateLimit{}) {
+			clients = append(clients, rl.client("token:"+token, limit))
+		}
+	}
+	return clients
+}
+
+func (rl *rateLimiter) client(key string, limit RateLimit) *clientBuckets {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	now := rl.clock.Now()
+	if now.Sub(rl.lastSweep) >= rateLimitSweepInterval {
+		for k, client := range rl.buckets {
+			if client.requests.isFull(now) && client.bytes.isFull(now) {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+	client, ok := rl.buckets[key]
+	if !ok {
+		client = &clientBuckets{}
+		if limit.RequestsPerSecond > 0 {
+			client.requests = newBucket(limit.RequestsPerSecond, float64(limit.RequestBurst), 1, now)
+		}
+		if limit.BytesPerSecond > 0 {
+			client.bytes = newBucket(limit.BytesPerSecond, float64(limit.ByteBurst), limit.BytesPerSecond, now)
+		}
+		rl.buckets[key] = client
+	}
+	return client
+}
+
+// allow takes a request token from each client, returning how long to wait
+// before retrying if any of them is over its limit, in which case no token is
+// taken.
+func (rl *rateLimiter) allow(clients []*clientBuckets) (bool, time.Duration) {
+	now := rl.clock.Now()
+	var retryAfter time.Duration
+	for _, client := range clients {
+		if wait := client.requests.wait(now, 1); wait > retryAfter {
+			retryAfter = wait
+		}
+		// a client whose responses have used more than their byte burst
+		// waits for the debt to be paid off
+		if wait := client.bytes.wait(now, 0); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	for _, client := range clients {
+		client.requests.take(now, 1)
+	}
+	return true, 0
+}
+
+// throttle takes n bytes from each client, returning how long to wait before
+// writing them.
+func (rl *rateLimiter) throttle(clients []*clientBuckets, n int) time.Duration {
+	now := rl.clock.Now()
+	var delay time.Duration
+	for _, client := range clients {
+		if wait := client.bytes.take(now, float64(n)); wait > delay {
+			delay = wait
+		}
+	}
+	return delay
+}
+
+// bucket is a token bucket whose token count may go negative, when bytes are
+// written beyond the burst, and which then refills from below zero.
+type bucket struct {
+	lk     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst float64, defaultBurst float64, now time.Time) *bucket {
+	if burst <= 0 {
+		burst = defaultBurst
+	}
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long until n tokens are available, zero if they are now.
+func (b *bucket) wait(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.refill(now)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take takes n tokens, returning how long until the token count is back to
+// zero if it goes negative.
+func (b *bucket) take(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) isFull(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// rateLimitMiddleware responds to requests over the limits of their clients
+// with 429, and slows down the responses over their bandwidth limits. The
+// health endpoints aren't limited.
+func rateLimitMiddleware(next http.Handler, limiter *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		clients := limiter.clientsOf(r)
+		if len(clients) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := limiter.allow(clients); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			logger.Debugw("rate limited request", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "retry_after", seconds)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, "Too Many Requests")
+			return
+		}
+		next.ServeHTTP(&throttledResponseWriter{ResponseWriter: w, req: r, limiter: limiter, clients: clients}, r)
+	})
+}
+
+// throttledResponseWriter delays the writes of a response over the bandwidth
+// limits of the clients it is for.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	req     *http.Request
+	limiter *rateLimiter
+	clients []*clientBuckets
+}
+
+func (w *throttledResponseWriter) Write(b []byte) (int, error) {
+	if delay := w.limiter.throttle(w.clients, len(b)); delay > 0 {
+		timer := w.limiter.clock.Timer(delay)
+		select {
+		case <-w.req.Context().Done():
+			timer.Stop()
+			return 0, w.req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *throttledResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack allows a throttled response to be terminated early, as an /ipfs/
+// response is on a failed retrieval.
+func (w *throttledResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("unable to access hijack interface")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,167 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits(strings.NewReader(`{
+		"ip": {"requestsPerSecond": 5, "requestBurst": 20},
+		"token": {"requestsPerSecond": 50, "bytesPerSecond": 1048576, "byteBurst": 4194304},
+		"tokens": {"trusted": {}}
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, RateLimits{
+		IP:     RateLimit{RequestsPerSecond: 5, RequestBurst: 20},
+		Token:  RateLimit{RequestsPerSecond: 50, BytesPerSecond: 1048576, ByteBurst: 4194304},
+		Tokens: map[string]RateLimit{"trusted": {}},
+	}, limits)
+
+	_, err = ParseRateLimits(strings.NewReader(`{"ip": {"requestsPerMinute": 5}}`))
+	require.ErrorContains(t, err, "unknown field")
+	_, err = ParseRateLimits(strings.NewReader(`{"token": {"requestsPerSecond": -1}}`))
+	require.ErrorContains(t, err, "can't be negative")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	clk := clock.NewMock()
+	limits := RateLimits{
+		IP:     RateLimit{RequestsPerSecond: 1, RequestBurst: 2},
+		Token:  RateLimit{RequestsPerSecond: 0.5},
+		Tokens: map[string]RateLimit{"trusted": {}},
+	}
+	limiter := newRateLimiter(limits, func(token string) bool { return token == "client" }, clk)
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), limiter)
+	serve := func(remoteAddr string, path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the burst of an IP address
+	require.Equal(t, http.StatusOK, serve("1.2.3.4:1000", "/ipfs/bafkqaaa", "").Code)
+	require.Equal(t, http.StatusOK, serve("1.2.3.4:1001", "/ipfs/bafkqaaa", "").Code)
+	rr := serve("1.2.3.4:1002", "/ipfs/bafkqaaa", "")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("Retry-After"))
+	// other addresses and the health endpoints aren't affected
+	require.Equal(t, http.StatusOK, serve("5.6.7.8:1000", "/ipfs/bafkqaaa", "").Code)
+	require.Equal(t, http.StatusOK, serve("1.2.3.4:1002", "/healthz", "").Code)
+
+	// the rate refills the bucket
+	clk.Add(time.Second)
+	require.Equal(t, http.StatusOK, serve("1.2.3.4:1000", "/ipfs/bafkqaaa", "").Code)
+	require.Equal(t, http.StatusTooManyRequests, serve("1.2.3.4:1000", "/ipfs/bafkqaaa", "").Code)
+
+	// a token is limited across addresses, unless it has its own limit
+	require.Equal(t, http.StatusOK, serve("10.0.0.1:1000", "/ipfs/bafkqaaa", "client").Code)
+	rr = serve("10.0.0.2:1000", "/ipfs/bafkqaaa", "client")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "2", rr.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusOK, serve("10.0.0.3:1000", "/ipfs/bafkqaaa", "trusted").Code)
+	require.Equal(t, http.StatusOK, serve("10.0.0.4:1000", "/ipfs/bafkqaaa", "trusted").Code)
+
+	// tokens that won't be authorized get no bucket of their own, and are
+	// limited by their IP address alone
+	buckets := len(limiter.buckets)
+	require.Equal(t, http.StatusOK, serve("10.0.0.5:1000", "/ipfs/bafkqaaa", "junk-1").Code)
+	require.Equal(t, http.StatusOK, serve("10.0.0.5:1000", "/ipfs/bafkqaaa", "junk-2").Code)
+	require.Equal(t, http.StatusTooManyRequests, serve("10.0.0.5:1000", "/ipfs/bafkqaaa", "junk-3").Code)
+	require.Equal(t, buckets+1, len(limiter.buckets))
+
+	// replaced limits apply straight away, from a full burst
+	limiter.setLimits(RateLimits{IP: RateLimit{RequestsPerSecond: 1, RequestBurst: 3}})
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, serve("1.2.3.4:1000", "/ipfs/bafkqaaa", "").Code)
+	}
+	require.Equal(t, http.StatusTooManyRequests, serve("1.2.3.4:1000", "/ipfs/bafkqaaa", "").Code)
+	require.Equal(t, http.StatusOK, serve("10.0.0.1:1000", "/ipfs/bafkqaaa", "client").Code)
+	// and without limits, requests aren't limited
+	limiter.setLimits(RateLimits{})
+	require.Equal(t, http.StatusOK, serve("1.2.3.4:1000", "/ipfs/bafkqaaa", "").Code)
+}
+
+func TestRateLimitBandwidth(t *testing.T) {
+	clk := clock.NewMock()
+	limiter := newRateLimiter(RateLimits{IP: RateLimit{BytesPerSecond: 100}}, nil, clk)
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil)
+	clients := limiter.clientsOf(req)
+
+	// the burst of 100 bytes is written at once, the next 200 bytes are paid
+	// off over two seconds
+	require.Zero(t, limiter.throttle(clients, 100))
+	require.Equal(t, 2*time.Second, limiter.throttle(clients, 200))
+
+	// while the client's bandwidth is in debt, new requests are turned away
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 100))
+	}), limiter)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "2", rr.Header().Get("Retry-After"))
+
+	// and once it's paid off, writes wait for their bytes
+	clk.Add(2 * time.Second)
+	rr = httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rr, req)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		clk.Add(100 * time.Millisecond)
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, 100, rr.Body.Len())
+
+	// a throttled write gives up when the request is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &throttledResponseWriter{
+		ResponseWriter: httptest.NewRecorder(),
+		req:            req.WithContext(ctx),
+		limiter:        limiter,
+		clients:        clients,
+	}
+	_, err := w.Write(make([]byte, 200))
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	clk := clock.NewMock()
+	limiter := newRateLimiter(RateLimits{IP: RateLimit{RequestsPerSecond: 1}}, nil, clk)
+	for _, addr := range []string{"1.1.1.1:1", "2.2.2.2:1"} {
+		req := httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil)
+		req.RemoteAddr = addr
+		ok, _ := limiter.allow(limiter.clientsOf(req))
+		require.True(t, ok)
+	}
+	require.Len(t, limiter.buckets, 2)
+
+	// the buckets have refilled by the time of the sweep
+	clk.Add(rateLimitSweepInterval)
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/bafkqaaa", nil)
+	req.RemoteAddr = "3.3.3.3:1"
+	limiter.clientsOf(req)
+	require.Len(t, limiter.buckets, 1)
+}
@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/filecoin-project/lassie/pkg/build"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+// serveRawBlock fetches the block of the requested CID and responds with it
+// as application/vnd.ipld.raw. Only a CID without a path may be requested as a
+// raw block, as a client can't verify that a block is at the end of a path
+// without the blocks leading to it, which a CAR response includes.
+func serveRawBlock(fetcher types.Fetcher, cfg HttpServerConfig, res http.ResponseWriter, req *http.Request, statusLogger *statusLogger) {
+	ok, rootCid, path := decodeUrlPath(res, req, statusLogger)
+	if !ok {
+		return
+	}
+	if path.Len() > 0 {
+		errorResponse(res, statusLogger, http.StatusBadRequest, errors.New("a path can't be requested as a raw block, request a CAR instead"))
+		return
+	}
+
+	ok, request := newRetrievalRequest(cfg, res, req, statusLogger, trustlessutils.Request{
+		Root:  rootCid,
+		Scope: trustlessutils.DagScopeBlock,
+	})
+	if !ok {
+		return
+	}
+
+	ok, fetchOpts := decodeGlobalTimeout(res, req, statusLogger)
+	if !ok {
+		return
+	}
+	fetchOpts = append(fetchOpts, types.WithEventsCallback(accessLogSubscriber(req.Context(), nil)))
+
+	store := &memstore.Store{}
+	request.LinkSystem.SetWriteStorage(store)
+	request.LinkSystem.SetReadStorage(store)
+
+	logger.Debugw("fetching raw block",
+		logging.RetrievalIDKey, request.RetrievalID,
+		"root", request.Root.String(),
+	)
+
+	if _, err := fetcher.Fetch(req.Context(), request, fetchOpts...); err != nil {
+		fetchErrorResponse(res, statusLogger, err)
+		return
+	}
+
+	data, err := request.LinkSystem.LoadRaw(linking.LinkContext{Ctx: req.Context()}, cidlink.Link{Cid: rootCid})
+	if err != nil {
+		errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("failed to load block: %w", err))
+		return
+	}
+
+	res.Header().Set("Server", build.UserAgent)
+	res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.bin\"", rootCid))
+	res.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	res.Header().Set("Content-Type", trustlesshttp.MimeTypeRaw)
+	res.Header().Set("Etag", fmt.Sprintf(`"%s.raw"`, rootCid))
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("X-Ipfs-Path", trustlessutils.PathEscape(req.URL.Path))
+	statusLogger.logStatus(200, "OK")
+	if _, err := res.Write(data); err != nil {
+		logger.Debugw("failed to write raw block response", "err", err)
+	}
+}
@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/resultstore"
+	"github.com/ipfs/go-cid"
+)
+
+// ResultsHandler returns a handler responding with a JSON array of the stored
+// results of finished retrievals, most recent first, as
+// lassie.QueryResults. The results may be narrowed with the "root",
+// "requestHash" and "outcome" query parameters, and the "since" and "until"
+// query parameters holding RFC 3339 times, and their number limited with
+// "limit". It responds with 404 if the Lassie instance doesn't store results.
+func ResultsHandler(l *lassie.Lassie) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+
+		query, err := parseResultsQuery(req)
+		if err != nil {
+			errorResponse(res, statusLogger, http.StatusBadRequest, err)
+			return
+		}
+
+		results, err := l.QueryResults(req.Context(), query)
+		if err != nil {
+			if errors.Is(err, lassie.ErrNoResultStore) {
+				errorResponse(res, statusLogger, http.StatusNotFound, err)
+				return
+			}
+			errorResponse(res, statusLogger, http.StatusInternalServerError, fmt.Errorf("failed to query results: %w", err))
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		res.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(res).Encode(results); err != nil {
+			logger.Debugw("failed to write results response", "err", err)
+		}
+		statusLogger.logStatus(http.StatusOK, "OK")
+	}
+}
+
+func parseResultsQuery(req *http.Request) (resultstore.Query, error) {
+	params := req.URL.Query()
+	query := resultstore.Query{
+		RequestHash: params.Get("requestHash"),
+		Outcome:     params.Get("outcome"),
+	}
+	var err error
+	if params.Has("root") {
+		if query.Root, err = cid.Parse(params.Get("root")); err != nil {
+			return resultstore.Query{}, errors.New("invalid root, must be a CID")
+		}
+	}
+	if params.Has("since") {
+		if query.Since, err = time.Parse(time.RFC3339, params.Get("since")); err != nil {
+			return resultstore.Query{}, errors.New("invalid since, must be an RFC 3339 time")
+		}
+	}
+	if params.Has("until") {
+		if query.Until, err = time.Parse(time.RFC3339, params.Get("until")); err != nil {
+			return resultstore.Query{}, errors.New("invalid until, must be an RFC 3339 time")
+		}
+	}
+	if params.Has("limit") {
+		if query.Limit, err = strconv.Atoi(params.Get("limit")); err != nil || query.Limit <= 0 {
+			return resultstore.Query{}, errors.New("invalid limit, must be a positive integer")
+		}
+	}
+	return query, nil
+}
@@ -2,17 +2,25 @@ package httpserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"net/http/pprof"
+	"time"
 
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/accesslog"
+	"github.com/filecoin-project/lassie/pkg/ipnsresolver"
 	"github.com/filecoin-project/lassie/pkg/lassie"
-	"github.com/ipfs/go-log/v2"
-	servertiming "github.com/mitchellh/go-server-timing"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/filecoin-project/lassie/pkg/responsecache"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var logger = log.Logger("lassie/httpserver")
+var logger = logging.Subsystem("lassie/httpserver")
 
 // HttpServer is a Lassie server for fetching data from the network via HTTP
 type HttpServer struct {
@@ -20,6 +28,20 @@ type HttpServer struct {
 	ctx      context.Context
 	listener net.Listener
 	server   *http.Server
+
+	// challengeListener and challengeServer answer ACME HTTP-01 challenges,
+	// when configured, see TLSConfig.ACMEHTTPAddress
+	challengeListener net.Listener
+	challengeServer   *http.Server
+
+	// adminListener and adminServer serve the admin endpoints, when
+	// configured, see HttpServerConfig.AdminAddress
+	adminListener net.Listener
+	adminServer   *http.Server
+
+	rateLimiter  *rateLimiter
+	apiKeys      []APIKey
+	drainTimeout time.Duration
 }
 
 type HttpServerConfig struct {
@@ -27,7 +49,111 @@ type HttpServerConfig struct {
 	Port                uint
 	TempDir             string
 	MaxBlocksPerRequest uint64
-	AccessToken         string
+	// AccessToken and AccessTokens, if set, require clients to authorize with
+	// the Bearer scheme and one of the tokens, or a JWT that is valid for the
+	// JWT configuration if set. The health endpoints are always served.
+	AccessToken  string
+	AccessTokens []string
+	JWT          *JWTConfig
+	// APIKeys, if set, are also accepted from clients with the Bearer scheme,
+	// each the key of a tenant with limits and quotas of its own whose usage
+	// is accounted for, see APIKey.
+	APIKeys []APIKey
+	// RateLimits, if set, limits the requests and response bandwidth of each
+	// client IP address and token, see RateLimits.
+	RateLimits RateLimits
+	// CORS, if set, allows scripts in web pages from the configured origins to
+	// fetch from the server.
+	CORS *CORSConfig
+	// TLS, if set, has NewHttpServer serve HTTPS rather than HTTP, see
+	// TLSConfig.
+	TLS *TLSConfig
+
+	// progress delivers the progress of retrievals to their /progress/
+	// streams, set by NewHandler.
+	progress *progressStreams
+	// rateLimiter applies the RateLimits, set by NewHttpServer so that they
+	// may be replaced with SetRateLimits.
+	rateLimiter *rateLimiter
+	// MaxConcurrentRequests is the number of in-flight retrieval requests at
+	// which the server reports itself as not ready on /readyz; zero means no
+	// limit. Requests beyond this number are still served.
+	MaxConcurrentRequests uint
+	// MinTempSpace is the number of bytes that must be free in the filesystem
+	// of TempDir for the server to report itself as ready on /readyz; zero
+	// means no minimum.
+	MinTempSpace uint64
+	// MaxConcurrentRetrievals, if set, caps the number of /ipfs/ and /ipns/
+	// requests served at once. Up to MaxQueuedRetrievals more wait for one of
+	// them to finish, for at most QueueTimeout if set, and any others are
+	// responded to with 503, so that a spike in traffic degrades the service
+	// rather than exhausting the server's memory.
+	MaxConcurrentRetrievals uint
+	MaxQueuedRetrievals     uint
+	QueueTimeout            time.Duration
+	// DrainTimeout, if set, bounds how long Shutdown waits for the requests in
+	// flight to complete before cutting them off.
+	DrainTimeout time.Duration
+	// ResponseCache, if set, keeps complete CAR responses of /ipfs/ requests,
+	// keyed by the canonical hash of the request, so that repeated requests
+	// for the same content are served without retrieving it again. Requests
+	// with a blockLimit or byteLimit, or a glob, aren't cached, and a request
+	// with "Cache-Control: no-cache" is always retrieved.
+	ResponseCache *responsecache.Cache
+	// AccessLog, if set, has an entry written to it for each request once it
+	// has been served, including those rejected by rate limits or
+	// authorization, see accesslog.Entry.
+	AccessLog *accesslog.Log
+	// EnableAdmin serves the /admin/ endpoints from NewHttpServer, see
+	// WithAdmin. With APIKeys or a JWT configuration, AdminAccessTokens are
+	// then required, so that tenants can't administer the gateway.
+	EnableAdmin bool
+	// AdminAddress, if set, has NewHttpServer serve the /admin/ endpoints on a
+	// listener of their own at this address, e.g. "127.0.0.1:8081", rather
+	// than alongside the gateway endpoints, so that they can be firewalled
+	// separately; EnableAdmin is then ignored, and AdminAccessTokens are
+	// mandatory. AdminAccessTokens, if set, are required of the clients of
+	// the admin endpoints, wherever they're served, with the Bearer scheme,
+	// in place of the gateway's access tokens. The admin listener serves
+	// HTTPS when TLS is configured.
+	AdminAddress      string
+	AdminAccessTokens []string
+	// Metrics, if set, is served at /metrics from NewHttpServer, see
+	// WithMetrics.
+	Metrics prometheus.Gatherer
+	// InMemory holds the temporary CAR of each request in memory rather than
+	// in TempDir, so that the server never touches disk. It is implied when
+	// serving an in-memory Lassie instance, see lassie.WithInMemory.
+	InMemory bool
+	// TempStore, if set, creates the storage for the temporary blocks of each
+	// request in place of a CAR in TempDir or in memory. It defaults to the
+	// Lassie instance's, see lassie.WithTempStore.
+	TempStore types.TempStoreFactory
+	// InMemoryThreshold, if set, holds the temporary blocks of each request in
+	// memory until they exceed this many bytes, only then moving them to a CAR
+	// in TempDir, see storage.SpillingTempStore. It has no effect with
+	// InMemory or TempStore, and defaults to the Lassie instance's, see
+	// lassie.WithInMemoryThreshold.
+	InMemoryThreshold uint64
+	// TempQuota, if set, bounds the total size of the temporary blocks held by
+	// the requests being served, see storage.TempQuota. A request that would
+	// start a retrieval while the total is at or above the quota's high water
+	// mark waits for it to fall below, for at most TempQuotaTimeout, and is
+	// otherwise responded to with 503; requests served from the ResponseCache
+	// are not held back.
+	TempQuota        *storage.TempQuota
+	TempQuotaTimeout time.Duration
+	// IpnsResolver, if set, serves /ipns/ requests by resolving the name with
+	// it, typically an ipnsresolver.Resolver, and then serving the content
+	// that it points to.
+	IpnsResolver ipnsresolver.NameResolver
+	// IpnsMaxAge is how long a name resolution is reused by /ipns/ requests
+	// before it is revalidated, and IpnsMaxStale how much longer it may still
+	// be served immediately while it is revalidated in the background. A
+	// request for a name resolved longer ago than both waits for the name to
+	// be resolved again.
+	IpnsMaxAge   time.Duration
+	IpnsMaxStale time.Duration
 }
 
 type contextKey struct {
@@ -40,59 +166,154 @@ func saveConnInCTX(ctx context.Context, c net.Conn) context.Context {
 	return context.WithValue(ctx, connContextKey, c)
 }
 
-// NewHttpServer creates a new HttpServer
-func NewHttpServer(ctx context.Context, lassie *lassie.Lassie, cfg HttpServerConfig) (*HttpServer, error) {
+// NewHttpServer creates a new HttpServer, serving the handler created by
+// NewHandler with the given options
+//
+// An in-memory server, see HttpServerConfig.InMemory, fails with an error
+// wrapping lassie.ErrDiskAccess if a TempDir is also configured. With a TLS
+// configuration, the server serves HTTPS, failing if its certificate files
+// can't be loaded. Serving the admin endpoints on an AdminAddress, or
+// alongside API keys or JWTs, fails without AdminAccessTokens.
+func NewHttpServer(ctx context.Context, lassie *lassie.Lassie, cfg HttpServerConfig, opts ...HandlerOption) (*HttpServer, error) {
+	if err := checkInMemory(lassie, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.AdminAddress != "" && len(cfg.AdminAccessTokens) == 0 {
+		return nil, errors.New("admin endpoints served on an admin address require admin access tokens")
+	}
+	if cfg.EnableAdmin && cfg.AdminAddress == "" && len(cfg.AdminAccessTokens) == 0 && (len(cfg.APIKeys) > 0 || cfg.JWT != nil) {
+		return nil, errors.New("admin endpoints served with API keys or JWTs require admin access tokens")
+	}
+
+	var tlsCfg *tls.Config
+	var challengeServer *http.Server
+	if cfg.TLS != nil {
+		var err error
+		if tlsCfg, challengeServer, err = cfg.TLS.build(); err != nil {
+			return nil, err
+		}
+	}
+
 	addr := fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
 	listener, err := net.Listen("tcp", addr) // assigns a port if port is 0
 	if err != nil {
 		return nil, err
 	}
+	var challengeListener net.Listener
+	if challengeServer != nil {
+		if challengeListener, err = net.Listen("tcp", challengeServer.Addr); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	var adminListener net.Listener
+	if cfg.AdminAddress != "" {
+		if adminListener, err = net.Listen("tcp", cfg.AdminAddress); err != nil {
+			_ = listener.Close()
+			if challengeListener != nil {
+				_ = challengeListener.Close()
+			}
+			return nil, err
+		}
+	}
+	if tlsCfg != nil {
+		listener = tls.NewListener(listener, tlsCfg)
+		if adminListener != nil {
+			adminListener = tls.NewListener(adminListener, tlsCfg)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
+	cfg.rateLimiter = newRateLimiter(cfg.RateLimits.withAPIKeys(cfg.APIKeys), tokenAuthorizer(cfg), clock.New())
 
-	// create server
-	mux := http.NewServeMux()
-	handler := servertiming.Middleware(mux, nil)
-
-	if cfg.AccessToken != "" {
-		handler = authorizationMiddleware(handler, cfg.AccessToken)
-	}
+	// the standalone server enables pprof unless disabled with WithPprof(false),
+	// and the admin and metrics endpoints as configured unless overridden with
+	// WithAdmin and WithMetrics
+	handler, adminHandler := newHandlers(lassie, cfg, append([]HandlerOption{WithPprof(true), WithAdmin(cfg.EnableAdmin && cfg.AdminAddress == ""), WithMetrics(cfg.Metrics)}, opts...)...)
 
+	// create server
 	server := &http.Server{
 		Addr:        fmt.Sprintf(":%d", cfg.Port),
 		BaseContext: func(listener net.Listener) context.Context { return ctx },
 		Handler:     handler,
 		ConnContext: saveConnInCTX,
+		TLSConfig:   tlsCfg, // already terminated by the listener
 	}
 
 	httpServer := &HttpServer{
-		cancel:   cancel,
-		ctx:      ctx,
-		listener: listener,
-		server:   server,
+		cancel:            cancel,
+		ctx:               ctx,
+		listener:          listener,
+		server:            server,
+		challengeListener: challengeListener,
+		challengeServer:   challengeServer,
+		rateLimiter:       cfg.rateLimiter,
+		apiKeys:           cfg.APIKeys,
+		drainTimeout:      cfg.DrainTimeout,
+	}
+	if adminListener != nil {
+		httpServer.adminListener = adminListener
+		httpServer.adminServer = &http.Server{
+			BaseContext: func(listener net.Listener) context.Context { return ctx },
+			Handler:     adminHandler,
+		}
 	}
-
-	// Routes
-	mux.HandleFunc("/ipfs/", IpfsHandler(lassie, cfg))
-
-	// Handle pprof endpoints
-	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
 	return httpServer, nil
 }
 
+// checkInMemory returns an error if the server is to be in-memory, either
+// configured to be or serving an in-memory Lassie instance, but would also
+// write temporary files.
+func checkInMemory(l *lassie.Lassie, cfg HttpServerConfig) error {
+	if (cfg.InMemory || l.InMemory()) && cfg.TempDir != "" {
+		return fmt.Errorf("%w: temporary directory %s", lassie.ErrDiskAccess, cfg.TempDir)
+	}
+	return nil
+}
+
 // Addr returns the listening address of the server
 func (s HttpServer) Addr() string {
 	return s.listener.Addr().String()
 }
 
+// AdminAddr returns the listening address of the admin endpoints, if they're
+// served on a listener of their own, see HttpServerConfig.AdminAddress.
+func (s HttpServer) AdminAddr() string {
+	if s.adminListener == nil {
+		return ""
+	}
+	return s.adminListener.Addr().String()
+}
+
+// SetRateLimits replaces the rate limits of the server, see
+// HttpServerConfig.RateLimits, such as when a daemon reloads its
+// configuration. Clients start afresh with the new limits, while the responses
+// in progress keep being slowed down by the bandwidth limits they started
+// with. The rate limits of the APIKeys still apply.
+func (s *HttpServer) SetRateLimits(limits RateLimits) {
+	s.rateLimiter.setLimits(limits.withAPIKeys(s.apiKeys))
+}
+
 // Start starts the http server, returning an error if the server failed to start
 func (s *HttpServer) Start() error {
-	logger.Infow("starting http server", "listen_addr", s.listener.Addr())
+	if s.adminServer != nil {
+		logger.Infow("starting admin server", "listen_addr", s.adminListener.Addr())
+		go func() {
+			if err := s.adminServer.Serve(s.adminListener); err != http.ErrServerClosed {
+				logger.Errorw("failed to start admin server", "err", err)
+			}
+		}()
+	}
+	if s.challengeServer != nil {
+		logger.Infow("starting ACME challenge server", "listen_addr", s.challengeListener.Addr())
+		go func() {
+			if err := s.challengeServer.Serve(s.challengeListener); err != http.ErrServerClosed {
+				logger.Errorw("failed to start ACME challenge server", "err", err)
+			}
+		}()
+	}
+	logger.Infow("starting http server", "listen_addr", s.listener.Addr(), "tls", s.server.TLSConfig != nil)
 	err := s.server.Serve(s.listener)
 	if err != http.ErrServerClosed {
 		logger.Errorw("failed to start http server", "err", err)
@@ -101,23 +322,48 @@ func (s *HttpServer) Start() error {
 	return nil
 }
 
-// Close shuts down the server and cancels the server context
+// Shutdown gracefully shuts down the server: it stops accepting requests and
+// waits for those in flight, including retrievals still streaming their
+// responses, to complete. If the context is done first, or the
+// HttpServerConfig.DrainTimeout elapses, the requests left are cut off as with
+// Close. The admin endpoints on their own address, see
+// HttpServerConfig.AdminAddress, are served until then, so that the
+// retrievals being waited on may be listed and cancelled.
+func (s *HttpServer) Shutdown(ctx context.Context) error {
+	logger.Infow("draining http server", "timeout", s.drainTimeout)
+	if s.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.drainTimeout)
+		defer cancel()
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		logger.Warnw("requests still in flight after draining, cutting them off", "err", err)
+		return s.Close()
+	}
+	s.cancel()
+	s.closeAuxiliary()
+	return nil
+}
+
+// Close shuts down the server and cancels the server context, cutting off any
+// requests in flight
 func (s *HttpServer) Close() error {
-	logger.Info("closing http server")
+	logger.Infow("closing http server")
 	s.cancel()
+	s.closeAuxiliary()
 	return s.server.Shutdown(context.Background())
 }
 
-func authorizationMiddleware(next http.Handler, accessToken string) http.Handler {
-	requiredHeaderValue := fmt.Sprintf("Bearer %s", accessToken)
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") == requiredHeaderValue {
-			next.ServeHTTP(w, r)
-			return
+// closeAuxiliary shuts down the ACME challenge and admin servers, if any.
+func (s *HttpServer) closeAuxiliary() {
+	if s.challengeServer != nil {
+		if err := s.challengeServer.Shutdown(context.Background()); err != nil {
+			logger.Warnw("failed to close ACME challenge server", "err", err)
 		}
-
-		// Unauthorized
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprintln(w, "Unauthorized")
-	})
+	}
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(context.Background()); err != nil {
+			logger.Warnw("failed to close admin server", "err", err)
+		}
+	}
 }
@@ -0,0 +1,178 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/internal/itest/mocknet"
+	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpServerAdminAddress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	require.NoError(t, mrn.MN.LinkAll())
+	l, err := lassie.NewLassie(ctx, lassie.WithHost(mrn.Self), lassie.WithFinder(mrn.Finder))
+	require.NoError(t, err)
+
+	server, err := NewHttpServer(ctx, l, HttpServerConfig{
+		Address:           "127.0.0.1",
+		TempDir:           t.TempDir(),
+		AccessTokens:      []string{"gateway"},
+		EnableAdmin:       true,
+		AdminAddress:      "127.0.0.1:0",
+		AdminAccessTokens: []string{"admin"},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, server.AdminAddr())
+	require.NotEqual(t, server.Addr(), server.AdminAddr())
+	go func() { _ = server.Start() }()
+	defer server.Close()
+
+	get := func(addr string, path string, token string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// the admin endpoints are only served on the admin address, with the
+	// admin token
+	require.Equal(t, http.StatusNotFound, get(server.Addr(), "/admin/retrievals", "gateway"))
+	require.Equal(t, http.StatusOK, get(server.AdminAddr(), "/admin/retrievals", "admin"))
+	require.Equal(t, http.StatusOK, get(server.AdminAddr(), "/admin/log-levels", "admin"))
+	require.Equal(t, http.StatusUnauthorized, get(server.AdminAddr(), "/admin/retrievals", "gateway"))
+	require.Equal(t, http.StatusNotFound, get(server.AdminAddr(), "/stats/failures", "admin"))
+	require.Equal(t, http.StatusOK, get(server.Addr(), "/stats/failures", "gateway"))
+
+	// alongside the gateway endpoints, tenants' API keys aren't admin tokens
+	_, err = NewHttpServer(ctx, l, HttpServerConfig{
+		Address:     "127.0.0.1",
+		TempDir:     t.TempDir(),
+		APIKeys:     []APIKey{{Name: "acme", Key: "acme-key"}},
+		EnableAdmin: true,
+	})
+	require.ErrorContains(t, err, "require admin access tokens")
+
+	// nor are the admin endpoints served on an admin address without tokens
+	_, err = NewHttpServer(ctx, l, HttpServerConfig{
+		Address:      "127.0.0.1",
+		TempDir:      t.TempDir(),
+		AdminAddress: "127.0.0.1:0",
+	})
+	require.ErrorContains(t, err, "require admin access tokens")
+}
+
+func TestHttpServerShutdown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	require.NoError(t, mrn.MN.LinkAll())
+	l, err := lassie.NewLassie(ctx, lassie.WithHost(mrn.Self), lassie.WithFinder(mrn.Finder))
+	require.NoError(t, err)
+
+	// /slow stands in for a retrieval that is still streaming its response
+	// when the server is shut down
+	newServer := func(drainTimeout time.Duration) (*HttpServer, chan struct{}, chan struct{}) {
+		started, release := make(chan struct{}), make(chan struct{})
+		slow := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/slow" {
+					next.ServeHTTP(res, req)
+					return
+				}
+				close(started)
+				select {
+				case <-release:
+					res.WriteHeader(http.StatusOK)
+				case <-req.Context().Done():
+					res.WriteHeader(http.StatusServiceUnavailable)
+				}
+			})
+		}
+		server, err := NewHttpServer(ctx, l, HttpServerConfig{Address: "127.0.0.1", TempDir: t.TempDir(), DrainTimeout: drainTimeout}, WithMiddleware(slow))
+		require.NoError(t, err)
+		go func() { _ = server.Start() }()
+		return server, started, release
+	}
+	getSlow := func(server *HttpServer) chan error {
+		result := make(chan error, 1)
+		go func() {
+			res, err := http.Get("http://" + server.Addr() + "/slow")
+			if err == nil {
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					err = fmt.Errorf("unexpected status %d", res.StatusCode)
+				}
+			}
+			result <- err
+		}()
+		return result
+	}
+
+	// requests in flight complete while no new ones are accepted
+	server, started, release := newServer(0)
+	result := getSlow(server)
+	<-started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", server.Addr())
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	close(release)
+	require.NoError(t, <-result)
+	require.NoError(t, <-shutdown)
+
+	// requests still in flight once the drain timeout elapses are cut off
+	server, started, _ = newServer(100 * time.Millisecond)
+	result = getSlow(server)
+	<-started
+	require.NoError(t, server.Shutdown(ctx))
+	require.ErrorContains(t, <-result, "503")
+}
+
+func TestHttpServerSetRateLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mrn := mocknet.NewMockRetrievalNet(ctx, t)
+	require.NoError(t, mrn.MN.LinkAll())
+	l, err := lassie.NewLassie(ctx, lassie.WithHost(mrn.Self), lassie.WithFinder(mrn.Finder))
+	require.NoError(t, err)
+
+	server, err := NewHttpServer(ctx, l, HttpServerConfig{Address: "127.0.0.1", TempDir: t.TempDir()})
+	require.NoError(t, err)
+	go func() { _ = server.Start() }()
+	defer server.Close()
+
+	get := func() int {
+		res, err := http.Get("http://" + server.Addr() + "/stats/failures")
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// limits may be set on a server started without any, and removed again
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, http.StatusOK, get())
+	server.SetRateLimits(RateLimits{IP: RateLimit{RequestsPerSecond: 0.01, RequestBurst: 1}})
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, http.StatusTooManyRequests, get())
+	server.SetRateLimits(RateLimits{})
+	require.Equal(t, http.StatusOK, get())
+}
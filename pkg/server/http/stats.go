@@ -0,0 +1,83 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/lassie"
+)
+
+// FailureStatsWindows are the windows summarized by FailureStatsHandler when
+// none is requested.
+var FailureStatsWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// FailureStatsHandler returns a handler responding with a JSON array of
+// lassie.FailureSummary, one for each of FailureStatsWindows, or for the
+// window given by the "window" query parameter, e.g. "?window=30m".
+func FailureStatsHandler(l *lassie.Lassie) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+
+		windows := FailureStatsWindows
+		if req.URL.Query().Has("window") {
+			window, err := time.ParseDuration(req.URL.Query().Get("window"))
+			if err != nil || window <= 0 || window > lassie.FailureStatsRetention {
+				errorResponse(res, statusLogger, http.StatusBadRequest, fmt.Errorf("invalid window, must be a duration up to %s", lassie.FailureStatsRetention))
+				return
+			}
+			windows = []time.Duration{window}
+		}
+
+		summaries := make([]lassie.FailureSummary, 0, len(windows))
+		for _, window := range windows {
+			summaries = append(summaries, l.FailureStats(window))
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		res.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(res).Encode(summaries); err != nil {
+			logger.Debugw("failed to write failure stats response", "err", err)
+		}
+		statusLogger.logStatus(http.StatusOK, "OK")
+	}
+}
+
+// ProtocolsHandler returns a handler responding with the JSON of
+// lassie.Protocols, whether each configured protocol is currently enabled.
+func ProtocolsHandler(l *lassie.Lassie) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+		writeProtocols(res, statusLogger, l)
+	}
+}
+
+// SessionStateHandler returns a handler responding with the JSON of
+// lassie.SessionState, the statistics held for each provider.
+func SessionStateHandler(l *lassie.Lassie) func(http.ResponseWriter, *http.Request) {
+	return func(res http.ResponseWriter, req *http.Request) {
+		statusLogger := newStatusLogger(req.Method, req.URL.Path)
+
+		if !checkGet(req, res, statusLogger) {
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		res.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(res).Encode(l.SessionState()); err != nil {
+			logger.Debugw("failed to write session state response", "err", err)
+		}
+		statusLogger.logStatus(http.StatusOK, "OK")
+	}
+}
@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig configures the server to terminate TLS itself, so that it may be
+// exposed without a reverse proxy in front of it. The certificate is either
+// loaded from CertFile and KeyFile, or obtained and renewed automatically for
+// the ACMEDomains from an ACME certificate authority, Let's Encrypt by
+// default.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// ACMEDomains are the domains to obtain a certificate for, accepting the
+	// terms of service of the certificate authority. Certificates are only
+	// issued for these domains, whatever the server name clients connect
+	// with.
+	ACMEDomains []string
+	// ACMECacheDir is the directory in which certificates and the ACME
+	// account key are kept, so that they survive a restart. Without it they
+	// are obtained again on every start, which certificate authorities rate
+	// limit.
+	ACMECacheDir string
+	// ACMEEmail, if set, is given to the certificate authority as the contact
+	// for problems with the certificates.
+	ACMEEmail string
+	// ACMEDirectoryURL is the directory of the certificate authority;
+	// Let's Encrypt's production directory, acme.LetsEncryptURL, when empty.
+	ACMEDirectoryURL string
+	// ACMEHTTPAddress, if set, is an address, such as ":80", on which the
+	// server answers the certificate authority's HTTP-01 challenges and
+	// redirects every other request to HTTPS. Without it the domains are
+	// validated with the TLS-ALPN-01 challenge, which the certificate
+	// authority makes on port 443, so the server must be reachable there.
+	ACMEHTTPAddress string
+}
+
+func (c *TLSConfig) usesACME() bool {
+	return len(c.ACMEDomains) > 0
+}
+
+// validate returns an error if the configuration doesn't describe exactly one
+// source of certificates.
+func (c *TLSConfig) validate() error {
+	switch {
+	case c.usesACME() && (c.CertFile != "" || c.KeyFile != ""):
+		return errors.New("TLS certificate files can't be used with ACME")
+	case c.usesACME():
+		return nil
+	case c.CertFile == "" || c.KeyFile == "":
+		return errors.New("TLS requires both a certificate and a key file, or ACME domains")
+	case c.ACMECacheDir != "" || c.ACMEEmail != "" || c.ACMEDirectoryURL != "" || c.ACMEHTTPAddress != "":
+		return errors.New("ACME options require ACME domains")
+	}
+	return nil
+}
+
+// build returns the tls.Config of the server and, when certificates are
+// obtained with ACME, the server to answer HTTP-01 challenges on, if any.
+func (c *TLSConfig) build() (*tls.Config, *http.Server, error) {
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	// responses to failed retrievals are cut short by hijacking the
+	// connection, which HTTP/2 doesn't allow, so only HTTP/1.1 is offered
+	if !c.usesACME() {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+			MinVersion:   tls.VersionTLS12,
+		}, nil, nil
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.ACMEDomains...),
+		Email:      c.ACMEEmail,
+	}
+	if c.ACMECacheDir != "" {
+		manager.Cache = autocert.DirCache(c.ACMECacheDir)
+	}
+	if c.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: c.ACMEDirectoryURL}
+	}
+	tlsCfg := manager.TLSConfig()
+	tlsCfg.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	tlsCfg.MinVersion = tls.VersionTLS12
+
+	var challengeServer *http.Server
+	if c.ACMEHTTPAddress != "" {
+		challengeServer = &http.Server{
+			Addr:              c.ACMEHTTPAddress,
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return tlsCfg, challengeServer, nil
+}
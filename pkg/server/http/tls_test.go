@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+)
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile)
+
+	tests := []struct {
+		name          string
+		cfg           TLSConfig
+		wantErr       string
+		wantProtos    []string
+		wantCert      bool
+		wantChallenge string
+	}{
+		{
+			name:       "certificate files",
+			cfg:        TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			wantProtos: []string{"http/1.1"},
+			wantCert:   true,
+		},
+		{
+			name:    "certificate without key",
+			cfg:     TLSConfig{CertFile: certFile},
+			wantErr: "TLS requires both a certificate and a key file, or ACME domains",
+		},
+		{
+			name:    "missing certificate file",
+			cfg:     TLSConfig{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile},
+			wantErr: "failed to load TLS certificate",
+		},
+		{
+			name:    "certificate as key",
+			cfg:     TLSConfig{CertFile: certFile, KeyFile: certFile},
+			wantErr: "failed to load TLS certificate",
+		},
+		{
+			name:    "nothing",
+			wantErr: "TLS requires both a certificate and a key file, or ACME domains",
+		},
+		{
+			name:       "ACME",
+			cfg:        TLSConfig{ACMEDomains: []string{"lassie.example.com"}, ACMECacheDir: filepath.Join(dir, "acme")},
+			wantProtos: []string{"http/1.1", acme.ALPNProto},
+		},
+		{
+			name:          "ACME with HTTP-01 challenges",
+			cfg:           TLSConfig{ACMEDomains: []string{"lassie.example.com"}, ACMEHTTPAddress: ":8080", ACMEDirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory"},
+			wantProtos:    []string{"http/1.1", acme.ALPNProto},
+			wantChallenge: ":8080",
+		},
+		{
+			name:    "ACME with certificate files",
+			cfg:     TLSConfig{ACMEDomains: []string{"lassie.example.com"}, CertFile: certFile, KeyFile: keyFile},
+			wantErr: "TLS certificate files can't be used with ACME",
+		},
+		{
+			name:    "ACME options without domains",
+			cfg:     TLSConfig{CertFile: certFile, KeyFile: keyFile, ACMEEmail: "ops@example.com"},
+			wantErr: "ACME options require ACME domains",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCfg, challengeServer, err := tt.cfg.build()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantProtos, tlsCfg.NextProtos)
+			require.Equal(t, uint16(tls.VersionTLS12), tlsCfg.MinVersion)
+			if tt.wantCert {
+				require.Len(t, tlsCfg.Certificates, 1)
+			} else {
+				require.NotNil(t, tlsCfg.GetCertificate)
+				// certificates are only obtained for the configured domains
+				_, err := tlsCfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+				require.Error(t, err)
+			}
+			if tt.wantChallenge != "" {
+				require.NotNil(t, challengeServer)
+				require.Equal(t, tt.wantChallenge, challengeServer.Addr)
+			} else {
+				require.Nil(t, challengeServer)
+			}
+		})
+	}
+}
+
+// writeSelfSignedCert writes a certificate for localhost and its key, as PEM.
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+}
@@ -1,10 +1,14 @@
 package session
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
 )
 
 type Random interface{ Float64() float64 }
@@ -12,6 +16,23 @@ type Random interface{ Float64() float64 }
 type ProviderConfig struct {
 	RetrievalTimeout        time.Duration
 	MaxConcurrentRetrievals uint
+	// Protocols override the RetrievalTimeout and MaxConcurrentRetrievals for
+	// retrievals over a particular protocol, such as longer timeouts for
+	// Graphsync from cold storage than for HTTP. Zero values are inherited.
+	// MaxConcurrentRetrievals still counts all of the retrievals from a
+	// storage provider, whatever their protocol.
+	Protocols map[multicodec.Code]ProviderConfig
+}
+
+// override returns the config with the non-zero values of the other config.
+func (pc ProviderConfig) override(other ProviderConfig) ProviderConfig {
+	if other.MaxConcurrentRetrievals != 0 {
+		pc.MaxConcurrentRetrievals = other.MaxConcurrentRetrievals
+	}
+	if other.RetrievalTimeout != 0 {
+		pc.RetrievalTimeout = other.RetrievalTimeout
+	}
+	return pc
 }
 
 // All config values should be safe to leave uninitialized
@@ -22,11 +43,27 @@ type Config struct {
 	ProviderAllowList     map[peer.ID]bool
 	DefaultProviderConfig ProviderConfig
 	ProviderConfigs       map[peer.ID]ProviderConfig
+	// Region is the region that the session is running in, storage providers
+	// recorded as being in the same region are scored with the RegionWeight.
+	// An empty Region, the default, prefers no region.
+	Region string
 
 	// --- Dynamic state config
 
 	// Random is an optional rng, if nil, math/rand will be used.
 	Random Random
+	// Clock is an optional clock, if nil, the system clock will be used.
+	Clock clock.Clock
+
+	// CircuitBreaker configures the suspension of storage providers that fail
+	// repeatedly, see CircuitBreaker.
+	CircuitBreaker CircuitBreaker
+
+	// AllowListAlertThreshold is the number of consecutive failures of an
+	// allowlisted storage provider after which an operator is alerted, see
+	// Session#RecordAllowListedFailure. They are counted whether or not the
+	// CircuitBreaker is enabled, 0 disables the alerts.
+	AllowListAlertThreshold uint
 
 	// ConnectTimeAlpha is the alpha value for the exponential moving average
 	// of the connect time for a storage provider. The connect time is the time
@@ -102,6 +139,32 @@ type Config struct {
 	// range of [0, 1] (where each failure contributes a 0 and each success
 	// contributes a 1).
 	SuccessWeight float64
+	// RegionWeight is the scoring weight applied when a candidate's storage
+	// provider is in the same region as the session, see Region. The weight is
+	// a multiplier of the base value of `1.0` when the regions match.
+	RegionWeight float64
+
+	// SmallContentThreshold is the size, in bytes, at or below which content
+	// is considered small. Small content is retrieved from the candidate with
+	// the lowest time to first byte. A value of 0 disables this size class.
+	SmallContentThreshold uint64
+	// LargeContentThreshold is the size, in bytes, at or above which content
+	// is considered large. Candidates for large content are scored with the
+	// LargeContentBandwidthWeight in place of the BandwidthWeight. A value of
+	// 0 disables this size class.
+	LargeContentThreshold uint64
+	// LargeContentBandwidthWeight is the scoring weight applied to the
+	// bandwidth exponential moving average when scoring candidates for large
+	// content.
+	LargeContentBandwidthWeight float64
+	// MaxContentSizes is the number of content sizes recorded with
+	// RecordContentSize that are remembered, the least recently used being
+	// forgotten beyond it. A value of 0 remembers none.
+	MaxContentSizes int
+	// ContentSizeTTL is how long a recorded content size is remembered for,
+	// after which the content is treated as being of unknown size until it's
+	// retrieved again. A value of 0 remembers sizes until they're evicted.
+	ContentSizeTTL time.Duration
 }
 
 // DefaultConfig returns a default config with usable alpha and weight values.
@@ -120,9 +183,128 @@ func DefaultConfig() *Config {
 		FirstByteTimeWeight:          1.0,
 		BandwidthWeight:              0.5,
 		SuccessWeight:                1.0,
+		RegionWeight:                 1.0,
+		SmallContentThreshold:        1 << 20, // 1 MiB
+		LargeContentThreshold:        1 << 30, // 1 GiB
+		LargeContentBandwidthWeight:  3.0,
+		MaxContentSizes:              10000,
+		ContentSizeTTL:               24 * time.Hour,
+		CircuitBreaker:               DefaultCircuitBreaker(),
+		AllowListAlertThreshold:      5,
 	}
 }
 
+// CircuitBreaker configures the circuit breaker that each storage provider
+// has, so that a flapping storage provider doesn't fail every retrieval that
+// it is a candidate for. After Threshold consecutive failures the breaker
+// opens and the storage provider is not retrieved from for the Cooldown. Once
+// the cooldown has passed, the next failure opens the breaker again for twice
+// the previous cooldown, up to MaxCooldown, while a success closes it.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures that open the breaker,
+	// 0 disables the circuit breaker.
+	Threshold uint
+	// Cooldown is how long the breaker stays open the first time it opens.
+	Cooldown time.Duration
+	// MaxCooldown is the longest the breaker stays open, 0 is no limit.
+	MaxCooldown time.Duration
+}
+
+// DefaultCircuitBreaker returns the circuit breaker config of DefaultConfig.
+func DefaultCircuitBreaker() CircuitBreaker {
+	return CircuitBreaker{
+		Threshold:   5,
+		Cooldown:    30 * time.Second,
+		MaxCooldown: 10 * time.Minute,
+	}
+}
+
+// cooldown returns how long the breaker stays open when it has opened the
+// given number of times in a row, the first being 1.
+func (cb CircuitBreaker) cooldown(opened uint) time.Duration {
+	cooldown := cb.Cooldown
+	for i := uint(1); i < opened; i++ {
+		if cooldown > math.MaxInt64/2 || (cb.MaxCooldown > 0 && cooldown >= cb.MaxCooldown) {
+			break
+		}
+		cooldown *= 2
+	}
+	if cb.MaxCooldown > 0 && cooldown > cb.MaxCooldown {
+		cooldown = cb.MaxCooldown
+	}
+	return cooldown
+}
+
+// ScoringWeights are the weights that candidates are scored with when choosing
+// between them, each being a multiplier of the contribution of a piece of
+// metadata or a collected metric to a candidate's score, see the Config fields
+// of the same names. A weight of 0 ignores the metadata or metric.
+type ScoringWeights struct {
+	GraphsyncVerifiedDeal  float64
+	GraphsyncFastRetrieval float64
+	ConnectTime            float64
+	FirstByteTime          float64
+	Bandwidth              float64
+	LargeContentBandwidth  float64
+	Success                float64
+	Region                 float64
+}
+
+// DefaultScoringWeights returns the scoring weights of DefaultConfig, as a
+// starting point for tuning.
+func DefaultScoringWeights() ScoringWeights {
+	return DefaultConfig().ScoringWeights()
+}
+
+// Validate returns an error if any of the weights is negative.
+func (w ScoringWeights) Validate() error {
+	for _, weight := range []struct {
+		name  string
+		value float64
+	}{
+		{"graphsync verified deal", w.GraphsyncVerifiedDeal},
+		{"graphsync fast retrieval", w.GraphsyncFastRetrieval},
+		{"connect time", w.ConnectTime},
+		{"first byte time", w.FirstByteTime},
+		{"bandwidth", w.Bandwidth},
+		{"large content bandwidth", w.LargeContentBandwidth},
+		{"success", w.Success},
+		{"region", w.Region},
+	} {
+		if weight.value < 0 {
+			return fmt.Errorf("%s scoring weight must not be negative, got %v", weight.name, weight.value)
+		}
+	}
+	return nil
+}
+
+// ScoringWeights returns the scoring weights of the config.
+func (cfg *Config) ScoringWeights() ScoringWeights {
+	return ScoringWeights{
+		GraphsyncVerifiedDeal:  cfg.GraphsyncVerifiedDealWeight,
+		GraphsyncFastRetrieval: cfg.GraphsyncFastRetrievalWeight,
+		ConnectTime:            cfg.ConnectTimeWeight,
+		FirstByteTime:          cfg.FirstByteTimeWeight,
+		Bandwidth:              cfg.BandwidthWeight,
+		LargeContentBandwidth:  cfg.LargeContentBandwidthWeight,
+		Success:                cfg.SuccessWeight,
+		Region:                 cfg.RegionWeight,
+	}
+}
+
+// WithScoringWeights sets all of the scoring weights.
+func (cfg Config) WithScoringWeights(weights ScoringWeights) *Config {
+	cfg.GraphsyncVerifiedDealWeight = weights.GraphsyncVerifiedDeal
+	cfg.GraphsyncFastRetrievalWeight = weights.GraphsyncFastRetrieval
+	cfg.ConnectTimeWeight = weights.ConnectTime
+	cfg.FirstByteTimeWeight = weights.FirstByteTime
+	cfg.BandwidthWeight = weights.Bandwidth
+	cfg.LargeContentBandwidthWeight = weights.LargeContentBandwidth
+	cfg.SuccessWeight = weights.Success
+	cfg.RegionWeight = weights.Region
+	return &cfg
+}
+
 // WithProviderBlockList sets the provider blocklist.
 func (cfg Config) WithProviderBlockList(blocklist map[peer.ID]bool) *Config {
 	cfg.ProviderBlockList = blocklist
@@ -147,6 +329,12 @@ func (cfg Config) WithProviderConfigs(providerConfigs map[peer.ID]ProviderConfig
 	return &cfg
 }
 
+// WithRegion sets the region that the session is running in.
+func (cfg Config) WithRegion(region string) *Config {
+	cfg.Region = region
+	return &cfg
+}
+
 // WithConnectTimeAlpha sets the connect time alpha.
 func (cfg Config) WithConnectTimeAlpha(alpha float64) *Config {
 	cfg.ConnectTimeAlpha = alpha
@@ -177,6 +365,26 @@ func (cfg Config) WithSuccessAlpha(alpha float64) *Config {
 	return &cfg
 }
 
+// WithClock sets the clock used to time the circuit breakers of storage
+// providers.
+func (cfg Config) WithClock(clock clock.Clock) *Config {
+	cfg.Clock = clock
+	return &cfg
+}
+
+// WithCircuitBreaker sets the circuit breaker config.
+func (cfg Config) WithCircuitBreaker(circuitBreaker CircuitBreaker) *Config {
+	cfg.CircuitBreaker = circuitBreaker
+	return &cfg
+}
+
+// WithAllowListAlertThreshold sets the number of consecutive failures of an
+// allowlisted storage provider after which an operator is alerted.
+func (cfg Config) WithAllowListAlertThreshold(threshold uint) *Config {
+	cfg.AllowListAlertThreshold = threshold
+	return &cfg
+}
+
 // WithoutRandomness removes the dice roll for choosing the best peer, with this
 // set, it will always choose the peer with the highest score.
 func (cfg Config) WithoutRandomness() *Config {
@@ -208,12 +416,56 @@ func (cfg Config) WithFirstByteTimeWeight(weight float64) *Config {
 	return &cfg
 }
 
+// WithBandwidthWeight sets the bandwidth weight.
+func (cfg Config) WithBandwidthWeight(weight float64) *Config {
+	cfg.BandwidthWeight = weight
+	return &cfg
+}
+
 // WithSuccessWeight sets the success weight.
 func (cfg Config) WithSuccessWeight(weight float64) *Config {
 	cfg.SuccessWeight = weight
 	return &cfg
 }
 
+// WithRegionWeight sets the same region weight.
+func (cfg Config) WithRegionWeight(weight float64) *Config {
+	cfg.RegionWeight = weight
+	return &cfg
+}
+
+// WithSmallContentThreshold sets the size at or below which content is
+// considered small.
+func (cfg Config) WithSmallContentThreshold(size uint64) *Config {
+	cfg.SmallContentThreshold = size
+	return &cfg
+}
+
+// WithLargeContentThreshold sets the size at or above which content is
+// considered large.
+func (cfg Config) WithLargeContentThreshold(size uint64) *Config {
+	cfg.LargeContentThreshold = size
+	return &cfg
+}
+
+// WithMaxContentSizes sets the number of recorded content sizes remembered.
+func (cfg Config) WithMaxContentSizes(max int) *Config {
+	cfg.MaxContentSizes = max
+	return &cfg
+}
+
+// WithContentSizeTTL sets how long a recorded content size is remembered for.
+func (cfg Config) WithContentSizeTTL(ttl time.Duration) *Config {
+	cfg.ContentSizeTTL = ttl
+	return &cfg
+}
+
+// WithLargeContentBandwidthWeight sets the bandwidth weight for large content.
+func (cfg Config) WithLargeContentBandwidthWeight(weight float64) *Config {
+	cfg.LargeContentBandwidthWeight = weight
+	return &cfg
+}
+
 // roll returns a random float64 between 0 and 1.
 func (c *Config) roll() float64 {
 	if c.Random == nil {
@@ -222,18 +474,22 @@ func (c *Config) roll() float64 {
 	return c.Random.Float64()
 }
 
-// getProviderConfig returns the provider config for a given peer.
-// If no config is set for the peer, the default config is returned.
-func (cfg *Config) getProviderConfig(peer peer.ID) ProviderConfig {
+// getProviderConfig returns the provider config for a given peer and
+// protocol. The default config is overridden by the default config for the
+// protocol, then the peer's config and finally the peer's config for the
+// protocol.
+func (cfg *Config) getProviderConfig(peer peer.ID, protocol multicodec.Code) ProviderConfig {
 	minerCfg := cfg.DefaultProviderConfig
+	if protocolCfg, ok := cfg.DefaultProviderConfig.Protocols[protocol]; ok {
+		minerCfg = minerCfg.override(protocolCfg)
+	}
 	if individual, ok := cfg.ProviderConfigs[peer]; ok {
-		if individual.MaxConcurrentRetrievals != 0 {
-			minerCfg.MaxConcurrentRetrievals = individual.MaxConcurrentRetrievals
-		}
-		if individual.RetrievalTimeout != 0 {
-			minerCfg.RetrievalTimeout = individual.RetrievalTimeout
+		minerCfg = minerCfg.override(individual)
+		if protocolCfg, ok := individual.Protocols[protocol]; ok {
+			minerCfg = minerCfg.override(protocolCfg)
 		}
 	}
+	minerCfg.Protocols = nil
 	return minerCfg
 }
 
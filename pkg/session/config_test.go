@@ -0,0 +1,55 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetProviderConfig(t *testing.T) {
+	coldStorage := peer.ID("A")
+	gateway := peer.ID("B")
+	other := peer.ID("C")
+	cfg := DefaultConfig().
+		WithDefaultProviderConfig(ProviderConfig{
+			RetrievalTimeout:        20 * time.Second,
+			MaxConcurrentRetrievals: 4,
+			Protocols: map[multicodec.Code]ProviderConfig{
+				multicodec.TransportIpfsGatewayHttp: {RetrievalTimeout: 5 * time.Second},
+			},
+		}).
+		WithProviderConfigs(map[peer.ID]ProviderConfig{
+			coldStorage: {
+				MaxConcurrentRetrievals: 1,
+				Protocols: map[multicodec.Code]ProviderConfig{
+					multicodec.TransportGraphsyncFilecoinv1: {RetrievalTimeout: 5 * time.Minute},
+				},
+			},
+			gateway: {
+				RetrievalTimeout:        time.Second,
+				MaxConcurrentRetrievals: 16,
+			},
+		})
+
+	testCases := []struct {
+		name     string
+		peer     peer.ID
+		protocol multicodec.Code
+		expected ProviderConfig
+	}{
+		{"default", other, multicodec.TransportGraphsyncFilecoinv1, ProviderConfig{RetrievalTimeout: 20 * time.Second, MaxConcurrentRetrievals: 4}},
+		{"default for protocol", other, multicodec.TransportIpfsGatewayHttp, ProviderConfig{RetrievalTimeout: 5 * time.Second, MaxConcurrentRetrievals: 4}},
+		{"provider for protocol", coldStorage, multicodec.TransportGraphsyncFilecoinv1, ProviderConfig{RetrievalTimeout: 5 * time.Minute, MaxConcurrentRetrievals: 1}},
+		{"provider over default for protocol", coldStorage, multicodec.TransportIpfsGatewayHttp, ProviderConfig{RetrievalTimeout: 5 * time.Second, MaxConcurrentRetrievals: 1}},
+		{"provider", gateway, multicodec.TransportIpfsGatewayHttp, ProviderConfig{RetrievalTimeout: time.Second, MaxConcurrentRetrievals: 16}},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, cfg.getProviderConfig(testCase.peer, testCase.protocol))
+		})
+	}
+}
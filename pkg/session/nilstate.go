@@ -14,11 +14,17 @@ var _ State = nilstate{}
 
 type nilstate struct{}
 
-func (ns nilstate) RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) error {
-	return nil
+func (ns nilstate) RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) (time.Duration, error) {
+	return 0, nil
 }
 
-func (ns nilstate) RecordSuccess(storageProviderId peer.ID, bandwidth uint64) {}
+func (ns nilstate) RecordSuccess(storageProviderId peer.ID, bandwidth uint64) bool {
+	return false
+}
+
+func (ns nilstate) IsSuspended(storageProviderId peer.ID) bool {
+	return false
+}
 
 func (ns nilstate) GetConcurrency(storageProviderId peer.ID) uint {
 	return 0
@@ -38,8 +44,32 @@ func (ns nilstate) EndRetrieval(retrievalId types.RetrievalID) error {
 
 func (ns nilstate) RecordConnectTime(storageProviderId peer.ID, connectTime time.Duration) {}
 
+func (ns nilstate) HasConnectTime(storageProviderId peer.ID) bool {
+	return false
+}
+
+func (ns nilstate) RecordRegion(storageProviderId peer.ID, region string) {}
+
 func (ns nilstate) RecordFirstByteTime(storageProviderId peer.ID, firstByteTime time.Duration) {}
 
 func (ns nilstate) ChooseNextProvider(peers []peer.ID, mda []metadata.Protocol) int {
 	return 0
 }
+
+func (ns nilstate) ChooseNextProviderWithStrategy(peers []peer.ID, mda []metadata.Protocol, strategy Strategy) int {
+	return 0
+}
+
+func (ns nilstate) RecordContentSize(cid cid.Cid, selector datamodel.Node, size uint64) {}
+
+func (ns nilstate) ChooseStrategy(cid cid.Cid, selector datamodel.Node, expectedSize uint64) Strategy {
+	return StrategyBalanced
+}
+
+func (ns nilstate) RecordAffinity(key string, storageProviderId peer.ID) bool {
+	return false
+}
+
+func (ns nilstate) ChooseAffinityProvider(key string, peers []peer.ID) (int, bool) {
+	return 0, false
+}
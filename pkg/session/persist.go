@@ -0,0 +1,346 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/logging"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var logger = logging.Subsystem("lassie/session")
+
+var (
+	providersKey = datastore.NewKey("/session/providers")
+	overallKey   = datastore.NewKey("/session/overall")
+)
+
+const (
+	DefaultPersistSaveInterval = time.Minute
+	DefaultPersistHalfLife     = 24 * time.Hour
+
+	// minPersistedWeight is the weight below which saved metrics have decayed
+	// to almost nothing, and are discarded rather than loaded.
+	minPersistedWeight = 1.0 / 64
+)
+
+// ErrNoState is returned by Session#Persist for a session without dynamic
+// state to persist.
+var ErrNoState = errors.New("session has no state to persist")
+
+// PersistConfig configures the persistence of the per-provider metrics that
+// the session's state scores providers with, so that they survive restarts,
+// see Session#Persist.
+type PersistConfig struct {
+	// Datastore is where the metrics are saved, under /session/.
+	Datastore datastore.Batching
+	// SaveInterval is how often the metrics are saved, they are also saved
+	// once the context passed to Persist is cancelled. Defaults to
+	// DefaultPersistSaveInterval.
+	SaveInterval time.Duration
+	// HalfLife is the age at which saved metrics carry half of their weight
+	// when loaded, the rest being made up of the values assumed for a provider
+	// without any metrics. Metrics that have decayed to almost nothing are
+	// discarded. Defaults to DefaultPersistHalfLife.
+	HalfLife time.Duration
+	// Clock is an optional clock, if nil, the system clock will be used.
+	Clock clock.Clock
+}
+
+func (cfg PersistConfig) withDefaults() PersistConfig {
+	if cfg.SaveInterval <= 0 {
+		cfg.SaveInterval = DefaultPersistSaveInterval
+	}
+	if cfg.HalfLife <= 0 {
+		cfg.HalfLife = DefaultPersistHalfLife
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+	return cfg
+}
+
+// Persist loads the provider metrics saved in the configured datastore into
+// the session's state, then saves them periodically until the context is
+// cancelled, and once more after that. Saving is best-effort, metrics
+// recorded since the last save are lost if the process exits before the
+// final save has completed.
+func (session *Session) Persist(ctx context.Context, cfg PersistConfig) error {
+	state, ok := session.State.(*SessionState)
+	if !ok {
+		return ErrNoState
+	}
+	p := &persister{
+		state:  state,
+		cfg:    cfg.withDefaults(),
+		loaded: make(map[peer.ID]providerMetrics),
+	}
+	if err := p.load(ctx); err != nil {
+		return fmt.Errorf("failed to load provider metrics: %w", err)
+	}
+	session.persisted = make(chan struct{})
+	ticker := p.cfg.Clock.Ticker(p.cfg.SaveInterval)
+	go func() {
+		defer close(session.persisted)
+		p.run(ctx, ticker)
+	}()
+	return nil
+}
+
+// WaitPersisted waits for the final save of the provider metrics made once the
+// context passed to Persist is cancelled, so that the datastore can then be
+// closed. It returns immediately if Persist wasn't called, or the context's
+// error if it's cancelled first.
+func (session *Session) WaitPersisted(ctx context.Context) error {
+	if session.persisted == nil {
+		return nil
+	}
+	select {
+	case <-session.persisted:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// providerMetrics are the metrics of a provider that are persisted.
+type providerMetrics struct {
+	connectTimeMs   metric[uint64]
+	firstByteTimeMs metric[uint64]
+	bandwidthBps    metric[uint64]
+	success         metric[float64]
+}
+
+func (pm providerMetrics) empty() bool {
+	return pm == providerMetrics{}
+}
+
+// persistedMetrics is the JSON form of providerMetrics, with the time they
+// were saved. The overall metrics are persisted in the same form, without a
+// success metric.
+type persistedMetrics struct {
+	ConnectTimeMs   *uint64   `json:"connectTimeMs,omitempty"`
+	FirstByteTimeMs *uint64   `json:"firstByteTimeMs,omitempty"`
+	BandwidthBps    *uint64   `json:"bandwidthBps,omitempty"`
+	Success         *float64  `json:"success,omitempty"`
+	SavedAt         time.Time `json:"savedAt"`
+}
+
+func toPersisted[T any](m metric[T]) *T {
+	if !m.initialized {
+		return nil
+	}
+	return &m.value
+}
+
+func fromPersisted[T any](v *T) metric[T] {
+	if v == nil {
+		return metric[T]{}
+	}
+	return metric[T]{initialized: true, value: *v}
+}
+
+func (pm providerMetrics) persisted(savedAt time.Time) persistedMetrics {
+	return persistedMetrics{
+		ConnectTimeMs:   toPersisted(pm.connectTimeMs),
+		FirstByteTimeMs: toPersisted(pm.firstByteTimeMs),
+		BandwidthBps:    toPersisted(pm.bandwidthBps),
+		Success:         toPersisted(pm.success),
+		SavedAt:         savedAt,
+	}
+}
+
+func (pm persistedMetrics) metrics() providerMetrics {
+	return providerMetrics{
+		connectTimeMs:   fromPersisted(pm.ConnectTimeMs),
+		firstByteTimeMs: fromPersisted(pm.FirstByteTimeMs),
+		bandwidthBps:    fromPersisted(pm.BandwidthBps),
+		success:         fromPersisted(pm.Success),
+	}
+}
+
+// decayUint64 blends a saved metric with the value assumed when there is
+// none, the overall metric, by the weight of the saved metric.
+func decayUint64(saved metric[uint64], overall metric[uint64], weight float64) metric[uint64] {
+	if !saved.initialized || !overall.initialized {
+		return saved
+	}
+	saved.value = uint64(weight*float64(saved.value) + (1-weight)*float64(overall.value))
+	return saved
+}
+
+type persister struct {
+	state *SessionState
+	cfg   PersistConfig
+	// loaded holds the metrics of the providers as they were loaded, those
+	// that are unchanged when saving are skipped so that they keep their age
+	loaded map[peer.ID]providerMetrics
+}
+
+func (p *persister) run(ctx context.Context, ticker *clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := p.save(saveCtx); err != nil {
+				logger.Errorw("Failed to save provider metrics", "err", err)
+			}
+			return
+		case <-ticker.C:
+			if err := p.save(ctx); err != nil {
+				logger.Errorw("Failed to save provider metrics", "err", err)
+			}
+		}
+	}
+}
+
+// weight returns the weight of metrics saved at the given time, halving with
+// each HalfLife of age.
+func (p *persister) weight(savedAt time.Time) float64 {
+	age := p.cfg.Clock.Since(savedAt)
+	if age < 0 {
+		age = 0
+	}
+	return math.Exp2(-float64(age) / float64(p.cfg.HalfLife))
+}
+
+func (p *persister) load(ctx context.Context) error {
+	var overall persistedMetrics
+	byts, err := p.cfg.Datastore.Get(ctx, overallKey)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(byts, &overall); err != nil {
+			return err
+		}
+		if p.weight(overall.SavedAt) < minPersistedWeight {
+			overall = persistedMetrics{}
+		}
+	}
+	overallMetrics := overall.metrics()
+
+	results, err := p.cfg.Datastore.Query(ctx, query.Query{Prefix: providersKey.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	var discard []datastore.Key
+	p.state.lk.Lock()
+	defer p.state.lk.Unlock()
+	for result := range results.Next() {
+		if result.Error != nil {
+			return result.Error
+		}
+		key := datastore.RawKey(result.Key)
+		id, err := peer.Decode(key.BaseNamespace())
+		if err != nil {
+			logger.Warnw("Discarding saved metrics of invalid provider", "key", result.Key, "err", err)
+			discard = append(discard, key)
+			continue
+		}
+		var saved persistedMetrics
+		if err := json.Unmarshal(result.Value, &saved); err != nil {
+			logger.Warnw("Discarding invalid saved provider metrics", "storageProviderId", id, "err", err)
+			discard = append(discard, key)
+			continue
+		}
+		weight := p.weight(saved.SavedAt)
+		if weight < minPersistedWeight {
+			discard = append(discard, key)
+			continue
+		}
+		metrics := saved.metrics()
+		metrics.connectTimeMs = decayUint64(metrics.connectTimeMs, overallMetrics.connectTimeMs, weight)
+		metrics.firstByteTimeMs = decayUint64(metrics.firstByteTimeMs, overallMetrics.firstByteTimeMs, weight)
+		metrics.bandwidthBps = decayUint64(metrics.bandwidthBps, overallMetrics.bandwidthBps, weight)
+		// a provider without a success metric is treated as fully successful
+		if metrics.success.initialized {
+			metrics.success.value = weight*metrics.success.value + (1 - weight)
+		}
+
+		sp := p.state.spm[id]
+		sp.connectTimeMs = metrics.connectTimeMs
+		sp.firstByteTimeMs = metrics.firstByteTimeMs
+		sp.bandwidthBps = metrics.bandwidthBps
+		sp.success = metrics.success
+		p.state.spm[id] = sp
+		p.loaded[id] = metrics
+	}
+	p.state.overallConnectTimeMs = overallMetrics.connectTimeMs
+	p.state.overallFirstByteTimeMs = overallMetrics.firstByteTimeMs
+	p.state.overallBandwidthBps = overallMetrics.bandwidthBps
+	logger.Infow("Loaded provider metrics", "providers", len(p.loaded), "discarded", len(discard))
+
+	for _, key := range discard {
+		if err := p.cfg.Datastore.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *persister) save(ctx context.Context) error {
+	now := p.cfg.Clock.Now()
+	changed := make(map[peer.ID]providerMetrics)
+	p.state.lk.RLock()
+	for id, sp := range p.state.spm {
+		metrics := providerMetrics{
+			connectTimeMs:   sp.connectTimeMs,
+			firstByteTimeMs: sp.firstByteTimeMs,
+			bandwidthBps:    sp.bandwidthBps,
+			success:         sp.success,
+		}
+		if metrics.empty() || metrics == p.loaded[id] {
+			continue
+		}
+		changed[id] = metrics
+	}
+	overall := providerMetrics{
+		connectTimeMs:   p.state.overallConnectTimeMs,
+		firstByteTimeMs: p.state.overallFirstByteTimeMs,
+		bandwidthBps:    p.state.overallBandwidthBps,
+	}
+	p.state.lk.RUnlock()
+
+	batch, err := p.cfg.Datastore.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for id, metrics := range changed {
+		byts, err := json.Marshal(metrics.persisted(now))
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, providersKey.ChildString(id.String()), byts); err != nil {
+			return err
+		}
+	}
+	if !overall.empty() {
+		byts, err := json.Marshal(overall.persisted(now))
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, overallKey, byts); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	// saved metrics age from now, until they change again
+	for id, metrics := range changed {
+		p.loaded[id] = metrics
+	}
+	return nil
+}
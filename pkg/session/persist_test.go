@@ -0,0 +1,128 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersist(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	clk := clock.NewMock()
+	cfg := PersistConfig{Datastore: ds, SaveInterval: time.Minute, HalfLife: time.Hour, Clock: clk}
+	fast, err := test.RandPeerID()
+	require.NoError(t, err)
+	broken, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	// metrics are saved each interval
+	saveCtx, saveCancel := context.WithCancel(ctx)
+	session := NewSession(DefaultConfig(), true)
+	require.NoError(t, session.Persist(saveCtx, cfg))
+	session.RecordFirstByteTime(fast, 100*time.Millisecond)
+	session.RecordFirstByteTime(broken, 300*time.Millisecond)
+	session.RecordSuccess(fast, 1000)
+	_, err = session.RecordFailure(registeredRetrieval(t, session, broken), broken)
+	require.NoError(t, err)
+	clk.Add(time.Minute)
+	require.Eventually(t, func() bool {
+		has, err := ds.Has(ctx, providersKey.ChildString(broken.String()))
+		require.NoError(t, err)
+		return has
+	}, time.Second, time.Millisecond)
+	// and once more when the context is cancelled
+	saveCancel()
+	require.NoError(t, session.WaitPersisted(ctx))
+	require.NoError(t, NewSession(DefaultConfig(), true).WaitPersisted(ctx))
+
+	testCases := []struct {
+		name     string
+		age      time.Duration
+		expected map[peer.ID]providerMetrics
+	}{
+		{
+			name: "loaded as saved",
+			expected: map[peer.ID]providerMetrics{
+				fast: {
+					firstByteTimeMs: metric[uint64]{true, 100},
+					bandwidthBps:    metric[uint64]{true, 1000},
+					success:         metric[float64]{true, 1},
+				},
+				broken: {
+					firstByteTimeMs: metric[uint64]{true, 300},
+					success:         metric[float64]{true, 0},
+				},
+			},
+		},
+		{
+			// halfway to the overall time to first byte of 140ms, and to
+			// success
+			name: "decayed with age",
+			age:  time.Hour,
+			expected: map[peer.ID]providerMetrics{
+				fast: {
+					firstByteTimeMs: metric[uint64]{true, 120},
+					bandwidthBps:    metric[uint64]{true, 1000},
+					success:         metric[float64]{true, 1},
+				},
+				broken: {
+					firstByteTimeMs: metric[uint64]{true, 220},
+					success:         metric[float64]{true, 0.5},
+				},
+			},
+		},
+		{
+			name:     "discarded once decayed",
+			age:      10 * time.Hour,
+			expected: map[peer.ID]providerMetrics{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			loadClk := clock.NewMock()
+			loadClk.Set(clk.Now().Add(testCase.age))
+			cfg := cfg
+			cfg.Clock = loadClk
+			session := NewSession(DefaultConfig(), true)
+			require.NoError(t, session.Persist(ctx, cfg))
+			state := session.State.(*SessionState)
+			state.lk.RLock()
+			defer state.lk.RUnlock()
+			loaded := make(map[peer.ID]providerMetrics)
+			for id, sp := range state.spm {
+				loaded[id] = providerMetrics{sp.connectTimeMs, sp.firstByteTimeMs, sp.bandwidthBps, sp.success}
+			}
+			require.Equal(t, testCase.expected, loaded)
+		})
+	}
+
+	// the discarded metrics were deleted
+	has, err := ds.Has(ctx, providersKey.ChildString(broken.String()))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.ErrorIs(t, NewSession(DefaultConfig(), false).Persist(ctx, cfg), ErrNoState)
+}
+
+func registeredRetrieval(t *testing.T, session *Session, p peer.ID) types.RetrievalID {
+	id, err := types.NewRetrievalID()
+	require.NoError(t, err)
+	require.True(t, session.RegisterRetrieval(id, cid.MustParse("bafkqaalb"), selectorparse.CommonSelector_ExploreAllRecursively))
+	require.NoError(t, session.AddToRetrieval(id, []peer.ID{p}))
+	return id
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"sync"
 	"time"
 
 	"github.com/filecoin-project/lassie/pkg/types"
@@ -17,6 +18,17 @@ import (
 type Session struct {
 	State
 	config *Config
+
+	listsLk   sync.RWMutex
+	blockList map[peer.ID]bool
+	allowList map[peer.ID]bool
+
+	failuresLk          sync.Mutex
+	allowListedFailures map[peer.ID]uint
+
+	// persisted is closed once the final save of Persist has completed, it is
+	// nil unless Persist was called
+	persisted chan struct{}
 }
 
 // NewSession constructs a new Session with the given config and with or
@@ -36,13 +48,65 @@ func NewSession(config *Config, withState bool) *Session {
 	if config == nil {
 		config = &Config{}
 	}
-	return &Session{state, config}
+	return &Session{State: state, config: config}
+}
+
+// SetProviderLists replaces the provider block list and allow list that apply
+// in addition to those of the config, such as lists that are reloaded from a
+// file while Lassie is running. Providers on either block list are never
+// used, and a provider must be on each allow list that is non-empty.
+func (session *Session) SetProviderLists(blockList, allowList map[peer.ID]bool) {
+	session.listsLk.Lock()
+	defer session.listsLk.Unlock()
+	session.blockList = blockList
+	session.allowList = allowList
+}
+
+// IsAllowListed returns whether the storage provider is on the allow list of
+// the config or the one set with SetProviderLists, meaning that it's expected
+// to serve the content it's retrieved for.
+func (session *Session) IsAllowListed(storageProviderId peer.ID) bool {
+	if session.config.ProviderAllowList[storageProviderId] {
+		return true
+	}
+	session.listsLk.RLock()
+	defer session.listsLk.RUnlock()
+	return session.allowList[storageProviderId]
+}
+
+// RecordAllowListedFailure counts a failure of the storage provider if it's
+// allowlisted, returning true each time it has failed
+// Config#AllowListAlertThreshold times in a row, so that an operator can be
+// alerted. Failures are counted separately from the circuit breaker, and a
+// success, see RecordSuccess, resets the count.
+func (session *Session) RecordAllowListedFailure(storageProviderId peer.ID) bool {
+	threshold := session.config.AllowListAlertThreshold
+	if threshold == 0 || !session.IsAllowListed(storageProviderId) {
+		return false
+	}
+	session.failuresLk.Lock()
+	defer session.failuresLk.Unlock()
+	if session.allowListedFailures == nil {
+		session.allowListedFailures = make(map[peer.ID]uint)
+	}
+	session.allowListedFailures[storageProviderId]++
+	return session.allowListedFailures[storageProviderId]%threshold == 0
+}
+
+// RecordSuccess records a success with the State, see State#RecordSuccess,
+// and resets the count of RecordAllowListedFailure for the storage provider.
+func (session *Session) RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) bool {
+	session.failuresLk.Lock()
+	delete(session.allowListedFailures, storageProviderId)
+	session.failuresLk.Unlock()
+	return session.State.RecordSuccess(storageProviderId, bandwidthBytesPerSecond)
 }
 
 // GetStorageProviderTimeout returns the per-retrieval timeout from the
-// RetrievalTimeout configuration option.
-func (session *Session) GetStorageProviderTimeout(storageProviderId peer.ID) time.Duration {
-	return session.config.getProviderConfig(storageProviderId).RetrievalTimeout
+// RetrievalTimeout configuration option for the storage provider and
+// protocol.
+func (session *Session) GetStorageProviderTimeout(storageProviderId peer.ID, protocol multicodec.Code) time.Duration {
+	return session.config.getProviderConfig(storageProviderId, protocol).RetrievalTimeout
 }
 
 // FilterIndexerCandidate filters out protocols that are not acceptable for
@@ -77,13 +141,30 @@ func (session *Session) FilterIndexerCandidate(candidate types.RetrievalCandidat
 	}
 }
 
-func (session *Session) isAcceptableCandidate(storageProviderId peer.ID) bool {
+// isBlocked returns whether the storage provider is on a block list, or isn't
+// on an allow list that is non-empty.
+func (session *Session) isBlocked(storageProviderId peer.ID) bool {
 	// if blacklisted, candidate is not acceptable
 	if session.config.ProviderBlockList[storageProviderId] {
-		return false
+		return true
 	}
 	// if a whitelist exists and the candidate is not on it, candidate is not acceptable
 	if len(session.config.ProviderAllowList) > 0 && !session.config.ProviderAllowList[storageProviderId] {
+		return true
+	}
+	// likewise for the lists set at runtime
+	session.listsLk.RLock()
+	defer session.listsLk.RUnlock()
+	return session.blockList[storageProviderId] || (len(session.allowList) > 0 && !session.allowList[storageProviderId])
+}
+
+func (session *Session) isAcceptableCandidate(storageProviderId peer.ID) bool {
+	if session.isBlocked(storageProviderId) {
+		return false
+	}
+	// if its circuit breaker is open, candidate is not acceptable until the
+	// cooldown has passed
+	if session.State.IsSuspended(storageProviderId) {
 		return false
 	}
 	return true
@@ -96,7 +177,7 @@ func (session *Session) isAcceptableCandidateForProtocol(storageProviderId peer.
 
 	// check if we are currently retrieving from the candidate with its maximum
 	// concurrency
-	minerConfig := session.config.getProviderConfig(storageProviderId)
+	minerConfig := session.config.getProviderConfig(storageProviderId, protocol)
 	if minerConfig.MaxConcurrentRetrievals > 0 &&
 		session.State.GetConcurrency(storageProviderId) >= minerConfig.MaxConcurrentRetrievals {
 		return false
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAllowListedFailure(t *testing.T) {
+	allowListed := peer.ID("A")
+	other := peer.ID("B")
+	session := NewSession(DefaultConfig().
+		WithCircuitBreaker(CircuitBreaker{}).
+		WithAllowListAlertThreshold(2), true)
+	session.SetProviderLists(nil, map[peer.ID]bool{allowListed: true})
+
+	// each second failure in a row alerts, with the circuit breaker disabled
+	alerts := make([]bool, 0, 4)
+	for i := 0; i < 4; i++ {
+		alerts = append(alerts, session.RecordAllowListedFailure(allowListed))
+	}
+	require.Equal(t, []bool{false, true, false, true}, alerts)
+
+	// a success starts the count again
+	require.False(t, session.RecordAllowListedFailure(allowListed))
+	session.RecordSuccess(allowListed, 1000)
+	require.False(t, session.RecordAllowListedFailure(allowListed))
+	require.True(t, session.RecordAllowListedFailure(allowListed))
+
+	// providers that aren't allowlisted don't alert
+	require.False(t, session.RecordAllowListedFailure(other))
+	require.False(t, session.RecordAllowListedFailure(other))
+
+	// nor does any provider with alerts disabled
+	session = NewSession(DefaultConfig().WithAllowListAlertThreshold(0), true)
+	session.SetProviderLists(nil, map[peer.ID]bool{allowListed: true})
+	for i := 0; i < 10; i++ {
+		require.False(t, session.RecordAllowListedFailure(allowListed))
+	}
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// firstByteTimeSamples is the number of the most recent times to first byte
+// of each storage provider that the percentiles of a Snapshot are taken from.
+const firstByteTimeSamples = 128
+
+// Percentiles summarizes a distribution of times, in milliseconds.
+type Percentiles struct {
+	P50 uint64 `json:"p50"`
+	P90 uint64 `json:"p90"`
+	P99 uint64 `json:"p99"`
+}
+
+// ProviderStats are the statistics a session holds for a storage provider.
+// Metrics that haven't been recorded for the provider are nil.
+type ProviderStats struct {
+	ProviderId peer.ID `json:"providerId"`
+	// SuccessRate is the moving average of the outcomes of retrievals from
+	// the provider, from 0 when they all failed to 1 when they all succeeded.
+	SuccessRate *float64 `json:"successRate,omitempty"`
+	// ConnectTimeMs, FirstByteTimeMs and BandwidthBytesPerSecond are the
+	// moving averages that the provider is scored with.
+	ConnectTimeMs           *uint64 `json:"connectTimeMs,omitempty"`
+	FirstByteTimeMs         *uint64 `json:"firstByteTimeMs,omitempty"`
+	BandwidthBytesPerSecond *uint64 `json:"bandwidthBytesPerSecond,omitempty"`
+	// FirstByteTimePercentilesMs are the percentiles of the provider's most
+	// recent times to first byte.
+	FirstByteTimePercentilesMs *Percentiles `json:"firstByteTimePercentilesMs,omitempty"`
+	// Concurrency is the number of retrievals currently using the provider.
+	Concurrency uint   `json:"concurrency"`
+	Region      string `json:"region,omitempty"`
+	// ConsecutiveFailures counts the failures since the provider's last
+	// success, and SuspendedUntil is set while its circuit breaker is open.
+	ConsecutiveFailures uint       `json:"consecutiveFailures"`
+	SuspendedUntil      *time.Time `json:"suspendedUntil,omitempty"`
+	// Blocked is true if the provider is excluded by a block list or isn't on
+	// an allow list.
+	Blocked bool `json:"blocked"`
+}
+
+// Snapshot is a point in time copy of the statistics a session holds, see
+// Session#Snapshot.
+type Snapshot struct {
+	Time             time.Time `json:"time"`
+	ActiveRetrievals int       `json:"activeRetrievals"`
+	// ConnectTimeMs, FirstByteTimeMs and BandwidthBytesPerSecond are the
+	// moving averages across all providers.
+	ConnectTimeMs           *uint64 `json:"connectTimeMs,omitempty"`
+	FirstByteTimeMs         *uint64 `json:"firstByteTimeMs,omitempty"`
+	BandwidthBytesPerSecond *uint64 `json:"bandwidthBytesPerSecond,omitempty"`
+	// Providers are sorted by ProviderId.
+	Providers []ProviderStats `json:"providers"`
+}
+
+// Snapshot returns a copy of the session's statistics for each storage
+// provider it has recorded metrics for, or has on a block list, for dashboards
+// and debugging. A session without dynamic state only lists the providers on
+// its block lists.
+func (session *Session) Snapshot() Snapshot {
+	var snapshot Snapshot
+	if state, ok := session.State.(*SessionState); ok {
+		snapshot = state.snapshot()
+	} else {
+		snapshot.Time = time.Now()
+	}
+
+	listed := make(map[peer.ID]struct{}, len(snapshot.Providers))
+	for i, stats := range snapshot.Providers {
+		snapshot.Providers[i].Blocked = session.isBlocked(stats.ProviderId)
+		listed[stats.ProviderId] = struct{}{}
+	}
+	session.listsLk.RLock()
+	for _, blockList := range []map[peer.ID]bool{session.config.ProviderBlockList, session.blockList} {
+		for id, blocked := range blockList {
+			if _, ok := listed[id]; blocked && !ok {
+				snapshot.Providers = append(snapshot.Providers, ProviderStats{ProviderId: id, Blocked: true})
+				listed[id] = struct{}{}
+			}
+		}
+	}
+	session.listsLk.RUnlock()
+	sort.Slice(snapshot.Providers, func(i, j int) bool {
+		return snapshot.Providers[i].ProviderId < snapshot.Providers[j].ProviderId
+	})
+	return snapshot
+}
+
+func (spt *SessionState) snapshot() Snapshot {
+	spt.lk.RLock()
+	defer spt.lk.RUnlock()
+
+	now := spt.clock.Now()
+	snapshot := Snapshot{
+		Time:                    now,
+		ActiveRetrievals:        len(spt.arm),
+		ConnectTimeMs:           toPersisted(spt.overallConnectTimeMs),
+		FirstByteTimeMs:         toPersisted(spt.overallFirstByteTimeMs),
+		BandwidthBytesPerSecond: toPersisted(spt.overallBandwidthBps),
+		Providers:               make([]ProviderStats, 0, len(spt.spm)),
+	}
+	for id, status := range spt.spm {
+		stats := ProviderStats{
+			ProviderId:                 id,
+			SuccessRate:                toPersisted(status.success),
+			ConnectTimeMs:              toPersisted(status.connectTimeMs),
+			FirstByteTimeMs:            toPersisted(status.firstByteTimeMs),
+			BandwidthBytesPerSecond:    toPersisted(status.bandwidthBps),
+			FirstByteTimePercentilesMs: percentiles(status.firstByteTimesMs),
+			Concurrency:                status.concurrency,
+			Region:                     status.region,
+			ConsecutiveFailures:        status.consecutiveFailures,
+		}
+		if now.Before(status.suspendedUntil) {
+			suspendedUntil := status.suspendedUntil
+			stats.SuspendedUntil = &suspendedUntil
+		}
+		snapshot.Providers = append(snapshot.Providers, stats)
+	}
+	return snapshot
+}
+
+// percentiles returns the nearest-rank percentiles of the samples, or nil if
+// there are none.
+func percentiles(samples []uint64) *Percentiles {
+	if len(samples) == 0 {
+		return nil
+	}
+	sorted := append([]uint64(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p int) uint64 {
+		// the smallest sample that p percent of the samples are at or below
+		return sorted[(p*len(sorted)+99)/100-1]
+	}
+	return &Percentiles{P50: rank(50), P90: rank(90), P99: rank(99)}
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/ipfs/go-cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	clk := clock.NewMock()
+	cfg := DefaultConfig().
+		WithClock(clk).
+		WithCircuitBreaker(CircuitBreaker{Threshold: 1, Cooldown: time.Minute}).
+		WithProviderBlockList(map[peer.ID]bool{"C": true})
+	session := NewSession(cfg, true)
+	session.SetProviderLists(map[peer.ID]bool{"D": true}, nil)
+	fast, failing := peer.ID("A"), peer.ID("B")
+
+	require.True(t, session.RegisterRetrieval(retrievalId, cid.MustParse("bafkqaalb"), selectorparse.CommonSelector_ExploreAllRecursively))
+	require.NoError(t, session.AddToRetrieval(retrievalId, []peer.ID{fast, failing}))
+	for i := 1; i <= 10; i++ {
+		session.RecordFirstByteTime(fast, time.Duration(i*10)*time.Millisecond)
+	}
+	require.False(t, session.RecordSuccess(fast, 1000))
+	_, err := session.RecordFailure(retrievalId, failing)
+	require.NoError(t, err)
+
+	snapshot := session.Snapshot()
+	require.Equal(t, clk.Now(), snapshot.Time)
+	require.Equal(t, 1, snapshot.ActiveRetrievals)
+	require.Equal(t, uint64(1000), *snapshot.BandwidthBytesPerSecond)
+	require.Nil(t, snapshot.ConnectTimeMs)
+	require.Len(t, snapshot.Providers, 4)
+
+	stats := snapshot.Providers[0]
+	require.Equal(t, fast, stats.ProviderId)
+	require.Equal(t, 1.0, *stats.SuccessRate)
+	require.Equal(t, &Percentiles{P50: 50, P90: 90, P99: 100}, stats.FirstByteTimePercentilesMs)
+	require.Equal(t, uint(1), stats.Concurrency)
+	require.Nil(t, stats.SuspendedUntil)
+	require.False(t, stats.Blocked)
+
+	stats = snapshot.Providers[1]
+	require.Equal(t, failing, stats.ProviderId)
+	require.Equal(t, 0.0, *stats.SuccessRate)
+	require.Nil(t, stats.FirstByteTimePercentilesMs)
+	require.Equal(t, uint(0), stats.Concurrency)
+	require.Equal(t, uint(1), stats.ConsecutiveFailures)
+	require.Equal(t, clk.Now().Add(time.Minute), *stats.SuspendedUntil)
+	require.False(t, stats.Blocked)
+
+	require.Equal(t, ProviderStats{ProviderId: "C", Blocked: true}, snapshot.Providers[2])
+	require.Equal(t, ProviderStats{ProviderId: "D", Blocked: true}, snapshot.Providers[3])
+
+	// the breaker's cooldown passes
+	clk.Add(time.Minute)
+	require.Nil(t, session.Snapshot().Providers[1].SuspendedUntil)
+	require.NoError(t, session.EndRetrieval(retrievalId))
+}
+
+func TestPercentiles(t *testing.T) {
+	require.Nil(t, percentiles(nil))
+	require.Equal(t, &Percentiles{P50: 7, P90: 7, P99: 7}, percentiles([]uint64{7}))
+	samples := make([]uint64, 0, 100)
+	for i := 100; i > 0; i-- {
+		samples = append(samples, uint64(i))
+	}
+	require.Equal(t, &Percentiles{P50: 50, P90: 90, P99: 99}, percentiles(samples))
+	require.Equal(t, uint64(100), samples[0], "samples aren't sorted in place")
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"container/list"
 	"fmt"
 	"math"
 	"sort"
@@ -8,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -46,6 +48,16 @@ type State interface {
 	// recorded with a decay according to SessionStateConfig#ConnectTimeAlpha.
 	RecordConnectTime(storageProviderId peer.ID, connectTime time.Duration)
 
+	// HasConnectTime returns true if a connect time has been recorded for a
+	// storage provider, otherwise its connect time is assumed to be the
+	// overall average when prioritising it.
+	HasConnectTime(storageProviderId peer.ID) bool
+
+	// RecordRegion records the region that a storage provider is located in.
+	// Storage providers in the same region as the session, see
+	// Config#Region, are preferred by ChooseNextProvider.
+	RecordRegion(storageProviderId peer.ID, region string)
+
 	// RecordFirstByteTime records the time it took to receive the first byte
 	// from a storage provider. This is used for prioritisation of storage
 	// providers and is recorded with a decay according to
@@ -54,21 +66,54 @@ type State interface {
 
 	// RecordFailure records a failure for a storage provider. This is used for
 	// prioritisation of storage providers and is recorded as a success=0 with a
-	// decay according to SessionStateConfig#SuccessAlpha.
-	RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) error
+	// decay according to SessionStateConfig#SuccessAlpha. If the failure opens
+	// the storage provider's circuit breaker, see Config#CircuitBreaker, the
+	// cooldown until it is tried again is returned, otherwise zero.
+	RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) (time.Duration, error)
 
 	// RecordSuccess records a success for a storage provider. This is used for
 	// prioritisation of storage providers and is recorded as a success=1 with a
 	// decay according to SessionStateConfig#SuccessAlpha. Bandwidth is also
 	// used for prioritisation and is recorded with a decay according to
-	// SessionStateConfig#BandwidthAlpha.
-	RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64)
+	// SessionStateConfig#BandwidthAlpha. Returns true if the success closes
+	// the storage provider's circuit breaker after it had opened.
+	RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) bool
+
+	// IsSuspended returns true if the circuit breaker of a storage provider,
+	// see Config#CircuitBreaker, is open and it shouldn't be retrieved from.
+	IsSuspended(storageProviderId peer.ID) bool
 
 	// ChooseNextProvider compares a list of storage providers and returns the
 	// index of the next storage provider to use. This uses both historically
 	// collected state and information contained within the metadata, depending
 	// on protocol.
 	ChooseNextProvider(peers []peer.ID, metadata []metadata.Protocol) int
+
+	// ChooseNextProviderWithStrategy operates as ChooseNextProvider but orders
+	// the storage providers according to the given Strategy.
+	ChooseNextProviderWithStrategy(peers []peer.ID, metadata []metadata.Protocol, strategy Strategy) int
+
+	// RecordContentSize records the size of the content retrieved for a CID
+	// and selector, so that it can be used to choose a Strategy for future
+	// retrievals of the same content. Sizes are remembered for
+	// Config#ContentSizeTTL, up to Config#MaxContentSizes of them.
+	RecordContentSize(cid cid.Cid, selector datamodel.Node, size uint64)
+
+	// ChooseStrategy returns the Strategy to use for a retrieval of the given
+	// CID and selector. The expectedSize is used to determine the size class
+	// of the content if non-zero, otherwise a size previously recorded with
+	// RecordContentSize is used.
+	ChooseStrategy(cid cid.Cid, selector datamodel.Node, expectedSize uint64) Strategy
+
+	// RecordAffinity records that a storage provider served a retrieval with
+	// the given affinity key, see RetrievalRequest#AffinityKey, returning true
+	// if it had already served an earlier retrieval with the same key.
+	RecordAffinity(key string, storageProviderId peer.ID) bool
+
+	// ChooseAffinityProvider returns the index of the storage provider in the
+	// list that most recently served a retrieval with the given affinity key,
+	// or false if none of them have.
+	ChooseAffinityProvider(key string, peers []peer.ID) (int, bool)
 }
 
 type activeRetrieval struct {
@@ -95,11 +140,22 @@ type storageProvider struct {
 	firstByteTimeMs metric[uint64]
 	bandwidthBps    metric[uint64]
 	success         metric[float64]
+	region          string
+	// the most recent times to first byte, oldest first, up to
+	// firstByteTimeSamples, for the percentiles of a Snapshot
+	firstByteTimesMs []uint64
+	// circuit breaker, the number of failures since the last success, the
+	// number of times the breaker has opened since then and until when it is
+	// open
+	consecutiveFailures uint
+	opened              uint
+	suspendedUntil      time.Time
 }
 
 type SessionState struct {
 	lk     sync.RWMutex
 	config *Config
+	clock  clock.Clock
 	// active retrievals
 	arm map[types.RetrievalID]activeRetrieval
 	// failures and concurrency of storage providers
@@ -107,6 +163,13 @@ type SessionState struct {
 	overallConnectTimeMs   metric[uint64]
 	overallFirstByteTimeMs metric[uint64]
 	overallBandwidthBps    metric[uint64]
+	// sizes of previously retrieved content, by CID and selector, bounded by
+	// Config#MaxContentSizes with the most recently used at the front
+	contentSizes    map[string]*list.Element
+	contentSizesLru *list.List
+	// providers that served retrievals with each affinity key, most recent
+	// first
+	affinities map[string][]peer.ID
 }
 
 // NewSessionState creates a new SessionState with the given config. If the config is
@@ -115,10 +178,18 @@ func NewSessionState(config *Config) *SessionState {
 	if config == nil {
 		panic("config is required")
 	}
+	clk := config.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
 	return &SessionState{
-		config: config,
-		arm:    make(map[types.RetrievalID]activeRetrieval),
-		spm:    make(map[peer.ID]storageProvider),
+		config:          config,
+		clock:           clk,
+		arm:             make(map[types.RetrievalID]activeRetrieval),
+		spm:             make(map[peer.ID]storageProvider),
+		contentSizes:    make(map[string]*list.Element),
+		contentSizesLru: list.New(),
+		affinities:      make(map[string][]peer.ID),
 	}
 }
 
@@ -212,17 +283,37 @@ func (spt *SessionState) removeFromRetrieval(retrievalId types.RetrievalID, stor
 	return nil
 }
 
-func (spt *SessionState) RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) error {
+func (spt *SessionState) RecordFailure(retrievalId types.RetrievalID, storageProviderId peer.ID) (time.Duration, error) {
 	spt.lk.Lock()
 	defer spt.lk.Unlock()
 
 	// remove from this retrieval to free up the SP to be tried again for a future retrieval
 	if err := spt.removeFromRetrieval(retrievalId, storageProviderId); err != nil {
-		return err
+		return 0, err
 	}
 
 	spt.recordSuccessMetric(storageProviderId, 0)
-	return nil
+
+	status := spt.spm[storageProviderId]
+	status.consecutiveFailures++
+	var cooldown time.Duration
+	breaker := spt.config.CircuitBreaker
+	now := spt.clock.Now()
+	// failures of attempts started before the breaker opened don't open it
+	// again while it is open
+	if breaker.Threshold > 0 && status.consecutiveFailures >= breaker.Threshold && !now.Before(status.suspendedUntil) {
+		status.opened++
+		cooldown = breaker.cooldown(status.opened)
+		status.suspendedUntil = now.Add(cooldown)
+	}
+	spt.spm[storageProviderId] = status
+	return cooldown, nil
+}
+
+func (spt *SessionState) IsSuspended(storageProviderId peer.ID) bool {
+	spt.lk.RLock()
+	defer spt.lk.RUnlock()
+	return spt.clock.Now().Before(spt.spm[storageProviderId].suspendedUntil)
 }
 
 func (spt *SessionState) recordSuccessMetric(storageProviderId peer.ID, current float64) {
@@ -236,13 +327,17 @@ func (spt *SessionState) recordSuccessMetric(storageProviderId peer.ID, current
 	}
 	spt.spm[storageProviderId] = status
 }
-func (spt *SessionState) RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) {
+func (spt *SessionState) RecordSuccess(storageProviderId peer.ID, bandwidthBytesPerSecond uint64) bool {
 	spt.lk.Lock()
 	defer spt.lk.Unlock()
 
 	spt.recordSuccessMetric(storageProviderId, 1)
 
 	status := spt.spm[storageProviderId]
+	closed := status.opened > 0
+	status.consecutiveFailures = 0
+	status.opened = 0
+	status.suspendedUntil = time.Time{}
 	// EMA of bandwidth
 	if !status.bandwidthBps.initialized {
 		status.bandwidthBps.initialized = true
@@ -258,6 +353,7 @@ func (spt *SessionState) RecordSuccess(storageProviderId peer.ID, bandwidthBytes
 	} else {
 		spt.overallBandwidthBps.value = uint64((1-spt.config.OverallBandwidthAlpha)*float64(bandwidthBytesPerSecond) + spt.config.OverallBandwidthAlpha*float64(spt.overallBandwidthBps.value))
 	}
+	return closed
 }
 
 func (spt *SessionState) RecordConnectTime(storageProviderId peer.ID, current time.Duration) {
@@ -284,6 +380,20 @@ func (spt *SessionState) RecordConnectTime(storageProviderId peer.ID, current ti
 	}
 }
 
+func (spt *SessionState) HasConnectTime(storageProviderId peer.ID) bool {
+	spt.lk.RLock()
+	defer spt.lk.RUnlock()
+	return spt.spm[storageProviderId].connectTimeMs.initialized
+}
+
+func (spt *SessionState) RecordRegion(storageProviderId peer.ID, region string) {
+	spt.lk.Lock()
+	defer spt.lk.Unlock()
+	status := spt.spm[storageProviderId]
+	status.region = region
+	spt.spm[storageProviderId] = status
+}
+
 func (spt *SessionState) RecordFirstByteTime(storageProviderId peer.ID, current time.Duration) {
 	spt.lk.Lock()
 	defer spt.lk.Unlock()
@@ -298,6 +408,10 @@ func (spt *SessionState) RecordFirstByteTime(storageProviderId peer.ID, current
 	} else {
 		status.firstByteTimeMs.value = uint64((1-spt.config.FirstByteTimeAlpha)*float64(currentMs) + spt.config.FirstByteTimeAlpha*float64(status.firstByteTimeMs.value))
 	}
+	status.firstByteTimesMs = append(status.firstByteTimesMs, currentMs)
+	if len(status.firstByteTimesMs) > firstByteTimeSamples {
+		status.firstByteTimesMs = status.firstByteTimesMs[1:]
+	}
 	spt.spm[storageProviderId] = status
 
 	if !spt.overallFirstByteTimeMs.initialized {
@@ -308,10 +422,117 @@ func (spt *SessionState) RecordFirstByteTime(storageProviderId peer.ID, current
 	}
 }
 
+func contentKey(cid cid.Cid, selector datamodel.Node) string {
+	jsonSelector, _ := ipld.Encode(selector, dagjson.Encode)
+	return cid.String() + "/" + string(jsonSelector)
+}
+
+type contentSize struct {
+	key        string
+	size       uint64
+	recordedAt time.Time
+}
+
+func (spt *SessionState) RecordContentSize(cid cid.Cid, selector datamodel.Node, size uint64) {
+	if spt.config.MaxContentSizes <= 0 {
+		return
+	}
+	key := contentKey(cid, selector)
+	spt.lk.Lock()
+	defer spt.lk.Unlock()
+	entry := contentSize{key: key, size: size, recordedAt: spt.clock.Now()}
+	if elem, ok := spt.contentSizes[key]; ok {
+		elem.Value = entry
+		spt.contentSizesLru.MoveToFront(elem)
+		return
+	}
+	spt.contentSizes[key] = spt.contentSizesLru.PushFront(entry)
+	for spt.contentSizesLru.Len() > spt.config.MaxContentSizes {
+		spt.removeContentSize(spt.contentSizesLru.Back())
+	}
+}
+
+func (spt *SessionState) ChooseStrategy(cid cid.Cid, selector datamodel.Node, expectedSize uint64) Strategy {
+	if expectedSize == 0 {
+		expectedSize = spt.recordedContentSize(contentKey(cid, selector))
+	}
+	return spt.config.strategyForSize(expectedSize)
+}
+
+// recordedContentSize returns the size recorded for the content, or 0 if none
+// was recorded or it has expired.
+func (spt *SessionState) recordedContentSize(key string) uint64 {
+	spt.lk.Lock()
+	defer spt.lk.Unlock()
+	elem, ok := spt.contentSizes[key]
+	if !ok {
+		return 0
+	}
+	entry := elem.Value.(contentSize)
+	if spt.config.ContentSizeTTL > 0 && spt.clock.Since(entry.recordedAt) >= spt.config.ContentSizeTTL {
+		spt.removeContentSize(elem)
+		return 0
+	}
+	spt.contentSizesLru.MoveToFront(elem)
+	return entry.size
+}
+
+func (spt *SessionState) removeContentSize(elem *list.Element) {
+	entry := spt.contentSizesLru.Remove(elem).(contentSize)
+	delete(spt.contentSizes, entry.key)
+}
+
+// maxAffinityProviders is the number of providers remembered for each
+// affinity key, older ones are forgotten.
+const maxAffinityProviders = 4
+
+func (spt *SessionState) RecordAffinity(key string, storageProviderId peer.ID) bool {
+	spt.lk.Lock()
+	defer spt.lk.Unlock()
+	providers := spt.affinities[key]
+	known := false
+	for ii, p := range providers {
+		if p == storageProviderId {
+			providers = append(providers[:ii], providers[ii+1:]...)
+			known = true
+			break
+		}
+	}
+	providers = append([]peer.ID{storageProviderId}, providers...)
+	if len(providers) > maxAffinityProviders {
+		providers = providers[:maxAffinityProviders]
+	}
+	spt.affinities[key] = providers
+	return known
+}
+
+func (spt *SessionState) ChooseAffinityProvider(key string, peers []peer.ID) (int, bool) {
+	spt.lk.RLock()
+	defer spt.lk.RUnlock()
+	for _, p := range spt.affinities[key] {
+		for ii, candidate := range peers {
+			if candidate == p {
+				return ii, true
+			}
+		}
+	}
+	return 0, false
+}
+
 func (spt *SessionState) ChooseNextProvider(peers []peer.ID, mda []metadata.Protocol) int {
+	return spt.ChooseNextProviderWithStrategy(peers, mda, StrategyBalanced)
+}
+
+func (spt *SessionState) ChooseNextProviderWithStrategy(peers []peer.ID, mda []metadata.Protocol, strategy Strategy) int {
 	spt.lk.Lock()
 	defer spt.lk.Unlock()
 
+	if strategy == StrategyLowestFirstByte {
+		if pi, ok := spt.lowestFirstByte(peers); ok {
+			return pi
+		}
+	}
+
 	// score all peers, a float >=0 each, higher is better
 	scores := make([]float64, len(peers))
 	var tot float64
@@ -319,7 +540,7 @@ func (spt *SessionState) ChooseNextProvider(peers []peer.ID, mda []metadata.Prot
 	for ii, p := range peers {
 		ind[ii] = ii
 		gsmd, _ := mda[ii].(*metadata.GraphsyncFilecoinV1)
-		scores[ii] = spt.scoreProvider(p, gsmd)
+		scores[ii] = spt.scoreProvider(p, gsmd, strategy)
 		tot += scores[ii]
 	}
 	// sort so that the non-random selection choose the first (best)
@@ -345,9 +566,29 @@ func (spt *SessionState) ChooseNextProvider(peers []peer.ID, mda []metadata.Prot
 	panic(sb.String())
 }
 
+// lowestFirstByte returns the index of the peer with the lowest time to first
+// byte. Peers without a recorded time to first byte are assumed to have the
+// overall average. If no peer is better than the others, false is returned.
+// This method assumes the caller holds lock.
+func (spt *SessionState) lowestFirstByte(peers []peer.ID) (int, bool) {
+	overall := spt.overallFirstByteTimeMs.getValue(0)
+	best, distinct := 0, false
+	for ii, p := range peers {
+		ttfb := spt.spm[p].firstByteTimeMs.getValue(overall)
+		bestTtfb := spt.spm[peers[best]].firstByteTimeMs.getValue(overall)
+		if ttfb != bestTtfb {
+			distinct = true
+		}
+		if ttfb < bestTtfb {
+			best = ii
+		}
+	}
+	return best, distinct
+}
+
 // scoreProvider returns a score for a given provider, higher is better.
 // This method assumes the caller holds lock.
-func (spt *SessionState) scoreProvider(id peer.ID, md *metadata.GraphsyncFilecoinV1) float64 {
+func (spt *SessionState) scoreProvider(id peer.ID, md *metadata.GraphsyncFilecoinV1, strategy Strategy) float64 {
 	var score float64
 	// var v, f bool
 
@@ -368,11 +609,19 @@ func (spt *SessionState) scoreProvider(id peer.ID, md *metadata.GraphsyncFilecoi
 
 	score += expDecay(spt.overallConnectTimeMs, sp.connectTimeMs, spt.config.ConnectTimeWeight)
 	score += expDecay(spt.overallFirstByteTimeMs, sp.firstByteTimeMs, spt.config.FirstByteTimeWeight)
-	score += expDecay(spt.overallBandwidthBps, sp.bandwidthBps, spt.config.BandwidthWeight)
+	if strategy == StrategyHighestBandwidth {
+		score += expGrowth(spt.overallBandwidthBps, sp.bandwidthBps, spt.config.LargeContentBandwidthWeight)
+	} else {
+		score += expDecay(spt.overallBandwidthBps, sp.bandwidthBps, spt.config.BandwidthWeight)
+	}
 
 	// if we have no success data, treat it as fully successful
 	score += spt.config.SuccessWeight * sp.success.getValue(1)
 
+	if spt.config.Region != "" && strings.EqualFold(sp.region, spt.config.Region) {
+		score += spt.config.RegionWeight
+	}
+
 	return score
 }
 
@@ -398,3 +647,15 @@ func expDecay(overall metric[uint64], current metric[uint64], weight float64) fl
 	λ := 1 / float64(o)
 	return weight * math.Exp(-λ*float64(current.getValue(o)))
 }
+
+// expGrowth is the complement of expDecay: `f(x) = 1 - exp(-λx)`, giving a
+// stronger signal for providers with higher values of `x`, approaching the
+// full weight as `x` grows well beyond the overall value.
+func expGrowth(overall metric[uint64], current metric[uint64], weight float64) float64 {
+	o := overall.getValue(1)
+	if o == 0 { // avoid divide by zero
+		o = 1
+	}
+	λ := 1 / float64(o)
+	return weight * (1 - math.Exp(-λ*float64(current.getValue(o))))
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/filecoin-project/lassie/pkg/types"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
@@ -81,11 +82,13 @@ func TestSPConcurrency(t *testing.T) {
 	require.Equal(t, uint(2), state.GetConcurrency(p2))
 	require.Equal(t, uint(2), state.GetConcurrency(p3))
 
-	require.NoError(t, state.RecordFailure(ret1, p1))
+	_, err := state.RecordFailure(ret1, p1)
+	require.NoError(t, err)
 	require.Equal(t, uint(1), state.GetConcurrency(p1))
 	require.Equal(t, uint(2), state.GetConcurrency(p2))
 	require.Equal(t, uint(2), state.GetConcurrency(p3))
-	require.ErrorContains(t, state.RecordFailure(ret1, p1), "no such storage provider")
+	_, err = state.RecordFailure(ret1, p1)
+	require.ErrorContains(t, err, "no such storage provider")
 
 	assert.NoError(t, state.EndRetrieval(ret2))
 	require.Equal(t, uint(0), state.GetConcurrency(p1))
@@ -142,6 +145,7 @@ const (
 	successAction
 	failureAction
 	ttfbAction
+	regionAction
 )
 
 type action struct {
@@ -149,6 +153,7 @@ type action struct {
 	typ actionType
 	d   time.Duration
 	v   uint64
+	r   string
 }
 
 func (a action) execute(t *testing.T, s *SessionState) {
@@ -159,9 +164,12 @@ func (a action) execute(t *testing.T, s *SessionState) {
 		s.RecordSuccess(a.p, a.v)
 	case failureAction:
 		require.NoError(t, s.AddToRetrieval(retrievalId, []peer.ID{a.p}))
-		require.NoError(t, s.RecordFailure(retrievalId, a.p))
+		_, err := s.RecordFailure(retrievalId, a.p)
+		require.NoError(t, err)
 	case ttfbAction:
 		s.RecordFirstByteTime(a.p, a.d)
+	case regionAction:
+		s.RecordRegion(a.p, a.r)
 	default:
 		panic("unrecognized action type")
 	}
@@ -196,11 +204,17 @@ func TestCandidateComparison(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		peers[i] = peer.ID(fmt.Sprintf("peer%d", i))
 	}
+	zeroGraphsyncWeights := DefaultScoringWeights()
+	zeroGraphsyncWeights.GraphsyncVerifiedDeal = 0
+	zeroGraphsyncWeights.GraphsyncFastRetrieval = 0
 
 	testCases := []struct {
 		name          string
 		actions       []action
 		metadata      map[peer.ID]metadata.Protocol
+		strategy      Strategy
+		weights       *ScoringWeights
+		region        string
 		expectedOrder []peer.ID
 	}{
 		{
@@ -250,6 +264,64 @@ func TestCandidateComparison(t *testing.T) {
 			},
 			expectedOrder: []peer.ID{peers[0], peers[1], peers[2], peers[3], peers[4]},
 		},
+		{
+			name: "graphsync metadata outweighs connect time",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 2 * time.Second},
+			},
+			metadata: map[peer.ID]metadata.Protocol{
+				peers[0]: &metadata.GraphsyncFilecoinV1{},
+				peers[1]: &metadata.GraphsyncFilecoinV1{VerifiedDeal: true},
+			},
+			expectedOrder: []peer.ID{peers[1], peers[0]},
+		},
+		{
+			name: "graphsync metadata ignored with zero weights",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 2 * time.Second},
+			},
+			metadata: map[peer.ID]metadata.Protocol{
+				peers[0]: &metadata.GraphsyncFilecoinV1{},
+				peers[1]: &metadata.GraphsyncFilecoinV1{VerifiedDeal: true},
+			},
+			weights:       &zeroGraphsyncWeights,
+			expectedOrder: []peer.ID{peers[0], peers[1]},
+		},
+		{
+			name: "same region preferred when otherwise comparable",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 1100 * time.Millisecond},
+				{p: peers[2], typ: connectAction, d: 1200 * time.Millisecond},
+				{p: peers[0], typ: regionAction, r: "eu-west"},
+				{p: peers[1], typ: regionAction, r: "US-East"},
+			},
+			region:        "us-east",
+			expectedOrder: []peer.ID{peers[1], peers[0], peers[2]},
+		},
+		{
+			name: "same region doesn't outweigh much faster providers",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 10 * time.Second},
+				{p: peers[0], typ: successAction, v: 1000},
+				{p: peers[1], typ: failureAction},
+				{p: peers[1], typ: regionAction, r: "us-east"},
+			},
+			region:        "us-east",
+			expectedOrder: []peer.ID{peers[0], peers[1]},
+		},
+		{
+			name: "region ignored without a session region",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 1100 * time.Millisecond},
+				{p: peers[1], typ: regionAction, r: "us-east"},
+			},
+			expectedOrder: []peer.ID{peers[0], peers[1]},
+		},
 		{
 			name: "multiple connect, averages don't cross",
 			actions: []action{
@@ -542,11 +614,49 @@ func TestCandidateComparison(t *testing.T) {
 			},
 			expectedOrder: []peer.ID{peers[0], peers[2], peers[1]},
 		},
+		{
+			name: "small content, lowest first byte wins over connect time",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 2 * time.Second},
+				{p: peers[2], typ: connectAction, d: 3 * time.Second},
+				{p: peers[0], typ: ttfbAction, d: 3 * time.Second},
+				{p: peers[1], typ: ttfbAction, d: 2 * time.Second},
+				{p: peers[2], typ: ttfbAction, d: time.Second},
+			},
+			strategy:      StrategyLowestFirstByte,
+			expectedOrder: []peer.ID{peers[2], peers[1], peers[0]},
+		},
+		{
+			name: "small content, no first byte data falls back to scoring",
+			actions: []action{
+				{p: peers[0], typ: connectAction, d: time.Second},
+				{p: peers[1], typ: connectAction, d: 2 * time.Second},
+			},
+			strategy:      StrategyLowestFirstByte,
+			expectedOrder: []peer.ID{peers[0], peers[1]},
+		},
+		{
+			name: "large content, highest bandwidth first",
+			actions: []action{
+				{p: peers[0], typ: successAction, v: 1000},
+				{p: peers[0], typ: successAction, v: 1100},
+				{p: peers[1], typ: successAction, v: 1200},
+				{p: peers[1], typ: successAction, v: 1200},
+				{p: peers[2], typ: successAction, v: 5000},
+			},
+			strategy:      StrategyHighestBandwidth,
+			expectedOrder: []peer.ID{peers[2], peers[1], peers[0]},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			cfg := DefaultConfig().WithoutRandomness()
+			if tc.weights != nil {
+				cfg = cfg.WithScoringWeights(*tc.weights)
+			}
+			cfg = cfg.WithRegion(tc.region)
 			state := NewSessionState(cfg)
 			// setup a retrieval so we don't error on "unknown retrieval"
 			require.True(t, state.RegisterRetrieval(retrievalId, cid.Undef, basicnode.NewString("boop")))
@@ -576,7 +686,7 @@ func TestCandidateComparison(t *testing.T) {
 			// choose next provider until we've exhausted the list
 			gotOrder := make([]peer.ID, 0, len(tc.expectedOrder))
 			for len(gotOrder) < len(tc.expectedOrder) {
-				next := state.ChooseNextProvider(tp, mda)
+				next := state.ChooseNextProviderWithStrategy(tp, mda, tc.strategy)
 				gotOrder = append(gotOrder, tp[next])
 				tp = append(tp[:next], tp[next+1:]...)
 			}
@@ -585,3 +695,140 @@ func TestCandidateComparison(t *testing.T) {
 		})
 	}
 }
+
+func TestChooseStrategy(t *testing.T) {
+	root := cid.MustParse("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+	sel := basicnode.NewString("boop")
+	cfg := DefaultConfig().WithSmallContentThreshold(100).WithLargeContentThreshold(1000)
+	state := NewSessionState(cfg)
+
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, sel, 0))
+	require.Equal(t, StrategyLowestFirstByte, state.ChooseStrategy(root, sel, 100))
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, sel, 500))
+	require.Equal(t, StrategyHighestBandwidth, state.ChooseStrategy(root, sel, 1000))
+
+	// a recorded size is used when no size is expected
+	state.RecordContentSize(root, sel, 5000)
+	require.Equal(t, StrategyHighestBandwidth, state.ChooseStrategy(root, sel, 0))
+	require.Equal(t, StrategyLowestFirstByte, state.ChooseStrategy(root, sel, 10))
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, basicnode.NewString("other"), 0))
+
+	// recorded sizes expire, and the least recently used are forgotten
+	clk := clock.NewMock()
+	state = NewSessionState(cfg.WithMaxContentSizes(2).WithContentSizeTTL(time.Hour).WithClock(clk))
+	other, third := basicnode.NewString("other"), basicnode.NewString("third")
+	state.RecordContentSize(root, sel, 5000)
+	clk.Add(59 * time.Minute)
+	require.Equal(t, StrategyHighestBandwidth, state.ChooseStrategy(root, sel, 0))
+	clk.Add(time.Minute)
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, sel, 0))
+	state.RecordContentSize(root, sel, 5000)
+	state.RecordContentSize(root, other, 10)
+	require.Equal(t, StrategyHighestBandwidth, state.ChooseStrategy(root, sel, 0))
+	state.RecordContentSize(root, third, 10)
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, other, 0))
+	require.Equal(t, StrategyHighestBandwidth, state.ChooseStrategy(root, sel, 0))
+	require.Equal(t, StrategyLowestFirstByte, state.ChooseStrategy(root, third, 0))
+	require.Len(t, state.contentSizes, 2)
+
+	state = NewSessionState(cfg.WithMaxContentSizes(0))
+	state.RecordContentSize(root, sel, 5000)
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, sel, 0))
+	require.Empty(t, state.contentSizes)
+
+	// disabled thresholds
+	state = NewSessionState(cfg.WithSmallContentThreshold(0).WithLargeContentThreshold(0))
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, sel, 1))
+	require.Equal(t, StrategyBalanced, state.ChooseStrategy(root, sel, 1<<40))
+}
+
+func TestAffinity(t *testing.T) {
+	state := NewSessionState(DefaultConfig())
+	peers := []peer.ID{"A", "B", "C", "D", "E"}
+
+	_, ok := state.ChooseAffinityProvider("dataset", peers)
+	require.False(t, ok)
+
+	require.False(t, state.RecordAffinity("dataset", "B"))
+	require.True(t, state.RecordAffinity("dataset", "B"))
+	chosen, ok := state.ChooseAffinityProvider("dataset", peers)
+	require.True(t, ok)
+	require.Equal(t, 1, chosen)
+	_, ok = state.ChooseAffinityProvider("other", peers)
+	require.False(t, ok)
+
+	// the most recent provider is preferred, among those that are candidates
+	require.False(t, state.RecordAffinity("dataset", "D"))
+	chosen, ok = state.ChooseAffinityProvider("dataset", peers)
+	require.True(t, ok)
+	require.Equal(t, 3, chosen)
+	chosen, ok = state.ChooseAffinityProvider("dataset", []peer.ID{"A", "B", "C"})
+	require.True(t, ok)
+	require.Equal(t, 1, chosen)
+
+	// only the most recent providers are remembered
+	for _, p := range []peer.ID{"A", "C", "E", "F"} {
+		require.False(t, state.RecordAffinity("dataset", p))
+	}
+	_, ok = state.ChooseAffinityProvider("dataset", []peer.ID{"B", "D"})
+	require.False(t, ok)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clk := clock.NewMock()
+	cfg := DefaultConfig().
+		WithClock(clk).
+		WithCircuitBreaker(CircuitBreaker{Threshold: 3, Cooldown: 10 * time.Second, MaxCooldown: 25 * time.Second})
+	session := NewSession(cfg, true)
+	p := peer.ID("A")
+	candidate := types.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: p}, Metadata: metadata.Default.New(&metadata.Bitswap{})}
+	fail := func() time.Duration {
+		require.NoError(t, session.AddToRetrieval(retrievalId, []peer.ID{p}))
+		cooldown, err := session.RecordFailure(retrievalId, p)
+		require.NoError(t, err)
+		return cooldown
+	}
+	require.True(t, session.RegisterRetrieval(retrievalId, cid.MustParse("bafkqaalb"), selectorparse.CommonSelector_ExploreAllRecursively))
+	defer func() { require.NoError(t, session.EndRetrieval(retrievalId)) }()
+
+	// opens after the threshold of consecutive failures
+	require.Zero(t, fail())
+	require.Zero(t, fail())
+	require.False(t, session.IsSuspended(p))
+	require.Equal(t, 10*time.Second, fail())
+	require.True(t, session.IsSuspended(p))
+	keep, _ := session.FilterIndexerCandidate(candidate)
+	require.False(t, keep)
+
+	// failures of attempts already started don't open it again
+	require.Zero(t, fail())
+	clk.Add(10 * time.Second)
+	require.False(t, session.IsSuspended(p))
+	keep, _ = session.FilterIndexerCandidate(candidate)
+	require.True(t, keep)
+
+	// after the cooldown a single failure opens it again, for longer each time
+	require.Equal(t, 20*time.Second, fail())
+	clk.Add(20 * time.Second)
+	require.Equal(t, 25*time.Second, fail())
+	clk.Add(25 * time.Second)
+
+	// a success closes it and resets the count of failures
+	require.True(t, session.RecordSuccess(p, 100))
+	require.False(t, session.IsSuspended(p))
+	require.False(t, session.RecordSuccess(p, 100))
+	require.Zero(t, fail())
+	require.Zero(t, fail())
+	require.Equal(t, 10*time.Second, fail())
+
+	// disabled
+	state := NewSessionState(DefaultConfig().WithCircuitBreaker(CircuitBreaker{}))
+	require.True(t, state.RegisterRetrieval(retrievalId, cid.MustParse("bafkqaalc"), selectorparse.CommonSelector_ExploreAllRecursively))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, state.AddToRetrieval(retrievalId, []peer.ID{p}))
+		cooldown, err := state.RecordFailure(retrievalId, p)
+		require.NoError(t, err)
+		require.Zero(t, cooldown)
+	}
+	require.False(t, state.IsSuspended(p))
+}
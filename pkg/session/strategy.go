@@ -0,0 +1,52 @@
+package session
+
+// Strategy describes how candidates are ordered for a retrieval, it is chosen
+// according to the size class of the content being retrieved. The size is the
+// request's ExpectedSize, or that of a recent retrieval of the same content;
+// content of unknown size is retrieved with StrategyBalanced. A Strategy only
+// orders candidates, each retrieval is still made from one provider at a time.
+type Strategy int
+
+const (
+	// StrategyBalanced uses the standard weighted scoring of all collected
+	// metrics, with a random weighted choice between candidates. It is used
+	// when the content size is unknown or between the small and large
+	// thresholds.
+	StrategyBalanced Strategy = iota
+	// StrategyLowestFirstByte is used for small content, where the transfer
+	// time is dominated by latency. The candidate with the lowest time to first
+	// byte is always chosen.
+	StrategyLowestFirstByte
+	// StrategyHighestBandwidth is used for large content, where the transfer
+	// time is dominated by throughput. Bandwidth is given a greater weight in
+	// the scoring of candidates. Graphsync and HTTP retrievals aren't striped
+	// across providers; Bitswap already spreads block requests across its
+	// peers.
+	StrategyHighestBandwidth
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyLowestFirstByte:
+		return "lowest-first-byte"
+	case StrategyHighestBandwidth:
+		return "highest-bandwidth"
+	default:
+		return "balanced"
+	}
+}
+
+// strategyForSize returns the Strategy for content of the given size, a size
+// of zero is treated as unknown.
+func (cfg *Config) strategyForSize(size uint64) Strategy {
+	switch {
+	case size == 0:
+		return StrategyBalanced
+	case cfg.SmallContentThreshold > 0 && size <= cfg.SmallContentThreshold:
+		return StrategyLowestFirstByte
+	case cfg.LargeContentThreshold > 0 && size >= cfg.LargeContentThreshold:
+		return StrategyHighestBandwidth
+	default:
+		return StrategyBalanced
+	}
+}
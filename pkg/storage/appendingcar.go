@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	ipldstorage "github.com/ipld/go-ipld-prime/storage"
+)
+
+var _ DeferredWriter = (*AppendingCar)(nil)
+
+// AppendingCar is a DeferredWriter that continues writing a CARv1 that was
+// partially written, such as by a fetch that was interrupted. Blocks already in
+// the CAR are not written again, new blocks are appended after them, so that a
+// retrieval of the same DAG completes the CAR in the order it would have been
+// written in had it not been interrupted.
+type AppendingCar struct {
+	lk       sync.Mutex
+	f        *os.File
+	closed   bool
+	existing map[string]struct{}
+	putCb    []putCb
+	blocks   int
+	bytes    uint64
+}
+
+type putCb struct {
+	cb   func(int)
+	once bool
+}
+
+// OpenAppendingCar opens the CARv1 at path, which must have root as its only
+// root, to append blocks to. Each complete block in the CAR is passed to
+// existing, which may be used to seed a store with the blocks so they aren't
+// retrieved again. Anything following the last block that can be read in full
+// and matches its CID, such as a block cut off when the CAR was interrupted,
+// is truncated.
+func OpenAppendingCar(ctx context.Context, path string, root cid.Cid, existing func(key string, data []byte) error) (*AppendingCar, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	ac, err := openAppendingCar(ctx, f, root, existing)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't resume %s: %w", path, err)
+	}
+	return ac, nil
+}
+
+func openAppendingCar(ctx context.Context, f *os.File, root cid.Cid, existing func(key string, data []byte) error) (*AppendingCar, error) {
+	cr := &countingReader{r: bufio.NewReader(f)}
+	br, err := carv2.NewBlockReader(cr)
+	if err != nil {
+		return nil, err
+	}
+	if br.Version != 1 {
+		return nil, fmt.Errorf("only a CARv1 can be resumed, found a CARv%d", br.Version)
+	}
+	if len(br.Roots) != 1 || !br.Roots[0].Equals(root) {
+		return nil, fmt.Errorf("the CAR has roots %v, expected %s", br.Roots, root)
+	}
+
+	ac := &AppendingCar{f: f, existing: make(map[string]struct{})}
+	end := cr.n
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := br.Next()
+		if err != nil {
+			// io.EOF at the end of the last block, anything else is a block
+			// that was cut off or is corrupt and will be retrieved again
+			break
+		}
+		key := string(blk.Cid().Bytes())
+		if _, ok := ac.existing[key]; !ok {
+			ac.existing[key] = struct{}{}
+			if err := existing(key, blk.RawData()); err != nil {
+				return nil, err
+			}
+		}
+		ac.blocks++
+		ac.bytes += uint64(len(blk.RawData()))
+		end = cr.n
+	}
+
+	if err := f.Truncate(end); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// Existing returns the number of blocks, and the bytes of their data, that were
+// already in the CAR when it was opened.
+func (ac *AppendingCar) Existing() (blocks int, bytes uint64) {
+	return ac.blocks, ac.bytes
+}
+
+// OnPut will call a callback when each block is appended. The argument to the
+// callback is the number of bytes being written. If once is true, the callback
+// will be removed after the first call.
+func (ac *AppendingCar) OnPut(cb func(int), once bool) {
+	ac.putCb = append(ac.putCb, putCb{cb: cb, once: once})
+}
+
+// Has returns true if the key was in the CAR when it was opened or has been
+// appended since.
+func (ac *AppendingCar) Has(ctx context.Context, key string) (bool, error) {
+	ac.lk.Lock()
+	defer ac.lk.Unlock()
+
+	if ac.closed {
+		return false, carstorage.ErrClosed
+	}
+	_, ok := ac.existing[key]
+	return ok, nil
+}
+
+// Put appends a block to the CAR unless it is already there. Identity CIDs
+// aren't written.
+func (ac *AppendingCar) Put(ctx context.Context, key string, content []byte) error {
+	ac.lk.Lock()
+	defer ac.lk.Unlock()
+
+	if ac.closed {
+		return carstorage.ErrClosed
+	}
+	if _, ok := ac.existing[key]; ok {
+		return nil
+	}
+	if _, ok, err := AsIdentity(key); err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+
+	for i := 0; i < len(ac.putCb); i++ {
+		cb := ac.putCb[i]
+		cb.cb(len(content))
+		if cb.once {
+			ac.putCb = append(ac.putCb[:i], ac.putCb[i+1:]...)
+			i--
+		}
+	}
+
+	section := binary.AppendUvarint(nil, uint64(len(key)+len(content)))
+	section = append(section, key...)
+	section = append(section, content...)
+	if _, err := ac.f.Write(section); err != nil {
+		return err
+	}
+	ac.existing[key] = struct{}{}
+	return nil
+}
+
+// BlockWriteOpener returns a BlockWriteOpener that appends to this CAR.
+func (ac *AppendingCar) BlockWriteOpener() linking.BlockWriteOpener {
+	return func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		wr, wrcommit, err := ipldstorage.PutStream(lctx.Ctx, ac)
+		return wr, func(lnk ipld.Link) error {
+			return wrcommit(lnk.Binary())
+		}, err
+	}
+}
+
+// Close closes the underlying file.
+func (ac *AppendingCar) Close() error {
+	ac.lk.Lock()
+	defer ac.lk.Unlock()
+
+	if ac.closed {
+		return carstorage.ErrClosed
+	}
+	ac.closed = true
+	return ac.f.Close()
+}
+
+// countingReader counts the bytes read through it, so the offset of the end
+// of each block read from a CAR is known.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/storage"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendingCar(t *testing.T) {
+	ctx := context.Background()
+	setupStore := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{
+		Bag: make(map[string][]byte),
+	}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.SetReadStorage(setupStore)
+	lsys.SetWriteStorage(setupStore)
+
+	file := unixfs.GenerateFile(t, &lsys, rand.Reader, 1<<20)
+	fileBlocks := testutil.ToBlocks(t, lsys, file.Root, selectorparse.CommonSelector_ExploreAllRecursively)
+	require.Greater(t, len(fileBlocks), 4)
+	half := len(fileBlocks) / 2
+
+	// write a CAR of the first half of the blocks, and part of the block after
+	// them, as an interrupted fetch would
+	carBytes := func(roots []cid.Cid, blks []blocks.Block) []byte {
+		var buf bytes.Buffer
+		w, err := carstorage.NewWritable(&buf, roots, carv2.WriteAsCarV1(true))
+		require.NoError(t, err)
+		for _, blk := range blks {
+			require.NoError(t, w.Put(ctx, blk.Cid().KeyString(), blk.RawData()))
+		}
+		require.NoError(t, w.Finalize())
+		return buf.Bytes()
+	}
+	partial := carBytes([]cid.Cid{file.Root}, fileBlocks[:half+1])
+	partial = partial[:len(partial)-len(fileBlocks[half].RawData())/2]
+	carPath := filepath.Join(t.TempDir(), "partial.car")
+	require.NoError(t, os.WriteFile(carPath, partial, 0644))
+
+	t.Run("wrong root", func(t *testing.T) {
+		_, err := storage.OpenAppendingCar(ctx, carPath, fileBlocks[1].Cid(), func(string, []byte) error { return nil })
+		require.ErrorContains(t, err, "expected "+fileBlocks[1].Cid().String())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := storage.OpenAppendingCar(ctx, filepath.Join(t.TempDir(), "missing.car"), file.Root, func(string, []byte) error { return nil })
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("append", func(t *testing.T) {
+		var seeded []string
+		ac, err := storage.OpenAppendingCar(ctx, carPath, file.Root, func(key string, data []byte) error {
+			seeded = append(seeded, key)
+			return nil
+		})
+		require.NoError(t, err)
+		existingBlocks, _ := ac.Existing()
+		require.Equal(t, half, existingBlocks)
+		require.Len(t, seeded, half)
+		for i, key := range seeded {
+			require.Equal(t, fileBlocks[i].Cid().KeyString(), key)
+		}
+
+		var appended int
+		ac.OnPut(func(int) { appended++ }, false)
+		for i, blk := range fileBlocks {
+			has, err := ac.Has(ctx, blk.Cid().KeyString())
+			require.NoError(t, err)
+			require.Equal(t, i < half, has)
+			require.NoError(t, ac.Put(ctx, blk.Cid().KeyString(), blk.RawData()))
+		}
+		require.Equal(t, len(fileBlocks)-half, appended)
+		require.NoError(t, ac.Close())
+
+		// the resumed CAR is the CAR of the complete DAG
+		resumed, err := os.ReadFile(carPath)
+		require.NoError(t, err)
+		require.Equal(t, carBytes([]cid.Cid{file.Root}, fileBlocks), resumed)
+
+		br, err := carv2.NewBlockReader(bytes.NewReader(resumed))
+		require.NoError(t, err)
+		for _, blk := range fileBlocks {
+			got, err := br.Next()
+			require.NoError(t, err)
+			require.Equal(t, blk.Cid(), got.Cid())
+		}
+		_, err = br.Next()
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
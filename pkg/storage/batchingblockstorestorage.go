@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	DefaultBatchMaxBlocks     = 256
+	DefaultBatchMaxBytes      = 8 << 20
+	DefaultBatchFlushInterval = 100 * time.Millisecond
+)
+
+var ErrBatchClosed = errors.New("batching blockstore storage is closed")
+
+var _ types.ReadableWritableStorage = (*BatchingBlockstoreStorage)(nil)
+
+// BlockstoreBatchConfig controls how BatchingBlockstoreStorage groups the
+// blocks it writes. A batch is committed with a single PutMany once it holds
+// MaxBlocks blocks or MaxBytes bytes, or once FlushInterval has passed since
+// the last commit, whichever comes first. Zero values take the defaults.
+//
+// Larger batches and longer intervals increase throughput with blockstores
+// that commit each write in a transaction, at the cost of more blocks being
+// held in memory, and lost if the process dies, before they are committed.
+// A MaxBlocks of 1 commits each block as it is written.
+type BlockstoreBatchConfig struct {
+	MaxBlocks     int
+	MaxBytes      int
+	FlushInterval time.Duration
+}
+
+func (cfg BlockstoreBatchConfig) withDefaults() BlockstoreBatchConfig {
+	if cfg.MaxBlocks <= 0 {
+		cfg.MaxBlocks = DefaultBatchMaxBlocks
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultBatchMaxBytes
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultBatchFlushInterval
+	}
+	return cfg
+}
+
+// BatchStats describes the commits made by a BatchingBlockstoreStorage.
+type BatchStats struct {
+	// Flushes is the number of batches committed.
+	Flushes uint64
+	// Blocks and Bytes are the totals committed over all batches.
+	Blocks uint64
+	Bytes  uint64
+	// FlushTime is the total time spent committing batches.
+	FlushTime time.Duration
+	// Pending is the number of blocks written but not yet committed.
+	Pending int
+}
+
+// BatchingBlockstoreStorage is a ReadableWritableStorage, like
+// BlockstoreStorage, that groups the blocks written to it into batches
+// committed to the Blockstore with PutMany, rather than putting each block as
+// it arrives. Blocks are readable from the storage as soon as they are
+// written, but only reach the Blockstore when their batch is committed, see
+// BlockstoreBatchConfig. Close must be called to commit the final batch.
+//
+// An error committing a batch is returned by the next Put, Flush or Close.
+type BatchingBlockstoreStorage struct {
+	bs  blockstore.Blockstore
+	cfg BlockstoreBatchConfig
+
+	flushLk sync.Mutex // serializes commits
+
+	lk           sync.Mutex
+	pending      []blocks.Block
+	pendingKeys  map[string][]byte
+	pendingBytes int
+	stats        BatchStats
+	err          error
+	closed       bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBatchingBlockstoreStorage creates a BatchingBlockstoreStorage writing to
+// bs, committing batches in the background every cfg.FlushInterval until it is
+// closed.
+func NewBatchingBlockstoreStorage(bs blockstore.Blockstore, cfg BlockstoreBatchConfig) *BatchingBlockstoreStorage {
+	bbs := &BatchingBlockstoreStorage{
+		bs:          bs,
+		cfg:         cfg.withDefaults(),
+		pendingKeys: make(map[string][]byte),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go bbs.run()
+	return bbs
+}
+
+func (bbs *BatchingBlockstoreStorage) run() {
+	defer close(bbs.done)
+	ticker := time.NewTicker(bbs.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bbs.stop:
+			return
+		case <-ticker.C:
+			// errors are held for the next Put, Flush or Close
+			_ = bbs.Flush(context.Background())
+		}
+	}
+}
+
+func (bbs *BatchingBlockstoreStorage) Has(ctx context.Context, key string) (bool, error) {
+	bbs.lk.Lock()
+	_, ok := bbs.pendingKeys[key]
+	bbs.lk.Unlock()
+	if ok {
+		return true, nil
+	}
+	c, err := cid.Cast([]byte(key))
+	if err != nil {
+		return false, err
+	}
+	return bbs.bs.Has(ctx, c)
+}
+
+func (bbs *BatchingBlockstoreStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	bbs.lk.Lock()
+	data, ok := bbs.pendingKeys[key]
+	bbs.lk.Unlock()
+	if ok {
+		return data, nil
+	}
+	c, err := cid.Cast([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	blk, err := bbs.bs.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return blk.RawData(), nil
+}
+
+func (bbs *BatchingBlockstoreStorage) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, err := bbs.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (bbs *BatchingBlockstoreStorage) Put(ctx context.Context, key string, content []byte) error {
+	c, err := cid.Cast([]byte(key))
+	if err != nil {
+		return err
+	}
+	// the content has already been verified against the CID by the
+	// retrieval, so there's no need to hash it again
+	blk, err := blocks.NewBlockWithCid(content, c)
+	if err != nil {
+		return err
+	}
+
+	bbs.lk.Lock()
+	if bbs.closed {
+		bbs.lk.Unlock()
+		return ErrBatchClosed
+	}
+	if bbs.err != nil {
+		err := bbs.err
+		bbs.lk.Unlock()
+		return err
+	}
+	if _, ok := bbs.pendingKeys[key]; !ok {
+		bbs.pending = append(bbs.pending, blk)
+		bbs.pendingKeys[key] = content
+		bbs.pendingBytes += len(content)
+	}
+	full := len(bbs.pending) >= bbs.cfg.MaxBlocks || bbs.pendingBytes >= bbs.cfg.MaxBytes
+	bbs.lk.Unlock()
+
+	if full {
+		// committing a full batch in the writer's goroutine holds the
+		// retrieval back while the Blockstore catches up
+		return bbs.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush commits the blocks written so far to the Blockstore.
+func (bbs *BatchingBlockstoreStorage) Flush(ctx context.Context) error {
+	bbs.flushLk.Lock()
+	defer bbs.flushLk.Unlock()
+
+	bbs.lk.Lock()
+	if bbs.err != nil || len(bbs.pending) == 0 {
+		err := bbs.err
+		bbs.lk.Unlock()
+		return err
+	}
+	// blocks written while the batch is being committed are appended after
+	// it, and stay readable until they're committed in turn
+	batch := bbs.pending
+	bbs.lk.Unlock()
+
+	start := time.Now()
+	err := bbs.bs.PutMany(ctx, batch)
+	elapsed := time.Since(start)
+
+	bbs.lk.Lock()
+	defer bbs.lk.Unlock()
+	if err != nil {
+		bbs.err = err
+		return err
+	}
+	var batchBytes int
+	for _, blk := range batch {
+		batchBytes += len(blk.RawData())
+		delete(bbs.pendingKeys, blk.Cid().KeyString())
+	}
+	bbs.pending = bbs.pending[len(batch):]
+	bbs.pendingBytes -= batchBytes
+	bbs.stats.Flushes++
+	bbs.stats.Blocks += uint64(len(batch))
+	bbs.stats.Bytes += uint64(batchBytes)
+	bbs.stats.FlushTime += elapsed
+	return nil
+}
+
+// Close stops committing batches in the background and commits the remaining
+// blocks to the Blockstore. Further writes return ErrBatchClosed.
+func (bbs *BatchingBlockstoreStorage) Close() error {
+	bbs.lk.Lock()
+	if bbs.closed {
+		err := bbs.err
+		bbs.lk.Unlock()
+		return err
+	}
+	bbs.closed = true
+	bbs.lk.Unlock()
+	close(bbs.stop)
+	<-bbs.done
+	return bbs.Flush(context.Background())
+}
+
+// Stats returns the commits made so far.
+func (bbs *BatchingBlockstoreStorage) Stats() BatchStats {
+	bbs.lk.Lock()
+	defer bbs.lk.Unlock()
+	stats := bbs.stats
+	stats.Pending = len(bbs.pending)
+	return stats
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchingBlockstoreStorage(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	// an interval long enough that only full batches and Close commit
+	store := NewBatchingBlockstoreStorage(bs, BlockstoreBatchConfig{MaxBlocks: 3, FlushInterval: time.Hour})
+
+	testCid1, testData1 := randBlock()
+	testCid2, testData2 := randBlock()
+	testCid3, testData3 := randBlock()
+	testCid4, testData4 := randBlock()
+
+	require.NoError(t, store.Put(ctx, testCid1.KeyString(), testData1))
+	require.NoError(t, store.Put(ctx, testCid2.KeyString(), testData2))
+	// a duplicate isn't batched again
+	require.NoError(t, store.Put(ctx, testCid2.KeyString(), testData2))
+
+	// readable from the storage before being committed
+	has, err := store.Has(ctx, testCid1.KeyString())
+	require.NoError(t, err)
+	require.True(t, has)
+	got, err := store.Get(ctx, testCid2.KeyString())
+	require.NoError(t, err)
+	require.Equal(t, testData2, got)
+	rdr, err := store.GetStream(ctx, testCid1.KeyString())
+	require.NoError(t, err)
+	got, err = io.ReadAll(rdr)
+	require.NoError(t, err)
+	require.Equal(t, testData1, got)
+	has, err = bs.Has(ctx, testCid1)
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, BatchStats{Pending: 2}, store.Stats())
+
+	// the third block fills the batch
+	require.NoError(t, store.Put(ctx, testCid3.KeyString(), testData3))
+	blk, err := bs.Get(ctx, testCid1)
+	require.NoError(t, err)
+	require.Equal(t, testData1, blk.RawData())
+	blk, err = bs.Get(ctx, testCid3)
+	require.NoError(t, err)
+	require.Equal(t, testData3, blk.RawData())
+	stats := store.Stats()
+	require.Equal(t, uint64(1), stats.Flushes)
+	require.Equal(t, uint64(3), stats.Blocks)
+	require.Equal(t, uint64(3*1024), stats.Bytes)
+	require.Zero(t, stats.Pending)
+
+	// Close commits the remainder
+	require.NoError(t, store.Put(ctx, testCid4.KeyString(), testData4))
+	require.NoError(t, store.Close())
+	has, err = bs.Has(ctx, testCid4)
+	require.NoError(t, err)
+	require.True(t, has)
+	require.Equal(t, uint64(2), store.Stats().Flushes)
+	require.ErrorIs(t, store.Put(ctx, testCid4.KeyString(), testData4), ErrBatchClosed)
+}
+
+func TestBatchingBlockstoreStorageInterval(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	store := NewBatchingBlockstoreStorage(bs, BlockstoreBatchConfig{FlushInterval: 10 * time.Millisecond})
+	defer store.Close()
+
+	testCid, testData := randBlock()
+	require.NoError(t, store.Put(ctx, testCid.KeyString(), testData))
+	require.Eventually(t, func() bool {
+		has, err := bs.Has(ctx, testCid)
+		return err == nil && has
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBatchingBlockstoreStorageError(t *testing.T) {
+	ctx := context.Background()
+	bs := &failingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore())),
+		err:        errors.New("disk full"),
+	}
+	store := NewBatchingBlockstoreStorage(bs, BlockstoreBatchConfig{MaxBlocks: 1, FlushInterval: time.Hour})
+
+	testCid1, testData1 := randBlock()
+	testCid2, testData2 := randBlock()
+	require.ErrorIs(t, store.Put(ctx, testCid1.KeyString(), testData1), bs.err)
+	// the error sticks
+	require.ErrorIs(t, store.Put(ctx, testCid2.KeyString(), testData2), bs.err)
+	require.ErrorIs(t, store.Close(), bs.err)
+}
+
+type failingBlockstore struct {
+	blockstore.Blockstore
+	err error
+}
+
+func (fbs *failingBlockstore) PutMany(context.Context, []blocks.Block) error {
+	return fbs.err
+}
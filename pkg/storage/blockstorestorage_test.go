@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockstoreStorage(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	store := NewBlockstoreStorage(bs)
+
+	testCid1, testData1 := randBlock()
+	testCid2, _ := randBlock()
+
+	require.NoError(t, store.Put(ctx, testCid1.KeyString(), testData1))
+
+	// written through to the blockstore
+	blk, err := bs.Get(ctx, testCid1)
+	require.NoError(t, err)
+	require.Equal(t, testData1, blk.RawData())
+
+	has, err := store.Has(ctx, testCid1.KeyString())
+	require.NoError(t, err)
+	require.True(t, has)
+	got, err := store.Get(ctx, testCid1.KeyString())
+	require.NoError(t, err)
+	require.Equal(t, testData1, got)
+	rdr, err := store.GetStream(ctx, testCid1.KeyString())
+	require.NoError(t, err)
+	got, err = io.ReadAll(rdr)
+	require.NoError(t, err)
+	require.Equal(t, testData1, got)
+
+	has, err = store.Has(ctx, testCid2.KeyString())
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = store.Get(ctx, testCid2.KeyString())
+	nf, ok := err.(interface{ NotFound() bool })
+	require.True(t, ok)
+	require.True(t, nf.NotFound())
+
+	// keys must be CIDs
+	_, err = store.Get(ctx, "not a cid")
+	require.Error(t, err)
+}
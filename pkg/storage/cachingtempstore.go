@@ -20,9 +20,9 @@ var _ types.ReadableWritableStorage = (*preloadStore)(nil)
 var errClosed = errors.New("store closed")
 
 // CachingTempStore is a ReadableWritableStorage that is intended for
-// temporary use. It uses DeferredStorageCar as a backing store, so the
-// underlying CAR file is lazily created on the first write (none will be
-// created if there are no writes).
+// temporary use. It holds blocks in a TempStore, usually a DeferredStorageCar,
+// so the underlying CAR file is lazily created on the first write (none will
+// be created if there are no writes).
 //
 // A provided BlockWriteOpener will receive blocks for each Put operation, this
 // is intended to be used to write a properly ordered CARv1 file.
@@ -34,13 +34,14 @@ var errClosed = errors.New("store closed")
 // store. In this way, the BlockWriteOpener will receive blocks in the order
 // that they appear in the traversal.
 type CachingTempStore struct {
-	store     *DeferredStorageCar
+	lk        sync.Mutex
+	store     types.TempStore
 	outWriter linking.BlockWriteOpener
 
 	preloadKeys map[string]struct{}
 }
 
-func NewCachingTempStore(outWriter linking.BlockWriteOpener, store *DeferredStorageCar) *CachingTempStore {
+func NewCachingTempStore(outWriter linking.BlockWriteOpener, store types.TempStore) *CachingTempStore {
 	return &CachingTempStore{
 		store:       store,
 		outWriter:   outWriter,
@@ -49,24 +50,20 @@ func NewCachingTempStore(outWriter linking.BlockWriteOpener, store *DeferredStor
 }
 
 func (ttrw *CachingTempStore) Has(ctx context.Context, key string) (bool, error) {
-	ttrw.store.lk.Lock()
-	defer ttrw.store.lk.Unlock()
+	ttrw.lk.Lock()
+	defer ttrw.lk.Unlock()
 
 	if _, ok := ttrw.preloadKeys[key]; ok {
 		// if it's in the preload list, then it's not in the store proper
 		return false, nil
 	}
 
-	if rw, err := ttrw.store.readWrite(); err != nil {
-		return false, err
-	} else {
-		return rw.Has(ctx, key)
-	}
+	return ttrw.store.Has(ctx, key)
 }
 
 func (ttrw *CachingTempStore) Get(ctx context.Context, key string) ([]byte, error) {
-	ttrw.store.lk.Lock()
-	defer ttrw.store.lk.Unlock()
+	ttrw.lk.Lock()
+	defer ttrw.lk.Unlock()
 
 	if _, ok := ttrw.preloadKeys[key]; ok {
 		// if it's in the preload list, then it's not in the store proper
@@ -77,16 +74,12 @@ func (ttrw *CachingTempStore) Get(ctx context.Context, key string) ([]byte, erro
 		return nil, carstorage.ErrNotFound{Cid: c}
 	}
 
-	if rw, err := ttrw.store.readWrite(); err != nil {
-		return nil, err
-	} else {
-		return rw.Get(ctx, key)
-	}
+	return ttrw.store.Get(ctx, key)
 }
 
 func (ttrw *CachingTempStore) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
-	ttrw.store.lk.Lock()
-	defer ttrw.store.lk.Unlock()
+	ttrw.lk.Lock()
+	defer ttrw.lk.Unlock()
 
 	if _, ok := ttrw.preloadKeys[key]; ok {
 		// if it's in the preload list, then it's not in the store proper
@@ -97,19 +90,14 @@ func (ttrw *CachingTempStore) GetStream(ctx context.Context, key string) (io.Rea
 		return nil, carstorage.ErrNotFound{Cid: c}
 	}
 
-	if rw, err := ttrw.store.readWrite(); err != nil {
-		return nil, err
-	} else {
-		r, err := rw.GetStream(ctx, key)
-		return r, err
-	}
+	return ttrw.store.GetStream(ctx, key)
 }
 
 // Put writes both to temporary readwrite caching storage (available for read
 // operations) and to the underlying write-only CARv1 output at the same time.
 func (ttrw *CachingTempStore) Put(ctx context.Context, key string, data []byte) error {
-	ttrw.store.lk.Lock()
-	defer ttrw.store.lk.Unlock()
+	ttrw.lk.Lock()
+	defer ttrw.lk.Unlock()
 
 	if _, ok := ttrw.preloadKeys[key]; ok {
 		// already in preload, just write to the outWriter
@@ -124,37 +112,21 @@ func (ttrw *CachingTempStore) Put(ctx context.Context, key string, data []byte)
 func (ttrw *CachingTempStore) Close() error {
 	// we need to ensure that the writer receives no more data, so swap
 	// it out with a no-op writer that returns an error
-	ttrw.store.lk.Lock()
+	ttrw.lk.Lock()
 	ttrw.outWriter = func(lc linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
 		return nil, nil, errClosed
 	}
-	ttrw.store.lk.Unlock()
+	ttrw.lk.Unlock()
 	return ttrw.store.Close()
 }
 
 func (ttrw *CachingTempStore) teePut(ctx context.Context, key string, data []byte) error {
-	// use the store directly so we don't have lock contention
-	rw, err := ttrw.store.readWrite()
-	if err != nil {
+	// the block must be readable from the store by the time the output sees
+	// it, as a DuplicateAdderCar output reads repeated blocks back from it
+	if err := ttrw.store.Put(ctx, key, data); err != nil {
 		return err
 	}
-
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-	var err1, err2 error
-	go func() {
-		defer wg.Done()
-		err1 = rw.Put(ctx, key, data)
-	}()
-	go func() {
-		defer wg.Done()
-		err2 = writeTo(ctx, ttrw.outWriter, key, data)
-	}()
-	wg.Wait()
-	if err1 != nil {
-		return err1
-	}
-	return err2
+	return writeTo(ctx, ttrw.outWriter, key, data)
 }
 
 func writeTo(ctx context.Context, outWriter linking.BlockWriteOpener, key string, data []byte) error {
@@ -185,15 +157,15 @@ type preloadStore struct {
 }
 
 func (ps *preloadStore) Has(ctx context.Context, key string) (bool, error) {
-	ps.ttrw.store.lk.Lock()
-	defer ps.ttrw.store.lk.Unlock()
+	ps.ttrw.lk.Lock()
+	defer ps.ttrw.lk.Unlock()
 	_, has := ps.ttrw.preloadKeys[key]
 	return has, nil
 }
 
 func (ps *preloadStore) Get(ctx context.Context, key string) ([]byte, error) {
-	ps.ttrw.store.lk.Lock()
-	defer ps.ttrw.store.lk.Unlock()
+	ps.ttrw.lk.Lock()
+	defer ps.ttrw.lk.Unlock()
 	if _, ok := ps.ttrw.preloadKeys[key]; !ok {
 		c, err := cid.Cast([]byte(key))
 		if err != nil {
@@ -201,16 +173,12 @@ func (ps *preloadStore) Get(ctx context.Context, key string) ([]byte, error) {
 		}
 		return nil, carstorage.ErrNotFound{Cid: c}
 	}
-	if rw, err := ps.ttrw.store.readWrite(); err != nil {
-		return nil, err
-	} else {
-		return rw.Get(ctx, key)
-	}
+	return ps.ttrw.store.Get(ctx, key)
 }
 
 func (ps *preloadStore) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
-	ps.ttrw.store.lk.Lock()
-	defer ps.ttrw.store.lk.Unlock()
+	ps.ttrw.lk.Lock()
+	defer ps.ttrw.lk.Unlock()
 	if _, ok := ps.ttrw.preloadKeys[key]; !ok {
 		c, err := cid.Cast([]byte(key))
 		if err != nil {
@@ -218,33 +186,25 @@ func (ps *preloadStore) GetStream(ctx context.Context, key string) (io.ReadClose
 		}
 		return nil, carstorage.ErrNotFound{Cid: c}
 	}
-	if rw, err := ps.ttrw.store.readWrite(); err != nil {
-		return nil, err
-	} else {
-		return rw.GetStream(ctx, key)
-	}
+	return ps.ttrw.store.GetStream(ctx, key)
 }
 
 func (ps *preloadStore) Put(ctx context.Context, key string, data []byte) error {
-	ps.ttrw.store.lk.Lock()
-	defer ps.ttrw.store.lk.Unlock()
+	ps.ttrw.lk.Lock()
+	defer ps.ttrw.lk.Unlock()
 	// is it already in the preload list?
 	if _, ok := ps.ttrw.preloadKeys[key]; ok {
 		return nil
 	}
-	if rw, err := ps.ttrw.store.readWrite(); err != nil {
+	// do we already have it in the store?
+	if has, err := ps.ttrw.store.Has(ctx, key); err != nil {
 		return err
-	} else {
-		// do we already have it in the store?
-		if has, err := rw.Has(ctx, key); err != nil {
-			return err
-		} else if has {
-			return nil
-		}
-		if err := rw.Put(ctx, key, data); err != nil {
-			return err
-		}
-		ps.ttrw.preloadKeys[key] = struct{}{}
+	} else if has {
 		return nil
 	}
+	if err := ps.ttrw.store.Put(ctx, key, data); err != nil {
+		return err
+	}
+	ps.ttrw.preloadKeys[key] = struct{}{}
+	return nil
 }
@@ -21,9 +21,13 @@ var _ ReadableWritableStorage = (*DeferredStorageCar)(nil)
 // until the first Put() operation. In this way it can be optimistically
 // instantiated and no file will be created if it is never written to (such as
 // in the case of an error).
+//
+// A DeferredStorageCar created with NewDeferredStorageCarInMemory holds the
+// CAR in memory rather than in a temporary file.
 type DeferredStorageCar struct {
-	tempDir string
-	root    cid.Cid
+	tempDir  string
+	inMemory bool
+	root     cid.Cid
 
 	lk     sync.Mutex
 	closed bool
@@ -39,6 +43,15 @@ func NewDeferredStorageCar(tempDir string, root cid.Cid) *DeferredStorageCar {
 	}
 }
 
+// NewDeferredStorageCarInMemory creates a new DeferredStorageCar that never
+// touches disk, the CAR is held in memory until the storage is closed.
+func NewDeferredStorageCarInMemory(root cid.Cid) *DeferredStorageCar {
+	return &DeferredStorageCar{
+		inMemory: true,
+		root:     root,
+	}
+}
+
 // Close will clean up any temporary resources used by the storage.
 func (dcs *DeferredStorageCar) Close() error {
 	dcs.lk.Lock()
@@ -60,6 +73,12 @@ func (dcs *DeferredStorageCar) Close() error {
 
 // Has returns true if the underlying CARv1 has the key.
 func (dcs *DeferredStorageCar) Has(ctx context.Context, key string) (bool, error) {
+	if _, ok, err := AsIdentity(key); ok {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
 	dcs.lk.Lock()
 	defer dcs.lk.Unlock()
 
@@ -154,12 +173,18 @@ func (dcs *DeferredStorageCar) readWrite() (ReadableWritableStorage, error) {
 		return nil, errClosed
 	}
 	if dcs.rw == nil {
-		var err error
-		if dcs.f, err = os.CreateTemp(dcs.tempDir, "lassie_carstorage"); err != nil {
-			return nil, err
+		var backing carstorage.ReaderAtWriterAt
+		if dcs.inMemory {
+			backing = &memFile{}
+		} else {
+			var err error
+			if dcs.f, err = os.CreateTemp(dcs.tempDir, "lassie_carstorage"); err != nil {
+				return nil, err
+			}
+			backing = dcs.f
 		}
 		rw, err := carstorage.NewReadableWritable(
-			dcs.f,
+			backing,
 			[]cid.Cid{dcs.root},
 			carv2.WriteAsCarV1(true),
 			carv2.StoreIdentityCIDs(false),
@@ -186,3 +211,41 @@ func AsIdentity(key string) (digest []byte, ok bool, err error) {
 	digest = dmh.Digest
 	return digest, ok, nil
 }
+
+// memFile is an in-memory stand-in for the temporary file backing a
+// DeferredStorageCar. Like an os.File, Write appends at its own offset, which
+// WriteAt doesn't move.
+type memFile struct {
+	data []byte
+	off  int64
+}
+
+func (mf *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(mf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, mf.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mf *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(mf.data)) {
+		if end > int64(cap(mf.data)) {
+			grown := make([]byte, end, 2*end)
+			copy(grown, mf.data)
+			mf.data = grown
+		} else {
+			mf.data = mf.data[:end]
+		}
+	}
+	return copy(mf.data[off:], p), nil
+}
+
+func (mf *memFile) Write(p []byte) (int, error) {
+	n, err := mf.WriteAt(p, mf.off)
+	mf.off += int64(n)
+	return n, err
+}
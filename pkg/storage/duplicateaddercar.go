@@ -37,7 +37,7 @@ type DuplicateAdderCar struct {
 	path               string
 	scope              trustlessutils.DagScope
 	bytes              *trustlessutils.ByteRange
-	store              *DeferredStorageCar
+	store              ipldstorage.ReadableStorage
 	blockStream        *blockStream
 	streamCompletion   chan error
 	streamCompletionLk sync.Mutex
@@ -50,7 +50,7 @@ func NewDuplicateAdderCarForStream(
 	path string,
 	scope trustlessutils.DagScope,
 	bytes *trustlessutils.ByteRange,
-	store *DeferredStorageCar,
+	store ipldstorage.ReadableStorage,
 ) *DuplicateAdderCar {
 
 	outgoing := deferred.NewDeferredCarWriterForStream(
@@ -71,7 +71,7 @@ func NewDuplicateAdderCarForPath(
 	path string,
 	scope trustlessutils.DagScope,
 	bytes *trustlessutils.ByteRange,
-	store *DeferredStorageCar,
+	store ipldstorage.ReadableStorage,
 ) *DuplicateAdderCar {
 
 	outgoing := deferred.NewDeferredCarWriterForPath(
@@ -91,7 +91,7 @@ func newDuplicateAdderCar(
 	path string,
 	scope trustlessutils.DagScope,
 	bytes *trustlessutils.ByteRange,
-	store *DeferredStorageCar,
+	store ipldstorage.ReadableStorage,
 	outgoing *deferred.DeferredCarWriter,
 ) *DuplicateAdderCar {
 	blockStream := &blockStream{ctx: ctx, seen: make(map[cid.Cid]struct{})}
@@ -126,7 +126,7 @@ func (da *DuplicateAdderCar) addDupes() {
 	lsys := cidlink.DefaultLinkSystem()
 	// use the final car writer to write blocks
 	lsys.SetWriteStorage(da)
-	// use the deferred storage car to read in any dups we need
+	// use the temporary store to read in any dups we need
 	// to serve
 	lsys.SetReadStorage(da.store)
 	lsys.TrustedStorage = true
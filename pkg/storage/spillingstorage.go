@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	carstorage "github.com/ipld/go-car/v2/storage"
+)
+
+var _ types.TempStore = (*SpillingTempStore)(nil)
+
+// SpillingTempStore holds blocks in memory until their total size would exceed
+// a threshold, at which point they, and every block after them, are moved to
+// a DeferredStorageCar in a temporary directory. Most retrievals served by a
+// gateway are small, so this spares them the churn of creating, writing and
+// removing a temporary file each, while still bounding the memory held by the
+// occasional large one.
+type SpillingTempStore struct {
+	tempDir   string
+	root      cid.Cid
+	threshold uint64
+
+	lk      sync.Mutex
+	closed  bool
+	blocks  map[string][]byte
+	size    uint64
+	spilled *DeferredStorageCar
+}
+
+// NewSpillingTempStore creates a new SpillingTempStore holding up to threshold
+// bytes of blocks in memory before spilling them to a CAR in tempDir.
+func NewSpillingTempStore(tempDir string, root cid.Cid, threshold uint64) *SpillingTempStore {
+	return &SpillingTempStore{
+		tempDir:   tempDir,
+		root:      root,
+		threshold: threshold,
+		blocks:    make(map[string][]byte),
+	}
+}
+
+// NewSpillingTempStoreFactory returns a TempStoreFactory creating a
+// SpillingTempStore for each retrieval, see NewSpillingTempStore.
+func NewSpillingTempStoreFactory(tempDir string, threshold uint64) types.TempStoreFactory {
+	return func(root cid.Cid) (types.TempStore, error) {
+		return NewSpillingTempStore(tempDir, root, threshold), nil
+	}
+}
+
+// Spilled returns true if the blocks have been moved to disk.
+func (sts *SpillingTempStore) Spilled() bool {
+	sts.lk.Lock()
+	defer sts.lk.Unlock()
+	return sts.spilled != nil
+}
+
+// Close releases the blocks held in memory and removes the temporary CAR, if
+// the blocks were spilled.
+func (sts *SpillingTempStore) Close() error {
+	sts.lk.Lock()
+	defer sts.lk.Unlock()
+
+	if sts.closed {
+		return nil
+	}
+	sts.closed = true
+	sts.blocks = nil
+	sts.size = 0
+	if sts.spilled != nil {
+		return sts.spilled.Close()
+	}
+	return nil
+}
+
+// Has returns true if the block is held, in memory or on disk.
+func (sts *SpillingTempStore) Has(ctx context.Context, key string) (bool, error) {
+	if _, ok, err := AsIdentity(key); ok {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	sts.lk.Lock()
+	defer sts.lk.Unlock()
+
+	if sts.closed {
+		return false, errClosed
+	}
+	if sts.spilled != nil {
+		return sts.spilled.Has(ctx, key)
+	}
+	_, ok := sts.blocks[key]
+	return ok, nil
+}
+
+// Get returns the data of a block held, in memory or on disk.
+func (sts *SpillingTempStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if digest, ok, err := AsIdentity(key); ok {
+		return digest, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	sts.lk.Lock()
+	defer sts.lk.Unlock()
+
+	if sts.closed {
+		return nil, errClosed
+	}
+	if sts.spilled != nil {
+		return sts.spilled.Get(ctx, key)
+	}
+	data, ok := sts.blocks[key]
+	if !ok {
+		keyCid, err := cid.Cast([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("bad CID key: %w", err)
+		}
+		return nil, carstorage.ErrNotFound{Cid: keyCid}
+	}
+	return data, nil
+}
+
+// GetStream returns the data of a block held, in memory or on disk.
+func (sts *SpillingTempStore) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	sts.lk.Lock()
+	spilled := sts.spilled
+	sts.lk.Unlock()
+	if spilled != nil {
+		return spilled.GetStream(ctx, key)
+	}
+	data, err := sts.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Put holds a copy of the block in memory or, once the blocks held would
+// exceed the threshold, spills them all to disk along with it.
+func (sts *SpillingTempStore) Put(ctx context.Context, key string, data []byte) error {
+	if _, ok, err := AsIdentity(key); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sts.lk.Lock()
+	defer sts.lk.Unlock()
+
+	if sts.closed {
+		return errClosed
+	}
+	if sts.spilled == nil {
+		if _, ok := sts.blocks[key]; ok {
+			return nil
+		}
+		if sts.size+uint64(len(data)) <= sts.threshold {
+			sts.blocks[key] = append([]byte(nil), data...)
+			sts.size += uint64(len(data))
+			return nil
+		}
+		if err := sts.spill(ctx); err != nil {
+			return err
+		}
+	}
+	return sts.spilled.Put(ctx, key, data)
+}
+
+// spill moves the blocks held in memory to a DeferredStorageCar. Must be
+// called with the lock held.
+func (sts *SpillingTempStore) spill(ctx context.Context) error {
+	spilled := NewDeferredStorageCar(sts.tempDir, sts.root)
+	for key, data := range sts.blocks {
+		if err := spilled.Put(ctx, key, data); err != nil {
+			_ = spilled.Close()
+			return err
+		}
+	}
+	sts.spilled = spilled
+	sts.blocks = nil
+	sts.size = 0
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	carstorage "github.com/ipld/go-car/v2/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillingTempStore(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	requireTempFiles := func(n int) {
+		entries, err := os.ReadDir(tempDir)
+		require.NoError(t, err)
+		require.Len(t, entries, n)
+	}
+
+	// blocks from randBlock are 1KiB each
+	store := NewSpillingTempStore(tempDir, randCid(), 2<<10)
+	c1, d1 := randBlock()
+	c2, d2 := randBlock()
+	c3, d3 := randBlock()
+
+	_, err := store.Get(ctx, c1.KeyString())
+	require.ErrorAs(t, err, &carstorage.ErrNotFound{})
+
+	// held in memory up to the threshold
+	require.NoError(t, store.Put(ctx, c1.KeyString(), d1))
+	require.NoError(t, store.Put(ctx, c2.KeyString(), d2))
+	require.NoError(t, store.Put(ctx, c2.KeyString(), d2))
+	require.False(t, store.Spilled())
+	requireTempFiles(0)
+
+	// the data is copied, the caller may reuse its buffer
+	d1[0]++
+	got, err := store.Get(ctx, c1.KeyString())
+	require.NoError(t, err)
+	require.NotEqual(t, d1, got)
+	d1[0]--
+
+	// and spilled to disk beyond it, every block still readable
+	require.NoError(t, store.Put(ctx, c3.KeyString(), d3))
+	require.True(t, store.Spilled())
+	requireTempFiles(1)
+	for _, blk := range []struct {
+		key  string
+		data []byte
+	}{{c1.KeyString(), d1}, {c2.KeyString(), d2}, {c3.KeyString(), d3}} {
+		has, err := store.Has(ctx, blk.key)
+		require.NoError(t, err)
+		require.True(t, has)
+		got, err := store.Get(ctx, blk.key)
+		require.NoError(t, err)
+		require.Equal(t, blk.data, got)
+		rdr, err := store.GetStream(ctx, blk.key)
+		require.NoError(t, err)
+		got, err = io.ReadAll(rdr)
+		require.NoError(t, err)
+		require.Equal(t, blk.data, got)
+	}
+
+	require.NoError(t, store.Close())
+	requireTempFiles(0)
+	_, err = store.Has(ctx, c1.KeyString())
+	require.ErrorIs(t, err, errClosed)
+	require.ErrorIs(t, store.Put(ctx, c1.KeyString(), d1), errClosed)
+	require.NoError(t, store.Close())
+
+	// a store that never spills never touches disk
+	store = NewSpillingTempStore(tempDir, randCid(), 2<<10)
+	require.NoError(t, store.Put(ctx, c1.KeyString(), d1))
+	require.NoError(t, store.Close())
+	requireTempFiles(0)
+}
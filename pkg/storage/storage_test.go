@@ -19,9 +19,9 @@ func TestTempCarStorage(t *testing.T) {
 	// Testing both DeferredCarStorage and CachingTempStore here with just some
 	// additional pieces of logic to make sure the teeing version is actually
 	// teeing.
-	for _, teeing := range []bool{true, false} {
-		teeing := teeing
-		t.Run(fmt.Sprintf("teeing=%t", teeing), func(t *testing.T) {
+	for _, tc := range []struct{ teeing, inMemory bool }{{true, false}, {false, false}, {true, true}, {false, true}} {
+		teeing, inMemory := tc.teeing, tc.inMemory
+		t.Run(fmt.Sprintf("teeing=%t,inMemory=%t", teeing, inMemory), func(t *testing.T) {
 			t.Parallel()
 			ctx := context.Background()
 			testCid1, testData1 := randBlock()
@@ -30,6 +30,13 @@ func TestTempCarStorage(t *testing.T) {
 
 			tempDir := t.TempDir()
 
+			newStore := func() *DeferredStorageCar {
+				if inMemory {
+					return NewDeferredStorageCarInMemory(testCid1)
+				}
+				return NewDeferredStorageCar(tempDir, testCid1)
+			}
+
 			teeCollect := make(map[cid.Cid][]byte, 0)
 			var cw types.ReadableWritableStorage
 			if teeing {
@@ -40,9 +47,9 @@ func TestTempCarStorage(t *testing.T) {
 						return nil
 					}, nil
 				}
-				cw = NewCachingTempStore(bwo, NewDeferredStorageCar(tempDir, testCid1))
+				cw = NewCachingTempStore(bwo, newStore())
 			} else {
-				cw = NewDeferredStorageCar(tempDir, testCid1)
+				cw = newStore()
 			}
 
 			ents, err := os.ReadDir(tempDir)
@@ -101,11 +108,15 @@ func TestTempCarStorage(t *testing.T) {
 
 			ents, err = os.ReadDir(tempDir)
 			require.NoError(t, err)
-			require.Len(t, ents, 1)
-			require.Contains(t, ents[0].Name(), "carstorage")
-			stat, err := os.Stat(tempDir + "/" + ents[0].Name())
-			require.NoError(t, err)
-			require.True(t, stat.Size() > int64(len(testData1)+len(testData2)))
+			if inMemory {
+				require.Len(t, ents, 0)
+			} else {
+				require.Len(t, ents, 1)
+				require.Contains(t, ents[0].Name(), "carstorage")
+				stat, err := os.Stat(tempDir + "/" + ents[0].Name())
+				require.NoError(t, err)
+				require.True(t, stat.Size() > int64(len(testData1)+len(testData2)))
+			}
 
 			closer, ok := cw.(io.Closer)
 			require.True(t, ok)
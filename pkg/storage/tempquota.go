@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrTempQuotaExceeded is returned by a TempStore wrapped by a TempQuota
+	// for a block that would take the total size of the blocks held beyond
+	// the quota.
+	ErrTempQuotaExceeded = errors.New("temporary storage quota exceeded")
+	// ErrTempQuotaFull is returned by TempQuota#Admit when the blocks held
+	// stay at or above the quota's high water mark.
+	ErrTempQuotaFull = errors.New("temporary storage is near its quota")
+)
+
+// TempQuota bounds the total size of the blocks held at once by every
+// TempStore it wraps, such as those of all of the retrievals served by a
+// daemon, so that the temporary storage of many large retrievals can't fill
+// a disk, or memory. A block that would take the total beyond the quota fails
+// to be written, failing its retrieval, and Admit holds back new retrievals
+// while the total is at or above a high water mark below the quota, so that
+// those in progress have room to finish. The size of a block is that of its
+// data, not counting the overhead of the storage holding it. A TempQuota is
+// safe for concurrent use.
+type TempQuota struct {
+	max       uint64
+	highWater uint64
+
+	lk      sync.Mutex
+	used    uint64
+	stores  int
+	changed chan struct{} // closed and replaced whenever used falls
+
+	admitted *prometheus.CounterVec
+	exceeded prometheus.Counter
+}
+
+// NewTempQuota returns a TempQuota of max bytes, admitting new retrievals
+// while fewer than highWater bytes are held, or 90% of max if highWater is
+// zero.
+func NewTempQuota(max uint64, highWater uint64) (*TempQuota, error) {
+	if max == 0 {
+		return nil, errors.New("temporary storage quota must be greater than zero")
+	}
+	if highWater == 0 {
+		highWater = max / 10 * 9
+	}
+	if highWater > max {
+		return nil, fmt.Errorf("temporary storage high water mark of %d bytes is above the quota of %d bytes", highWater, max)
+	}
+	return &TempQuota{
+		max:       max,
+		highWater: highWater,
+		changed:   make(chan struct{}),
+		admitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "temp_storage_admissions_total",
+			Help:      "Number of retrievals admitted or rejected by the temporary storage quota, by result.",
+		}, []string{"result"}),
+		exceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "lassie",
+			Name:      "temp_storage_quota_exceeded_total",
+			Help:      "Number of blocks that failed to be written as they would exceed the temporary storage quota.",
+		}),
+	}, nil
+}
+
+// Max returns the quota, in bytes.
+func (q *TempQuota) Max() uint64 {
+	return q.max
+}
+
+// HighWater returns the number of bytes held at or above which new retrievals
+// aren't admitted.
+func (q *TempQuota) HighWater() uint64 {
+	return q.highWater
+}
+
+// Used returns the total size of the blocks held by the TempStores wrapped.
+func (q *TempQuota) Used() uint64 {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.used
+}
+
+// Admits returns true if a new retrieval would be admitted right away, as the
+// blocks held are below the high water mark.
+func (q *TempQuota) Admits() bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.used < q.highWater
+}
+
+// Admit waits until the blocks held are below the high water mark, for at
+// most the timeout, failing with ErrTempQuotaFull if they aren't by then. A
+// timeout of zero doesn't wait.
+func (q *TempQuota) Admit(ctx context.Context, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		q.lk.Lock()
+		used, changed := q.used, q.changed
+		q.lk.Unlock()
+		if used < q.highWater {
+			q.admitted.WithLabelValues("admitted").Inc()
+			return nil
+		}
+		if timeout <= 0 {
+			q.admitted.WithLabelValues("rejected").Inc()
+			return fmt.Errorf("%w: %d of %d bytes in use", ErrTempQuotaFull, used, q.max)
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			q.admitted.WithLabelValues("rejected").Inc()
+			return fmt.Errorf("%w: %d of %d bytes in use", ErrTempQuotaFull, used, q.max)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Wrap returns a TempStore that counts the blocks written to store against
+// the quota, until it's closed.
+func (q *TempQuota) Wrap(store types.TempStore) types.TempStore {
+	q.lk.Lock()
+	q.stores++
+	q.lk.Unlock()
+	return &quotaTempStore{TempStore: store, quota: q}
+}
+
+// Factory returns a TempStoreFactory that wraps each TempStore created by
+// factory, see Wrap.
+func (q *TempQuota) Factory(factory types.TempStoreFactory) types.TempStoreFactory {
+	return func(root cid.Cid) (types.TempStore, error) {
+		store, err := factory(root)
+		if err != nil {
+			return nil, err
+		}
+		return q.Wrap(store), nil
+	}
+}
+
+// Collectors returns Prometheus collectors for the quota, the bytes held, the
+// quota itself, the number of stores holding them, the retrievals admitted and
+// rejected and the blocks rejected for exceeding the quota, to be registered
+// with a Registerer.
+func (q *TempQuota) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "lassie",
+			Name:      "temp_storage_used_bytes",
+			Help:      "Total size of the blocks held in temporary storage.",
+		}, func() float64 { return float64(q.Used()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "lassie",
+			Name:      "temp_storage_quota_bytes",
+			Help:      "Quota of the total size of the blocks held in temporary storage.",
+		}, func() float64 { return float64(q.max) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "lassie",
+			Name:      "temp_storage_stores",
+			Help:      "Number of temporary stores open, one for each retrieval in progress.",
+		}, func() float64 {
+			q.lk.Lock()
+			defer q.lk.Unlock()
+			return float64(q.stores)
+		}),
+		q.admitted,
+		q.exceeded,
+	}
+}
+
+func (q *TempQuota) reserve(size uint64) error {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.used+size > q.max {
+		q.exceeded.Inc()
+		return fmt.Errorf("%w: %d of %d bytes in use", ErrTempQuotaExceeded, q.used, q.max)
+	}
+	q.used += size
+	return nil
+}
+
+func (q *TempQuota) release(size uint64, closed bool) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	q.used -= size
+	if closed {
+		q.stores--
+	}
+	if size > 0 {
+		close(q.changed)
+		q.changed = make(chan struct{})
+	}
+}
+
+type quotaTempStore struct {
+	types.TempStore
+	quota *TempQuota
+
+	lk     sync.Mutex
+	used   uint64
+	closed bool
+}
+
+func (qts *quotaTempStore) Put(ctx context.Context, key string, data []byte) error {
+	size := uint64(len(data))
+	if err := qts.quota.reserve(size); err != nil {
+		return err
+	}
+	if err := qts.TempStore.Put(ctx, key, data); err != nil {
+		qts.quota.release(size, false)
+		return err
+	}
+	qts.lk.Lock()
+	defer qts.lk.Unlock()
+	if qts.closed {
+		// written after the store was closed, and released, already
+		qts.quota.release(size, false)
+		return nil
+	}
+	qts.used += size
+	return nil
+}
+
+func (qts *quotaTempStore) Close() error {
+	err := qts.TempStore.Close()
+	qts.lk.Lock()
+	defer qts.lk.Unlock()
+	if !qts.closed {
+		qts.closed = true
+		qts.quota.release(qts.used, true)
+		qts.used = 0
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempQuota(t *testing.T) {
+	ctx := context.Background()
+
+	// blocks from randBlock are 1KiB each
+	quota, err := NewTempQuota(3<<10, 2<<10)
+	require.NoError(t, err)
+	reg := prometheus.NewRegistry()
+	for _, collector := range quota.Collectors() {
+		require.NoError(t, reg.Register(collector))
+	}
+
+	require.NoError(t, quota.Admit(ctx, 0))
+	store1 := quota.Wrap(NewDeferredStorageCarInMemory(randCid()))
+	store2 := quota.Wrap(NewDeferredStorageCarInMemory(randCid()))
+
+	c1, d1 := randBlock()
+	c2, d2 := randBlock()
+	c3, d3 := randBlock()
+	require.NoError(t, store1.Put(ctx, c1.KeyString(), d1))
+	require.NoError(t, store2.Put(ctx, c2.KeyString(), d2))
+	require.Equal(t, uint64(2<<10), quota.Used())
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP lassie_temp_storage_stores Number of temporary stores open, one for each retrieval in progress.
+# TYPE lassie_temp_storage_stores gauge
+lassie_temp_storage_stores 2
+# HELP lassie_temp_storage_used_bytes Total size of the blocks held in temporary storage.
+# TYPE lassie_temp_storage_used_bytes gauge
+lassie_temp_storage_used_bytes 2048
+`), "lassie_temp_storage_stores", "lassie_temp_storage_used_bytes"))
+
+	// at the high water mark, new retrievals aren't admitted, but those in
+	// progress may write up to the quota
+	require.False(t, quota.Admits())
+	require.ErrorIs(t, quota.Admit(ctx, 0), ErrTempQuotaFull)
+	require.ErrorIs(t, quota.Admit(ctx, 10*time.Millisecond), ErrTempQuotaFull)
+	require.NoError(t, store1.Put(ctx, c3.KeyString(), d3))
+	c4, d4 := randBlock()
+	require.ErrorIs(t, store2.Put(ctx, c4.KeyString(), d4), ErrTempQuotaExceeded)
+	has, err := store2.Has(ctx, c4.KeyString())
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, uint64(3<<10), quota.Used())
+
+	// a retrieval waiting for room is admitted once another finishes
+	admitted := make(chan error, 1)
+	go func() { admitted <- quota.Admit(ctx, 10*time.Second) }()
+	select {
+	case err := <-admitted:
+		t.Fatalf("admitted while full: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.NoError(t, store1.Close())
+	require.NoError(t, <-admitted)
+	require.Equal(t, uint64(1<<10), quota.Used())
+	require.True(t, quota.Admits())
+
+	// closing again releases nothing more
+	require.NoError(t, store1.Close())
+	require.Equal(t, uint64(1<<10), quota.Used())
+	require.NoError(t, store2.Close())
+	require.Equal(t, uint64(0), quota.Used())
+
+	// a cancelled wait isn't counted as rejected
+	fill := quota.Wrap(NewDeferredStorageCarInMemory(randCid()))
+	for i := 0; i < 2; i++ {
+		c, d := randBlock()
+		require.NoError(t, fill.Put(ctx, c.KeyString(), d))
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, quota.Admit(cancelled, time.Second), context.Canceled)
+	require.NoError(t, fill.Close())
+
+	require.Equal(t, float64(2), testutil.ToFloat64(quota.admitted.WithLabelValues("admitted")))
+	require.Equal(t, float64(2), testutil.ToFloat64(quota.admitted.WithLabelValues("rejected")))
+	require.Equal(t, float64(1), testutil.ToFloat64(quota.exceeded))
+
+	_, err = NewTempQuota(0, 0)
+	require.Error(t, err)
+	_, err = NewTempQuota(10, 11)
+	require.Error(t, err)
+	quota, err = NewTempQuota(100, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(90), quota.HighWater())
+}
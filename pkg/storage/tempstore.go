@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+)
+
+var _ types.TempStore = (*DeferredStorageCar)(nil)
+var _ types.TempStore = (*blockstoreTempStore)(nil)
+
+// NewTempCarStoreFactory returns a TempStoreFactory that holds the blocks of
+// each retrieval in its own DeferredStorageCar, a CAR file in tempDir that's
+// created on the first write and removed when the retrieval is done. This is
+// Lassie's default temporary storage.
+func NewTempCarStoreFactory(tempDir string) types.TempStoreFactory {
+	return func(root cid.Cid) (types.TempStore, error) {
+		return NewDeferredStorageCar(tempDir, root), nil
+	}
+}
+
+// NewInMemoryCarStoreFactory returns a TempStoreFactory that holds the blocks
+// of each retrieval in its own DeferredStorageCar in memory, so that the
+// retrieval never touches disk.
+func NewInMemoryCarStoreFactory() types.TempStoreFactory {
+	return func(root cid.Cid) (types.TempStore, error) {
+		return NewDeferredStorageCarInMemory(root), nil
+	}
+}
+
+// NewBlockstoreTempStoreFactory returns a TempStoreFactory that holds the
+// blocks of each retrieval in a Blockstore returned by open, such as one over
+// a badger or flatfs datastore, or a go-datastore MapDatastore for memory.
+// Each call to open should return an empty Blockstore of its own, along with a
+// function that's called when the retrieval is done to close it and discard
+// its blocks, e.g. by removing its directory.
+func NewBlockstoreTempStoreFactory(open func(root cid.Cid) (blockstore.Blockstore, func() error, error)) types.TempStoreFactory {
+	return func(root cid.Cid) (types.TempStore, error) {
+		bs, close, err := open(root)
+		if err != nil {
+			return nil, err
+		}
+		return &blockstoreTempStore{BlockstoreStorage: NewBlockstoreStorage(bs), close: close}, nil
+	}
+}
+
+type blockstoreTempStore struct {
+	*BlockstoreStorage
+	close func() error
+}
+
+func (bts *blockstoreTempStore) Close() error {
+	if bts.close == nil {
+		return nil
+	}
+	return bts.close()
+}
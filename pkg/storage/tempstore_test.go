@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/types"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempStoreFactories(t *testing.T) {
+	ctx := context.Background()
+
+	tempDir := t.TempDir()
+	spillDir := t.TempDir()
+	var closed bool
+	tc := []struct {
+		name       string
+		factory    types.TempStoreFactory
+		checkClose func(t *testing.T)
+	}{
+		{
+			name:    "temp car",
+			factory: NewTempCarStoreFactory(tempDir),
+			checkClose: func(t *testing.T) {
+				entries, err := os.ReadDir(tempDir)
+				require.NoError(t, err)
+				require.Empty(t, entries)
+			},
+		},
+		{
+			name:    "in-memory car",
+			factory: NewInMemoryCarStoreFactory(),
+		},
+		{
+			name:    "spilling",
+			factory: NewSpillingTempStoreFactory(spillDir, 1<<10),
+			checkClose: func(t *testing.T) {
+				entries, err := os.ReadDir(spillDir)
+				require.NoError(t, err)
+				require.Empty(t, entries)
+			},
+		},
+		{
+			name: "blockstore",
+			factory: NewBlockstoreTempStoreFactory(func(root cid.Cid) (blockstore.Blockstore, func() error, error) {
+				closed = false
+				bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+				return bs, func() error { closed = true; return nil }, nil
+			}),
+			checkClose: func(t *testing.T) {
+				require.True(t, closed)
+			},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			testCid1, testData1 := randBlock()
+			testCid2, testData2 := randBlock()
+
+			store, err := tt.factory(testCid1)
+			require.NoError(t, err)
+
+			// used as the temporary store of a CachingTempStore, blocks are
+			// written through to the output and readable, preloaded blocks
+			// only from the preload store
+			var out []cid.Cid
+			bwo := func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+				var buf bytes.Buffer
+				return &buf, func(lnk datamodel.Link) error {
+					out = append(out, lnk.(cidlink.Link).Cid)
+					return nil
+				}, nil
+			}
+			cts := NewCachingTempStore(bwo, store)
+
+			has, err := cts.Has(ctx, testCid1.KeyString())
+			require.NoError(t, err)
+			require.False(t, has)
+			require.NoError(t, cts.Put(ctx, testCid1.KeyString(), testData1))
+			require.NoError(t, cts.PreloadStore().Put(ctx, testCid2.KeyString(), testData2))
+
+			got, err := cts.Get(ctx, testCid1.KeyString())
+			require.NoError(t, err)
+			require.Equal(t, testData1, got)
+			gotStream, err := cts.GetStream(ctx, testCid1.KeyString())
+			require.NoError(t, err)
+			got, err = io.ReadAll(gotStream)
+			require.NoError(t, err)
+			require.Equal(t, testData1, got)
+			has, err = cts.Has(ctx, testCid2.KeyString())
+			require.NoError(t, err)
+			require.False(t, has)
+			got, err = cts.PreloadStore().Get(ctx, testCid2.KeyString())
+			require.NoError(t, err)
+			require.Equal(t, testData2, got)
+			require.Equal(t, []cid.Cid{testCid1}, out)
+
+			require.NoError(t, cts.Put(ctx, testCid2.KeyString(), testData2))
+			require.Equal(t, []cid.Cid{testCid1, testCid2}, out)
+
+			require.NoError(t, cts.Close())
+			if tt.checkClose != nil {
+				tt.checkClose(t)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// LinkDecision is the outcome of a LinkPolicy for a single link.
+type LinkDecision int
+
+const (
+	// LinkContinue fetches the link and continues the traversal below it.
+	LinkContinue LinkDecision = iota
+	// LinkSkip leaves the link, and everything below it, out of the retrieval
+	// while continuing with the rest of the DAG.
+	LinkSkip
+	// LinkAbort ends the retrieval with an error.
+	LinkAbort
+)
+
+func (d LinkDecision) String() string {
+	switch d {
+	case LinkContinue:
+		return "continue"
+	case LinkSkip:
+		return "skip"
+	case LinkAbort:
+		return "abort"
+	default:
+		return "unknown"
+	}
+}
+
+// LinkPolicy is consulted for each link encountered during a retrieval, with
+// the path of the link from the root of the DAG and the CID it points to. It
+// can be used to enforce application-specific rules, such as excluding
+// certain paths or nested DAGs, while the retrieval is in progress.
+//
+// Where Lassie drives the traversal (Bitswap, and HTTP requests with an
+// explicit Selector) the policy is consulted before a link is fetched, and a
+// skipped link is never fetched. Where the provider drives the traversal
+// (Graphsync, and other HTTP requests) the policy is consulted as each block
+// arrives; blocks of a skipped link are still received but are not written to
+// the request's LinkSystem.
+//
+// A LinkPolicy may be called concurrently and more than once for the same
+// link, so it should depend only on its arguments and be safe for concurrent
+// use.
+type LinkPolicy func(path datamodel.Path, c cid.Cid) LinkDecision
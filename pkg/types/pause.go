@@ -0,0 +1,85 @@
+package types
+
+import (
+	"context"
+	"sync"
+)
+
+// PauseControl pauses and resumes a retrieval that is in progress. While
+// paused, a retrieval keeps its state and connections but stops making
+// progress:
+//
+//   - Bitswap sends no new wants, blocks already requested may still arrive
+//   - Graphsync and HTTP stop reading blocks, so the provider is held back by
+//     flow control on the connection
+//   - no new providers are tried
+//
+// Provider timeouts don't apply while a retrieval is paused, but a global
+// timeout continues to run.
+//
+// A nil *PauseControl is never paused.
+type PauseControl struct {
+	lk      sync.Mutex
+	resumed chan struct{} // non-nil while paused, closed on resume
+}
+
+// NewPauseControl creates a PauseControl in the running state.
+func NewPauseControl() *PauseControl {
+	return &PauseControl{}
+}
+
+// Pause pauses the retrieval, returning false if it was already paused.
+func (pc *PauseControl) Pause() bool {
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+	if pc.resumed != nil {
+		return false
+	}
+	pc.resumed = make(chan struct{})
+	return true
+}
+
+// Resume resumes the retrieval, returning false if it wasn't paused.
+func (pc *PauseControl) Resume() bool {
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+	if pc.resumed == nil {
+		return false
+	}
+	close(pc.resumed)
+	pc.resumed = nil
+	return true
+}
+
+// Paused returns whether the retrieval is paused.
+func (pc *PauseControl) Paused() bool {
+	if pc == nil {
+		return false
+	}
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+	return pc.resumed != nil
+}
+
+// Wait blocks while the retrieval is paused, returning whether it had to wait
+// or an error if the context is done first.
+func (pc *PauseControl) Wait(ctx context.Context) (bool, error) {
+	if pc == nil {
+		return false, nil
+	}
+	waited := false
+	for {
+		pc.lk.Lock()
+		resumed := pc.resumed
+		pc.lk.Unlock()
+		if resumed == nil {
+			return waited, nil
+		}
+		waited = true
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return waited, ctx.Err()
+		}
+	}
+}
@@ -2,23 +2,52 @@ package types
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/filecoin-project/lassie/pkg/contentpath"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	ipldstorage "github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	"github.com/ipni/go-libipni/maurl"
 	"github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	ErrByteRangeWithSelector = errors.New("byte range can't be used with an explicit selector")
+	ErrInvalidByteRange      = errors.New("invalid byte range")
+	ErrInvalidSelector       = errors.New("invalid selector")
+	ErrInvalidDepth          = errors.New("invalid depth limit")
+)
+
+const (
+	// MaxSelectorRecursionDepth is the largest explicit recursion depth limit
+	// accepted in a custom selector. Unlimited recursion (as used for
+	// dag-scope=all) is still permitted, a request's MaxBlocks should be used
+	// to bound such retrievals.
+	MaxSelectorRecursionDepth int64 = 1 << 16
+	// MaxSelectorNesting is the maximum nesting depth of a custom selector
+	// document.
+	MaxSelectorNesting = 64
 )
 
 type ReadableWritableStorage interface {
@@ -27,6 +56,18 @@ type ReadableWritableStorage interface {
 	ipldstorage.StreamingReadableStorage
 }
 
+// TempStore holds blocks for the duration of a retrieval that aren't yet, or
+// won't be, written to the request's LinkSystem, such as those preloaded by
+// Bitswap before the traversal reaches them. It's closed once the retrieval is
+// done, discarding the blocks it holds.
+type TempStore interface {
+	ReadableWritableStorage
+	io.Closer
+}
+
+// TempStoreFactory creates a new, empty, TempStore for a retrieval of root.
+type TempStoreFactory func(root cid.Cid) (TempStore, error)
+
 type RetrievalID uuid.UUID
 
 func NewRetrievalID() (RetrievalID, error) {
@@ -49,8 +90,47 @@ func (id *RetrievalID) UnmarshalText(data []byte) error {
 	return (*uuid.UUID)(id).UnmarshalText(data)
 }
 
+// ProviderQueryLimits bounds the queries a single retrieval may issue while
+// finding providers and asking them for content, separately from any limits on
+// the blocks transferred. This prevents content with a very large number of
+// advertised providers from generating a storm of queries. Zero values mean no
+// limit.
+type ProviderQueryLimits struct {
+	// MaxCandidates is the maximum number of candidates accepted from the
+	// candidate finder, e.g. the indexer; further provider records are ignored.
+	MaxCandidates uint
+	// MaxGraphsyncQueries is the maximum number of providers that are asked
+	// for the content over Graphsync.
+	MaxGraphsyncQueries uint
+	// MaxHttpQueries is the maximum number of providers that are sent an HTTP
+	// request for the content.
+	MaxHttpQueries uint
+}
+
+// MaxQueries returns the maximum number of providers that may be queried using
+// the given protocol, zero means no limit.
+func (l ProviderQueryLimits) MaxQueries(protocol multicodec.Code) uint {
+	switch protocol {
+	case multicodec.TransportGraphsyncFilecoinv1:
+		return l.MaxGraphsyncQueries
+	case multicodec.TransportIpfsGatewayHttp:
+		return l.MaxHttpQueries
+	default:
+		return 0
+	}
+}
+
 // RetrievalRequest describes the parameters of a request. It is intended to be
 // immutable.
+//
+// The embedded trustlessutils.Request carries the Root, Path, Scope and Bytes
+// (entity-bytes) of the request. A byte range is only applied when the Scope
+// is DagScopeEntity and the entity at the end of the Path is a UnixFS file,
+// in which case only the blocks required to read that range of the file are
+// fetched. The same selector is used for Bitswap and Graphsync traversals and
+// for verifying HTTP responses, so the blocks fetched for a byte range are the
+// same regardless of transport. See ByteRangeFrom, ByteRangeBetween and
+// ByteRangeLast for helpers to construct byte ranges.
 type RetrievalRequest struct {
 	trustlessutils.Request
 
@@ -65,6 +145,14 @@ type RetrievalRequest struct {
 
 	// Selector is the IPLD selector to use when fetching the DAG. If nil, the
 	// Path and Scope will be used to generate a selector.
+	//
+	// A custom selector is executed directly by Graphsync and Bitswap
+	// retrievals. HTTP retrievals can't transmit a selector, so the Path and
+	// Scope are used to form the HTTP request and the selector is then applied
+	// locally to the verified response, only the blocks matched by the
+	// selector are written to the LinkSystem. The Path should therefore be
+	// set to the deepest path that still covers the selector to limit the
+	// amount of data transferred over HTTP.
 	Selector ipld.Node
 
 	// Protocols is an optional list of protocols to use when fetching the DAG.
@@ -77,12 +165,83 @@ type RetrievalRequest struct {
 	PreloadLinkSystem ipld.LinkSystem
 
 	// MaxBlocks optionally specifies the maximum number of blocks to fetch.
-	// If zero, no limit is applied.
+	// If zero, no limit is applied. When the limit is reached the retrieval
+	// ends early with a partial result, see RetrievalStats#Partial.
 	MaxBlocks uint64
 
+	// MaxBytes optionally specifies the maximum number of bytes of block data
+	// to fetch. If zero, no limit is applied. A block that would exceed the
+	// limit is not written, and the retrieval ends early with a partial result,
+	// see RetrievalStats#Partial.
+	MaxBytes uint64
+
+	// MaxDuration optionally specifies how long the retrieval may spend
+	// fetching blocks. If zero, no limit is applied. Over the second half of
+	// the duration, links ever closer to the root are skipped, except for
+	// those along the request's Path, so that a retrieval running out of time
+	// completes the shallower parts of the DAG rather than being cut off deep
+	// within one branch. When the duration has elapsed the retrieval ends with
+	// a partial result, see RetrievalStats#Partial.
+	MaxDuration time.Duration
+
+	// MaxBlockSize optionally specifies the maximum size, in bytes, of any
+	// single block received. A provider that sends a larger block fails the
+	// retrieval attempt and is recorded as having failed. If zero, no limit is
+	// applied; Lassie sets this from its configuration when it is not set.
+	MaxBlockSize uint64
+
+	// QueryLimits optionally bounds the number of providers found and queried
+	// for this retrieval. If zero, Lassie sets this from its configuration.
+	QueryLimits ProviderQueryLimits
+
+	// MaxAttempts optionally limits the number of distinct providers the
+	// retrieval attempts, across all protocols, before failing. A provider
+	// attempted over one protocol may still be attempted over another. Further
+	// candidates are ignored once the limit is reached. If zero, no limit is
+	// applied.
+	MaxAttempts uint
+
+	// ProviderTimeout optionally overrides the configured timeout for
+	// retrieving from each provider, see lassie.WithProviderTimeout. If zero,
+	// the configured timeout is used.
+	ProviderTimeout time.Duration
+
+	// LinkPolicy is optionally consulted for each link encountered during the
+	// retrieval to decide whether it is fetched, skipped, or ends the
+	// retrieval. If nil, all links matched by the selector are fetched.
+	LinkPolicy LinkPolicy
+
+	// PauseControl optionally allows the retrieval to be paused and resumed
+	// while it is in progress. If nil, the retrieval is never paused.
+	PauseControl *PauseControl
+
+	// ProviderAllowList and ProviderBlockList optionally restrict the
+	// providers used for this retrieval. They are evaluated in addition to
+	// any allow and block lists Lassie is configured with, so a provider must
+	// be acceptable to both. If ProviderAllowList is non-empty, only the
+	// providers on it are used; providers on ProviderBlockList are never used.
+	ProviderAllowList map[peer.ID]bool
+	ProviderBlockList map[peer.ID]bool
+
 	// FixedPeers optionally specifies a list of peers to use when fetching
 	// blocks. If nil, the default peer discovery mechanism will be used.
 	FixedPeers []peer.AddrInfo
+
+	// ExpectedSize is an optional estimate, in bytes, of the total size of
+	// the content to be fetched, such as the Tsize of the link to it in its
+	// parent UnixFS directory, which the caller must supply as Lassie doesn't
+	// read UnixFS metadata ahead of a retrieval. It is used to choose a
+	// strategy for ordering candidates. If zero, the size recorded for a
+	// recent retrieval of the same content is used, if any.
+	ExpectedSize uint64
+
+	// AffinityKey optionally groups retrievals that are part of the same
+	// dataset, or otherwise benefit from being served by the same providers.
+	// Graphsync and HTTP retrievals with a key prefer the providers that
+	// served earlier retrievals with the same key, whose connections are warm
+	// and whose caches may hold the rest of the dataset, and fail over to
+	// other providers as usual. See RetrievalStats#AffinityHit.
+	AffinityKey string
 }
 
 // NewRequestForPath creates a new RetrievalRequest for the given root CID as
@@ -131,6 +290,211 @@ func NewRequestForPath(
 	}, nil
 }
 
+// NewRequestForByteRange creates a new RetrievalRequest for a byte range of
+// the UnixFS file found at the given path within the graph under the root CID.
+// The request uses DagScopeEntity, which is required for a byte range to be
+// applied. See NewRequestForPath for details of the LinkSystem setup.
+func NewRequestForByteRange(
+	store ipldstorage.WritableStorage,
+	rootCid cid.Cid,
+	path string,
+	byteRange trustlessutils.ByteRange,
+) (RetrievalRequest, error) {
+	return NewRequestForPath(store, rootCid, path, trustlessutils.DagScopeEntity, &byteRange)
+}
+
+// NewRequestForURL creates a new RetrievalRequest for content addressed in
+// any of the forms accepted by contentpath.Parse, such as
+// /ipfs/<cid>/path?dag-scope=entity, ipfs://<cid>/path or a path or subdomain
+// gateway URL. The dag-scope, entity-bytes, dups, protocols and providers
+// query parameters are applied to the request. /ipns/ names must be resolved
+// before calling this function. See NewRequestForPath for details of the
+// LinkSystem setup.
+func NewRequestForURL(store ipldstorage.WritableStorage, contentURL string) (RetrievalRequest, error) {
+	p, err := contentpath.Parse(contentURL)
+	if err != nil {
+		return RetrievalRequest{}, err
+	}
+	if p.Namespace != contentpath.NamespaceIPFS {
+		return RetrievalRequest{}, fmt.Errorf("%s must be resolved to an /ipfs/ path", p)
+	}
+	scope, err := p.DagScope()
+	if err != nil {
+		return RetrievalRequest{}, err
+	}
+	byteRange, err := p.EntityBytes()
+	if err != nil {
+		return RetrievalRequest{}, err
+	}
+	request, err := NewRequestForPath(store, p.Root, p.Path.String(), scope, byteRange)
+	if err != nil {
+		return RetrievalRequest{}, err
+	}
+	request.Duplicates = p.Duplicates()
+	if v := p.Query.Get("protocols"); v != "" {
+		if request.Protocols, err = ParseProtocolsString(v); err != nil {
+			return RetrievalRequest{}, err
+		}
+	}
+	if v := p.Query.Get("providers"); v != "" {
+		if request.FixedPeers, err = ParseProviderStrings(v); err != nil {
+			return RetrievalRequest{}, err
+		}
+	}
+	return request, nil
+}
+
+// ByteRangeFrom returns an open-ended byte range starting at the given offset
+// and continuing to the end of the file. A negative offset is counted back
+// from the end of the file.
+func ByteRangeFrom(from int64) trustlessutils.ByteRange {
+	return trustlessutils.ByteRange{From: from}
+}
+
+// ByteRangeBetween returns a byte range from the first offset up to and
+// including the second offset. Either offset may be negative, in which case it
+// is counted back from the end of the file.
+func ByteRangeBetween(from int64, to int64) trustlessutils.ByteRange {
+	return trustlessutils.ByteRange{From: from, To: &to}
+}
+
+// ByteRangeLast returns a byte range covering the final n bytes of a file. n
+// must be positive, since the last zero bytes can't be requested and a
+// negative count would be taken as an offset from the start of the file; an
+// error wrapping ErrInvalidByteRange is returned otherwise.
+func ByteRangeLast(n int64) (trustlessutils.ByteRange, error) {
+	if n <= 0 {
+		return trustlessutils.ByteRange{}, fmt.Errorf("%w: last %d bytes", ErrInvalidByteRange, n)
+	}
+	return trustlessutils.ByteRange{From: -n}, nil
+}
+
+// HasByteRange returns true if this request specifies a byte range other than
+// the default of the entire entity.
+func (r RetrievalRequest) HasByteRange() bool {
+	return !r.Bytes.IsDefault()
+}
+
+// ValidateByteRange checks that the byte range, if any, on this request is
+// usable. A byte range can't be combined with an explicit Selector and, where
+// both offsets are counted from the start of the file, the range must not end
+// before it starts.
+func (r RetrievalRequest) ValidateByteRange() error {
+	if !r.HasByteRange() {
+		return nil
+	}
+	if r.Selector != nil {
+		return ErrByteRangeWithSelector
+	}
+	if r.Bytes.To != nil && r.Bytes.From >= 0 && *r.Bytes.To >= 0 && *r.Bytes.To < r.Bytes.From {
+		return fmt.Errorf("%w: %s", ErrInvalidByteRange, r.Bytes.String())
+	}
+	return nil
+}
+
+// NewRequestForSelector creates a new RetrievalRequest for the given root CID
+// using a custom IPLD selector to describe the portion of the graph to fetch.
+// See NewRequestForPath for details of the LinkSystem setup and the
+// RetrievalRequest Selector field for how the selector is applied to the
+// different protocols.
+func NewRequestForSelector(
+	store ipldstorage.WritableStorage,
+	rootCid cid.Cid,
+	selector ipld.Node,
+) (RetrievalRequest, error) {
+	request, err := NewRequestForPath(store, rootCid, "", trustlessutils.DagScopeAll, nil)
+	if err != nil {
+		return RetrievalRequest{}, err
+	}
+	request.Selector = selector
+	return request, request.ValidateSelector()
+}
+
+// WithMaxDepth returns a copy of the request with a Selector that follows the
+// Path and then explores the DAG below it to at most the given depth, where
+// each link followed from the terminal of the Path is one level. A depth of 1
+// will fetch a directory and the root block of each of its entries without
+// the remaining blocks of those entries, e.g. the leaves of files.
+//
+// The depth limit can only be applied to a request with DagScopeAll, no byte
+// range and no existing Selector. It must be between 1 and
+// MaxSelectorRecursionDepth; DagScopeBlock should be used to fetch only the
+// terminal block of the Path.
+func (r RetrievalRequest) WithMaxDepth(depth uint64) (RetrievalRequest, error) {
+	if depth == 0 || depth > uint64(MaxSelectorRecursionDepth) {
+		return RetrievalRequest{}, fmt.Errorf("%w: must be between 1 and %d", ErrInvalidDepth, MaxSelectorRecursionDepth)
+	}
+	if r.Selector != nil {
+		return RetrievalRequest{}, fmt.Errorf("%w: can't be used with an explicit selector", ErrInvalidDepth)
+	}
+	if r.Scope != trustlessutils.DagScopeAll || r.HasByteRange() {
+		return RetrievalRequest{}, fmt.Errorf("%w: can only be used with dag-scope=all and no entity-bytes", ErrInvalidDepth)
+	}
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	terminal := ssb.ExploreRecursive(
+		selector.RecursionLimitDepth(int64(depth)),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
+	)
+	r.Selector = unixfsnode.UnixFSPathSelectorBuilder(r.Path, terminal, false)
+	return r, nil
+}
+
+// ValidateSelector checks that the custom Selector, if any, on this request is
+// a valid selector and that it is within the safety limits for recursion:
+// explicit recursion depth limits must not exceed MaxSelectorRecursionDepth
+// and the selector document must not be nested beyond MaxSelectorNesting.
+func (r RetrievalRequest) ValidateSelector() error {
+	if r.Selector == nil {
+		return nil
+	}
+	if _, err := selector.CompileSelector(r.Selector); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+	}
+	return checkSelectorLimits(r.Selector, 0)
+}
+
+func checkSelectorLimits(node datamodel.Node, nesting int) error {
+	if nesting > MaxSelectorNesting {
+		return fmt.Errorf("%w: exceeds maximum nesting of %d", ErrInvalidSelector, MaxSelectorNesting)
+	}
+	switch node.Kind() {
+	case datamodel.Kind_Map:
+		if limit, err := node.LookupByString(selector.SelectorKey_Limit); err == nil {
+			if depth, err := limit.LookupByString(selector.SelectorKey_LimitDepth); err == nil {
+				d, err := depth.AsInt()
+				if err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+				}
+				if d > MaxSelectorRecursionDepth {
+					return fmt.Errorf("%w: recursion depth %d exceeds maximum of %d", ErrInvalidSelector, d, MaxSelectorRecursionDepth)
+				}
+			}
+		}
+		itr := node.MapIterator()
+		for !itr.Done() {
+			_, v, err := itr.Next()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+			}
+			if err := checkSelectorLimits(v, nesting+1); err != nil {
+				return err
+			}
+		}
+	case datamodel.Kind_List:
+		itr := node.ListIterator()
+		for !itr.Done() {
+			_, v, err := itr.Next()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+			}
+			if err := checkSelectorLimits(v, nesting+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // GetSelector will safely return a selector for this request. If none has been
 // set, it will generate one for the path & scope.
 func (r RetrievalRequest) GetSelector() ipld.Node {
@@ -145,20 +509,26 @@ func (r RetrievalRequest) GetSelector() ipld.Node {
 // (nor safe) to use as an HTTP request. Instead, this should be used for
 // logging and other descriptive purposes.
 //
-// If this request uses an explicit Selector rather than a Path, an error will
-// be returned.
+// If this request uses an explicit Selector, the descriptor will contain the
+// dag-json form of the selector in place of the path, scope and byte range.
 func (r RetrievalRequest) GetDescriptorString() (string, error) {
+	var path, scopeAndRange string
 	if r.Selector != nil {
-		return "", errors.New("RetrievalRequest uses an explicit selector, can't generate a descriptor path for it")
-	}
-	scope := r.Scope
-	if r.Scope == "" {
-		scope = trustlessutils.DagScopeAll
-	}
-	path := trustlessutils.PathEscape(r.Path)
-	byteRange := ""
-	if !r.Bytes.IsDefault() {
-		byteRange = "&entity-bytes=" + r.Bytes.String()
+		sel, err := ipld.Encode(r.Selector, dagjson.Encode)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+		}
+		scopeAndRange = "selector=" + url.QueryEscape(string(sel))
+	} else {
+		scope := r.Scope
+		if r.Scope == "" {
+			scope = trustlessutils.DagScopeAll
+		}
+		path = trustlessutils.PathEscape(r.Path)
+		scopeAndRange = "dag-scope=" + string(scope)
+		if !r.Bytes.IsDefault() {
+			scopeAndRange += "&entity-bytes=" + r.Bytes.String()
+		}
 	}
 	dups := "y"
 	if !r.Duplicates {
@@ -168,6 +538,9 @@ func (r RetrievalRequest) GetDescriptorString() (string, error) {
 	if r.MaxBlocks > 0 {
 		blockLimit = fmt.Sprintf("&blockLimit=%d", r.MaxBlocks)
 	}
+	if r.MaxBytes > 0 {
+		blockLimit += fmt.Sprintf("&byteLimit=%d", r.MaxBytes)
+	}
 	var protocols string
 	if len(r.Protocols) > 0 {
 		var sb strings.Builder
@@ -188,10 +561,67 @@ func (r RetrievalRequest) GetDescriptorString() (string, error) {
 		}
 		providers = "&providers=" + ps
 	}
-	return fmt.Sprintf("/ipfs/%s%s?dag-scope=%s%s&dups=%s%s%s%s", r.Root.String(), path, scope, byteRange, dups, blockLimit, protocols, providers), nil
+	return fmt.Sprintf("/ipfs/%s%s?%s&dups=%s%s%s%s", r.Root.String(), path, scopeAndRange, dups, blockLimit, protocols, providers), nil
 
 }
 
+// CanonicalHash returns a hex encoded SHA-256 hash of the canonical form of
+// the parts of the request that determine the content retrieved: the root,
+// path, dag-scope, entity-bytes, duplicates and protocols, or the explicit
+// selector in place of the path, scope and byte range. Equivalent requests
+// hash to the same value regardless of the CID version of the root, how the
+// path is written, or the order of the protocols, so the hash can be used as
+// a cache key for the result of a retrieval. Limits, timeouts, providers and
+// the LinkPolicy are not included.
+func (r RetrievalRequest) CanonicalHash() (string, error) {
+	var sb strings.Builder
+	sb.WriteString("/ipfs/")
+	sb.WriteString(cid.NewCidV1(r.Root.Type(), r.Root.Hash()).String())
+	if r.Selector != nil {
+		sel, err := ipld.Encode(r.Selector, dagjson.Encode)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+		}
+		sb.WriteString("?selector=")
+		sb.WriteString(url.QueryEscape(string(sel)))
+	} else {
+		if path := datamodel.ParsePath(r.Path); path.Len() > 0 {
+			sb.WriteString("/")
+			sb.WriteString(trustlessutils.PathEscape(path.String()))
+		}
+		scope := r.Scope
+		if scope == "" {
+			scope = trustlessutils.DagScopeAll
+		}
+		sb.WriteString("?dag-scope=")
+		sb.WriteString(string(scope))
+		if !r.Bytes.IsDefault() {
+			sb.WriteString("&entity-bytes=")
+			sb.WriteString(r.Bytes.String())
+		}
+	}
+	if r.Duplicates {
+		sb.WriteString("&dups=y")
+	} else {
+		sb.WriteString("&dups=n")
+	}
+	if len(r.Protocols) > 0 {
+		protocols := make([]string, 0, len(r.Protocols))
+		seen := make(map[multicodec.Code]struct{}, len(r.Protocols))
+		for _, protocol := range r.Protocols {
+			if _, ok := seen[protocol]; !ok {
+				seen[protocol] = struct{}{}
+				protocols = append(protocols, protocol.String())
+			}
+		}
+		sort.Strings(protocols)
+		sb.WriteString("&protocols=")
+		sb.WriteString(strings.Join(protocols, ","))
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:]), nil
+}
+
 // GetSupportedProtocols will safely return the supported protocols for a specific request.
 // It takes a list of all supported protocols, and
 // -- if the request has protocols, it will return all the request protocols that are in the supported list
@@ -212,6 +642,18 @@ func (r RetrievalRequest) GetSupportedProtocols(allSupportedProtocols []multicod
 	return supportedProtocols
 }
 
+// IsAcceptableProvider returns true if the provider is allowed by the
+// request's ProviderAllowList and ProviderBlockList.
+func (r RetrievalRequest) IsAcceptableProvider(id peer.ID) bool {
+	if r.ProviderBlockList[id] {
+		return false
+	}
+	if len(r.ProviderAllowList) > 0 && !r.ProviderAllowList[id] {
+		return false
+	}
+	return true
+}
+
 func (r RetrievalRequest) HasPreloadLinkSystem() bool {
 	return r.PreloadLinkSystem.StorageReadOpener != nil && r.PreloadLinkSystem.StorageWriteOpener != nil
 }
@@ -236,6 +678,36 @@ func ParseProtocolsString(v string) ([]multicodec.Code, error) {
 	return protocols, nil
 }
 
+// ParseDigestString parses the digest of a file, see WithExpectedDigest, given
+// as the name of its hash function and its hex encoding, separated by a colon,
+// such as "sha2-256:9f86d0...". The hash function is named as it is in the
+// multihash table, with "sha256" accepted for "sha2-256".
+func ParseDigestString(v string) (multihash.Multihash, error) {
+	name, digestHex, ok := strings.Cut(v, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid digest %q, expected <hash function>:<hex digest>", v)
+	}
+	if name == "sha256" {
+		name = "sha2-256"
+	}
+	code, ok := multihash.Names[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid digest, unknown hash function: %s", name)
+	}
+	digest, err := hex.DecodeString(digestHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest, not hex: %w", err)
+	}
+	hasher, err := multihash.GetHasher(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest: %w", err)
+	}
+	if len(digest) != hasher.Size() {
+		return nil, fmt.Errorf("invalid digest, %s digests are %d bytes, got %d", name, hasher.Size(), len(digest))
+	}
+	return multihash.Encode(digest, code)
+}
+
 func ParseProviderStrings(v string) ([]peer.AddrInfo, error) {
 	vs := strings.Split(v, ",")
 	providerAddrInfos := make([]peer.AddrInfo, 0, len(vs))
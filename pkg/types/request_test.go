@@ -1,11 +1,19 @@
 package types
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -202,6 +210,422 @@ func TestRequestStringRepresentations(t *testing.T) {
 	})
 }
 
+func TestByteRangeHelpers(t *testing.T) {
+	last := func(n int64) trustlessutils.ByteRange {
+		byteRange, err := ByteRangeLast(n)
+		require.NoError(t, err)
+		return byteRange
+	}
+	testCases := []struct {
+		name               string
+		byteRange          trustlessutils.ByteRange
+		expectedDescriptor string
+		expectErr          error
+	}{
+		{
+			name:               "from",
+			byteRange:          ByteRangeFrom(100),
+			expectedDescriptor: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/file?dag-scope=entity&entity-bytes=100:*&dups=n",
+		},
+		{
+			name:               "between",
+			byteRange:          ByteRangeBetween(100, 200),
+			expectedDescriptor: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/file?dag-scope=entity&entity-bytes=100:200&dups=n",
+		},
+		{
+			name:               "between, negative end",
+			byteRange:          ByteRangeBetween(100, -200),
+			expectedDescriptor: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/file?dag-scope=entity&entity-bytes=100:-200&dups=n",
+		},
+		{
+			name:               "last",
+			byteRange:          last(1024),
+			expectedDescriptor: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/file?dag-scope=entity&entity-bytes=-1024:*&dups=n",
+		},
+		{
+			name:      "between, inverted",
+			byteRange: ByteRangeBetween(200, 100),
+			expectErr: ErrInvalidByteRange,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := NewRequestForByteRange(nil, testCidV1, "file", tc.byteRange)
+			require.NoError(t, err)
+			require.True(t, request.HasByteRange())
+			require.Equal(t, trustlessutils.DagScopeEntity, request.Scope)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, request.ValidateByteRange(), tc.expectErr)
+				return
+			}
+			require.NoError(t, request.ValidateByteRange())
+			descriptor, err := request.GetDescriptorString()
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedDescriptor, descriptor)
+		})
+	}
+
+	t.Run("last, not positive", func(t *testing.T) {
+		for _, n := range []int64{0, -1} {
+			_, err := ByteRangeLast(n)
+			require.ErrorIs(t, err, ErrInvalidByteRange)
+		}
+	})
+
+	t.Run("with selector", func(t *testing.T) {
+		request, err := NewRequestForByteRange(nil, testCidV1, "", ByteRangeFrom(1))
+		require.NoError(t, err)
+		request.Selector = request.GetSelector()
+		require.ErrorIs(t, request.ValidateByteRange(), ErrByteRangeWithSelector)
+	})
+}
+
+func TestNewRequestForURL(t *testing.T) {
+	const descriptor = "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/a/b?dag-scope=entity&entity-bytes=0:99&dups=y&protocols=transport-bitswap"
+	testCases := []struct {
+		name               string
+		url                string
+		expectedDescriptor string
+		expectErr          bool
+	}{
+		{
+			name:               "content path",
+			url:                "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/a/b?dag-scope=entity&entity-bytes=0:99&dups=y&protocols=bitswap",
+			expectedDescriptor: descriptor,
+		},
+		{
+			name:               "native URL",
+			url:                "ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/a/b?dag-scope=entity&entity-bytes=0:99&dups=y&protocols=bitswap",
+			expectedDescriptor: descriptor,
+		},
+		{
+			name:               "path gateway URL",
+			url:                "https://ipfs.io/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/a/b?dag-scope=entity&entity-bytes=0:99&dups=y&protocols=bitswap",
+			expectedDescriptor: descriptor,
+		},
+		{
+			name:               "subdomain gateway URL",
+			url:                "https://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi.ipfs.dweb.link/a/b?dag-scope=entity&entity-bytes=0:99&dups=y&protocols=bitswap",
+			expectedDescriptor: descriptor,
+		},
+		{
+			name:               "bare CID",
+			url:                "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
+			expectedDescriptor: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?dag-scope=all&dups=n",
+		},
+		{
+			name:               "providers",
+			url:                "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?providers=/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+			expectedDescriptor: "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?dag-scope=all&dups=n&providers=/ip4/127.0.0.1/tcp/5000/p2p/12D3KooWBSTEYMLSu5FnQjshEVah9LFGEZoQt26eacCEVYfedWA4",
+		},
+		{
+			name:      "unresolved ipns",
+			url:       "/ipns/docs.ipfs.tech",
+			expectErr: true,
+		},
+		{
+			name:      "bad dag-scope",
+			url:       "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?dag-scope=nope",
+			expectErr: true,
+		},
+		{
+			name:      "bad protocols",
+			url:       "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?protocols=nope",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := NewRequestForURL(nil, tc.url)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			descriptor, err := request.GetDescriptorString()
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedDescriptor, descriptor)
+		})
+	}
+}
+
+func TestSelectorValidation(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	recurse := func(limit selector.RecursionLimit) datamodel.Node {
+		return ssb.ExploreRecursive(limit, ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+	}
+	nested := ssb.Matcher()
+	for i := 0; i < MaxSelectorNesting; i++ {
+		nested = ssb.ExploreIndex(0, nested)
+	}
+
+	testCases := []struct {
+		name      string
+		selector  datamodel.Node
+		expectErr bool
+	}{
+		{
+			name:     "depth limited recursion",
+			selector: recurse(selector.RecursionLimitDepth(10)),
+		},
+		{
+			name:     "unlimited recursion",
+			selector: recurse(selector.RecursionLimitNone()),
+		},
+		{
+			name:      "excessive recursion depth",
+			selector:  recurse(selector.RecursionLimitDepth(MaxSelectorRecursionDepth + 1)),
+			expectErr: true,
+		},
+		{
+			name:      "excessive nesting",
+			selector:  nested.Node(),
+			expectErr: true,
+		},
+		{
+			name:      "not a selector",
+			selector:  basicnode.NewString("nope"),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := NewRequestForSelector(nil, testCidV1, tc.selector)
+			if tc.expectErr {
+				require.ErrorIs(t, err, ErrInvalidSelector)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.selector, request.GetSelector())
+		})
+	}
+
+	t.Run("descriptor", func(t *testing.T) {
+		request, err := NewRequestForSelector(nil, testCidV1, recurse(selector.RecursionLimitDepth(10)))
+		require.NoError(t, err)
+		descriptor, err := request.GetDescriptorString()
+		require.NoError(t, err)
+		require.Equal(t, "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?selector=%7B%22R%22%3A%7B%22%3A%3E%22%3A%7B%22a%22%3A%7B%22%3E%22%3A%7B%22%40%22%3A%7B%7D%7D%7D%7D%2C%22l%22%3A%7B%22depth%22%3A10%7D%7D%7D&dups=n", descriptor)
+	})
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	byteRange := ByteRangeFrom(10)
+
+	testCases := []struct {
+		name         string
+		path         string
+		scope        trustlessutils.DagScope
+		byteRange    *trustlessutils.ByteRange
+		depth        uint64
+		expectErr    bool
+		expectSelect datamodel.Node
+	}{
+		{
+			name:         "root",
+			scope:        trustlessutils.DagScopeAll,
+			depth:        1,
+			expectSelect: ssb.ExploreRecursive(selector.RecursionLimitDepth(1), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node(),
+		},
+		{
+			name:  "path",
+			path:  "some/path",
+			scope: trustlessutils.DagScopeAll,
+			depth: 2,
+			expectSelect: unixfsnode.UnixFSPathSelectorBuilder("some/path",
+				ssb.ExploreRecursive(selector.RecursionLimitDepth(2), ssb.ExploreAll(ssb.ExploreRecursiveEdge())), false),
+		},
+		{
+			name:      "zero depth",
+			scope:     trustlessutils.DagScopeAll,
+			depth:     0,
+			expectErr: true,
+		},
+		{
+			name:      "excessive depth",
+			scope:     trustlessutils.DagScopeAll,
+			depth:     uint64(MaxSelectorRecursionDepth) + 1,
+			expectErr: true,
+		},
+		{
+			name:      "entity scope",
+			scope:     trustlessutils.DagScopeEntity,
+			depth:     1,
+			expectErr: true,
+		},
+		{
+			name:      "byte range",
+			scope:     trustlessutils.DagScopeAll,
+			byteRange: &byteRange,
+			depth:     1,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := NewRequestForPath(nil, testCidV1, tc.path, tc.scope, tc.byteRange)
+			require.NoError(t, err)
+			request, err = request.WithMaxDepth(tc.depth)
+			if tc.expectErr {
+				require.ErrorIs(t, err, ErrInvalidDepth)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.path, request.Path)
+			require.Equal(t, tc.expectSelect, request.GetSelector())
+			require.NoError(t, request.ValidateSelector())
+		})
+	}
+
+	t.Run("explicit selector", func(t *testing.T) {
+		request, err := NewRequestForSelector(nil, testCidV1, ssb.Matcher().Node())
+		require.NoError(t, err)
+		_, err = request.WithMaxDepth(1)
+		require.ErrorIs(t, err, ErrInvalidDepth)
+	})
+}
+
+func TestCanonicalHash(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	byteRange := ByteRangeFrom(10)
+	base := RetrievalRequest{
+		Request: trustlessutils.Request{
+			Root:       testCidV0,
+			Path:       "some/path",
+			Scope:      trustlessutils.DagScopeAll,
+			Duplicates: true,
+		},
+		Protocols: []multicodec.Code{multicodec.TransportIpfsGatewayHttp, multicodec.TransportBitswap},
+	}
+
+	testCases := []struct {
+		name        string
+		modify      func(r RetrievalRequest) RetrievalRequest
+		expectEqual bool
+	}{
+		{
+			name: "CIDv1 root",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Root = cid.NewCidV1(cid.DagProtobuf, testCidV0.Hash())
+				return r
+			},
+			expectEqual: true,
+		},
+		{
+			name: "path with extra slashes",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Path = "/some/path/"
+				return r
+			},
+			expectEqual: true,
+		},
+		{
+			name: "default scope",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Scope = ""
+				return r
+			},
+			expectEqual: true,
+		},
+		{
+			name: "protocols reordered and repeated",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Protocols = []multicodec.Code{multicodec.TransportBitswap, multicodec.TransportIpfsGatewayHttp, multicodec.TransportBitswap}
+				return r
+			},
+			expectEqual: true,
+		},
+		{
+			name: "limits and providers",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.MaxBlocks = 10
+				r.MaxBytes = 1000
+				r.FixedPeers = []peer.AddrInfo{{ID: peer.ID("A")}}
+				return r
+			},
+			expectEqual: true,
+		},
+		{
+			name: "different root",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Root = testCidV1
+				return r
+			},
+		},
+		{
+			name: "different path",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Path = "some/other/path"
+				return r
+			},
+		},
+		{
+			name: "different scope",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Scope = trustlessutils.DagScopeEntity
+				return r
+			},
+		},
+		{
+			name: "byte range",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Scope = trustlessutils.DagScopeEntity
+				r.Bytes = &byteRange
+				return r
+			},
+		},
+		{
+			name: "no duplicates",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Duplicates = false
+				return r
+			},
+		},
+		{
+			name: "different protocols",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Protocols = []multicodec.Code{multicodec.TransportBitswap}
+				return r
+			},
+		},
+		{
+			name: "no protocols",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Protocols = nil
+				return r
+			},
+		},
+		{
+			name: "explicit selector",
+			modify: func(r RetrievalRequest) RetrievalRequest {
+				r.Selector = ssb.Matcher().Node()
+				return r
+			},
+		},
+	}
+
+	baseHash, err := base.CanonicalHash()
+	require.NoError(t, err)
+	require.Len(t, baseHash, 64)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, err := tc.modify(base).CanonicalHash()
+			require.NoError(t, err)
+			if tc.expectEqual {
+				require.Equal(t, baseHash, hash)
+			} else {
+				require.NotEqual(t, baseHash, hash)
+			}
+		})
+	}
+}
+
 func TestProviderStrings(t *testing.T) {
 	testCases := []struct {
 		name        string
@@ -259,6 +683,67 @@ func TestProviderStrings(t *testing.T) {
 	}
 }
 
+func TestDigestString(t *testing.T) {
+	sha256Hex := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	testCases := []struct {
+		name       string
+		input      string
+		expectCode uint64
+		expectErr  string
+	}{
+		{
+			name:       "sha2-256",
+			input:      "sha2-256:" + sha256Hex,
+			expectCode: multihash.SHA2_256,
+		},
+		{
+			name:       "sha256",
+			input:      "sha256:" + sha256Hex,
+			expectCode: multihash.SHA2_256,
+		},
+		{
+			name:       "blake3",
+			input:      "blake3:" + sha256Hex,
+			expectCode: multihash.BLAKE3,
+		},
+		{
+			name:      "no hash function",
+			input:     sha256Hex,
+			expectErr: "expected <hash function>:<hex digest>",
+		},
+		{
+			name:      "unknown hash function",
+			input:     "crc32:" + sha256Hex,
+			expectErr: "unknown hash function: crc32",
+		},
+		{
+			name:      "not hex",
+			input:     "sha2-256:not-hex",
+			expectErr: "not hex",
+		},
+		{
+			name:      "wrong length",
+			input:     "sha2-256:" + sha256Hex[:32],
+			expectErr: "sha2-256 digests are 32 bytes, got 16",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := ParseDigestString(tc.input)
+			if tc.expectErr != "" {
+				require.ErrorContains(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			decoded, err := multihash.Decode(parsed)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectCode, decoded.Code)
+			require.Equal(t, sha256Hex, hex.EncodeToString(decoded.Digest))
+		})
+	}
+}
+
 func TestUnknownPeerID(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		p := nextUnknownPeerID()
@@ -9,10 +9,13 @@ import (
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipni/go-libipni/maurl"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
 type Fetcher interface {
@@ -21,6 +24,84 @@ type Fetcher interface {
 
 type FetchConfig struct {
 	EventsCallback func(RetrievalEvent)
+	// MaxDepth limits the depth of the traversal below the terminal of the
+	// request's Path, see RetrievalRequest#WithMaxDepth. Zero means no limit.
+	MaxDepth uint64
+	// MaxBlocks and MaxBytes set a budget for the retrieval, overriding the
+	// request's MaxBlocks and MaxBytes where they are lower. Zero means no
+	// limit.
+	MaxBlocks uint64
+	MaxBytes  uint64
+	// MaxDuration sets an elapsed time budget for the retrieval, overriding
+	// the request's MaxDuration where it is lower. Zero means no limit.
+	MaxDuration time.Duration
+	// ProviderTimeout and GlobalTimeout, if set, override the instance's
+	// configured timeouts for this retrieval.
+	ProviderTimeout time.Duration
+	GlobalTimeout   time.Duration
+	// MaxAttempts, if set, limits the number of distinct providers the
+	// retrieval attempts, see RetrievalRequest#MaxAttempts.
+	MaxAttempts uint
+	// Protocols, if set, replaces the request's Protocols, restricting the
+	// retrieval to the given protocols.
+	Protocols []multicodec.Code
+	// LinkPolicy, if set, is consulted for each link of the retrieval, see
+	// RetrievalRequest#LinkPolicy.
+	LinkPolicy LinkPolicy
+	// PauseControl, if set, is used to pause and resume the retrieval, see
+	// RetrievalRequest#PauseControl.
+	PauseControl *PauseControl
+	// Progress, if set, is called with updates on the progress of the
+	// retrieval, see WithProgress.
+	Progress func(ProgressUpdate)
+	// ProviderAllowList and ProviderBlockList, if set, restrict the providers
+	// used for this retrieval, see RetrievalRequest#ProviderAllowList.
+	ProviderAllowList map[peer.ID]bool
+	ProviderBlockList map[peer.ID]bool
+	// BatchConcurrency limits the number of retrievals of a batch, see
+	// Lassie#FetchAll, that run at once. Zero means the default limit. It is
+	// ignored by single retrievals.
+	BatchConcurrency int
+	// NestedCars, if set, expands the CAR files found in the retrieved DAG,
+	// see WithNestedCars.
+	NestedCars *NestedCarConfig
+	// ExpectedDigest, if set, is the digest the bytes of the retrieved UnixFS
+	// file must hash to, see WithExpectedDigest.
+	ExpectedDigest multihash.Multihash
+	// Subscribers receive the events of this retrieval only, see
+	// WithSubscriber.
+	Subscribers []RetrievalEventSubscriber
+	// AffinityKey, if set, replaces the request's AffinityKey, see
+	// RetrievalRequest#AffinityKey.
+	AffinityKey string
+	// TempStore, if set, replaces the instance's temporary storage for this
+	// retrieval, see WithTempStore.
+	TempStore TempStoreFactory
+}
+
+const (
+	DefaultNestedCarMaxDepth = 1
+	DefaultNestedCarMaxBytes = 256 << 20
+)
+
+// NestedCarConfig bounds the expansion of nested CARs. MaxDepth is the number
+// of levels of CARs within CARs that are expanded, and MaxBytes the size of
+// the largest CAR that is expanded, larger CARs are left as they are. Zero
+// values take the defaults.
+type NestedCarConfig struct {
+	MaxDepth int
+	MaxBytes uint64
+}
+
+// WithDefaults returns the config with zero values replaced by the defaults.
+func (cfg NestedCarConfig) WithDefaults() NestedCarConfig {
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = DefaultNestedCarMaxDepth
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultNestedCarMaxBytes
+	}
+	return cfg
 }
 
 type FetchOption func(cfg *FetchConfig)
@@ -33,6 +114,200 @@ func WithEventsCallback(callback func(RetrievalEvent)) FetchOption {
 	}
 }
 
+// WithSubscriber attaches a subscriber that receives only the events of this
+// retrieval, and is unregistered once it has received the last of them. Like
+// a subscriber registered with the Lassie instance, and unlike the callback
+// set with WithEventsCallback, it is called asynchronously, so it may receive
+// events after Fetch has returned, and more than one may be attached.
+func WithSubscriber(subscriber RetrievalEventSubscriber) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.Subscribers = append(cfg.Subscribers, subscriber)
+	}
+}
+
+// WithAffinityKey sets a key, such as a dataset ID, shared by sequential
+// retrievals that should prefer the providers that served the earlier ones,
+// see RetrievalRequest#AffinityKey.
+func WithAffinityKey(key string) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.AffinityKey = key
+	}
+}
+
+// WithMaxDepth bounds the depth of the traversal below the terminal of the
+// request's Path, where each link followed is one level. A depth of 1 fetches
+// the terminal node, e.g. a directory, and the root blocks of its immediate
+// children. See RetrievalRequest#WithMaxDepth for the restrictions on the
+// requests it can be applied to.
+func WithMaxDepth(depth uint64) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.MaxDepth = depth
+	}
+}
+
+// WithMaxBlocks sets the maximum number of blocks to fetch. Once the budget is
+// reached the retrieval ends early and the returned RetrievalStats are marked
+// as Partial.
+func WithMaxBlocks(maxBlocks uint64) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.MaxBlocks = maxBlocks
+	}
+}
+
+// WithMaxBytes sets the maximum number of bytes of block data to fetch. A
+// block that would exceed the budget is not stored, the retrieval ends early
+// and the returned RetrievalStats are marked as Partial.
+func WithMaxBytes(maxBytes uint64) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.MaxBytes = maxBytes
+	}
+}
+
+// WithMaxDuration sets how long the retrieval may spend fetching blocks, see
+// RetrievalRequest#MaxDuration. Unlike WithGlobalTimeout, once the duration
+// has elapsed the retrieval ends with the blocks fetched so far, and the
+// returned RetrievalStats are marked as Partial, rather than failing.
+func WithMaxDuration(maxDuration time.Duration) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.MaxDuration = maxDuration
+	}
+}
+
+// WithProviderTimeout overrides the instance's timeout for retrieving from
+// each provider for this retrieval, see RetrievalRequest#ProviderTimeout.
+func WithProviderTimeout(timeout time.Duration) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.ProviderTimeout = timeout
+	}
+}
+
+// WithGlobalTimeout overrides the instance's timeout for the entire
+// retrieval, including finding candidates and retrieving from them.
+func WithGlobalTimeout(timeout time.Duration) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.GlobalTimeout = timeout
+	}
+}
+
+// WithMaxAttempts limits the number of distinct providers the retrieval
+// attempts before failing, independent of any timeouts, see
+// RetrievalRequest#MaxAttempts. Together with a provider timeout, this bounds
+// the worst-case duration of a retrieval for latency-sensitive callers.
+func WithMaxAttempts(maxAttempts uint) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.MaxAttempts = maxAttempts
+	}
+}
+
+// WithProtocols restricts the retrieval to the given protocols, overriding
+// the request's Protocols. Protocols that aren't enabled for the Lassie
+// instance are ignored; if none are enabled the Fetch fails. Retrievals over
+// the given protocols are raced against each other, so their order doesn't
+// imply a preference.
+func WithProtocols(protocols ...multicodec.Code) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.Protocols = protocols
+	}
+}
+
+// WithLinkPolicy sets a LinkPolicy that is consulted for each link of the
+// retrieval, allowing individual links to be skipped or the retrieval to be
+// aborted. See LinkPolicy for the guarantees each transport provides.
+func WithLinkPolicy(policy LinkPolicy) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.LinkPolicy = policy
+	}
+}
+
+// WithPauseControl sets a PauseControl that can be used to pause and resume
+// the retrieval while it is in progress.
+func WithPauseControl(pc *PauseControl) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.PauseControl = pc
+	}
+}
+
+// WithProgress sets a callback that is called with updates on the progress of
+// the retrieval: when it moves to a new phase, as blocks are verified, at most
+// every ProgressInterval, and once more when it has finished. The callback is
+// not called concurrently, so it should return quickly to avoid holding up the
+// retrieval.
+func WithProgress(callback func(ProgressUpdate)) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.Progress = callback
+	}
+}
+
+// WithProviderAllowList restricts the retrieval to the given providers, in
+// addition to any allow list Lassie is configured with.
+func WithProviderAllowList(providers ...peer.ID) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.ProviderAllowList = peerSet(providers)
+	}
+}
+
+// WithProviderBlockList prevents the given providers from being used for the
+// retrieval, in addition to any block list Lassie is configured with.
+func WithProviderBlockList(providers ...peer.ID) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.ProviderBlockList = peerSet(providers)
+	}
+}
+
+// WithBatchConcurrency limits the number of retrievals of a batch that run at
+// once.
+func WithBatchConcurrency(concurrency int) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.BatchConcurrency = concurrency
+	}
+}
+
+// WithNestedCars expands the CAR files found among the files and raw leaves of
+// the retrieved DAG, for datasets packaged as CARs within a DAG. Once the
+// retrieval succeeds, each CAR within the bounds of cfg is verified, checking
+// every block against its CID and that the DAGs under its roots are complete,
+// and its blocks are written to the request's LinkSystem, which must be
+// readable. CARs within expanded CARs are expanded in turn, up to
+// cfg.MaxDepth. A CAR that fails verification fails the retrieval.
+func WithNestedCars(cfg NestedCarConfig) FetchOption {
+	return func(fetchCfg *FetchConfig) {
+		cfg := cfg.WithDefaults()
+		fetchCfg.NestedCars = &cfg
+	}
+}
+
+// WithExpectedDigest verifies the UnixFS file at the terminal of the request's
+// Path against a digest of its bytes, such as a sha2-256 or blake3 digest
+// published alongside the file, see ParseDigestString. Every block is already
+// verified against its CID; this gives end-to-end assurance that the DAG is
+// the one for the expected file. Once the retrieval succeeds the file is read
+// back from the request's LinkSystem, which must be readable, and hashed. The
+// request must be for the whole file, and a file that doesn't match the
+// digest, or a partial retrieval, fails the retrieval.
+func WithExpectedDigest(digest multihash.Multihash) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.ExpectedDigest = digest
+	}
+}
+
+// WithTempStore sets the storage that FetchToWriter, FetchIntoBlockstore,
+// FetchBlocks and FetchNodes use to hold blocks temporarily during this
+// retrieval, replacing the instance's, see lassie.WithTempStore. It has no
+// effect on Fetch, whose request carries its own storage.
+func WithTempStore(factory TempStoreFactory) FetchOption {
+	return func(cfg *FetchConfig) {
+		cfg.TempStore = factory
+	}
+}
+
+func peerSet(peers []peer.ID) map[peer.ID]bool {
+	set := make(map[peer.ID]bool, len(peers))
+	for _, p := range peers {
+		set[p] = true
+	}
+	return set
+}
+
 // NewFetchConfig creates a new FetchConfig with the given options.
 func NewFetchConfig(opts ...FetchOption) FetchConfig {
 	cfg := FetchConfig{
@@ -170,6 +445,106 @@ type RetrievalStats struct {
 	AskPrice          abi.TokenAmount
 	TimeToFirstByte   time.Duration
 	Selector          string
+	// Partial is true when the retrieval was ended early because the
+	// request's MaxBlocks, MaxBytes or MaxDuration budget was reached; only
+	// the blocks within the budget were fetched.
+	Partial bool
+	// IndexerQueries, GraphsyncQueries and HttpQueries count the candidate
+	// finder lookups, and the providers queried over Graphsync and HTTP, for
+	// the retrieval.
+	IndexerQueries   uint64
+	GraphsyncQueries uint64
+	HttpQueries      uint64
+	// NestedCars counts the nested CARs expanded, see WithNestedCars.
+	NestedCars uint64
+	// Prewarm describes the warming up of the connection to an HTTP provider
+	// before retrieving from it, if it was warmed up.
+	Prewarm *PrewarmStats
+	// AffinityHit is true when the request had an AffinityKey and was served
+	// by a provider that had served an earlier retrieval with the same key.
+	AffinityHit bool
+	// BlockCacheHit is true when every block of the retrieval was found in the
+	// instance's block cache, see lassie.WithBlockCache, and it was served
+	// without contacting any provider.
+	BlockCacheHit bool
+	// RequestHash is the RetrievalRequest#CanonicalHash of the request, which
+	// identifies the content retrieved and may be used to cache the result.
+	RequestHash string
+}
+
+// PrewarmStats describes the small requests made to an HTTP provider to warm
+// up connections to it before a retrieval, see retriever.HttpPrewarm. The
+// retrieval's Duration, TimeToFirstByte and AverageSpeed exclude the time
+// spent prewarming, which is given by Duration, so the effect on throughput
+// can be compared against retrievals that weren't warmed up.
+type PrewarmStats struct {
+	// Requests is the number of requests that completed successfully.
+	Requests int
+	// Bytes is the number of bytes received in response to the requests.
+	Bytes uint64
+	// Duration is the time spent prewarming.
+	Duration time.Duration
+	// Latency is the provider's latest time to first byte, which made it
+	// eligible for prewarming, or zero if it wasn't known.
+	Latency time.Duration
+}
+
+// RetrievedBlock is a block that has been verified as part of a retrieval.
+type RetrievedBlock struct {
+	Cid  cid.Cid
+	Data []byte
+	// Path is the path, from the root of the retrieval, at which the block
+	// was reached in the traversal.
+	Path datamodel.Path
+}
+
+// ProgressInterval is the minimum interval between progress updates for
+// verified blocks, see WithProgress.
+const ProgressInterval = 100 * time.Millisecond
+
+// ProgressPhase is the phase a retrieval is in, as reported by a
+// ProgressUpdate.
+type ProgressPhase string
+
+const (
+	// ProgressFindingCandidates is the phase before any provider is tried,
+	// while candidates are looked up.
+	ProgressFindingCandidates ProgressPhase = "finding-candidates"
+	// ProgressConnecting is the phase once providers are being tried, before
+	// any data has been received.
+	ProgressConnecting ProgressPhase = "connecting"
+	// ProgressTransferring is the phase once data is being received.
+	ProgressTransferring ProgressPhase = "transferring"
+	// ProgressFinished is the final phase, reported once when the retrieval
+	// has succeeded or failed.
+	ProgressFinished ProgressPhase = "finished"
+)
+
+// ProgressUpdate describes the progress of a retrieval, see WithProgress.
+type ProgressUpdate struct {
+	RetrievalID RetrievalID
+	Phase       ProgressPhase
+	// BytesReceived is the number of bytes received from providers, which may
+	// include blocks that are later discarded, such as duplicates.
+	BytesReceived uint64
+	// BlocksVerified and BytesVerified count the blocks that have been
+	// verified and stored.
+	BlocksVerified uint64
+	BytesVerified  uint64
+	// Provider and Protocol identify where the most recent data came from;
+	// Provider is empty for Bitswap when the sending peer isn't known.
+	Provider peer.ID
+	Protocol multicodec.Code
+	// Elapsed is the time since the retrieval started.
+	Elapsed time.Duration
+	// ETA is an estimate of the time remaining, based on the rate that data
+	// has been verified so far. It is only available when the size of the
+	// retrieval is bounded in advance, by an entity-bytes range or a MaxBytes
+	// budget, and is zero otherwise.
+	ETA time.Duration
+	// Err is the error the retrieval failed with, in the ProgressFinished
+	// phase.
+	Err error
 }
 
 type RetrievalResult struct {
@@ -224,6 +599,10 @@ type EventCode string
 const (
 	CandidatesFoundCode          EventCode = "candidates-found"
 	CandidatesFilteredCode       EventCode = "candidates-filtered"
+	CandidateSkippedCode         EventCode = "candidate-skipped"
+	CircuitBreakerOpenedCode     EventCode = "circuit-breaker-opened"
+	CircuitBreakerClosedCode     EventCode = "circuit-breaker-closed"
+	ProviderAlertCode            EventCode = "provider-alert"
 	StartedCode                  EventCode = "started"
 	StartedFetchCode             EventCode = "started-fetch"
 	StartedFindingCandidatesCode EventCode = "started-finding-candidates"
@@ -239,6 +618,8 @@ const (
 	SuccessCode                  EventCode = "success"
 	FinishedCode                 EventCode = "finished"
 	BlockReceivedCode            EventCode = "block-received"
+	SwarmTelemetryCode           EventCode = "swarm-telemetry"
+	NameResolvedCode             EventCode = "name-resolved"
 )
 
 type RetrievalEvent interface {
@@ -0,0 +1,233 @@
+package unixfsextract
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipldstorage "github.com/ipld/go-ipld-prime/storage"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multihash"
+)
+
+// FileStream writes the bytes of a UnixFS file to a writer as its blocks
+// become available in a store, such as while the file is being retrieved into
+// it. Each call to Advance writes as much of the file as the blocks present
+// allow, in order, and the blocks of the file that lie wholly outside of the
+// byte range aren't needed.
+type FileStream struct {
+	e         *extractor
+	store     ipldstorage.ReadableStorage
+	w         io.Writer
+	byteRange *trustlessutils.ByteRange
+
+	// from and to are the offsets of the byte range, to is exclusive, known
+	// once the root of the file is loaded
+	from, to uint64
+	rooted   bool
+	next     *fileLink
+	stack    []*fileFrame
+	written  uint64
+	done     bool
+}
+
+// fileLink is a block of a file and the offset of its bytes in the file.
+type fileLink struct {
+	cid    cid.Cid
+	offset uint64
+}
+
+// fileFrame is a file node whose children are being written.
+type fileFrame struct {
+	links  []dagpb.PBLink
+	sizes  []uint64
+	index  int
+	offset uint64
+}
+
+// NewFileStream creates a FileStream for the UnixFS file at root, writing the
+// bytes of byteRange, or of the whole file if it's nil, to w. The range is
+// that of the entity-bytes parameter of a trustless request, which may count
+// from the end of the file. Blocks are checked against their CID as they're
+// loaded from store.
+func NewFileStream(ctx context.Context, store ipldstorage.ReadableStorage, root cid.Cid, byteRange *trustlessutils.ByteRange, w io.Writer) *FileStream {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	return &FileStream{
+		e:         &extractor{ctx: ctx, lsys: lsys},
+		store:     store,
+		w:         w,
+		byteRange: byteRange,
+		next:      &fileLink{cid: root},
+	}
+}
+
+// Advance writes the bytes of the file up to the first block that's needed but
+// not yet in the store. It returns an error if the file can't be written, such
+// as when root isn't a UnixFS file, in which case ErrNotFile is returned.
+func (fs *FileStream) Advance() error {
+	for !fs.done {
+		if fs.next == nil {
+			if fs.next = fs.nextLink(); fs.next == nil {
+				fs.done = true
+				break
+			}
+		}
+		if fs.next.cid.Prefix().MhType != multihash.IDENTITY {
+			if has, err := fs.store.Has(fs.e.ctx, cidlink.Link{Cid: fs.next.cid}.Binary()); err != nil {
+				return err
+			} else if !has {
+				return nil
+			}
+		}
+		if err := fs.write(*fs.next); err != nil {
+			return err
+		}
+		fs.next = nil
+	}
+	return nil
+}
+
+// Done returns true once every byte of the file, or of the range, has been
+// written.
+func (fs *FileStream) Done() bool {
+	return fs.done
+}
+
+// Written returns the number of bytes written so far.
+func (fs *FileStream) Written() uint64 {
+	return fs.written
+}
+
+// nextLink returns the next block of the file with bytes in the range, or nil
+// if there are no more.
+func (fs *FileStream) nextLink() *fileLink {
+	for len(fs.stack) > 0 {
+		frame := fs.stack[len(fs.stack)-1]
+		if frame.index >= len(frame.links) {
+			fs.stack = fs.stack[:len(fs.stack)-1]
+			continue
+		}
+		link := frame.links[frame.index]
+		offset, size := frame.offset, frame.sizes[frame.index]
+		frame.index++
+		frame.offset += size
+		if offset+size <= fs.from || offset >= fs.to {
+			continue
+		}
+		return &fileLink{cid: link.Hash.Link().(cidlink.Link).Cid, offset: offset}
+	}
+	return nil
+}
+
+// write writes the bytes of the block of lnk that are in the range, and queues
+// its children.
+func (fs *FileStream) write(lnk fileLink) error {
+	node, ufsData, err := fs.e.loadData(cidlink.Link{Cid: lnk.cid})
+	if err != nil {
+		return err
+	}
+
+	var byts []byte
+	pbNode, isPb := node.(dagpb.PBNode)
+	if !isPb {
+		if byts, err = node.AsBytes(); err != nil {
+			return err
+		}
+	} else {
+		if typ := ufsData.FieldDataType().Int(); typ != data.Data_File && typ != data.Data_Raw {
+			return fmt.Errorf("%w: %s is a %s", ErrNotFile, lnk.cid, typeName(typ))
+		}
+		if ufsData.FieldData().Exists() {
+			byts = ufsData.FieldData().Must().Bytes()
+		}
+	}
+
+	if !fs.rooted {
+		fs.rooted = true
+		size := uint64(len(byts))
+		if isPb {
+			if ufsData.FieldFileSize().Exists() {
+				size = uint64(ufsData.FieldFileSize().Must().Int())
+			} else {
+				itr := ufsData.FieldBlockSizes().Iterator()
+				for !itr.Done() {
+					_, blockSize := itr.Next()
+					size += uint64(blockSize.Int())
+				}
+			}
+		}
+		fs.from, fs.to = resolveRange(fs.byteRange, size)
+	}
+
+	// write the part of the block's own bytes within the range
+	start, end := lnk.offset, lnk.offset+uint64(len(byts))
+	if start < fs.from {
+		start = fs.from
+	}
+	if end > fs.to {
+		end = fs.to
+	}
+	if start < end {
+		n, err := fs.w.Write(byts[start-lnk.offset : end-lnk.offset])
+		fs.written += uint64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isPb || pbNode.FieldLinks().Length() == 0 {
+		return nil
+	}
+	frame := &fileFrame{offset: lnk.offset + uint64(len(byts))}
+	itr := pbNode.FieldLinks().ListIterator()
+	for !itr.Done() {
+		_, link, err := itr.Next()
+		if err != nil {
+			return err
+		}
+		frame.links = append(frame.links, link.(dagpb.PBLink))
+	}
+	sizes := ufsData.FieldBlockSizes().Iterator()
+	for !sizes.Done() {
+		_, size := sizes.Next()
+		frame.sizes = append(frame.sizes, uint64(size.Int()))
+	}
+	if len(frame.sizes) != len(frame.links) {
+		return fmt.Errorf("%s has %d links but %d block sizes", lnk.cid, len(frame.links), len(frame.sizes))
+	}
+	fs.stack = append(fs.stack, frame)
+	return nil
+}
+
+// resolveRange returns the offsets of the bytes of byteRange in a file of size
+// bytes, with to exclusive, counting negative offsets from the end of the file.
+func resolveRange(byteRange *trustlessutils.ByteRange, size uint64) (from uint64, to uint64) {
+	if byteRange == nil {
+		return 0, size
+	}
+	start, end := byteRange.From, int64(size)
+	if start < 0 {
+		start += int64(size)
+	}
+	if byteRange.To != nil {
+		last := *byteRange.To
+		if last < 0 {
+			last += int64(size)
+		}
+		if last+1 < end {
+			end = last + 1
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < start {
+		end = start
+	}
+	return uint64(start), uint64(end)
+}
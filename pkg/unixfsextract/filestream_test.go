@@ -0,0 +1,128 @@
+package unixfsextract_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/internal/testutil"
+	"github.com/filecoin-project/lassie/pkg/unixfsextract"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStream(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	rnd := rand.New(rand.NewSource(0))
+
+	file := unixfs.GenerateFile(t, &lsys, rnd, 1<<20)
+	dir := unixfs.GenerateDirectory(t, &lsys, rnd, 1<<20, false)
+	// a file of repeated chunks, whose leaves appear more than once in its DAG
+	zeros := make([]byte, 100<<10)
+	zerosLnk, _, err := builder.BuildUnixFSFile(bytes.NewReader(zeros), "size-1024", &lsys)
+	require.NoError(t, err)
+	zerosRoot := zerosLnk.(cidlink.Link).Cid
+	size := int64(len(file.Content))
+
+	ptr := func(i int64) *int64 { return &i }
+	testCases := []struct {
+		name      string
+		root      cid.Cid
+		byteRange *trustlessutils.ByteRange
+		expected  []byte
+		expectErr error
+	}{
+		{
+			name:     "whole file",
+			root:     file.Root,
+			expected: file.Content,
+		},
+		{
+			name:      "open range",
+			root:      file.Root,
+			byteRange: &trustlessutils.ByteRange{From: 1000},
+			expected:  file.Content[1000:],
+		},
+		{
+			name:      "range",
+			root:      file.Root,
+			byteRange: &trustlessutils.ByteRange{From: 1000, To: ptr(300000)},
+			expected:  file.Content[1000:300001],
+		},
+		{
+			name:      "range from the end",
+			root:      file.Root,
+			byteRange: &trustlessutils.ByteRange{From: -1000},
+			expected:  file.Content[size-1000:],
+		},
+		{
+			name:      "range to the end",
+			root:      file.Root,
+			byteRange: &trustlessutils.ByteRange{From: 100, To: ptr(-100)},
+			expected:  file.Content[100 : size-99],
+		},
+		{
+			name:      "range past the end",
+			root:      file.Root,
+			byteRange: &trustlessutils.ByteRange{From: size + 1},
+		},
+		{
+			name:     "repeated blocks",
+			root:     zerosRoot,
+			expected: zeros,
+		},
+		{
+			name:      "directory",
+			root:      dir.Root,
+			expectErr: unixfsextract.ErrNotFile,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			// add the blocks to the store one at a time, in the order of a
+			// retrieval, and check that the file is written as they arrive
+			arrived := &memstore.Store{}
+			var buf bytes.Buffer
+			fs := unixfsextract.NewFileStream(ctx, arrived, testCase.root, testCase.byteRange, &buf)
+			var err error
+			for _, blk := range testutil.ToBlocks(t, lsys, testCase.root, selectorparse.CommonSelector_ExploreAllRecursively) {
+				require.NoError(t, arrived.Put(ctx, cidlink.Link{Cid: blk.Cid()}.Binary(), blk.RawData()))
+				if err = fs.Advance(); err != nil {
+					break
+				}
+				require.Equal(t, uint64(buf.Len()), fs.Written())
+				require.True(t, bytes.HasPrefix(testCase.expected, buf.Bytes()))
+			}
+			if testCase.expectErr != nil {
+				require.ErrorIs(t, err, testCase.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, fs.Done())
+			require.Equal(t, len(testCase.expected), buf.Len())
+			require.True(t, bytes.Equal(testCase.expected, buf.Bytes()))
+		})
+	}
+
+	t.Run("waits for missing blocks", func(t *testing.T) {
+		var buf bytes.Buffer
+		fs := unixfsextract.NewFileStream(ctx, &memstore.Store{}, file.Root, nil, &buf)
+		require.NoError(t, fs.Advance())
+		require.False(t, fs.Done())
+		require.Zero(t, fs.Written())
+	})
+}
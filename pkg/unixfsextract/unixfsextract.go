@@ -0,0 +1,427 @@
+/*
+Package unixfsextract writes the UnixFS files, directories and symlinks of a
+DAG, such as one held in a CAR retrieved by Lassie, out to the local
+filesystem, and lists the entries of its directories. Both plain and HAMT
+sharded directories are supported, and files may be made of raw leaves or of
+UnixFS file nodes. A FileStream writes the bytes of a file as its blocks are
+retrieved.
+*/
+package unixfsextract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// ErrNotFile is returned by WriteFile when the path doesn't lead to a file.
+var ErrNotFile = errors.New("not a UnixFS file")
+
+// ErrNotDirectory is returned by List when the path doesn't lead to a
+// directory.
+var ErrNotDirectory = errors.New("not a UnixFS directory")
+
+var protoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
+
+// Stats counts what was written by Extract.
+type Stats struct {
+	Files       int
+	Directories int
+	Symlinks    int
+	Bytes       uint64
+}
+
+// Extract writes the UnixFS entity at path below root, loading its blocks from
+// lsys, into the directory out, which is created if it doesn't exist. With an
+// empty path, the entries of a root directory are written directly into out;
+// otherwise the entity is written to out under its name, the last segment of
+// path, or the root CID if path is empty. Existing files are overwritten.
+//
+// An entry whose name could escape out, such as "..", or that repeats the name
+// of another entry of its directory fails the extraction, as does a block that
+// can't be loaded from lsys. Symlinks already below out, from an earlier
+// extraction or written by this one, are replaced rather than written through,
+// so that nothing is written outside out.
+func Extract(ctx context.Context, lsys linking.LinkSystem, root cid.Cid, path datamodel.Path, out string) (Stats, error) {
+	e := &extractor{ctx: ctx, lsys: lsys}
+	lnk, err := e.resolve(root, path)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	dest := out
+	if path.Len() > 0 {
+		dest = filepath.Join(out, path.Last().String())
+	} else if typ, _, err := e.load(lnk); err != nil {
+		return Stats{}, err
+	} else if typ != data.Data_Directory && typ != data.Data_HAMTShard {
+		dest = filepath.Join(out, root.String())
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return Stats{}, err
+	}
+	// out itself may be a symlink, it's what's below it that mustn't be
+	if dest == out {
+		if dest, err = filepath.EvalSymlinks(out); err != nil {
+			return Stats{}, err
+		}
+	}
+	err = e.extract(lnk, dest, "/"+path.String())
+	return e.stats, err
+}
+
+// WriteFile writes the bytes of the UnixFS file at path below root, loading its
+// blocks from lsys, to w, returning the number of bytes written.
+func WriteFile(ctx context.Context, lsys linking.LinkSystem, root cid.Cid, path datamodel.Path, w io.Writer) (uint64, error) {
+	e := &extractor{ctx: ctx, lsys: lsys}
+	lnk, err := e.resolve(root, path)
+	if err != nil {
+		return 0, err
+	}
+	typ, node, err := e.load(lnk)
+	if err != nil {
+		return 0, err
+	}
+	if typ != data.Data_File && typ != data.Data_Raw {
+		return 0, fmt.Errorf("%w: /%s is a %s", ErrNotFile, path, typeName(typ))
+	}
+	n, err := e.copyFile(node, w)
+	return uint64(n), err
+}
+
+// Entry is an entry of a UnixFS directory, as listed by List.
+type Entry struct {
+	Name string
+	Cid  cid.Cid
+	// Size is the total size of the blocks of the entry's DAG, as recorded in
+	// the directory's link to it.
+	Size uint64
+}
+
+// List returns the entries of the UnixFS directory at path below root,
+// loading its blocks from lsys. Only the blocks of the directory itself are
+// needed, including each of the shards of a HAMT sharded directory, not those
+// of its entries. The entries of a sharded directory are sorted by name, those
+// of a plain directory are in the order of the directory, which is also by
+// name when it's created by the usual tools.
+func List(ctx context.Context, lsys linking.LinkSystem, root cid.Cid, path datamodel.Path) ([]Entry, error) {
+	e := &extractor{ctx: ctx, lsys: lsys}
+	lnk, err := e.resolve(root, path)
+	if err != nil {
+		return nil, err
+	}
+	node, ufsData, err := e.loadData(lnk)
+	if err != nil {
+		return nil, err
+	}
+	typ := data.Data_Raw
+	if ufsData != nil {
+		typ = ufsData.FieldDataType().Int()
+	}
+	pbNode, _ := node.(dagpb.PBNode)
+	switch typ {
+	case data.Data_Directory:
+		entries := make([]Entry, 0, pbNode.FieldLinks().Length())
+		it := pbNode.FieldLinks().Iterator()
+		for !it.Done() {
+			_, link := it.Next()
+			entries = append(entries, newEntry(link, ""))
+		}
+		return entries, nil
+	case data.Data_HAMTShard:
+		var entries []Entry
+		if err := e.listShard(pbNode, ufsData, &entries); err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("%w: /%s is a %s", ErrNotDirectory, path, typeName(typ))
+	}
+}
+
+// listShard appends the entries of a HAMT shard and its sub-shards to entries.
+// The name of each link of a shard is prefixed with its index in the shard, in
+// hex, and a link with nothing after the prefix is a sub-shard.
+func (e *extractor) listShard(pbNode dagpb.PBNode, ufsData data.UnixFSData, entries *[]Entry) error {
+	if !ufsData.FieldFanout().Exists() {
+		return errors.New("HAMT shard has no fanout")
+	}
+	prefixLen := len(fmt.Sprintf("%X", ufsData.FieldFanout().Must().Int()-1))
+	it := pbNode.FieldLinks().Iterator()
+	for !it.Done() {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		_, link := it.Next()
+		name := link.FieldName().Must().String()
+		if len(name) > prefixLen {
+			*entries = append(*entries, newEntry(link, name[:prefixLen]))
+			continue
+		}
+		subNode, subData, err := e.loadData(link.FieldHash().Link())
+		if err != nil {
+			return err
+		}
+		if subData == nil || subData.FieldDataType().Int() != data.Data_HAMTShard {
+			return fmt.Errorf("%s is not a HAMT shard", link.FieldHash().Link())
+		}
+		if err := e.listShard(subNode.(dagpb.PBNode), subData, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newEntry(link dagpb.PBLink, prefix string) Entry {
+	var entry Entry
+	if link.FieldName().Exists() {
+		entry.Name = strings.TrimPrefix(link.FieldName().Must().String(), prefix)
+	}
+	entry.Cid = link.FieldHash().Link().(cidlink.Link).Cid
+	if link.FieldTsize().Exists() {
+		entry.Size = uint64(link.FieldTsize().Must().Int())
+	}
+	return entry
+}
+
+type extractor struct {
+	ctx   context.Context
+	lsys  linking.LinkSystem
+	stats Stats
+}
+
+// resolve follows path from root, returning the link to the entity at its end.
+func (e *extractor) resolve(root cid.Cid, path datamodel.Path) (datamodel.Link, error) {
+	var lnk datamodel.Link = cidlink.Link{Cid: root}
+	for i, segment := range path.Segments() {
+		typ, node, err := e.load(lnk)
+		if err != nil {
+			return nil, err
+		}
+		if typ != data.Data_Directory && typ != data.Data_HAMTShard {
+			return nil, fmt.Errorf("failed to resolve /%s: /%s is a %s", path.Truncate(i+1), path.Truncate(i), typeName(typ))
+		}
+		child, err := node.LookupBySegment(segment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve /%s: %w", path.Truncate(i+1), err)
+		}
+		if lnk, err = child.AsLink(); err != nil {
+			return nil, fmt.Errorf("failed to resolve /%s: %w", path.Truncate(i+1), err)
+		}
+	}
+	return lnk, nil
+}
+
+// load loads and reifies the UnixFS node of lnk, returning its UnixFS type. A
+// raw block is a file.
+func (e *extractor) load(lnk datamodel.Link) (int64, datamodel.Node, error) {
+	node, ufsData, err := e.loadData(lnk)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	typ := data.Data_Raw
+	if ufsData != nil {
+		typ = ufsData.FieldDataType().Int()
+		if typ == data.Data_Symlink {
+			// the target of a symlink is its data, there's nothing to reify
+			return typ, basicnode.NewBytes(ufsData.FieldData().Must().Bytes()), nil
+		}
+	}
+
+	node, err = unixfsnode.Reify(linking.LinkContext{Ctx: e.ctx}, node, &e.lsys)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, node, nil
+}
+
+// loadData loads the block of lnk, returning its node, a dagpb.PBNode or the
+// bytes of a raw block, and its UnixFS data, which is nil for a raw block.
+func (e *extractor) loadData(lnk datamodel.Link) (datamodel.Node, data.UnixFSData, error) {
+	lnkCtx := linking.LinkContext{Ctx: e.ctx}
+	proto, err := protoChooser(lnk, lnkCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	node, err := e.lsys.Load(lnkCtx, lnk, proto)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %s: %w", lnk, err)
+	}
+	pbNode, ok := node.(dagpb.PBNode)
+	if !ok {
+		if node.Kind() != datamodel.Kind_Bytes {
+			return nil, nil, fmt.Errorf("%s is not UnixFS", lnk)
+		}
+		return node, nil, nil
+	}
+	if !pbNode.FieldData().Exists() {
+		return nil, nil, fmt.Errorf("%s is not UnixFS", lnk)
+	}
+	ufsData, err := data.DecodeUnixFSData(pbNode.FieldData().Must().Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is not UnixFS: %w", lnk, err)
+	}
+	return pbNode, ufsData, nil
+}
+
+// extract writes the entity of lnk to dest, rel is its path for errors.
+func (e *extractor) extract(lnk datamodel.Link, dest string, rel string) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	typ, node, err := e.load(lnk)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+
+	switch typ {
+	case data.Data_Directory, data.Data_HAMTShard:
+		if err := mkdir(dest); err != nil {
+			return err
+		}
+		e.stats.Directories++
+		names := make(map[string]struct{})
+		it := node.MapIterator()
+		for !it.Done() {
+			k, v, err := it.Next()
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			name, err := k.AsString()
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			if !validName(name) {
+				return fmt.Errorf("%s: invalid entry name %q", rel, name)
+			}
+			if _, ok := names[name]; ok {
+				return fmt.Errorf("%s: duplicate entry name %q", rel, name)
+			}
+			names[name] = struct{}{}
+			childLnk, err := v.AsLink()
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			if err := e.extract(childLnk, filepath.Join(dest, name), strings.TrimSuffix(rel, "/")+"/"+name); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case data.Data_File, data.Data_Raw:
+		if err := removeNonDir(dest); err != nil {
+			return err
+		}
+		// exclusively, so that a symlink created since isn't followed
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		n, err := e.copyFile(node, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		e.stats.Files++
+		e.stats.Bytes += uint64(n)
+		return nil
+
+	case data.Data_Symlink:
+		target, err := node.AsBytes()
+		if err != nil {
+			return err
+		}
+		if err := removeNonDir(dest); err != nil {
+			return err
+		}
+		if err := os.Symlink(string(target), dest); err != nil {
+			return err
+		}
+		e.stats.Symlinks++
+		return nil
+
+	default:
+		return fmt.Errorf("%s: can't extract a UnixFS %s", rel, typeName(typ))
+	}
+}
+
+// mkdir creates the directory dest, unless it already exists as a directory.
+// A symlink at dest is replaced, rather than followed.
+func mkdir(dest string) error {
+	fi, err := os.Lstat(dest)
+	if err == nil && fi.IsDir() {
+		return nil
+	} else if err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Mkdir(dest, 0755)
+}
+
+// removeNonDir removes whatever is at dest, such as a file or a symlink, for it
+// to be replaced, but not a directory, which is left to fail its replacement.
+func removeNonDir(dest string) error {
+	fi, err := os.Lstat(dest)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fi.IsDir()) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return os.Remove(dest)
+}
+
+// copyFile copies the bytes of a reified UnixFS file to w.
+func (e *extractor) copyFile(node datamodel.Node, w io.Writer) (int64, error) {
+	var rdr io.Reader
+	if lbn, ok := node.(datamodel.LargeBytesNode); ok {
+		rs, err := lbn.AsLargeBytes()
+		if err != nil {
+			return 0, err
+		}
+		rdr = rs
+	} else {
+		byts, err := node.AsBytes()
+		if err != nil {
+			return 0, err
+		}
+		rdr = bytes.NewReader(byts)
+	}
+	return io.Copy(w, rdr)
+}
+
+// validName reports whether a directory entry name can be written as a single
+// element of a local path.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsRune(name, '/') && !strings.ContainsRune(name, filepath.Separator)
+}
+
+func typeName(typ int64) string {
+	if name, ok := data.DataTypeNames[typ]; ok {
+		return strings.ToLower(name)
+	}
+	return fmt.Sprintf("type %d", typ)
+}
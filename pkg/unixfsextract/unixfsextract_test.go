@@ -0,0 +1,237 @@
+package unixfsextract_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/filecoin-project/lassie/pkg/unixfsextract"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lsys.TrustedStorage = true
+	rnd := rand.New(rand.NewSource(0))
+
+	dir := unixfs.GenerateDirectory(t, &lsys, rnd, 1<<20, false)
+	shardedDir := unixfs.GenerateDirectory(t, &lsys, rnd, 1<<20, true)
+	file := unixfs.GenerateFile(t, &lsys, rnd, 1<<20)
+
+	// a directory holding a symlink, and one with an entry that would escape
+	// the output directory
+	symlink, _, err := builder.BuildUnixFSSymlink("target.txt", &lsys)
+	require.NoError(t, err)
+	buildDir := func(name string, lnk datamodel.Link) cid.Cid {
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, 0, lnk)
+		require.NoError(t, err)
+		dirLnk, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{entry}, &lsys)
+		require.NoError(t, err)
+		return dirLnk.(cidlink.Link).Cid
+	}
+	symlinkDir := buildDir("link", symlink)
+	escapingDir := buildDir("..", cidlink.Link{Cid: file.Root})
+
+	// checkDir checks that each file of entry was written below out
+	var checkDir func(t *testing.T, out string, entry unixfs.DirEntry)
+	checkDir = func(t *testing.T, out string, entry unixfs.DirEntry) {
+		for _, child := range entry.Children {
+			if child.Children != nil {
+				require.DirExists(t, filepath.Join(out, child.Path))
+				checkDir(t, out, child)
+				continue
+			}
+			got, err := os.ReadFile(filepath.Join(out, child.Path))
+			require.NoError(t, err)
+			require.Equal(t, child.Content, got, child.Path)
+		}
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		out := t.TempDir()
+		stats, err := unixfsextract.Extract(ctx, lsys, dir.Root, datamodel.Path{}, out)
+		require.NoError(t, err)
+		checkDir(t, out, dir)
+		require.Positive(t, stats.Files)
+		require.Positive(t, stats.Directories)
+	})
+
+	t.Run("sharded directory", func(t *testing.T) {
+		out := t.TempDir()
+		_, err := unixfsextract.Extract(ctx, lsys, shardedDir.Root, datamodel.Path{}, out)
+		require.NoError(t, err)
+		checkDir(t, out, shardedDir)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		stats, err := unixfsextract.Extract(ctx, lsys, file.Root, datamodel.Path{}, out)
+		require.NoError(t, err)
+		require.Equal(t, unixfsextract.Stats{Files: 1, Bytes: 1 << 20}, stats)
+		got, err := os.ReadFile(filepath.Join(out, file.Root.String()))
+		require.NoError(t, err)
+		require.Equal(t, file.Content, got)
+	})
+
+	t.Run("path", func(t *testing.T) {
+		child := dir
+		for child.Children != nil {
+			child = child.Children[len(child.Children)-1]
+		}
+		out := t.TempDir()
+		_, err := unixfsextract.Extract(ctx, lsys, dir.Root, datamodel.ParsePath(child.Path), out)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(out, filepath.Base(child.Path)))
+		require.NoError(t, err)
+		require.Equal(t, child.Content, got)
+
+		var buf bytes.Buffer
+		n, err := unixfsextract.WriteFile(ctx, lsys, dir.Root, datamodel.ParsePath(child.Path), &buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(child.Content)), n)
+		require.Equal(t, child.Content, buf.Bytes())
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		_, err := unixfsextract.Extract(ctx, lsys, dir.Root, datamodel.ParsePath("nope"), t.TempDir())
+		require.ErrorContains(t, err, "failed to resolve /nope")
+	})
+
+	t.Run("write a directory", func(t *testing.T) {
+		_, err := unixfsextract.WriteFile(ctx, lsys, dir.Root, datamodel.Path{}, &bytes.Buffer{})
+		require.ErrorIs(t, err, unixfsextract.ErrNotFile)
+	})
+
+	t.Run("symlink", func(t *testing.T) {
+		out := t.TempDir()
+		stats, err := unixfsextract.Extract(ctx, lsys, symlinkDir, datamodel.Path{}, out)
+		require.NoError(t, err)
+		require.Equal(t, 1, stats.Symlinks)
+		target, err := os.Readlink(filepath.Join(out, "link"))
+		require.NoError(t, err)
+		require.Equal(t, "target.txt", target)
+	})
+
+	t.Run("escaping name", func(t *testing.T) {
+		_, err := unixfsextract.Extract(ctx, lsys, escapingDir, datamodel.Path{}, t.TempDir())
+		require.ErrorContains(t, err, `invalid entry name ".."`)
+	})
+
+	t.Run("duplicate name escaping through a symlink", func(t *testing.T) {
+		// a symlink out of the output directory, then a directory of the same
+		// name whose file would be written through it
+		outside := t.TempDir()
+		escapeLink, _, err := builder.BuildUnixFSSymlink(outside, &lsys)
+		require.NoError(t, err)
+		linkEntry, err := builder.BuildUnixFSDirectoryEntry("sub", 0, escapeLink)
+		require.NoError(t, err)
+		dirEntry, err := builder.BuildUnixFSDirectoryEntry("sub", 0, cidlink.Link{Cid: buildDir("escaped", cidlink.Link{Cid: file.Root})})
+		require.NoError(t, err)
+		malicious, _, err := builder.BuildUnixFSDirectory([]dagpb.PBLink{linkEntry, dirEntry}, &lsys)
+		require.NoError(t, err)
+
+		_, err = unixfsextract.Extract(ctx, lsys, malicious.(cidlink.Link).Cid, datamodel.Path{}, t.TempDir())
+		require.ErrorContains(t, err, `duplicate entry name "sub"`)
+		require.NoFileExists(t, filepath.Join(outside, "escaped"))
+	})
+
+	t.Run("existing symlinks", func(t *testing.T) {
+		// symlinks left in the output directory, as by an earlier extraction,
+		// to where a directory and a file are then written
+		outside := t.TempDir()
+		out := t.TempDir()
+		subDir := buildDir("escaped", cidlink.Link{Cid: file.Root})
+		entries := make([]dagpb.PBLink, 0, 2)
+		for name, lnk := range map[string]cid.Cid{"sub": subDir, "escaped": file.Root} {
+			entry, err := builder.BuildUnixFSDirectoryEntry(name, 0, cidlink.Link{Cid: lnk})
+			require.NoError(t, err)
+			entries = append(entries, entry)
+			require.NoError(t, os.Symlink(outside, filepath.Join(out, name)))
+		}
+		root, _, err := builder.BuildUnixFSDirectory(entries, &lsys)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(outside, "escaped"), []byte("untouched"), 0644))
+
+		stats, err := unixfsextract.Extract(ctx, lsys, root.(cidlink.Link).Cid, datamodel.Path{}, out)
+		require.NoError(t, err)
+		require.Equal(t, 2, stats.Files)
+		got, err := os.ReadFile(filepath.Join(outside, "escaped"))
+		require.NoError(t, err)
+		require.Equal(t, "untouched", string(got))
+		for _, path := range []string{"escaped", "sub/escaped"} {
+			fi, err := os.Lstat(filepath.Join(out, path))
+			require.NoError(t, err)
+			require.True(t, fi.Mode().IsRegular(), path)
+			got, err := os.ReadFile(filepath.Join(out, path))
+			require.NoError(t, err)
+			require.Equal(t, file.Content, got)
+		}
+		fi, err := os.Lstat(filepath.Join(out, "sub"))
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+
+		// into an output directory that is itself a symlink
+		linkedOut := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, os.Symlink(out, linkedOut))
+		_, err = unixfsextract.Extract(ctx, lsys, root.(cidlink.Link).Cid, datamodel.Path{}, linkedOut)
+		require.NoError(t, err)
+		fi, err = os.Lstat(linkedOut)
+		require.NoError(t, err)
+		require.NotZero(t, fi.Mode()&os.ModeSymlink)
+	})
+
+	// checkList checks that entries are those of the children of entry
+	checkList := func(t *testing.T, entry unixfs.DirEntry, entries []unixfsextract.Entry) {
+		expected := make(map[string]cid.Cid)
+		for _, child := range entry.Children {
+			expected[filepath.Base(child.Path)] = child.Root
+		}
+		got := make(map[string]cid.Cid)
+		for _, e := range entries {
+			got[e.Name] = e.Cid
+			require.Positive(t, e.Size, e.Name)
+		}
+		require.Equal(t, expected, got)
+		require.True(t, sort.SliceIsSorted(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name }))
+	}
+
+	t.Run("list", func(t *testing.T) {
+		entries, err := unixfsextract.List(ctx, lsys, dir.Root, datamodel.Path{})
+		require.NoError(t, err)
+		checkList(t, dir, entries)
+	})
+
+	t.Run("list sharded", func(t *testing.T) {
+		entries, err := unixfsextract.List(ctx, lsys, shardedDir.Root, datamodel.Path{})
+		require.NoError(t, err)
+		require.Greater(t, len(entries), 1)
+		checkList(t, shardedDir, entries)
+	})
+
+	t.Run("list a file", func(t *testing.T) {
+		_, err := unixfsextract.List(ctx, lsys, file.Root, datamodel.Path{})
+		require.ErrorIs(t, err, unixfsextract.ErrNotDirectory)
+	})
+
+	t.Run("missing block", func(t *testing.T) {
+		emptyLsys := cidlink.DefaultLinkSystem()
+		emptyLsys.SetReadStorage(&memstore.Store{})
+		_, err := unixfsextract.Extract(ctx, emptyLsys, dir.Root, datamodel.Path{}, t.TempDir())
+		require.ErrorContains(t, err, "failed to load "+dir.Root.String())
+	})
+}